@@ -0,0 +1,407 @@
+package smt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrNotArchived is returned by Versions and DeleteVersion for a tree created
+// without WithArchive.
+var ErrNotArchived = errors.New("tree is not in archive mode")
+
+// ErrVersionInUse is returned by DeleteVersion for the current root, or a
+// root retained with WithRootRetention.
+var ErrVersionInUse = errors.New("version is the current or a retained root")
+
+// Keys of the archive store. They are not the size of a hash, so the counts
+// and values stored by hash cannot collide with them.
+var (
+	archiveNextKey   = []byte("smt:archive:next")
+	archiveFirstKey  = []byte("smt:archive:first")
+	archiveSeqPrefix = []byte("smt:archive:seq:")
+	// archiveRootPrefix, archiveRefsPrefix and archiveValuePrefix are
+	// followed by a root, the hash of a node and the hash of a leaf.
+	archiveRootPrefix  = []byte("smt:archive:root:")
+	archiveRefsPrefix  = []byte("smt:archive:refs:")
+	archiveValuePrefix = []byte("smt:archive:value:")
+)
+
+// archive is the state of a tree in archive mode.
+type archive struct {
+	store MapStore
+	// orphans are the nodes orphaned by the change of the root in progress,
+	// deleted at its end unless counted.
+	orphans [][]byte
+}
+
+// WithArchive keeps every root the tree changes to as a version, in store,
+// whose nodes are kept until the version is deleted with DeleteVersion, so
+// that the past roots of a long-lived tree can be read and proven, with
+// GetForRoot and ProveForRoot, without copying its stores at each of them.
+//
+// Nodes are reference counted across versions in store: a node counts once
+// for each version it is the root of and each counted node it is a child of,
+// and is deleted once its count drops to zero. A change of the root counts
+// the nodes it writes, reached from the new root, and deletes the nodes it
+// orphans that are not counted, so each change costs a Get and a Set of the
+// archive store per node written. The values of the leaves counted are kept
+// in archive too, by leaf hash, as the tree only stores the current value of
+// each key. The root a tree is imported at is counted, walking the whole
+// tree, on its first change, unless it already is a version; the root of a
+// new tree is only a version once the tree changes back to it.
+//
+// Roots retained with WithRootRetention are also versions, which they keep
+// until they expire and can be deleted.
+func WithArchive(store MapStore) Option {
+	if store == nil {
+		panic("smt: nil archive store")
+	}
+	return func(smt *SparseMerkleTree) {
+		smt.archive = &archive{store: store}
+	}
+}
+
+// Versions returns the roots kept by the tree in archive mode, in the order
+// the tree first changed to them, or ErrNotArchived.
+func (smt *SparseMerkleTree) Versions() ([][]byte, error) {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	if smt.archive == nil {
+		return nil, ErrNotArchived
+	}
+	return smt.versionRoots()
+}
+
+// DeleteVersion deletes the version of root, deleting the nodes, and values,
+// that no other version is made of. It returns ErrRootPruned if root is not a
+// version, ErrVersionInUse if it is the current or a retained root, or
+// ErrNotArchived. Snapshots keep reading the nodes deleted, as they do those
+// deleted by updates.
+func (smt *SparseMerkleTree) DeleteVersion(root []byte) error {
+	defer smt.writeLock()()
+	if smt.archive == nil {
+		return ErrNotArchived
+	}
+	seq, ok, err := smt.versionSeq(root)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrRootPruned
+	}
+	if _, err := smt.retainedIndex(root); err == nil {
+		return ErrVersionInUse
+	}
+	if err := smt.releaseNode(root); err != nil {
+		return err
+	}
+	store := smt.archive.store
+	if err := store.Delete(archiveSeqKey(seq)); err != nil {
+		return err
+	}
+	if err := store.Delete(append(append([]byte{}, archiveRootPrefix...), root...)); err != nil {
+		return err
+	}
+	smt.debugf("deleted version %x", root)
+	return smt.advanceFirstVersion()
+}
+
+// isVersion returns true if the tree is in archive mode and root is one of
+// its versions.
+func (smt *SparseMerkleTree) isVersion(root []byte) (bool, error) {
+	if smt.archive == nil {
+		return false, nil
+	}
+	_, ok, err := smt.versionSeq(root)
+	return ok, err
+}
+
+// versionRoots returns the versions, without locking.
+func (smt *SparseMerkleTree) versionRoots() ([][]byte, error) {
+	store := smt.archive.store
+	first, err := readArchiveUint(store, archiveFirstKey)
+	if err != nil {
+		return nil, err
+	}
+	next, err := readArchiveUint(store, archiveNextKey)
+	if err != nil {
+		return nil, err
+	}
+	var roots [][]byte
+	for seq := first; seq < next; seq++ {
+		root, err := store.Get(archiveSeqKey(seq))
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, root)
+	}
+	return roots, nil
+}
+
+// versionSeq returns the sequence number of the version of root, and false
+// if there is none.
+func (smt *SparseMerkleTree) versionSeq(root []byte) (uint64, bool, error) {
+	encoded, err := smt.archive.store.Get(append(append([]byte{}, archiveRootPrefix...), root...))
+	if errors.Is(err, ErrKeyNotFound) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if len(encoded) != 8 {
+		return 0, false, errors.New("archive store holds a malformed version")
+	}
+	return binary.BigEndian.Uint64(encoded), true, nil
+}
+
+// addVersion makes root a version, counting its nodes, unless it already is
+// one.
+func (smt *SparseMerkleTree) addVersion(root []byte) error {
+	if _, ok, err := smt.versionSeq(root); err != nil || ok {
+		return err
+	}
+	if err := smt.retainNode(root); err != nil {
+		return err
+	}
+	store := smt.archive.store
+	seq, err := readArchiveUint(store, archiveNextKey)
+	if err != nil {
+		return err
+	}
+	if err := store.Set(archiveSeqKey(seq), append([]byte{}, root...)); err != nil {
+		return err
+	}
+	if err := store.Set(append(append([]byte{}, archiveRootPrefix...), root...), encodeArchiveUint(seq)); err != nil {
+		return err
+	}
+	return store.Set(archiveNextKey, encodeArchiveUint(seq+1))
+}
+
+// advanceFirstVersion moves the sequence number Versions starts from past
+// the versions deleted, so that deleting the oldest versions, as an archive
+// expiring its history does, keeps Versions from reading their gap.
+func (smt *SparseMerkleTree) advanceFirstVersion() error {
+	store := smt.archive.store
+	first, err := readArchiveUint(store, archiveFirstKey)
+	if err != nil {
+		return err
+	}
+	next, err := readArchiveUint(store, archiveNextKey)
+	if err != nil {
+		return err
+	}
+	seq := first
+	for ; seq < next; seq++ {
+		_, err := store.Get(archiveSeqKey(seq))
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrKeyNotFound) {
+			return err
+		}
+	}
+	if seq == first {
+		return nil
+	}
+	return store.Set(archiveFirstKey, encodeArchiveUint(seq))
+}
+
+// beginArchive begins a change of the root from oldRoot in archive mode,
+// dropping the orphans of a change that failed, and making oldRoot a version,
+// as it already is unless the tree was imported at it, before the change
+// replaces the values of its leaves.
+func (smt *SparseMerkleTree) beginArchive(oldRoot []byte) error {
+	if smt.archive == nil {
+		return nil
+	}
+	smt.archive.orphans = nil
+	if bytes.Equal(oldRoot, smt.th.placeholder()) {
+		return nil
+	}
+	return smt.addVersion(oldRoot)
+}
+
+// archiveRoot ends a change of the root to newRoot in archive mode, making it
+// a version and deleting the orphaned nodes that are not counted, such as
+// those written and orphaned again by the change.
+func (smt *SparseMerkleTree) archiveRoot(newRoot []byte) error {
+	a := smt.archive
+	if a == nil {
+		return nil
+	}
+	orphans := a.orphans
+	a.orphans = nil
+	if err := smt.addVersion(newRoot); err != nil {
+		return err
+	}
+	deleted := 0
+	for _, hash := range orphans {
+		refs, err := smt.nodeRefs(hash)
+		if err != nil {
+			return err
+		}
+		if refs != 0 {
+			continue
+		}
+		if err := smt.deleteNode(hash); err != nil {
+			return err
+		}
+		deleted++
+	}
+	if deleted != 0 && smt.logger != nil {
+		smt.debugf("deleted %d orphaned nodes of no version", deleted)
+	}
+	return nil
+}
+
+// retainNode counts a reference to the node with the given hash, counting
+// the references of a node counted for the first time to its children, and
+// keeping the value of a leaf.
+func (smt *SparseMerkleTree) retainNode(hash []byte) error {
+	if bytes.Equal(hash, smt.th.placeholder()) {
+		return nil
+	}
+	refs, err := smt.nodeRefs(hash)
+	if err != nil {
+		return err
+	}
+	if err := smt.setNodeRefs(hash, refs+1); err != nil || refs != 0 {
+		return err
+	}
+	data, err := smt.getNode(hash)
+	if err != nil {
+		return err
+	}
+	if !smt.th.isLeaf(data) {
+		leftNode, rightNode := smt.th.parseNode(data)
+		if err := smt.retainNode(leftNode); err != nil {
+			return err
+		}
+		return smt.retainNode(rightNode)
+	}
+	// The leaf was just reached from the new root, so the value stored at
+	// its path, if any, is its own.
+	path, _ := smt.th.parseLeaf(data)
+	value, err := smt.values.Get(path)
+	if errors.Is(err, ErrKeyNotFound) {
+		// A presence leaf, or one set with UpdateLeafHash, may have no value.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return smt.archive.store.Set(archiveValueKey(hash), append([]byte{}, value...))
+}
+
+// releaseNode drops a reference to the node with the given hash, deleting it,
+// along with the value of a leaf, and dropping its references to its
+// children once it has none.
+func (smt *SparseMerkleTree) releaseNode(hash []byte) error {
+	if bytes.Equal(hash, smt.th.placeholder()) {
+		return nil
+	}
+	refs, err := smt.nodeRefs(hash)
+	if err != nil || refs == 0 {
+		return err
+	}
+	if refs > 1 {
+		return smt.setNodeRefs(hash, refs-1)
+	}
+	data, err := smt.getNode(hash)
+	if err != nil {
+		return err
+	}
+	if err := smt.archive.store.Delete(archiveRefsKey(hash)); err != nil {
+		return err
+	}
+	if smt.th.isLeaf(data) {
+		if err := smt.archive.store.Delete(archiveValueKey(hash)); err != nil && !errors.Is(err, ErrKeyNotFound) {
+			return err
+		}
+	} else {
+		leftNode, rightNode := smt.th.parseNode(data)
+		if err := smt.releaseNode(leftNode); err != nil {
+			return err
+		}
+		if err := smt.releaseNode(rightNode); err != nil {
+			return err
+		}
+	}
+	return smt.deleteNode(hash)
+}
+
+// nodeRefs returns the reference count of the node with the given hash, 0 if
+// it is not counted.
+func (smt *SparseMerkleTree) nodeRefs(hash []byte) (uint64, error) {
+	return readArchiveUint(smt.archive.store, archiveRefsKey(hash))
+}
+
+func (smt *SparseMerkleTree) setNodeRefs(hash []byte, refs uint64) error {
+	return smt.archive.store.Set(archiveRefsKey(hash), encodeArchiveUint(refs))
+}
+
+// versionValue returns the value of key under root, a version, from the
+// value kept for its leaf.
+func (smt *SparseMerkleTree) versionValue(key []byte, root []byte) ([]byte, error) {
+	path := smt.th.path(key)
+	valueHash, err := smt.leafValueHash(path, root)
+	if err != nil || valueHash == nil {
+		return smt.emptyValue(), err
+	}
+	_, pathNodes, _, _, err := smt.sideNodesForRoot(path, root, false)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := smt.archive.store.Get(archiveValueKey(pathNodes[0]))
+	if errors.Is(err, ErrKeyNotFound) {
+		if len(valueHash) == 0 {
+			return smt.emptyValue(), nil
+		}
+		return nil, ErrValueMissing
+	}
+	if err != nil {
+		return nil, err
+	}
+	if smt.decodeValue == nil {
+		return stored, nil
+	}
+	return smt.decodeValue(stored)
+}
+
+func archiveSeqKey(seq uint64) []byte {
+	return append(append([]byte{}, archiveSeqPrefix...), encodeArchiveUint(seq)...)
+}
+
+func archiveRefsKey(hash []byte) []byte {
+	return append(append([]byte{}, archiveRefsPrefix...), hash...)
+}
+
+func archiveValueKey(hash []byte) []byte {
+	return append(append([]byte{}, archiveValuePrefix...), hash...)
+}
+
+func encodeArchiveUint(n uint64) []byte {
+	encoded := make([]byte, 8)
+	binary.BigEndian.PutUint64(encoded, n)
+	return encoded
+}
+
+// readArchiveUint reads the integer stored under key, or 0 if there is none.
+func readArchiveUint(store MapStore, key []byte) (uint64, error) {
+	encoded, err := store.Get(key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(encoded) != 8 {
+		return 0, fmt.Errorf("archive store holds a malformed integer under %q", key)
+	}
+	return binary.BigEndian.Uint64(encoded), nil
+}
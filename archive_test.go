@@ -0,0 +1,178 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestArchive(t *testing.T) {
+	nodes, values, archive := NewSimpleMap(), NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(nodes, values, sha256.New(), WithArchive(archive))
+	if versions, err := smt.Versions(); err != nil || len(versions) != 0 {
+		t.Fatalf("got versions %v and error %v for a new tree", versions, err)
+	}
+
+	var roots [][]byte
+	for _, kv := range [][2]string{{"foo", "bar"}, {"baz", "qux"}, {"foo", "quux"}, {"bar", "corge"}} {
+		root, err := smt.Update([]byte(kv[0]), []byte(kv[1]))
+		if err != nil {
+			t.Fatalf("returned error when updating: %v", err)
+		}
+		roots = append(roots, root)
+	}
+	root, _ := smt.Delete([]byte("baz"))
+	roots = append(roots, root)
+
+	versions, err := smt.Versions()
+	if err != nil || len(versions) != len(roots) {
+		t.Fatalf("got %d versions and error %v, want %d", len(versions), err, len(roots))
+	}
+	for i := range roots {
+		if !bytes.Equal(versions[i], roots[i]) {
+			t.Errorf("version %d is %x, want %x", i, versions[i], roots[i])
+		}
+	}
+
+	for _, tc := range []struct {
+		root       []byte
+		key, value string
+	}{
+		{roots[0], "foo", "bar"},
+		{roots[0], "baz", ""},
+		{roots[1], "baz", "qux"},
+		{roots[2], "foo", "quux"},
+		{roots[3], "baz", "qux"},
+		{roots[4], "baz", ""},
+	} {
+		value, err := smt.GetForRoot([]byte(tc.key), tc.root)
+		if err != nil || !bytes.Equal(value, []byte(tc.value)) {
+			t.Errorf("got %q and error %v for %s under %x, want %q", value, err, tc.key, tc.root, tc.value)
+		}
+		var want []byte
+		if tc.value != "" {
+			want = []byte(tc.value)
+		}
+		proof, err := smt.ProveForRoot([]byte(tc.key), tc.root)
+		if err != nil || !VerifyProof(proof, tc.root, []byte(tc.key), want, sha256.New()) {
+			t.Errorf("proof of %s under %x did not verify: %v", tc.key, tc.root, err)
+		}
+	}
+
+	// Only the nodes of no other version are deleted.
+	stored := storedNodes(t, nodes)
+	if err := smt.DeleteVersion(roots[1]); err != nil {
+		t.Fatalf("returned error when deleting a version: %v", err)
+	}
+	if after := storedNodes(t, nodes); after >= stored {
+		t.Errorf("deleting a version left %d of %d nodes", after, stored)
+	}
+	if _, err := smt.GetForRoot([]byte("baz"), roots[1]); !errors.Is(err, ErrRootPruned) {
+		t.Errorf("got %v for a deleted version, want ErrRootPruned", err)
+	}
+	if value, err := smt.GetForRoot([]byte("baz"), roots[3]); err != nil || string(value) != "qux" {
+		t.Errorf("got %q and error %v from a version sharing the leaf deleted", value, err)
+	}
+	if err := smt.DeleteVersion(roots[1]); !errors.Is(err, ErrRootPruned) {
+		t.Errorf("got %v deleting a version twice, want ErrRootPruned", err)
+	}
+	if err := smt.DeleteVersion(smt.Root()); !errors.Is(err, ErrVersionInUse) {
+		t.Errorf("got %v deleting the current version, want ErrVersionInUse", err)
+	}
+	if versions, _ := smt.Versions(); len(versions) != len(roots)-1 {
+		t.Errorf("got %d versions, want %d", len(versions), len(roots)-1)
+	}
+
+	if pruned, err := smt.Prune(nil); err != nil || pruned != 0 {
+		t.Errorf("pruned %d nodes, with error %v, of versions", pruned, err)
+	}
+
+	// Deleting every past version leaves the nodes of the current root.
+	for _, root := range roots[:len(roots)-1] {
+		if err := smt.DeleteVersion(root); err != nil && !errors.Is(err, ErrRootPruned) {
+			t.Fatalf("returned error when deleting a version: %v", err)
+		}
+	}
+	if got, want := storedNodes(t, nodes), reachableNodes(t, smt, smt.Root()); got != want {
+		t.Errorf("got %d stored nodes, want the %d of the current root", got, want)
+	}
+	if value, err := smt.Get([]byte("foo")); err != nil || string(value) != "quux" {
+		t.Errorf("got %q and error %v after deleting past versions", value, err)
+	}
+}
+
+func TestArchiveImport(t *testing.T) {
+	nodes, values := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(nodes, values, sha256.New())
+	smt.Update([]byte("foo"), []byte("bar"))
+	imported := smt.Root()
+
+	archive := NewSimpleMap()
+	smt = ImportSparseMerkleTree(nodes, values, sha256.New(), imported, WithArchive(archive))
+	root, _ := smt.Update([]byte("foo"), []byte("baz"))
+	if value, err := smt.GetForRoot([]byte("foo"), imported); err != nil || string(value) != "bar" {
+		t.Errorf("got %q and error %v under the root imported", value, err)
+	}
+
+	// The archive persists with the stores.
+	smt = ImportSparseMerkleTree(nodes, values, sha256.New(), root, WithArchive(archive))
+	versions, err := smt.Versions()
+	if err != nil || len(versions) != 2 || !bytes.Equal(versions[0], imported) || !bytes.Equal(versions[1], root) {
+		t.Fatalf("got versions %x and error %v after reopening", versions, err)
+	}
+	smt.Update([]byte("foo"), []byte("qux"))
+	if value, err := smt.GetForRoot([]byte("foo"), root); err != nil || string(value) != "baz" {
+		t.Errorf("got %q and error %v after reopening", value, err)
+	}
+	if err := smt.DeleteVersion(imported); err != nil {
+		t.Errorf("returned error when deleting the root imported: %v", err)
+	}
+}
+
+func TestArchiveRetention(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithArchive(NewSimpleMap()), WithRootRetention(1))
+	first, _ := smt.Update([]byte("foo"), []byte("bar"))
+	smt.Update([]byte("foo"), []byte("baz"))
+	if err := smt.DeleteVersion(first); !errors.Is(err, ErrVersionInUse) {
+		t.Errorf("got %v deleting a retained version, want ErrVersionInUse", err)
+	}
+	smt.Update([]byte("foo"), []byte("qux"))
+	if err := smt.DeleteVersion(first); err != nil {
+		t.Errorf("returned error when deleting an expired version: %v", err)
+	}
+}
+
+func TestNotArchived(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	if _, err := smt.Versions(); !errors.Is(err, ErrNotArchived) {
+		t.Errorf("got %v, want ErrNotArchived", err)
+	}
+	if err := smt.DeleteVersion(smt.Root()); !errors.Is(err, ErrNotArchived) {
+		t.Errorf("got %v, want ErrNotArchived", err)
+	}
+}
+
+// storedNodes returns the number of nodes in nodes.
+func storedNodes(t *testing.T, nodes MapStore) int {
+	t.Helper()
+	data, err := nodes.Export()
+	if err != nil {
+		t.Fatalf("returned error when exporting nodes: %v", err)
+	}
+	var stored map[string][]byte
+	if err := decodeSnapshot(data, &stored); err != nil {
+		t.Fatalf("returned error when decoding nodes: %v", err)
+	}
+	return len(stored)
+}
+
+// reachableNodes returns the number of nodes under root.
+func reachableNodes(t *testing.T, smt *SparseMerkleTree, root []byte) int {
+	t.Helper()
+	reachable := make(map[string]bool)
+	if err := smt.markReachable(root, reachable); err != nil {
+		t.Fatalf("returned error when walking the tree: %v", err)
+	}
+	return len(reachable)
+}
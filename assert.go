@@ -0,0 +1,85 @@
+package smt
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// AssertSameRoot builds a tree over keyvals both by calling Update for each
+// pair and with BuildFromSorted, using the same hasher as NewMerkleTrie, and
+// returns an ErrRootMismatch describing the first node at which the two trees
+// diverge if their roots differ.
+func AssertSameRoot(keyvals map[string][]byte) error {
+	updated := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha3.New256())
+	keys := make([][]byte, 0, len(keyvals))
+	for key, value := range keyvals {
+		if _, err := updated.Update([]byte(key), value); err != nil {
+			return fmt.Errorf("updating key %s: %w", formatKey([]byte(key)), err)
+		}
+		keys = append(keys, []byte(key))
+	}
+
+	paths := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		paths[string(key)] = updated.th.path(key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(paths[string(keys[i])], paths[string(keys[j])]) < 0
+	})
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		values[i] = keyvals[string(key)]
+	}
+	built, err := BuildFromSorted(NewSimpleMap(), NewSimpleMap(), sha3.New256, NewSliceIterator(keys, values))
+	if err != nil {
+		return fmt.Errorf("building from sorted: %w", err)
+	}
+
+	return firstDivergence("Update", updated, "BuildFromSorted", built)
+}
+
+// firstDivergence returns nil if a and b have the same root, or otherwise an
+// error naming the shallowest differing node on the leftmost divergent path.
+func firstDivergence(nameA string, a *SparseMerkleTree, nameB string, b *SparseMerkleTree) error {
+	hashA, hashB := a.Root(), b.Root()
+	var position strings.Builder
+	position.WriteString("/")
+	for !bytes.Equal(hashA, hashB) {
+		dataA, err := a.nodeData(hashA)
+		if err != nil {
+			return err
+		}
+		dataB, err := b.nodeData(hashB)
+		if err != nil {
+			return err
+		}
+		if dataA == nil || dataB == nil || a.th.isLeaf(dataA) || b.th.isLeaf(dataB) {
+			return fmt.Errorf("%w: node at %s is %x under %s and %x under %s",
+				ErrRootMismatch, position.String(), hashA, nameA, hashB, nameB)
+		}
+
+		leftA, rightA := a.th.parseNode(dataA)
+		leftB, rightB := b.th.parseNode(dataB)
+		if !bytes.Equal(leftA, leftB) {
+			hashA, hashB = leftA, leftB
+			position.WriteByte('0')
+		} else {
+			hashA, hashB = rightA, rightB
+			position.WriteByte('1')
+		}
+	}
+	return nil
+}
+
+// nodeData returns the data of the node with the given hash, or nil for a
+// placeholder.
+func (smt *SparseMerkleTree) nodeData(hash []byte) ([]byte, error) {
+	if bytes.Equal(hash, smt.th.placeholder()) {
+		return nil, nil
+	}
+	return smt.getNode(hash)
+}
@@ -0,0 +1,37 @@
+package smt
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestAssertSameRoot(t *testing.T) {
+	keyvals := make(map[string][]byte)
+	if err := AssertSameRoot(keyvals); err != nil {
+		t.Errorf("returned error for empty tree: %v", err)
+	}
+	for i := 0; i < 200; i++ {
+		keyvals[fmt.Sprintf("testKey%d", i)] = []byte(fmt.Sprintf("testValue%d", i))
+	}
+	keyvals["emptyKey"] = defaultValue
+	if err := AssertSameRoot(keyvals); err != nil {
+		t.Errorf("returned error for matching trees: %v", err)
+	}
+
+	a := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	b := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 20; i++ {
+		a.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte("testValue"))
+		b.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte("testValue"))
+	}
+	b.Update([]byte("testKey7"), []byte("otherValue"))
+	err := firstDivergence("a", a, "b", b)
+	if err == nil {
+		t.Fatal("did not return error for diverging trees")
+	}
+	if !strings.Contains(err.Error(), "under a") || !strings.Contains(err.Error(), "under b") {
+		t.Errorf("error does not name the trees: %v", err)
+	}
+}
@@ -0,0 +1,197 @@
+package smt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrAuditLogCorrupt is returned by ReadAuditLog when a record of an audit log
+// is malformed or fails its checksum.
+var ErrAuditLogCorrupt = errors.New("audit log is corrupt")
+
+// AuditOp is the operation an audit record is for.
+type AuditOp byte
+
+// Operations recorded by an AuditStore.
+const (
+	AuditSet    AuditOp = 1
+	AuditDelete AuditOp = 2
+)
+
+// AuditEntry is a record of an operation applied to an AuditStore.
+type AuditEntry struct {
+	Op   AuditOp
+	Time time.Time
+	Key  []byte
+	// ValueHash is the sha256 digest of the value set, or nil for a delete.
+	ValueHash []byte
+}
+
+// Audit log layout, as a sequence of records, each:
+//
+//	uvarint length of the body, body, big-endian CRC32 (IEEE) of the body
+//
+// where the body is the operation byte, the time as a varint of Unix
+// nanoseconds, then the key and the value hash, each as a uvarint length
+// followed by the bytes.
+
+// AuditStore is a MapStore that records every Set and Delete applied to an
+// inner store in an append-only audit log, with the time of the operation,
+// the key and the digest of the value. Records are only ever appended, each
+// with a single Write, so log should be a file opened with os.O_APPEND or a
+// similar append-only sink. Read the log back with ReadAuditLog.
+type AuditStore struct {
+	store MapStore
+	mu    sync.Mutex
+	log   io.Writer
+	now   func() time.Time
+}
+
+// NewAuditStore creates an AuditStore over store, appending its records to
+// log.
+func NewAuditStore(store MapStore, log io.Writer) *AuditStore {
+	if store == nil {
+		panic("smt: nil store")
+	}
+	if log == nil {
+		panic("smt: nil audit log")
+	}
+	return &AuditStore{store: store, log: log, now: time.Now}
+}
+
+// Get gets the value for a key.
+func (as *AuditStore) Get(key []byte) ([]byte, error) {
+	return as.store.Get(key)
+}
+
+// Set updates the value for a key, and records it once it is applied. If the
+// record cannot be written, its error is returned, but the value is set.
+func (as *AuditStore) Set(key []byte, value []byte) error {
+	if err := as.store.Set(key, value); err != nil {
+		return err
+	}
+	digest := sha256.Sum256(value)
+	return as.record(AuditSet, key, digest[:])
+}
+
+// Delete deletes a key, and records it once it is applied, like Set.
+func (as *AuditStore) Delete(key []byte) error {
+	if err := as.store.Delete(key); err != nil {
+		return err
+	}
+	return as.record(AuditDelete, key, nil)
+}
+
+// Export exports the inner store. Exports are not recorded.
+func (as *AuditStore) Export() ([]byte, error) {
+	return as.store.Export()
+}
+
+// Flush flushes the inner store, if it is a ClosableStore. The audit log is
+// not flushed, as each record is written in full.
+func (as *AuditStore) Flush() error {
+	return flushStore(as.store)
+}
+
+// Close closes the inner store, if it is a ClosableStore. The audit log is
+// left open for its owner to close.
+func (as *AuditStore) Close() error {
+	return closeStore(as.store)
+}
+
+func (as *AuditStore) record(op AuditOp, key []byte, valueHash []byte) error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	var body bytes.Buffer
+	var buf [binary.MaxVarintLen64]byte
+	body.WriteByte(byte(op))
+	body.Write(buf[:binary.PutVarint(buf[:], as.now().UnixNano())])
+	for _, field := range [][]byte{key, valueHash} {
+		body.Write(buf[:binary.PutUvarint(buf[:], uint64(len(field)))])
+		body.Write(field)
+	}
+
+	var crc [4]byte
+	binary.BigEndian.PutUint32(crc[:], crc32.ChecksumIEEE(body.Bytes()))
+	record := make([]byte, 0, binary.MaxVarintLen64+body.Len()+len(crc))
+	record = append(record, buf[:binary.PutUvarint(buf[:], uint64(body.Len()))]...)
+	record = append(record, body.Bytes()...)
+	record = append(record, crc[:]...)
+	_, err := as.log.Write(record)
+	return err
+}
+
+// ReadAuditLog reads the records of an audit log written by an AuditStore, in
+// the order they were applied. ErrAuditLogCorrupt is returned for a malformed
+// record, such as one cut short by a crash while it was written, along with
+// the records before it.
+func ReadAuditLog(r io.Reader) ([]AuditEntry, error) {
+	br := bufio.NewReader(r)
+	var entries []AuditEntry
+	for {
+		length, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil || length > math.MaxInt32 {
+			return entries, ErrAuditLogCorrupt
+		}
+		// Grow the buffer as the record arrives rather than trusting length
+		// up front.
+		var record bytes.Buffer
+		if _, err := io.CopyN(&record, br, int64(length)+4); err != nil {
+			if errors.Is(err, io.EOF) {
+				return entries, ErrAuditLogCorrupt
+			}
+			return entries, err
+		}
+		body := record.Bytes()[:length]
+		if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(record.Bytes()[length:]) {
+			return entries, ErrAuditLogCorrupt
+		}
+		entry, ok := parseAuditRecord(body)
+		if !ok {
+			return entries, ErrAuditLogCorrupt
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func parseAuditRecord(body []byte) (AuditEntry, bool) {
+	if len(body) == 0 {
+		return AuditEntry{}, false
+	}
+	entry := AuditEntry{Op: AuditOp(body[0])}
+	if entry.Op != AuditSet && entry.Op != AuditDelete {
+		return AuditEntry{}, false
+	}
+	body = body[1:]
+	nanos, n := binary.Varint(body)
+	if n <= 0 {
+		return AuditEntry{}, false
+	}
+	entry.Time, body = time.Unix(0, nanos), body[n:]
+	var fields [2][]byte
+	for i := range fields {
+		length, n := binary.Uvarint(body)
+		if n <= 0 || uint64(len(body)-n) < length {
+			return AuditEntry{}, false
+		}
+		fields[i], body = body[n:n+int(length)], body[n+int(length):]
+	}
+	if len(body) != 0 {
+		return AuditEntry{}, false
+	}
+	entry.Key = fields[0]
+	if entry.Op == AuditSet {
+		entry.ValueHash = fields[1]
+	}
+	return entry, true
+}
@@ -0,0 +1,80 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAuditStore(t *testing.T) {
+	var log bytes.Buffer
+	as := NewAuditStore(NewSimpleMap(), &log)
+	clock := time.Unix(1700000000, 0)
+	as.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+
+	as.Set([]byte("key1"), []byte("value1"))
+	as.Set([]byte("key2"), []byte("value2"))
+	as.Delete([]byte("key1"))
+	var invalidKeyError *InvalidKeyError
+	if err := as.Delete([]byte("key1")); !errors.As(err, &invalidKeyError) {
+		t.Errorf("did not return InvalidKeyError when deleting missing key, got %v", err)
+	}
+	value, err := as.Get([]byte("key2"))
+	if err != nil || !bytes.Equal(value, []byte("value2")) {
+		t.Errorf("did not get value from inner store, got %s, %v", value, err)
+	}
+
+	entries, err := ReadAuditLog(bytes.NewReader(log.Bytes()))
+	if err != nil {
+		t.Fatalf("returned error when reading audit log: %v", err)
+	}
+	hash1, hash2 := sha256.Sum256([]byte("value1")), sha256.Sum256([]byte("value2"))
+	expected := []AuditEntry{
+		{Op: AuditSet, Key: []byte("key1"), ValueHash: hash1[:]},
+		{Op: AuditSet, Key: []byte("key2"), ValueHash: hash2[:]},
+		{Op: AuditDelete, Key: []byte("key1")},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("read %d audit entries, expected %d", len(entries), len(expected))
+	}
+	for i, entry := range entries {
+		if entry.Op != expected[i].Op || !bytes.Equal(entry.Key, expected[i].Key) || !bytes.Equal(entry.ValueHash, expected[i].ValueHash) {
+			t.Errorf("audit entry %d is %+v, expected %+v", i, entry, expected[i])
+		}
+		if !entry.Time.Equal(time.Unix(1700000001+int64(i), 0)) {
+			t.Errorf("audit entry %d has time %v", i, entry.Time)
+		}
+	}
+
+	// A record cut short is reported after the records before it.
+	entries, err = ReadAuditLog(bytes.NewReader(log.Bytes()[:log.Len()-1]))
+	if !errors.Is(err, ErrAuditLogCorrupt) || len(entries) != 2 {
+		t.Errorf("did not return ErrAuditLogCorrupt after 2 entries for truncated log, got %d, %v", len(entries), err)
+	}
+	corrupted := append([]byte(nil), log.Bytes()...)
+	corrupted[3] ^= 1
+	if _, err := ReadAuditLog(bytes.NewReader(corrupted)); !errors.Is(err, ErrAuditLogCorrupt) {
+		t.Errorf("did not return ErrAuditLogCorrupt for corrupted log, got %v", err)
+	}
+}
+
+func TestAuditStoreTree(t *testing.T) {
+	var log bytes.Buffer
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewAuditStore(NewSimpleMap(), &log), sha256.New())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	smt.Delete([]byte("testKey"))
+
+	entries, err := ReadAuditLog(&log)
+	if err != nil {
+		t.Fatalf("returned error when reading audit log: %v", err)
+	}
+	path := smt.th.path([]byte("testKey"))
+	if len(entries) != 2 || entries[0].Op != AuditSet || entries[1].Op != AuditDelete || !bytes.Equal(entries[1].Key, path) {
+		t.Errorf("did not record the value updates of the tree, got %+v", entries)
+	}
+}
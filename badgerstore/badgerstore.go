@@ -0,0 +1,268 @@
+// Package badgerstore provides a MapStore backed by BadgerDB, whose
+// log-structured design suits the many small writes of tree updates.
+package badgerstore
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/causevest/smt"
+	badger "github.com/dgraph-io/badger/v3"
+)
+
+// BadgerStore is a MapStore that keeps its entries in a Badger database under
+// a key prefix, so that several stores, such as the nodes and values of a
+// tree, can share one database. The prefixes of stores sharing a database
+// must not be prefixes of each other. It is safe for concurrent use.
+//
+// A store created with NewBadgerStore writes each Set and Delete in its own
+// transaction, and each BatchSet, such as the nodes of an update, in a single
+// one. Stores created from a Batch instead buffer their writes until Flush,
+// which commits them in one transaction.
+type BadgerStore struct {
+	db     *badger.DB
+	prefix []byte
+	batch  *Batch
+}
+
+// NewBadgerStore creates a BadgerStore keeping its entries in db under prefix.
+// Entries already in db under prefix are kept, so a tree can be imported from
+// the store at its last root. The store does not own db: close it once the
+// stores on it are no longer used.
+func NewBadgerStore(db *badger.DB, prefix []byte) *BadgerStore {
+	if db == nil {
+		panic("badgerstore: nil database")
+	}
+	return &BadgerStore{db: db, prefix: append([]byte{}, prefix...)}
+}
+
+// Batch buffers the writes of the BadgerStores created from it, to commit
+// them to the database together with Flush, so that the nodes and values
+// written by the updates of a tree since the last flush, e.g. those of a
+// block, are persisted in full or not at all. Writes are held in memory until
+// flushed, and must fit in a single Badger transaction, or Flush fails with
+// badger.ErrTxnTooBig and keeps them buffered.
+type Batch struct {
+	db *badger.DB
+
+	mu sync.Mutex
+	// pending are the buffered writes by prefixed key, nil for a delete.
+	pending map[string][]byte
+}
+
+// NewBatch creates a Batch committing to db.
+func NewBatch(db *badger.DB) *Batch {
+	if db == nil {
+		panic("badgerstore: nil database")
+	}
+	return &Batch{db: db, pending: make(map[string][]byte)}
+}
+
+// Store creates a BadgerStore keeping its entries in the database of b under
+// prefix, whose writes are buffered in b.
+func (b *Batch) Store(prefix []byte) *BadgerStore {
+	return &BadgerStore{db: b.db, prefix: append([]byte{}, prefix...), batch: b}
+}
+
+// Flush commits the buffered writes in a single transaction.
+func (b *Batch) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.pending) == 0 {
+		return nil
+	}
+	err := b.db.Update(func(txn *badger.Txn) error {
+		for key, value := range b.pending {
+			var err error
+			if value == nil {
+				err = txn.Delete([]byte(key))
+			} else {
+				err = txn.Set([]byte(key), value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	b.pending = make(map[string][]byte)
+	return nil
+}
+
+// Discard drops the buffered writes.
+func (b *Batch) Discard() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending = make(map[string][]byte)
+}
+
+func (s *BadgerStore) key(key []byte) []byte {
+	prefixed := make([]byte, 0, len(s.prefix)+len(key))
+	prefixed = append(prefixed, s.prefix...)
+	return append(prefixed, key...)
+}
+
+// get reads the value of a prefixed key in txn, from the buffered writes
+// first if the store has a batch, whose lock must then be held.
+func (s *BadgerStore) get(txn *badger.Txn, prefixed []byte) ([]byte, bool, error) {
+	if s.batch != nil {
+		if value, ok := s.batch.pending[string(prefixed)]; ok {
+			return value, value != nil, nil
+		}
+	}
+	item, err := txn.Get(prefixed)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	value, err := item.ValueCopy(nil)
+	return value, err == nil, err
+}
+
+// lock locks the batch of the store, if any, returning the function to unlock
+// it.
+func (s *BadgerStore) lock() func() {
+	if s.batch == nil {
+		return func() {}
+	}
+	s.batch.mu.Lock()
+	return s.batch.mu.Unlock
+}
+
+// Get gets the value for a key.
+func (s *BadgerStore) Get(key []byte) ([]byte, error) {
+	values, err := s.BatchGet([][]byte{key})
+	if err != nil {
+		return nil, err
+	}
+	return values[0], nil
+}
+
+// BatchGet gets the values for keys, in order, in a single read transaction.
+func (s *BadgerStore) BatchGet(keys [][]byte) ([][]byte, error) {
+	defer s.lock()()
+	values := make([][]byte, len(keys))
+	err := s.db.View(func(txn *badger.Txn) error {
+		for i, key := range keys {
+			value, ok, err := s.get(txn, s.key(key))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return &smt.InvalidKeyError{Key: key}
+			}
+			values[i] = value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// Set updates the value for a key.
+func (s *BadgerStore) Set(key []byte, value []byte) error {
+	return s.BatchSet([][]byte{key}, [][]byte{value})
+}
+
+// BatchSet updates the values for keys in a single transaction, or buffers
+// them if the store has a batch.
+func (s *BadgerStore) BatchSet(keys [][]byte, values [][]byte) error {
+	if s.batch != nil {
+		defer s.lock()()
+		for i, key := range keys {
+			s.batch.pending[string(s.key(key))] = append([]byte{}, values[i]...)
+		}
+		return nil
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		for i, key := range keys {
+			if err := txn.Set(s.key(key), append([]byte{}, values[i]...)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Delete deletes a key, returning an InvalidKeyError if it is missing, as
+// SimpleMap does.
+func (s *BadgerStore) Delete(key []byte) error {
+	defer s.lock()()
+	prefixed := s.key(key)
+	update := s.db.Update
+	if s.batch != nil {
+		update = s.db.View
+	}
+	return update(func(txn *badger.Txn) error {
+		_, ok, err := s.get(txn, prefixed)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return &smt.InvalidKeyError{Key: key}
+		}
+		if s.batch != nil {
+			s.batch.pending[string(prefixed)] = nil
+			return nil
+		}
+		return txn.Delete(prefixed)
+	})
+}
+
+// Export exports the entries of the store as a map snapshot, like
+// SimpleMap.Export, including buffered writes.
+func (s *BadgerStore) Export() ([]byte, error) {
+	defer s.lock()()
+	sm := smt.NewSimpleMap()
+	err := s.db.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.IteratorOptions{PrefetchValues: true, PrefetchSize: 100, Prefix: s.prefix})
+		defer iter.Close()
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			item := iter.Item()
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if err := sm.Set(item.KeyCopy(nil)[len(s.prefix):], value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if s.batch != nil {
+		for key, value := range s.batch.pending {
+			if len(key) < len(s.prefix) || key[:len(s.prefix)] != string(s.prefix) {
+				continue
+			}
+			if value == nil {
+				sm.Delete([]byte(key[len(s.prefix):]))
+			} else if err := sm.Set([]byte(key[len(s.prefix):]), value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return sm.Export()
+}
+
+// Flush commits the writes buffered in the batch of the store, including those
+// of the other stores of the batch, if it has one.
+func (s *BadgerStore) Flush() error {
+	if s.batch == nil {
+		return nil
+	}
+	return s.batch.Flush()
+}
+
+// Close flushes the store. The database is left open for its owner to close.
+func (s *BadgerStore) Close() error {
+	return s.Flush()
+}
@@ -0,0 +1,102 @@
+package badgerstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/causevest/smt"
+	badger "github.com/dgraph-io/badger/v3"
+)
+
+func openDB(t *testing.T, dir string) *badger.DB {
+	options := badger.DefaultOptions(dir).WithLogger(nil)
+	if dir == "" {
+		options = options.WithInMemory(true)
+	}
+	db, err := badger.Open(options)
+	if err != nil {
+		t.Fatalf("returned error when opening database: %v", err)
+	}
+	return db
+}
+
+func TestBadgerStore(t *testing.T) {
+	db := openDB(t, "")
+	defer db.Close()
+	s := NewBadgerStore(db, []byte("n/"))
+	other := NewBadgerStore(db, []byte("v/"))
+
+	if err := s.Set([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("returned error when setting: %v", err)
+	}
+	if value, err := s.Get([]byte("key")); err != nil || !bytes.Equal(value, []byte("value")) {
+		t.Errorf("did not get value, got %s, %v", value, err)
+	}
+	var invalidKeyError *smt.InvalidKeyError
+	if _, err := other.Get([]byte("key")); !errors.As(err, &invalidKeyError) {
+		t.Errorf("read key of another prefix, got %v", err)
+	}
+	if err := s.Delete([]byte("key")); err != nil {
+		t.Errorf("returned error when deleting: %v", err)
+	}
+	if _, err := s.Get([]byte("key")); !errors.As(err, &invalidKeyError) {
+		t.Errorf("did not return InvalidKeyError for deleted key, got %v", err)
+	}
+	if err := s.Delete([]byte("key")); !errors.As(err, &invalidKeyError) {
+		t.Errorf("did not return InvalidKeyError when deleting missing key, got %v", err)
+	}
+}
+
+func TestBadgerStoreBatch(t *testing.T) {
+	dir := t.TempDir()
+	db := openDB(t, dir)
+	batch := NewBatch(db)
+	nodes, values := batch.Store([]byte("n/")), batch.Store([]byte("v/"))
+	tree := smt.NewSparseMerkleTree(nodes, values, sha256.New())
+	reference := smt.NewSparseMerkleTree(smt.NewSimpleMap(), smt.NewSimpleMap(), sha256.New())
+	for i := 0; i < 50; i++ {
+		key, value := []byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i))
+		tree.Update(key, value)
+		reference.Update(key, value)
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatalf("returned error when flushing: %v", err)
+	}
+	flushed := tree.Root()
+
+	// Buffered writes are read back before they are flushed, and lost if the
+	// batch is discarded.
+	tree.Delete([]byte("testKey3"))
+	tree.Update([]byte("testKey4"), []byte("newValue"))
+	if value, err := tree.Get([]byte("testKey4")); err != nil || !bytes.Equal(value, []byte("newValue")) {
+		t.Errorf("did not get buffered value, got %s, %v", value, err)
+	}
+	if _, err := NewBadgerStore(db, []byte("n/")).Get(tree.Root()); err == nil {
+		t.Error("unflushed write reached the database")
+	}
+	batch.Discard()
+
+	if !bytes.Equal(flushed, reference.Root()) {
+		t.Fatal("tree on Badger has a different root")
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("returned error when closing database: %v", err)
+	}
+	db = openDB(t, dir)
+	defer db.Close()
+	tree = smt.ImportSparseMerkleTree(NewBadgerStore(db, []byte("n/")), NewBadgerStore(db, []byte("v/")), sha256.New(), flushed)
+	for i := 0; i < 50; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		value, err := tree.Get(key)
+		if err != nil || !bytes.Equal(value, []byte(fmt.Sprintf("testValue%d", i))) {
+			t.Errorf("did not get flushed value %d after reopening, got %s, %v", i, value, err)
+		}
+	}
+	proof, err := tree.Prove([]byte("testKey3"))
+	if err != nil || !smt.VerifyProof(proof, flushed, []byte("testKey3"), []byte("testValue3"), sha256.New()) {
+		t.Errorf("did not prove key after reopening: %v", err)
+	}
+}
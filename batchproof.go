@@ -0,0 +1,124 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+)
+
+// BatchUpdate is a single update in a batch: setting Key to Value, or deleting
+// Key if Value is the default (empty) value.
+type BatchUpdate struct {
+	Key   []byte
+	Value []byte
+}
+
+// BatchUpdateProof proves that applying a sequence of updates to one root
+// gives another.
+type BatchUpdateProof struct {
+	// OldValues are the values of the updated keys under the old root, or the
+	// default value for keys that were absent.
+	OldValues [][]byte
+	// Proofs are updatable proofs of the old values against the old root, in
+	// the same order as the updates.
+	Proofs []SparseMerkleProof
+	// Nodes is the data of nodes of the old tree that are not part of any of
+	// the proofs but are reached while replaying the updates, e.g. a subtree
+	// that becomes the sibling of an updated key after a delete.
+	Nodes [][]byte
+}
+
+// ProveBatchUpdate generates a proof for applying updates, in order, to the
+// current root of the tree. The tree itself is not modified; apply the
+// updates with Update to obtain the new root the proof is verified against.
+func (smt *SparseMerkleTree) ProveBatchUpdate(updates []BatchUpdate) (BatchUpdateProof, error) {
+	proof := BatchUpdateProof{
+		OldValues: make([][]byte, len(updates)),
+		Proofs:    make([]SparseMerkleProof, len(updates)),
+	}
+	for i, update := range updates {
+		value, err := smt.Get(update.Key)
+		if err != nil {
+			return BatchUpdateProof{}, err
+		}
+		keyProof, err := smt.ProveUpdatable(update.Key)
+		if err != nil {
+			return BatchUpdateProof{}, err
+		}
+		proof.OldValues[i] = value
+		proof.Proofs[i] = keyProof
+	}
+
+	// Replay the updates as the verifier will, recording the nodes it is
+	// missing.
+	nodes := &recordingStore{MapStore: NewSimpleMap(), source: smt.nodes}
+	dsmst := &DeepSparseMerkleSubTree{
+		SparseMerkleTree: &SparseMerkleTree{th: smt.th, nodes: nodes, values: NewSimpleMap(), root: smt.Root(), defaultLeafValue: smt.defaultLeafValue},
+	}
+	if err := replayBatchUpdate(dsmst, updates, proof); err != nil {
+		return BatchUpdateProof{}, err
+	}
+	for _, data := range nodes.fetched {
+		proof.Nodes = append(proof.Nodes, smt.th.decodeNode(data))
+	}
+	return proof, nil
+}
+
+// VerifyBatchUpdate verifies that applying updates, in order, to oldRoot gives
+// newRoot, using only the proof and without access to the tree: the proven
+// branches are assembled into a deep subtree of oldRoot, on which the updates
+// are replayed.
+func VerifyBatchUpdate(oldRoot []byte, newRoot []byte, updates []BatchUpdate, proof BatchUpdateProof, hasher hash.Hash, options ...VerifyOption) bool {
+	if len(proof.Proofs) != len(updates) || len(proof.OldValues) != len(updates) {
+		return false
+	}
+
+	config := newVerifyConfig(options)
+	dsmst := NewDeepSparseMerkleSubTree(NewSimpleMap(), NewSimpleMap(), hasher, oldRoot, config.treeOptions()...)
+	dsmst.th.setDepth(config.depth)
+	// Nodes are stored by hash, so any data given for them is authentic.
+	for _, data := range proof.Nodes {
+		if err := dsmst.setNode(dsmst.th.digest(data), data); err != nil {
+			return false
+		}
+	}
+	if err := replayBatchUpdate(dsmst, updates, proof); err != nil {
+		return false
+	}
+	return bytes.Equal(dsmst.Root(), newRoot)
+}
+
+func replayBatchUpdate(dsmst *DeepSparseMerkleSubTree, updates []BatchUpdate, proof BatchUpdateProof) error {
+	for i, update := range updates {
+		if err := dsmst.AddBranch(proof.Proofs[i], update.Key, proof.OldValues[i]); err != nil {
+			return err
+		}
+	}
+	for _, update := range updates {
+		if _, err := dsmst.Update(update.Key, update.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordingStore is a MapStore that falls back to reading from source for
+// keys it does not have, recording the values fetched.
+type recordingStore struct {
+	MapStore
+	source  MapStore
+	fetched [][]byte
+}
+
+func (s *recordingStore) Get(key []byte) ([]byte, error) {
+	value, err := s.MapStore.Get(key)
+	if !errors.Is(err, ErrKeyNotFound) {
+		return value, err
+	}
+	value, err = s.source.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	s.fetched = append(s.fetched, value)
+	return value, s.MapStore.Set(key, value)
+}
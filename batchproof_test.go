@@ -0,0 +1,68 @@
+package smt
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestBatchUpdateProof(t *testing.T) {
+	for trial := 0; trial < 100; trial++ {
+		smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+		n := rand.Intn(50)
+		for i := 0; i < n; i++ {
+			smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte("testValue"))
+		}
+		oldRoot := smt.Root()
+
+		// Mix updates of existing keys, deletes, inserts and repeated keys.
+		var updates []BatchUpdate
+		for i := 0; i < 20; i++ {
+			key := []byte(fmt.Sprintf("testKey%d", rand.Intn(70)))
+			value := []byte(fmt.Sprintf("newValue%d", i))
+			if rand.Intn(3) == 0 {
+				value = defaultValue
+			}
+			updates = append(updates, BatchUpdate{Key: key, Value: value})
+		}
+
+		proof, err := smt.ProveBatchUpdate(updates)
+		if err != nil {
+			t.Fatalf("returned error when proving batch update: %v", err)
+		}
+		for _, update := range updates {
+			smt.Update(update.Key, update.Value)
+		}
+		newRoot := smt.Root()
+
+		if !VerifyBatchUpdate(oldRoot, newRoot, updates, proof, sha256.New()) {
+			t.Fatal("valid batch update proof failed to verify")
+		}
+		if VerifyBatchUpdate(oldRoot, oldRoot, updates, proof, sha256.New()) && string(oldRoot) != string(newRoot) {
+			t.Error("batch update proof verified against wrong new root")
+		}
+
+		// Tamper with the last update, which no later update overrides.
+		tampered := append([]BatchUpdate(nil), updates...)
+		last := len(updates) - 1
+		tampered[last] = BatchUpdate{Key: updates[last].Key, Value: []byte("tampered")}
+		if VerifyBatchUpdate(oldRoot, newRoot, tampered, proof, sha256.New()) {
+			t.Error("batch update proof verified with tampered update")
+		}
+	}
+
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	smt.Update([]byte("testKey1"), []byte("testValue1"))
+	updates := []BatchUpdate{{Key: []byte("testKey1"), Value: []byte("newValue1")}}
+	proof, _ := smt.ProveBatchUpdate(updates)
+	oldRoot := smt.Root()
+	smt.Update([]byte("testKey1"), []byte("newValue1"))
+	proof.OldValues[0] = []byte("wrongValue")
+	if VerifyBatchUpdate(oldRoot, smt.Root(), updates, proof, sha256.New()) {
+		t.Error("batch update proof verified with wrong old value")
+	}
+	if VerifyBatchUpdate(oldRoot, smt.Root(), updates, BatchUpdateProof{}, sha256.New()) {
+		t.Error("batch update proof verified with missing proofs")
+	}
+}
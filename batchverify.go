@@ -0,0 +1,77 @@
+package smt
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"runtime"
+	"sync"
+)
+
+// ErrBadRootSize is returned by VerifyProofsBatch for a root that is not the
+// size of the digests of the hasher.
+var ErrBadRootSize = errors.New("root is not the size of the hasher's digests")
+
+// ProofItem is a key, its value, and a proof of the value, for
+// VerifyProofsBatch.
+type ProofItem struct {
+	Key   []byte
+	Value []byte
+	Proof SparseMerkleProof
+}
+
+// VerifyProofsBatch verifies the proofs of many items against one root, as by
+// VerifyProof, in parallel across up to GOMAXPROCS goroutines, each hashing
+// with its own hasher from the hasher factory. It returns whether each item's
+// proof verifies, in the order of items, or an error for arguments under
+// which no proof could, such as ErrBadRootSize.
+func VerifyProofsBatch(root []byte, items []ProofItem, hasher func() hash.Hash, options ...VerifyOption) ([]bool, error) {
+	if hasher == nil {
+		panic("smt: nil hasher")
+	}
+	if size := hasher().Size(); len(root) != size {
+		return nil, fmt.Errorf("%w: %d bytes, expected %d", ErrBadRootSize, len(root), size)
+	}
+
+	results := make([]bool, len(items))
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(items) {
+		workers = len(items)
+	}
+	// Workers take items in chunks from a shared counter, so that slow
+	// proofs do not hold up a fixed share of the items.
+	const chunk = 64
+	var (
+		mu   sync.Mutex
+		next int
+		wg   sync.WaitGroup
+	)
+	claim := func() (int, int) {
+		mu.Lock()
+		defer mu.Unlock()
+		start := next
+		if next += chunk; next > len(items) {
+			next = len(items)
+		}
+		return start, next
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h := hasher()
+			for {
+				start, end := claim()
+				if start == end {
+					return
+				}
+				for i := start; i < end; i++ {
+					item := &items[i]
+					results[i] = VerifyProof(item.Proof, root, item.Key, item.Value, h, options...)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return results, nil
+}
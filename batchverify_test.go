@@ -0,0 +1,54 @@
+package smt
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestVerifyProofsBatch(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 300; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+
+	// Present and absent keys, with every seventh item given a wrong value.
+	var items []ProofItem
+	for i := 0; i < 500; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		proof, err := smt.Prove(key)
+		if err != nil {
+			t.Fatalf("returned error when proving key: %v", err)
+		}
+		value, _ := smt.Get(key)
+		if i%7 == 0 {
+			value = []byte("badValue")
+		}
+		items = append(items, ProofItem{Key: key, Value: value, Proof: proof})
+	}
+
+	results, err := VerifyProofsBatch(smt.Root(), items, sha256.New)
+	if err != nil {
+		t.Fatalf("returned error when verifying proofs: %v", err)
+	}
+	if len(results) != len(items) {
+		t.Fatalf("returned %d results for %d items", len(results), len(items))
+	}
+	for i, ok := range results {
+		if ok != (i%7 != 0) {
+			t.Errorf("item %d verified %v", i, ok)
+		}
+		if ok != VerifyProof(items[i].Proof, smt.Root(), items[i].Key, items[i].Value, sha256.New()) {
+			t.Errorf("item %d result differs from VerifyProof", i)
+		}
+	}
+
+	if results, err := VerifyProofsBatch(smt.Root(), nil, sha256.New); err != nil || len(results) != 0 {
+		t.Errorf("did not verify empty batch, got %v, %v", results, err)
+	}
+	if _, err := VerifyProofsBatch(smt.Root(), items, sha512.New); !errors.Is(err, ErrBadRootSize) {
+		t.Errorf("did not return ErrBadRootSize, got %v", err)
+	}
+}
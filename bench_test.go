@@ -34,3 +34,49 @@ func BenchmarkSparseMerkleTree_Delete(b *testing.B) {
 		_, _ = smt.Delete([]byte(s))
 	}
 }
+
+func BenchmarkSparseMerkleTree_ProveCached(b *testing.B) {
+	for _, size := range []int{0, 10000} {
+		b.Run("cache="+strconv.Itoa(size), func(b *testing.B) {
+			nodes := &countingStore{MapStore: NewSimpleMap()}
+			smt := NewSparseMerkleTree(nodes, NewSimpleMap(), sha256.New())
+			for i := 0; i < 1000; i++ {
+				s := strconv.Itoa(i)
+				_, _ = smt.Update([]byte(s), []byte(s))
+			}
+			smt.EnableNodeHashCache(size)
+			nodes.gets = 0
+
+			b.ResetTimer()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, _ = smt.Prove([]byte(strconv.Itoa(i % 1000)))
+			}
+			b.ReportMetric(float64(nodes.gets)/float64(b.N), "reads/op")
+		})
+	}
+}
+
+func BenchmarkSparseMerkleTree_UpdateBatch(b *testing.B) {
+	keys, values := make([][]byte, 10000), make([][]byte, 10000)
+	for i := range keys {
+		s := strconv.Itoa(i)
+		keys[i], values[i] = []byte(s), []byte(s)
+	}
+	b.Run("sequential", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+			for i := range keys {
+				_, _ = smt.Update(keys[i], values[i])
+			}
+		}
+	})
+	b.Run("batch", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+			_, _ = smt.UpdateBatch(keys, values)
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
+
+// ErrBlobMismatch is returned by BlobOffloadStore when an offloaded value read
+// from the blob store does not match the digest recorded for it.
+var ErrBlobMismatch = errors.New("offloaded value does not match its digest")
+
+// Tags of entries in the local store of a BlobOffloadStore.
+const (
+	blobInline    = 0 // followed by the value
+	blobReference = 1 // followed by the sha256 digest of the value
+)
+
+// BlobOffloadStore is a MapStore that keeps values larger than a threshold in
+// a separate blob store, such as a FileStore or an adapter for an object
+// store, keeping only a reference to them in the local store. Offloaded values
+// are stored in the blob store under the same key, and checked against the
+// digest in their reference when read back.
+type BlobOffloadStore struct {
+	local, blobs MapStore
+	threshold    int
+}
+
+// NewBlobOffloadStore creates a BlobOffloadStore that offloads values of more
+// than threshold bytes from local to blobs. To reopen a store, pass the same
+// local and blob stores.
+func NewBlobOffloadStore(local, blobs MapStore, threshold int) *BlobOffloadStore {
+	return &BlobOffloadStore{local: local, blobs: blobs, threshold: threshold}
+}
+
+// Get gets the value for a key.
+func (bs *BlobOffloadStore) Get(key []byte) ([]byte, error) {
+	entry, err := bs.local.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return bs.resolve(key, entry)
+}
+
+func (bs *BlobOffloadStore) resolve(key []byte, entry []byte) ([]byte, error) {
+	if entry[0] == blobInline {
+		return entry[1:], nil
+	}
+	value, err := bs.blobs.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(value)
+	if !bytes.Equal(digest[:], entry[1:]) {
+		return nil, ErrBlobMismatch
+	}
+	return value, nil
+}
+
+// Set updates the value for a key.
+func (bs *BlobOffloadStore) Set(key []byte, value []byte) error {
+	if len(value) <= bs.threshold {
+		if err := bs.local.Set(key, append([]byte{blobInline}, value...)); err != nil {
+			return err
+		}
+		// Drop any blob of an earlier, larger value.
+		return bs.deleteBlob(key)
+	}
+
+	// Write the blob before the reference, so the reference never points at a
+	// missing blob.
+	if err := bs.blobs.Set(key, value); err != nil {
+		return err
+	}
+	digest := sha256.Sum256(value)
+	return bs.local.Set(key, append([]byte{blobReference}, digest[:]...))
+}
+
+// Delete deletes a key.
+func (bs *BlobOffloadStore) Delete(key []byte) error {
+	if err := bs.local.Delete(key); err != nil {
+		return err
+	}
+	return bs.deleteBlob(key)
+}
+
+func (bs *BlobOffloadStore) deleteBlob(key []byte) error {
+	err := bs.blobs.Delete(key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil
+	}
+	return err
+}
+
+// Export exports the local store, with references to offloaded values rather
+// than the values themselves. Import it with ImportMerkleMap and wrap it in a
+// BlobOffloadStore with the same blob store to restore it.
+func (bs *BlobOffloadStore) Export() ([]byte, error) {
+	return bs.local.Export()
+}
+
+// ExportInline exports the store with offloaded values read back from the
+// blob store, in the same format as SimpleMap.Export, so that it can be
+// imported without the blob store.
+func (bs *BlobOffloadStore) ExportInline() ([]byte, error) {
+	serial, err := bs.local.Export()
+	if err != nil {
+		return nil, err
+	}
+	var entries map[string][]byte
+	if err := decodeSnapshot(serial, &entries); err != nil {
+		return nil, err
+	}
+	for key, entry := range entries {
+		value, err := bs.resolve([]byte(key), entry)
+		if err != nil {
+			return nil, err
+		}
+		entries[key] = value
+	}
+	return encodeSnapshot(entries)
+}
+
+// Flush flushes the blob store, then the local store, each if it is a
+// ClosableStore, so that a flushed reference never points at an unflushed
+// blob.
+func (bs *BlobOffloadStore) Flush() error {
+	if err := flushStore(bs.blobs); err != nil {
+		return err
+	}
+	return flushStore(bs.local)
+}
+
+// Close closes the blob store, then the local store, in the same order as
+// Flush, even once the first fails, returning the first error.
+func (bs *BlobOffloadStore) Close() error {
+	err := closeStore(bs.blobs)
+	if localErr := closeStore(bs.local); err == nil {
+		err = localErr
+	}
+	return err
+}
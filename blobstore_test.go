@@ -0,0 +1,89 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestBlobOffloadStore(t *testing.T) {
+	local, blobs := NewSimpleMap(), NewSimpleMap()
+	bs := NewBlobOffloadStore(local, blobs, 8)
+	small, large := []byte("small"), bytes.Repeat([]byte("large"), 10)
+
+	bs.Set([]byte("smallKey"), small)
+	bs.Set([]byte("largeKey"), large)
+	if len(blobs.m) != 1 {
+		t.Errorf("offloaded %d values, expected 1", len(blobs.m))
+	}
+	for key, expected := range map[string][]byte{"smallKey": small, "largeKey": large} {
+		value, err := bs.Get([]byte(key))
+		if err != nil {
+			t.Errorf("returned error when getting %s: %v", key, err)
+		}
+		if !bytes.Equal(value, expected) {
+			t.Errorf("did not get correct value for %s", key)
+		}
+	}
+
+	// Shrinking an offloaded value drops its blob.
+	bs.Set([]byte("largeKey"), small)
+	if len(blobs.m) != 0 {
+		t.Error("did not drop blob of shrunk value")
+	}
+	bs.Set([]byte("largeKey"), large)
+	if err := bs.Delete([]byte("largeKey")); err != nil {
+		t.Errorf("returned error when deleting offloaded value: %v", err)
+	}
+	if len(blobs.m) != 0 {
+		t.Error("did not drop blob of deleted value")
+	}
+	var invalidKeyError *InvalidKeyError
+	if _, err := bs.Get([]byte("largeKey")); !errors.As(err, &invalidKeyError) {
+		t.Errorf("did not return InvalidKeyError for deleted key, got %v", err)
+	}
+
+	bs.Set([]byte("largeKey"), large)
+	blobs.Set([]byte("largeKey"), []byte("tampered value"))
+	if _, err := bs.Get([]byte("largeKey")); !errors.Is(err, ErrBlobMismatch) {
+		t.Errorf("did not return ErrBlobMismatch for tampered blob, got %v", err)
+	}
+}
+
+func TestBlobOffloadStoreTree(t *testing.T) {
+	blobs := NewSimpleMap()
+	values := NewBlobOffloadStore(NewSimpleMap(), blobs, 8)
+	smt := NewSparseMerkleTree(NewSimpleMap(), values, sha256.New())
+	large := bytes.Repeat([]byte("large"), 10)
+	smt.Update([]byte("smallKey"), []byte("small"))
+	smt.Update([]byte("largeKey"), large)
+
+	// References are restored with the same blob store.
+	serial, err := values.Export()
+	if err != nil {
+		t.Fatalf("returned error when exporting: %v", err)
+	}
+	_, local, err := ImportMerkleMap(serial, serial)
+	if err != nil {
+		t.Fatalf("returned error when importing: %v", err)
+	}
+	value, err := NewBlobOffloadStore(local, blobs, 8).Get(smt.th.path([]byte("largeKey")))
+	if err != nil || !bytes.Equal(value, large) {
+		t.Errorf("did not restore offloaded value from references: %v", err)
+	}
+
+	// Inlined exports import without the blob store.
+	serial, err = values.ExportInline()
+	if err != nil {
+		t.Fatalf("returned error when exporting inline: %v", err)
+	}
+	_, inlined, err := ImportMerkleMap(serial, serial)
+	if err != nil {
+		t.Fatalf("returned error when importing inline: %v", err)
+	}
+	value, err = inlined.Get(smt.th.path([]byte("largeKey")))
+	if err != nil || !bytes.Equal(value, large) {
+		t.Errorf("did not inline offloaded value: %v", err)
+	}
+}
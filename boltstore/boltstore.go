@@ -0,0 +1,168 @@
+// Package boltstore provides a MapStore backed by bbolt, for embedded
+// applications keeping a tree in a single transactional file.
+package boltstore
+
+import (
+	"io"
+
+	"github.com/causevest/smt"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Buckets of the stores opened by OpenBoltStores.
+var (
+	NodesBucket  = []byte("nodes")
+	ValuesBucket = []byte("values")
+)
+
+// BoltStore is a MapStore that keeps its entries in a bucket of a bbolt
+// database, so that the nodes and values of a tree can be kept apart in one
+// file. It is safe for concurrent use.
+type BoltStore struct {
+	db     *bolt.DB
+	bucket []byte
+}
+
+// NewBoltStore creates a BoltStore keeping its entries in bucket of db,
+// creating the bucket if it does not exist. Entries already in the bucket are
+// kept, so a tree can be imported from the store at its last root.
+func NewBoltStore(db *bolt.DB, bucket []byte) (*BoltStore, error) {
+	if db == nil {
+		panic("boltstore: nil database")
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltStore{db: db, bucket: append([]byte{}, bucket...)}, nil
+}
+
+// OpenBoltStores opens or creates the database file at path, and returns
+// stores for the nodes and values of a tree in NodesBucket and ValuesBucket.
+// Close either store, or the tree, to close the file.
+func OpenBoltStores(path string, options *bolt.Options) (nodes *BoltStore, values *BoltStore, err error) {
+	db, err := bolt.Open(path, 0600, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	if nodes, err = NewBoltStore(db, NodesBucket); err == nil {
+		values, err = NewBoltStore(db, ValuesBucket)
+	}
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return nodes, values, nil
+}
+
+// Get gets the value for a key.
+func (s *BoltStore) Get(key []byte) ([]byte, error) {
+	values, err := s.BatchGet([][]byte{key})
+	if err != nil {
+		return nil, err
+	}
+	return values[0], nil
+}
+
+// BatchGet gets the values for keys, in order, in a single read transaction.
+func (s *BoltStore) BatchGet(keys [][]byte) ([][]byte, error) {
+	values := make([][]byte, len(keys))
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		for i, key := range keys {
+			value := b.Get(key)
+			if value == nil {
+				return &smt.InvalidKeyError{Key: key}
+			}
+			// Values are only valid for the transaction.
+			values[i] = append([]byte{}, value...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// Set updates the value for a key.
+func (s *BoltStore) Set(key []byte, value []byte) error {
+	return s.BatchSet([][]byte{key}, [][]byte{value})
+}
+
+// BatchSet updates the values for keys in a single transaction, so that the
+// nodes of an update are written in full or not at all.
+func (s *BoltStore) BatchSet(keys [][]byte, values [][]byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		for i, key := range keys {
+			if err := b.Put(key, values[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Delete deletes a key, returning an InvalidKeyError if it is missing, as
+// SimpleMap does.
+func (s *BoltStore) Delete(key []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		if b.Get(key) == nil {
+			return &smt.InvalidKeyError{Key: key}
+		}
+		return b.Delete(key)
+	})
+}
+
+// Export exports the entries of the store as a map snapshot, like
+// SimpleMap.Export, read in a single transaction.
+func (s *BoltStore) Export() ([]byte, error) {
+	sm := smt.NewSimpleMap()
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).ForEach(func(key, value []byte) error {
+			return sm.Set(append([]byte{}, key...), append([]byte{}, value...))
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sm.Export()
+}
+
+// ExportTo writes the entries of the store to w as a record stream, as read
+// by smt.ImportStoreFrom, one at a time in a read transaction.
+func (s *BoltStore) ExportTo(w io.Writer) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		rw := smt.NewRecordWriter(w)
+		err := tx.Bucket(s.bucket).ForEach(func(key, value []byte) error {
+			return rw.Write(key, value)
+		})
+		if err != nil {
+			return err
+		}
+		return rw.Close()
+	})
+}
+
+// Sync syncs the database file to disk, for a database opened with NoSync,
+// which otherwise syncs on every commit.
+func (s *BoltStore) Sync() error {
+	return s.db.Sync()
+}
+
+// Flush syncs the database file, as Sync.
+func (s *BoltStore) Flush() error {
+	return s.Sync()
+}
+
+// Close closes the database. The stores on a database share it, so once one
+// is closed, all are; closing the others as well, as a tree does with its
+// stores, is harmless.
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,101 @@
+package boltstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/causevest/smt"
+)
+
+func TestBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	nodes, values, err := OpenBoltStores(path, nil)
+	if err != nil {
+		t.Fatalf("returned error when opening stores: %v", err)
+	}
+
+	if err := nodes.Set([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("returned error when setting: %v", err)
+	}
+	if value, err := nodes.Get([]byte("key")); err != nil || !bytes.Equal(value, []byte("value")) {
+		t.Errorf("did not get value, got %s, %v", value, err)
+	}
+	var invalidKeyError *smt.InvalidKeyError
+	if _, err := values.Get([]byte("key")); !errors.As(err, &invalidKeyError) {
+		t.Errorf("read key of another bucket, got %v", err)
+	}
+	if err := nodes.Delete([]byte("key")); err != nil {
+		t.Errorf("returned error when deleting: %v", err)
+	}
+	if err := nodes.Delete([]byte("key")); !errors.As(err, &invalidKeyError) {
+		t.Errorf("did not return InvalidKeyError when deleting missing key, got %v", err)
+	}
+
+	tree := smt.NewSparseMerkleTree(nodes, values, sha256.New())
+	reference := smt.NewSparseMerkleTree(smt.NewSimpleMap(), smt.NewSimpleMap(), sha256.New())
+	for i := 0; i < 50; i++ {
+		key, value := []byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i))
+		tree.Update(key, value)
+		reference.Update(key, value)
+	}
+	if !bytes.Equal(tree.Root(), reference.Root()) {
+		t.Fatal("tree on bbolt has a different root")
+	}
+	root := tree.Root()
+	if err := tree.Flush(); err != nil {
+		t.Errorf("returned error when flushing: %v", err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("returned error when closing: %v", err)
+	}
+
+	nodes, values, err = OpenBoltStores(path, nil)
+	if err != nil {
+		t.Fatalf("returned error when reopening stores: %v", err)
+	}
+	defer nodes.Close()
+	tree = smt.ImportSparseMerkleTree(nodes, values, sha256.New(), root)
+	if value, err := tree.Get([]byte("testKey7")); err != nil || !bytes.Equal(value, []byte("testValue7")) {
+		t.Errorf("did not get value after reopening, got %s, %v", value, err)
+	}
+	nodesBytes, _ := nodes.Export()
+	valuesBytes, _ := values.Export()
+	smn, smv, err := smt.ImportMerkleMap(nodesBytes, valuesBytes)
+	if err != nil {
+		t.Fatalf("returned error when importing export: %v", err)
+	}
+	imported := smt.ImportSparseMerkleTree(smn, smv, sha256.New(), root)
+	if value, err := imported.Get([]byte("testKey42")); err != nil || !bytes.Equal(value, []byte("testValue42")) {
+		t.Errorf("did not get value from export, got %s, %v", value, err)
+	}
+}
+
+func TestBoltStoreExportTo(t *testing.T) {
+	nodes, values, err := OpenBoltStores(filepath.Join(t.TempDir(), "tree.db"), nil)
+	if err != nil {
+		t.Fatalf("returned error when opening stores: %v", err)
+	}
+	defer nodes.Close()
+	for i := 0; i < 20; i++ {
+		nodes.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)))
+		values.Set([]byte(fmt.Sprintf("otherKey%d", i)), []byte("otherValue"))
+	}
+
+	var buf bytes.Buffer
+	if err := smt.ExportStoreTo(&buf, nodes); err != nil {
+		t.Fatalf("returned error when exporting: %v", err)
+	}
+	imported := smt.NewSimpleMap()
+	if n, err := smt.ImportStoreFrom(&buf, imported); err != nil || n != 20 {
+		t.Fatalf("imported %d entries: %v", n, err)
+	}
+	for i := 0; i < 20; i++ {
+		if value, err := imported.Get([]byte(fmt.Sprintf("key%d", i))); err != nil || !bytes.Equal(value, []byte(fmt.Sprintf("value%d", i))) {
+			t.Errorf("did not import entry, got %s, %v", value, err)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+module github.com/causevest/smt/boltstore
+
+go 1.18
+
+require (
+	github.com/causevest/smt v0.0.0-00010101000000-000000000000
+	go.etcd.io/bbolt v1.3.7
+)
+
+require (
+	golang.org/x/crypto v0.2.0 // indirect
+	golang.org/x/sys v0.4.0 // indirect
+)
+
+replace github.com/causevest/smt => ..
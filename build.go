@@ -0,0 +1,370 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+	"sort"
+	"sync"
+)
+
+// ErrUnsortedInput is returned by BuildFromSorted when the entries are not in
+// strictly ascending path order.
+var ErrUnsortedInput = errors.New("entries are not sorted by path")
+
+// KVIterator iterates over key-value pairs.
+type KVIterator interface {
+	// Next advances the iterator to the next pair, returning false when there
+	// are no more pairs or an error occurred.
+	Next() bool
+	// Key returns the key of the current pair.
+	Key() []byte
+	// Value returns the value of the current pair.
+	Value() []byte
+	// Err returns the error that stopped the iteration, if any.
+	Err() error
+}
+
+type sliceIterator struct {
+	keys, values [][]byte
+	pos          int
+}
+
+// NewSliceIterator returns a KVIterator over the given keys and values, which
+// must be of equal length.
+func NewSliceIterator(keys, values [][]byte) KVIterator {
+	return &sliceIterator{keys: keys, values: values, pos: -1}
+}
+
+func (it *sliceIterator) Next() bool {
+	if it.pos+1 >= len(it.keys) || it.pos+1 >= len(it.values) {
+		return false
+	}
+	it.pos++
+	return true
+}
+
+func (it *sliceIterator) Key() []byte   { return it.keys[it.pos] }
+func (it *sliceIterator) Value() []byte { return it.values[it.pos] }
+func (it *sliceIterator) Err() error    { return nil }
+
+// WithBuildParallelism sets the number of goroutines BuildFromSorted and
+// BuildFrom may use to hash independent subtrees, and BuildFrom the paths of
+// the keys. Values below 2 build serially.
+func WithBuildParallelism(n int) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.buildParallelism = n
+	}
+}
+
+type buildEntry struct {
+	key   []byte
+	path  []byte
+	value []byte
+}
+
+type builder struct {
+	smt *SparseMerkleTree
+	sem chan struct{}
+	mu  sync.Mutex // guards writes to the stores
+}
+
+// BuildFromSorted creates a new Sparse Merkle tree on empty MapStores from
+// entries sorted in strictly ascending order of their paths (the digests of
+// their keys under hasher). The tree is constructed bottom-up, hashing each
+// node exactly once, and has the same root as a tree built by calling Update
+// for every entry.
+//
+// The tree is created as by NewSparseMerkleTreeWithHasherFunc, so that
+// subtrees can be hashed concurrently; see WithBuildParallelism. Built
+// serially, the entries are consumed one at a time, holding no more than one
+// partial subtree per bit of a path in memory, so that trees of more entries
+// than fit in memory can be built; built concurrently, they are all read
+// first.
+func BuildFromSorted(nodes, values MapStore, hasher func() hash.Hash, iter KVIterator, options ...Option) (*SparseMerkleTree, error) {
+	smt := NewSparseMerkleTreeWithHasherFunc(nodes, values, hasher, options...)
+	if smt.buildParallelism < 2 {
+		return buildStream(smt, iter)
+	}
+
+	var entries []buildEntry
+	for iter.Next() {
+		value := iter.Value()
+		if smt.IsDeletionValue(value) {
+			continue
+		}
+		if err := smt.checkValueSize(value); err != nil {
+			return nil, err
+		}
+		path := smt.th.path(iter.Key())
+		if len(entries) > 0 && bytes.Compare(entries[len(entries)-1].path, path) >= 0 {
+			return nil, ErrUnsortedInput
+		}
+		entries = append(entries, buildEntry{key: iter.Key(), path: path, value: value})
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	return buildSorted(smt, entries)
+}
+
+// BuildFrom creates a new Sparse Merkle tree on empty MapStores from entries
+// in any order, such as the accounts of a genesis file, as BuildFromSorted
+// does from sorted ones. The paths of the keys are hashed concurrently, as
+// the subtrees are, with WithBuildParallelism, and the entries sorted by
+// path in memory before the tree is built. A key given more than once takes
+// its last value, and is left out if that is the default value.
+func BuildFrom(nodes, values MapStore, hasher func() hash.Hash, iter KVIterator, options ...Option) (*SparseMerkleTree, error) {
+	smt := NewSparseMerkleTreeWithHasherFunc(nodes, values, hasher, options...)
+
+	var entries []buildEntry
+	for iter.Next() {
+		value := iter.Value()
+		if !smt.IsDeletionValue(value) {
+			if err := smt.checkValueSize(value); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, buildEntry{key: iter.Key(), value: value})
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	workers := smt.buildParallelism
+	if workers < 1 {
+		workers = 1
+	}
+	chunk := (len(entries) + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < len(entries); start += chunk {
+		end := start + chunk
+		if end > len(entries) {
+			end = len(entries)
+		}
+		wg.Add(1)
+		go func(part []buildEntry) {
+			defer wg.Done()
+			for i := range part {
+				part[i].path = smt.th.path(part[i].key)
+			}
+		}(entries[start:end])
+	}
+	wg.Wait()
+
+	// A stable sort keeps the writes of a path in order, the last one last.
+	sort.SliceStable(entries, func(i, j int) bool { return bytes.Compare(entries[i].path, entries[j].path) < 0 })
+	kept := entries[:0]
+	for i, entry := range entries {
+		if i+1 < len(entries) && bytes.Equal(entries[i+1].path, entry.path) {
+			continue
+		}
+		if !smt.IsDeletionValue(entry.value) {
+			kept = append(kept, entry)
+		}
+	}
+	return buildSorted(smt, kept)
+}
+
+// buildSorted builds the tree of smt from entries sorted by path and sets its
+// root.
+func buildSorted(smt *SparseMerkleTree, entries []buildEntry) (*SparseMerkleTree, error) {
+	b := &builder{smt: smt}
+	if smt.buildParallelism > 1 {
+		b.sem = make(chan struct{}, smt.buildParallelism-1)
+	}
+	root, err := b.build(entries, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := smt.SetRoot(root); err != nil {
+		return nil, err
+	}
+	return smt, nil
+}
+
+// streamSubtree is a subtree built from consecutive entries of a stream.
+type streamSubtree struct {
+	hash []byte
+	// path is the path of one of the leaves of the subtree.
+	path []byte
+	// depth is the depth of the root of the subtree, which either is a leaf
+	// or splits its leaves on the bit of the path at that depth.
+	depth int
+	leaf  bool
+	// prefix is the number of bits the path shares with that of the subtree
+	// before it in the stream.
+	prefix int
+}
+
+// buildStream builds the tree of smt from the entries of iter, sorted by
+// path, and sets its root. The subtrees built so far are kept on a stack
+// whose common prefixes with the subtree below grow towards the top, so that
+// each entry merges the subtrees that can no longer gain leaves.
+func buildStream(smt *SparseMerkleTree, iter KVIterator) (*SparseMerkleTree, error) {
+	var stack []streamSubtree
+	// merge merges the two subtrees at the top of the stack.
+	merge := func() error {
+		left, right := stack[len(stack)-2], stack[len(stack)-1]
+		depth := right.prefix
+		leftHash, err := streamRaise(smt, left, depth+1)
+		if err != nil {
+			return err
+		}
+		rightHash, err := streamRaise(smt, right, depth+1)
+		if err != nil {
+			return err
+		}
+		hash, data := smt.th.digestNode(leftHash, rightHash)
+		if err := smt.setNode(hash, data); err != nil {
+			return err
+		}
+		stack = stack[:len(stack)-1]
+		stack[len(stack)-1] = streamSubtree{hash: hash, path: left.path, depth: depth, prefix: left.prefix}
+		return nil
+	}
+
+	for iter.Next() {
+		value := iter.Value()
+		if smt.IsDeletionValue(value) {
+			continue
+		}
+		if err := smt.checkValueSize(value); err != nil {
+			return nil, err
+		}
+		path := smt.th.path(iter.Key())
+		leaf := streamSubtree{path: path, leaf: true}
+		if len(stack) > 0 {
+			last := stack[len(stack)-1].path
+			if bytes.Compare(last, path) >= 0 {
+				return nil, ErrUnsortedInput
+			}
+			leaf.prefix = countCommonPrefix(last, path)
+			for len(stack) > 1 && stack[len(stack)-1].prefix > leaf.prefix {
+				if err := merge(); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		var data []byte
+		leaf.hash, data = smt.th.digestLeaf(path, smt.th.digestValue(value))
+		if err := smt.setNode(leaf.hash, data); err != nil {
+			return nil, err
+		}
+		if err := smt.setValue(path, value); err != nil {
+			return nil, err
+		}
+		if err := smt.setKey(path, iter.Key()); err != nil {
+			return nil, err
+		}
+		stack = append(stack, leaf)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	root := smt.th.placeholder()
+	for len(stack) > 1 {
+		if err := merge(); err != nil {
+			return nil, err
+		}
+	}
+	if len(stack) == 1 {
+		var err error
+		if root, err = streamRaise(smt, stack[0], 0); err != nil {
+			return nil, err
+		}
+	}
+	if err := smt.SetRoot(root); err != nil {
+		return nil, err
+	}
+	return smt, nil
+}
+
+// streamRaise returns the hash of the node at depth above subtree, whose root
+// is at least as deep, writing the nodes between them, which have
+// placeholders for their other children. Leaves are not raised, as the tree
+// keeps them at the top of their otherwise empty subtrees.
+func streamRaise(smt *SparseMerkleTree, subtree streamSubtree, depth int) ([]byte, error) {
+	hash := subtree.hash
+	if subtree.leaf {
+		return hash, nil
+	}
+	for d := subtree.depth - 1; d >= depth; d-- {
+		var data []byte
+		if getBitAtFromMSB(subtree.path, d) == right {
+			hash, data = smt.th.digestNode(smt.th.placeholder(), hash)
+		} else {
+			hash, data = smt.th.digestNode(hash, smt.th.placeholder())
+		}
+		if err := smt.setNode(hash, data); err != nil {
+			return nil, err
+		}
+	}
+	return hash, nil
+}
+
+// build returns the root of the subtree at the given depth containing entries,
+// writing its nodes and values to the stores.
+func (b *builder) build(entries []buildEntry, depth int) ([]byte, error) {
+	th := &b.smt.th
+	switch len(entries) {
+	case 0:
+		return th.placeholder(), nil
+	case 1:
+		hash, data := th.digestLeaf(entries[0].path, th.digestValue(entries[0].value))
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if err := b.smt.setNode(hash, data); err != nil {
+			return nil, err
+		}
+		if err := b.smt.setValue(entries[0].path, entries[0].value); err != nil {
+			return nil, err
+		}
+		if err := b.smt.setKey(entries[0].path, entries[0].key); err != nil {
+			return nil, err
+		}
+		return hash, nil
+	}
+
+	split := sort.Search(len(entries), func(i int) bool {
+		return getBitAtFromMSB(entries[i].path, depth) == right
+	})
+
+	var leftHash, rightHash []byte
+	var leftErr, rightErr error
+	select {
+	case b.sem <- struct{}{}:
+		// A worker is available; hash the left subtree concurrently.
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-b.sem }()
+			leftHash, leftErr = b.build(entries[:split], depth+1)
+		}()
+		rightHash, rightErr = b.build(entries[split:], depth+1)
+		wg.Wait()
+	default:
+		leftHash, leftErr = b.build(entries[:split], depth+1)
+		if leftErr == nil {
+			rightHash, rightErr = b.build(entries[split:], depth+1)
+		}
+	}
+	if leftErr != nil {
+		return nil, leftErr
+	}
+	if rightErr != nil {
+		return nil, rightErr
+	}
+
+	hash, data := th.digestNode(leftHash, rightHash)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.smt.setNode(hash, data); err != nil {
+		return nil, err
+	}
+	return hash, nil
+}
@@ -0,0 +1,171 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// sortedByPath returns n random key-value pairs sorted by path under sha256.
+func sortedByPath(n int) ([][]byte, [][]byte) {
+	th := newTreeHasher(sha256.New())
+	keys := make([][]byte, n)
+	values := make([][]byte, n)
+	for i := range keys {
+		keys[i] = make([]byte, 32)
+		rand.Read(keys[i])
+		values[i] = []byte(strconv.Itoa(i))
+	}
+	sort.Sort(byPath{th, keys, values})
+	return keys, values
+}
+
+type byPath struct {
+	th           *treeHasher
+	keys, values [][]byte
+}
+
+func (s byPath) Len() int { return len(s.keys) }
+func (s byPath) Less(i, j int) bool {
+	return bytes.Compare(s.th.path(s.keys[i]), s.th.path(s.keys[j])) < 0
+}
+func (s byPath) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.values[i], s.values[j] = s.values[j], s.values[i]
+}
+
+func TestBuildFromSorted(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 3, 100, 1000} {
+		keys, values := sortedByPath(n)
+
+		smn, smv := NewSimpleMap(), NewSimpleMap()
+		smt := NewSparseMerkleTree(smn, smv, sha256.New())
+		for i := range keys {
+			if _, err := smt.Update(keys[i], values[i]); err != nil {
+				t.Fatalf("returned error when updating key: %v", err)
+			}
+		}
+
+		for _, parallelism := range []int{1, 2, 8} {
+			bsmn, bsmv := NewSimpleMap(), NewSimpleMap()
+			built, err := BuildFromSorted(bsmn, bsmv, sha256.New, NewSliceIterator(keys, values), WithBuildParallelism(parallelism))
+			if err != nil {
+				t.Fatalf("returned error when building tree: %v", err)
+			}
+			if !bytes.Equal(smt.Root(), built.Root()) {
+				t.Errorf("built root does not match updated root for %d keys with parallelism %d", n, parallelism)
+			}
+			if len(smn.m) != len(bsmn.m) || len(smv.m) != len(bsmv.m) {
+				t.Errorf("built stores do not match updated stores for %d keys with parallelism %d", n, parallelism)
+			}
+			for i := range keys {
+				value, err := built.Get(keys[i])
+				if err != nil {
+					t.Errorf("returned error when getting key: %v", err)
+				}
+				if !bytes.Equal(values[i], value) {
+					t.Error("did not get correct value from built tree")
+				}
+			}
+		}
+	}
+}
+
+func TestBuildFromSortedShortPaths(t *testing.T) {
+	// With 8-bit paths, leaves share most of their paths, as in a dense tree.
+	th := newTreeHasher(sha256.New())
+	var keys, values [][]byte
+	used := make(map[byte]bool)
+	for i := 0; len(keys) < 100; i++ {
+		key := []byte(strconv.Itoa(i))
+		if path := th.path(key); !used[path[0]] {
+			used[path[0]] = true
+			keys, values = append(keys, key), append(values, []byte("value"))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return th.path(keys[i])[0] < th.path(keys[j])[0] })
+
+	for n := 0; n <= len(keys); n += 7 {
+		smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithPathBits(8))
+		for i := range keys[:n] {
+			smt.Update(keys[i], values[i])
+		}
+		for _, parallelism := range []int{1, 4} {
+			built, err := BuildFromSorted(NewSimpleMap(), NewSimpleMap(), sha256.New, NewSliceIterator(keys[:n], values[:n]), WithPathBits(8), WithBuildParallelism(parallelism))
+			if err != nil {
+				t.Fatalf("returned error when building tree: %v", err)
+			}
+			if !bytes.Equal(smt.Root(), built.Root()) {
+				t.Errorf("built root does not match updated root for %d keys with parallelism %d", n, parallelism)
+			}
+		}
+	}
+}
+
+func TestBuildFromSortedUnsorted(t *testing.T) {
+	keys, values := sortedByPath(10)
+	keys[3], keys[4] = keys[4], keys[3]
+	_, err := BuildFromSorted(NewSimpleMap(), NewSimpleMap(), sha256.New, NewSliceIterator(keys, values))
+	if !errors.Is(err, ErrUnsortedInput) {
+		t.Error("did not return ErrUnsortedInput for unsorted input")
+	}
+
+	keys, values = sortedByPath(10)
+	keys[4] = keys[3]
+	_, err = BuildFromSorted(NewSimpleMap(), NewSimpleMap(), sha256.New, NewSliceIterator(keys, values))
+	if !errors.Is(err, ErrUnsortedInput) {
+		t.Error("did not return ErrUnsortedInput for duplicate keys")
+	}
+}
+
+func TestBuildFrom(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 100, 1000} {
+		keys, values := sortedByPath(n)
+		rand.Shuffle(n, func(i, j int) {
+			keys[i], keys[j] = keys[j], keys[i]
+			values[i], values[j] = values[j], values[i]
+		})
+		smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithKeyStore(NewSimpleMap()))
+		for i := range keys {
+			smt.Update(keys[i], values[i])
+		}
+		// Repeated keys take their last value, or are left out if that is the
+		// default value.
+		if n > 2 {
+			keys = append(keys, keys[0], keys[1], keys[1])
+			values = append(values, []byte("first"), []byte("second"), defaultValue)
+			smt.Update(keys[0], []byte("first"))
+			smt.Delete(keys[1])
+		}
+
+		for _, parallelism := range []int{1, 3, 8} {
+			smk := NewSimpleMap()
+			built, err := BuildFrom(NewSimpleMap(), NewSimpleMap(), sha256.New, NewSliceIterator(keys, values), WithBuildParallelism(parallelism), WithKeyStore(smk))
+			if err != nil {
+				t.Fatalf("returned error when building tree: %v", err)
+			}
+			if !bytes.Equal(smt.Root(), built.Root()) {
+				t.Errorf("built root does not match updated root for %d keys with parallelism %d", n, parallelism)
+			}
+			if want := len(smt.keys.(*SimpleMap).m); len(smk.m) != want {
+				t.Errorf("built key store has %d keys, want %d", len(smk.m), want)
+			}
+		}
+	}
+}
+
+func BenchmarkBuildFromSorted(b *testing.B) {
+	keys, values := sortedByPath(100000)
+	for _, parallelism := range []int{1, 8} {
+		b.Run("parallelism="+strconv.Itoa(parallelism), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, _ = BuildFromSorted(NewSimpleMap(), NewSimpleMap(), sha256.New, NewSliceIterator(keys, values), WithBuildParallelism(parallelism))
+			}
+		})
+	}
+}
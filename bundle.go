@@ -0,0 +1,185 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+)
+
+// ErrKeyNotInBundle is returned when accessing a key outside the proof bundle
+// a deep subtree was imported from.
+var ErrKeyNotInBundle = errors.New("key is not in the proof bundle")
+
+// proofBundle is the serialised form of a proof bundle.
+type proofBundle struct {
+	Root   []byte
+	Keys   [][]byte
+	Values [][]byte
+	// Nodes is the data of every node on the paths of the keys and of their
+	// side nodes, which is enough to update the keys in any order.
+	Nodes [][]byte
+	// Prefixes are the leaf and node domain prefixes of the tree, or nil for
+	// bundles exported before they were recorded, with the default prefixes.
+	Prefixes []byte
+}
+
+// ExportProofBundle exports the nodes needed to prove and update keys under
+// the current root, along with the keys' values. Import it with
+// ImportProofBundle.
+func (smt *SparseMerkleTree) ExportProofBundle(keys [][]byte) ([]byte, error) {
+	root := smt.Root()
+	bundle := proofBundle{Root: root, Keys: keys, Values: make([][]byte, len(keys)), Prefixes: smt.th.domainPrefixes()}
+	seen := make(map[string]bool)
+	for i, key := range keys {
+		value, err := smt.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		bundle.Values[i] = value
+
+		sideNodes, pathNodes, _, _, err := smt.sideNodesForRoot(smt.th.path(key), root, false)
+		if err != nil {
+			return nil, err
+		}
+		var hashes [][]byte
+		for _, hash := range append(pathNodes, sideNodes...) {
+			if !bytes.Equal(hash, smt.th.placeholder()) && !seen[string(hash)] {
+				seen[string(hash)] = true
+				hashes = append(hashes, hash)
+			}
+		}
+		nodes, err := smt.getNodes(hashes)
+		if err != nil {
+			return nil, err
+		}
+		bundle.Nodes = append(bundle.Nodes, nodes...)
+	}
+	return GobEncode(bundle)
+}
+
+// EstimateBundleSize returns the size of the proof bundle ExportProofBundle
+// exports for keys, to weigh it against a full export of the tree, such as
+// the encoded TrieWrap of ExportTrie, and ship whichever is smaller. The
+// bundle is built and serialised to be measured, so this costs as much as
+// exporting it.
+func (smt *SparseMerkleTree) EstimateBundleSize(keys [][]byte) (int, error) {
+	bundle, err := smt.ExportProofBundle(keys)
+	if err != nil {
+		return 0, err
+	}
+	return len(bundle), nil
+}
+
+// ExportSingleKeyTree exports the minimal tree for proving and updating key
+// under the current root: the nodes on its path and their siblings, with its
+// value. It is a proof bundle of the one key, so it is imported with
+// ImportProofBundle into a deep subtree whose Get, Update and Delete of key
+// give roots that the exporting tree would, for a light client to send back.
+func (smt *SparseMerkleTree) ExportSingleKeyTree(key []byte) ([]byte, error) {
+	return smt.ExportProofBundle([][]byte{key})
+}
+
+// ImportProofBundle imports a proof bundle exported by ExportProofBundle into
+// a deep subtree on in-memory stores, rooted at the root the bundle was
+// exported at. Every node and value in the bundle is verified against the
+// root. The subtree can get, update and prove only the keys in the bundle,
+// returning ErrKeyNotInBundle for others. options must give the domain
+// prefixes of the exporting tree, or ErrDomainPrefixes is returned.
+func ImportProofBundle(data []byte, hasher hash.Hash, options ...Option) (*DeepSparseMerkleSubTree, error) {
+	var bundle proofBundle
+	if err := GobDecode(data, &bundle); err != nil {
+		return nil, err
+	}
+	if len(bundle.Values) != len(bundle.Keys) {
+		return nil, ErrBadProof
+	}
+
+	dsmst := NewDeepSparseMerkleSubTree(NewSimpleMap(), NewSimpleMap(), hasher, bundle.Root, options...)
+	if bundle.Prefixes == nil {
+		bundle.Prefixes = []byte{leafPrefix[0], nodePrefix[0]}
+	}
+	if !bytes.Equal(bundle.Prefixes, dsmst.th.domainPrefixes()) {
+		return nil, ErrDomainPrefixes
+	}
+	// Nodes are stored by hash, so any data given for them is authentic.
+	var writes nodeBatch
+	for _, node := range bundle.Nodes {
+		writes.add(dsmst.th.digest(node), node)
+	}
+	if err := dsmst.setNodes(&writes); err != nil {
+		return nil, err
+	}
+
+	dsmst.bundlePaths = make(map[string]struct{}, len(bundle.Keys))
+	for i, key := range bundle.Keys {
+		proof, err := dsmst.ProveForRoot(key, bundle.Root)
+		if err != nil {
+			return nil, err
+		}
+		if ok, _ := verifyProofWithUpdates(proof, bundle.Root, key, bundle.Values[i], &dsmst.th, dsmst.emptyValue()); !ok {
+			return nil, ErrBadProof
+		}
+		path := dsmst.th.path(key)
+		if !bytes.Equal(bundle.Values[i], dsmst.emptyValue()) {
+			if err := dsmst.setValue(path, bundle.Values[i]); err != nil {
+				return nil, err
+			}
+		}
+		dsmst.bundlePaths[string(path)] = struct{}{}
+	}
+	return dsmst, nil
+}
+
+// checkBundle returns ErrKeyNotInBundle if the subtree was imported from a
+// proof bundle that does not include key.
+func (dsmst *DeepSparseMerkleSubTree) checkBundle(key []byte) error {
+	if dsmst.bundlePaths == nil {
+		return nil
+	}
+	if _, ok := dsmst.bundlePaths[string(dsmst.th.path(key))]; !ok {
+		return ErrKeyNotInBundle
+	}
+	return nil
+}
+
+// Get gets the value of a key from the subtree.
+func (dsmst *DeepSparseMerkleSubTree) Get(key []byte) ([]byte, error) {
+	if err := dsmst.checkBundle(key); err != nil {
+		return nil, err
+	}
+	return dsmst.SparseMerkleTree.Get(key)
+}
+
+// Has returns true if the subtree has a leaf for key, false otherwise.
+func (dsmst *DeepSparseMerkleSubTree) Has(key []byte) (bool, error) {
+	if err := dsmst.checkBundle(key); err != nil {
+		return false, err
+	}
+	return dsmst.SparseMerkleTree.Has(key)
+}
+
+// Update sets a new value for a key in the subtree, and sets and returns the
+// new root of the subtree.
+func (dsmst *DeepSparseMerkleSubTree) Update(key []byte, value []byte) ([]byte, error) {
+	if err := dsmst.checkBundle(key); err != nil {
+		return nil, err
+	}
+	return dsmst.SparseMerkleTree.Update(key, value)
+}
+
+// Delete deletes a value from the subtree. It returns the new root of the
+// subtree.
+func (dsmst *DeepSparseMerkleSubTree) Delete(key []byte) ([]byte, error) {
+	if err := dsmst.checkBundle(key); err != nil {
+		return nil, err
+	}
+	return dsmst.SparseMerkleTree.Delete(key)
+}
+
+// Prove generates a Merkle proof for a key against the current root.
+func (dsmst *DeepSparseMerkleSubTree) Prove(key []byte) (SparseMerkleProof, error) {
+	if err := dsmst.checkBundle(key); err != nil {
+		return SparseMerkleProof{}, err
+	}
+	return dsmst.SparseMerkleTree.Prove(key)
+}
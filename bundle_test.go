@@ -0,0 +1,182 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestProofBundle(t *testing.T) {
+	for trial := 0; trial < 20; trial++ {
+		smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+		n := 1 + rand.Intn(100)
+		for i := 0; i < n; i++ {
+			smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+		}
+		// Bundle some present and some absent keys.
+		var keys [][]byte
+		for i := 0; i < 10; i++ {
+			keys = append(keys, []byte(fmt.Sprintf("testKey%d", rand.Intn(n+10))))
+		}
+
+		data, err := smt.ExportProofBundle(keys)
+		if err != nil {
+			t.Fatalf("returned error when exporting bundle: %v", err)
+		}
+		dsmst, err := ImportProofBundle(data, sha256.New())
+		if err != nil {
+			t.Fatalf("returned error when importing bundle: %v", err)
+		}
+		if !bytes.Equal(dsmst.Root(), smt.Root()) {
+			t.Fatal("imported bundle has wrong root")
+		}
+		for _, key := range keys {
+			expected, _ := smt.Get(key)
+			value, err := dsmst.Get(key)
+			if err != nil {
+				t.Errorf("returned error when getting bundled key: %v", err)
+			}
+			if !bytes.Equal(value, expected) {
+				t.Errorf("did not get correct value for bundled key %s", key)
+			}
+		}
+
+		// Updates and deletes of bundled keys, in any order, track the tree.
+		for i := 0; i < 20; i++ {
+			key := keys[rand.Intn(len(keys))]
+			value := []byte(fmt.Sprintf("newValue%d", i))
+			if rand.Intn(2) == 0 {
+				value = defaultValue
+			}
+			smt.Update(key, value)
+			if _, err := dsmst.Update(key, value); err != nil {
+				t.Fatalf("returned error when updating bundled key: %v", err)
+			}
+			if !bytes.Equal(dsmst.Root(), smt.Root()) {
+				t.Fatal("subtree root diverged from tree root after update")
+			}
+		}
+
+		if _, err := dsmst.Get([]byte("outsideKey")); !errors.Is(err, ErrKeyNotInBundle) {
+			t.Errorf("did not return ErrKeyNotInBundle when getting outside key, got %v", err)
+		}
+		if _, err := dsmst.Update([]byte("outsideKey"), []byte("value")); !errors.Is(err, ErrKeyNotInBundle) {
+			t.Errorf("did not return ErrKeyNotInBundle when updating outside key, got %v", err)
+		}
+	}
+}
+
+func TestSingleKeyTree(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 50; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	key := []byte("testKey7")
+	data, err := smt.ExportSingleKeyTree(key)
+	if err != nil {
+		t.Fatalf("returned error when exporting single key tree: %v", err)
+	}
+	dsmst, err := ImportProofBundle(data, sha256.New())
+	if err != nil {
+		t.Fatalf("returned error when importing single key tree: %v", err)
+	}
+	if value, _ := dsmst.Get(key); !bytes.Equal(value, []byte("testValue7")) {
+		t.Errorf("did not get value of exported key, got %s", value)
+	}
+	newRoot, err := dsmst.Update(key, []byte("newValue"))
+	if err != nil {
+		t.Fatalf("returned error when updating exported key: %v", err)
+	}
+	if root, _ := smt.Update(key, []byte("newValue")); !bytes.Equal(newRoot, root) {
+		t.Error("root of updated single key tree differs from the tree's")
+	}
+	if _, err := dsmst.Get([]byte("testKey8")); !errors.Is(err, ErrKeyNotInBundle) {
+		t.Errorf("did not return ErrKeyNotInBundle for another key, got %v", err)
+	}
+}
+
+func TestProofBundleTampered(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	smt.Update([]byte("testKey1"), []byte("testValue1"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	data, _ := smt.ExportProofBundle([][]byte{[]byte("testKey1")})
+
+	var bundle proofBundle
+	GobDecode(data, &bundle)
+	bundle.Values[0] = []byte("tampered")
+	data, _ = GobEncode(bundle)
+	if _, err := ImportProofBundle(data, sha256.New()); !errors.Is(err, ErrBadProof) {
+		t.Errorf("did not return ErrBadProof for tampered value, got %v", err)
+	}
+
+	bundle.Values[0] = []byte("testValue1")
+	bundle.Nodes = bundle.Nodes[:1]
+	data, _ = GobEncode(bundle)
+	if _, err := ImportProofBundle(data, sha256.New()); err == nil {
+		t.Error("did not return error for bundle with missing nodes")
+	}
+}
+
+func TestProofBundleDomainPrefixes(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithDomainPrefixes(0x10, 0x11))
+	var keys [][]byte
+	for i := 0; i < 10; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		smt.Update(key, []byte(fmt.Sprintf("testValue%d", i)))
+		keys = append(keys, key)
+	}
+	data, err := smt.ExportProofBundle(keys[:3])
+	if err != nil {
+		t.Fatalf("returned error when exporting bundle: %v", err)
+	}
+	if _, err := ImportProofBundle(data, sha256.New()); !errors.Is(err, ErrDomainPrefixes) {
+		t.Errorf("did not return ErrDomainPrefixes for other prefixes, got %v", err)
+	}
+	dsmst, err := ImportProofBundle(data, sha256.New(), WithDomainPrefixes(0x10, 0x11))
+	if err != nil {
+		t.Fatalf("returned error when importing bundle: %v", err)
+	}
+	if _, err := dsmst.Update(keys[0], []byte("newValue")); err != nil {
+		t.Errorf("returned error when updating imported key: %v", err)
+	}
+	smt.Update(keys[0], []byte("newValue"))
+	if !bytes.Equal(dsmst.Root(), smt.Root()) {
+		t.Error("imported subtree root does not match after update")
+	}
+}
+
+func TestEstimateBundleSize(t *testing.T) {
+	trie := NewMerkleTrie()
+	var keys [][]byte
+	for i := 0; i < 200; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		trie.Update(key, []byte(fmt.Sprintf("testValue%d", i)))
+		keys = append(keys, key)
+	}
+	wrap, err := ExportTrie(trie)
+	if err != nil {
+		t.Fatalf("returned error when exporting trie: %v", err)
+	}
+	full, err := GobEncode(wrap)
+	if err != nil {
+		t.Fatalf("returned error when encoding trie: %v", err)
+	}
+
+	size, err := trie.EstimateBundleSize(keys[:3])
+	if err != nil {
+		t.Fatalf("returned error when estimating bundle size: %v", err)
+	}
+	bundle, _ := trie.ExportProofBundle(keys[:3])
+	if size != len(bundle) {
+		t.Errorf("estimated %d bytes for a %d-byte bundle", size, len(bundle))
+	}
+	if size >= len(full) {
+		t.Errorf("bundle of 3 keys is %d bytes, full export %d", size, len(full))
+	}
+	if all, _ := trie.EstimateBundleSize(keys); all <= size {
+		t.Errorf("bundle of all keys is %d bytes, of 3 keys %d", all, size)
+	}
+}
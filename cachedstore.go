@@ -0,0 +1,180 @@
+package smt
+
+import (
+	"container/list"
+	"sync"
+)
+
+// CacheStats counts the Get calls served by a CachedStore, and what it holds.
+type CacheStats struct {
+	Hits      uint64 // Gets served from the cache.
+	Misses    uint64 // Gets read from the inner store.
+	Evictions uint64 // Entries evicted to stay within the budget.
+	Entries   int    // Entries cached.
+	Bytes     int64  // Size of the cached keys and values.
+}
+
+// CachedStore is a MapStore that keeps the values most recently read from or
+// written to an inner store, such as a LevelDB, SQL or remote node store, in
+// a least recently used cache bounded by a number of entries, a number of
+// bytes, or both. Every Get and Update of a tree reads the nodes on a path
+// from the root, so a cache in front of a slow node store serves the upper
+// levels, which every path shares, from memory. Sets are written through to
+// the inner store and cached, and Deletes invalidate the cached entry;
+// missing keys are not cached. Writes made to the inner store other than
+// through the CachedStore are not seen while the entry is cached.
+type CachedStore struct {
+	store      MapStore
+	maxEntries int
+	maxBytes   int64
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // Of *cachedEntry, most recently used first.
+	stats   CacheStats
+	// writes counts the Sets and Deletes, so that a Get that raced with one
+	// does not cache the value it read.
+	writes uint64
+}
+
+type cachedEntry struct {
+	key   string
+	value []byte
+}
+
+// NewCachedStore creates a CachedStore over store, caching up to maxEntries
+// entries and up to maxBytes bytes of keys and values; a bound of 0 lifts
+// it, but not both. A value larger than maxBytes by itself is not cached.
+func NewCachedStore(store MapStore, maxEntries int, maxBytes int64) *CachedStore {
+	if store == nil {
+		panic("smt: nil store")
+	}
+	if maxEntries < 0 || maxBytes < 0 || (maxEntries == 0 && maxBytes == 0) {
+		panic("smt: invalid cache budget")
+	}
+	return &CachedStore{
+		store:      store,
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// Get gets the value for a key, from the cache if it holds it.
+func (cs *CachedStore) Get(key []byte) ([]byte, error) {
+	cs.mu.Lock()
+	if elem, ok := cs.entries[string(key)]; ok {
+		cs.order.MoveToFront(elem)
+		cs.stats.Hits++
+		cs.mu.Unlock()
+		return elem.Value.(*cachedEntry).value, nil
+	}
+	cs.stats.Misses++
+	writes := cs.writes
+	cs.mu.Unlock()
+
+	value, err := cs.store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.writes == writes {
+		cs.add(key, value)
+	}
+	return value, nil
+}
+
+// Set updates the value for a key in the inner store, then caches it.
+func (cs *CachedStore) Set(key []byte, value []byte) error {
+	cs.Invalidate(key)
+	if err := cs.store.Set(key, value); err != nil {
+		return err
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.add(key, append([]byte{}, value...))
+	return nil
+}
+
+// Delete deletes a key from the inner store and invalidates its cached value.
+func (cs *CachedStore) Delete(key []byte) error {
+	cs.Invalidate(key)
+	return cs.store.Delete(key)
+}
+
+// Export exports the inner store.
+func (cs *CachedStore) Export() ([]byte, error) {
+	return cs.store.Export()
+}
+
+// Flush flushes the inner store, if it is a ClosableStore.
+func (cs *CachedStore) Flush() error {
+	return flushStore(cs.store)
+}
+
+// Close closes the inner store, if it is a ClosableStore.
+func (cs *CachedStore) Close() error {
+	return closeStore(cs.store)
+}
+
+// Invalidate drops the cached value of key, if any, so that the next Get
+// reads it from the inner store.
+func (cs *CachedStore) Invalidate(key []byte) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.writes++
+	if elem, ok := cs.entries[string(key)]; ok {
+		cs.remove(elem)
+	}
+}
+
+// Purge drops every cached value.
+func (cs *CachedStore) Purge() {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.writes++
+	cs.entries = make(map[string]*list.Element)
+	cs.order.Init()
+	cs.stats.Entries, cs.stats.Bytes = 0, 0
+}
+
+// Stats returns the hit, miss and eviction counts of the cache so far, and
+// its current size.
+func (cs *CachedStore) Stats() CacheStats {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.stats
+}
+
+// add caches value for key, which must not be cached, evicting the least
+// recently used entries to stay within the budget. It must be called with the
+// lock held.
+func (cs *CachedStore) add(key []byte, value []byte) {
+	entry := &cachedEntry{key: string(key), value: value}
+	size := cachedSize(entry)
+	if cs.maxBytes > 0 && size > cs.maxBytes {
+		return
+	}
+	for cs.order.Len() > 0 && ((cs.maxEntries > 0 && cs.order.Len() >= cs.maxEntries) || (cs.maxBytes > 0 && cs.stats.Bytes+size > cs.maxBytes)) {
+		cs.remove(cs.order.Back())
+		cs.stats.Evictions++
+	}
+	cs.entries[entry.key] = cs.order.PushFront(entry)
+	cs.stats.Entries++
+	cs.stats.Bytes += size
+}
+
+// remove drops elem from the cache. It must be called with the lock held.
+func (cs *CachedStore) remove(elem *list.Element) {
+	entry := elem.Value.(*cachedEntry)
+	cs.order.Remove(elem)
+	delete(cs.entries, entry.key)
+	cs.stats.Entries--
+	cs.stats.Bytes -= cachedSize(entry)
+}
+
+func cachedSize(entry *cachedEntry) int64 {
+	return int64(len(entry.key) + len(entry.value))
+}
@@ -0,0 +1,106 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func TestCachedStore(t *testing.T) {
+	inner := NewSimpleMap()
+	cs := NewCachedStore(inner, 2, 0)
+
+	inner.Set([]byte("key1"), []byte("value1"))
+	cs.Get([]byte("key1"))
+	// Writes made around the cache are not seen while the entry is cached.
+	inner.Set([]byte("key1"), []byte("changed1"))
+	if value, _ := cs.Get([]byte("key1")); !bytes.Equal(value, []byte("value1")) {
+		t.Errorf("did not serve cached value, got %s", value)
+	}
+	cs.Invalidate([]byte("key1"))
+	if value, _ := cs.Get([]byte("key1")); !bytes.Equal(value, []byte("changed1")) {
+		t.Errorf("did not read inner store after invalidation, got %s", value)
+	}
+
+	cs.Set([]byte("key2"), []byte("value2"))
+	if value, _ := inner.Get([]byte("key2")); !bytes.Equal(value, []byte("value2")) {
+		t.Error("Set did not write through to inner store")
+	}
+	cs.Get([]byte("key1"))
+	cs.Set([]byte("key3"), []byte("value3"))
+	inner.Set([]byte("key2"), []byte("changed2"))
+	if value, _ := cs.Get([]byte("key2")); !bytes.Equal(value, []byte("changed2")) {
+		t.Errorf("least recently used entry not evicted, got %s", value)
+	}
+	if stats := cs.Stats(); stats.Hits != 2 || stats.Misses != 3 || stats.Evictions != 2 || stats.Entries != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	cs.Delete([]byte("key3"))
+	if _, err := cs.Get([]byte("key3")); err == nil {
+		t.Error("got deleted key")
+	}
+	if _, err := cs.Get([]byte("missing")); err == nil {
+		t.Error("got missing key")
+	}
+	cs.Purge()
+	if stats := cs.Stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("purged cache holds %d entries of %d bytes", stats.Entries, stats.Bytes)
+	}
+
+	// A byte budget evicts as many entries as needed, and skips values that
+	// do not fit at all.
+	cs = NewCachedStore(NewSimpleMap(), 0, 20)
+	cs.Set([]byte("a"), []byte("123456789"))
+	cs.Set([]byte("b"), []byte("123456789"))
+	cs.Set([]byte("c"), []byte("123456789012345678"))
+	if stats := cs.Stats(); stats.Entries != 1 || stats.Bytes != 19 || stats.Evictions != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	cs.Set([]byte("d"), make([]byte, 20))
+	if stats := cs.Stats(); stats.Entries != 1 || stats.Bytes != 19 {
+		t.Errorf("cached a value over the budget, stats %+v", stats)
+	}
+	if value, err := cs.Get([]byte("d")); err != nil || len(value) != 20 {
+		t.Errorf("did not read uncached value, got %d bytes, %v", len(value), err)
+	}
+}
+
+func TestCachedStoreTree(t *testing.T) {
+	nodes := NewCachedStore(NewSimpleMap(), 64, 0)
+	smt := NewSparseMerkleTree(nodes, NewSimpleMap(), sha256.New())
+	plain := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 100; i++ {
+		key, value := []byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i))
+		smt.Update(key, value)
+		plain.Update(key, value)
+	}
+	for i := 0; i < 100; i += 2 {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		smt.Delete(key)
+		plain.Delete(key)
+	}
+	if !bytes.Equal(smt.Root(), plain.Root()) {
+		t.Fatal("tree over a cached store has a different root")
+	}
+	for i := 1; i < 100; i += 2 {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		proof, err := smt.Prove(key)
+		if err != nil || !VerifyProof(proof, smt.Root(), key, []byte(fmt.Sprintf("testValue%d", i)), sha256.New()) {
+			t.Fatalf("bad proof for %s", key)
+		}
+	}
+	if stats := nodes.Stats(); stats.Hits == 0 || stats.Entries > 64 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestCachedStoreBudget(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("did not panic without a budget")
+		}
+	}()
+	NewCachedStore(NewSimpleMap(), 0, 0)
+}
@@ -0,0 +1,214 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"sort"
+)
+
+// A minimal encoder and decoder of CBOR (RFC 8949) for the MarshalCBOR
+// methods of proofs and exported tries: unsigned integers, byte and text
+// strings, arrays and maps, of definite lengths. Encodings are deterministic,
+// as in section 4.2 of the RFC: integers and lengths take the fewest bytes,
+// and map keys are sorted by their encodings.
+
+// CBOR major types.
+const (
+	cborUint  byte = 0
+	cborBytes byte = 2
+	cborText  byte = 3
+	cborArray byte = 4
+	cborMap   byte = 5
+)
+
+var errCBOR = errors.New("malformed CBOR")
+
+// appendCBORHead appends the head of a data item of major type major and
+// argument n.
+func appendCBORHead(b []byte, major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return append(b, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return append(b, major|25, byte(n>>8), byte(n))
+	case n <= math.MaxUint32:
+		return append(b, major|26, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	b = append(b, major|27)
+	for shift := 56; shift >= 0; shift -= 8 {
+		b = append(b, byte(n>>shift))
+	}
+	return b
+}
+
+func appendCBORBytes(b []byte, major byte, v []byte) []byte {
+	return append(appendCBORHead(b, major, uint64(len(v))), v...)
+}
+
+// cborMapWriter collects the entries of a map, to be encoded in the order of
+// their keys.
+type cborMapWriter struct {
+	keys, values [][]byte
+}
+
+func (mw *cborMapWriter) add(key, value []byte) {
+	mw.keys = append(mw.keys, key)
+	mw.values = append(mw.values, value)
+}
+
+// text adds an entry with a text key.
+func (mw *cborMapWriter) text(key string, value []byte) {
+	mw.add(appendCBORBytes(nil, cborText, []byte(key)), value)
+}
+
+func (mw *cborMapWriter) appendTo(b []byte) []byte {
+	order := make([]int, len(mw.keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return bytes.Compare(mw.keys[order[i]], mw.keys[order[j]]) < 0 })
+	b = appendCBORHead(b, cborMap, uint64(len(mw.keys)))
+	for _, i := range order {
+		b = append(append(b, mw.keys[i]...), mw.values[i]...)
+	}
+	return b
+}
+
+// cborReader decodes data items, keeping the first error.
+type cborReader struct {
+	data []byte
+	err  error
+}
+
+// head reads the head of a data item, failing for indefinite lengths and
+// arguments that are not the shortest.
+func (cr *cborReader) head() (major byte, n uint64) {
+	if cr.err != nil {
+		return 0, 0
+	}
+	if len(cr.data) == 0 {
+		cr.err = errCBOR
+		return 0, 0
+	}
+	major, info := cr.data[0]>>5, cr.data[0]&0x1f
+	cr.data = cr.data[1:]
+	if info < 24 {
+		return major, uint64(info)
+	}
+	if info > 27 {
+		cr.err = errCBOR
+		return 0, 0
+	}
+	size := 1 << (info - 24)
+	if len(cr.data) < size {
+		cr.err = errCBOR
+		return 0, 0
+	}
+	for _, b := range cr.data[:size] {
+		n = n<<8 | uint64(b)
+	}
+	cr.data = cr.data[size:]
+	if (size == 1 && n < 24) || (size > 1 && n>>(size*4) == 0) {
+		cr.err = errCBOR
+		return 0, 0
+	}
+	return major, n
+}
+
+// expect reads the head of a data item of the given major type.
+func (cr *cborReader) expect(major byte) uint64 {
+	actual, n := cr.head()
+	if cr.err == nil && actual != major {
+		cr.err = errCBOR
+	}
+	return n
+}
+
+func (cr *cborReader) uint(max uint64) uint64 {
+	n := cr.expect(cborUint)
+	if cr.err == nil && n > max {
+		cr.err = errCBOR
+	}
+	return n
+}
+
+// bytes reads a byte or text string as a copy.
+func (cr *cborReader) bytes(major byte) []byte {
+	n := cr.expect(major)
+	if cr.err != nil {
+		return nil
+	}
+	if n > uint64(len(cr.data)) {
+		cr.err = errCBOR
+		return nil
+	}
+	v := append([]byte{}, cr.data[:n]...)
+	cr.data = cr.data[n:]
+	return v
+}
+
+// length reads the head of an array or map, whose elements must each take at
+// least a byte, bounding it before it is trusted with an allocation.
+func (cr *cborReader) length(major byte) int {
+	n := cr.expect(major)
+	if cr.err == nil && n > uint64(len(cr.data)) {
+		cr.err = errCBOR
+	}
+	if cr.err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+// cborMaxNesting bounds the nesting of the arrays, maps and tags skipped.
+const cborMaxNesting = 32
+
+// skip reads a data item of any type, such as the value of an unknown map
+// key.
+func (cr *cborReader) skip() {
+	cr.skipNested(0)
+}
+
+func (cr *cborReader) skipNested(depth int) {
+	major, n := cr.head()
+	if cr.err == nil && depth > cborMaxNesting {
+		cr.err = errCBOR
+	}
+	if cr.err != nil {
+		return
+	}
+	switch major {
+	case cborBytes, cborText:
+		if n > uint64(len(cr.data)) {
+			cr.err = errCBOR
+			return
+		}
+		cr.data = cr.data[n:]
+	case cborArray, cborMap:
+		if n > uint64(len(cr.data)) {
+			cr.err = errCBOR
+			return
+		}
+		if major == cborMap {
+			n *= 2
+		}
+		for i := uint64(0); i < n && cr.err == nil; i++ {
+			cr.skipNested(depth + 1)
+		}
+	case 6:
+		// A tag, followed by its content.
+		cr.skipNested(depth + 1)
+	}
+}
+
+// done fails if data remains after the item read.
+func (cr *cborReader) done() error {
+	if cr.err == nil && len(cr.data) != 0 {
+		cr.err = errCBOR
+	}
+	return cr.err
+}
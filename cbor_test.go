@@ -0,0 +1,70 @@
+package smt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestCBOREncoding(t *testing.T) {
+	// Examples of Appendix A of RFC 8949.
+	for n, want := range map[uint64]string{
+		0:             "00",
+		23:            "17",
+		24:            "1818",
+		1000:          "1903e8",
+		1000000:       "1a000f4240",
+		1000000000000: "1b000000e8d4a51000",
+	} {
+		if got := hex.EncodeToString(appendCBORHead(nil, cborUint, n)); got != want {
+			t.Errorf("encoded %d as %s, want %s", n, got, want)
+		}
+		cr := &cborReader{data: appendCBORHead(nil, cborUint, n)}
+		if got := cr.uint(n); cr.done() != nil || got != n {
+			t.Errorf("decoded %d as %d, %v", n, got, cr.err)
+		}
+	}
+	if got := hex.EncodeToString(appendCBORBytes(nil, cborBytes, []byte{1, 2, 3, 4})); got != "4401020304" {
+		t.Errorf("encoded byte string as %s", got)
+	}
+	var mw cborMapWriter
+	mw.text("b", appendCBORHead(appendCBORHead(appendCBORHead(nil, cborArray, 2), cborUint, 2), cborUint, 3))
+	mw.text("a", appendCBORHead(nil, cborUint, 1))
+	if got := hex.EncodeToString(mw.appendTo(nil)); got != "a26161016162820203" {
+		t.Errorf("encoded map as %s", got)
+	}
+}
+
+func TestCBORDecoding(t *testing.T) {
+	for name, data := range map[string]string{
+		"not shortest":      "1817",
+		"not shortest long": "1900ff",
+		"indefinite length": "5f",
+		"truncated head":    "19",
+		"truncated string":  "4401",
+		"trailing data":     "0000",
+	} {
+		b, _ := hex.DecodeString(data)
+		cr := &cborReader{data: b}
+		cr.skip()
+		if cr.done() == nil {
+			t.Errorf("%s: decoded %s", name, data)
+		}
+	}
+
+	// Tags, floats, negative integers and nested items are skipped.
+	b, _ := hex.DecodeString("c11a514b67b0" + "fb3ff199999999999a" + "3903e7" + "a1616181a0" + "42beef")
+	cr := &cborReader{data: b}
+	for i := 0; i < 4; i++ {
+		cr.skip()
+	}
+	if v := cr.bytes(cborBytes); cr.done() != nil || !bytes.Equal(v, []byte{0xbe, 0xef}) {
+		t.Errorf("did not skip items, got %x, %v", v, cr.err)
+	}
+
+	nested := bytes.Repeat([]byte{0x81}, cborMaxNesting+2)
+	cr = &cborReader{data: append(nested, 0)}
+	if cr.skip(); cr.err == nil {
+		t.Error("skipped items nested too deeply")
+	}
+}
@@ -0,0 +1,261 @@
+package smt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"sync"
+)
+
+// ErrChangesCorrupt is returned by ApplyChanges when a changeset is malformed
+// or does not match its checksum.
+var ErrChangesCorrupt = errors.New("changeset is corrupt")
+
+// ErrUnknownMarker is returned by ExportChangesSince for a marker that was not
+// returned by MarkSnapshotPoint, or whose changes were discarded.
+var ErrUnknownMarker = errors.New("unknown snapshot marker")
+
+// changesMagic identifies a changeset exported by ExportChangesSince.
+var changesMagic = []byte("SMTC")
+
+const changesVersion = 1
+
+// Tags of the entries of a changeset.
+const (
+	changeDelete = 0
+	changeSet    = 1 // followed by the value
+)
+
+// Changeset layout, with lengths and counts as uvarints:
+//
+//	magic, one byte of format version
+//	entry count
+//	per entry, in ascending key order: key length, key, tag,
+//	value length, value (if set)
+//	big-endian CRC32 (IEEE) of everything before it
+
+// ChangeTrackingStore is a MapStore that records the keys Set or Deleted in an
+// inner store, such as the node store of a database-backed tree, for
+// incremental backups: after a full backup, MarkSnapshotPoint returns a
+// marker, and ExportChangesSince that marker later returns the keys changed
+// since, with their current values, which ApplyChanges applies on top of the
+// full backup to reconstruct the store. A key changed several times is
+// exported once. One entry is kept per key changed since the oldest marker
+// still needed; see DiscardChangesBefore. To checkpoint the stores of a tree
+// together with its root, see WriteCheckpoint.
+type ChangeTrackingStore struct {
+	store MapStore
+
+	mu sync.Mutex
+	// epoch is the current marker; changes are recorded under it.
+	epoch uint64
+	// oldest is the oldest marker whose changes are still recorded.
+	oldest uint64
+	// changed is the marker at the time of the last change of each key.
+	changed map[string]uint64
+}
+
+// NewChangeTrackingStore creates a ChangeTrackingStore over store, recording
+// changes from marker 0.
+func NewChangeTrackingStore(store MapStore) *ChangeTrackingStore {
+	if store == nil {
+		panic("smt: nil store")
+	}
+	return &ChangeTrackingStore{store: store, changed: make(map[string]uint64)}
+}
+
+// Get gets the value for a key.
+func (cs *ChangeTrackingStore) Get(key []byte) ([]byte, error) {
+	return cs.store.Get(key)
+}
+
+// Set updates the value for a key, and records the change once it is applied.
+func (cs *ChangeTrackingStore) Set(key []byte, value []byte) error {
+	if err := cs.store.Set(key, value); err != nil {
+		return err
+	}
+	cs.record(key)
+	return nil
+}
+
+// Delete deletes a key, and records the change once it is applied.
+func (cs *ChangeTrackingStore) Delete(key []byte) error {
+	if err := cs.store.Delete(key); err != nil {
+		return err
+	}
+	cs.record(key)
+	return nil
+}
+
+// Export exports the inner store, as for a full backup.
+func (cs *ChangeTrackingStore) Export() ([]byte, error) {
+	return cs.store.Export()
+}
+
+// Flush flushes the inner store, if it is a ClosableStore.
+func (cs *ChangeTrackingStore) Flush() error {
+	return flushStore(cs.store)
+}
+
+// Close closes the inner store, if it is a ClosableStore.
+func (cs *ChangeTrackingStore) Close() error {
+	return closeStore(cs.store)
+}
+
+func (cs *ChangeTrackingStore) record(key []byte) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.changed[string(key)] = cs.epoch
+}
+
+// MarkSnapshotPoint returns a new marker, after the changes recorded so far
+// and before any later ones. Take it while the store is not being written,
+// e.g. with the tree's updates blocked, right after a backup, so that the
+// backup holds exactly the changes before it.
+func (cs *ChangeTrackingStore) MarkSnapshotPoint() uint64 {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.epoch++
+	return cs.epoch
+}
+
+// ExportChangesSince returns a changeset of the keys Set or Deleted since
+// marker, with their current values, or a delete for those no longer in the
+// store, to be applied with ApplyChanges. Marker 0 covers every change since
+// the store was created. As the values are read when the changeset is
+// exported, the store should not be written meanwhile.
+func (cs *ChangeTrackingStore) ExportChangesSince(marker uint64) ([]byte, error) {
+	cs.mu.Lock()
+	if marker > cs.epoch || marker < cs.oldest {
+		cs.mu.Unlock()
+		return nil, fmt.Errorf("%w: %d", ErrUnknownMarker, marker)
+	}
+	var keys []string
+	for key, epoch := range cs.changed {
+		if epoch >= marker {
+			keys = append(keys, key)
+		}
+	}
+	cs.mu.Unlock()
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	sw := &streamWriter{w: bufio.NewWriter(&buf)}
+	sw.write(changesMagic)
+	sw.write([]byte{changesVersion})
+	sw.writeUvarint(uint64(len(keys)))
+	for _, key := range keys {
+		value, err := cs.store.Get([]byte(key))
+		if errors.Is(err, ErrKeyNotFound) {
+			sw.writeBytes([]byte(key))
+			sw.write([]byte{changeDelete})
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		sw.writeBytes([]byte(key))
+		sw.write([]byte{changeSet})
+		sw.writeBytes(value)
+	}
+	if sw.err == nil {
+		sw.err = sw.w.Flush()
+	}
+	if sw.err != nil {
+		return nil, sw.err
+	}
+	var trailer [4]byte
+	binary.BigEndian.PutUint32(trailer[:], crc32.ChecksumIEEE(buf.Bytes()))
+	return append(buf.Bytes(), trailer[:]...), nil
+}
+
+// DiscardChangesBefore forgets the changes only needed to export changesets
+// since markers before marker, once backups no longer depend on them, so
+// that the record of changes stays bounded. ExportChangesSince then returns
+// ErrUnknownMarker for those markers.
+func (cs *ChangeTrackingStore) DiscardChangesBefore(marker uint64) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if marker > cs.epoch {
+		marker = cs.epoch
+	}
+	if marker <= cs.oldest {
+		return
+	}
+	for key, epoch := range cs.changed {
+		if epoch < marker {
+			delete(cs.changed, key)
+		}
+	}
+	cs.oldest = marker
+}
+
+// ApplyChanges applies a changeset exported by ExportChangesSince to store,
+// such as one restored from the full backup the changeset's marker was taken
+// after, giving the store the contents the exporting store had. Deletes of
+// keys store does not hold are skipped. The changeset is checked in full
+// before it is applied, but if store fails partway through, it may hold part
+// of it.
+func ApplyChanges(store MapStore, changes []byte) error {
+	if len(changes) < 4 {
+		return ErrChangesCorrupt
+	}
+	body, trailer := changes[:len(changes)-4], changes[len(changes)-4:]
+	if binary.BigEndian.Uint32(trailer) != crc32.ChecksumIEEE(body) {
+		return ErrChangesCorrupt
+	}
+	sr := &streamReader{r: bufio.NewReader(bytes.NewReader(body)), crc: crc32.NewIEEE()}
+	magic := sr.read(len(changesMagic))
+	if sr.err == nil && !bytes.Equal(magic, changesMagic) {
+		return ErrChangesCorrupt
+	}
+	version := sr.read(1)
+	if sr.err == nil && version[0] != changesVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrChangesCorrupt, version[0])
+	}
+	type change struct {
+		key, value []byte
+		tag        byte
+	}
+	var entries []change
+	count := sr.readUvarint()
+	for i := uint64(0); i < count && sr.err == nil; i++ {
+		c := change{key: sr.readBytes(), tag: sr.readTag()}
+		if sr.err != nil {
+			break
+		}
+		switch c.tag {
+		case changeSet:
+			c.value = sr.readBytes()
+		case changeDelete:
+		default:
+			return ErrChangesCorrupt
+		}
+		entries = append(entries, c)
+	}
+	if sr.err != nil {
+		if errors.Is(sr.err, ErrSnapshotCorrupt) {
+			return ErrChangesCorrupt
+		}
+		return sr.err
+	}
+	if _, err := sr.r.ReadByte(); err == nil {
+		return ErrChangesCorrupt
+	}
+
+	for _, c := range entries {
+		if c.tag == changeSet {
+			if err := store.Set(c.key, c.value); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := store.Delete(c.key); err != nil && !errors.Is(err, ErrKeyNotFound) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestChangeTrackingStore(t *testing.T) {
+	nodes := NewChangeTrackingStore(NewSimpleMap())
+	smt := NewSparseMerkleTree(nodes, NewSimpleMap(), sha256.New())
+	for i := 0; i < 50; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+
+	// Take a full backup, then back up the changes since it, twice.
+	full, err := nodes.Export()
+	if err != nil {
+		t.Fatalf("returned error when exporting store: %v", err)
+	}
+	backup, _, err := ImportMerkleMap(full, full)
+	if err != nil {
+		t.Fatalf("returned error when importing backup: %v", err)
+	}
+	marker := nodes.MarkSnapshotPoint()
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 50; i += 3 {
+			key := []byte(fmt.Sprintf("testKey%d", i+round))
+			if i%2 == 0 {
+				smt.Delete(key)
+			} else {
+				smt.Update(key, []byte(fmt.Sprintf("newValue%d", round)))
+			}
+		}
+		changes, err := nodes.ExportChangesSince(marker)
+		if err != nil {
+			t.Fatalf("returned error when exporting changes: %v", err)
+		}
+		marker = nodes.MarkSnapshotPoint()
+		if err := ApplyChanges(backup, changes); err != nil {
+			t.Fatalf("returned error when applying changes: %v", err)
+		}
+
+		want, _ := nodes.Export()
+		got, _ := backup.Export()
+		if !bytes.Equal(got, want) {
+			t.Fatalf("restored store differs after round %d", round)
+		}
+		restored := ImportSparseMerkleTree(backup, smt.values, sha256.New(), smt.Root())
+		for i := 0; i < 50; i++ {
+			key := []byte(fmt.Sprintf("testKey%d", i))
+			want, _ := smt.Get(key)
+			proof, err := restored.Prove(key)
+			if err != nil || !VerifyProof(proof, smt.Root(), key, want, sha256.New()) {
+				t.Errorf("did not prove key %d from restored tree: %v", i, err)
+			}
+		}
+	}
+
+	// Changes since the last marker cover nothing yet.
+	changes, err := nodes.ExportChangesSince(marker)
+	if err != nil {
+		t.Fatalf("returned error when exporting changes: %v", err)
+	}
+	all, _ := nodes.ExportChangesSince(0)
+	if len(changes) >= len(all) {
+		t.Errorf("changes since last marker are %d bytes, all changes %d", len(changes), len(all))
+	}
+
+	if _, err := nodes.ExportChangesSince(marker + 1); !errors.Is(err, ErrUnknownMarker) {
+		t.Errorf("did not return ErrUnknownMarker for a future marker, got %v", err)
+	}
+	nodes.DiscardChangesBefore(marker)
+	if _, err := nodes.ExportChangesSince(0); !errors.Is(err, ErrUnknownMarker) {
+		t.Errorf("did not return ErrUnknownMarker for a discarded marker, got %v", err)
+	}
+	if _, err := nodes.ExportChangesSince(marker); err != nil {
+		t.Errorf("returned error for a kept marker: %v", err)
+	}
+
+	corrupt := append([]byte(nil), all...)
+	corrupt[len(corrupt)/2] ^= 1
+	if err := ApplyChanges(NewSimpleMap(), corrupt); !errors.Is(err, ErrChangesCorrupt) {
+		t.Errorf("did not return ErrChangesCorrupt, got %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package smt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// ErrNotChangeTracked is returned by WriteCheckpoint and CheckpointTo for a
+// tree whose node or value store is not a ChangeTrackingStore.
+var ErrNotChangeTracked = errors.New("tree stores do not track changes")
+
+// checkpointMagic identifies a checkpoint stream written by WriteCheckpoint.
+var checkpointMagic = []byte("SMTK")
+
+const checkpointVersion = 1
+
+// Checkpoint stream layout, with lengths as uvarints:
+//
+//	magic, one byte of format version
+//	root length, root
+//	per store, for nodes, values and keys: changeset length, changeset
+//	(empty for a tree without a key store)
+//	big-endian CRC32 (IEEE) of everything before it
+
+// WriteCheckpoint writes to w an incremental checkpoint of a tree created on
+// ChangeTrackingStores: the current root, and the entries of the stores
+// changed since the previous checkpoint, as changesets of ExportChangesSince.
+// Applied with ApplyCheckpoint, in order, to stores holding the earlier
+// checkpoints, it gives them the tree at the root, so that a large tree can
+// be checkpointed every block by writing only what the block changed. The
+// first checkpoint holds every change since the stores were wrapped, which
+// for a new tree is the whole tree.
+//
+// The checkpoints take over the markers of the stores, which should not also
+// be used directly. Updates are blocked while the checkpoint is written. If
+// writing fails, the changes are kept for the next checkpoint. A key store
+// that does not track changes is left out, while node and value stores that
+// do not return ErrNotChangeTracked.
+func (smt *SparseMerkleTree) WriteCheckpoint(w io.Writer) error {
+	_, err := smt.checkpoint(func(root []byte, changesets [][]byte) error {
+		crc := crc32.NewIEEE()
+		sw := &streamWriter{w: bufio.NewWriter(io.MultiWriter(w, crc))}
+		sw.write(checkpointMagic)
+		sw.write([]byte{checkpointVersion})
+		sw.writeBytes(root)
+		for _, changes := range changesets {
+			sw.writeBytes(changes)
+		}
+		if sw.err != nil {
+			return sw.err
+		}
+		if err := sw.w.Flush(); err != nil {
+			return err
+		}
+		var sum [4]byte
+		binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+		_, err := w.Write(sum[:])
+		return err
+	})
+	return err
+}
+
+// CheckpointTo applies an incremental checkpoint, as written by
+// WriteCheckpoint, directly to the given stores, such as those of a replica
+// on disk, and returns the root it was taken at. keys may be nil.
+func (smt *SparseMerkleTree) CheckpointTo(nodes, values, keys MapStore) ([]byte, error) {
+	checkStores(nodes, values)
+	return smt.checkpoint(func(root []byte, changesets [][]byte) error {
+		return applyChangesets(changesets, nodes, values, keys)
+	})
+}
+
+// checkpoint exports the changes of the stores of the tree since the last
+// checkpoint, and passes them to write with the root. The changes are only
+// discarded once write succeeds.
+func (smt *SparseMerkleTree) checkpoint(write func(root []byte, changesets [][]byte) error) ([]byte, error) {
+	defer smt.writeLock()()
+	stores := []MapStore{smt.nodes, smt.values, smt.keys}
+	tracked := make([]*ChangeTrackingStore, len(stores))
+	changesets := make([][]byte, len(stores))
+	for i, store := range stores {
+		cs, ok := store.(*ChangeTrackingStore)
+		if !ok {
+			if i == keyStore {
+				continue
+			}
+			return nil, fmt.Errorf("%w: %T", ErrNotChangeTracked, store)
+		}
+		cs.mu.Lock()
+		marker := cs.oldest
+		cs.mu.Unlock()
+		changes, err := cs.ExportChangesSince(marker)
+		if err != nil {
+			return nil, err
+		}
+		tracked[i], changesets[i] = cs, changes
+	}
+
+	if err := write(smt.root, changesets); err != nil {
+		return nil, err
+	}
+	for _, cs := range tracked {
+		if cs != nil {
+			cs.DiscardChangesBefore(cs.MarkSnapshotPoint())
+		}
+	}
+	smt.debugf("checkpointed root %x", smt.root)
+	return smt.root, nil
+}
+
+// ApplyCheckpoint reads an incremental checkpoint written by WriteCheckpoint
+// from r, and applies it to the given stores, which must hold the checkpoints
+// before it, returning the root the tree is at in them; import it with
+// ImportSparseMerkleTree. keys may be nil, skipping any key changes. A stream
+// that is malformed returns ErrSnapshotCorrupt, and one whose checksum does
+// not match ErrSnapshotChecksum, before anything is applied; if a store fails
+// partway through, the stores may hold part of the checkpoint.
+func ApplyCheckpoint(r io.Reader, nodes, values, keys MapStore) ([]byte, error) {
+	checkStores(nodes, values)
+	sr := &streamReader{r: bufio.NewReader(r), crc: crc32.NewIEEE()}
+	magic := sr.read(len(checkpointMagic))
+	if sr.err == nil && !bytes.Equal(magic, checkpointMagic) {
+		return nil, ErrSnapshotCorrupt
+	}
+	version := sr.read(1)
+	if sr.err == nil && version[0] != checkpointVersion {
+		return nil, fmt.Errorf("%w: %d", ErrSnapshotVersion, version[0])
+	}
+	root := sr.readBytes()
+	changesets := make([][]byte, keyStore+1)
+	for i := range changesets {
+		changesets[i] = sr.readBytes()
+	}
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	sum := sr.crc.Sum32()
+	trailer := sr.read(4)
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	if binary.BigEndian.Uint32(trailer) != sum {
+		return nil, ErrSnapshotChecksum
+	}
+	if err := applyChangesets(changesets, nodes, values, keys); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// applyChangesets applies the changesets of the node, value and key stores of
+// a checkpoint to the given stores. An empty changeset, or a nil store for
+// keys, is skipped.
+func applyChangesets(changesets [][]byte, nodes, values, keys MapStore) error {
+	for i, store := range []MapStore{nodes, values, keys} {
+		if store == nil || len(changesets[i]) == 0 {
+			continue
+		}
+		if err := ApplyChanges(store, changesets[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCheckpoints(t *testing.T) {
+	smn, smv, smk := NewSimpleMap(), NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(NewChangeTrackingStore(smn), NewChangeTrackingStore(smv), sha256.New(), WithKeyStore(NewChangeTrackingStore(smk)))
+	replica, replicaValues, replicaKeys := NewSimpleMap(), NewSimpleMap(), NewSimpleMap()
+	direct, directValues := NewSimpleMap(), NewSimpleMap()
+
+	var sizes []int
+	for round := 0; round < 3; round++ {
+		// The first checkpoint holds the whole tree, and later ones only the
+		// few keys written since.
+		writes := 150
+		if round > 0 {
+			writes = 10
+		}
+		for i := 0; i < writes; i++ {
+			key := []byte(fmt.Sprintf("key%d", (round*37+i)%150))
+			if i%10 == 9 {
+				smt.Delete(key)
+			} else {
+				smt.Update(key, []byte(fmt.Sprintf("value%d-%d", round, i)))
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := smt.WriteCheckpoint(&buf); err != nil {
+			t.Fatalf("returned error when writing checkpoint: %v", err)
+		}
+		sizes = append(sizes, buf.Len())
+		root, err := ApplyCheckpoint(&buf, replica, replicaValues, replicaKeys)
+		if err != nil {
+			t.Fatalf("returned error when applying checkpoint: %v", err)
+		}
+		if !bytes.Equal(root, smt.Root()) {
+			t.Error("checkpoint root does not match the root of the tree")
+		}
+		if !simpleMapsEqual(replica, smn) || !simpleMapsEqual(replicaValues, smv) || !simpleMapsEqual(replicaKeys, smk) {
+			t.Errorf("stores with checkpoints %d applied differ from the stores of the tree", round)
+		}
+		imported := ImportSparseMerkleTree(replica, replicaValues, sha256.New(), root)
+		for i := 0; i < 150; i++ {
+			key := []byte(fmt.Sprintf("key%d", i))
+			want, _ := smt.Get(key)
+			if got, err := imported.Get(key); err != nil || !bytes.Equal(got, want) {
+				t.Errorf("replica has %q, %v for %s, want %q", got, err, key, want)
+			}
+		}
+
+		// The same checkpoints applied directly to stores; the changes of
+		// the previous checkpoint are already taken.
+		if round == 0 {
+			if root, err := smt.CheckpointTo(direct, directValues, nil); err != nil || !bytes.Equal(root, smt.Root()) {
+				t.Fatalf("got %x, %v when checkpointing to stores", root, err)
+			}
+			if len(direct.m) != 0 {
+				t.Error("checkpoint with no changes wrote nodes")
+			}
+		}
+	}
+	if sizes[2] >= sizes[0]/2 {
+		t.Errorf("incremental checkpoint of %d bytes is not much smaller than the first of %d", sizes[2], sizes[0])
+	}
+}
+
+func TestCheckpointWriteFailure(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(NewChangeTrackingStore(smn), NewChangeTrackingStore(smv), sha256.New())
+	smt.Update([]byte("foo"), []byte("bar"))
+	if err := smt.WriteCheckpoint(failingWriter{}); err == nil {
+		t.Fatal("did not return error when the checkpoint failed to write")
+	}
+	// The changes of the failed checkpoint go into the next one.
+	nodes, values := NewSimpleMap(), NewSimpleMap()
+	root, err := smt.CheckpointTo(nodes, values, nil)
+	if err != nil {
+		t.Fatalf("returned error when checkpointing: %v", err)
+	}
+	if !simpleMapsEqual(nodes, smn) || !simpleMapsEqual(values, smv) {
+		t.Error("changes of a failed checkpoint were lost")
+	}
+	if got, _ := ImportSparseMerkleTree(nodes, values, sha256.New(), root).Get([]byte("foo")); !bytes.Equal(got, []byte("bar")) {
+		t.Errorf("got %q from the checkpointed tree", got)
+	}
+
+	untracked := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	if err := untracked.WriteCheckpoint(&bytes.Buffer{}); !errors.Is(err, ErrNotChangeTracked) {
+		t.Errorf("got %v checkpointing a tree on untracked stores, want ErrNotChangeTracked", err)
+	}
+}
+
+func TestApplyCheckpointCorrupt(t *testing.T) {
+	smt := NewSparseMerkleTree(NewChangeTrackingStore(NewSimpleMap()), NewChangeTrackingStore(NewSimpleMap()), sha256.New())
+	smt.Update([]byte("foo"), []byte("bar"))
+	var buf bytes.Buffer
+	if err := smt.WriteCheckpoint(&buf); err != nil {
+		t.Fatalf("returned error when writing checkpoint: %v", err)
+	}
+	data := buf.Bytes()
+
+	flipped := append([]byte{}, data...)
+	flipped[len(flipped)/2] ^= 1
+	nodes := NewSimpleMap()
+	if _, err := ApplyCheckpoint(bytes.NewReader(flipped), nodes, NewSimpleMap(), nil); err == nil {
+		t.Error("did not return error for a corrupt checkpoint")
+	}
+	if len(nodes.m) != 0 {
+		t.Error("corrupt checkpoint was applied")
+	}
+	if _, err := ApplyCheckpoint(bytes.NewReader(data[:len(data)-3]), NewSimpleMap(), NewSimpleMap(), nil); !errors.Is(err, ErrSnapshotCorrupt) {
+		t.Errorf("got %v for a truncated checkpoint, want ErrSnapshotCorrupt", err)
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+// simpleMapsEqual returns true if a and b hold the same entries.
+func simpleMapsEqual(a, b *SimpleMap) bool {
+	if len(a.m) != len(b.m) {
+		return false
+	}
+	for key, value := range a.m {
+		if other, ok := b.m[key]; !ok || !bytes.Equal(value, other) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,47 @@
+package smt
+
+// Clear empties the tree, deleting the nodes under the current root and the
+// values and raw keys of its leaves from the stores, and sets the root to the
+// empty root. It takes the tree's write lock like any other update, so that an
+// ExportTrie, WriteSnapshot or other read of the whole tree running alongside
+// it sees either the tree before the Clear or the empty tree, never a partly
+// cleared one. With WithRootRetention, the removed nodes and values are kept
+// until the root before the Clear expires.
+func (smt *SparseMerkleTree) Clear() error {
+	_, err := smt.changeRoot(func(root []byte) ([]byte, error) {
+		if smt.sealed {
+			return nil, ErrSealed
+		}
+		var hashes, paths [][]byte
+		err := smt.walk(root, func(_ []bool, hash []byte, data []byte) error {
+			hashes = append(hashes, hash)
+			if smt.th.isLeaf(data) {
+				path, _ := smt.th.parseLeaf(data)
+				paths = append(paths, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, hash := range hashes {
+			if err := smt.pruneNode(hash); err != nil {
+				return nil, err
+			}
+		}
+		for _, path := range paths {
+			if err := smt.deleteValue(path); err != nil {
+				return nil, err
+			}
+			if err := smt.deleteKey(path); err != nil {
+				return nil, err
+			}
+			if err := smt.bumpKeyVersion(path); err != nil {
+				return nil, err
+			}
+		}
+		smt.debugf("cleared %d nodes and %d leaves under root %x", len(hashes), len(paths), root)
+		return smt.th.placeholder(), nil
+	})
+	return err
+}
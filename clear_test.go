@@ -0,0 +1,79 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestClear(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+	emptyRoot := smt.Root()
+	for i := 0; i < 20; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	if err := smt.Clear(); err != nil {
+		t.Fatalf("returned error when clearing tree: %v", err)
+	}
+	if !bytes.Equal(smt.Root(), emptyRoot) {
+		t.Error("cleared tree does not have the empty root")
+	}
+	if len(smn.m) != 0 || len(smv.m) != 0 {
+		t.Errorf("cleared tree left %d nodes and %d values in the stores", len(smn.m), len(smv.m))
+	}
+	if value, _ := smt.Get([]byte("testKey1")); !bytes.Equal(value, defaultValue) {
+		t.Error("cleared tree still has a value")
+	}
+
+	smt.Seal()
+	if err := smt.Clear(); err != ErrSealed {
+		t.Errorf("did not return ErrSealed when clearing sealed tree, got %v", err)
+	}
+}
+
+// Test that an export running alongside a Clear sees the whole tree or none of
+// it. Run with -race.
+func TestClearConcurrentExport(t *testing.T) {
+	for trial := 0; trial < 20; trial++ {
+		trie := NewMerkleTrie()
+		for i := 0; i < 50; i++ {
+			trie.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+		}
+		fullRoot := trie.Root()
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			trie.Clear()
+		}()
+		wrap, err := ExportTrie(trie)
+		wg.Wait()
+		if err != nil {
+			t.Fatalf("returned error when exporting trie: %v", err)
+		}
+
+		imported, err := ImportTrie(wrap)
+		if err != nil {
+			t.Fatalf("returned error when importing trie: %v", err)
+		}
+		if !bytes.Equal(imported.Root(), fullRoot) {
+			if _, leaves, _ := imported.NodeCount(); leaves != 0 {
+				t.Fatalf("export of cleared trie has %d leaves", leaves)
+			}
+			continue
+		}
+		for i := 0; i < 50; i++ {
+			value, err := imported.Get([]byte(fmt.Sprintf("testKey%d", i)))
+			if err != nil || !bytes.Equal(value, []byte(fmt.Sprintf("testValue%d", i))) {
+				t.Fatalf("export of full trie is missing key %d: %v", i, err)
+			}
+		}
+		if _, err := imported.Prove([]byte("testKey0")); err != nil {
+			t.Fatalf("export of full trie is missing nodes: %v", err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package smt
+
+import (
+	"errors"
+	"sync"
+)
+
+// errCloneExport is returned by the Export of the stores of a clone.
+var errCloneExport = errors.New("cannot export the stores of a clone")
+
+// Clone returns a writable copy of the tree at its current root, for
+// speculative updates, e.g. executing a block that may be discarded, while
+// the tree keeps serving reads and updates of its own. It is cheap to take,
+// as nothing is copied up front: the clone reads the tree's stores through a
+// Snapshot, and keeps its own writes in memory, so the updates of either are
+// not seen by the other. The clone is configured like the tree, but does not
+// track key versions.
+//
+// The memory held by the clone grows with its own writes and, as for a
+// snapshot, with the entries the tree's updates replace; Close the clone to
+// release its snapshot once it is no longer needed. The stores of a clone
+// cannot be exported.
+func (smt *SparseMerkleTree) Clone() *SparseMerkleTree {
+	s := smt.Snapshot()
+	var release sync.Once
+	overlay := func(index int) MapStore {
+		if s.stores[index] == nil {
+			return nil
+		}
+		return &cloneStore{base: s.stores[index], entries: make(map[string][]byte), release: func() {
+			release.Do(s.Release)
+		}}
+	}
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	clone := smt.configuredCopy(overlay(nodeStore), overlay(valueStore), overlay(keyStore), nil)
+	clone.root = s.Root()
+	return clone
+}
+
+// cloneStore is a store of a clone: the writes of the clone, over the store
+// of its snapshot.
+type cloneStore struct {
+	base MapStore
+	// release releases the snapshot of the clone.
+	release func()
+
+	mu sync.RWMutex
+	// entries are the writes of the clone, nil for deletions.
+	entries map[string][]byte
+}
+
+func (cs *cloneStore) Get(key []byte) ([]byte, error) {
+	cs.mu.RLock()
+	value, ok := cs.entries[string(key)]
+	cs.mu.RUnlock()
+	if !ok {
+		return cs.base.Get(key)
+	}
+	if value == nil {
+		return nil, &InvalidKeyError{Key: key}
+	}
+	return value, nil
+}
+
+func (cs *cloneStore) Set(key []byte, value []byte) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if value == nil {
+		value = []byte{}
+	}
+	cs.entries[string(key)] = value
+	return nil
+}
+
+func (cs *cloneStore) Delete(key []byte) error {
+	if _, err := cs.Get(key); err != nil {
+		return err
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.entries[string(key)] = nil
+	return nil
+}
+
+func (cs *cloneStore) Export() ([]byte, error) {
+	return nil, errCloneExport
+}
+
+// Flush does nothing, as the writes of a clone are only held in memory.
+func (cs *cloneStore) Flush() error {
+	return nil
+}
+
+// Close releases the writes of the clone and its snapshot.
+func (cs *cloneStore) Close() error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.entries = nil
+	cs.release()
+	return nil
+}
@@ -0,0 +1,73 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func TestClone(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithKeyStore(NewSimpleMap()))
+	reference := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 50; i++ {
+		key, value := []byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i))
+		smt.Update(key, value)
+		reference.Update(key, value)
+	}
+	root := smt.Root()
+	clone := smt.Clone()
+
+	// The clone and the tree are updated independently.
+	for i := 0; i < 50; i += 3 {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		if i%2 == 0 {
+			if _, err := clone.Delete(key); err != nil {
+				t.Fatalf("returned error when deleting from clone: %v", err)
+			}
+			reference.Delete(key)
+		} else {
+			if _, err := clone.Update(key, []byte("cloneValue")); err != nil {
+				t.Fatalf("returned error when updating clone: %v", err)
+			}
+			reference.Update(key, []byte("cloneValue"))
+		}
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i+1)), []byte("treeValue"))
+	}
+	smt.Delete([]byte("testKey2"))
+
+	if !bytes.Equal(clone.Root(), reference.Root()) {
+		t.Error("clone has a different root")
+	}
+	for i := 0; i < 50; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		want, _ := reference.Get(key)
+		got, err := clone.Get(key)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("clone got %s for key %d, expected %s: %v", got, i, want, err)
+		}
+		proof, err := clone.Prove(key)
+		if err != nil || !VerifyProof(proof, clone.Root(), key, want, sha256.New()) {
+			t.Errorf("did not prove key %d from clone: %v", i, err)
+		}
+	}
+	if value, _ := smt.Get([]byte("testKey3")); !bytes.Equal(value, []byte("testValue3")) {
+		t.Errorf("tree sees the clone's value %s", value)
+	}
+	if value, _ := smt.Get([]byte("testKey1")); !bytes.Equal(value, []byte("treeValue")) {
+		t.Errorf("tree lost its own value, got %s", value)
+	}
+	if bytes.Equal(smt.Root(), root) || bytes.Equal(smt.Root(), clone.Root()) {
+		t.Error("tree did not move on independently of the clone")
+	}
+
+	if err := clone.Close(); err != nil {
+		t.Errorf("returned error when closing clone: %v", err)
+	}
+	if len(smt.snapshots) != 0 {
+		t.Error("closing clone did not release its snapshot")
+	}
+	if _, err := ExportTrie(clone); err == nil {
+		t.Error("exported clone")
+	}
+}
@@ -0,0 +1,79 @@
+package smt
+
+// ClosableStore is a MapStore with a lifecycle, such as a database-backed
+// store that buffers writes or holds connections. The tree and the stores
+// wrapping other stores call these methods, when the stores implement them,
+// from their own Flush and Close.
+type ClosableStore interface {
+	MapStore
+	// Flush durably writes any buffered writes.
+	Flush() error
+	// Close flushes the store and releases its resources. The store must not
+	// be used afterwards.
+	Close() error
+}
+
+// flushStore flushes store if it is a ClosableStore.
+func flushStore(store MapStore) error {
+	if cs, ok := store.(ClosableStore); ok {
+		return cs.Flush()
+	}
+	return nil
+}
+
+// closeStore closes store if it is a ClosableStore.
+func closeStore(store MapStore) error {
+	if cs, ok := store.(ClosableStore); ok {
+		return cs.Close()
+	}
+	return nil
+}
+
+// Flush flushes the stores of the tree, e.g. at the end of a transaction so
+// that the updates made in it are durable. Updates are blocked while the
+// stores are flushed, so that no update is flushed in part.
+func (smt *SparseMerkleTree) Flush() error {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+	for _, store := range smt.lifecycleStores() {
+		if err := flushStore(store); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close flushes and closes the stores of the tree, even once one fails,
+// returning the first error. The tree must not be used afterwards.
+func (smt *SparseMerkleTree) Close() error {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+	var first error
+	for _, store := range smt.lifecycleStores() {
+		if err := closeStore(store); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// lifecycleStores returns the distinct stores of the tree, including its
+// write-ahead log, as a tree may keep its nodes and values in one store.
+func (smt *SparseMerkleTree) lifecycleStores() []MapStore {
+	var stores []MapStore
+	for _, store := range []MapStore{smt.nodes, smt.values, smt.keys, smt.versions, smt.wal} {
+		if store == nil {
+			continue
+		}
+		seen := false
+		for _, s := range stores {
+			if s == store {
+				seen = true
+			}
+		}
+		if !seen {
+			stores = append(stores, store)
+		}
+	}
+	return stores
+}
@@ -0,0 +1,57 @@
+package smt
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+)
+
+// lifecycleStore is a SimpleMap that counts its flushes and closes.
+type lifecycleStore struct {
+	*SimpleMap
+	flushes, closes int
+	closeErr        error
+}
+
+func (ls *lifecycleStore) Flush() error {
+	ls.flushes++
+	return nil
+}
+
+func (ls *lifecycleStore) Close() error {
+	ls.closes++
+	return ls.closeErr
+}
+
+func TestTreeFlushClose(t *testing.T) {
+	shared := &lifecycleStore{SimpleMap: NewSimpleMap()}
+	keys := &lifecycleStore{SimpleMap: NewSimpleMap()}
+	// The value store is an audit store over the node store, so the node store
+	// is reached once directly and once through it.
+	smt := NewSparseMerkleTree(shared, NewAuditStore(shared, io.Discard), sha256.New(), WithKeyStore(keys))
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	if err := smt.Flush(); err != nil {
+		t.Fatalf("returned error when flushing: %v", err)
+	}
+	if shared.flushes != 2 || keys.flushes != 1 {
+		t.Errorf("flushed shared store %d times and key store %d times", shared.flushes, keys.flushes)
+	}
+
+	keys.closeErr = errors.New("close failed")
+	if err := smt.Close(); err != keys.closeErr {
+		t.Errorf("did not return error of failed close, got %v", err)
+	}
+	if shared.closes != 2 || keys.closes != 1 {
+		t.Errorf("closed shared store %d times and key store %d times", shared.closes, keys.closes)
+	}
+
+	// Stores without a lifecycle are left alone.
+	plain := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	if err := plain.Flush(); err != nil {
+		t.Errorf("returned error when flushing SimpleMaps: %v", err)
+	}
+	if err := plain.Close(); err != nil {
+		t.Errorf("returned error when closing SimpleMaps: %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/causevest/smt"
+)
+
+func (c *cli) root(args []string) error {
+	return c.withTree(func(tree *smt.SparseMerkleTree) error {
+		fmt.Fprintln(c.stdout, hex.EncodeToString(tree.Root()))
+		return nil
+	})
+}
+
+func (c *cli) get(args []string) error {
+	key, err := c.bytesArg(args[0])
+	if err != nil {
+		return err
+	}
+	return c.withTree(func(tree *smt.SparseMerkleTree) error {
+		value, err := tree.Get(key)
+		if err != nil {
+			return err
+		}
+		c.printBytes(value)
+		return nil
+	})
+}
+
+func (c *cli) prove(args []string) error {
+	key, err := c.bytesArg(args[0])
+	if err != nil {
+		return err
+	}
+	return c.withTree(func(tree *smt.SparseMerkleTree) error {
+		proof, err := tree.Prove(key)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(c.stdout, hex.EncodeToString(proof.ToProto()))
+		return nil
+	})
+}
+
+func (c *cli) verify(args []string) error {
+	key, err := c.bytesArg(args[0])
+	if err != nil {
+		return err
+	}
+	value, err := c.bytesArg(args[1])
+	if err != nil {
+		return err
+	}
+	data, err := hex.DecodeString(args[2])
+	if err != nil {
+		return fmt.Errorf("proof: %w", err)
+	}
+	var proof smt.SparseMerkleProof
+	if err := proof.FromProto(data); err != nil {
+		return fmt.Errorf("proof: %w", err)
+	}
+
+	var root []byte
+	if c.triePath == "" && c.storeSpec == "" {
+		if root, err = c.rootFlag(); err != nil {
+			return err
+		}
+		if root == nil {
+			return errors.New("give -trie, -store or -root")
+		}
+	} else if err := c.withTree(func(tree *smt.SparseMerkleTree) error {
+		root = tree.Root()
+		return nil
+	}); err != nil {
+		return err
+	}
+	hashName, options := c.hashName, []smt.VerifyOption(nil)
+	if c.wrap != nil {
+		hashName = c.wrap.Hash
+		if c.wrap.SchemaVersion < 2 {
+			hashName = smt.HashSHA3_256
+		}
+		if c.wrap.Depth != 0 {
+			options = append(options, smt.WithVerifyDepth(c.wrap.Depth))
+		}
+	}
+	hasher, err := smt.NewHash(hashName)
+	if err != nil {
+		return err
+	}
+	if !smt.VerifyProof(proof, root, key, value, hasher, options...) {
+		return fmt.Errorf("proof does not verify against root %x", root)
+	}
+	fmt.Fprintln(c.stdout, "ok")
+	return nil
+}
+
+func (c *cli) stats(args []string) error {
+	return c.withTree(func(tree *smt.SparseMerkleTree) error {
+		stats, err := tree.Stats()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout, "root           %x\n", tree.Root())
+		fmt.Fprintf(c.stdout, "leaves         %d\n", stats.Leaves)
+		fmt.Fprintf(c.stdout, "branches       %d\n", stats.Branches)
+		fmt.Fprintf(c.stdout, "max depth      %d\n", stats.MaxDepth)
+		fmt.Fprintf(c.stdout, "mean depth     %.2f\n", stats.MeanDepth)
+		fmt.Fprintf(c.stdout, "stored nodes   %d (%d bytes)\n", stats.StoredNodes, stats.NodeBytes)
+		fmt.Fprintf(c.stdout, "stored values  %d (%d bytes)\n", stats.StoredValues, stats.ValueBytes)
+		return nil
+	})
+}
+
+func (c *cli) export(args []string) error {
+	return c.withTree(c.writeTrie)
+}
+
+// writeTrie writes tree as an exported trie in -format, to -o or standard
+// output.
+func (c *cli) writeTrie(tree *smt.SparseMerkleTree) error {
+	var format smt.Format
+	for format = smt.FormatGob; format <= smt.FormatCanonical; format++ {
+		if format.String() == c.formatName {
+			break
+		}
+	}
+	if format > smt.FormatCanonical {
+		return fmt.Errorf("unknown format %q", c.formatName)
+	}
+	wrap, err := smt.ExportTrie(tree)
+	if err != nil {
+		return err
+	}
+	data, err := smt.EncodeTrieWrap(wrap, format)
+	if err != nil {
+		return err
+	}
+	if c.out == "" {
+		_, err = c.stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(c.out, data, 0644)
+}
+
+func (c *cli) importTrie(args []string) error {
+	if c.triePath == "" || c.storeSpec == "" {
+		return errors.New("import needs -trie and -store")
+	}
+	if _, err := c.openTrie(); err != nil {
+		return err
+	}
+	nodes, values, err := smt.ImportMerkleMap(c.wrap.NodesBytes, c.wrap.ValuesBytes)
+	if err != nil {
+		return err
+	}
+	storeNodes, storeValues, err := openStore(c.storeSpec)
+	if err != nil {
+		return err
+	}
+	err = copyStore(storeNodes, nodes)
+	if err == nil {
+		err = copyStore(storeValues, values)
+	}
+	if closeErr := closeStores(storeNodes, storeValues); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.stdout, hex.EncodeToString(c.wrap.Root))
+	return nil
+}
+
+// copyStore sets the entries of from in to.
+func copyStore(to smt.MapStore, from *smt.SimpleMap) error {
+	var buf bytes.Buffer
+	if err := from.ExportTo(&buf); err != nil {
+		return err
+	}
+	_, err := smt.ImportStoreFrom(&buf, to)
+	return err
+}
+
+func (c *cli) prune(args []string) error {
+	return c.withTree(func(tree *smt.SparseMerkleTree) error {
+		n, err := tree.Prune(nil)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout, "pruned %d nodes\n", n)
+		if c.triePath != "" && c.out != "" {
+			return c.writeTrie(tree)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,18 @@
+module github.com/causevest/smt/cmd/smt
+
+go 1.18
+
+require (
+	github.com/causevest/smt v0.0.0-00010101000000-000000000000
+	github.com/causevest/smt/boltstore v0.0.0-00010101000000-000000000000
+)
+
+require (
+	go.etcd.io/bbolt v1.3.7 // indirect
+	golang.org/x/crypto v0.2.0 // indirect
+	golang.org/x/sys v0.4.0 // indirect
+)
+
+replace github.com/causevest/smt => ../..
+
+replace github.com/causevest/smt/boltstore => ../../boltstore
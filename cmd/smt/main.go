@@ -0,0 +1,262 @@
+// Command smt inspects and manipulates the trees of package
+// github.com/causevest/smt, exported as tries or kept in persistent stores,
+// e.g. to debug a checkpoint without writing a program for it.
+//
+// Usage:
+//
+//	smt [flags] command [arguments]
+//
+// The tree is loaded from -trie, a file holding a trie exported with
+// smt.ExportTrie in any smt.Format, or from -store, a persistent store, at
+// -root. Stores are given as kind:path, where kind is bolt, for a bbolt
+// database file, or file or mmap, for a directory holding the nodes and
+// values subdirectories of a FileStore or MmapStore of each. The commands are:
+//
+//	root                    print the root of the tree
+//	get key                 print the value of key
+//	prove key               print a proof of key, in hex of the
+//	                        SparseMerkleProof message of proto/smt.proto
+//	verify key value proof  check that a proof printed by prove verifies for
+//	                        key and value, the empty value for an absent key,
+//	                        against the root of the tree, or -root without one
+//	stats                   print statistics of the tree
+//	export                  write the tree as an exported trie, in -format
+//	import                  copy the trie of -trie into -store, after checking
+//	                        its nodes and values against its root
+//	prune                   delete the nodes no longer under the root, and
+//	                        write the tree as export does to -o if it is from
+//	                        -trie
+//
+// Keys and values are given and printed as text, or in hex with -hex.
+// Exported tries are written to standard output unless -o is given.
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/causevest/smt"
+	"github.com/causevest/smt/boltstore"
+	"github.com/causevest/smt/mmapstore"
+)
+
+// errUsage is returned for a command line that is not valid, once the usage
+// has been printed.
+var errUsage = errors.New("invalid usage")
+
+// command is a subcommand, taking args arguments.
+type command struct {
+	args int
+	run  func(c *cli, args []string) error
+}
+
+var commands = map[string]command{
+	"root":   {0, (*cli).root},
+	"get":    {1, (*cli).get},
+	"prove":  {1, (*cli).prove},
+	"verify": {3, (*cli).verify},
+	"stats":  {0, (*cli).stats},
+	"export": {0, (*cli).export},
+	"import": {0, (*cli).importTrie},
+	"prune":  {0, (*cli).prune},
+}
+
+// cli holds the flags of a command line.
+type cli struct {
+	triePath, storeSpec, rootHex string
+	hashName, formatName, out    string
+	hex                          bool
+	stdout                       io.Writer
+
+	// wrap is the trie loaded from triePath, if any.
+	wrap *smt.TrieWrap
+}
+
+func main() {
+	err := run(os.Args[1:], os.Stdout, os.Stderr)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "smt:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	c := &cli{stdout: stdout}
+	fs := flag.NewFlagSet("smt", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&c.triePath, "trie", "", "load the tree from the exported trie in `file`")
+	fs.StringVar(&c.storeSpec, "store", "", "load the tree from the persistent store `kind:path`")
+	fs.StringVar(&c.rootHex, "root", "", "the `root` of the tree in -store, in hex")
+	fs.StringVar(&c.hashName, "hash", smt.HashSHA256, "the hash `algorithm` of the tree in -store")
+	fs.StringVar(&c.formatName, "format", smt.FormatCanonical.String(), "the `format` of exported tries written: gob, json or canonical")
+	fs.StringVar(&c.out, "o", "", "write exported tries to `file`")
+	fs.BoolVar(&c.hex, "hex", false, "give and print keys and values in hex")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: smt [flags] root|get key|prove key|verify key value proof|stats|export|import|prune")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok || fs.NArg()-1 != cmd.args {
+		fs.Usage()
+		return errUsage
+	}
+	return cmd.run(c, fs.Args()[1:])
+}
+
+// open loads the tree of -trie or -store, returning it with the function
+// closing it.
+func (c *cli) open() (*smt.SparseMerkleTree, func() error, error) {
+	switch {
+	case c.triePath != "" && c.storeSpec != "":
+		return nil, nil, errors.New("give one of -trie and -store")
+	case c.triePath != "":
+		tree, err := c.openTrie()
+		return tree, func() error { return nil }, err
+	case c.storeSpec != "":
+		root, err := c.rootFlag()
+		if err != nil {
+			return nil, nil, err
+		}
+		if root == nil {
+			return nil, nil, errors.New("-root is required with -store")
+		}
+		nodes, values, err := openStore(c.storeSpec)
+		if err != nil {
+			return nil, nil, err
+		}
+		hasher, err := smt.NewHash(c.hashName)
+		if err != nil {
+			closeStores(nodes, values)
+			return nil, nil, err
+		}
+		tree, err := smt.AttachTree(nodes, values, root, hasher)
+		if err != nil {
+			closeStores(nodes, values)
+			return nil, nil, err
+		}
+		return tree, tree.Close, nil
+	}
+	return nil, nil, errors.New("give -trie or -store")
+}
+
+// openTrie imports the trie of -trie.
+func (c *cli) openTrie() (*smt.SparseMerkleTree, error) {
+	data, err := ioutil.ReadFile(c.triePath)
+	if err != nil {
+		return nil, err
+	}
+	format := smt.FormatGob
+	switch {
+	case bytes.HasPrefix(data, []byte("SMTW")):
+		format = smt.FormatCanonical
+	case bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")):
+		format = smt.FormatJSON
+	}
+	if c.wrap, err = smt.DecodeTrieWrap(data, format); err != nil {
+		return nil, fmt.Errorf("decoding %s as %v: %w", c.triePath, format, err)
+	}
+	return smt.ImportTrie(c.wrap, smt.WithImportVerification())
+}
+
+// openStore opens the node and value stores of spec.
+func openStore(spec string) (nodes, values smt.MapStore, err error) {
+	kind, path, ok := strings.Cut(spec, ":")
+	if !ok || path == "" {
+		return nil, nil, fmt.Errorf("store %q is not kind:path", spec)
+	}
+	switch kind {
+	case "bolt":
+		return boltstore.OpenBoltStores(path, nil)
+	case "file":
+		if nodes, err = smt.NewFileStore(filepath.Join(path, "nodes")); err != nil {
+			return nil, nil, err
+		}
+		values, err = smt.NewFileStore(filepath.Join(path, "values"))
+		return nodes, values, err
+	case "mmap":
+		n, err := mmapstore.NewMmapStore(filepath.Join(path, "nodes"))
+		if err != nil {
+			return nil, nil, err
+		}
+		v, err := mmapstore.NewMmapStore(filepath.Join(path, "values"))
+		if err != nil {
+			n.Close()
+			return nil, nil, err
+		}
+		return n, v, nil
+	}
+	return nil, nil, fmt.Errorf("unknown store kind %q", kind)
+}
+
+// closeStores closes the stores that are closable.
+func closeStores(stores ...smt.MapStore) error {
+	var first error
+	for _, store := range stores {
+		if cs, ok := store.(smt.ClosableStore); ok {
+			if err := cs.Close(); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// rootFlag returns the root given with -root, or nil if none is given.
+func (c *cli) rootFlag() ([]byte, error) {
+	if c.rootHex == "" {
+		return nil, nil
+	}
+	root, err := hex.DecodeString(c.rootHex)
+	if err != nil {
+		return nil, fmt.Errorf("-root: %w", err)
+	}
+	return root, nil
+}
+
+// bytesArg decodes a key or value given on the command line.
+func (c *cli) bytesArg(arg string) ([]byte, error) {
+	if !c.hex {
+		return []byte(arg), nil
+	}
+	return hex.DecodeString(arg)
+}
+
+// printBytes prints a key or value.
+func (c *cli) printBytes(b []byte) {
+	if c.hex {
+		fmt.Fprintln(c.stdout, hex.EncodeToString(b))
+	} else {
+		fmt.Fprintln(c.stdout, string(b))
+	}
+}
+
+// withTree runs f on the tree of -trie or -store, closing it afterwards.
+func (c *cli) withTree(f func(tree *smt.SparseMerkleTree) error) error {
+	tree, closeTree, err := c.open()
+	if err != nil {
+		return err
+	}
+	err = f(tree)
+	if closeErr := closeTree(); err == nil {
+		err = closeErr
+	}
+	return err
+}
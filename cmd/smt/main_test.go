@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/causevest/smt"
+)
+
+// writeTrie exports a tree of a few keys to a file, along with the stale
+// nodes of another tree, returning its path and the tree.
+func writeTrie(t *testing.T) (string, *smt.SparseMerkleTree) {
+	t.Helper()
+	nodes, values := smt.NewSimpleMap(), smt.NewSimpleMap()
+	tree := smt.NewSparseMerkleTree(nodes, values, sha256.New())
+	tree.Update([]byte("foo"), []byte("bar"))
+	tree.Update([]byte("baz"), []byte("qux"))
+	stale := smt.NewSparseMerkleTree(nodes, values, sha256.New())
+	stale.Update([]byte("gone"), []byte("soon"))
+	wrap, err := smt.ExportTrie(tree)
+	if err != nil {
+		t.Fatalf("returned error when exporting: %v", err)
+	}
+	data, err := smt.EncodeTrieWrap(wrap, smt.FormatCanonical)
+	if err != nil {
+		t.Fatalf("returned error when encoding: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "trie")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, tree
+}
+
+// runCLI runs a command line, returning its output.
+func runCLI(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	err := run(args, &stdout, &stderr)
+	return stdout.String(), err
+}
+
+func TestCLI(t *testing.T) {
+	path, tree := writeTrie(t)
+	root := hex.EncodeToString(tree.Root())
+
+	if out, err := runCLI(t, "-trie", path, "root"); err != nil || strings.TrimSpace(out) != root {
+		t.Errorf("root printed %q, %v", out, err)
+	}
+	if out, err := runCLI(t, "-trie", path, "get", "foo"); err != nil || out != "bar\n" {
+		t.Errorf("get printed %q, %v", out, err)
+	}
+	if out, err := runCLI(t, "-trie", path, "-hex", "get", hex.EncodeToString([]byte("baz"))); err != nil || out != hex.EncodeToString([]byte("qux"))+"\n" {
+		t.Errorf("get -hex printed %q, %v", out, err)
+	}
+
+	proof, err := runCLI(t, "-trie", path, "prove", "foo")
+	if err != nil {
+		t.Fatalf("returned error when proving: %v", err)
+	}
+	proof = strings.TrimSpace(proof)
+	if out, err := runCLI(t, "-trie", path, "verify", "foo", "bar", proof); err != nil || out != "ok\n" {
+		t.Errorf("verify printed %q, %v", out, err)
+	}
+	if _, err := runCLI(t, "-root", root, "verify", "foo", "bar", proof); err != nil {
+		t.Errorf("returned error verifying against -root: %v", err)
+	}
+	if _, err := runCLI(t, "-trie", path, "verify", "foo", "other", proof); err == nil {
+		t.Error("proof verified for another value")
+	}
+	absent, _ := runCLI(t, "-trie", path, "prove", "gone")
+	if _, err := runCLI(t, "-trie", path, "verify", "gone", "", strings.TrimSpace(absent)); err != nil {
+		t.Errorf("returned error verifying an absent key: %v", err)
+	}
+
+	out, err := runCLI(t, "-trie", path, "stats")
+	if err != nil || !strings.Contains(out, "leaves         2\n") {
+		t.Errorf("stats printed %q, %v", out, err)
+	}
+
+	out, err = runCLI(t, "-trie", path, "-format", "json", "export")
+	if err != nil {
+		t.Fatalf("returned error when exporting: %v", err)
+	}
+	if wrap, err := smt.DecodeTrieWrap([]byte(out), smt.FormatJSON); err != nil || !bytes.Equal(wrap.Root, tree.Root()) {
+		t.Errorf("export wrote a trie that decodes to %v, %v", wrap, err)
+	}
+	if _, err := runCLI(t, "-trie", path, "-format", "xml", "export"); err == nil {
+		t.Error("exported in an unknown format")
+	}
+
+	pruned := filepath.Join(t.TempDir(), "pruned")
+	if out, err := runCLI(t, "-trie", path, "-o", pruned, "prune"); err != nil || out == "pruned 0 nodes\n" {
+		t.Errorf("prune printed %q, %v", out, err)
+	}
+	if out, err := runCLI(t, "-trie", pruned, "prune"); err != nil || out != "pruned 0 nodes\n" {
+		t.Errorf("prune of a pruned trie printed %q, %v", out, err)
+	}
+	if out, err := runCLI(t, "-trie", pruned, "get", "foo"); err != nil || out != "bar\n" {
+		t.Errorf("get from the pruned trie printed %q, %v", out, err)
+	}
+}
+
+func TestCLIImport(t *testing.T) {
+	path, tree := writeTrie(t)
+	root := hex.EncodeToString(tree.Root())
+	for _, kind := range []string{"bolt", "file", "mmap"} {
+		store := kind + ":" + filepath.Join(t.TempDir(), "store")
+		if out, err := runCLI(t, "-trie", path, "-store", store, "import"); err != nil || strings.TrimSpace(out) != root {
+			t.Fatalf("import into %s printed %q, %v", kind, out, err)
+		}
+		if out, err := runCLI(t, "-store", store, "-root", root, "get", "baz"); err != nil || out != "qux\n" {
+			t.Errorf("get from %s printed %q, %v", kind, out, err)
+		}
+		if _, err := runCLI(t, "-store", store, "get", "baz"); err == nil {
+			t.Errorf("opened %s without -root", kind)
+		}
+	}
+}
+
+func TestCLIUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"frobnicate"},
+		{"-trie", "x", "get"},
+		{"-nosuchflag", "root"},
+	} {
+		if _, err := runCLI(t, args...); !errors.Is(err, errUsage) {
+			t.Errorf("got %v for %q, want errUsage", err, args)
+		}
+	}
+	if _, err := runCLI(t, "-store", "nowhere", "-root", "00", "root"); err == nil {
+		t.Error("opened a store without a kind")
+	}
+}
@@ -0,0 +1,46 @@
+package smt
+
+import "sync"
+
+// WithConcurrentReads makes Get, Has and the Prove methods safe to call from
+// many goroutines while updates are made, without serialising the reads with
+// each other: reads take a shared lock of the tree, and updates, deletions
+// and SetRoot an exclusive one, so that no read sees the stores partway
+// through an update or follows a root whose nodes an update is pruning. The
+// stores must support concurrent reads, as SimpleMap does, and concurrent
+// writes too if they are also used outside of the tree; see
+// NewConcurrentSimpleMap. Without the option, reads made during an update may
+// fail with missing nodes.
+func WithConcurrentReads() Option {
+	return func(smt *SparseMerkleTree) {
+		smt.reads = new(sync.RWMutex)
+	}
+}
+
+// readLock takes the shared lock of a tree created with WithConcurrentReads,
+// returning the function releasing it. It must be taken before, never while,
+// holding mu, and read methods holding it must call the unlocked variants of
+// each other.
+func (smt *SparseMerkleTree) readLock() func() {
+	if smt.reads == nil {
+		return func() {}
+	}
+	smt.reads.RLock()
+	return smt.reads.RUnlock
+}
+
+// writeLock locks the tree for a change of its stores, taking the exclusive
+// lock of a tree created with WithConcurrentReads and then mu, returning the
+// function releasing both.
+func (smt *SparseMerkleTree) writeLock() func() {
+	if smt.reads != nil {
+		smt.reads.Lock()
+	}
+	smt.mu.Lock()
+	return func() {
+		smt.mu.Unlock()
+		if smt.reads != nil {
+			smt.reads.Unlock()
+		}
+	}
+}
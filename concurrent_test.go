@@ -0,0 +1,134 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentReads(t *testing.T) {
+	smt := NewSparseMerkleTreeWithHasherFunc(NewSimpleMap(), NewSimpleMap(), sha256.New, WithConcurrentReads())
+	for i := 0; i < 50; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	roots := [][]byte{smt.Root()}
+
+	// One writer updates and deletes other keys while readers read and prove
+	// the first keys, whose values do not change.
+	type result struct {
+		key, value []byte
+		proof      SparseMerkleProof
+	}
+	results := make(chan result, 8*100)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			key := []byte(fmt.Sprintf("testKey%d", 10+i%40))
+			var root []byte
+			var err error
+			if i%3 == 0 {
+				root, err = smt.Delete(key)
+			} else {
+				root, err = smt.Update(key, []byte(fmt.Sprintf("newValue%d", i)))
+			}
+			if err != nil {
+				t.Errorf("returned error when updating: %v", err)
+				return
+			}
+			roots = append(roots, root)
+		}
+	}()
+	for r := 0; r < 8; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				key := []byte(fmt.Sprintf("testKey%d", (r+i)%10))
+				value, err := smt.Get(key)
+				if err != nil {
+					t.Errorf("returned error when getting: %v", err)
+					return
+				}
+				proof, err := smt.Prove(key)
+				if err != nil {
+					t.Errorf("returned error when proving: %v", err)
+					return
+				}
+				if _, err := smt.ProveMulti([][]byte{key}); err != nil {
+					t.Errorf("returned error when proving multiple keys: %v", err)
+					return
+				}
+				if _, _, err := smt.EstimateProofSize(key); err != nil {
+					t.Errorf("returned error when estimating proof size: %v", err)
+					return
+				}
+				// The range strictly between a key and itself is empty.
+				if _, err := smt.ProveEmptyRange(key, key); err != nil {
+					t.Errorf("returned error when proving empty range: %v", err)
+					return
+				}
+				results <- result{key, value, proof}
+			}
+		}(r)
+	}
+	wg.Wait()
+	close(results)
+
+	for res := range results {
+		if !bytes.Equal(res.value, []byte(fmt.Sprintf("testValue%s", res.key[len("testKey"):]))) {
+			t.Errorf("got value %s for %s", res.value, res.key)
+		}
+		verified := false
+		for _, root := range roots {
+			if VerifyProof(res.proof, root, res.key, res.value, sha256.New()) {
+				verified = true
+				break
+			}
+		}
+		if !verified {
+			t.Errorf("proof of %s verifies against none of the roots", res.key)
+		}
+	}
+}
+
+func TestConcurrentSimpleMap(t *testing.T) {
+	sm := NewConcurrentSimpleMap()
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				key := []byte(fmt.Sprintf("key%d-%d", w, i))
+				if err := sm.Set(key, []byte("value")); err != nil {
+					t.Errorf("returned error when setting: %v", err)
+				}
+				if value, err := sm.Get(key); err != nil || !bytes.Equal(value, []byte("value")) {
+					t.Errorf("did not get value, got %s, %v", value, err)
+				}
+				if i%2 == 0 {
+					if err := sm.Delete(key); err != nil {
+						t.Errorf("returned error when deleting: %v", err)
+					}
+				}
+			}
+			if _, err := sm.Export(); err != nil {
+				t.Errorf("returned error when exporting: %v", err)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	data, _ := sm.Export()
+	m, _, err := ImportMerkleMap(data, data)
+	if err != nil {
+		t.Fatalf("returned error when importing export: %v", err)
+	}
+	if len(m.m) != 4*50 {
+		t.Errorf("map holds %d entries, expected %d", len(m.m), 4*50)
+	}
+}
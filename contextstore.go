@@ -0,0 +1,126 @@
+package smt
+
+import (
+	"context"
+	"time"
+)
+
+// ContextMapStore is a key-value store whose operations take a context, such
+// as a remote store shared by several processes, so that they can honor
+// timeouts and cancellation. Wrap it in a ContextStore to back a tree with
+// it.
+type ContextMapStore interface {
+	GetContext(ctx context.Context, key []byte) ([]byte, error)     // GetContext gets the value for a key.
+	SetContext(ctx context.Context, key []byte, value []byte) error // SetContext updates the value for a key.
+	DeleteContext(ctx context.Context, key []byte) error            // DeleteContext deletes a key.
+	ExportContext(ctx context.Context) ([]byte, error)              // exports the map into a byte array
+}
+
+// ContextMultiStore is a ContextMapStore that can also read and write several
+// keys in one call, like a MultiStore.
+type ContextMultiStore interface {
+	ContextMapStore
+	// BatchGetContext gets the values for keys, in order. It fails like
+	// GetContext if any key is missing.
+	BatchGetContext(ctx context.Context, keys [][]byte) ([][]byte, error)
+	// BatchSetContext updates the values for keys.
+	BatchSetContext(ctx context.Context, keys [][]byte, values [][]byte) error
+}
+
+// ContextStore is a MapStore over a ContextMapStore, which runs each
+// operation under a context derived from a base context, with a timeout. A
+// tree backed by it fails the operations whose store calls time out, or that
+// are made once the base context is done, e.g. at shutdown, with the error of
+// the call, such as context.DeadlineExceeded. It is a MultiStore, batching
+// reads and writes in one call when the inner store is a ContextMultiStore.
+type ContextStore struct {
+	ctx     context.Context
+	store   ContextMapStore
+	timeout time.Duration
+}
+
+// NewContextStore creates a ContextStore over store, running each operation
+// under ctx with the given timeout, or with no timeout of its own if it is 0.
+func NewContextStore(ctx context.Context, store ContextMapStore, timeout time.Duration) *ContextStore {
+	if ctx == nil {
+		panic("smt: nil context")
+	}
+	if store == nil {
+		panic("smt: nil store")
+	}
+	if timeout < 0 {
+		panic("smt: negative timeout")
+	}
+	return &ContextStore{ctx: ctx, store: store, timeout: timeout}
+}
+
+// context returns the context of an operation, and the function releasing it.
+func (cs *ContextStore) context() (context.Context, context.CancelFunc) {
+	if cs.timeout == 0 {
+		return context.WithCancel(cs.ctx)
+	}
+	return context.WithTimeout(cs.ctx, cs.timeout)
+}
+
+// Get gets the value for a key.
+func (cs *ContextStore) Get(key []byte) ([]byte, error) {
+	ctx, cancel := cs.context()
+	defer cancel()
+	return cs.store.GetContext(ctx, key)
+}
+
+// Set updates the value for a key.
+func (cs *ContextStore) Set(key []byte, value []byte) error {
+	ctx, cancel := cs.context()
+	defer cancel()
+	return cs.store.SetContext(ctx, key, value)
+}
+
+// Delete deletes a key.
+func (cs *ContextStore) Delete(key []byte) error {
+	ctx, cancel := cs.context()
+	defer cancel()
+	return cs.store.DeleteContext(ctx, key)
+}
+
+// Export exports the inner store.
+func (cs *ContextStore) Export() ([]byte, error) {
+	ctx, cancel := cs.context()
+	defer cancel()
+	return cs.store.ExportContext(ctx)
+}
+
+// BatchGet gets the values for keys, in order, in one call if the inner store
+// is a ContextMultiStore, or one by one otherwise, under one timeout.
+func (cs *ContextStore) BatchGet(keys [][]byte) ([][]byte, error) {
+	ctx, cancel := cs.context()
+	defer cancel()
+	if ms, ok := cs.store.(ContextMultiStore); ok {
+		return ms.BatchGetContext(ctx, keys)
+	}
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		value, err := cs.store.GetContext(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// BatchSet updates the values for keys, in one call if the inner store is a
+// ContextMultiStore, or one by one otherwise, under one timeout.
+func (cs *ContextStore) BatchSet(keys [][]byte, values [][]byte) error {
+	ctx, cancel := cs.context()
+	defer cancel()
+	if ms, ok := cs.store.(ContextMultiStore); ok {
+		return ms.BatchSetContext(ctx, keys, values)
+	}
+	for i, key := range keys {
+		if err := cs.store.SetContext(ctx, key, values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
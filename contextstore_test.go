@@ -0,0 +1,92 @@
+package smt
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// contextMap is a ContextMapStore over a SimpleMap whose calls block until
+// their context is done while it is stalled, as a remote store that stops
+// answering would.
+type contextMap struct {
+	m       *SimpleMap
+	stalled bool
+}
+
+func (cm *contextMap) wait(ctx context.Context) error {
+	if cm.stalled {
+		<-ctx.Done()
+	}
+	return ctx.Err()
+}
+
+func (cm *contextMap) GetContext(ctx context.Context, key []byte) ([]byte, error) {
+	if err := cm.wait(ctx); err != nil {
+		return nil, err
+	}
+	return cm.m.Get(key)
+}
+
+func (cm *contextMap) SetContext(ctx context.Context, key []byte, value []byte) error {
+	if err := cm.wait(ctx); err != nil {
+		return err
+	}
+	return cm.m.Set(key, value)
+}
+
+func (cm *contextMap) DeleteContext(ctx context.Context, key []byte) error {
+	if err := cm.wait(ctx); err != nil {
+		return err
+	}
+	return cm.m.Delete(key)
+}
+
+func (cm *contextMap) ExportContext(ctx context.Context) ([]byte, error) {
+	if err := cm.wait(ctx); err != nil {
+		return nil, err
+	}
+	return cm.m.Export()
+}
+
+func TestContextStore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodes := &contextMap{m: NewSimpleMap()}
+	smt := NewSparseMerkleTree(NewContextStore(ctx, nodes, 10*time.Millisecond), NewSimpleMap(), sha256.New())
+	reference := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 20; i++ {
+		key, value := []byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i))
+		if _, err := smt.Update(key, value); err != nil {
+			t.Fatalf("returned error when updating: %v", err)
+		}
+		reference.Update(key, value)
+	}
+	if !bytes.Equal(smt.Root(), reference.Root()) {
+		t.Fatal("tree over ContextStore has a different root")
+	}
+	if _, err := smt.Prove([]byte("testKey3")); err != nil {
+		t.Errorf("returned error when proving: %v", err)
+	}
+
+	// A stalled store times out.
+	nodes.stalled = true
+	root := smt.Root()
+	if _, err := smt.Update([]byte("testKey3"), []byte("newValue")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("did not return DeadlineExceeded, got %v", err)
+	}
+	if !bytes.Equal(smt.Root(), root) {
+		t.Error("timed out update changed the root")
+	}
+
+	// Once the base context is done, operations fail at once.
+	nodes.stalled = false
+	cancel()
+	if _, err := smt.Prove([]byte("testKey3")); !errors.Is(err, context.Canceled) {
+		t.Errorf("did not return Canceled, got %v", err)
+	}
+}
@@ -9,15 +9,23 @@ import (
 // ErrBadProof is returned when an invalid Merkle proof is supplied.
 var ErrBadProof = errors.New("bad proof")
 
+// ErrInvalidProof is another name for ErrBadProof, which errors.Is matches
+// either way.
+var ErrInvalidProof = ErrBadProof
+
 // DeepSparseMerkleSubTree is a deep Sparse Merkle subtree for working on only a few leafs.
 type DeepSparseMerkleSubTree struct {
 	*SparseMerkleTree
+
+	// Paths of the keys of the proof bundle the subtree was imported from, if
+	// any; see ImportProofBundle.
+	bundlePaths map[string]struct{}
 }
 
 // NewDeepSparseMerkleSubTree creates a new deep Sparse Merkle subtree on an empty MapStore.
-func NewDeepSparseMerkleSubTree(nodes, values MapStore, hasher hash.Hash, root []byte) *DeepSparseMerkleSubTree {
+func NewDeepSparseMerkleSubTree(nodes, values MapStore, hasher hash.Hash, root []byte, options ...Option) *DeepSparseMerkleSubTree {
 	return &DeepSparseMerkleSubTree{
-		SparseMerkleTree: ImportSparseMerkleTree(nodes, values, hasher, root),
+		SparseMerkleTree: ImportSparseMerkleTree(nodes, values, hasher, root, options...),
 	}
 }
 
@@ -28,36 +36,70 @@ func NewDeepSparseMerkleSubTree(nodes, values MapStore, hasher hash.Hash, root [
 // If the leaf may be updated (e.g. during a state transition fraud proof),
 // an updatable proof should be used. See SparseMerkleTree.ProveUpdatable.
 func (dsmst *DeepSparseMerkleSubTree) AddBranch(proof SparseMerkleProof, key []byte, value []byte) error {
-	result, updates := verifyProofWithUpdates(proof, dsmst.Root(), key, value, dsmst.th.hasher)
+	dsmst.mu.Lock()
+	defer dsmst.mu.Unlock()
+	if dsmst.sealed {
+		return ErrSealed
+	}
+	result, updates := verifyProofWithUpdates(proof, dsmst.root, key, value, &dsmst.th, dsmst.emptyValue())
 	if !result {
+		dsmst.warnf("rejected branch for key %s: proof does not verify against root %x", formatKey(key), dsmst.root)
+		return ErrBadProof
+	}
+	if proof.SiblingData != nil && len(proof.SideNodes) > 0 &&
+		!bytes.Equal(dsmst.th.digest(proof.SiblingData), proof.SideNodes[0]) {
+		dsmst.warnf("rejected branch for key %s: sibling data does not match its hash", formatKey(key))
 		return ErrBadProof
 	}
 
-	if !bytes.Equal(value, defaultValue) { // Membership proof.
-		if err := dsmst.values.Set(dsmst.th.path(key), value); err != nil {
+	if !bytes.Equal(value, dsmst.emptyValue()) { // Membership proof.
+		if err := dsmst.setValue(dsmst.th.path(key), value); err != nil {
 			return err
 		}
 	}
 
 	// Update nodes along branch
+	var writes nodeBatch
 	for _, update := range updates {
-		err := dsmst.nodes.Set(update[0], update[1])
-		if err != nil {
-			return err
-		}
+		writes.add(update[0], update[1])
 	}
 
 	// Update sibling node
 	if proof.SiblingData != nil {
 		if proof.SideNodes != nil && len(proof.SideNodes) > 0 {
-			err := dsmst.nodes.Set(proof.SideNodes[0], proof.SiblingData)
-			if err != nil {
+			writes.add(proof.SideNodes[0], proof.SiblingData)
+		}
+	}
+
+	return dsmst.setNodes(&writes)
+}
+
+// AddMultiBranch adds the branches of the keys of a multiproof, generated by
+// ProveMulti, like AddBranch does those of individual proofs, so that the
+// subtree can be seeded from the one proof of the keys a block touches. The
+// subtrees off the paths of the keys are known by their hashes only, so a
+// key whose sibling is such a subtree cannot be deleted. If the proof is
+// invalid, ErrBadProof is returned.
+func (dsmst *DeepSparseMerkleSubTree) AddMultiBranch(proof SparseMultiProof, keys [][]byte, values [][]byte) error {
+	dsmst.mu.Lock()
+	defer dsmst.mu.Unlock()
+	if dsmst.sealed {
+		return ErrSealed
+	}
+	var writes nodeBatch
+	config := &verifyConfig{defaultValue: dsmst.emptyValue(), valueSize: dsmst.valueSize}
+	if !verifyMultiProof(proof, dsmst.root, keys, values, &dsmst.th, config, &writes) {
+		dsmst.warnf("rejected multiproof of %d keys: proof does not verify against root %x", len(keys), dsmst.root)
+		return ErrBadProof
+	}
+	for i, key := range keys {
+		if !bytes.Equal(values[i], dsmst.emptyValue()) {
+			if err := dsmst.setValue(dsmst.th.path(key), values[i]); err != nil {
 				return err
 			}
 		}
 	}
-
-	return nil
+	return dsmst.setNodes(&writes)
 }
 
 // GetDescend gets the value of a key from the tree by descending it.
@@ -69,13 +111,13 @@ func (smt *SparseMerkleTree) GetDescend(key []byte) ([]byte, error) {
 
 	if bytes.Equal(root, smt.th.placeholder()) {
 		// The tree is empty, return the default value.
-		return defaultValue, nil
+		return smt.emptyValue(), nil
 	}
 
 	path := smt.th.path(key)
 	currentHash := root
 	for i := 0; i < smt.depth(); i++ {
-		currentData, err := smt.nodes.Get(currentHash)
+		currentData, err := smt.getNode(currentHash)
 		if err != nil {
 			return nil, err
 		} else if smt.th.isLeaf(currentData) {
@@ -83,10 +125,10 @@ func (smt *SparseMerkleTree) GetDescend(key []byte) ([]byte, error) {
 			p, _ := smt.th.parseLeaf(currentData)
 			if !bytes.Equal(path, p) {
 				// Nope. Therefore the key is actually empty.
-				return defaultValue, nil
+				return smt.emptyValue(), nil
 			}
 			// Otherwise, yes. Return the value.
-			value, err := smt.values.Get(path)
+			value, err := smt.getValue(path)
 			if err != nil {
 				return nil, err
 			}
@@ -102,14 +144,14 @@ func (smt *SparseMerkleTree) GetDescend(key []byte) ([]byte, error) {
 
 		if bytes.Equal(currentHash, smt.th.placeholder()) {
 			// We've hit a placeholder value; this is the end.
-			return defaultValue, nil
+			return smt.emptyValue(), nil
 		}
 	}
 
 	// The following lines of code should only be reached if the path is 256
 	// nodes high, which should be very unlikely if the underlying hash function
 	// is collision-resistant.
-	value, err := smt.values.Get(path)
+	value, err := smt.getValue(path)
 	if err != nil {
 		return nil, err
 	}
@@ -125,5 +167,5 @@ func (smt *SparseMerkleTree) HasDescend(key []byte) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	return !bytes.Equal(defaultValue, val), nil
+	return !bytes.Equal(smt.emptyValue(), val), nil
 }
@@ -0,0 +1,453 @@
+package smt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// ErrDiffCorrupt is returned by ApplyDiff when a diff is malformed or does not
+// match its checksum.
+var ErrDiffCorrupt = errors.New("diff is corrupt")
+
+// ErrDiffMismatch is returned by ApplyDiff when a diff does not apply to the
+// tree: the tree is not at the root the diff is from, a leaf it changes is not
+// as the diff expects, or applying it does not give the root it is to.
+var ErrDiffMismatch = errors.New("diff does not apply to the tree")
+
+// diffMagic identifies a diff exported by ExportDiff.
+var diffMagic = []byte("SMTD")
+
+const diffVersion = 1
+
+// Tags of the old and new leaves of a diff entry.
+const (
+	diffAbsent    = 0 // no leaf, i.e. a tombstone for the new leaf
+	diffValue     = 1 // followed by the value of the leaf
+	diffValueHash = 2 // followed by the value hash of a leaf without a value
+)
+
+// Diff layout, with lengths and counts as uvarints:
+//
+//	magic, one byte of format version
+//	from root length, from root, to root length, to root
+//	entry count
+//	per entry: path, key length, key (empty if not retained),
+//	old tag, old value hash length, old value hash (if not absent),
+//	new tag, value or value hash length, value or value hash (if not absent)
+//	big-endian CRC32 (IEEE) of everything before it
+//
+// Old leaves are given by value hash only, with the diffValueHash tag, as
+// they are only checked.
+
+// diffEntry is the change in the leaf at path between two roots. Leaves are
+// described by a tag and the value or value hash it says follows.
+type diffEntry struct {
+	path, key                []byte
+	oldTag, newTag           byte
+	oldValueHash, newContent []byte
+}
+
+// ExportDiff returns a changeset of the leaves that differ between fromRoot
+// and toRoot, to be shipped to another tree at fromRoot and fast-forwarded to
+// toRoot with ApplyDiff. Each entry gives the path of a changed leaf, the
+// value hash it had under fromRoot, and its value under toRoot, or a
+// tombstone if it was deleted; the raw key is included if the tree retains it
+// with WithKeyStore. Subtrees the roots share are skipped, so the cost is in
+// the number of changes rather than the size of the tree.
+//
+// Both roots must be the current root or retained ones, as the nodes of
+// neither may be pruned, or ErrRootPruned is returned.
+func (smt *SparseMerkleTree) ExportDiff(fromRoot []byte, toRoot []byte) ([]byte, error) {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	if _, err := smt.retainedIndex(fromRoot); err != nil {
+		return nil, err
+	}
+	toIndex, err := smt.retainedIndex(toRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []diffEntry
+	err = smt.diffNodes(fromRoot, toRoot, func(path []byte, oldLeaf []byte, newLeaf []byte) error {
+		e := diffEntry{path: path}
+		if oldLeaf != nil {
+			_, e.oldValueHash = smt.th.parseLeaf(oldLeaf)
+			e.oldTag = diffValueHash
+		}
+		if newLeaf != nil {
+			_, valueHash := smt.th.parseLeaf(newLeaf)
+			e.newTag, e.newContent = diffValueHash, valueHash
+			if len(valueHash) > 0 {
+				value, err := smt.valueAtIndex(path, toIndex)
+				if err != nil {
+					return err
+				}
+				// Leaves set with UpdateLeafHash have no value to ship.
+				if bytes.Equal(smt.th.digestValue(value), valueHash) {
+					e.newTag, e.newContent = diffValue, value
+				}
+			}
+		}
+		if smt.keys != nil {
+			key, err := smt.keys.Get(path)
+			if err != nil && !errors.Is(err, ErrKeyNotFound) {
+				return err
+			}
+			e.key = key
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	sw := &streamWriter{w: bufio.NewWriter(&buf)}
+	sw.write(diffMagic)
+	sw.write([]byte{diffVersion})
+	sw.writeBytes(fromRoot)
+	sw.writeBytes(toRoot)
+	sw.writeUvarint(uint64(len(entries)))
+	for _, e := range entries {
+		sw.write(e.path)
+		sw.writeBytes(e.key)
+		sw.write([]byte{e.oldTag})
+		if e.oldTag != diffAbsent {
+			sw.writeBytes(e.oldValueHash)
+		}
+		sw.write([]byte{e.newTag})
+		if e.newTag != diffAbsent {
+			sw.writeBytes(e.newContent)
+		}
+	}
+	if sw.err == nil {
+		sw.err = sw.w.Flush()
+	}
+	if sw.err != nil {
+		return nil, sw.err
+	}
+	var trailer [4]byte
+	binary.BigEndian.PutUint32(trailer[:], crc32.ChecksumIEEE(buf.Bytes()))
+	smt.debugf("exported diff from %x to %x with %d entries", fromRoot, toRoot, len(entries))
+	return append(buf.Bytes(), trailer[:]...), nil
+}
+
+// ChangeKind is the kind of a change of a key between two roots.
+type ChangeKind int
+
+// Kinds of changes.
+const (
+	KeyInserted ChangeKind = iota + 1
+	KeyUpdated
+	KeyDeleted
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case KeyInserted:
+		return "inserted"
+	case KeyUpdated:
+		return "updated"
+	case KeyDeleted:
+		return "deleted"
+	}
+	return fmt.Sprintf("ChangeKind(%d)", int(k))
+}
+
+// KeyChange is the change of a key between two roots, as returned by Diff.
+type KeyChange struct {
+	Kind ChangeKind
+	// Path is the path of the key, and Key the key itself, or nil if the tree
+	// does not retain it with WithKeyStore.
+	Path, Key []byte
+	// OldValue and NewValue are the values of the key under the old and new
+	// roots, nil where it is absent. A leaf set with UpdateLeafHash, or a
+	// presence leaf, has no value, and is given an empty one.
+	OldValue, NewValue []byte
+}
+
+// Diff returns the changes of the keys between oldRoot and newRoot, in
+// ascending path order: the keys inserted, updated and deleted, with their
+// old and new values, e.g. to show what a block changed. Subtrees the roots
+// share are skipped, as in ExportDiff, so the cost is in the number of
+// changes rather than the size of the tree.
+//
+// Both roots must be the current root or retained ones, or ErrRootPruned is
+// returned; the values and keys a retained root had are kept with it.
+func (smt *SparseMerkleTree) Diff(oldRoot []byte, newRoot []byte) ([]KeyChange, error) {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	oldIndex, err := smt.retainedIndex(oldRoot)
+	if err != nil {
+		return nil, err
+	}
+	newIndex, err := smt.retainedIndex(newRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []KeyChange
+	err = smt.diffNodes(oldRoot, newRoot, func(path []byte, oldLeaf []byte, newLeaf []byte) error {
+		c := KeyChange{Kind: KeyUpdated, Path: path}
+		index := newIndex
+		switch {
+		case oldLeaf == nil:
+			c.Kind = KeyInserted
+		case newLeaf == nil:
+			c.Kind, index = KeyDeleted, oldIndex
+		}
+		var err error
+		if c.Key, err = smt.keyAtIndex(path, index); err != nil {
+			return err
+		}
+		if oldLeaf != nil {
+			if c.OldValue, err = smt.leafValueAtIndex(path, oldLeaf, oldIndex); err != nil {
+				return err
+			}
+		}
+		if newLeaf != nil {
+			if c.NewValue, err = smt.leafValueAtIndex(path, newLeaf, newIndex); err != nil {
+				return err
+			}
+		}
+		changes = append(changes, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// leafValueAtIndex returns the value of the leaf with the given data at path,
+// under the root at index, or an empty value if the leaf has none stored.
+func (smt *SparseMerkleTree) leafValueAtIndex(path []byte, leaf []byte, index int) ([]byte, error) {
+	_, valueHash := smt.th.parseLeaf(leaf)
+	if len(valueHash) == 0 {
+		return []byte{}, nil
+	}
+	value, err := smt.valueAtIndex(path, index)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(smt.th.digestValue(value), valueHash) {
+		return []byte{}, nil
+	}
+	return value, nil
+}
+
+// ApplyDiff fast-forwards the tree from fromRoot, which must be its current
+// root, to the root a diff exported by ExportDiff is to, and returns it. The
+// leaves the diff changes are checked against the values the diff expects
+// them to have, and the resulting root against the one it is to; if either
+// does not match, ErrDiffMismatch is returned and nothing is written to the
+// tree; when a root does not match, it is also an ErrRootMismatch.
+func (smt *SparseMerkleTree) ApplyDiff(fromRoot []byte, diff []byte) ([]byte, error) {
+	diffFrom, diffTo, entries, err := smt.decodeDiff(diff)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(diffFrom, fromRoot) {
+		return nil, rootMismatch("%w: diff is from %x", ErrDiffMismatch, diffFrom)
+	}
+	return smt.changeRoot(func(root []byte) ([]byte, error) {
+		if !bytes.Equal(root, fromRoot) {
+			return nil, rootMismatch("%w: tree is at %x", ErrDiffMismatch, root)
+		}
+		// The entries are staged in a transaction, so a diff that does not
+		// give diffTo writes nothing to the stores.
+		tx := smt.newTx()
+		tx.done = true
+		for _, e := range entries {
+			if root, err = tx.view.applyDiffEntry(e, root); err != nil {
+				return nil, err
+			}
+		}
+		if !bytes.Equal(root, diffTo) {
+			smt.warnf("diff from %x gave root %x, expected %x", fromRoot, root, diffTo)
+			return nil, rootMismatch("%w: gave root %x, expected %x", ErrDiffMismatch, root, diffTo)
+		}
+		return root, tx.apply()
+	})
+}
+
+func (smt *SparseMerkleTree) decodeDiff(diff []byte) (fromRoot []byte, toRoot []byte, entries []diffEntry, err error) {
+	if len(diff) < 4 {
+		return nil, nil, nil, ErrDiffCorrupt
+	}
+	body, trailer := diff[:len(diff)-4], diff[len(diff)-4:]
+	if binary.BigEndian.Uint32(trailer) != crc32.ChecksumIEEE(body) {
+		return nil, nil, nil, ErrDiffCorrupt
+	}
+	sr := &streamReader{r: bufio.NewReader(bytes.NewReader(body)), crc: crc32.NewIEEE()}
+	magic := sr.read(len(diffMagic))
+	if sr.err == nil && !bytes.Equal(magic, diffMagic) {
+		return nil, nil, nil, ErrDiffCorrupt
+	}
+	version := sr.read(1)
+	if sr.err == nil && version[0] != diffVersion {
+		return nil, nil, nil, fmt.Errorf("%w: unsupported version %d", ErrDiffCorrupt, version[0])
+	}
+	fromRoot = sr.readBytes()
+	toRoot = sr.readBytes()
+	count := sr.readUvarint()
+	for i := uint64(0); i < count && sr.err == nil; i++ {
+		e := diffEntry{path: sr.read(smt.th.pathSize()), key: sr.readBytes()}
+		if e.oldTag = sr.readTag(); e.oldTag != diffAbsent {
+			e.oldValueHash = sr.readBytes()
+		}
+		if e.newTag = sr.readTag(); e.newTag != diffAbsent {
+			e.newContent = sr.readBytes()
+		}
+		if e.oldTag > diffValueHash || e.newTag > diffValueHash {
+			return nil, nil, nil, ErrDiffCorrupt
+		}
+		entries = append(entries, e)
+	}
+	if sr.err != nil {
+		if errors.Is(sr.err, ErrSnapshotCorrupt) {
+			return nil, nil, nil, ErrDiffCorrupt
+		}
+		return nil, nil, nil, sr.err
+	}
+	if _, err := sr.r.ReadByte(); err == nil {
+		return nil, nil, nil, ErrDiffCorrupt
+	}
+	return fromRoot, toRoot, entries, nil
+}
+
+// applyDiffEntry applies a diff entry to the tree at root, returning the new
+// root.
+func (smt *SparseMerkleTree) applyDiffEntry(e diffEntry, root []byte) ([]byte, error) {
+	if smt.sealed {
+		return nil, ErrSealed
+	}
+	sideNodes, pathNodes, oldLeafData, _, err := smt.sideNodesForRoot(e.path, root, false)
+	if err != nil {
+		return nil, err
+	}
+	var found bool
+	var oldValueHash []byte
+	if !bytes.Equal(pathNodes[0], smt.th.placeholder()) {
+		var actualPath []byte
+		actualPath, oldValueHash = smt.th.parseLeaf(oldLeafData)
+		found = bytes.Equal(actualPath, e.path)
+	}
+	if found != (e.oldTag != diffAbsent) || (found && !bytes.Equal(oldValueHash, e.oldValueHash)) {
+		return nil, ErrDiffMismatch
+	}
+
+	switch e.newTag {
+	case diffAbsent:
+		newRoot, err := smt.deleteWithSideNodes(e.path, sideNodes, pathNodes, oldLeafData)
+		if err != nil {
+			return nil, err
+		}
+		if err := smt.deleteValue(e.path); err != nil {
+			return nil, err
+		}
+		return newRoot, smt.deleteKey(e.path)
+	case diffValue:
+		newRoot, err := smt.updateWithSideNodes(e.path, smt.th.digestValue(e.newContent), e.newContent, sideNodes, pathNodes, oldLeafData)
+		if err != nil {
+			return nil, err
+		}
+		return newRoot, smt.setDiffKey(e)
+	default:
+		newRoot, err := smt.updateWithSideNodes(e.path, e.newContent, nil, sideNodes, pathNodes, oldLeafData)
+		if err != nil {
+			return nil, err
+		}
+		return newRoot, smt.setDiffKey(e)
+	}
+}
+
+// setDiffKey stores the key of a diff entry, if it has one.
+func (smt *SparseMerkleTree) setDiffKey(e diffEntry) error {
+	if len(e.key) == 0 {
+		return nil
+	}
+	return smt.setKey(e.path, e.key)
+}
+
+// diffNodes calls visit, in ascending path order, with the path and the data
+// of the leaves under a and b for each path whose leaf differs between them,
+// with nil for a missing leaf. Subtrees with the same hash are skipped.
+func (smt *SparseMerkleTree) diffNodes(a []byte, b []byte, visit func(path []byte, aLeaf []byte, bLeaf []byte) error) error {
+	if bytes.Equal(a, b) {
+		return nil
+	}
+	aData, err := smt.nodeData(a)
+	if err != nil {
+		return err
+	}
+	bData, err := smt.nodeData(b)
+	if err != nil {
+		return err
+	}
+	if aData != nil && bData != nil && !smt.th.isLeaf(aData) && !smt.th.isLeaf(bData) {
+		aLeft, aRight := smt.th.parseNode(aData)
+		bLeft, bRight := smt.th.parseNode(bData)
+		if err := smt.diffNodes(aLeft, bLeft, visit); err != nil {
+			return err
+		}
+		return smt.diffNodes(aRight, bRight, visit)
+	}
+
+	// One side is a leaf or empty, so has at most one leaf to match against
+	// the leaves of the other.
+	aLeaves, err := smt.leavesUnder(a)
+	if err != nil {
+		return err
+	}
+	bLeaves, err := smt.leavesUnder(b)
+	if err != nil {
+		return err
+	}
+	for len(aLeaves) > 0 || len(bLeaves) > 0 {
+		var aLeaf, bLeaf []byte
+		var aPath, bPath []byte
+		if len(aLeaves) > 0 {
+			aPath, _ = smt.th.parseLeaf(aLeaves[0])
+		}
+		if len(bLeaves) > 0 {
+			bPath, _ = smt.th.parseLeaf(bLeaves[0])
+		}
+		if bPath == nil || (aPath != nil && bytes.Compare(aPath, bPath) <= 0) {
+			aLeaf, aLeaves = aLeaves[0], aLeaves[1:]
+		}
+		if aPath == nil || (bPath != nil && bytes.Compare(bPath, aPath) <= 0) {
+			bLeaf, bLeaves = bLeaves[0], bLeaves[1:]
+		}
+		if bytes.Equal(aLeaf, bLeaf) {
+			continue
+		}
+		path := aPath
+		if aLeaf == nil {
+			path = bPath
+		}
+		if err := visit(path, aLeaf, bLeaf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// leavesUnder returns the data of the leaves under the node with the given
+// hash, in ascending path order.
+func (smt *SparseMerkleTree) leavesUnder(hash []byte) ([][]byte, error) {
+	var leaves [][]byte
+	err := smt.walk(hash, func(_ []bool, _ []byte, data []byte) error {
+		if smt.th.isLeaf(data) {
+			leaves = append(leaves, data)
+		}
+		return nil
+	})
+	return leaves, err
+}
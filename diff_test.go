@@ -0,0 +1,221 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	source := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithRootRetention(40), WithKeyStore(NewSimpleMap()))
+	replica := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithKeyStore(NewSimpleMap()))
+	for i := 0; i < 50; i++ {
+		key, value := []byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i))
+		source.Update(key, value)
+		replica.Update(key, value)
+	}
+	fromRoot := source.Root()
+
+	for i := 0; i < 10; i++ {
+		source.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("newValue%d", i)))
+		source.Delete([]byte(fmt.Sprintf("testKey%d", i+10)))
+		source.Update([]byte(fmt.Sprintf("newKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	source.UpdateLeafHash([]byte("leafHashKey"), source.th.digest([]byte("unstored")))
+	source.UpdatePresence([]byte("presenceKey"))
+	source.UpdatePresence([]byte("testKey30"))
+	toRoot := source.Root()
+
+	diff, err := source.ExportDiff(fromRoot, toRoot)
+	if err != nil {
+		t.Fatalf("returned error when exporting diff: %v", err)
+	}
+	if empty, _ := source.ExportDiff(toRoot, toRoot); len(empty) >= len(diff) {
+		t.Error("diff of a root with itself is not smaller than a diff with changes")
+	}
+	root, err := replica.ApplyDiff(fromRoot, diff)
+	if err != nil {
+		t.Fatalf("returned error when applying diff: %v", err)
+	}
+	if !bytes.Equal(root, toRoot) || !bytes.Equal(replica.Root(), toRoot) {
+		t.Error("applying diff did not give the root it is to")
+	}
+	for _, key := range []string{"testKey0", "testKey10", "newKey0", "testKey20", "testKey30"} {
+		want, _ := source.Get([]byte(key))
+		if got, _ := replica.Get([]byte(key)); !bytes.Equal(got, want) {
+			t.Errorf("value of %s after applying diff is %q, want %q", key, got, want)
+		}
+	}
+	if stored, _ := replica.keys.Get(replica.th.path([]byte("newKey0"))); !bytes.Equal(stored, []byte("newKey0")) {
+		t.Error("raw key not stored after applying diff")
+	}
+	if _, err := replica.ApplyDiff(fromRoot, diff); !errors.Is(err, ErrDiffMismatch) {
+		t.Errorf("did not return ErrDiffMismatch when tree is not at fromRoot, got %v", err)
+	} else if !errors.Is(err, ErrRootMismatch) {
+		t.Errorf("ErrDiffMismatch for another root is not an ErrRootMismatch: %v", err)
+	}
+
+	// A tree at fromRoot whose leaves differ must not be fast-forwarded.
+	forged := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 50; i++ {
+		forged.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	forged.Update([]byte("testKey0"), []byte("otherValue"))
+	if _, err := forged.ApplyDiff(forged.Root(), diff); !errors.Is(err, ErrDiffMismatch) {
+		t.Errorf("did not return ErrDiffMismatch for diff from another root, got %v", err)
+	}
+
+	corrupted := append([]byte(nil), diff...)
+	corrupted[len(corrupted)/2] ^= 1
+	stale := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	if _, err := stale.ApplyDiff(fromRoot, corrupted); !errors.Is(err, ErrDiffCorrupt) {
+		t.Errorf("did not return ErrDiffCorrupt for corrupted diff, got %v", err)
+	}
+	if _, err := stale.ApplyDiff(fromRoot, diff[:3]); !errors.Is(err, ErrDiffCorrupt) {
+		t.Errorf("did not return ErrDiffCorrupt for truncated diff, got %v", err)
+	}
+	// Truncated diffs with a matching checksum are rejected too.
+	body := diff[:len(diff)-4]
+	for n := 0; n < len(body); n++ {
+		var trailer [4]byte
+		binary.BigEndian.PutUint32(trailer[:], crc32.ChecksumIEEE(body[:n]))
+		truncated := append(append([]byte(nil), body[:n]...), trailer[:]...)
+		if _, err := stale.ApplyDiff(fromRoot, truncated); !errors.Is(err, ErrDiffCorrupt) && !errors.Is(err, ErrDiffMismatch) {
+			t.Fatalf("did not reject diff truncated to %d bytes, got %v", n, err)
+		}
+	}
+
+	for i := 0; i < 40; i++ {
+		source.Update([]byte("testKey49"), []byte(fmt.Sprintf("pruning%d", i)))
+	}
+	if _, err := source.ExportDiff(fromRoot, source.Root()); !errors.Is(err, ErrRootPruned) {
+		t.Errorf("did not return ErrRootPruned for pruned root, got %v", err)
+	}
+}
+
+func TestDiffRejected(t *testing.T) {
+	source := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithRootRetention(10))
+	nodes := NewSimpleMap()
+	replica := NewSparseMerkleTree(nodes, NewSimpleMap(), sha256.New())
+	for i := 0; i < 20; i++ {
+		key, value := []byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i))
+		source.Update(key, value)
+		replica.Update(key, value)
+	}
+	fromRoot := source.Root()
+	for i := 0; i < 5; i++ {
+		source.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("newValue%d", i)))
+		source.Delete([]byte(fmt.Sprintf("testKey%d", i+5)))
+	}
+	diff, err := source.ExportDiff(fromRoot, source.Root())
+	if err != nil {
+		t.Fatalf("returned error when exporting diff: %v", err)
+	}
+
+	// Every entry applies, but the root the diff is to is not the one they
+	// give. The root follows the magic, the version and fromRoot.
+	corrupted := append([]byte(nil), diff[:len(diff)-4]...)
+	corrupted[len(diffMagic)+1+1+len(fromRoot)+1] ^= 1
+	var trailer [4]byte
+	binary.BigEndian.PutUint32(trailer[:], crc32.ChecksumIEEE(corrupted))
+	corrupted = append(corrupted, trailer[:]...)
+
+	branches, leaves, _ := replica.NodeCount()
+	stored := storedNodes(t, nodes)
+	if _, err := replica.ApplyDiff(fromRoot, corrupted); !errors.Is(err, ErrDiffMismatch) {
+		t.Fatalf("did not return ErrDiffMismatch for a diff to another root, got %v", err)
+	}
+	if !bytes.Equal(replica.Root(), fromRoot) {
+		t.Error("rejected diff changed the root")
+	}
+	for i := 0; i < 20; i++ {
+		key, value := []byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i))
+		if got, err := replica.Get(key); err != nil || !bytes.Equal(got, value) {
+			t.Errorf("got %q and error %v for %s after a rejected diff", got, err, key)
+		}
+		proof, err := replica.Prove(key)
+		if err != nil || !VerifyProof(proof, fromRoot, key, value, sha256.New()) {
+			t.Errorf("proof of %s did not verify after a rejected diff: %v", key, err)
+		}
+	}
+	if b, l, err := replica.NodeCount(); err != nil || b != branches || l != leaves {
+		t.Errorf("got %d branches and %d leaves, error %v, after a rejected diff, want %d and %d", b, l, err, branches, leaves)
+	}
+	if after := storedNodes(t, nodes); after != stored {
+		t.Errorf("rejected diff left %d stored nodes, want %d", after, stored)
+	}
+
+	if _, err := replica.ApplyDiff(fromRoot, diff); err != nil {
+		t.Errorf("returned error when applying the diff after rejecting one: %v", err)
+	}
+}
+
+func TestDiffChanges(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithRootRetention(40), WithKeyStore(NewSimpleMap()))
+	for i := 0; i < 20; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	oldRoot := smt.Root()
+	smt.Update([]byte("testKey1"), []byte("newValue1"))
+	smt.Delete([]byte("testKey2"))
+	smt.Update([]byte("newKey"), []byte("newValue"))
+	smt.Update([]byte("testKey3"), []byte("testValue3"))
+	smt.UpdatePresence([]byte("presenceKey"))
+	midRoot := smt.Root()
+	smt.Delete([]byte("newKey"))
+
+	changes, err := smt.Diff(oldRoot, midRoot)
+	if err != nil {
+		t.Fatalf("returned error: %v", err)
+	}
+	want := map[string]KeyChange{
+		"testKey1":    {Kind: KeyUpdated, OldValue: []byte("testValue1"), NewValue: []byte("newValue1")},
+		"testKey2":    {Kind: KeyDeleted, OldValue: []byte("testValue2")},
+		"newKey":      {Kind: KeyInserted, NewValue: []byte("newValue")},
+		"presenceKey": {Kind: KeyInserted, NewValue: []byte{}},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("got %d changes, want %d", len(changes), len(want))
+	}
+	for i, c := range changes {
+		if i > 0 && bytes.Compare(changes[i-1].Path, c.Path) >= 0 {
+			t.Error("changes are out of path order")
+		}
+		w, ok := want[string(c.Key)]
+		if !ok {
+			t.Errorf("unexpected change of %q", c.Key)
+			continue
+		}
+		if c.Kind != w.Kind || !bytes.Equal(c.OldValue, w.OldValue) || !bytes.Equal(c.NewValue, w.NewValue) || (c.OldValue == nil) != (w.OldValue == nil) || (c.NewValue == nil) != (w.NewValue == nil) {
+			t.Errorf("change of %s is %v %q to %q, want %v %q to %q", c.Key, c.Kind, c.OldValue, c.NewValue, w.Kind, w.OldValue, w.NewValue)
+		}
+		if !bytes.Equal(c.Path, smt.th.path(c.Key)) {
+			t.Errorf("change of %s has the wrong path", c.Key)
+		}
+	}
+
+	// Reversed, the changes are undone; the key deleted since is still known.
+	changes, _ = smt.Diff(midRoot, oldRoot)
+	for _, c := range changes {
+		if bytes.Equal(c.Key, []byte("newKey")) && c.Kind != KeyDeleted {
+			t.Errorf("newKey is %v going back", c.Kind)
+		}
+		if bytes.Equal(c.Key, []byte("testKey2")) && (c.Kind != KeyInserted || !bytes.Equal(c.NewValue, []byte("testValue2"))) {
+			t.Errorf("testKey2 is %v %q going back", c.Kind, c.NewValue)
+		}
+		if c.Key == nil {
+			t.Error("change going back has no key")
+		}
+	}
+
+	if changes, err := smt.Diff(smt.Root(), smt.Root()); err != nil || len(changes) != 0 {
+		t.Errorf("got %d changes of a root with itself, %v", len(changes), err)
+	}
+	if _, err := smt.Diff([]byte("unknown root"), smt.Root()); !errors.Is(err, ErrRootPruned) {
+		t.Errorf("did not return ErrRootPruned, got %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DefaultDumpLimit is the maximum number of leaves printed by Dump.
+const DefaultDumpLimit = 1000
+
+// ErrDumpLimit is returned when a tree has more leaves than allowed to dump.
+var ErrDumpLimit = errors.New("tree has too many leaves to dump")
+
+// Dump writes a readable description of the tree's structure to w, failing
+// with ErrDumpLimit if the tree has more than DefaultDumpLimit leaves.
+func (smt *SparseMerkleTree) Dump(w io.Writer) error {
+	return smt.DumpN(w, DefaultDumpLimit)
+}
+
+// DumpN writes a readable description of the tree's structure to w, one node
+// per line in depth-first, left-to-right order, so the output is stable for a
+// given root. Each line starts with the node's position as a string of
+// directions from the root (0 for left, 1 for right). Leaves are printed with
+// their path, their key if the tree was created with WithKeyStore, and their
+// value, or the hash of their value if they were set with UpdateLeafHash.
+// Placeholders are omitted.
+//
+// Nothing is written if the tree has more than maxLeaves leaves; ErrDumpLimit
+// is returned instead.
+func (smt *SparseMerkleTree) DumpN(w io.Writer, maxLeaves int) error {
+	defer smt.readLock()()
+	root := smt.Root()
+	if bytes.Equal(root, smt.th.placeholder()) {
+		_, err := fmt.Fprintln(w, "empty")
+		return err
+	}
+
+	leaves := 0
+	err := smt.walk(root, func(_ []bool, _ []byte, data []byte) error {
+		if smt.th.isLeaf(data) {
+			leaves++
+			if leaves > maxLeaves {
+				return ErrDumpLimit
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return smt.walk(root, func(prefix []bool, hash []byte, data []byte) error {
+		var position strings.Builder
+		position.WriteString("/")
+		for _, bit := range prefix {
+			if bit {
+				position.WriteByte('1')
+			} else {
+				position.WriteByte('0')
+			}
+		}
+
+		var err error
+		if smt.th.isLeaf(data) {
+			path, valueHash := smt.th.parseLeaf(data)
+			leaf := fmt.Sprintf("%s leaf %x path=%x", position.String(), hash, path)
+			if smt.keys != nil {
+				key, err := smt.keys.Get(path)
+				if err == nil {
+					leaf += fmt.Sprintf(" key=%x", key)
+				} else if !errors.Is(err, ErrKeyNotFound) {
+					return err
+				}
+			}
+			if len(valueHash) == 0 {
+				_, err = fmt.Fprintf(w, "%s presence\n", leaf)
+				return err
+			}
+			var value []byte
+			value, err = smt.getValue(path)
+			if errors.Is(err, ErrKeyNotFound) {
+				_, err = fmt.Fprintf(w, "%s valueHash=%x\n", leaf, valueHash)
+				return err
+			}
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(w, "%s value=%x\n", leaf, value)
+		} else {
+			_, err = fmt.Fprintf(w, "%s branch %x\n", position.String(), hash)
+		}
+		return err
+	})
+}
@@ -0,0 +1,83 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	smt1 := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	smt2 := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+
+	var buf bytes.Buffer
+	if err := smt1.Dump(&buf); err != nil {
+		t.Errorf("returned error when dumping empty tree: %v", err)
+	}
+	if buf.String() != "empty\n" {
+		t.Errorf("unexpected dump of empty tree: %q", buf.String())
+	}
+
+	for i := 0; i < 10; i++ {
+		smt1.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+		smt2.Update([]byte(fmt.Sprintf("testKey%d", 9-i)), []byte(fmt.Sprintf("testValue%d", 9-i)))
+	}
+
+	var dump1, dump2 bytes.Buffer
+	if err := smt1.Dump(&dump1); err != nil {
+		t.Errorf("returned error when dumping tree: %v", err)
+	}
+	if err := smt2.Dump(&dump2); err != nil {
+		t.Errorf("returned error when dumping tree: %v", err)
+	}
+	if dump1.String() != dump2.String() {
+		t.Error("dumps of identical trees differ")
+	}
+	if !strings.HasPrefix(dump1.String(), fmt.Sprintf("/ branch %x\n", smt1.Root())) {
+		t.Error("dump does not start with the root")
+	}
+	if n := strings.Count(dump1.String(), " leaf "); n != 10 {
+		t.Errorf("dump has %d leaves, expected 10", n)
+	}
+	if !strings.Contains(dump1.String(), fmt.Sprintf("value=%x\n", "testValue3")) {
+		t.Error("dump does not contain leaf value")
+	}
+
+	valueHash := sha256.Sum256([]byte("external"))
+	smt1.UpdateLeafHash([]byte("hashedKey"), valueHash[:])
+	var hashed bytes.Buffer
+	if err := smt1.Dump(&hashed); err != nil {
+		t.Errorf("returned error when dumping a leaf with no value: %v", err)
+	}
+	if !strings.Contains(hashed.String(), fmt.Sprintf("valueHash=%x\n", valueHash)) {
+		t.Error("dump does not contain the value hash of a leaf with no value")
+	}
+
+	var limited bytes.Buffer
+	err := smt1.DumpN(&limited, 10)
+	if !errors.Is(err, ErrDumpLimit) {
+		t.Error("did not return ErrDumpLimit when exceeding the leaf limit")
+	}
+	if limited.Len() != 0 {
+		t.Error("wrote output despite exceeding the leaf limit")
+	}
+}
+
+func TestDumpKeys(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithKeyStore(NewSimpleMap()))
+	smt.Update([]byte("foo"), []byte("bar"))
+	smt.Update([]byte("baz"), []byte("qux"))
+
+	var buf bytes.Buffer
+	if err := smt.Dump(&buf); err != nil {
+		t.Fatalf("returned error when dumping tree: %v", err)
+	}
+	path := sha256.Sum256([]byte("foo"))
+	want := fmt.Sprintf("path=%x key=%x value=%x\n", path, "foo", "bar")
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("dump %q does not contain the stored key of a leaf", buf.String())
+	}
+}
@@ -0,0 +1,118 @@
+package smt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// ErrDecryptFailed is returned by EncryptedStore for a value that does not
+// decrypt under its key, having been written with another key, tampered with,
+// or moved to another key in the inner store.
+var ErrDecryptFailed = errors.New("value failed to decrypt")
+
+// EncryptedStore is a MapStore that encrypts values with AES-GCM before
+// writing them to an inner store, such as shared storage, and decrypts them
+// when read, so that a tree is encrypted at rest without any change to it.
+// Each value is sealed with a random nonce, and authenticated along with its
+// key, so that values cannot be swapped between keys in the inner store. Keys
+// are stored as they are, or, with a key MAC key, replaced by their
+// HMAC-SHA256, so that the inner store does not see them either; lookups still
+// work as the HMAC of a key is always the same.
+type EncryptedStore struct {
+	store  MapStore
+	aead   cipher.AEAD
+	macKey []byte
+}
+
+// NewEncryptedStore creates an EncryptedStore over store, encrypting values
+// with key, which must be 16, 24 or 32 bytes for AES-128, AES-192 or AES-256.
+// If macKey is not nil, keys are replaced by their HMAC under it. To reopen a
+// store, pass the same keys.
+func NewEncryptedStore(store MapStore, key []byte, macKey []byte) (*EncryptedStore, error) {
+	if store == nil {
+		panic("smt: nil store")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	es := &EncryptedStore{store: store, aead: aead}
+	if macKey != nil {
+		es.macKey = append([]byte{}, macKey...)
+	}
+	return es, nil
+}
+
+// innerKey returns the key of the inner store for key.
+func (es *EncryptedStore) innerKey(key []byte) []byte {
+	if es.macKey == nil {
+		return key
+	}
+	mac := hmac.New(sha256.New, es.macKey)
+	mac.Write(key)
+	return mac.Sum(nil)
+}
+
+// Get gets the value for a key.
+func (es *EncryptedStore) Get(key []byte) ([]byte, error) {
+	sealed, err := es.store.Get(es.innerKey(key))
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, &InvalidKeyError{Key: key}
+	}
+	if err != nil {
+		return nil, err
+	}
+	nonceSize := es.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, ErrDecryptFailed
+	}
+	value, err := es.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], key)
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+	return value, nil
+}
+
+// Set updates the value for a key.
+func (es *EncryptedStore) Set(key []byte, value []byte) error {
+	nonce := make([]byte, es.aead.NonceSize(), es.aead.NonceSize()+len(value)+es.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("generating nonce: %w", err)
+	}
+	return es.store.Set(es.innerKey(key), es.aead.Seal(nonce, nonce, value, key))
+}
+
+// Delete deletes a key.
+func (es *EncryptedStore) Delete(key []byte) error {
+	err := es.store.Delete(es.innerKey(key))
+	if errors.Is(err, ErrKeyNotFound) {
+		return &InvalidKeyError{Key: key}
+	}
+	return err
+}
+
+// Export exports the inner store, with its values encrypted and, with a key
+// MAC key, its keys replaced. Import it with ImportMerkleMap and wrap it in
+// an EncryptedStore with the same keys to restore it.
+func (es *EncryptedStore) Export() ([]byte, error) {
+	return es.store.Export()
+}
+
+// Flush flushes the inner store if it is a ClosableStore.
+func (es *EncryptedStore) Flush() error {
+	return flushStore(es.store)
+}
+
+// Close closes the inner store if it is a ClosableStore.
+func (es *EncryptedStore) Close() error {
+	return closeStore(es.store)
+}
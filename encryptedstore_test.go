@@ -0,0 +1,83 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestEncryptedStore(t *testing.T) {
+	key, macKey := bytes.Repeat([]byte{1}, 32), []byte("mac key")
+	for _, withMAC := range []bool{false, true} {
+		inner := NewSimpleMap()
+		var mk []byte
+		if withMAC {
+			mk = macKey
+		}
+		es, err := NewEncryptedStore(inner, key, mk)
+		if err != nil {
+			t.Fatalf("returned error when creating store: %v", err)
+		}
+		es.Set([]byte("foo"), []byte("secret value"))
+		if value, err := es.Get([]byte("foo")); err != nil || !bytes.Equal(value, []byte("secret value")) {
+			t.Errorf("got %q, %v from the store", value, err)
+		}
+		for k, sealed := range inner.m {
+			if bytes.Contains(sealed, []byte("secret")) {
+				t.Error("value is stored in the clear")
+			}
+			if withMAC == (k == "foo") {
+				t.Errorf("stored under key %x with key MAC %v", k, withMAC)
+			}
+		}
+		if _, err := es.Get([]byte("bar")); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("got %v for a missing key, want ErrKeyNotFound", err)
+		}
+		var invalidKeyError *InvalidKeyError
+		if err := es.Delete([]byte("bar")); !errors.As(err, &invalidKeyError) || !bytes.Equal(invalidKeyError.Key, []byte("bar")) {
+			t.Errorf("got %v deleting a missing key, want an InvalidKeyError for it", err)
+		}
+
+		// The same store reopened with another key does not decrypt it.
+		other, _ := NewEncryptedStore(inner, bytes.Repeat([]byte{2}, 32), mk)
+		if _, err := other.Get([]byte("foo")); !errors.Is(err, ErrDecryptFailed) {
+			t.Errorf("got %v with another key, want ErrDecryptFailed", err)
+		}
+	}
+
+	// Values moved to another key in the inner store do not decrypt.
+	inner := NewSimpleMap()
+	es, _ := NewEncryptedStore(inner, key, nil)
+	es.Set([]byte("foo"), []byte("value"))
+	inner.Set([]byte("bar"), inner.m["foo"])
+	if _, err := es.Get([]byte("bar")); !errors.Is(err, ErrDecryptFailed) {
+		t.Errorf("got %v for a value moved to another key, want ErrDecryptFailed", err)
+	}
+
+	if _, err := NewEncryptedStore(NewSimpleMap(), []byte("short"), nil); err == nil {
+		t.Error("did not return error for a key of the wrong size")
+	}
+}
+
+func TestEncryptedStoreTree(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 16)
+	nodes, _ := NewEncryptedStore(NewSimpleMap(), key, []byte("nodes"))
+	values, _ := NewEncryptedStore(NewSimpleMap(), key, []byte("values"))
+	smt := NewSparseMerkleTree(nodes, values, sha256.New())
+	plain := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 50; i++ {
+		k, v := []byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i))
+		smt.Update(k, v)
+		plain.Update(k, v)
+	}
+	smt.Delete([]byte("testKey7"))
+	plain.Delete([]byte("testKey7"))
+	if !bytes.Equal(smt.Root(), plain.Root()) {
+		t.Error("tree on encrypted stores has another root")
+	}
+	if value, err := smt.Get([]byte("testKey3")); err != nil || !bytes.Equal(value, []byte("testValue3")) {
+		t.Errorf("got %q, %v from the tree", value, err)
+	}
+}
@@ -0,0 +1,130 @@
+package smt
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// evmWord is the size of the words of the EVM proof encoding, which is also
+// the size of the hashes it holds.
+const evmWord = 32
+
+// MarshalEVM encodes the proof for verifiers on the EVM, such as on-chain
+// Solidity verifiers, in 32-byte words, with integers big-endian:
+//
+//   - the number of side nodes, i.e. the depth of the leaf;
+//   - a bit mask of the side nodes that are placeholders, bit i, of value
+//     1<<i, standing for side node i, the nearest to the leaf being 0;
+//   - 1 if the proof gives the leaf of another key at the position of the key,
+//     proving it absent, and 0 if not, for a proof of the key's own leaf, or
+//     of an empty subtree if the verifier is given the default value;
+//   - if it does, the path and the value hash of that leaf;
+//   - the side nodes that are not placeholders, nearest to the leaf first.
+//
+// In a tree created with sha3.NewLegacyKeccak256, the hash of the EVM, and
+// the default domain prefixes, leaves hash as
+// keccak256(0x00 || path || keccak256(value)), with path keccak256(key), and
+// nodes as keccak256(0x01 || left || right); placeholders are zero words. The
+// proof must be of a tree with 32-byte hashes. SiblingData, which is only
+// needed to update the tree from the proof, is not encoded, and proofs of
+// absence by a presence leaf are not supported, returning ErrBadProof.
+func (proof SparseMerkleProof) MarshalEVM() ([]byte, error) {
+	if len(proof.SideNodes) > evmWord*8 {
+		return nil, fmt.Errorf("%w: %d side nodes", ErrBadProof, len(proof.SideNodes))
+	}
+	data := make([]byte, 3*evmWord, (5+len(proof.SideNodes))*evmWord)
+	putEVMUint(data[:evmWord], len(proof.SideNodes))
+	mask := data[evmWord : 2*evmWord]
+	if proof.NonMembershipLeafData != nil {
+		if len(proof.NonMembershipLeafData) != 1+2*evmWord {
+			return nil, fmt.Errorf("%w: leaf data of %d bytes", ErrBadProof, len(proof.NonMembershipLeafData))
+		}
+		putEVMUint(data[2*evmWord:3*evmWord], 1)
+		data = append(data, proof.NonMembershipLeafData[1:]...)
+	}
+	placeholder := make([]byte, evmWord)
+	for i, sideNode := range proof.SideNodes {
+		if len(sideNode) != evmWord {
+			return nil, fmt.Errorf("%w: side node of %d bytes", ErrBadProof, len(sideNode))
+		}
+		if bytes.Equal(sideNode, placeholder) {
+			mask[evmWord-1-i/8] |= 1 << (i % 8)
+			continue
+		}
+		data = append(data, sideNode...)
+	}
+	return data, nil
+}
+
+// UnmarshalEVMProof decodes a proof encoded with MarshalEVM, for a tree with
+// the domain prefixes given by options, if any. The proof has the depth of a
+// tree with 32-byte hashes. ErrBadProof is returned for malformed data.
+func UnmarshalEVMProof(data []byte, options ...VerifyOption) (SparseMerkleProof, error) {
+	if len(data)%evmWord != 0 || len(data) < 3*evmWord {
+		return SparseMerkleProof{}, fmt.Errorf("%w: %d bytes", ErrBadProof, len(data))
+	}
+	numSideNodes, ok := evmUint(data[:evmWord], evmWord*8)
+	if !ok {
+		return SparseMerkleProof{}, fmt.Errorf("%w: number of side nodes out of range", ErrBadProof)
+	}
+	otherLeaf, ok := evmUint(data[2*evmWord:3*evmWord], 1)
+	if !ok {
+		return SparseMerkleProof{}, fmt.Errorf("%w: unknown leaf kind", ErrBadProof)
+	}
+	mask := data[evmWord : 2*evmWord]
+	words := data[3*evmWord:]
+
+	proof := SparseMerkleProof{SideNodes: make([][]byte, numSideNodes), Depth: evmWord * 8}
+	if otherLeaf == 1 {
+		if len(words) < 2*evmWord {
+			return SparseMerkleProof{}, fmt.Errorf("%w: missing leaf data", ErrBadProof)
+		}
+		prefix := leafPrefix
+		if config := newVerifyConfig(options); config.prefixes != nil {
+			prefix = config.prefixes[:1]
+		}
+		proof.NonMembershipLeafData = append(append([]byte{}, prefix...), words[:2*evmWord]...)
+		words = words[2*evmWord:]
+	}
+	for i := 0; i < evmWord*8; i++ {
+		placeholder := mask[evmWord-1-i/8]&(1<<(i%8)) != 0
+		switch {
+		case i >= numSideNodes:
+			if placeholder {
+				return SparseMerkleProof{}, fmt.Errorf("%w: bit mask beyond the side nodes", ErrBadProof)
+			}
+		case placeholder:
+			proof.SideNodes[i] = make([]byte, evmWord)
+		default:
+			if len(words) < evmWord {
+				return SparseMerkleProof{}, fmt.Errorf("%w: missing side nodes", ErrBadProof)
+			}
+			proof.SideNodes[i] = append([]byte{}, words[:evmWord]...)
+			words = words[evmWord:]
+		}
+	}
+	if len(words) != 0 {
+		return SparseMerkleProof{}, fmt.Errorf("%w: %d trailing bytes", ErrBadProof, len(words))
+	}
+	return proof, nil
+}
+
+// putEVMUint writes n to word as a big-endian integer.
+func putEVMUint(word []byte, n int) {
+	for i := len(word) - 1; n > 0; i-- {
+		word[i] = byte(n)
+		n >>= 8
+	}
+}
+
+// evmUint reads a big-endian integer of at most max from word.
+func evmUint(word []byte, max int) (int, bool) {
+	n := 0
+	for _, b := range word {
+		if n > max {
+			return 0, false
+		}
+		n = n<<8 | int(b)
+	}
+	return n, n <= max
+}
@@ -0,0 +1,142 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// evmRoot computes the root from a proof encoded with MarshalEVM as an
+// on-chain verifier would, from the words alone.
+func evmRoot(data []byte, key []byte, value []byte) []byte {
+	keccak := func(parts ...[]byte) []byte {
+		h := sha3.NewLegacyKeccak256()
+		for _, part := range parts {
+			h.Write(part)
+		}
+		return h.Sum(nil)
+	}
+	word := func(i int) []byte { return data[i*32 : (i+1)*32] }
+	numSideNodes := int(new(big.Int).SetBytes(word(0)).Int64())
+	mask := new(big.Int).SetBytes(word(1))
+	next := 3
+	path := keccak(key)
+	var current []byte
+	switch {
+	case new(big.Int).SetBytes(word(2)).Sign() != 0:
+		current = keccak([]byte{0}, word(3), word(4))
+		next = 5
+	case value == nil:
+		current = make([]byte, 32)
+	default:
+		current = keccak([]byte{0}, path, keccak(value))
+	}
+	for i := 0; i < numSideNodes; i++ {
+		sideNode := make([]byte, 32)
+		if mask.Bit(i) == 0 {
+			sideNode = word(next)
+			next++
+		}
+		if getBitAtFromMSB(path, numSideNodes-1-i) == right {
+			current = keccak([]byte{1}, sideNode, current)
+		} else {
+			current = keccak([]byte{1}, current, sideNode)
+		}
+	}
+	return current
+}
+
+func TestMarshalEVM(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha3.NewLegacyKeccak256())
+	for i := 0; i < 30; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	for i := 0; i < 60; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		value, _ := smt.Get(key)
+		proof, _ := smt.Prove(key)
+		data, err := proof.MarshalEVM()
+		if err != nil {
+			t.Fatalf("returned error when encoding proof: %v", err)
+		}
+		if len(data)%32 != 0 {
+			t.Errorf("encoded proof of %d bytes, not whole words", len(data))
+		}
+		if i >= 30 {
+			value = nil
+		}
+		if !bytes.Equal(evmRoot(data, key, value), smt.Root()) {
+			t.Errorf("encoded proof of %s does not compute the root", key)
+		}
+
+		decoded, err := UnmarshalEVMProof(data)
+		if err != nil {
+			t.Fatalf("returned error when decoding proof: %v", err)
+		}
+		if !VerifyProof(decoded, smt.Root(), key, value, sha3.NewLegacyKeccak256()) {
+			t.Errorf("decoded proof of %s failed to verify", key)
+		}
+		if again, _ := decoded.MarshalEVM(); !bytes.Equal(again, data) {
+			t.Errorf("decoded proof of %s encodes differently", key)
+		}
+	}
+
+	// Non-membership leaves keep the domain prefixes given.
+	prefixed := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithDomainPrefixes(0x10, 0x11))
+	for i := 0; i < 30; i++ {
+		prefixed.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte("testValue"))
+	}
+	for i := 0; i < 30; i++ {
+		key := []byte(fmt.Sprintf("absentKey%d", i))
+		proof, _ := prefixed.Prove(key)
+		data, _ := proof.MarshalEVM()
+		decoded, err := UnmarshalEVMProof(data, WithVerifyDomainPrefixes(0x10, 0x11))
+		if err != nil {
+			t.Fatalf("returned error when decoding proof: %v", err)
+		}
+		if !VerifyProof(decoded, prefixed.Root(), key, defaultValue, sha256.New(), WithVerifyDomainPrefixes(0x10, 0x11)) {
+			t.Errorf("decoded proof of %s failed to verify with domain prefixes", key)
+		}
+	}
+}
+
+func TestUnmarshalEVMProofMalformed(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha3.NewLegacyKeccak256())
+	for i := 0; i < 30; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte("testValue"))
+	}
+	proof, _ := smt.Prove([]byte("testKey1"))
+	data, _ := proof.MarshalEVM()
+
+	word := func(n int) []byte {
+		w := make([]byte, 32)
+		w[31] = byte(n)
+		return w
+	}
+	replace := func(i int, w []byte) []byte {
+		d := append([]byte{}, data...)
+		copy(d[i*32:], w)
+		return d
+	}
+	for name, d := range map[string][]byte{
+		"truncated":        data[:len(data)-32],
+		"partial word":     data[:len(data)-1],
+		"trailing word":    append(append([]byte{}, data...), word(0)...),
+		"too deep":         replace(0, append([]byte{1}, make([]byte, 31)...)),
+		"unknown kind":     replace(2, word(2)),
+		"mask beyond side": replace(1, append([]byte{0x80}, make([]byte, 31)...)),
+	} {
+		if _, err := UnmarshalEVMProof(d); !errors.Is(err, ErrBadProof) {
+			t.Errorf("did not return ErrBadProof for %s proof, got %v", name, err)
+		}
+	}
+
+	if _, err := (SparseMerkleProof{SideNodes: [][]byte{make([]byte, 20)}}).MarshalEVM(); !errors.Is(err, ErrBadProof) {
+		t.Errorf("did not return ErrBadProof for proof with 20-byte side node, got %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package smt
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FileStore is a MapStore that keeps each entry in its own file under a base
+// directory, named by the hex encoding of its key. Keys must be non-empty.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates a FileStore in dir, creating the directory if it does
+// not exist. Entries already in the directory are kept.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (fs *FileStore) path(key []byte) string {
+	return filepath.Join(fs.dir, hex.EncodeToString(key))
+}
+
+// Get gets the value for a key.
+func (fs *FileStore) Get(key []byte) ([]byte, error) {
+	value, err := ioutil.ReadFile(fs.path(key))
+	if os.IsNotExist(err) {
+		return nil, &InvalidKeyError{Key: key}
+	}
+	return value, err
+}
+
+// Set updates the value for a key. The value is written to a temporary file
+// that is then renamed into place, so readers never see a partial value.
+func (fs *FileStore) Set(key []byte, value []byte) error {
+	tmp, err := ioutil.TempFile(fs.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(value); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), fs.path(key))
+}
+
+// Delete deletes a key.
+func (fs *FileStore) Delete(key []byte) error {
+	err := os.Remove(fs.path(key))
+	if os.IsNotExist(err) {
+		return &InvalidKeyError{Key: key}
+	}
+	return err
+}
+
+// Export dumps the entries in the directory into a checksummed gob serial, in
+// the same format as SimpleMap.Export. Files whose names are not hex-encoded
+// keys are ignored.
+func (fs *FileStore) Export() ([]byte, error) {
+	files, err := ioutil.ReadDir(fs.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[string][]byte, len(files))
+	for _, file := range files {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		key, err := hex.DecodeString(file.Name())
+		if err != nil || len(key) == 0 {
+			continue
+		}
+		value, err := ioutil.ReadFile(filepath.Join(fs.dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m[string(key)] = value
+	}
+	return encodeSnapshot(m)
+}
@@ -0,0 +1,100 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smt-filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("returned error when creating store: %v", err)
+	}
+
+	_, err = fs.Get([]byte("testKey"))
+	var invalidKeyError *InvalidKeyError
+	if !errors.As(err, &invalidKeyError) {
+		t.Errorf("did not return InvalidKeyError when getting a non-existent key, got %v", err)
+	}
+
+	if err := fs.Set([]byte("testKey"), []byte("testValue")); err != nil {
+		t.Errorf("returned error when setting key: %v", err)
+	}
+	value, err := fs.Get([]byte("testKey"))
+	if err != nil {
+		t.Errorf("returned error when getting key: %v", err)
+	}
+	if !bytes.Equal(value, []byte("testValue")) {
+		t.Error("did not get correct value for key")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "746573744b6579")); err != nil {
+		t.Errorf("did not store key as hex-named file: %v", err)
+	}
+
+	if err := fs.Delete([]byte("testKey")); err != nil {
+		t.Errorf("returned error when deleting key: %v", err)
+	}
+	if _, err := fs.Get([]byte("testKey")); !errors.As(err, &invalidKeyError) {
+		t.Error("failed to delete key")
+	}
+	if err := fs.Delete([]byte("testKey")); !errors.As(err, &invalidKeyError) {
+		t.Error("did not return InvalidKeyError when deleting a non-existent key")
+	}
+}
+
+func TestFileStoreTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smt-filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	nodes, err := NewFileStore(filepath.Join(dir, "nodes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := NewFileStore(filepath.Join(dir, "values"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	smt := NewSparseMerkleTree(nodes, values, sha256.New())
+	smt.Update([]byte("testKey1"), []byte("testValue1"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	smt.Delete([]byte("testKey1"))
+
+	// The exported snapshots import into a tree with the same contents.
+	nodesBytes, err := nodes.Export()
+	if err != nil {
+		t.Fatalf("returned error when exporting nodes: %v", err)
+	}
+	valuesBytes, err := values.Export()
+	if err != nil {
+		t.Fatalf("returned error when exporting values: %v", err)
+	}
+	smn, smv, err := ImportMerkleMap(nodesBytes, valuesBytes)
+	if err != nil {
+		t.Fatalf("returned error when importing: %v", err)
+	}
+	imported := ImportSparseMerkleTree(smn, smv, sha256.New(), smt.Root())
+	value, err := imported.Get([]byte("testKey2"))
+	if err != nil {
+		t.Errorf("returned error when getting imported key: %v", err)
+	}
+	if !bytes.Equal(value, []byte("testValue2")) {
+		t.Error("did not get correct value for imported key")
+	}
+	if len(smn.m) != 1 || len(smv.m) != 1 {
+		t.Errorf("exported %d nodes and %d values, expected 1 of each", len(smn.m), len(smv.m))
+	}
+}
@@ -0,0 +1,336 @@
+package smt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+)
+
+// ErrUnknownFormat is returned for a Format that is not supported.
+var ErrUnknownFormat = errors.New("unknown trie encoding format")
+
+// Format is an encoding of an exported trie, as a TrieWrap.
+type Format int
+
+// Supported formats. FormatGob is the gob encoding of a TrieWrap, as saved
+// with GobEncode, whose stores are map snapshots as exported by
+// SimpleMap.Export. FormatJSON is a JSON object with the schema version, the
+// root, and the nodes and values as objects, with hex keys and values, for
+// consumers outside Go. FormatCanonical is a versioned binary encoding with
+// every field length-prefixed, that encodes the same trie to the same bytes
+// every time, for content-addressed storage and deduplicated checkpoints; it
+// does not depend on gob, which is kept for TrieWraps already saved with it.
+const (
+	FormatGob Format = iota
+	FormatJSON
+	FormatCanonical
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatGob:
+		return "gob"
+	case FormatJSON:
+		return "json"
+	case FormatCanonical:
+		return "canonical"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// trieJSON is the FormatJSON encoding of a TrieWrap.
+type trieJSON struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	Hash          string            `json:"hash,omitempty"`
+	Depth         int               `json:"depth,omitempty"`
+	Root          string            `json:"root"`
+	Nodes         map[string]string `json:"nodes"`
+	Values        map[string]string `json:"values"`
+}
+
+// EncodeTrieWrap encodes wrap, such as one returned by ExportTrie, in format.
+func EncodeTrieWrap(wrap *TrieWrap, format Format) ([]byte, error) {
+	switch format {
+	case FormatGob:
+		return GobEncode(wrap)
+	case FormatJSON:
+		var nodes, values map[string][]byte
+		if err := decodeSnapshot(wrap.NodesBytes, &nodes); err != nil {
+			return nil, err
+		}
+		if err := decodeSnapshot(wrap.ValuesBytes, &values); err != nil {
+			return nil, err
+		}
+		return json.Marshal(trieJSON{
+			SchemaVersion: wrap.SchemaVersion,
+			Hash:          wrap.Hash,
+			Depth:         wrap.Depth,
+			Root:          hex.EncodeToString(wrap.Root),
+			Nodes:         hexMap(nodes),
+			Values:        hexMap(values),
+		})
+	case FormatCanonical:
+		return encodeCanonicalTrieWrap(wrap)
+	}
+	return nil, fmt.Errorf("%w: %v", ErrUnknownFormat, format)
+}
+
+// DecodeTrieWrap decodes a TrieWrap encoded in format, to be imported with
+// ImportTrie.
+func DecodeTrieWrap(data []byte, format Format) (*TrieWrap, error) {
+	switch format {
+	case FormatGob:
+		var wrap TrieWrap
+		if err := GobDecode(data, &wrap); err != nil {
+			return nil, err
+		}
+		return &wrap, nil
+	case FormatJSON:
+		var t trieJSON
+		if err := json.Unmarshal(data, &t); err != nil {
+			return nil, err
+		}
+		root, err := hex.DecodeString(t.Root)
+		if err != nil {
+			return nil, err
+		}
+		nodes, err := unhexMap(t.Nodes)
+		if err != nil {
+			return nil, err
+		}
+		values, err := unhexMap(t.Values)
+		if err != nil {
+			return nil, err
+		}
+		wrap := TrieWrap{Root: root, SchemaVersion: t.SchemaVersion, Hash: t.Hash, Depth: t.Depth}
+		if wrap.NodesBytes, err = encodeSnapshot(nodes); err != nil {
+			return nil, err
+		}
+		if wrap.ValuesBytes, err = encodeSnapshot(values); err != nil {
+			return nil, err
+		}
+		return &wrap, nil
+	case FormatCanonical:
+		return decodeCanonicalTrieWrap(data)
+	}
+	return nil, fmt.Errorf("%w: %v", ErrUnknownFormat, format)
+}
+
+// canonicalMagic identifies a TrieWrap in FormatCanonical.
+var canonicalMagic = []byte("SMTW")
+
+// canonicalVersion is the format version of new FormatCanonical encodings.
+// Version 1 encodings, which do not record the depth, counts or checksums of
+// the stores, are still decoded.
+const canonicalVersion = 2
+
+// FormatCanonical layout, with lengths, counts and the schema version and
+// depth as uvarints:
+//
+//	magic, one byte of format version
+//	schema version, depth, then the numbers of nodes and of values
+//	the hash name and root, each length-prefixed
+//	the nodes and values, each length-prefixed and followed by its
+//	big-endian CRC32 (IEEE)
+//	the big-endian CRC32 (IEEE) of everything before it
+//
+// Version 1 has no depth, counts or store checksums. The nodes and values are
+// map snapshots, whose current version is itself canonical; those of older
+// versions are upgraded when encoded, so that the encoding only depends on
+// the contents of the trie. The counts are checked against the snapshots when
+// decoded, so that an encoding whose stores were truncated before it was
+// checksummed is still caught.
+func encodeCanonicalTrieWrap(wrap *TrieWrap) ([]byte, error) {
+	nodes, err := UpgradeSnapshot(wrap.NodesBytes)
+	if err != nil {
+		return nil, err
+	}
+	values, err := UpgradeSnapshot(wrap.ValuesBytes)
+	if err != nil {
+		return nil, err
+	}
+	data := append(append([]byte{}, canonicalMagic...), canonicalVersion)
+	for _, n := range []int{wrap.SchemaVersion, wrap.Depth, snapshotLen(nodes), snapshotLen(values)} {
+		data = appendUvarint(data, uint64(n))
+	}
+	for _, field := range [][]byte{[]byte(wrap.Hash), wrap.Root} {
+		data = appendUvarint(data, uint64(len(field)))
+		data = append(data, field...)
+	}
+	for _, store := range [][]byte{nodes, values} {
+		data = appendUvarint(data, uint64(len(store)))
+		data = append(data, store...)
+		data = appendChecksum(data, store)
+	}
+	return appendChecksum(data, data), nil
+}
+
+// appendChecksum appends the big-endian CRC32 (IEEE) of data to b.
+func appendChecksum(b, data []byte) []byte {
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.ChecksumIEEE(data))
+	return append(b, sum[:]...)
+}
+
+// snapshotLen returns the number of entries of a snapshot of the current
+// version, as it records them ahead of its entries.
+func snapshotLen(serial []byte) int {
+	n, _ := binary.Uvarint(serial[snapshotHeaderSize:])
+	return int(n)
+}
+
+func decodeCanonicalTrieWrap(data []byte) (*TrieWrap, error) {
+	if len(data) < len(canonicalMagic)+1+4 || !bytes.HasPrefix(data, canonicalMagic) {
+		return nil, ErrSnapshotCorrupt
+	}
+	version := data[len(canonicalMagic)]
+	if version < 1 || version > canonicalVersion {
+		return nil, fmt.Errorf("%w: %d", ErrSnapshotVersion, version)
+	}
+	body, sum := data[:len(data)-4], data[len(data)-4:]
+	if binary.BigEndian.Uint32(sum) != crc32.ChecksumIEEE(body) {
+		return nil, ErrSnapshotChecksum
+	}
+	body = body[len(canonicalMagic)+1:]
+	// ok is false once body is short or malformed.
+	ok := true
+	uvarint := func() uint64 {
+		n, size := binary.Uvarint(body)
+		if size <= 0 {
+			ok = false
+			return 0
+		}
+		body = body[size:]
+		return n
+	}
+	field := func() []byte {
+		n := uvarint()
+		if !ok || n > uint64(len(body)) {
+			ok = false
+			return nil
+		}
+		f := append([]byte{}, body[:n]...)
+		body = body[n:]
+		return f
+	}
+	checksum := func(f []byte) bool {
+		if !ok || len(body) < 4 {
+			ok = false
+			return false
+		}
+		sum := binary.BigEndian.Uint32(body)
+		body = body[4:]
+		return sum == crc32.ChecksumIEEE(f)
+	}
+
+	schemaVersion := uvarint()
+	if ok && schemaVersion > uint64(trieWrapSchemaVersion) {
+		return nil, fmt.Errorf("%w: %d", ErrTrieWrapVersion, schemaVersion)
+	}
+	wrap := TrieWrap{SchemaVersion: int(schemaVersion)}
+	if version == 1 {
+		wrap.Hash, wrap.Root = string(field()), field()
+		wrap.NodesBytes, wrap.ValuesBytes = field(), field()
+		if !ok || len(body) != 0 {
+			return nil, ErrSnapshotCorrupt
+		}
+		return &wrap, nil
+	}
+
+	depth, nodeCount, valueCount := uvarint(), uvarint(), uvarint()
+	if depth > math.MaxInt32 {
+		ok = false
+	}
+	wrap.Depth = int(depth)
+	wrap.Hash, wrap.Root = string(field()), field()
+	wrap.NodesBytes = field()
+	nodesValid := checksum(wrap.NodesBytes)
+	wrap.ValuesBytes = field()
+	valuesValid := checksum(wrap.ValuesBytes)
+	if !ok || len(body) != 0 {
+		return nil, ErrSnapshotCorrupt
+	}
+	if !nodesValid || !valuesValid {
+		return nil, ErrSnapshotChecksum
+	}
+	for _, store := range []struct {
+		serial []byte
+		count  uint64
+	}{{wrap.NodesBytes, nodeCount}, {wrap.ValuesBytes, valueCount}} {
+		var m map[string][]byte
+		if err := decodeSnapshot(store.serial, &m); err != nil {
+			return nil, err
+		}
+		if uint64(len(m)) != store.count {
+			return nil, fmt.Errorf("%w: %d entries, recorded %d", ErrSnapshotCorrupt, len(m), store.count)
+		}
+	}
+	return &wrap, nil
+}
+
+// MigrateTrieWrap migrates an exported trie, in FormatGob as saved by
+// applications before FormatCanonical, or in FormatCanonical of any version,
+// to the current FormatCanonical, so that it records its hash algorithm and
+// depth and is checksummed. The trie is imported as ImportTrie would, with
+// options, and exported again: give WithPathBits for a trie of fewer path
+// bits than its hash whose TrieWrap does not record its depth. Already
+// migrated tries are checked and migrated again to the same bytes.
+func MigrateTrieWrap(data []byte, options ...Option) ([]byte, error) {
+	format := FormatGob
+	if bytes.HasPrefix(data, canonicalMagic) {
+		format = FormatCanonical
+	}
+	wrap, err := DecodeTrieWrap(data, format)
+	if err != nil {
+		return nil, err
+	}
+	trie, err := ImportTrie(wrap, options...)
+	if err != nil {
+		return nil, err
+	}
+	migrated, err := ExportTrie(trie)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeTrieWrap(migrated, FormatCanonical)
+}
+
+// ConvertSnapshot converts an exported trie encoded in one format to another,
+// decoding its root, nodes and values and encoding them again, without
+// building a tree, e.g. to migrate stored snapshots in bulk offline.
+func ConvertSnapshot(in []byte, from Format, to Format) ([]byte, error) {
+	wrap, err := DecodeTrieWrap(in, from)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeTrieWrap(wrap, to)
+}
+
+func hexMap(m map[string][]byte) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[hex.EncodeToString([]byte(k))] = hex.EncodeToString(v)
+	}
+	return out
+}
+
+func unhexMap(m map[string]string) (map[string][]byte, error) {
+	out := make(map[string][]byte, len(m))
+	for k, v := range m {
+		key, err := hex.DecodeString(k)
+		if err != nil {
+			return nil, err
+		}
+		value, err := hex.DecodeString(v)
+		if err != nil {
+			return nil, err
+		}
+		out[string(key)] = value
+	}
+	return out, nil
+}
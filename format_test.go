@@ -0,0 +1,215 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"testing"
+)
+
+func TestConvertSnapshot(t *testing.T) {
+	trie := NewMerkleTrie()
+	for i := 0; i < 20; i++ {
+		trie.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	wrap, _ := ExportTrie(trie)
+	gob, err := EncodeTrieWrap(wrap, FormatGob)
+	if err != nil {
+		t.Fatalf("returned error when encoding trie: %v", err)
+	}
+
+	converted, err := ConvertSnapshot(gob, FormatGob, FormatJSON)
+	if err != nil {
+		t.Fatalf("returned error when converting to JSON: %v", err)
+	}
+	back, err := ConvertSnapshot(converted, FormatJSON, FormatGob)
+	if err != nil {
+		t.Fatalf("returned error when converting back to gob: %v", err)
+	}
+	for _, c := range []struct {
+		data   []byte
+		format Format
+	}{{converted, FormatJSON}, {back, FormatGob}} {
+		wrap, err := DecodeTrieWrap(c.data, c.format)
+		if err != nil {
+			t.Fatalf("returned error when decoding %v trie: %v", c.format, err)
+		}
+		imported, err := ImportTrie(wrap)
+		if err != nil {
+			t.Fatalf("returned error when importing %v trie: %v", c.format, err)
+		}
+		if !bytes.Equal(imported.Root(), trie.Root()) {
+			t.Errorf("%v trie has a different root", c.format)
+		}
+		if value, _ := imported.Get([]byte("testKey7")); !bytes.Equal(value, []byte("testValue7")) {
+			t.Errorf("%v trie lost a value", c.format)
+		}
+	}
+
+	if _, err := ConvertSnapshot(gob, FormatGob, Format(9)); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("did not return ErrUnknownFormat, got %v", err)
+	}
+}
+
+func TestCanonicalFormat(t *testing.T) {
+	build := func(reversed bool) *SparseMerkleTree {
+		trie := NewMerkleTrie()
+		for i := 0; i < 30; i++ {
+			j := i
+			if reversed {
+				j = 29 - i
+			}
+			trie.Update([]byte(fmt.Sprintf("testKey%d", j)), []byte(fmt.Sprintf("testValue%d", j)))
+		}
+		return trie
+	}
+	trie := build(false)
+	wrap, _ := ExportTrie(trie)
+	data, err := EncodeTrieWrap(wrap, FormatCanonical)
+	if err != nil {
+		t.Fatalf("returned error when encoding trie: %v", err)
+	}
+	otherWrap, _ := ExportTrie(build(true))
+	if other, _ := EncodeTrieWrap(otherWrap, FormatCanonical); !bytes.Equal(data, other) {
+		t.Error("encodings of the same trie differ")
+	}
+	a, _ := EncodeTrieWrap(wrap, FormatGob)
+	b, _ := EncodeTrieWrap(otherWrap, FormatGob)
+	if !bytes.Equal(a, b) {
+		t.Error("gob encodings of the same trie differ")
+	}
+
+	decoded, err := DecodeTrieWrap(data, FormatCanonical)
+	if err != nil {
+		t.Fatalf("returned error when decoding trie: %v", err)
+	}
+	if decoded.SchemaVersion != wrap.SchemaVersion || decoded.Hash != wrap.Hash {
+		t.Errorf("decoded schema version %d and hash %q, want %d and %q", decoded.SchemaVersion, decoded.Hash, wrap.SchemaVersion, wrap.Hash)
+	}
+	imported, err := ImportTrie(decoded)
+	if err != nil {
+		t.Fatalf("returned error when importing trie: %v", err)
+	}
+	if !bytes.Equal(imported.Root(), trie.Root()) {
+		t.Error("imported trie has a different root")
+	}
+	if value, _ := imported.Get([]byte("testKey7")); !bytes.Equal(value, []byte("testValue7")) {
+		t.Error("imported trie lost a value")
+	}
+
+	// A TrieWrap with legacy gob snapshots encodes the same as a current one.
+	var nodes, values map[string][]byte
+	decodeSnapshot(wrap.NodesBytes, &nodes)
+	decodeSnapshot(wrap.ValuesBytes, &values)
+	legacy := *wrap
+	legacy.NodesBytes, _ = GobEncode(nodes)
+	legacy.ValuesBytes, _ = GobEncode(values)
+	gob, _ := EncodeTrieWrap(&legacy, FormatGob)
+	if converted, err := ConvertSnapshot(gob, FormatGob, FormatCanonical); err != nil || !bytes.Equal(converted, data) {
+		t.Errorf("converted legacy trie differs from a current encoding, %v", err)
+	}
+
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(corrupted)/2] ^= 1
+	if _, err := DecodeTrieWrap(corrupted, FormatCanonical); !errors.Is(err, ErrSnapshotChecksum) {
+		t.Errorf("did not return ErrSnapshotChecksum, got %v", err)
+	}
+	if _, err := DecodeTrieWrap(gob, FormatCanonical); !errors.Is(err, ErrSnapshotCorrupt) {
+		t.Errorf("did not return ErrSnapshotCorrupt for a gob encoding, got %v", err)
+	}
+	unsupported := append([]byte(nil), data...)
+	unsupported[len(canonicalMagic)] = canonicalVersion + 1
+	if _, err := DecodeTrieWrap(unsupported, FormatCanonical); !errors.Is(err, ErrSnapshotVersion) {
+		t.Errorf("did not return ErrSnapshotVersion, got %v", err)
+	}
+}
+
+func TestCanonicalFormatCounts(t *testing.T) {
+	trie := NewMerkleTrie()
+	for i := 0; i < 20; i++ {
+		trie.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	wrap, _ := ExportTrie(trie)
+	data, _ := EncodeTrieWrap(wrap, FormatCanonical)
+
+	// The node count follows the schema version and depth; one more than the
+	// nodes there are is caught even with the checksum recomputed.
+	offset := len(canonicalMagic) + 1
+	for i := 0; i < 2; i++ {
+		_, size := binary.Uvarint(data[offset:])
+		offset += size
+	}
+	if data[offset] >= 0x7f {
+		t.Fatalf("node count %d does not fit a byte", data[offset])
+	}
+	miscounted := append([]byte(nil), data...)
+	miscounted[offset]++
+	body := miscounted[:len(miscounted)-4]
+	binary.BigEndian.PutUint32(miscounted[len(body):], crc32.ChecksumIEEE(body))
+	if _, err := DecodeTrieWrap(miscounted, FormatCanonical); !errors.Is(err, ErrSnapshotCorrupt) {
+		t.Errorf("got %v for a wrong node count, want ErrSnapshotCorrupt", err)
+	}
+}
+
+func TestMigrateTrieWrap(t *testing.T) {
+	trie := NewMerkleTrie()
+	for i := 0; i < 20; i++ {
+		trie.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	wrap, _ := ExportTrie(trie)
+	want, _ := EncodeTrieWrap(wrap, FormatCanonical)
+
+	// A TrieWrap saved before the schema version was recorded, with legacy
+	// gob snapshots.
+	var nodes, values map[string][]byte
+	decodeSnapshot(wrap.NodesBytes, &nodes)
+	decodeSnapshot(wrap.ValuesBytes, &values)
+	legacyNodes, _ := GobEncode(nodes)
+	legacyValues, _ := GobEncode(values)
+	legacy, _ := GobEncode(struct {
+		Root        []byte
+		NodesBytes  []byte
+		ValuesBytes []byte
+	}{wrap.Root, legacyNodes, legacyValues})
+
+	// A version 1 FormatCanonical encoding, without depth, counts or store
+	// checksums.
+	v1 := append(append([]byte{}, canonicalMagic...), 1)
+	v1 = appendUvarint(v1, 2)
+	for _, field := range [][]byte{[]byte(wrap.Hash), wrap.Root, wrap.NodesBytes, wrap.ValuesBytes} {
+		v1 = appendUvarint(v1, uint64(len(field)))
+		v1 = append(v1, field...)
+	}
+	v1 = appendChecksum(v1, v1)
+
+	for name, data := range map[string][]byte{"legacy gob": legacy, "canonical v1": v1, "current": want} {
+		migrated, err := MigrateTrieWrap(data)
+		if err != nil {
+			t.Fatalf("returned error when migrating %s trie: %v", name, err)
+		}
+		if !bytes.Equal(migrated, want) {
+			t.Errorf("migrated %s trie differs from a current encoding", name)
+		}
+	}
+	if _, err := MigrateTrieWrap(want[:len(want)-1]); err == nil {
+		t.Error("did not return error for a truncated trie")
+	}
+
+	// The depth of a legacy trie of fewer path bits comes from the options.
+	short := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithPathBits(160))
+	short.Update([]byte("testKey"), []byte("testValue"))
+	shortWrap, _ := ExportTrie(short)
+	shortWrap.SchemaVersion, shortWrap.Depth = 2, 0
+	gob, _ := EncodeTrieWrap(shortWrap, FormatGob)
+	migrated, err := MigrateTrieWrap(gob, WithPathBits(160))
+	if err != nil {
+		t.Fatalf("returned error when migrating trie: %v", err)
+	}
+	decoded, err := DecodeTrieWrap(migrated, FormatCanonical)
+	if err != nil || decoded.Depth != 160 || decoded.SchemaVersion != trieWrapSchemaVersion {
+		t.Errorf("migrated trie decoded with depth %d and schema version %d, %v", decoded.Depth, decoded.SchemaVersion, err)
+	}
+}
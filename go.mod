@@ -1,5 +1,7 @@
 module github.com/causevest/smt
 
-go 1.14
+go 1.18
 
-require golang.org/x/crypto v0.0.0-20210920023735-84f357641f63
+require golang.org/x/crypto v0.2.0
+
+require golang.org/x/sys v0.4.0 // indirect
@@ -0,0 +1,108 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
+)
+
+// ErrUnknownHash is returned for a hash algorithm that is not registered; see
+// RegisterHash.
+var ErrUnknownHash = errors.New("unknown hash algorithm")
+
+// ErrHashMismatch is returned by ImportTrieWithHasher for a TrieWrap of a
+// trie exported with another hash algorithm than the one given.
+var ErrHashMismatch = errors.New("trie was exported with another hash algorithm")
+
+// Names of the hash algorithms registered by default.
+const (
+	HashSHA3_256   = "sha3-256"
+	HashSHA256     = "sha256"
+	HashBlake2b256 = "blake2b-256"
+	// HashKeccak256 is the legacy Keccak-256 of Ethereum, which differs from
+	// SHA3-256 in its padding.
+	HashKeccak256 = "keccak256"
+)
+
+// hashes are the registered hash algorithms, by name.
+var hashes = struct {
+	sync.RWMutex
+	m map[string]func() hash.Hash
+}{m: map[string]func() hash.Hash{
+	HashSHA3_256: sha3.New256,
+	HashSHA256:   sha256.New,
+	HashBlake2b256: func() hash.Hash {
+		h, _ := blake2b.New256(nil)
+		return h
+	},
+	HashKeccak256: sha3.NewLegacyKeccak256,
+}}
+
+// RegisterHash registers the hash algorithm whose hashes newHash creates
+// under name, so that ExportTrie records the name in the TrieWraps of tries
+// hashed with it, and ImportTrie and NewMerkleTrieWithHash find it by name.
+// It panics if name is empty or already registered, or newHash is nil.
+func RegisterHash(name string, newHash func() hash.Hash) {
+	if name == "" || newHash == nil {
+		panic("smt: invalid hash registration")
+	}
+	hashes.Lock()
+	defer hashes.Unlock()
+	if _, ok := hashes.m[name]; ok {
+		panic(fmt.Sprintf("smt: hash %q already registered", name))
+	}
+	hashes.m[name] = newHash
+}
+
+// NewHash returns a new hash of the algorithm registered under name, or
+// ErrUnknownHash if there is none.
+func NewHash(name string) (hash.Hash, error) {
+	hashes.RLock()
+	newHash, ok := hashes.m[name]
+	hashes.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownHash, name)
+	}
+	return newHash(), nil
+}
+
+// hashProbe is hashed to tell which registered algorithm a hasher computes.
+var hashProbe = []byte("smt hash probe")
+
+// hashName returns the name of the registered algorithm the tree hashes with,
+// found by comparing digests of a probe, or "" if it is none of them.
+func (th *treeHasher) hashName() string {
+	digest := th.sum(hashProbe)
+	hashes.RLock()
+	defer hashes.RUnlock()
+	names := make([]string, 0, len(hashes.m))
+	for name := range hashes.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		h := hashes.m[name]()
+		h.Write(hashProbe)
+		if bytes.Equal(h.Sum(nil), digest) {
+			return name
+		}
+	}
+	return ""
+}
+
+// NewMerkleTrieWithHash makes a new trie like NewMerkleTrie, hashed with the
+// algorithm registered under name.
+func NewMerkleTrieWithHash(name string) (*SparseMerkleTree, error) {
+	hasher, err := NewHash(name)
+	if err != nil {
+		return nil, err
+	}
+	return NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), hasher), nil
+}
@@ -0,0 +1,113 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/sha3"
+)
+
+func TestHashRegistry(t *testing.T) {
+	for _, name := range []string{HashSHA3_256, HashSHA256, HashBlake2b256, HashKeccak256} {
+		trie, err := NewMerkleTrieWithHash(name)
+		if err != nil {
+			t.Fatalf("returned error when creating trie with %s: %v", name, err)
+		}
+		for i := 0; i < 10; i++ {
+			trie.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+		}
+		wrap, err := ExportTrie(trie)
+		if err != nil {
+			t.Fatalf("returned error when exporting: %v", err)
+		}
+		if wrap.Hash != name {
+			t.Errorf("exported trie hashed with %s as %q", name, wrap.Hash)
+		}
+		for _, format := range []Format{FormatGob, FormatJSON} {
+			data, err := EncodeTrieWrap(wrap, format)
+			if err != nil {
+				t.Fatalf("returned error when encoding: %v", err)
+			}
+			decoded, err := DecodeTrieWrap(data, format)
+			if err != nil {
+				t.Fatalf("returned error when decoding: %v", err)
+			}
+			imported, err := ImportTrie(decoded)
+			if err != nil {
+				t.Fatalf("returned error when importing trie hashed with %s: %v", name, err)
+			}
+			// The imported trie hashes new leaves as the original does.
+			expected, _ := trie.Update([]byte("newKey"), []byte("newValue"))
+			if root, _ := imported.Update([]byte("newKey"), []byte("newValue")); !bytes.Equal(root, expected) {
+				t.Errorf("trie hashed with %s imported in %v with another hash", name, format)
+			}
+			trie.Delete([]byte("newKey"))
+		}
+	}
+	if _, err := NewMerkleTrieWithHash("md4"); !errors.Is(err, ErrUnknownHash) {
+		t.Errorf("did not return ErrUnknownHash for unknown hash, got %v", err)
+	}
+}
+
+func TestImportTrieWithHasher(t *testing.T) {
+	trie := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha512.New512_256())
+	trie.Update([]byte("testKey"), []byte("testValue"))
+	wrap, _ := ExportTrie(trie)
+	if wrap.Hash != "" {
+		t.Errorf("exported trie of unregistered hash as %q", wrap.Hash)
+	}
+	if _, err := ImportTrie(wrap); !errors.Is(err, ErrUnknownHash) {
+		t.Errorf("did not return ErrUnknownHash for unregistered hash, got %v", err)
+	}
+	imported, err := ImportTrieWithHasher(wrap, sha512.New512_256())
+	if err != nil {
+		t.Fatalf("returned error when importing with hasher: %v", err)
+	}
+	if value, err := imported.Get([]byte("testKey")); err != nil || !bytes.Equal(value, []byte("testValue")) {
+		t.Errorf("did not get value from trie imported with hasher, got %s, %v", value, err)
+	}
+
+	// The hasher must be that of a trie of a registered hash.
+	trie = NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	trie.Update([]byte("testKey"), []byte("testValue"))
+	wrap, _ = ExportTrie(trie)
+	if _, err := ImportTrieWithHasher(wrap, sha3.New256()); !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("did not return ErrHashMismatch for other hasher, got %v", err)
+	}
+	if _, err := ImportTrieWithHasher(wrap, sha256.New()); err != nil {
+		t.Errorf("returned error when importing with the trie's hasher: %v", err)
+	}
+
+	// Wraps from before the hash was recorded are of SHA3-256 tries.
+	wrap.SchemaVersion, wrap.Hash = 1, ""
+	if _, err := ImportTrieWithHasher(wrap, sha256.New()); !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("did not return ErrHashMismatch for version 1 wrap, got %v", err)
+	}
+}
+
+func TestRegisterHash(t *testing.T) {
+	RegisterHash("sha512-256", sha512.New512_256)
+	trie := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha512.New512_256())
+	trie.Update([]byte("testKey"), []byte("testValue"))
+	wrap, _ := ExportTrie(trie)
+	if wrap.Hash != "sha512-256" {
+		t.Errorf("exported trie of registered hash as %q", wrap.Hash)
+	}
+	if _, err := ImportTrie(wrap); err != nil {
+		t.Errorf("returned error when importing trie of registered hash: %v", err)
+	}
+	hashes.Lock()
+	delete(hashes.m, "sha512-256")
+	hashes.Unlock()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("did not panic when registering a hash twice")
+		}
+	}()
+	RegisterHash(HashSHA256, sha256.New)
+}
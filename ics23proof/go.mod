@@ -0,0 +1,16 @@
+module github.com/causevest/smt/ics23proof
+
+go 1.18
+
+require (
+	github.com/causevest/smt v0.0.0-00010101000000-000000000000
+	github.com/cosmos/ics23/go v0.10.0
+)
+
+require (
+	github.com/cosmos/gogoproto v1.4.3 // indirect
+	golang.org/x/crypto v0.2.0 // indirect
+	golang.org/x/sys v0.4.0 // indirect
+)
+
+replace github.com/causevest/smt => ..
@@ -0,0 +1,183 @@
+// Package ics23proof converts the proofs of a tree to ICS23 commitment
+// proofs, so that IBC light clients can verify them against the tree's root
+// with the proof spec of the tree, without a verifier of their own.
+package ics23proof
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	ics23 "github.com/cosmos/ics23/go"
+
+	"github.com/causevest/smt"
+)
+
+// ErrEmptyTree is returned when proving a key absent from an empty tree, as
+// ICS23 proves absence by the leaves next to the key, of which there are none.
+var ErrEmptyTree = errors.New("cannot prove absence in an empty tree")
+
+// ErrSpecMismatch is returned when a converted proof does not compute the
+// root of the tree, as for a tree not hashed with SHA-256 and the domain
+// prefixes of the Prover, or one updated while proving.
+var ErrSpecMismatch = errors.New("converted proof does not compute the root")
+
+// Default domain prefixes of leaves and nodes, as in package smt.
+const (
+	defaultLeafPrefix = 0
+	defaultNodePrefix = 1
+)
+
+// Prover generates ICS23 proofs of the keys of a tree. The tree must be hashed
+// with SHA-256, and created with smt.WithKeyStore to prove keys absent, as an
+// ICS23 non-existence proof holds the proofs of the keys next to the absent
+// one. A Prover is safe for concurrent use if its tree is.
+type Prover struct {
+	tree                   *smt.SparseMerkleTree
+	leafPrefix, nodePrefix byte
+}
+
+// Option is a function that configures a Prover.
+type Option func(*Prover)
+
+// WithDomainPrefixes converts the proofs of a tree created with
+// smt.WithDomainPrefixes and the same prefixes.
+func WithDomainPrefixes(leaf byte, node byte) Option {
+	return func(p *Prover) {
+		p.leafPrefix, p.nodePrefix = leaf, node
+	}
+}
+
+// NewProver creates a Prover of the keys of tree.
+func NewProver(tree *smt.SparseMerkleTree, options ...Option) *Prover {
+	if tree == nil {
+		panic("ics23proof: nil tree")
+	}
+	p := &Prover{tree: tree, leafPrefix: defaultLeafPrefix, nodePrefix: defaultNodePrefix}
+	for _, option := range options {
+		option(p)
+	}
+	return p
+}
+
+// Spec returns the ICS23 proof spec the proofs of the Prover are verified
+// with. With the default domain prefixes, it is ics23.SmtSpec.
+func (p *Prover) Spec() *ics23.ProofSpec {
+	return &ics23.ProofSpec{
+		LeafSpec: &ics23.LeafOp{
+			Hash:         ics23.HashOp_SHA256,
+			PrehashKey:   ics23.HashOp_SHA256,
+			PrehashValue: ics23.HashOp_SHA256,
+			Length:       ics23.LengthOp_NO_PREFIX,
+			Prefix:       []byte{p.leafPrefix},
+		},
+		InnerSpec: &ics23.InnerSpec{
+			ChildOrder:      []int32{0, 1},
+			ChildSize:       sha256.Size,
+			MinPrefixLength: 1,
+			MaxPrefixLength: 1,
+			EmptyChild:      make([]byte, sha256.Size),
+			Hash:            ics23.HashOp_SHA256,
+		},
+		MaxDepth:                   sha256.Size * 8,
+		PrehashKeyBeforeComparison: true,
+	}
+}
+
+// Prove generates an ICS23 proof of key against the current root of the tree:
+// an existence proof of its value if it is present, or a non-existence proof
+// from the existence proofs of its neighbors, see smt.Neighbors, if not.
+func (p *Prover) Prove(key []byte) (*ics23.CommitmentProof, error) {
+	root := p.tree.Root()
+	has, err := p.tree.Has(key)
+	if err != nil {
+		return nil, err
+	}
+	if has {
+		exist, err := p.proveExistence(key, root)
+		if err != nil {
+			return nil, err
+		}
+		return &ics23.CommitmentProof{Proof: &ics23.CommitmentProof_Exist{Exist: exist}}, nil
+	}
+
+	left, right, err := p.tree.NeighborsForRoot(key, root)
+	if err != nil {
+		return nil, err
+	}
+	if left == nil && right == nil {
+		return nil, ErrEmptyTree
+	}
+	nonExist := &ics23.NonExistenceProof{Key: key}
+	if left != nil {
+		if nonExist.Left, err = p.proveExistence(left, root); err != nil {
+			return nil, err
+		}
+	}
+	if right != nil {
+		if nonExist.Right, err = p.proveExistence(right, root); err != nil {
+			return nil, err
+		}
+	}
+	return &ics23.CommitmentProof{Proof: &ics23.CommitmentProof_Nonexist{Nonexist: nonExist}}, nil
+}
+
+// proveExistence generates an ICS23 existence proof of a present key against
+// root, checking that it computes root.
+func (p *Prover) proveExistence(key []byte, root []byte) (*ics23.ExistenceProof, error) {
+	value, err := p.tree.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	proof, err := p.tree.ProveForRoot(key, root)
+	if err != nil {
+		return nil, err
+	}
+	exist, err := p.ConvertExistenceProof(proof, key, value)
+	if err != nil {
+		return nil, err
+	}
+	computed, err := exist.Calculate()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(computed, root) {
+		return nil, fmt.Errorf("%w: got %x for key %x, expected %x", ErrSpecMismatch, computed, key, root)
+	}
+	return exist, nil
+}
+
+// ConvertExistenceProof converts a membership proof generated by Prove of the
+// tree, that key has value, to an ICS23 existence proof. smt.ErrBadProof is
+// returned for a non-membership proof.
+func (p *Prover) ConvertExistenceProof(proof smt.SparseMerkleProof, key []byte, value []byte) (*ics23.ExistenceProof, error) {
+	if proof.NonMembershipLeafData != nil {
+		return nil, smt.ErrBadProof
+	}
+	spec := p.Spec()
+	path := sha256.Sum256(key)
+	exist := &ics23.ExistenceProof{
+		Key:   key,
+		Value: value,
+		Leaf:  spec.LeafSpec,
+		Path:  make([]*ics23.InnerOp, len(proof.SideNodes)),
+	}
+	// Side nodes run from the leaf up, as the steps of ICS23 paths do.
+	for i, sideNode := range proof.SideNodes {
+		op := &ics23.InnerOp{Hash: ics23.HashOp_SHA256}
+		if pathBit(path[:], len(proof.SideNodes)-1-i) {
+			op.Prefix = append([]byte{p.nodePrefix}, sideNode...)
+		} else {
+			op.Prefix, op.Suffix = []byte{p.nodePrefix}, append([]byte{}, sideNode...)
+		}
+		exist.Path[i] = op
+	}
+	return exist, nil
+}
+
+// pathBit returns true if the bit of path at depth, most significant first, is
+// set, i.e. if the path goes right at depth.
+func pathBit(path []byte, depth int) bool {
+	return path[depth/8]&(1<<(7-uint(depth%8))) != 0
+}
@@ -0,0 +1,117 @@
+package ics23proof
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+
+	ics23 "github.com/cosmos/ics23/go"
+
+	"github.com/causevest/smt"
+)
+
+func newTree(options ...smt.Option) *smt.SparseMerkleTree {
+	options = append(options, smt.WithKeyStore(smt.NewSimpleMap()))
+	return smt.NewSparseMerkleTree(smt.NewSimpleMap(), smt.NewSimpleMap(), sha256.New(), options...)
+}
+
+func TestProver(t *testing.T) {
+	tree := newTree()
+	p := NewProver(tree)
+	if !p.Spec().SpecEquals(ics23.SmtSpec) {
+		t.Error("spec with default prefixes differs from ics23.SmtSpec")
+	}
+	if _, err := p.Prove([]byte("testKey")); !errors.Is(err, ErrEmptyTree) {
+		t.Errorf("did not return ErrEmptyTree for empty tree, got %v", err)
+	}
+
+	for i := 0; i < 50; i++ {
+		tree.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	root := tree.Root()
+	for i := 0; i < 50; i++ {
+		key, value := []byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i))
+		proof, err := p.Prove(key)
+		if err != nil {
+			t.Fatalf("returned error when proving present key: %v", err)
+		}
+		if !ics23.VerifyMembership(p.Spec(), root, proof, key, value) {
+			t.Errorf("existence proof of %s failed to verify", key)
+		}
+		if ics23.VerifyMembership(p.Spec(), root, proof, key, []byte("otherValue")) {
+			t.Errorf("existence proof of %s verified for another value", key)
+		}
+	}
+
+	// Absent keys, including some before the first key and after the last.
+	var leftmost, rightmost int
+	for i := 0; i < 200; i++ {
+		key := []byte(fmt.Sprintf("absentKey%d", i))
+		proof, err := p.Prove(key)
+		if err != nil {
+			t.Fatalf("returned error when proving absent key: %v", err)
+		}
+		if !ics23.VerifyNonMembership(p.Spec(), root, proof, key) {
+			t.Errorf("non-existence proof of %s failed to verify", key)
+		}
+		if ics23.VerifyNonMembership(p.Spec(), root, proof, []byte("testKey1")) {
+			t.Errorf("non-existence proof of %s verified for present key", key)
+		}
+		nonExist := proof.GetNonexist()
+		if nonExist.Left == nil {
+			leftmost++
+		}
+		if nonExist.Right == nil {
+			rightmost++
+		}
+	}
+	if leftmost == 0 || rightmost == 0 {
+		t.Errorf("proved %d keys before the first and %d after the last, expected some of each", leftmost, rightmost)
+	}
+}
+
+func TestProverDomainPrefixes(t *testing.T) {
+	tree := newTree(smt.WithDomainPrefixes(0x10, 0x11))
+	for i := 0; i < 20; i++ {
+		tree.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte("testValue"))
+	}
+
+	p := NewProver(tree, WithDomainPrefixes(0x10, 0x11))
+	for _, key := range []string{"testKey3", "absentKey"} {
+		proof, err := p.Prove([]byte(key))
+		if err != nil {
+			t.Fatalf("returned error when proving %s: %v", key, err)
+		}
+		if !ics23.VerifyMembership(p.Spec(), tree.Root(), proof, []byte(key), []byte("testValue")) &&
+			!ics23.VerifyNonMembership(p.Spec(), tree.Root(), proof, []byte(key)) {
+			t.Errorf("proof of %s failed to verify", key)
+		}
+	}
+
+	// A Prover with the wrong prefixes does not convert the proofs.
+	if _, err := NewProver(tree).Prove([]byte("testKey3")); !errors.Is(err, ErrSpecMismatch) {
+		t.Errorf("did not return ErrSpecMismatch for other prefixes, got %v", err)
+	}
+}
+
+func TestConvertExistenceProof(t *testing.T) {
+	tree := newTree()
+	tree.Update([]byte("testKey"), []byte("testValue"))
+	tree.Update([]byte("otherKey"), []byte("otherValue"))
+	p := NewProver(tree)
+
+	proof, _ := tree.Prove([]byte("testKey"))
+	exist, err := p.ConvertExistenceProof(proof, []byte("testKey"), []byte("testValue"))
+	if err != nil {
+		t.Fatalf("returned error when converting proof: %v", err)
+	}
+	if err := exist.Verify(p.Spec(), tree.Root(), []byte("testKey"), []byte("testValue")); err != nil {
+		t.Errorf("converted proof failed to verify: %v", err)
+	}
+
+	proof.NonMembershipLeafData = make([]byte, 1+2*sha256.Size)
+	if _, err := p.ConvertExistenceProof(proof, []byte("absentKey"), nil); !errors.Is(err, smt.ErrBadProof) {
+		t.Errorf("did not return ErrBadProof for non-membership proof, got %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+)
+
+// Iterate calls fn with the key and value of each leaf under the current root,
+// in ascending path order, until fn returns false. The order is the same for
+// the same contents however they were written, as paths are hashes of the
+// keys. A leaf set with UpdateLeafHash has no value, and is given a nil one.
+// The tree must be created with WithKeyStore; otherwise ErrKeysNotRetained is
+// returned. The tree should not be updated from fn.
+func (smt *SparseMerkleTree) Iterate(fn func(key, value []byte) bool) error {
+	defer smt.readLock()()
+	it := smt.iteratorForRoot(smt.Root())
+	for it.next() {
+		if !fn(it.key, it.value) {
+			return nil
+		}
+	}
+	return it.err
+}
+
+// Iterator is a cursor over the leaves under a root of a tree, in ascending
+// path order, as Iterate visits them:
+//
+//	it := tree.Iterator()
+//	for it.Next() {
+//		use(it.Key(), it.Value())
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// An Iterator is not safe for concurrent use. It reads the nodes under its
+// root as it goes, so they must stay in the node store while it is used:
+// updates made meanwhile may prune them, unless the root is retained; see
+// WithRootRetention. Values are those of the keys when they are read.
+type Iterator struct {
+	smt *SparseMerkleTree
+	// stack holds the subtrees left to visit, the next on top.
+	stack      [][]byte
+	key, value []byte
+	err        error
+}
+
+// Iterator returns an Iterator over the leaves under the current root. The
+// tree must be created with WithKeyStore; otherwise the iterator fails at
+// once with ErrKeysNotRetained.
+func (smt *SparseMerkleTree) Iterator() *Iterator {
+	return smt.iteratorForRoot(smt.Root())
+}
+
+func (smt *SparseMerkleTree) iteratorForRoot(root []byte) *Iterator {
+	it := &Iterator{smt: smt, stack: [][]byte{root}}
+	if smt.keys == nil {
+		it.err = ErrKeysNotRetained
+	}
+	return it
+}
+
+// Next moves the iterator to the next leaf, returning false once there are
+// no more leaves or it failed; see Err.
+func (it *Iterator) Next() bool {
+	defer it.smt.readLock()()
+	return it.next()
+}
+
+func (it *Iterator) next() bool {
+	it.key, it.value = nil, nil
+	if it.err != nil {
+		return false
+	}
+	smt := it.smt
+	for len(it.stack) > 0 {
+		hash := it.stack[len(it.stack)-1]
+		it.stack = it.stack[:len(it.stack)-1]
+		if bytes.Equal(hash, smt.th.placeholder()) {
+			continue
+		}
+		data, err := smt.getNode(hash)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if !smt.th.isLeaf(data) {
+			leftNode, rightNode := smt.th.parseNode(data)
+			it.stack = append(it.stack, rightNode, leftNode)
+			continue
+		}
+
+		path, valueHash := smt.th.parseLeaf(data)
+		if it.key, err = smt.keys.Get(path); err != nil {
+			it.err = err
+			return false
+		}
+		value, err := smt.getValue(path)
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			it.err = err
+			return false
+		}
+		if err == nil && bytes.Equal(smt.th.digestValue(value), valueHash) {
+			it.value = value
+		}
+		return true
+	}
+	return false
+}
+
+// Key returns the key of the leaf the iterator is at.
+func (it *Iterator) Key() []byte {
+	return it.key
+}
+
+// Value returns the value of the leaf the iterator is at, or nil for a leaf
+// set with UpdateLeafHash.
+func (it *Iterator) Value() []byte {
+	return it.value
+}
+
+// Err returns the error the iterator failed with, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,109 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestIterate(t *testing.T) {
+	build := func(reversed bool) *SparseMerkleTree {
+		smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithKeyStore(NewSimpleMap()))
+		for i := 0; i < 30; i++ {
+			j := i
+			if reversed {
+				j = 29 - i
+			}
+			smt.Update([]byte(fmt.Sprintf("testKey%d", j)), []byte(fmt.Sprintf("testValue%d", j)))
+		}
+		return smt
+	}
+	smt := build(false)
+	smt.Delete([]byte("testKey5"))
+	smt.UpdateLeafHash([]byte("hashOnly"), smt.th.digest([]byte("offTree")))
+
+	var keys [][]byte
+	var lastPath []byte
+	err := smt.Iterate(func(key, value []byte) bool {
+		path := smt.th.path(key)
+		if lastPath != nil && bytes.Compare(lastPath, path) >= 0 {
+			t.Errorf("%s is out of path order", key)
+		}
+		lastPath = path
+		if bytes.Equal(key, []byte("hashOnly")) {
+			if value != nil {
+				t.Errorf("got value %q for a leaf set by hash", value)
+			}
+		} else if want, _ := smt.Get(key); !bytes.Equal(value, want) {
+			t.Errorf("got value %q for %s, want %q", value, key, want)
+		}
+		keys = append(keys, key)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("returned error when iterating: %v", err)
+	}
+	if len(keys) != 30 {
+		t.Errorf("iterated over %d keys, want 30", len(keys))
+	}
+
+	// The order only depends on the contents.
+	a, b := build(false), build(true)
+	var aKeys, bKeys bytes.Buffer
+	a.Iterate(func(key, _ []byte) bool { aKeys.Write(key); return true })
+	for it := b.Iterator(); it.Next(); {
+		bKeys.Write(it.Key())
+	}
+	if !bytes.Equal(aKeys.Bytes(), bKeys.Bytes()) {
+		t.Error("iterations over the same contents differ")
+	}
+
+	n := 0
+	smt.Iterate(func(_, _ []byte) bool { n++; return n < 3 })
+	if n != 3 {
+		t.Errorf("iteration did not stop, visited %d leaves", n)
+	}
+
+	it := smt.Iterator()
+	for i := 0; i < len(keys); i++ {
+		if !it.Next() || !bytes.Equal(it.Key(), keys[i]) {
+			t.Fatalf("iterator is at %s, want %s", it.Key(), keys[i])
+		}
+	}
+	if it.Next() || it.Err() != nil || it.Key() != nil {
+		t.Errorf("iterator did not end, err %v", it.Err())
+	}
+
+	// Snapshots iterate over the leaves they were taken with.
+	snapshot := smt.Snapshot()
+	defer snapshot.Release()
+	smt.Update([]byte("newKey"), []byte("newValue"))
+	smt.Delete([]byte("testKey7"))
+	n = 0
+	err = snapshot.Iterate(func(key, value []byte) bool {
+		if bytes.Equal(key, []byte("testKey7")) && !bytes.Equal(value, []byte("testValue7")) {
+			t.Errorf("snapshot got value %q for deleted key", value)
+		}
+		n++
+		return true
+	})
+	if err != nil || n != len(keys) {
+		t.Errorf("snapshot iterated over %d keys, want %d, %v", n, len(keys), err)
+	}
+
+	empty := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithKeyStore(NewSimpleMap()))
+	if err := empty.Iterate(func(_, _ []byte) bool { t.Error("iterated over an empty tree"); return true }); err != nil {
+		t.Errorf("returned error when iterating over an empty tree: %v", err)
+	}
+
+	plain := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	plain.Update([]byte("testKey"), []byte("testValue"))
+	if err := plain.Iterate(func(_, _ []byte) bool { return true }); !errors.Is(err, ErrKeysNotRetained) {
+		t.Errorf("did not return ErrKeysNotRetained, got %v", err)
+	}
+	if it := plain.Iterator(); it.Next() || !errors.Is(it.Err(), ErrKeysNotRetained) {
+		t.Errorf("iterator did not fail with ErrKeysNotRetained, got %v", it.Err())
+	}
+}
@@ -0,0 +1,87 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ErrPathCollision is returned when updating a key whose path is already
+// occupied by a different key, which can only happen if their digests are
+// identical. It is only detected on trees created with WithKeyStore; without
+// the raw keys, the second key is indistinguishable from the first and
+// overwrites its value.
+var ErrPathCollision = errors.New("key collides with the path of another key")
+
+// ErrKeysNotRetained is returned by operations that need the raw keys of a
+// tree created without WithKeyStore.
+var ErrKeysNotRetained = errors.New("tree does not retain raw keys")
+
+// WithKeyStore stores the raw key of each leaf in keys, indexed by path, so
+// that path collisions between distinct keys can be detected and the keys of
+// a tree can be recovered from it.
+func WithKeyStore(keys MapStore) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.keys = keys
+	}
+}
+
+// otherKey reports whether a key other than key is stored for path.
+func (smt *SparseMerkleTree) otherKey(path []byte, key []byte) (bool, error) {
+	if smt.keys == nil {
+		return false, nil
+	}
+	stored, err := smt.keys.Get(path)
+	if errors.Is(err, ErrKeyNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Equal(stored, key), nil
+}
+
+// checkKey returns ErrPathCollision if the leaf that oldLeafData describes is
+// at path but belongs to a key other than key.
+func (smt *SparseMerkleTree) checkKey(path []byte, key []byte, oldLeafData []byte) error {
+	if smt.keys == nil || oldLeafData == nil {
+		return nil
+	}
+	if actualPath, _ := smt.th.parseLeaf(oldLeafData); !bytes.Equal(actualPath, path) {
+		return nil
+	}
+	other, err := smt.otherKey(path, key)
+	if err != nil {
+		return err
+	}
+	if other {
+		return ErrPathCollision
+	}
+	return nil
+}
+
+func (smt *SparseMerkleTree) setKey(path []byte, key []byte) error {
+	if smt.keys == nil {
+		return nil
+	}
+	if err := smt.preserve(keyStore, path); err != nil {
+		return err
+	}
+	return smt.keys.Set(path, append([]byte{}, key...))
+}
+
+func (smt *SparseMerkleTree) deleteKey(path []byte) error {
+	if smt.keys == nil {
+		return nil
+	}
+	if err := smt.retainKey(path); err != nil {
+		return err
+	}
+	if err := smt.preserve(keyStore, path); err != nil {
+		return err
+	}
+	err := smt.keys.Delete(path)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"testing"
+)
+
+// collidingHasher is a sha256 hasher under which "collidingKey" has the same
+// digest as "testKey".
+type collidingHasher struct {
+	hash.Hash
+	data []byte
+}
+
+func (h *collidingHasher) Write(data []byte) (int, error) {
+	h.data = append(h.data, data...)
+	return len(data), nil
+}
+
+func (h *collidingHasher) Sum(prefix []byte) []byte {
+	preimage := h.data
+	if bytes.Equal(preimage, []byte("collidingKey")) {
+		preimage = []byte("testKey")
+	}
+	h.Hash.Write(preimage)
+	digest := h.Hash.Sum(prefix)
+	h.Hash.Reset()
+	return digest
+}
+
+func (h *collidingHasher) Reset() {
+	h.data = nil
+}
+
+func TestPathCollision(t *testing.T) {
+	keys := NewSimpleMap()
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), &collidingHasher{Hash: sha256.New()}, WithKeyStore(keys))
+	smt.Update([]byte("otherKey"), []byte("otherValue"))
+	if _, err := smt.Update([]byte("testKey"), []byte("testValue")); err != nil {
+		t.Errorf("returned error when updating key: %v", err)
+	}
+	root := smt.Root()
+
+	if _, err := smt.Update([]byte("collidingKey"), []byte("collidingValue")); !errors.Is(err, ErrPathCollision) {
+		t.Errorf("did not return ErrPathCollision when updating colliding key, got %v", err)
+	}
+	if _, err := smt.Delete([]byte("collidingKey")); !errors.Is(err, ErrPathCollision) {
+		t.Errorf("did not return ErrPathCollision when deleting colliding key, got %v", err)
+	}
+	if !bytes.Equal(smt.Root(), root) {
+		t.Error("root changed after colliding update")
+	}
+	value, err := smt.Get([]byte("collidingKey"))
+	if err != nil {
+		t.Errorf("returned error when getting colliding key: %v", err)
+	}
+	if !bytes.Equal(value, defaultValue) {
+		t.Error("did not get default value for colliding key")
+	}
+	value, err = smt.Get([]byte("testKey"))
+	if err != nil {
+		t.Errorf("returned error when getting key: %v", err)
+	}
+	if !bytes.Equal(value, []byte("testValue")) {
+		t.Error("did not get correct value for key after colliding update")
+	}
+
+	// Once the key is deleted, the colliding key can take its path.
+	smt.Delete([]byte("testKey"))
+	if _, err := keys.Get(smt.th.path([]byte("testKey"))); err == nil {
+		t.Error("did not delete key from key store")
+	}
+	if _, err := smt.Update([]byte("collidingKey"), []byte("collidingValue")); err != nil {
+		t.Errorf("returned error when updating colliding key after delete: %v", err)
+	}
+
+	// Without a key store, colliding keys are treated as the same key.
+	smt = NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), &collidingHasher{Hash: sha256.New()})
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	if _, err := smt.Update([]byte("collidingKey"), []byte("collidingValue")); err != nil {
+		t.Errorf("returned error when updating colliding key without key store: %v", err)
+	}
+	value, _ = smt.Get([]byte("testKey"))
+	if !bytes.Equal(value, []byte("collidingValue")) {
+		t.Error("colliding key did not overwrite value without key store")
+	}
+}
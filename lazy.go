@@ -0,0 +1,125 @@
+package smt
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// lazyWrites are the writes staged by Update and Delete in a tree created
+// with WithLazyCommit, by path, until Commit.
+type lazyWrites struct {
+	mu      sync.Mutex
+	entries map[string]batchEntry
+}
+
+// WithLazyCommit makes Update and Delete stage their writes in memory rather
+// than rehash the tree and write its stores, leaving the root as it is, until
+// Commit applies all the staged writes at once, as UpdateBatch does, and
+// returns the new root. This suits applying the many writes of a block when
+// only the root after the last one is needed: each node shared by their paths
+// is rehashed and written once, and a key written repeatedly only once.
+//
+// Get and Has return the staged values. Every other method, such as Prove,
+// Root and UpdateBatch, sees the tree as of the last Commit, and staged writes
+// of a key take precedence over writes the other methods make meanwhile.
+func WithLazyCommit() Option {
+	return func(smt *SparseMerkleTree) {
+		smt.lazy = &lazyWrites{entries: make(map[string]batchEntry)}
+	}
+}
+
+// stage stages the write of value for key, or its deletion if value is nil,
+// returning the root as of the last Commit.
+func (smt *SparseMerkleTree) stage(key []byte, value []byte) ([]byte, error) {
+	if value != nil {
+		if err := smt.checkValueSize(value); err != nil {
+			return nil, err
+		}
+		value = append([]byte{}, value...)
+	}
+	if smt.IsSealed() {
+		return nil, ErrSealed
+	}
+	path := smt.th.path(key)
+	smt.lazy.mu.Lock()
+	smt.lazy.entries[string(path)] = batchEntry{path: path, key: append([]byte{}, key...), value: value}
+	smt.lazy.mu.Unlock()
+	return smt.Root(), nil
+}
+
+// staged returns the value staged for key, nil for a deletion, and whether a
+// write of key is staged.
+func (smt *SparseMerkleTree) staged(key []byte) ([]byte, bool) {
+	smt.lazy.mu.Lock()
+	defer smt.lazy.mu.Unlock()
+	entry, ok := smt.lazy.entries[string(smt.th.path(key))]
+	if !ok || !bytes.Equal(entry.key, key) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// Pending returns the number of keys with writes staged for Commit in a tree
+// created with WithLazyCommit.
+func (smt *SparseMerkleTree) Pending() int {
+	if smt.lazy == nil {
+		return 0
+	}
+	smt.lazy.mu.Lock()
+	defer smt.lazy.mu.Unlock()
+	return len(smt.lazy.entries)
+}
+
+// Commit applies the writes staged by Update and Delete in a tree created with
+// WithLazyCommit, and sets and returns the new root of the tree, as a single
+// change of the root. A batch failing its checks, such as for a collision of
+// paths, writes nothing; the staged writes are discarded either way. Without
+// staged writes it returns the root as is.
+func (smt *SparseMerkleTree) Commit() ([]byte, error) {
+	if smt.metrics != nil && smt.lazy != nil {
+		start := time.Now()
+		root, err := smt.commit()
+		smt.observe(OperationCommit, start, err)
+		return root, err
+	}
+	return smt.commit()
+}
+
+func (smt *SparseMerkleTree) commit() ([]byte, error) {
+	if smt.lazy == nil {
+		return smt.Root(), nil
+	}
+	smt.lazy.mu.Lock()
+	staged := smt.lazy.entries
+	smt.lazy.entries = make(map[string]batchEntry)
+	smt.lazy.mu.Unlock()
+	if len(staged) == 0 {
+		return smt.Root(), nil
+	}
+
+	entries := make([]batchEntry, 0, len(staged))
+	for _, entry := range staged {
+		if entry.value != nil {
+			entry.valueHash = smt.th.digestValue(entry.value)
+		}
+		entries = append(entries, entry)
+	}
+	return smt.changeRoot(func(root []byte) ([]byte, error) {
+		if smt.sealed {
+			return nil, ErrSealed
+		}
+		return smt.applyBatch(entries, root)
+	})
+}
+
+// Discard drops the writes staged for Commit in a tree created with
+// WithLazyCommit.
+func (smt *SparseMerkleTree) Discard() {
+	if smt.lazy == nil {
+		return
+	}
+	smt.lazy.mu.Lock()
+	defer smt.lazy.mu.Unlock()
+	smt.lazy.entries = make(map[string]batchEntry)
+}
@@ -0,0 +1,94 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func TestLazyCommit(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	lazy := NewSparseMerkleTree(smn, smv, sha256.New(), WithLazyCommit())
+	eager := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+
+	for round := 0; round < 3; round++ {
+		emptyRoot := lazy.Root()
+		for i := 0; i < 50; i++ {
+			key := []byte(fmt.Sprintf("key%d", i%20))
+			value := []byte(fmt.Sprintf("value%d-%d", round, i))
+			for _, smt := range []*SparseMerkleTree{lazy, eager} {
+				var err error
+				if i%7 == 3 {
+					_, err = smt.Delete(key)
+				} else {
+					_, err = smt.Update(key, value)
+				}
+				if err != nil && err != errKeyAlreadyEmpty {
+					t.Fatalf("returned error when writing key: %v", err)
+				}
+			}
+		}
+		if !bytes.Equal(lazy.Root(), emptyRoot) {
+			t.Error("root changed before commit")
+		}
+		if n := lazy.Pending(); n != 20 {
+			t.Errorf("pending writes %d, want 20", n)
+		}
+		for i := 0; i < 20; i++ {
+			key := []byte(fmt.Sprintf("key%d", i))
+			got, err := lazy.Get(key)
+			if err != nil {
+				t.Fatalf("returned error when getting staged key: %v", err)
+			}
+			want, _ := eager.Get(key)
+			if !bytes.Equal(got, want) {
+				t.Errorf("staged value of %s is %q, want %q", key, got, want)
+			}
+		}
+
+		root, err := lazy.Commit()
+		if err != nil {
+			t.Fatalf("returned error when committing: %v", err)
+		}
+		if !bytes.Equal(root, eager.Root()) || !bytes.Equal(lazy.Root(), root) {
+			t.Errorf("committed root does not match the root of the same writes made eagerly")
+		}
+		if lazy.Pending() != 0 {
+			t.Error("writes still pending after commit")
+		}
+	}
+
+	// Discarded writes are not committed.
+	root := lazy.Root()
+	lazy.Update([]byte("discarded"), []byte("value"))
+	lazy.Discard()
+	if got, _ := lazy.Get([]byte("discarded")); len(got) != 0 {
+		t.Error("discarded write still visible")
+	}
+	if committed, _ := lazy.Commit(); !bytes.Equal(committed, root) {
+		t.Error("commit of discarded writes changed the root")
+	}
+}
+
+func TestLazyCommitDefersStoreWrites(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New(), WithLazyCommit())
+	for i := 0; i < 10; i++ {
+		smt.Update([]byte(fmt.Sprintf("key%d", i)), []byte("value"))
+	}
+	if len(smn.m) != 0 || len(smv.m) != 0 {
+		t.Error("staged writes reached the stores before commit")
+	}
+	if _, err := smt.Commit(); err != nil {
+		t.Fatalf("returned error when committing: %v", err)
+	}
+	if len(smv.m) != 10 {
+		t.Errorf("value store has %d values after commit, want 10", len(smv.m))
+	}
+
+	smt.Seal()
+	if _, err := smt.Update([]byte("key"), []byte("value")); err != ErrSealed {
+		t.Errorf("staging a write on a sealed tree returned %v, want ErrSealed", err)
+	}
+}
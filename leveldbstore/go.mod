@@ -0,0 +1,17 @@
+module github.com/causevest/smt/leveldbstore
+
+go 1.18
+
+require (
+	github.com/causevest/smt v0.0.0-00010101000000-000000000000
+	github.com/syndtr/goleveldb v1.0.0
+)
+
+require (
+	github.com/golang/snappy v0.0.3 // indirect
+	golang.org/x/crypto v0.2.0 // indirect
+	golang.org/x/sys v0.4.0 // indirect
+	gopkg.in/yaml.v2 v2.4.0 // indirect
+)
+
+replace github.com/causevest/smt => ..
@@ -0,0 +1,138 @@
+// Package leveldbstore provides a MapStore backed by LevelDB, for trees whose
+// nodes and values are kept on disk rather than in memory.
+package leveldbstore
+
+import (
+	"errors"
+	"io"
+
+	"github.com/causevest/smt"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// LevelDBStore is a MapStore that keeps its entries in a LevelDB database,
+// under a key prefix, so that several stores, such as the nodes and values of
+// a tree, can share one database. The prefixes of stores sharing a database
+// must not be prefixes of each other. It is safe for concurrent use.
+type LevelDBStore struct {
+	db     *leveldb.DB
+	prefix []byte
+}
+
+// NewLevelDBStore creates a LevelDBStore keeping its entries in db under
+// prefix. Entries already in db under prefix are kept, so a tree can be
+// imported from the store at its last root. The store does not own db: close
+// it once the stores on it are no longer used.
+func NewLevelDBStore(db *leveldb.DB, prefix []byte) *LevelDBStore {
+	if db == nil {
+		panic("leveldbstore: nil database")
+	}
+	return &LevelDBStore{db: db, prefix: append([]byte{}, prefix...)}
+}
+
+func (s *LevelDBStore) key(key []byte) []byte {
+	prefixed := make([]byte, 0, len(s.prefix)+len(key))
+	prefixed = append(prefixed, s.prefix...)
+	return append(prefixed, key...)
+}
+
+// Get gets the value for a key.
+func (s *LevelDBStore) Get(key []byte) ([]byte, error) {
+	value, err := s.db.Get(s.key(key), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil, &smt.InvalidKeyError{Key: key}
+	}
+	return value, err
+}
+
+// Set updates the value for a key.
+func (s *LevelDBStore) Set(key []byte, value []byte) error {
+	return s.db.Put(s.key(key), value, nil)
+}
+
+// Delete deletes a key, returning an InvalidKeyError if it is missing, as
+// SimpleMap does.
+func (s *LevelDBStore) Delete(key []byte) error {
+	ok, err := s.db.Has(s.key(key), nil)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &smt.InvalidKeyError{Key: key}
+	}
+	return s.db.Delete(s.key(key), nil)
+}
+
+// BatchGet gets the values for keys, in order, reading them from a single
+// snapshot of the database.
+func (s *LevelDBStore) BatchGet(keys [][]byte) ([][]byte, error) {
+	snapshot, err := s.db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer snapshot.Release()
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		value, err := snapshot.Get(s.key(key), nil)
+		if errors.Is(err, leveldb.ErrNotFound) {
+			return nil, &smt.InvalidKeyError{Key: key}
+		} else if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// BatchSet updates the values for keys in a single atomic write, so that the
+// nodes of an update are written in full or not at all.
+func (s *LevelDBStore) BatchSet(keys [][]byte, values [][]byte) error {
+	var batch leveldb.Batch
+	for i, key := range keys {
+		batch.Put(s.key(key), values[i])
+	}
+	return s.db.Write(&batch, nil)
+}
+
+// Export exports the entries of the store as a map snapshot, like
+// SimpleMap.Export, read from a single snapshot of the database.
+func (s *LevelDBStore) Export() ([]byte, error) {
+	sm := smt.NewSimpleMap()
+	iter := s.db.NewIterator(util.BytesPrefix(s.prefix), nil)
+	for iter.Next() {
+		// The iterator reuses its buffers.
+		key := append([]byte{}, iter.Key()[len(s.prefix):]...)
+		if err := sm.Set(key, append([]byte{}, iter.Value()...)); err != nil {
+			iter.Release()
+			return nil, err
+		}
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return sm.Export()
+}
+
+// ExportTo writes the entries of the store to w as a record stream, as read
+// by smt.ImportStoreFrom, one at a time from a snapshot of the database.
+func (s *LevelDBStore) ExportTo(w io.Writer) error {
+	snapshot, err := s.db.GetSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snapshot.Release()
+	iter := snapshot.NewIterator(util.BytesPrefix(s.prefix), nil)
+	defer iter.Release()
+	rw := smt.NewRecordWriter(w)
+	for iter.Next() {
+		if err := rw.Write(iter.Key()[len(s.prefix):], iter.Value()); err != nil {
+			return err
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return rw.Close()
+}
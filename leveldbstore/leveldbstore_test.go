@@ -0,0 +1,130 @@
+package leveldbstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/causevest/smt"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestLevelDBStore(t *testing.T) {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatalf("returned error when opening database: %v", err)
+	}
+	defer db.Close()
+	s := NewLevelDBStore(db, []byte("n/"))
+	other := NewLevelDBStore(db, []byte("v/"))
+
+	if err := s.Set([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("returned error when setting: %v", err)
+	}
+	if value, err := s.Get([]byte("key")); err != nil || !bytes.Equal(value, []byte("value")) {
+		t.Errorf("did not get value, got %s, %v", value, err)
+	}
+	var invalidKeyError *smt.InvalidKeyError
+	if _, err := other.Get([]byte("key")); !errors.As(err, &invalidKeyError) {
+		t.Errorf("read key of another prefix, got %v", err)
+	}
+	if err := s.Delete([]byte("key")); err != nil {
+		t.Errorf("returned error when deleting: %v", err)
+	}
+	if _, err := s.Get([]byte("key")); !errors.As(err, &invalidKeyError) {
+		t.Errorf("did not return InvalidKeyError for deleted key, got %v", err)
+	}
+	if err := s.Delete([]byte("key")); !errors.As(err, &invalidKeyError) {
+		t.Errorf("did not return InvalidKeyError when deleting missing key, got %v", err)
+	}
+}
+
+func TestLevelDBStoreTree(t *testing.T) {
+	dir := t.TempDir()
+	db, err := leveldb.OpenFile(dir, nil)
+	if err != nil {
+		t.Fatalf("returned error when opening database: %v", err)
+	}
+	nodes, values := NewLevelDBStore(db, []byte("n/")), NewLevelDBStore(db, []byte("v/"))
+	tree := smt.NewSparseMerkleTree(nodes, values, sha256.New())
+	reference := smt.NewSparseMerkleTree(smt.NewSimpleMap(), smt.NewSimpleMap(), sha256.New())
+	for i := 0; i < 100; i++ {
+		key, value := []byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i))
+		tree.Update(key, value)
+		reference.Update(key, value)
+	}
+	tree.Delete([]byte("testKey3"))
+	reference.Delete([]byte("testKey3"))
+	if !bytes.Equal(tree.Root(), reference.Root()) {
+		t.Fatal("tree on LevelDB has a different root")
+	}
+	root := tree.Root()
+
+	// The tree survives reopening the database.
+	if err := db.Close(); err != nil {
+		t.Fatalf("returned error when closing database: %v", err)
+	}
+	db, err = leveldb.OpenFile(dir, nil)
+	if err != nil {
+		t.Fatalf("returned error when reopening database: %v", err)
+	}
+	defer db.Close()
+	nodes, values = NewLevelDBStore(db, []byte("n/")), NewLevelDBStore(db, []byte("v/"))
+	tree = smt.ImportSparseMerkleTree(nodes, values, sha256.New(), root)
+	if value, err := tree.Get([]byte("testKey7")); err != nil || !bytes.Equal(value, []byte("testValue7")) {
+		t.Errorf("did not get value after reopening, got %s, %v", value, err)
+	}
+	proof, err := tree.Prove([]byte("testKey3"))
+	if err != nil || !smt.VerifyProof(proof, root, []byte("testKey3"), nil, sha256.New()) {
+		t.Errorf("did not prove deleted key after reopening: %v", err)
+	}
+
+	// The export imports as a SimpleMap holding the same tree.
+	nodesBytes, err := nodes.Export()
+	if err != nil {
+		t.Fatalf("returned error when exporting nodes: %v", err)
+	}
+	valuesBytes, err := values.Export()
+	if err != nil {
+		t.Fatalf("returned error when exporting values: %v", err)
+	}
+	smn, smv, err := smt.ImportMerkleMap(nodesBytes, valuesBytes)
+	if err != nil {
+		t.Fatalf("returned error when importing export: %v", err)
+	}
+	imported := smt.ImportSparseMerkleTree(smn, smv, sha256.New(), root)
+	if value, err := imported.Get([]byte("testKey42")); err != nil || !bytes.Equal(value, []byte("testValue42")) {
+		t.Errorf("did not get value from export, got %s, %v", value, err)
+	}
+}
+
+func TestLevelDBStoreExportTo(t *testing.T) {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatalf("returned error when opening database: %v", err)
+	}
+	defer db.Close()
+	s := NewLevelDBStore(db, []byte("n/"))
+	other := NewLevelDBStore(db, []byte("v/"))
+	for i := 0; i < 20; i++ {
+		s.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)))
+		other.Set([]byte(fmt.Sprintf("otherKey%d", i)), []byte("otherValue"))
+	}
+
+	var buf bytes.Buffer
+	if err := smt.ExportStoreTo(&buf, s); err != nil {
+		t.Fatalf("returned error when exporting: %v", err)
+	}
+	imported := smt.NewSimpleMap()
+	if n, err := smt.ImportStoreFrom(&buf, imported); err != nil || n != 20 {
+		t.Fatalf("imported %d entries: %v", n, err)
+	}
+	for i := 0; i < 20; i++ {
+		if value, err := imported.Get([]byte(fmt.Sprintf("key%d", i))); err != nil || !bytes.Equal(value, []byte(fmt.Sprintf("value%d", i))) {
+			t.Errorf("did not import entry, got %s, %v", value, err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package smt
+
+import (
+	"errors"
+)
+
+// ErrLimitExceeded is returned by operations that traverse the whole tree when
+// it has more leaves than allowed by SetOperationLimit.
+var ErrLimitExceeded = errors.New("tree has more leaves than the operation limit")
+
+// errLimitReached stops the walk of checkOperationLimit.
+var errLimitReached = errors.New("limit reached")
+
+// SetOperationLimit guards a shared tree against accidental traversals of all
+// of its leaves: once set, NodeCount, Stats, WriteSnapshot, Rehash, Split,
+// ExportTrie, SwapNodeStore and SwapValueStore fail with ErrLimitExceeded if
+// the tree has more than maxLeaves leaves, unless passed
+// WithoutOperationLimit. Checking
+// walks the tree until the limit is passed, so it is bounded by the limit
+// rather than by the size of the tree. A limit of 0 removes the guard. Dump is
+// already bounded by DefaultDumpLimit.
+//
+// Like SetValueCodec, the limit should be set before the tree is shared.
+func (smt *SparseMerkleTree) SetOperationLimit(maxLeaves int) {
+	smt.operationLimit = maxLeaves
+}
+
+// TraversalOption is an option for an operation that traverses the whole tree.
+type TraversalOption func(*traversalConfig)
+
+type traversalConfig struct {
+	unbounded bool
+}
+
+// WithoutOperationLimit runs an operation on the whole tree even if it has
+// more leaves than the limit set with SetOperationLimit.
+func WithoutOperationLimit() TraversalOption {
+	return func(config *traversalConfig) {
+		config.unbounded = true
+	}
+}
+
+// checkOperationLimit returns ErrLimitExceeded if the tree has an operation
+// limit, the options do not lift it, and root has more leaves than it.
+func (smt *SparseMerkleTree) checkOperationLimit(root []byte, options []TraversalOption) error {
+	if smt.operationLimit <= 0 {
+		return nil
+	}
+	var config traversalConfig
+	for _, option := range options {
+		option(&config)
+	}
+	if config.unbounded {
+		return nil
+	}
+	leaves := 0
+	err := smt.walk(root, func(_ []bool, _ []byte, data []byte) error {
+		if smt.th.isLeaf(data) {
+			leaves++
+			if leaves > smt.operationLimit {
+				return errLimitReached
+			}
+		}
+		return nil
+	})
+	if err == errLimitReached {
+		smt.warnf("refused to traverse tree with more than %d leaves", smt.operationLimit)
+		return ErrLimitExceeded
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestOperationLimit(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithKeyStore(NewSimpleMap()))
+	for i := 0; i < 20; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte("testValue"))
+	}
+	smt.SetOperationLimit(10)
+
+	if _, _, err := smt.NodeCount(); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("did not return ErrLimitExceeded from NodeCount, got %v", err)
+	}
+	var buf bytes.Buffer
+	if err := smt.WriteSnapshot(&buf); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("did not return ErrLimitExceeded from WriteSnapshot, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Error("wrote snapshot over the limit")
+	}
+	if _, err := smt.Rehash(sha256.New); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("did not return ErrLimitExceeded from Rehash, got %v", err)
+	}
+	if _, err := ExportTrie(smt); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("did not return ErrLimitExceeded from ExportTrie, got %v", err)
+	}
+	nodes := NewSimpleMap()
+	if err := smt.SwapNodeStore(nodes); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("did not return ErrLimitExceeded from SwapNodeStore, got %v", err)
+	}
+	if len(nodes.m) != 0 {
+		t.Error("copied nodes over the limit")
+	}
+	if err := smt.SwapValueStore(NewSimpleMap()); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("did not return ErrLimitExceeded from SwapValueStore, got %v", err)
+	}
+
+	// The limit is lifted explicitly.
+	_, leaves, err := smt.NodeCount(WithoutOperationLimit())
+	if err != nil || leaves != 20 {
+		t.Errorf("did not count leaves without the limit, got %d, %v", leaves, err)
+	}
+	if err := smt.WriteSnapshot(&buf, WithoutOperationLimit()); err != nil {
+		t.Errorf("returned error when writing snapshot without the limit: %v", err)
+	}
+	if _, err := ExportTrie(smt, WithoutOperationLimit()); err != nil {
+		t.Errorf("returned error when exporting trie without the limit: %v", err)
+	}
+	if err := smt.SwapNodeStore(nodes, WithoutOperationLimit()); err != nil {
+		t.Errorf("returned error when swapping node store without the limit: %v", err)
+	}
+
+	// Trees within the limit are traversed.
+	smt.SetOperationLimit(20)
+	if _, err := smt.Rehash(sha256.New); err != nil {
+		t.Errorf("returned error when rehashing within the limit: %v", err)
+	}
+	smt.SetOperationLimit(0)
+	smt.Update([]byte("newKey"), []byte("newValue"))
+	if _, _, err := smt.NodeCount(); err != nil {
+		t.Errorf("returned error when counting nodes without a limit: %v", err)
+	}
+}
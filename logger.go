@@ -0,0 +1,30 @@
+package smt
+
+// Logger receives diagnostic messages from a tree, e.g. at import and export
+// boundaries, when orphaned nodes are pruned, and when consistency checks
+// fail.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+}
+
+// WithLogger sets the logger of a tree. Trees log nothing by default.
+func WithLogger(logger Logger) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.logger = logger
+	}
+}
+
+// debugf and warnf log to the tree's logger, if set. Callers on hot paths
+// should check smt.logger first, to avoid building the arguments.
+func (smt *SparseMerkleTree) debugf(format string, args ...interface{}) {
+	if smt.logger != nil {
+		smt.logger.Debugf(format, args...)
+	}
+}
+
+func (smt *SparseMerkleTree) warnf(format string, args ...interface{}) {
+	if smt.logger != nil {
+		smt.logger.Warnf(format, args...)
+	}
+}
@@ -0,0 +1,99 @@
+package smt
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// recordingLogger records the messages logged to it.
+type recordingLogger struct {
+	debug, warn []string
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.debug = append(l.debug, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Warnf(format string, args ...interface{}) {
+	l.warn = append(l.warn, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) logged(messages []string, substr string) bool {
+	for _, message := range messages {
+		if strings.Contains(message, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLogger(t *testing.T) {
+	logger := &recordingLogger{}
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha3.New256(), WithLogger(logger))
+	smt.Update([]byte("testKey1"), []byte("testValue1"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	smt.Update([]byte("testKey1"), []byte("newValue1"))
+	smt.Delete([]byte("testKey2"))
+	if !logger.logged(logger.debug, "pruned") {
+		t.Error("did not log pruning")
+	}
+
+	wrap, err := ExportTrie(smt)
+	if err != nil {
+		t.Fatalf("returned error when exporting: %v", err)
+	}
+	if !logger.logged(logger.debug, "exported trie") {
+		t.Error("did not log export")
+	}
+	if _, err := ImportTrie(wrap, WithLogger(logger)); err != nil {
+		t.Fatalf("returned error when importing: %v", err)
+	}
+	if !logger.logged(logger.debug, "with 1 nodes and 1 values") {
+		t.Errorf("did not log import entry counts, got %v", logger.debug)
+	}
+	wrap.NodesBytes = []byte("corrupt")
+	if _, err := ImportTrie(wrap, WithLogger(logger)); err == nil {
+		t.Error("did not return error when importing corrupt trie")
+	}
+	if !logger.logged(logger.warn, "failed to import") {
+		t.Error("did not warn about failed import")
+	}
+
+	other := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	other.Update([]byte("testKey1"), []byte("testValue1"))
+	proof, _ := other.Prove([]byte("testKey1"))
+	dsmst := NewDeepSparseMerkleSubTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), other.Root(), WithLogger(logger))
+	dsmst.AddBranch(proof, []byte("testKey1"), []byte("wrongValue"))
+	if !logger.logged(logger.warn, "rejected branch") {
+		t.Error("did not warn about rejected branch")
+	}
+}
+
+func TestLoggerImportOptions(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha3.New256())
+	smt.Update([]byte("testKey1"), []byte("testValue1"))
+	wrap, _ := ExportTrie(smt)
+
+	// Options are applied once, to the tree imported.
+	logger := &recordingLogger{}
+	applied := 0
+	count := func(*SparseMerkleTree) { applied++ }
+	if _, err := ImportTrie(wrap, WithLogger(logger), count); err != nil {
+		t.Fatalf("returned error when importing: %v", err)
+	}
+	if applied != 1 {
+		t.Errorf("applied an option %d times, want 1", applied)
+	}
+
+	applied = 0
+	if _, err := ImportTrieWithHasher(wrap, sha256.New(), WithLogger(logger), count); err == nil {
+		t.Error("did not return error when importing with another hasher")
+	}
+	if applied != 1 || !logger.logged(logger.warn, "exported with") {
+		t.Errorf("applied an option %d times and warned %v", applied, logger.warn)
+	}
+}
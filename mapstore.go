@@ -3,7 +3,10 @@ package smt
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
 	"fmt"
+	"hash"
+	"sync"
 
 	"golang.org/x/crypto/sha3"
 )
@@ -16,18 +19,37 @@ type MapStore interface {
 	Export() ([]byte, error)            // exports the map into a byte array
 }
 
+// ErrKeyNotFound is the error a MapStore returns, possibly wrapped, for a key
+// it does not have, so that a missing key can be told from a failure of the
+// store with errors.Is. InvalidKeyError wraps it.
+var ErrKeyNotFound = errors.New("key not found")
+
 // InvalidKeyError is thrown when a key that does not exist is being accessed.
+// Its message shows only the start of long keys.
 type InvalidKeyError struct {
 	Key []byte
 }
 
 func (e *InvalidKeyError) Error() string {
-	return fmt.Sprintf("invalid key: %x", e.Key)
+	return "invalid key: " + formatKey(e.Key)
+}
+
+// Unwrap returns ErrKeyNotFound.
+func (e *InvalidKeyError) Unwrap() error {
+	return ErrKeyNotFound
 }
 
+// ErrStoreFull is returned by the Set of a SimpleMap created with
+// NewBoundedSimpleMap that would grow the map past its cap.
+var ErrStoreFull = errors.New("store is full")
+
 // SimpleMap is a simple in-memory map.
 type SimpleMap struct {
 	m map[string][]byte
+	// maxEntries caps the number of entries, if positive.
+	maxEntries int
+	// mu guards m in a map created with NewConcurrentSimpleMap.
+	mu *sync.RWMutex
 }
 
 // NewSimpleMap creates a new empty SimpleMap.
@@ -37,8 +59,59 @@ func NewSimpleMap() *SimpleMap {
 	}
 }
 
+// NewSimpleMapWithCapacity creates a new empty SimpleMap with room for n
+// entries, so that filling it with that many does not grow the map along the
+// way.
+func NewSimpleMapWithCapacity(n int) *SimpleMap {
+	return &SimpleMap{
+		m: make(map[string][]byte, n),
+	}
+}
+
+// NewBoundedSimpleMap creates a new empty SimpleMap that holds at most
+// maxEntries entries: a Set of a new key once it is full returns
+// ErrStoreFull, while keys already in it can still be set, e.g. to exercise
+// how callers handle a store that fills up.
+func NewBoundedSimpleMap(maxEntries int) *SimpleMap {
+	if maxEntries <= 0 {
+		panic("smt: non-positive store size")
+	}
+	return &SimpleMap{
+		m:          make(map[string][]byte),
+		maxEntries: maxEntries,
+	}
+}
+
+// NewConcurrentSimpleMap creates a new empty SimpleMap that is safe for
+// concurrent use, guarding the map with a read-write lock so that Gets run in
+// parallel with each other but not with writes, e.g. for a store shared by
+// several trees or also read outside of the tree.
+func NewConcurrentSimpleMap() *SimpleMap {
+	return &SimpleMap{
+		m:  make(map[string][]byte),
+		mu: new(sync.RWMutex),
+	}
+}
+
+func (sm *SimpleMap) rlock() func() {
+	if sm.mu == nil {
+		return func() {}
+	}
+	sm.mu.RLock()
+	return sm.mu.RUnlock
+}
+
+func (sm *SimpleMap) lock() func() {
+	if sm.mu == nil {
+		return func() {}
+	}
+	sm.mu.Lock()
+	return sm.mu.Unlock
+}
+
 // Get gets the value for a key.
 func (sm *SimpleMap) Get(key []byte) ([]byte, error) {
+	defer sm.rlock()()
 	if value, ok := sm.m[string(key)]; ok {
 		return value, nil
 	}
@@ -47,13 +120,20 @@ func (sm *SimpleMap) Get(key []byte) ([]byte, error) {
 
 // Set updates the value for a key.
 func (sm *SimpleMap) Set(key []byte, value []byte) error {
+	defer sm.lock()()
+	if sm.maxEntries > 0 && len(sm.m) >= sm.maxEntries {
+		if _, ok := sm.m[string(key)]; !ok {
+			return fmt.Errorf("%w: %d entries", ErrStoreFull, sm.maxEntries)
+		}
+	}
 	sm.m[string(key)] = value
 	return nil
 }
 
-// Export dumps the map into a gob serial
+// Export dumps the map into a checksummed, canonical serial
 func (sm *SimpleMap) Export() ([]byte, error) {
-	serial, err := GobEncode(sm.m)
+	defer sm.rlock()()
+	serial, err := encodeSnapshot(sm.m)
 	return serial, err
 }
 
@@ -77,6 +157,7 @@ func GobDecode(b []byte, v interface{}) error {
 
 // Delete deletes a key.
 func (sm *SimpleMap) Delete(key []byte) error {
+	defer sm.lock()()
 	_, ok := sm.m[string(key)]
 	if ok {
 		delete(sm.m, string(key))
@@ -97,51 +178,348 @@ func NewMerkleTrie() *SparseMerkleTree {
 	return trie
 }
 
+// NewMerkleTrieWithCapacity makes a new trie like NewMerkleTrie, with stores
+// preallocated for n keys: a tree of n leaves holds about 2n nodes, the
+// leaves and the branches joining them, and n values.
+func NewMerkleTrieWithCapacity(n int) *SparseMerkleTree {
+	return NewSparseMerkleTree(NewSimpleMapWithCapacity(2*n), NewSimpleMapWithCapacity(n), sha3.New256())
+}
+
+// ErrTrieWrapVersion is returned by ImportTrie for a TrieWrap with an
+// unsupported schema version.
+var ErrTrieWrapVersion = errors.New("unsupported trie wrap schema version")
+
+// trieWrapSchemaVersion is the schema version of the TrieWraps ExportTrie
+// returns. Version 0 is that of TrieWraps from before the version was
+// recorded, which gob decodes with a zero SchemaVersion; they have the same
+// fields and are imported alike. Versions before 2 do not record Hash, and
+// are of tries hashed with SHA3-256. Versions before 3 do not record Depth,
+// and are imported with the path length of the options they are imported
+// with.
+const trieWrapSchemaVersion = 3
+
 // used to save the a Trie to statedb
 // keeps the root and map serial together
 type TrieWrap struct {
 	Root        []byte
 	NodesBytes  []byte
 	ValuesBytes []byte
+
+	// SchemaVersion is the version of the layout of the TrieWrap, set by
+	// ExportTrie and checked by ImportTrie, so that fields can be added
+	// without older or newer encodings being misread.
+	SchemaVersion int
+
+	// Hash is the name of the hash algorithm of the trie, as registered with
+	// RegisterHash, or empty if it is not a registered one.
+	Hash string
+
+	// Depth is the number of bits of the paths of the trie, as set with
+	// WithPathBits, so that ImportTrie imports it with the same path length.
+	// It is 0 in TrieWraps of schema versions before 3.
+	Depth int
 }
 
-func ImportTrie(wrap *TrieWrap) (*SparseMerkleTree, error) {
-	// takes the gob encoded map for an smt and returns the smt
-	smn, smv, err := ImportMerkleMap(wrap.NodesBytes, wrap.ValuesBytes)
+// ErrDepthMismatch is returned by ImportTrie for a TrieWrap recording another
+// path length than that of the options it is imported with, and by Rehash for
+// a hasher whose digests are shorter than the paths of the tree.
+var ErrDepthMismatch = errors.New("trie has another path length")
+
+// ErrNodeHashMismatch is returned by ImportTrie, with WithNodeHashCheck, for
+// a node stored under a key other than its hash.
+var ErrNodeHashMismatch = errors.New("node is not stored under its hash")
+
+// WithNodeHashCheck makes ImportTrie check that every node of the imported
+// node store is stored under its hash, returning ErrNodeHashMismatch if not,
+// for snapshots from untrusted sources: a node stored under another key is
+// otherwise only caught if a read reaches it, and can hide poisoned nodes in
+// a snapshot whose root verifies. The check hashes every node, as many hashes
+// as building the tree again, so it can take longer than the import itself
+// for large trees. It has no effect on trees not imported with ImportTrie.
+func WithNodeHashCheck() Option {
+	return func(smt *SparseMerkleTree) {
+		smt.checkNodeHashes = true
+	}
+}
+
+// ErrTrieCorrupt is returned by ImportTrie, with WithImportVerification, for a
+// trie whose nodes or values do not match its root.
+var ErrTrieCorrupt = errors.New("trie does not match its root")
+
+// WithImportVerification makes ImportTrie walk the imported nodes from the
+// root of the trie, checking that every node it references is present, well
+// formed and hashes to the reference, and that each value present hashes to
+// the one its leaf commits to, returning ErrTrieCorrupt for the first that
+// does not. A truncated or tampered export otherwise imports, and only fails
+// once a read reaches the damage. Values missing for their leaves are only
+// reported with WithMissingValueCheck, as keys set with UpdateLeafHash have
+// none. The walk reads every node and value under the root, so it can take
+// longer than the import itself for large trees. It has no effect on trees
+// not imported with ImportTrie.
+func WithImportVerification() Option {
+	return func(smt *SparseMerkleTree) {
+		smt.verifyImport = true
+	}
+}
+
+// ImportTrie imports a trie exported with ExportTrie, hashed with the
+// algorithm its TrieWrap records. ErrUnknownHash is returned for a trie of a
+// hash algorithm that is not registered; import it with ImportTrieWithHasher.
+//
+// A trie of an unsupported schema version or hash algorithm fails before the
+// tree is configured, so the logger of options does not see it.
+func ImportTrie(wrap *TrieWrap, options ...Option) (*SparseMerkleTree, error) {
+	if err := checkTrieWrap(wrap); err != nil {
+		return nil, err
+	}
+	hasher, err := NewHash(wrap.hashName())
 	if err != nil {
 		return nil, err
 	}
+	return importTrie(wrap, hasher, false, options)
+}
+
+// ImportTrieWithHasher imports a trie exported with ExportTrie like
+// ImportTrie, hashed with hasher, e.g. for a trie of a hash algorithm that is
+// not registered. ErrHashMismatch is returned if the TrieWrap records another
+// algorithm than that of hasher.
+func ImportTrieWithHasher(wrap *TrieWrap, hasher hash.Hash, options ...Option) (*SparseMerkleTree, error) {
+	return importTrie(wrap, hasher, true, options)
+}
+
+// hashName returns the name of the hash algorithm of the trie of wrap.
+func (wrap *TrieWrap) hashName() string {
+	if wrap.SchemaVersion < 2 {
+		return HashSHA3_256
+	}
+	return wrap.Hash
+}
 
-	return ImportSparseMerkleTree(smn, smv, sha3.New256(), wrap.Root), nil
+// checkTrieWrap returns ErrTrieWrapVersion for a TrieWrap of an unsupported
+// schema version.
+func checkTrieWrap(wrap *TrieWrap) error {
+	if wrap.SchemaVersion < 0 || wrap.SchemaVersion > trieWrapSchemaVersion {
+		return fmt.Errorf("%w: %d", ErrTrieWrapVersion, wrap.SchemaVersion)
+	}
+	return nil
 }
 
-func ExportTrie(trie *SparseMerkleTree) (*TrieWrap, error) {
-	nodesBytes, err := trie.nodes.Export()
+// importTrie imports wrap hashed with hasher, checking first that it records
+// the algorithm of hasher if checkHash is set. The tree is configured before
+// the trie is checked and decoded, on empty stores that the decoded ones then
+// replace, so that its failures are logged to the logger of options.
+func importTrie(wrap *TrieWrap, hasher hash.Hash, checkHash bool, options []Option) (*SparseMerkleTree, error) {
+	var depthErr error
+	switch {
+	case wrap.SchemaVersion < 3:
+		// The path length is that of the options.
+	case wrap.Depth <= 0 || wrap.Depth%8 != 0 || wrap.Depth > hasher.Size()*8:
+		depthErr = fmt.Errorf("%w: invalid depth %d", ErrSnapshotCorrupt, wrap.Depth)
+	default:
+		// Options given to the import still take precedence, and are
+		// checked against the recorded depth once the tree is built.
+		options = append([]Option{WithPathBits(wrap.Depth)}, options...)
+	}
+	smt := ImportSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), hasher, wrap.Root, options...)
+	smt.debugf("importing trie at root %x", wrap.Root)
+	fail := func(err error) (*SparseMerkleTree, error) {
+		smt.warnf("failed to import trie at root %x: %v", wrap.Root, err)
+		return nil, err
+	}
+	if err := checkTrieWrap(wrap); err != nil {
+		return fail(err)
+	}
+	if name := wrap.hashName(); checkHash && name != "" {
+		if actual := smt.th.hashName(); actual != name {
+			return fail(fmt.Errorf("%w: exported with %q, importing with %q", ErrHashMismatch, name, actual))
+		}
+	}
+	if depthErr != nil {
+		return fail(depthErr)
+	}
+
+	// takes the encoded maps for an smt and returns the smt
+	smn, smv, err := ImportMerkleMap(wrap.NodesBytes, wrap.ValuesBytes)
 	if err != nil {
+		return fail(err)
+	}
+	smt.debugf("imported trie at root %x with %d nodes and %d values", wrap.Root, len(smn.m), len(smv.m))
+	smt.nodes, smt.values = smn, smv
+	if wrap.SchemaVersion >= 3 && smt.depth() != wrap.Depth {
+		return fail(fmt.Errorf("%w: exported with %d bits, importing with %d", ErrDepthMismatch, wrap.Depth, smt.depth()))
+	}
+	if smt.checkNodeHashes {
+		if err := smt.checkStoredHashes(smn); err != nil {
+			return fail(err)
+		}
+	}
+	if smt.verifyImport {
+		if err := smt.verifyImportedNode(wrap.Root, 0); err != nil {
+			return fail(err)
+		}
+	}
+	return smt, nil
+}
+
+// verifyImportedNode checks the node at hash, depth levels below the root,
+// and the nodes and values under it, for WithImportVerification.
+func (smt *SparseMerkleTree) verifyImportedNode(hash []byte, depth int) error {
+	if bytes.Equal(hash, smt.th.placeholder()) {
+		return nil
+	}
+	if depth > smt.depth() {
+		return fmt.Errorf("%w: node %x is %d levels below the root, deeper than the tree", ErrTrieCorrupt, hash, depth)
+	}
+	stored, err := smt.nodes.Get(hash)
+	if errors.Is(err, ErrKeyNotFound) {
+		return fmt.Errorf("%w: node %x, %d levels below the root, is missing", ErrTrieCorrupt, hash, depth)
+	} else if err != nil {
+		return err
+	}
+	if !smt.th.validEncodedNode(stored) || !smt.th.validNode(smt.th.decodeNode(stored)) {
+		return fmt.Errorf("%w: node %x, %d levels below the root, is malformed", ErrTrieCorrupt, hash, depth)
+	}
+	data := smt.th.decodeNode(stored)
+	if actual := smt.th.digest(data); !bytes.Equal(actual, hash) {
+		return fmt.Errorf("%w: node %x, %d levels below the root, hashes to %x", ErrTrieCorrupt, hash, depth, actual)
+	}
+	if !smt.th.isLeaf(data) {
+		leftNode, rightNode := smt.th.parseNode(data)
+		if err := smt.verifyImportedNode(leftNode, depth+1); err != nil {
+			return err
+		}
+		return smt.verifyImportedNode(rightNode, depth+1)
+	}
+
+	path, valueHash := smt.th.parseLeaf(data)
+	if len(valueHash) == 0 {
+		// Presence leaves commit to no value.
+		return nil
+	}
+	value, err := smt.getValue(path)
+	if errors.Is(err, ErrKeyNotFound) {
+		if smt.checkMissingValues {
+			return fmt.Errorf("%w: value of leaf %x at path %x is missing", ErrTrieCorrupt, hash, path)
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !bytes.Equal(smt.th.digestValue(value), valueHash) {
+		return fmt.Errorf("%w: value at path %x does not hash to that of leaf %x", ErrTrieCorrupt, path, hash)
+	}
+	return nil
+}
+
+// checkStoredHashes checks that every node in nodes is stored under its hash.
+func (smt *SparseMerkleTree) checkStoredHashes(nodes *SimpleMap) error {
+	for key, data := range nodes.m {
+		if !bytes.Equal(smt.th.digest(smt.th.decodeNode(data)), []byte(key)) {
+			return fmt.Errorf("%w: %x", ErrNodeHashMismatch, key)
+		}
+	}
+	return nil
+}
+
+// ExportTrie exports the root and stores of a trie as they were at a single
+// point in time, even while it is being updated. Stores that can take a cheap
+// copy of their contents, like SimpleMap, block updates only while copying;
+// other stores block them for the whole export. The nodes and values are
+// exported concurrently, so a tree whose node and value stores share a
+// backend must support concurrent calls to Export. See SetOperationLimit.
+func ExportTrie(trie *SparseMerkleTree, options ...TraversalOption) (*TrieWrap, error) {
+	trie.mu.RLock()
+	root := trie.root
+	if err := trie.checkOperationLimit(root, options); err != nil {
+		trie.mu.RUnlock()
 		return nil, err
 	}
+	trie.debugf("exporting trie at root %x", root)
+	nodes, nodesCopied := snapshotStore(trie.nodes)
+	values, valuesCopied := snapshotStore(trie.values)
+	if nodesCopied && valuesCopied {
+		trie.mu.RUnlock()
+	} else {
+		defer trie.mu.RUnlock()
+	}
 
-	valuesBytes, err := trie.values.Export()
+	nodesBytes, valuesBytes, err := exportStores(nodes, values)
 	if err != nil {
 		return nil, err
 	}
 
 	wrap := TrieWrap{
-		Root:        trie.Root(),
-		NodesBytes:  nodesBytes,
-		ValuesBytes: valuesBytes,
+		Root:          root,
+		NodesBytes:    nodesBytes,
+		ValuesBytes:   valuesBytes,
+		SchemaVersion: trieWrapSchemaVersion,
+		Hash:          trie.th.hashName(),
+		Depth:         trie.depth(),
 	}
+	trie.debugf("exported trie at root %x with %d bytes of nodes and %d bytes of values", root, len(nodesBytes), len(valuesBytes))
 	return &wrap, nil
 }
 
+// exportStores exports nodes and values concurrently, as the stores of a tree
+// are independent and each export may be a full iteration of a database.
+func exportStores(nodes, values MapStore) ([]byte, []byte, error) {
+	var valuesBytes []byte
+	var valuesErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		valuesBytes, valuesErr = values.Export()
+	}()
+	nodesBytes, err := nodes.Export()
+	wg.Wait()
+	if err != nil {
+		return nil, nil, err
+	}
+	if valuesErr != nil {
+		return nil, nil, valuesErr
+	}
+	return nodesBytes, valuesBytes, nil
+}
+
+// snapshotter is implemented by stores that can take a cheap point-in-time
+// copy of their contents.
+type snapshotter interface {
+	snapshot() MapStore
+}
+
+// snapshotStore returns a copy of store and true if it is a snapshotter, or
+// store itself and false otherwise.
+func snapshotStore(store MapStore) (MapStore, bool) {
+	if s, ok := store.(snapshotter); ok {
+		return s.snapshot(), true
+	}
+	return store, false
+}
+
+// snapshot returns a copy of the map. Values are shared with the copy, as
+// they are replaced rather than modified in place.
+func (sm *SimpleMap) snapshot() MapStore {
+	defer sm.rlock()()
+	m := make(map[string][]byte, len(sm.m))
+	for key, value := range sm.m {
+		m[key] = value
+	}
+	snapshot := &SimpleMap{m: m}
+	if sm.mu != nil {
+		snapshot.mu = new(sync.RWMutex)
+	}
+	return snapshot
+}
+
 func ImportMerkleMap(nodesBytes, valuesBytes []byte) (*SimpleMap, *SimpleMap, error) {
 	var smn, smv SimpleMap
-	err := GobDecode(nodesBytes, &smn.m)
+	err := decodeSnapshot(nodesBytes, &smn.m)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	err = GobDecode(valuesBytes, &smv.m)
+	err = decodeSnapshot(valuesBytes, &smv.m)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -3,7 +3,14 @@ package smt
 import (
 	"bytes"
 	"crypto/sha256"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
+
+	"golang.org/x/crypto/sha3"
 )
 
 func TestSimpleMap(t *testing.T) {
@@ -47,3 +54,440 @@ func TestSimpleMap(t *testing.T) {
 		t.Error("deleting a key did not return an error on a non-existent key")
 	}
 }
+
+// Test exporting a trie while it is being updated. Run with -race.
+func TestExportTrieConcurrent(t *testing.T) {
+	trie := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	roots := make(map[string]bool)
+	roots[string(trie.Root())] = true
+	var mu sync.Mutex
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			root, err := trie.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte("testValue"))
+			if err != nil {
+				t.Errorf("returned error when updating: %v", err)
+				return
+			}
+			mu.Lock()
+			roots[string(root)] = true
+			mu.Unlock()
+		}
+	}()
+
+	for exporting := true; exporting; {
+		select {
+		case <-done:
+			exporting = false
+		default:
+		}
+		wrap, err := ExportTrie(trie)
+		if err != nil {
+			t.Fatalf("returned error when exporting: %v", err)
+		}
+		mu.Lock()
+		known := roots[string(wrap.Root)]
+		mu.Unlock()
+		if !known {
+			t.Fatal("exported root is not one of the tree's roots")
+		}
+
+		// The exported stores hold exactly the tree at the exported root.
+		nodes, values, err := ImportMerkleMap(wrap.NodesBytes, wrap.ValuesBytes)
+		if err != nil {
+			t.Fatalf("returned error when importing: %v", err)
+		}
+		imported := ImportSparseMerkleTree(nodes, values, sha256.New(), wrap.Root)
+		branches, leaves, err := imported.NodeCount()
+		if err != nil {
+			t.Fatalf("exported tree is torn: %v", err)
+		}
+		if branches+leaves != len(nodes.m) || leaves != len(values.m) {
+			t.Fatal("exported stores do not match the exported root")
+		}
+	}
+}
+
+// overlappingStore is a MapStore whose Export waits, up to a timeout, for the
+// Export of the other stores sharing started to begin.
+type overlappingStore struct {
+	MapStore
+	started *sync.WaitGroup
+}
+
+func (s *overlappingStore) Export() ([]byte, error) {
+	s.started.Done()
+	overlapped := make(chan struct{})
+	go func() {
+		s.started.Wait()
+		close(overlapped)
+	}()
+	select {
+	case <-overlapped:
+	case <-time.After(5 * time.Second):
+		return nil, errors.New("exports did not overlap")
+	}
+	return s.MapStore.Export()
+}
+
+func TestExportTrieParallel(t *testing.T) {
+	var started sync.WaitGroup
+	started.Add(2)
+	nodes := &overlappingStore{MapStore: NewSimpleMap(), started: &started}
+	values := &overlappingStore{MapStore: NewSimpleMap(), started: &started}
+	trie := NewSparseMerkleTree(nodes, values, sha256.New())
+	for i := 0; i < 100; i++ {
+		trie.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+
+	wrap, err := ExportTrie(trie)
+	if err != nil {
+		t.Fatalf("returned error when exporting: %v", err)
+	}
+
+	// The concurrent export holds the same entries as sequential ones.
+	for _, export := range []struct {
+		store  MapStore
+		serial []byte
+	}{{nodes.MapStore, wrap.NodesBytes}, {values.MapStore, wrap.ValuesBytes}} {
+		sequential, err := export.store.Export()
+		if err != nil {
+			t.Fatalf("returned error when exporting store: %v", err)
+		}
+		var expected, got map[string][]byte
+		if err := decodeSnapshot(sequential, &expected); err != nil {
+			t.Fatalf("returned error when decoding sequential export: %v", err)
+		}
+		if err := decodeSnapshot(export.serial, &got); err != nil {
+			t.Fatalf("returned error when decoding concurrent export: %v", err)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Error("concurrent export differs from sequential export")
+		}
+	}
+}
+
+func TestTrieWrapSchemaVersion(t *testing.T) {
+	trie := NewMerkleTrie()
+	trie.Update([]byte("testKey"), []byte("testValue"))
+	wrap, err := ExportTrie(trie)
+	if err != nil {
+		t.Fatalf("returned error when exporting: %v", err)
+	}
+	if wrap.SchemaVersion != trieWrapSchemaVersion {
+		t.Errorf("exported schema version %d, expected %d", wrap.SchemaVersion, trieWrapSchemaVersion)
+	}
+
+	// TrieWraps encoded before the version was recorded decode as version 0.
+	legacy, err := GobEncode(struct {
+		Root        []byte
+		NodesBytes  []byte
+		ValuesBytes []byte
+	}{wrap.Root, wrap.NodesBytes, wrap.ValuesBytes})
+	if err != nil {
+		t.Fatalf("returned error when encoding legacy wrap: %v", err)
+	}
+	var decoded TrieWrap
+	if err := GobDecode(legacy, &decoded); err != nil {
+		t.Fatalf("returned error when decoding legacy wrap: %v", err)
+	}
+	if decoded.SchemaVersion != 0 {
+		t.Errorf("legacy wrap decoded with schema version %d", decoded.SchemaVersion)
+	}
+	imported, err := ImportTrie(&decoded)
+	if err != nil {
+		t.Fatalf("returned error when importing legacy wrap: %v", err)
+	}
+	value, err := imported.Get([]byte("testKey"))
+	if err != nil || !bytes.Equal(value, []byte("testValue")) {
+		t.Errorf("did not get value from legacy wrap, got %s, %v", value, err)
+	}
+
+	encoded, err := GobEncode(wrap)
+	if err != nil {
+		t.Fatalf("returned error when encoding wrap: %v", err)
+	}
+	decoded = TrieWrap{}
+	if err := GobDecode(encoded, &decoded); err != nil || decoded.SchemaVersion != trieWrapSchemaVersion {
+		t.Errorf("did not decode schema version, got %d, %v", decoded.SchemaVersion, err)
+	}
+	if _, err := ImportTrie(&decoded); err != nil {
+		t.Errorf("returned error when importing wrap: %v", err)
+	}
+
+	decoded.SchemaVersion = trieWrapSchemaVersion + 1
+	if _, err := ImportTrie(&decoded); !errors.Is(err, ErrTrieWrapVersion) {
+		t.Errorf("did not return ErrTrieWrapVersion for unsupported version, got %v", err)
+	}
+}
+
+func TestTrieWrapDepth(t *testing.T) {
+	trie := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithPathBits(160))
+	for i := 0; i < 20; i++ {
+		trie.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	wrap, err := ExportTrie(trie)
+	if err != nil {
+		t.Fatalf("returned error when exporting: %v", err)
+	}
+	if wrap.Depth != 160 {
+		t.Errorf("exported depth %d, want 160", wrap.Depth)
+	}
+	imported, err := ImportTrie(wrap)
+	if err != nil {
+		t.Fatalf("returned error when importing: %v", err)
+	}
+	if value, err := imported.Get([]byte("testKey7")); err != nil || !bytes.Equal(value, []byte("testValue7")) {
+		t.Errorf("got %q, %v from the imported trie", value, err)
+	}
+	if imported.depth() != 160 {
+		t.Errorf("imported trie has depth %d, want 160", imported.depth())
+	}
+	if _, err := ImportTrie(wrap, WithPathBits(128)); !errors.Is(err, ErrDepthMismatch) {
+		t.Errorf("got %v importing with another path length, want ErrDepthMismatch", err)
+	}
+
+	// TrieWraps from before the depth was recorded take it from the options.
+	legacy := *wrap
+	legacy.SchemaVersion, legacy.Depth = 2, 0
+	if imported, err := ImportTrie(&legacy, WithPathBits(160)); err != nil || !bytes.Equal(imported.Root(), trie.Root()) {
+		t.Errorf("did not import legacy wrap, %v", err)
+	}
+
+	invalid := *wrap
+	invalid.Depth = 12
+	if _, err := ImportTrie(&invalid); !errors.Is(err, ErrSnapshotCorrupt) {
+		t.Errorf("got %v for an invalid depth, want ErrSnapshotCorrupt", err)
+	}
+}
+
+func TestNewMerkleTrieWithCapacity(t *testing.T) {
+	trie, hinted := NewMerkleTrie(), NewMerkleTrieWithCapacity(100)
+	for i := 0; i < 100; i++ {
+		key, value := []byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i))
+		trie.Update(key, value)
+		if _, err := hinted.Update(key, value); err != nil {
+			t.Errorf("returned error when updating preallocated trie: %v", err)
+		}
+	}
+	if !bytes.Equal(trie.Root(), hinted.Root()) {
+		t.Error("preallocated trie has a different root")
+	}
+}
+
+// benchmarkSimpleMapInsert inserts a million keys into the maps made by
+// newMap.
+func benchmarkSimpleMapInsert(b *testing.B, newMap func(n int) *SimpleMap) {
+	const n = 1000000
+	keys := make([][]byte, n)
+	for i := range keys {
+		key := sha256.Sum256([]byte(fmt.Sprintf("testKey%d", i)))
+		keys[i] = key[:]
+	}
+	value := []byte("testValue")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sm := newMap(n)
+		for _, key := range keys {
+			sm.Set(key, value)
+		}
+	}
+}
+
+func BenchmarkSimpleMapInsert1M(b *testing.B) {
+	benchmarkSimpleMapInsert(b, func(int) *SimpleMap { return NewSimpleMap() })
+}
+
+func BenchmarkSimpleMapInsert1MWithCapacity(b *testing.B) {
+	benchmarkSimpleMapInsert(b, NewSimpleMapWithCapacity)
+}
+
+func TestImportTrieNodeHashCheck(t *testing.T) {
+	trie := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha3.New256(), WithCompactNodes())
+	for i := 0; i < 20; i++ {
+		trie.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	wrap, err := ExportTrie(trie)
+	if err != nil {
+		t.Fatalf("returned error when exporting: %v", err)
+	}
+	if _, err := ImportTrie(wrap, WithNodeHashCheck()); err != nil {
+		t.Fatalf("returned error when importing untampered trie: %v", err)
+	}
+
+	// Store a node under the hash of another one.
+	nodes, values, err := ImportMerkleMap(wrap.NodesBytes, wrap.ValuesBytes)
+	if err != nil {
+		t.Fatalf("returned error when importing stores: %v", err)
+	}
+	var poisoned []byte
+	for key := range nodes.m {
+		if key != string(wrap.Root) {
+			poisoned = []byte(key)
+			break
+		}
+	}
+	nodes.Set(poisoned, []byte("poisoned"))
+	tampered := *wrap
+	if tampered.NodesBytes, err = nodes.Export(); err != nil {
+		t.Fatalf("returned error when exporting nodes: %v", err)
+	}
+	if tampered.ValuesBytes, err = values.Export(); err != nil {
+		t.Fatalf("returned error when exporting values: %v", err)
+	}
+	if _, err := ImportTrie(&tampered); err != nil {
+		t.Errorf("returned error when importing without check: %v", err)
+	}
+	if _, err := ImportTrie(&tampered, WithNodeHashCheck()); !errors.Is(err, ErrNodeHashMismatch) {
+		t.Errorf("did not return ErrNodeHashMismatch, got %v", err)
+	}
+}
+
+func TestImportTrieVerification(t *testing.T) {
+	trie := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha3.New256(), WithCompactNodes())
+	for i := 0; i < 20; i++ {
+		trie.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	trie.UpdateLeafHash([]byte("hashedKey"), bytes.Repeat([]byte{1}, 32))
+	wrap, err := ExportTrie(trie)
+	if err != nil {
+		t.Fatalf("returned error when exporting: %v", err)
+	}
+	if _, err := ImportTrie(wrap, WithImportVerification()); err != nil {
+		t.Fatalf("returned error when importing untampered trie: %v", err)
+	}
+
+	tamper := func(change func(nodes, values *SimpleMap)) *TrieWrap {
+		t.Helper()
+		nodes, values, err := ImportMerkleMap(wrap.NodesBytes, wrap.ValuesBytes)
+		if err != nil {
+			t.Fatalf("returned error when importing stores: %v", err)
+		}
+		change(nodes, values)
+		tampered := *wrap
+		tampered.NodesBytes, _ = nodes.Export()
+		tampered.ValuesBytes, _ = values.Export()
+		return &tampered
+	}
+	leaf, _ := trie.th.digestLeaf(trie.th.path([]byte("testKey3")), trie.th.digest([]byte("testValue3")))
+	for name, tampered := range map[string]*TrieWrap{
+		"missing node": tamper(func(nodes, values *SimpleMap) {
+			nodes.Delete(leaf)
+		}),
+		"tampered value": tamper(func(nodes, values *SimpleMap) {
+			values.Set(trie.th.path([]byte("testKey5")), []byte("otherValue"))
+		}),
+	} {
+		if _, err := ImportTrie(tampered); err != nil {
+			t.Errorf("returned error importing a trie with a %s without verification: %v", name, err)
+		}
+		if _, err := ImportTrie(tampered, WithImportVerification()); !errors.Is(err, ErrTrieCorrupt) {
+			t.Errorf("got %v importing a trie with a %s, want ErrTrieCorrupt", err, name)
+		}
+	}
+	truncated := *wrap
+	truncated.Root = bytes.Repeat([]byte{2}, 32)
+	if _, err := ImportTrie(&truncated, WithImportVerification()); !errors.Is(err, ErrTrieCorrupt) {
+		t.Errorf("got %v importing a trie without its root node, want ErrTrieCorrupt", err)
+	}
+
+	// Missing values are only reported with WithMissingValueCheck, as keys
+	// set with UpdateLeafHash have none.
+	missing := tamper(func(nodes, values *SimpleMap) {
+		values.Delete(trie.th.path([]byte("testKey7")))
+	})
+	if _, err := ImportTrie(missing, WithImportVerification()); err != nil {
+		t.Errorf("returned error importing a trie with a missing value: %v", err)
+	}
+	if _, err := ImportTrie(missing, WithImportVerification(), WithMissingValueCheck()); !errors.Is(err, ErrTrieCorrupt) {
+		t.Errorf("got %v importing a trie with a missing value, want ErrTrieCorrupt", err)
+	}
+}
+
+func TestBoundedSimpleMap(t *testing.T) {
+	sm := NewBoundedSimpleMap(2)
+	sm.Set([]byte("key1"), []byte("value1"))
+	sm.Set([]byte("key2"), []byte("value2"))
+	if err := sm.Set([]byte("key3"), []byte("value3")); !errors.Is(err, ErrStoreFull) {
+		t.Errorf("did not return ErrStoreFull, got %v", err)
+	}
+	if err := sm.Set([]byte("key1"), []byte("newValue1")); err != nil {
+		t.Errorf("returned error when replacing a value: %v", err)
+	}
+	sm.Delete([]byte("key2"))
+	if err := sm.Set([]byte("key3"), []byte("value3")); err != nil {
+		t.Errorf("returned error when setting after a delete: %v", err)
+	}
+
+	// A tree on a full store returns the error and keeps its root.
+	smt := NewSparseMerkleTree(NewBoundedSimpleMap(20), NewSimpleMap(), sha256.New())
+	var err error
+	var n int
+	for n = 0; n < 20; n++ {
+		root := smt.Root()
+		if _, err = smt.Update([]byte(fmt.Sprintf("testKey%d", n)), []byte("testValue")); err != nil {
+			if !bytes.Equal(smt.Root(), root) {
+				t.Error("root changed on a failed update")
+			}
+			break
+		}
+	}
+	if !errors.Is(err, ErrStoreFull) {
+		t.Fatalf("did not return ErrStoreFull from Update, got %v", err)
+	}
+	for i := 0; i < n; i++ {
+		if value, err := smt.Get([]byte(fmt.Sprintf("testKey%d", i))); err != nil || !bytes.Equal(value, []byte("testValue")) {
+			t.Errorf("did not get value %d after full store, got %v, %v", i, value, err)
+		}
+	}
+}
+
+// missingStore is a MapStore that reports missing keys with a wrapped
+// ErrKeyNotFound rather than an InvalidKeyError, and fails reads of keys in
+// broken.
+type missingStore struct {
+	*SimpleMap
+	broken map[string]bool
+}
+
+var errBackend = errors.New("backend failed")
+
+func (s *missingStore) Get(key []byte) ([]byte, error) {
+	if s.broken[string(key)] {
+		return nil, errBackend
+	}
+	value, err := s.SimpleMap.Get(key)
+	if err != nil {
+		return nil, fmt.Errorf("missing %x: %w", key, ErrKeyNotFound)
+	}
+	return value, nil
+}
+
+func TestKeyNotFound(t *testing.T) {
+	if _, err := NewSimpleMap().Get([]byte("foo")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("InvalidKeyError is not an ErrKeyNotFound: %v", err)
+	}
+	if !errors.Is(ErrInvalidProof, ErrBadProof) {
+		t.Error("ErrInvalidProof is not ErrBadProof")
+	}
+
+	values := &missingStore{SimpleMap: NewSimpleMap(), broken: map[string]bool{}}
+	smt := NewSparseMerkleTree(NewSimpleMap(), values, sha256.New())
+	smt.Update([]byte("foo"), []byte("bar"))
+	if value, err := smt.Get([]byte("baz")); err != nil || len(value) != 0 {
+		t.Errorf("got %q, %v for a key the store wraps ErrKeyNotFound for", value, err)
+	}
+
+	// A failing store is not taken for a missing key.
+	values.broken[string(smt.th.path([]byte("foo")))] = true
+	_, err := smt.Get([]byte("foo"))
+	if !errors.Is(err, errBackend) {
+		t.Errorf("got %v from a failing store, want the error of the store", err)
+	}
+	if errors.Is(err, ErrKeyNotFound) {
+		t.Error("error of a failing store is an ErrKeyNotFound")
+	}
+}
@@ -0,0 +1,351 @@
+package smt
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// The JSON and CBOR encodings of proofs are objects, or maps with text keys,
+// with the fields below, JSON encoding byte strings in hex; empty fields are
+// left out. Exported tries encode as in FormatJSON, whose fields CBOR
+// encodes alike, with the nodes and values as maps of byte strings.
+
+// hexBytes encodes as a hex string in JSON.
+type hexBytes []byte
+
+func (h hexBytes) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(h)), nil
+}
+
+func (h *hexBytes) UnmarshalText(text []byte) error {
+	b, err := hex.DecodeString(string(text))
+	*h = b
+	return err
+}
+
+// proofJSON is the JSON encoding of proofs.
+type proofJSON struct {
+	SideNodes             []hexBytes `json:"sideNodes"`
+	NonMembershipLeafData hexBytes   `json:"nonMembershipLeafData,omitempty"`
+	BitMask               hexBytes   `json:"bitMask,omitempty"`
+	NumSideNodes          int        `json:"numSideNodes,omitempty"`
+	SiblingData           hexBytes   `json:"siblingData,omitempty"`
+	Depth                 int        `json:"depth,omitempty"`
+}
+
+func toHexBytes(sideNodes [][]byte) []hexBytes {
+	out := make([]hexBytes, len(sideNodes))
+	for i, sideNode := range sideNodes {
+		out[i] = sideNode
+	}
+	return out
+}
+
+func fromHexBytes(sideNodes []hexBytes) [][]byte {
+	if len(sideNodes) == 0 {
+		return nil
+	}
+	out := make([][]byte, len(sideNodes))
+	for i, sideNode := range sideNodes {
+		out[i] = append([]byte{}, sideNode...)
+	}
+	return out
+}
+
+// checkProofJSON returns an error for a JSON proof whose integers are out of
+// range.
+func checkProofJSON(p *proofJSON) error {
+	if p.NumSideNodes < 0 || p.Depth < 0 || p.NumSideNodes > math.MaxInt32 || p.Depth > math.MaxInt32 {
+		return fmt.Errorf("%w: integer out of range", ErrBadProof)
+	}
+	return nil
+}
+
+// MarshalJSON encodes the proof as a JSON object.
+func (proof SparseMerkleProof) MarshalJSON() ([]byte, error) {
+	return json.Marshal(proofJSON{
+		SideNodes:             toHexBytes(proof.SideNodes),
+		NonMembershipLeafData: proof.NonMembershipLeafData,
+		SiblingData:           proof.SiblingData,
+		Depth:                 proof.Depth,
+	})
+}
+
+// UnmarshalJSON decodes a proof encoded with MarshalJSON, returning
+// ErrBadProof if it is malformed.
+func (proof *SparseMerkleProof) UnmarshalJSON(data []byte) error {
+	var p proofJSON
+	if err := json.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadProof, err)
+	}
+	if err := checkProofJSON(&p); err != nil {
+		return err
+	}
+	*proof = SparseMerkleProof{
+		SideNodes:             fromHexBytes(p.SideNodes),
+		NonMembershipLeafData: emptyToNil(p.NonMembershipLeafData),
+		SiblingData:           emptyToNil(p.SiblingData),
+		Depth:                 p.Depth,
+	}
+	return nil
+}
+
+// MarshalJSON encodes the proof as a JSON object.
+func (proof SparseCompactMerkleProof) MarshalJSON() ([]byte, error) {
+	return json.Marshal(proofJSON{
+		SideNodes:             toHexBytes(proof.SideNodes),
+		NonMembershipLeafData: proof.NonMembershipLeafData,
+		BitMask:               proof.BitMask,
+		NumSideNodes:          proof.NumSideNodes,
+		SiblingData:           proof.SiblingData,
+		Depth:                 proof.Depth,
+	})
+}
+
+// UnmarshalJSON decodes a proof encoded with MarshalJSON, returning
+// ErrBadProof if it is malformed.
+func (proof *SparseCompactMerkleProof) UnmarshalJSON(data []byte) error {
+	var p proofJSON
+	if err := json.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadProof, err)
+	}
+	if err := checkProofJSON(&p); err != nil {
+		return err
+	}
+	*proof = SparseCompactMerkleProof{
+		SideNodes:             fromHexBytes(p.SideNodes),
+		NonMembershipLeafData: emptyToNil(p.NonMembershipLeafData),
+		BitMask:               emptyToNil(p.BitMask),
+		NumSideNodes:          p.NumSideNodes,
+		SiblingData:           emptyToNil(p.SiblingData),
+		Depth:                 p.Depth,
+	}
+	return nil
+}
+
+// MarshalJSON encodes the exported trie in FormatJSON.
+func (wrap TrieWrap) MarshalJSON() ([]byte, error) {
+	return EncodeTrieWrap(&wrap, FormatJSON)
+}
+
+// UnmarshalJSON decodes an exported trie in FormatJSON.
+func (wrap *TrieWrap) UnmarshalJSON(data []byte) error {
+	decoded, err := DecodeTrieWrap(data, FormatJSON)
+	if err != nil {
+		return err
+	}
+	*wrap = *decoded
+	return nil
+}
+
+// Keys of the CBOR encodings, as in their JSON encodings.
+const (
+	cborSideNodes             = "sideNodes"
+	cborNonMembershipLeafData = "nonMembershipLeafData"
+	cborBitMask               = "bitMask"
+	cborNumSideNodes          = "numSideNodes"
+	cborSiblingData           = "siblingData"
+	cborDepth                 = "depth"
+	cborSchemaVersion         = "schemaVersion"
+	cborHash                  = "hash"
+	cborRoot                  = "root"
+	cborNodes                 = "nodes"
+	cborValues                = "values"
+)
+
+// cborProof is the CBOR encoding of the fields of a proof, those of a
+// SparseMerkleProof having no bit mask or number of side nodes.
+func cborProof(p proofJSON) []byte {
+	var mw cborMapWriter
+	var sideNodes []byte
+	sideNodes = appendCBORHead(sideNodes, cborArray, uint64(len(p.SideNodes)))
+	for _, sideNode := range p.SideNodes {
+		sideNodes = appendCBORBytes(sideNodes, cborBytes, sideNode)
+	}
+	mw.text(cborSideNodes, sideNodes)
+	for _, field := range []struct {
+		key   string
+		value []byte
+	}{{cborNonMembershipLeafData, p.NonMembershipLeafData}, {cborBitMask, p.BitMask}, {cborSiblingData, p.SiblingData}} {
+		if len(field.value) > 0 {
+			mw.text(field.key, appendCBORBytes(nil, cborBytes, field.value))
+		}
+	}
+	for _, field := range []struct {
+		key   string
+		value int
+	}{{cborNumSideNodes, p.NumSideNodes}, {cborDepth, p.Depth}} {
+		if field.value != 0 {
+			mw.text(field.key, appendCBORHead(nil, cborUint, uint64(field.value)))
+		}
+	}
+	return mw.appendTo(nil)
+}
+
+// parseCBORProof decodes the fields of a proof encoded by cborProof.
+func parseCBORProof(data []byte) (proofJSON, error) {
+	var p proofJSON
+	cr := &cborReader{data: data}
+	for n := cr.length(cborMap); n > 0 && cr.err == nil; n-- {
+		switch string(cr.bytes(cborText)) {
+		case cborSideNodes:
+			count := cr.length(cborArray)
+			p.SideNodes = make([]hexBytes, 0, count)
+			for i := 0; i < count && cr.err == nil; i++ {
+				p.SideNodes = append(p.SideNodes, cr.bytes(cborBytes))
+			}
+		case cborNonMembershipLeafData:
+			p.NonMembershipLeafData = cr.bytes(cborBytes)
+		case cborBitMask:
+			p.BitMask = cr.bytes(cborBytes)
+		case cborSiblingData:
+			p.SiblingData = cr.bytes(cborBytes)
+		case cborNumSideNodes:
+			p.NumSideNodes = int(cr.uint(math.MaxInt32))
+		case cborDepth:
+			p.Depth = int(cr.uint(math.MaxInt32))
+		default:
+			cr.skip()
+		}
+	}
+	if err := cr.done(); err != nil {
+		return proofJSON{}, fmt.Errorf("%w: %v", ErrBadProof, err)
+	}
+	return p, nil
+}
+
+// MarshalCBOR encodes the proof as a CBOR map, deterministically.
+func (proof SparseMerkleProof) MarshalCBOR() ([]byte, error) {
+	return cborProof(proofJSON{
+		SideNodes:             toHexBytes(proof.SideNodes),
+		NonMembershipLeafData: proof.NonMembershipLeafData,
+		SiblingData:           proof.SiblingData,
+		Depth:                 proof.Depth,
+	}), nil
+}
+
+// UnmarshalCBOR decodes a proof encoded with MarshalCBOR, returning
+// ErrBadProof if it is malformed.
+func (proof *SparseMerkleProof) UnmarshalCBOR(data []byte) error {
+	p, err := parseCBORProof(data)
+	if err != nil {
+		return err
+	}
+	*proof = SparseMerkleProof{
+		SideNodes:             fromHexBytes(p.SideNodes),
+		NonMembershipLeafData: emptyToNil(p.NonMembershipLeafData),
+		SiblingData:           emptyToNil(p.SiblingData),
+		Depth:                 p.Depth,
+	}
+	return nil
+}
+
+// MarshalCBOR encodes the proof as a CBOR map, deterministically.
+func (proof SparseCompactMerkleProof) MarshalCBOR() ([]byte, error) {
+	return cborProof(proofJSON{
+		SideNodes:             toHexBytes(proof.SideNodes),
+		NonMembershipLeafData: proof.NonMembershipLeafData,
+		BitMask:               proof.BitMask,
+		NumSideNodes:          proof.NumSideNodes,
+		SiblingData:           proof.SiblingData,
+		Depth:                 proof.Depth,
+	}), nil
+}
+
+// UnmarshalCBOR decodes a proof encoded with MarshalCBOR, returning
+// ErrBadProof if it is malformed.
+func (proof *SparseCompactMerkleProof) UnmarshalCBOR(data []byte) error {
+	p, err := parseCBORProof(data)
+	if err != nil {
+		return err
+	}
+	*proof = SparseCompactMerkleProof{
+		SideNodes:             fromHexBytes(p.SideNodes),
+		NonMembershipLeafData: emptyToNil(p.NonMembershipLeafData),
+		BitMask:               emptyToNil(p.BitMask),
+		NumSideNodes:          p.NumSideNodes,
+		SiblingData:           emptyToNil(p.SiblingData),
+		Depth:                 p.Depth,
+	}
+	return nil
+}
+
+// MarshalCBOR encodes the exported trie as a CBOR map, deterministically.
+func (wrap TrieWrap) MarshalCBOR() ([]byte, error) {
+	var mw cborMapWriter
+	mw.text(cborSchemaVersion, appendCBORHead(nil, cborUint, uint64(wrap.SchemaVersion)))
+	if wrap.Hash != "" {
+		mw.text(cborHash, appendCBORBytes(nil, cborText, []byte(wrap.Hash)))
+	}
+	if wrap.Depth != 0 {
+		mw.text(cborDepth, appendCBORHead(nil, cborUint, uint64(wrap.Depth)))
+	}
+	mw.text(cborRoot, appendCBORBytes(nil, cborBytes, wrap.Root))
+	for _, store := range []struct {
+		key    string
+		serial []byte
+	}{{cborNodes, wrap.NodesBytes}, {cborValues, wrap.ValuesBytes}} {
+		var m map[string][]byte
+		if err := decodeSnapshot(store.serial, &m); err != nil {
+			return nil, err
+		}
+		var entries cborMapWriter
+		for key, value := range m {
+			entries.add(appendCBORBytes(nil, cborBytes, []byte(key)), appendCBORBytes(nil, cborBytes, value))
+		}
+		mw.text(store.key, entries.appendTo(nil))
+	}
+	return mw.appendTo(nil), nil
+}
+
+// UnmarshalCBOR decodes an exported trie encoded with MarshalCBOR, returning
+// ErrSnapshotCorrupt if it is malformed.
+func (wrap *TrieWrap) UnmarshalCBOR(data []byte) error {
+	var w TrieWrap
+	nodes, values := make(map[string][]byte), make(map[string][]byte)
+	cr := &cborReader{data: data}
+	for n := cr.length(cborMap); n > 0 && cr.err == nil; n-- {
+		switch string(cr.bytes(cborText)) {
+		case cborSchemaVersion:
+			w.SchemaVersion = int(cr.uint(math.MaxInt32))
+		case cborHash:
+			w.Hash = string(cr.bytes(cborText))
+		case cborDepth:
+			w.Depth = int(cr.uint(math.MaxInt32))
+		case cborRoot:
+			w.Root = cr.bytes(cborBytes)
+		case cborNodes:
+			cr.entries(nodes)
+		case cborValues:
+			cr.entries(values)
+		default:
+			cr.skip()
+		}
+	}
+	if err := cr.done(); err != nil {
+		return fmt.Errorf("%w: %v", ErrSnapshotCorrupt, err)
+	}
+	var err error
+	if w.NodesBytes, err = encodeSnapshot(nodes); err != nil {
+		return err
+	}
+	if w.ValuesBytes, err = encodeSnapshot(values); err != nil {
+		return err
+	}
+	*wrap = w
+	return nil
+}
+
+// entries reads a map of byte strings into m, failing for duplicate keys.
+func (cr *cborReader) entries(m map[string][]byte) {
+	for n := cr.length(cborMap); n > 0 && cr.err == nil; n-- {
+		key := cr.bytes(cborBytes)
+		value := cr.bytes(cborBytes)
+		if _, ok := m[string(key)]; ok && cr.err == nil {
+			cr.err = fmt.Errorf("duplicate key %x", key)
+		}
+		m[string(key)] = value
+	}
+}
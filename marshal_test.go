@@ -0,0 +1,147 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProofMarshaling(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 20; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	membership, _ := smt.ProveUpdatable([]byte("testKey3"))
+	nonMembership, _ := smt.Prove([]byte("otherKey"))
+
+	for _, proof := range []SparseMerkleProof{membership, nonMembership} {
+		data, err := json.Marshal(proof)
+		if err != nil {
+			t.Fatalf("returned error when encoding proof as JSON: %v", err)
+		}
+		if !strings.Contains(string(data), fmt.Sprintf(`"%x"`, proof.SideNodes[0])) {
+			t.Errorf("side nodes are not hex encoded: %s", data)
+		}
+		var decoded SparseMerkleProof
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("returned error when decoding JSON proof: %v", err)
+		}
+		if !reflect.DeepEqual(decoded, proof) {
+			t.Errorf("decoded JSON proof differs, got %+v, want %+v", decoded, proof)
+		}
+
+		data, _ = proof.MarshalCBOR()
+		decoded = SparseMerkleProof{}
+		if err := decoded.UnmarshalCBOR(data); err != nil {
+			t.Fatalf("returned error when decoding CBOR proof: %v", err)
+		}
+		if !reflect.DeepEqual(decoded, proof) {
+			t.Errorf("decoded CBOR proof differs, got %+v, want %+v", decoded, proof)
+		}
+	}
+
+	compact, _ := CompactProof(membership, sha256.New())
+	data, _ := json.Marshal(compact)
+	var decodedCompact SparseCompactMerkleProof
+	if err := json.Unmarshal(data, &decodedCompact); err != nil {
+		t.Fatalf("returned error when decoding JSON compact proof: %v", err)
+	}
+	if !VerifyCompactProof(decodedCompact, smt.Root(), []byte("testKey3"), []byte("testValue3"), sha256.New()) {
+		t.Error("decoded JSON compact proof does not verify")
+	}
+	data, _ = compact.MarshalCBOR()
+	decodedCompact = SparseCompactMerkleProof{}
+	if err := decodedCompact.UnmarshalCBOR(data); err != nil {
+		t.Fatalf("returned error when decoding CBOR compact proof: %v", err)
+	}
+	if !reflect.DeepEqual(decodedCompact, compact) {
+		t.Error("decoded CBOR compact proof differs")
+	}
+
+	var decoded SparseMerkleProof
+	for name, data := range map[string]string{
+		"bad hex":        `{"sideNodes":["zz"]}`,
+		"negative depth": `{"sideNodes":[],"depth":-1}`,
+		"not an object":  `[]`,
+	} {
+		if err := json.Unmarshal([]byte(data), &decoded); !errors.Is(err, ErrBadProof) {
+			t.Errorf("%s: did not return ErrBadProof, got %v", name, err)
+		}
+	}
+	cbor, _ := membership.MarshalCBOR()
+	for name, data := range map[string][]byte{
+		"truncated":    cbor[:len(cbor)-1],
+		"not a map":    {0x80},
+		"wrong type":   {0xa1, 0x65, 'd', 'e', 'p', 't', 'h', 0x40},
+		"out of range": {0xa1, 0x65, 'd', 'e', 'p', 't', 'h', 0x1b, 0xff, 0, 0, 0, 0, 0, 0, 0},
+	} {
+		if err := decoded.UnmarshalCBOR(data); !errors.Is(err, ErrBadProof) {
+			t.Errorf("%s: did not return ErrBadProof, got %v", name, err)
+		}
+	}
+}
+
+func TestTrieWrapMarshaling(t *testing.T) {
+	build := func(reversed bool) *SparseMerkleTree {
+		trie := NewMerkleTrie()
+		for i := 0; i < 20; i++ {
+			j := i
+			if reversed {
+				j = 19 - i
+			}
+			trie.Update([]byte(fmt.Sprintf("testKey%d", j)), []byte(fmt.Sprintf("testValue%d", j)))
+		}
+		return trie
+	}
+	trie := build(false)
+	wrap, _ := ExportTrie(trie)
+	otherWrap, _ := ExportTrie(build(true))
+
+	jsonData, err := json.Marshal(wrap)
+	if err != nil {
+		t.Fatalf("returned error when encoding trie as JSON: %v", err)
+	}
+	if formatted, _ := EncodeTrieWrap(wrap, FormatJSON); !bytes.Equal(jsonData, formatted) {
+		t.Error("JSON trie is not in FormatJSON")
+	}
+	cborData, err := wrap.MarshalCBOR()
+	if err != nil {
+		t.Fatalf("returned error when encoding trie as CBOR: %v", err)
+	}
+	if other, _ := otherWrap.MarshalCBOR(); !bytes.Equal(cborData, other) {
+		t.Error("CBOR encodings of the same trie differ")
+	}
+
+	var fromJSON, fromCBOR TrieWrap
+	if err := json.Unmarshal(jsonData, &fromJSON); err != nil {
+		t.Fatalf("returned error when decoding JSON trie: %v", err)
+	}
+	if err := fromCBOR.UnmarshalCBOR(cborData); err != nil {
+		t.Fatalf("returned error when decoding CBOR trie: %v", err)
+	}
+	for name, decoded := range map[string]*TrieWrap{"JSON": &fromJSON, "CBOR": &fromCBOR} {
+		if !reflect.DeepEqual(decoded, wrap) {
+			t.Errorf("decoded %s trie differs", name)
+		}
+		imported, err := ImportTrie(decoded)
+		if err != nil {
+			t.Fatalf("returned error when importing %s trie: %v", name, err)
+		}
+		if value, _ := imported.Get([]byte("testKey7")); !bytes.Equal(value, []byte("testValue7")) {
+			t.Errorf("%s trie lost a value", name)
+		}
+	}
+
+	if err := fromCBOR.UnmarshalCBOR(cborData[:len(cborData)-1]); !errors.Is(err, ErrSnapshotCorrupt) {
+		t.Errorf("did not return ErrSnapshotCorrupt for a truncated trie, got %v", err)
+	}
+	duplicate := []byte{0xa1, 0x65, 'n', 'o', 'd', 'e', 's', 0xa2, 0x41, 'k', 0x40, 0x41, 'k', 0x40}
+	if err := fromCBOR.UnmarshalCBOR(duplicate); !errors.Is(err, ErrSnapshotCorrupt) {
+		t.Errorf("did not return ErrSnapshotCorrupt for duplicate keys, got %v", err)
+	}
+}
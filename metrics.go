@@ -0,0 +1,87 @@
+package smt
+
+import (
+	"fmt"
+	"time"
+)
+
+// Operation is an operation of a tree measured by Metrics.
+type Operation int
+
+const (
+	OperationGet Operation = iota + 1
+	OperationUpdate
+	OperationDelete
+	OperationProve
+	OperationCommit
+)
+
+func (op Operation) String() string {
+	switch op {
+	case OperationGet:
+		return "get"
+	case OperationUpdate:
+		return "update"
+	case OperationDelete:
+		return "delete"
+	case OperationProve:
+		return "prove"
+	case OperationCommit:
+		return "commit"
+	}
+	return fmt.Sprintf("Operation(%d)", int(op))
+}
+
+// Metrics receives measurements of a tree, to be exported to a monitoring
+// system; package smtprom records them in Prometheus collectors. Its methods
+// are called on the paths they measure, from every goroutine using the tree,
+// so they should be quick and safe for concurrent use.
+type Metrics interface {
+	// ObserveOperation records that a call of Get, Update, Delete, Prove or
+	// ProveCompact, or Commit, took d, and failed if err is not nil.
+	ObserveOperation(op Operation, d time.Duration, err error)
+	// ObserveProofSize records the size in bytes of the hashes and leaf data
+	// of a proof returned by Prove or ProveCompact.
+	ObserveProofSize(size int)
+	// AddNodeReads records nodes read from the node store, and from the
+	// node cache instead; see EnableNodeHashCache.
+	AddNodeReads(fromStore, fromCache int)
+	// AddNodeWrites records nodes written to the node store, and deleted
+	// from it.
+	AddNodeWrites(written, deleted int)
+}
+
+// WithMetrics sets the metrics of a tree. Trees measure nothing by default.
+func WithMetrics(metrics Metrics) Option {
+	if metrics == nil {
+		panic("smt: nil metrics")
+	}
+	return func(smt *SparseMerkleTree) {
+		smt.metrics = metrics
+	}
+}
+
+// observe records that op, started at start, failed with err. Callers check
+// that the tree has metrics.
+func (smt *SparseMerkleTree) observe(op Operation, start time.Time, err error) {
+	smt.metrics.ObserveOperation(op, time.Since(start), err)
+}
+
+// proofSize returns the size of proof, as recorded by ObserveProofSize.
+func proofSize(proof SparseMerkleProof) int {
+	size := len(proof.NonMembershipLeafData) + len(proof.SiblingData)
+	for _, sideNode := range proof.SideNodes {
+		size += len(sideNode)
+	}
+	return size
+}
+
+// compactProofSize returns the size of proof, as recorded by
+// ObserveProofSize.
+func compactProofSize(proof SparseCompactMerkleProof) int {
+	size := len(proof.NonMembershipLeafData) + len(proof.SiblingData) + len(proof.BitMask)
+	for _, sideNode := range proof.SideNodes {
+		size += len(sideNode)
+	}
+	return size
+}
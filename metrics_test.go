@@ -0,0 +1,100 @@
+package smt
+
+import (
+	"crypto/sha256"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingMetrics counts the measurements of a tree.
+type recordingMetrics struct {
+	mu                    sync.Mutex
+	operations            map[Operation]int
+	failures              map[Operation]int
+	proofSizes            []int
+	storeReads, cacheHits int
+	writes, deletes       int
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{operations: make(map[Operation]int), failures: make(map[Operation]int)}
+}
+
+func (m *recordingMetrics) ObserveOperation(op Operation, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.operations[op]++
+	if err != nil {
+		m.failures[op]++
+	}
+}
+
+func (m *recordingMetrics) ObserveProofSize(size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.proofSizes = append(m.proofSizes, size)
+}
+
+func (m *recordingMetrics) AddNodeReads(fromStore, fromCache int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.storeReads += fromStore
+	m.cacheHits += fromCache
+}
+
+func (m *recordingMetrics) AddNodeWrites(written, deleted int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.writes += written
+	m.deletes += deleted
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := newRecordingMetrics()
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithMetrics(metrics))
+	smt.Update([]byte("foo"), []byte("bar"))
+	smt.Update([]byte("baz"), []byte("qux"))
+	smt.Delete([]byte("baz"))
+	smt.Get([]byte("foo"))
+	proof, _ := smt.Prove([]byte("foo"))
+	compact, _ := smt.ProveCompact([]byte("foo"))
+	smt.Seal()
+	smt.Delete([]byte("foo"))
+
+	for op, want := range map[Operation]int{OperationUpdate: 2, OperationDelete: 2, OperationGet: 1, OperationProve: 2} {
+		if metrics.operations[op] != want {
+			t.Errorf("got %d %v operations, want %d", metrics.operations[op], op, want)
+		}
+	}
+	if metrics.failures[OperationDelete] != 1 {
+		t.Errorf("got %d failed deletes, want 1", metrics.failures[OperationDelete])
+	}
+	if len(metrics.proofSizes) != 2 || metrics.proofSizes[0] != proofSize(proof) || metrics.proofSizes[1] != compactProofSize(compact) {
+		t.Errorf("got proof sizes %v", metrics.proofSizes)
+	}
+	if metrics.storeReads == 0 || metrics.cacheHits != 0 || metrics.writes == 0 || metrics.deletes == 0 {
+		t.Errorf("got %d store reads, %d cache hits, %d writes and %d deletes", metrics.storeReads, metrics.cacheHits, metrics.writes, metrics.deletes)
+	}
+
+	smt.EnableNodeHashCache(16)
+	smt.Prove([]byte("foo"))
+	reads := metrics.storeReads
+	smt.Prove([]byte("foo"))
+	if metrics.storeReads != reads || metrics.cacheHits == 0 {
+		t.Errorf("got %d store reads and %d cache hits reading cached nodes", metrics.storeReads-reads, metrics.cacheHits)
+	}
+}
+
+func TestMetricsLazyCommit(t *testing.T) {
+	metrics := newRecordingMetrics()
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithMetrics(metrics), WithLazyCommit())
+	smt.Update([]byte("foo"), []byte("bar"))
+	if metrics.writes != 0 {
+		t.Errorf("staged update wrote %d nodes", metrics.writes)
+	}
+	smt.Commit()
+	if metrics.operations[OperationUpdate] != 1 || metrics.operations[OperationCommit] != 1 || metrics.writes == 0 {
+		t.Errorf("got operations %v and %d writes", metrics.operations, metrics.writes)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !(aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris)
+
+package mmapstore
+
+import "os"
+
+// mapFile returns no mapping where memory mapping is not supported, so that
+// values are read from the file instead.
+func mapFile(file *os.File, size int) ([]byte, error) {
+	return nil, nil
+}
+
+func unmapFile(mapping []byte) error {
+	return nil
+}
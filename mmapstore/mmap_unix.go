@@ -0,0 +1,18 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package mmapstore
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps size bytes of file for reading, which may extend past its end
+// as long as only the bytes in the file are read.
+func mapFile(file *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(file.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func unmapFile(mapping []byte) error {
+	return syscall.Munmap(mapping)
+}
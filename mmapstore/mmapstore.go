@@ -0,0 +1,471 @@
+// Package mmapstore provides a MapStore backed by an append-only data file
+// read through a memory mapping, for trees larger than memory that should be
+// served from the page cache and open without importing them.
+package mmapstore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/causevest/smt"
+)
+
+// ErrClosed is returned by the methods of a closed MmapStore.
+var ErrClosed = errors.New("mmapstore: store is closed")
+
+// errCorruptRecord ends the replay of the data file at a record that does
+// not match its checksum.
+var errCorruptRecord = errors.New("mmapstore: corrupt record")
+
+const (
+	dataFile  = "data"
+	indexFile = "index"
+
+	recordSet    = 1
+	recordDelete = 2
+
+	// minMapping is the smallest memory mapping of the data file.
+	minMapping = 1 << 20
+)
+
+var indexMagic = []byte("SMTI")
+
+const indexVersion = 1
+
+// Data file layout, one record per write, with lengths as uvarints:
+//
+//	big-endian CRC32 (IEEE) of the rest of the record
+//	one byte of record kind, set or delete
+//	key length, value length (0 for a delete)
+//	key, value
+//
+// Index file layout, written by Flush:
+//
+//	magic, one byte of format version
+//	length of the data file covered, number of entries
+//	per entry: key length, key, value offset, value length
+//	big-endian CRC32 (IEEE) of everything before it
+
+// MmapStore is a MapStore that appends its entries to a data file in a
+// directory, and reads values through a memory mapping of the file, so that
+// the operating system pages them in and out as needed. The offset of the
+// value of each key is kept in memory, and written to an index file by Flush
+// and Close, so that opening the store only reads the index and the records
+// written after it, rather than the values. Overwritten and deleted entries
+// are not reclaimed: to compact a store, export it to a new one. It is safe
+// for concurrent use.
+type MmapStore struct {
+	dir string
+
+	mu      sync.RWMutex
+	file    *os.File
+	size    int64
+	mapping []byte
+	index   map[string]entry
+	closed  bool
+}
+
+// entry locates a value in the data file.
+type entry struct {
+	offset int64
+	length int64
+}
+
+// NewMmapStore opens the MmapStore in dir, creating the directory and an empty
+// store if they do not exist. The records written since the last Flush are
+// read back from the data file, and a record left incomplete by a crash is
+// discarded.
+func NewMmapStore(dir string) (*MmapStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, dataFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &MmapStore{dir: dir, file: file}
+	if err := s.open(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// open loads the index and replays the data file after it.
+func (s *MmapStore) open() error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	fileSize := info.Size()
+	index, covered := readIndex(filepath.Join(s.dir, indexFile))
+	if covered > fileSize {
+		index, covered = nil, 0
+	}
+	if index == nil {
+		index = make(map[string]entry)
+	}
+	s.index = index
+	end, err := s.replay(covered, fileSize)
+	if err != nil {
+		return err
+	}
+	if end < fileSize {
+		if err := s.file.Truncate(end); err != nil {
+			return err
+		}
+	}
+	s.size = end
+	return s.remap()
+}
+
+// replay applies the records of the data file from start to the index, up to
+// size, returning the end of the last complete record.
+func (s *MmapStore) replay(start, size int64) (int64, error) {
+	r := bufio.NewReader(io.NewSectionReader(s.file, start, size-start))
+	offset := start
+	for offset < size {
+		kind, key, value, n, err := readRecord(r)
+		if err != nil {
+			// A torn or corrupt record ends the log.
+			return offset, nil
+		}
+		switch kind {
+		case recordSet:
+			s.index[string(key)] = entry{offset: offset + int64(n-len(value)), length: int64(len(value))}
+		case recordDelete:
+			delete(s.index, string(key))
+		}
+		offset += int64(n)
+	}
+	return offset, nil
+}
+
+// readRecord reads a record, returning its kind, key and value and its
+// length in the file.
+func readRecord(r *bufio.Reader) (byte, []byte, []byte, int, error) {
+	var sum [4]byte
+	if _, err := io.ReadFull(r, sum[:]); err != nil {
+		return 0, nil, nil, 0, err
+	}
+	kind, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, nil, 0, err
+	}
+	keyLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, nil, nil, 0, err
+	}
+	valueLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, nil, nil, 0, err
+	}
+	if keyLen > math.MaxInt32 || valueLen > math.MaxInt64-keyLen {
+		return 0, nil, nil, 0, errCorruptRecord
+	}
+	// Grow the buffer as the data arrives rather than trusting the lengths.
+	var body bytes.Buffer
+	if _, err := io.CopyN(&body, r, int64(keyLen+valueLen)); err != nil {
+		return 0, nil, nil, 0, err
+	}
+	header := appendUvarint(appendUvarint([]byte{kind}, keyLen), valueLen)
+	crc := crc32.Update(crc32.ChecksumIEEE(header), crc32.IEEETable, body.Bytes())
+	if binary.BigEndian.Uint32(sum[:]) != crc || (kind != recordSet && kind != recordDelete) {
+		return 0, nil, nil, 0, errCorruptRecord
+	}
+	data := body.Bytes()
+	return kind, data[:keyLen], data[keyLen:], len(sum) + len(header) + len(data), nil
+}
+
+// encodeRecord encodes a record of the given kind.
+func encodeRecord(kind byte, key []byte, value []byte) []byte {
+	record := make([]byte, 4, 4+1+2*binary.MaxVarintLen64+len(key)+len(value))
+	record = append(record, kind)
+	record = appendUvarint(record, uint64(len(key)))
+	record = appendUvarint(record, uint64(len(value)))
+	record = append(record, key...)
+	record = append(record, value...)
+	binary.BigEndian.PutUint32(record, crc32.ChecksumIEEE(record[4:]))
+	return record
+}
+
+func appendUvarint(buf []byte, x uint64) []byte {
+	var varint [binary.MaxVarintLen64]byte
+	return append(buf, varint[:binary.PutUvarint(varint[:], x)]...)
+}
+
+// remap maps the data file again if it has grown past the mapping.
+func (s *MmapStore) remap() error {
+	if s.size <= int64(len(s.mapping)) && s.mapping != nil {
+		return nil
+	}
+	size := int64(minMapping)
+	for size < s.size {
+		size *= 2
+	}
+	if s.mapping != nil {
+		if err := unmapFile(s.mapping); err != nil {
+			return err
+		}
+		s.mapping = nil
+	}
+	mapping, err := mapFile(s.file, int(size))
+	if err != nil {
+		return err
+	}
+	s.mapping = mapping
+	return nil
+}
+
+// Get gets the value for a key.
+func (s *MmapStore) Get(key []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	e, ok := s.index[string(key)]
+	if !ok {
+		return nil, &smt.InvalidKeyError{Key: key}
+	}
+	return s.read(e)
+}
+
+// read copies a value out of the data file, as the mapping may be replaced
+// once the lock is released.
+func (s *MmapStore) read(e entry) ([]byte, error) {
+	value := make([]byte, e.length)
+	if s.mapping == nil {
+		if _, err := s.file.ReadAt(value, e.offset); err != nil {
+			return nil, err
+		}
+		return value, nil
+	}
+	copy(value, s.mapping[e.offset:e.offset+e.length])
+	return value, nil
+}
+
+// Set updates the value for a key.
+func (s *MmapStore) Set(key []byte, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	record := encodeRecord(recordSet, key, value)
+	if err := s.append(record); err != nil {
+		return err
+	}
+	s.index[string(key)] = entry{offset: s.size - int64(len(value)), length: int64(len(value))}
+	return s.remap()
+}
+
+// Delete deletes a key, returning an InvalidKeyError if it is missing, as
+// SimpleMap does.
+func (s *MmapStore) Delete(key []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if _, ok := s.index[string(key)]; !ok {
+		return &smt.InvalidKeyError{Key: key}
+	}
+	if err := s.append(encodeRecord(recordDelete, key, nil)); err != nil {
+		return err
+	}
+	delete(s.index, string(key))
+	return s.remap()
+}
+
+// append writes a record at the end of the data file.
+func (s *MmapStore) append(record []byte) error {
+	if _, err := s.file.WriteAt(record, s.size); err != nil {
+		// Drop any part of the record written.
+		s.file.Truncate(s.size)
+		return err
+	}
+	s.size += int64(len(record))
+	return nil
+}
+
+// Len returns the number of keys in the store.
+func (s *MmapStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.index)
+}
+
+// Export exports the entries of the store as a map snapshot, like
+// SimpleMap.Export.
+func (s *MmapStore) Export() ([]byte, error) {
+	sm := smt.NewSimpleMap()
+	err := s.each(func(key []byte, value []byte) error {
+		return sm.Set(key, value)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sm.Export()
+}
+
+// ExportTo writes the entries of the store to w as a record stream, as read
+// by smt.ImportStoreFrom, one at a time. Writes are blocked until it returns.
+func (s *MmapStore) ExportTo(w io.Writer) error {
+	rw := smt.NewRecordWriter(w)
+	if err := s.each(rw.Write); err != nil {
+		return err
+	}
+	return rw.Close()
+}
+
+// each calls f for each entry of the store, holding the read lock.
+func (s *MmapStore) each(f func(key []byte, value []byte) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return ErrClosed
+	}
+	for key, e := range s.index {
+		value, err := s.read(e)
+		if err != nil {
+			return err
+		}
+		if err := f([]byte(key), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush syncs the data file to disk, then writes the index file, so that the
+// store reopens without replaying the records written so far.
+func (s *MmapStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	return s.flush()
+}
+
+func (s *MmapStore) flush() error {
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	return writeIndex(filepath.Join(s.dir, indexFile), s.index, s.size)
+}
+
+// Close flushes the store, unmaps the data file and closes it. The store must
+// not be used afterwards.
+func (s *MmapStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	s.closed = true
+	err := s.flush()
+	if s.mapping != nil {
+		if unmapErr := unmapFile(s.mapping); err == nil {
+			err = unmapErr
+		}
+		s.mapping = nil
+	}
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// writeIndex writes the index to a temporary file renamed over path, so that
+// a crash leaves the previous index in place.
+func writeIndex(path string, index map[string]entry, covered int64) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	crc := crc32.NewIEEE()
+	w := bufio.NewWriter(io.MultiWriter(tmp, crc))
+	var buf []byte
+	buf = append(buf, indexMagic...)
+	buf = append(buf, indexVersion)
+	buf = appendUvarint(buf, uint64(covered))
+	buf = appendUvarint(buf, uint64(len(index)))
+	w.Write(buf)
+	for key, e := range index {
+		buf = appendUvarint(buf[:0], uint64(len(key)))
+		buf = append(buf, key...)
+		buf = appendUvarint(buf, uint64(e.offset))
+		buf = appendUvarint(buf, uint64(e.length))
+		w.Write(buf)
+	}
+	err = w.Flush()
+	if err == nil {
+		var sum [4]byte
+		binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+		_, err = tmp.Write(sum[:])
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing index: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readIndex reads the index file at path, returning the entries and the
+// length of the data file they cover, or nil if there is no valid index.
+func readIndex(path string) (map[string]entry, int64) {
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) < len(indexMagic)+1+4 {
+		return nil, 0
+	}
+	body, trailer := data[:len(data)-4], data[len(data)-4:]
+	if binary.BigEndian.Uint32(trailer) != crc32.ChecksumIEEE(body) ||
+		!bytes.Equal(body[:len(indexMagic)], indexMagic) || body[len(indexMagic)] != indexVersion {
+		return nil, 0
+	}
+	r := bytes.NewReader(body[len(indexMagic)+1:])
+	covered, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, 0
+	}
+	count, err := binary.ReadUvarint(r)
+	if err != nil || count > uint64(len(body)) {
+		return nil, 0
+	}
+	index := make(map[string]entry, count)
+	for i := uint64(0); i < count; i++ {
+		keyLen, err := binary.ReadUvarint(r)
+		if err != nil || keyLen > uint64(r.Len()) {
+			return nil, 0
+		}
+		key := make([]byte, keyLen)
+		r.Read(key)
+		offset, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, 0
+		}
+		length, err := binary.ReadUvarint(r)
+		if err != nil || offset+length > covered {
+			return nil, 0
+		}
+		index[string(key)] = entry{offset: int64(offset), length: int64(length)}
+	}
+	return index, int64(covered)
+}
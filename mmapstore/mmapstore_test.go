@@ -0,0 +1,162 @@
+package mmapstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/causevest/smt"
+)
+
+func TestMmapStore(t *testing.T) {
+	s, err := NewMmapStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("returned error when opening store: %v", err)
+	}
+	defer s.Close()
+
+	if err := s.Set([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("returned error when setting: %v", err)
+	}
+	s.Set([]byte("key"), []byte("newValue"))
+	if value, err := s.Get([]byte("key")); err != nil || !bytes.Equal(value, []byte("newValue")) {
+		t.Errorf("did not get value, got %s, %v", value, err)
+	}
+	if err := s.Delete([]byte("key")); err != nil {
+		t.Errorf("returned error when deleting: %v", err)
+	}
+	var invalidKeyError *smt.InvalidKeyError
+	if _, err := s.Get([]byte("key")); !errors.As(err, &invalidKeyError) {
+		t.Errorf("did not return InvalidKeyError for deleted key, got %v", err)
+	}
+	if err := s.Delete([]byte("key")); !errors.As(err, &invalidKeyError) {
+		t.Errorf("did not return InvalidKeyError when deleting missing key, got %v", err)
+	}
+
+	// Values past the first mapping are read after it grows.
+	large := bytes.Repeat([]byte("x"), minMapping)
+	s.Set([]byte("large"), large)
+	s.Set([]byte("after"), []byte("value"))
+	if value, err := s.Get([]byte("large")); err != nil || !bytes.Equal(value, large) {
+		t.Errorf("did not get large value, got %d bytes, %v", len(value), err)
+	}
+	if value, err := s.Get([]byte("after")); err != nil || !bytes.Equal(value, []byte("value")) {
+		t.Errorf("did not get value after the first mapping, got %s, %v", value, err)
+	}
+}
+
+func TestMmapStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewMmapStore(dir)
+	if err != nil {
+		t.Fatalf("returned error when opening store: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		s.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)))
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatalf("returned error when flushing: %v", err)
+	}
+	// Written after the index, and so replayed from the data file.
+	s.Delete([]byte("key3"))
+	s.Set([]byte("key4"), []byte("newValue4"))
+
+	check := func(s *MmapStore) {
+		t.Helper()
+		if s.Len() != 99 {
+			t.Errorf("reopened store has %d keys, want 99", s.Len())
+		}
+		if _, err := s.Get([]byte("key3")); err == nil {
+			t.Error("deleted key is in the reopened store")
+		}
+		if value, _ := s.Get([]byte("key4")); !bytes.Equal(value, []byte("newValue4")) {
+			t.Errorf("got %q for key4 from the reopened store", value)
+		}
+		if value, _ := s.Get([]byte("key50")); !bytes.Equal(value, []byte("value50")) {
+			t.Errorf("got %q for key50 from the reopened store", value)
+		}
+	}
+
+	// Reopened without closing, as after a crash, with a torn record at the
+	// end of the data file.
+	f, err := os.OpenFile(filepath.Join(dir, dataFile), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("returned error when opening data file: %v", err)
+	}
+	f.Write(encodeRecord(recordSet, []byte("torn"), []byte("value"))[:8])
+	f.Close()
+	crashed, err := NewMmapStore(dir)
+	if err != nil {
+		t.Fatalf("returned error when reopening store: %v", err)
+	}
+	check(crashed)
+	if _, err := crashed.Get([]byte("torn")); err == nil {
+		t.Error("torn record was read")
+	}
+	crashed.Close()
+	s.Close()
+
+	reopened, err := NewMmapStore(dir)
+	if err != nil {
+		t.Fatalf("returned error when reopening store: %v", err)
+	}
+	defer reopened.Close()
+	check(reopened)
+	if err := reopened.Set([]byte("key100"), []byte("value100")); err != nil {
+		t.Errorf("returned error when setting in the reopened store: %v", err)
+	}
+}
+
+func TestMmapStoreTree(t *testing.T) {
+	dir := t.TempDir()
+	nodes, err := NewMmapStore(filepath.Join(dir, "nodes"))
+	if err != nil {
+		t.Fatalf("returned error when opening store: %v", err)
+	}
+	values, _ := NewMmapStore(filepath.Join(dir, "values"))
+	tree := smt.NewSparseMerkleTree(nodes, values, sha256.New())
+	reference := smt.NewSparseMerkleTree(smt.NewSimpleMap(), smt.NewSimpleMap(), sha256.New())
+	for i := 0; i < 100; i++ {
+		key, value := []byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i))
+		tree.Update(key, value)
+		reference.Update(key, value)
+	}
+	tree.Delete([]byte("testKey3"))
+	reference.Delete([]byte("testKey3"))
+	if !bytes.Equal(tree.Root(), reference.Root()) {
+		t.Error("tree on mmap stores has another root")
+	}
+	root := tree.Root()
+	if err := tree.Close(); err != nil {
+		t.Fatalf("returned error when closing tree: %v", err)
+	}
+
+	nodes, _ = NewMmapStore(filepath.Join(dir, "nodes"))
+	values, _ = NewMmapStore(filepath.Join(dir, "values"))
+	defer nodes.Close()
+	defer values.Close()
+	reopened := smt.ImportSparseMerkleTree(nodes, values, sha256.New(), root)
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		want, _ := reference.Get(key)
+		if value, err := reopened.Get(key); err != nil || !bytes.Equal(value, want) {
+			t.Errorf("got %q, %v for %s from the reopened tree", value, err, key)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := nodes.ExportTo(&buf); err != nil {
+		t.Fatalf("returned error when exporting: %v", err)
+	}
+	imported := smt.NewSimpleMap()
+	if _, err := smt.ImportStoreFrom(&buf, imported); err != nil {
+		t.Fatalf("returned error when importing: %v", err)
+	}
+	if value, _ := imported.Get(root); value == nil {
+		t.Error("exported store is missing the root node")
+	}
+}
@@ -0,0 +1,423 @@
+package smt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"sync"
+)
+
+// ErrIndexOutOfRange is returned when accessing or proving leaves of a
+// MerkleMountainRange past its size.
+var ErrIndexOutOfRange = errors.New("index is out of range")
+
+// MerkleMountainRange is an append-only accumulator over a sequence of values,
+// for workloads whose keys are sequence numbers, where a Sparse Merkle tree
+// would hash a full-depth path for every key. The leaves form a list of
+// perfect binary trees, the mountains, one for each bit set in the number of
+// leaves, largest first; appending a leaf merges the mountains of equal
+// height, so it hashes as many nodes as trailing ones in the index. The root
+// bags the peaks of the mountains from right to left.
+//
+// Leaves and nodes are hashed as in a SparseMerkleTree, the leaf of the value
+// at index i being 0x00||i||H(value), with i as 8 big-endian bytes, and a node
+// 0x01||left||right. Nodes are stored by height and index in nodes, values by
+// index in values. A MerkleMountainRange is safe for concurrent use.
+type MerkleMountainRange struct {
+	th     treeHasher
+	nodes  MapStore
+	values MapStore
+	mu     sync.RWMutex
+	size   uint64
+}
+
+// mmrNode is a node of a MerkleMountainRange, the root of the perfect binary
+// tree over the leaves from index<<height up to (index+1)<<height.
+type mmrNode struct {
+	height uint
+	index  uint64
+}
+
+func (n mmrNode) start() uint64 { return n.index << n.height }
+func (n mmrNode) end() uint64   { return (n.index + 1) << n.height }
+
+func (n mmrNode) children() (mmrNode, mmrNode) {
+	return mmrNode{n.height - 1, 2 * n.index}, mmrNode{n.height - 1, 2*n.index + 1}
+}
+
+// key returns the key of the node in the node store.
+func (n mmrNode) key() []byte {
+	key := make([]byte, 9)
+	key[0] = byte(n.height)
+	binary.BigEndian.PutUint64(key[1:], n.index)
+	return key
+}
+
+// mmrPeaks returns the peaks of the mountains over size leaves, from left to
+// right.
+func mmrPeaks(size uint64) []mmrNode {
+	var peaks []mmrNode
+	var offset uint64
+	for height := 63; height >= 0; height-- {
+		if size&(1<<uint(height)) != 0 {
+			peaks = append(peaks, mmrNode{uint(height), offset >> uint(height)})
+			offset += 1 << uint(height)
+		}
+	}
+	return peaks
+}
+
+// NewMerkleMountainRange creates a new empty MerkleMountainRange on empty
+// MapStores.
+func NewMerkleMountainRange(nodes, values MapStore, hasher hash.Hash) *MerkleMountainRange {
+	return ImportMerkleMountainRange(nodes, values, hasher, 0)
+}
+
+// ImportMerkleMountainRange imports a MerkleMountainRange of size leaves from
+// the MapStores it was stored in.
+func ImportMerkleMountainRange(nodes, values MapStore, hasher hash.Hash, size uint64) *MerkleMountainRange {
+	if nodes == nil || values == nil {
+		panic("smt: nil store")
+	}
+	if hasher == nil {
+		panic("smt: nil hasher")
+	}
+	return &MerkleMountainRange{th: *newTreeHasher(hasher), nodes: nodes, values: values, size: size}
+}
+
+// Size returns the number of values appended.
+func (mmr *MerkleMountainRange) Size() uint64 {
+	mmr.mu.RLock()
+	defer mmr.mu.RUnlock()
+	return mmr.size
+}
+
+// Root returns the root of the MerkleMountainRange, which for no values is the
+// placeholder of an empty Sparse Merkle tree.
+func (mmr *MerkleMountainRange) Root() ([]byte, error) {
+	mmr.mu.RLock()
+	defer mmr.mu.RUnlock()
+	peaks, err := mmr.getNodes(mmrPeaks(mmr.size))
+	if err != nil {
+		return nil, err
+	}
+	return mmr.th.bagPeaks(peaks), nil
+}
+
+// Append appends value, returning its index and the new root.
+func (mmr *MerkleMountainRange) Append(value []byte) (uint64, []byte, error) {
+	mmr.mu.Lock()
+	defer mmr.mu.Unlock()
+	index := mmr.size
+	if err := mmr.values.Set(mmrIndex(index), append([]byte{}, value...)); err != nil {
+		return 0, nil, err
+	}
+	node := mmrNode{0, index}
+	hash := mmr.th.digestMMRLeaf(index, value)
+	if err := mmr.nodes.Set(node.key(), hash); err != nil {
+		return 0, nil, err
+	}
+	// A right child completes its parent.
+	for node.index%2 == 1 {
+		sibling, err := mmr.nodes.Get(mmrNode{node.height, node.index - 1}.key())
+		if err != nil {
+			return 0, nil, err
+		}
+		node = mmrNode{node.height + 1, node.index / 2}
+		hash, _ = mmr.th.digestNode(sibling, hash)
+		if err := mmr.nodes.Set(node.key(), hash); err != nil {
+			return 0, nil, err
+		}
+	}
+	mmr.size++
+
+	peaks, err := mmr.getNodes(mmrPeaks(mmr.size))
+	if err != nil {
+		return 0, nil, err
+	}
+	return index, mmr.th.bagPeaks(peaks), nil
+}
+
+// Get gets the value at index.
+func (mmr *MerkleMountainRange) Get(index uint64) ([]byte, error) {
+	mmr.mu.RLock()
+	defer mmr.mu.RUnlock()
+	if index >= mmr.size {
+		return nil, ErrIndexOutOfRange
+	}
+	return mmr.values.Get(mmrIndex(index))
+}
+
+// MountainRangeProof is a proof of the values of a run of consecutive leaves of
+// a MerkleMountainRange.
+type MountainRangeProof struct {
+	// Size is the number of leaves of the MerkleMountainRange proved against,
+	// and Start the index of the first proved leaf.
+	Size, Start uint64
+	// Hashes are the hashes of the nodes not covering any of the proved
+	// leaves that are children of nodes that do, and of the peaks that do
+	// not, from left to right.
+	Hashes [][]byte
+}
+
+// Prove generates a proof of the value at index against the current root.
+func (mmr *MerkleMountainRange) Prove(index uint64) (MountainRangeProof, error) {
+	return mmr.ProveRange(index, index+1)
+}
+
+// ProveRange generates a proof of the values from index start up to end
+// against the current root, which costs fewer hashes than proving each.
+func (mmr *MerkleMountainRange) ProveRange(start, end uint64) (MountainRangeProof, error) {
+	mmr.mu.RLock()
+	defer mmr.mu.RUnlock()
+	if start >= end || end > mmr.size {
+		return MountainRangeProof{}, ErrIndexOutOfRange
+	}
+	proof := MountainRangeProof{Size: mmr.size, Start: start}
+	var collect func(node mmrNode) error
+	collect = func(node mmrNode) error {
+		if node.end() <= start || node.start() >= end {
+			hash, err := mmr.nodes.Get(node.key())
+			proof.Hashes = append(proof.Hashes, hash)
+			return err
+		}
+		if node.height == 0 {
+			return nil
+		}
+		left, right := node.children()
+		if err := collect(left); err != nil {
+			return err
+		}
+		return collect(right)
+	}
+	for _, peak := range mmrPeaks(mmr.size) {
+		if err := collect(peak); err != nil {
+			return MountainRangeProof{}, err
+		}
+	}
+	return proof, nil
+}
+
+// VerifyMountainRangeProof verifies a proof that values are the values of the
+// leaves of a MerkleMountainRange with the given root from the proof's Start.
+func VerifyMountainRangeProof(proof MountainRangeProof, root []byte, values [][]byte, hasher hash.Hash) bool {
+	th := newTreeHasher(hasher)
+	start, end := proof.Start, proof.Start+uint64(len(values))
+	if len(values) == 0 || end < start || end > proof.Size {
+		return false
+	}
+	hashes := &mmrProofHashes{th: th, hashes: proof.Hashes}
+	var compute func(node mmrNode) []byte
+	compute = func(node mmrNode) []byte {
+		if node.end() <= start || node.start() >= end {
+			return hashes.next()
+		}
+		if node.height == 0 {
+			return th.digestMMRLeaf(node.index, values[node.index-start])
+		}
+		left, right := node.children()
+		leftHash, rightHash := compute(left), compute(right)
+		hash, _ := th.digestNode(leftHash, rightHash)
+		return hash
+	}
+	var peaks [][]byte
+	for _, peak := range mmrPeaks(proof.Size) {
+		peaks = append(peaks, compute(peak))
+	}
+	return hashes.done() && bytes.Equal(th.bagPeaks(peaks), root)
+}
+
+// MountainRangeAppendProof is a proof that a MerkleMountainRange was only
+// appended to between two sizes, i.e. that the leaves of the older root are
+// the first leaves of the newer one.
+type MountainRangeAppendProof struct {
+	OldSize, NewSize uint64
+	// OldPeaks are the hashes of the peaks at the old size, and Hashes those
+	// of the nodes at the new size outside the old leaves that are children
+	// of nodes covering some of them, or of new peaks, from left to right.
+	OldPeaks, Hashes [][]byte
+}
+
+// ProveAppend generates a proof that the root at oldSize leaves is a prefix
+// of the current root.
+func (mmr *MerkleMountainRange) ProveAppend(oldSize uint64) (MountainRangeAppendProof, error) {
+	mmr.mu.RLock()
+	defer mmr.mu.RUnlock()
+	if oldSize > mmr.size {
+		return MountainRangeAppendProof{}, ErrIndexOutOfRange
+	}
+	proof := MountainRangeAppendProof{OldSize: oldSize, NewSize: mmr.size}
+	oldPeaks := mmrPeaks(oldSize)
+	var err error
+	if proof.OldPeaks, err = mmr.getNodes(oldPeaks); err != nil {
+		return MountainRangeAppendProof{}, err
+	}
+	isOldPeak := mmrNodeSet(oldPeaks)
+	var collect func(node mmrNode) error
+	collect = func(node mmrNode) error {
+		if isOldPeak[node] {
+			return nil
+		}
+		if node.start() >= oldSize {
+			hash, err := mmr.nodes.Get(node.key())
+			proof.Hashes = append(proof.Hashes, hash)
+			return err
+		}
+		left, right := node.children()
+		if err := collect(left); err != nil {
+			return err
+		}
+		return collect(right)
+	}
+	for _, peak := range mmrPeaks(mmr.size) {
+		if err := collect(peak); err != nil {
+			return MountainRangeAppendProof{}, err
+		}
+	}
+	return proof, nil
+}
+
+// VerifyMountainRangeAppendProof verifies a proof that a MerkleMountainRange
+// with newRoot was obtained by appending to one with oldRoot.
+func VerifyMountainRangeAppendProof(proof MountainRangeAppendProof, oldRoot []byte, newRoot []byte, hasher hash.Hash) bool {
+	th := newTreeHasher(hasher)
+	oldPeaks := mmrPeaks(proof.OldSize)
+	if proof.OldSize > proof.NewSize || len(proof.OldPeaks) != len(oldPeaks) {
+		return false
+	}
+	oldHashes := make(map[mmrNode][]byte, len(oldPeaks))
+	for i, peak := range oldPeaks {
+		if len(proof.OldPeaks[i]) != th.hashSize() {
+			return false
+		}
+		oldHashes[peak] = proof.OldPeaks[i]
+	}
+	if !bytes.Equal(th.bagPeaks(proof.OldPeaks), oldRoot) {
+		return false
+	}
+
+	hashes := &mmrProofHashes{th: th, hashes: proof.Hashes}
+	var compute func(node mmrNode) []byte
+	compute = func(node mmrNode) []byte {
+		if hash, ok := oldHashes[node]; ok {
+			return hash
+		}
+		if node.start() >= proof.OldSize {
+			return hashes.next()
+		}
+		if node.height == 0 {
+			// Every old leaf is under an old peak, so this is unreachable
+			// for a consistent proof.
+			hashes.err = true
+			return nil
+		}
+		left, right := node.children()
+		leftHash, rightHash := compute(left), compute(right)
+		hash, _ := th.digestNode(leftHash, rightHash)
+		return hash
+	}
+	var peaks [][]byte
+	for _, peak := range mmrPeaks(proof.NewSize) {
+		peaks = append(peaks, compute(peak))
+	}
+	return hashes.done() && bytes.Equal(th.bagPeaks(peaks), newRoot)
+}
+
+// mmrProofHashes hands out the hashes of a proof in order, flagging a proof
+// with too few hashes or malformed ones.
+type mmrProofHashes struct {
+	th     *treeHasher
+	hashes [][]byte
+	err    bool
+}
+
+func (h *mmrProofHashes) next() []byte {
+	if len(h.hashes) == 0 || len(h.hashes[0]) != h.th.hashSize() {
+		h.err = true
+		return h.th.placeholder()
+	}
+	hash := h.hashes[0]
+	h.hashes = h.hashes[1:]
+	return hash
+}
+
+// done returns true if every hash of the proof was used and none was missing.
+func (h *mmrProofHashes) done() bool {
+	return !h.err && len(h.hashes) == 0
+}
+
+// MountainRangeWrap holds the size and stores of a MerkleMountainRange, to be
+// saved with GobEncode like a TrieWrap.
+type MountainRangeWrap struct {
+	Size        uint64
+	NodesBytes  []byte
+	ValuesBytes []byte
+}
+
+// ExportMountainRange exports the size and stores of a MerkleMountainRange.
+func ExportMountainRange(mmr *MerkleMountainRange) (*MountainRangeWrap, error) {
+	mmr.mu.RLock()
+	defer mmr.mu.RUnlock()
+	nodesBytes, valuesBytes, err := exportStores(mmr.nodes, mmr.values)
+	if err != nil {
+		return nil, err
+	}
+	return &MountainRangeWrap{Size: mmr.size, NodesBytes: nodesBytes, ValuesBytes: valuesBytes}, nil
+}
+
+// ImportMountainRange imports a MerkleMountainRange exported by
+// ExportMountainRange into in-memory stores.
+func ImportMountainRange(wrap *MountainRangeWrap, hasher hash.Hash) (*MerkleMountainRange, error) {
+	nodes, values, err := ImportMerkleMap(wrap.NodesBytes, wrap.ValuesBytes)
+	if err != nil {
+		return nil, err
+	}
+	return ImportMerkleMountainRange(nodes, values, hasher, wrap.Size), nil
+}
+
+// getNodes returns the stored hashes of nodes.
+func (mmr *MerkleMountainRange) getNodes(nodes []mmrNode) ([][]byte, error) {
+	hashes := make([][]byte, len(nodes))
+	for i, node := range nodes {
+		hash, err := mmr.nodes.Get(node.key())
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = hash
+	}
+	return hashes, nil
+}
+
+// digestMMRLeaf returns the hash of the leaf of value at index.
+func (th *treeHasher) digestMMRLeaf(index uint64, value []byte) []byte {
+	hash, _ := th.digestLeaf(mmrIndex(index), th.digest(value))
+	return hash
+}
+
+// bagPeaks returns the root of a MerkleMountainRange with the given peaks,
+// folding them from right to left.
+func (th *treeHasher) bagPeaks(peaks [][]byte) []byte {
+	if len(peaks) == 0 {
+		return th.placeholder()
+	}
+	root := peaks[len(peaks)-1]
+	for i := len(peaks) - 2; i >= 0; i-- {
+		root, _ = th.digestNode(peaks[i], root)
+	}
+	return root
+}
+
+func mmrIndex(index uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, index)
+	return b
+}
+
+func mmrNodeSet(nodes []mmrNode) map[mmrNode]bool {
+	set := make(map[mmrNode]bool, len(nodes))
+	for _, node := range nodes {
+		set[node] = true
+	}
+	return set
+}
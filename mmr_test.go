@@ -0,0 +1,122 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestMerkleMountainRange(t *testing.T) {
+	mmr := NewMerkleMountainRange(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	root, _ := mmr.Root()
+	roots := [][]byte{root}
+	var values [][]byte
+	for i := 0; i < 37; i++ {
+		value := []byte(fmt.Sprintf("testValue%d", i))
+		index, root, err := mmr.Append(value)
+		if err != nil {
+			t.Fatalf("returned error when appending: %v", err)
+		}
+		if index != uint64(i) {
+			t.Errorf("appended value %d at index %d", i, index)
+		}
+		if current, _ := mmr.Root(); !bytes.Equal(current, root) {
+			t.Error("Append did not return the new root")
+		}
+		roots = append(roots, root)
+		values = append(values, value)
+	}
+	root = roots[len(roots)-1]
+
+	for i := range values {
+		proof, err := mmr.Prove(uint64(i))
+		if err != nil {
+			t.Fatalf("returned error when proving index %d: %v", i, err)
+		}
+		if !VerifyMountainRangeProof(proof, root, values[i:i+1], sha256.New()) {
+			t.Errorf("proof of index %d failed to verify", i)
+		}
+		if VerifyMountainRangeProof(proof, root, [][]byte{[]byte("wrongValue")}, sha256.New()) {
+			t.Errorf("proof of index %d verified for wrong value", i)
+		}
+		if value, _ := mmr.Get(uint64(i)); !bytes.Equal(value, values[i]) {
+			t.Errorf("did not get value at index %d", i)
+		}
+	}
+
+	for _, r := range [][2]int{{0, 37}, {3, 9}, {16, 32}, {31, 37}} {
+		proof, err := mmr.ProveRange(uint64(r[0]), uint64(r[1]))
+		if err != nil {
+			t.Fatalf("returned error when proving range %v: %v", r, err)
+		}
+		if !VerifyMountainRangeProof(proof, root, values[r[0]:r[1]], sha256.New()) {
+			t.Errorf("proof of range %v failed to verify", r)
+		}
+		if VerifyMountainRangeProof(proof, root, values[r[0]:r[1]-1], sha256.New()) {
+			t.Errorf("proof of range %v verified for fewer values", r)
+		}
+		proof.Hashes = append(proof.Hashes, root)
+		if VerifyMountainRangeProof(proof, root, values[r[0]:r[1]], sha256.New()) {
+			t.Errorf("proof of range %v verified with an extra hash", r)
+		}
+	}
+	if _, err := mmr.Prove(37); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Errorf("did not return ErrIndexOutOfRange when proving past the end, got %v", err)
+	}
+
+	for oldSize := range roots {
+		proof, err := mmr.ProveAppend(uint64(oldSize))
+		if err != nil {
+			t.Fatalf("returned error when proving append from %d: %v", oldSize, err)
+		}
+		if !VerifyMountainRangeAppendProof(proof, roots[oldSize], root, sha256.New()) {
+			t.Errorf("append proof from size %d failed to verify", oldSize)
+		}
+		if oldSize > 0 && VerifyMountainRangeAppendProof(proof, roots[oldSize-1], root, sha256.New()) {
+			t.Errorf("append proof from size %d verified for another old root", oldSize)
+		}
+	}
+	// A root of other values is not a prefix.
+	forked := NewMerkleMountainRange(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 5; i++ {
+		forked.Append([]byte(fmt.Sprintf("forkedValue%d", i)))
+	}
+	forkedRoot, _ := forked.Root()
+	proof, _ := mmr.ProveAppend(5)
+	if VerifyMountainRangeAppendProof(proof, forkedRoot, root, sha256.New()) {
+		t.Error("append proof verified for a forked old root")
+	}
+}
+
+func TestMountainRangeExport(t *testing.T) {
+	mmr := NewMerkleMountainRange(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 10; i++ {
+		mmr.Append([]byte(fmt.Sprintf("testValue%d", i)))
+	}
+	wrap, err := ExportMountainRange(mmr)
+	if err != nil {
+		t.Fatalf("returned error when exporting: %v", err)
+	}
+	data, err := GobEncode(wrap)
+	if err != nil {
+		t.Fatalf("returned error when encoding: %v", err)
+	}
+	var decoded MountainRangeWrap
+	if err := GobDecode(data, &decoded); err != nil {
+		t.Fatalf("returned error when decoding: %v", err)
+	}
+	imported, err := ImportMountainRange(&decoded, sha256.New())
+	if err != nil {
+		t.Fatalf("returned error when importing: %v", err)
+	}
+	root, _ := mmr.Root()
+	if importedRoot, _ := imported.Root(); !bytes.Equal(importedRoot, root) || imported.Size() != 10 {
+		t.Error("imported mountain range differs")
+	}
+	_, root, _ = mmr.Append([]byte("next"))
+	if _, importedRoot, _ := imported.Append([]byte("next")); !bytes.Equal(importedRoot, root) {
+		t.Error("imported mountain range diverged after append")
+	}
+}
@@ -0,0 +1,292 @@
+package smt
+
+import (
+	"bytes"
+	"hash"
+	"sort"
+)
+
+// Kinds of the nodes of the pruned tree of a SparseMultiProof.
+const (
+	// multiBranch is a branch on the path of a proven key, followed by its
+	// left and right subtrees.
+	multiBranch byte = iota
+	// multiHash is a subtree off the paths of the proven keys, given by the
+	// next of the proof's Hashes.
+	multiHash
+	// multiEmpty is an empty subtree.
+	multiEmpty
+	// multiLeaf is the leaf of a proven key, whose value the verifier hashes.
+	multiLeaf
+	// multiOtherLeaf is the leaf of another key where proven keys would be,
+	// given by the next of the proof's Leaves, proving their absence.
+	multiOtherLeaf
+)
+
+// SparseMultiProof proves the values of an arbitrary set of keys against one
+// root. It is the tree pruned to the paths of the keys: side nodes shared by
+// several of the keys are given once, and the nodes on the path of another
+// proven key, which the verifier recomputes, not at all. A proof of scattered
+// keys so has no more hashes than their individual proofs together, and one
+// of clustered keys has much fewer; empty subtrees take a byte rather than a
+// hash.
+type SparseMultiProof struct {
+	// Structure is the kind of each node of the pruned tree, in depth-first,
+	// left-to-right order.
+	Structure []byte
+	// Hashes are the hashes of the subtrees off the paths of the keys, in the
+	// same order.
+	Hashes [][]byte
+	// Leaves are the data of the leaves of other keys at the position of
+	// absent keys, in the same order.
+	Leaves [][]byte
+	// Depth is the depth of the tree the proof is from, as in
+	// SparseMerkleProof.
+	Depth int
+}
+
+// ProveMulti generates a proof of the values of keys against the current
+// root, for present and absent keys alike. A key given more than once is
+// proven once.
+func (smt *SparseMerkleTree) ProveMulti(keys [][]byte) (SparseMultiProof, error) {
+	defer smt.readLock()()
+	root := smt.Root()
+	paths := sortedPaths(&smt.th, keys)
+	proof := SparseMultiProof{Depth: smt.depth()}
+	if err := smt.proveMultiNode(root, 0, paths, &proof); err != nil {
+		return SparseMultiProof{}, err
+	}
+	return proof, nil
+}
+
+func (smt *SparseMerkleTree) proveMultiNode(hash []byte, depth int, paths [][]byte, proof *SparseMultiProof) error {
+	if bytes.Equal(hash, smt.th.placeholder()) {
+		proof.Structure = append(proof.Structure, multiEmpty)
+		return nil
+	}
+	if len(paths) == 0 {
+		proof.Structure = append(proof.Structure, multiHash)
+		proof.Hashes = append(proof.Hashes, hash)
+		return nil
+	}
+	data, err := smt.getNode(hash)
+	if err != nil {
+		return err
+	}
+	if smt.th.isLeaf(data) {
+		leafPath, _ := smt.th.parseLeaf(data)
+		for _, path := range paths {
+			if bytes.Equal(path, leafPath) {
+				proof.Structure = append(proof.Structure, multiLeaf)
+				return nil
+			}
+		}
+		proof.Structure = append(proof.Structure, multiOtherLeaf)
+		proof.Leaves = append(proof.Leaves, data)
+		return nil
+	}
+
+	proof.Structure = append(proof.Structure, multiBranch)
+	leftNode, rightNode := smt.th.parseNode(data)
+	split := splitPaths(paths, depth)
+	if err := smt.proveMultiNode(leftNode, depth+1, paths[:split], proof); err != nil {
+		return err
+	}
+	return smt.proveMultiNode(rightNode, depth+1, paths[split:], proof)
+}
+
+// VerifyMultiProof verifies a proof that keys have values, with the default
+// value for absent keys, against root, reconstructing the root once from the
+// pruned tree of the proof. A key given more than once, as ProveMulti takes
+// it, must be given the same value each time, or the proof does not verify.
+func VerifyMultiProof(proof SparseMultiProof, root []byte, keys [][]byte, values [][]byte, hasher hash.Hash, options ...VerifyOption) bool {
+	config := newVerifyConfig(options)
+	return verifyMultiProof(proof, root, keys, values, config.treeHasher(hasher), config, nil)
+}
+
+// verifyMultiProof verifies a multiproof, adding the nodes it reconstructs to
+// writes if not nil.
+func verifyMultiProof(proof SparseMultiProof, root []byte, keys [][]byte, values [][]byte, th *treeHasher, config *verifyConfig, writes *nodeBatch) bool {
+	if len(keys) != len(values) || !th.checkDepth(proof.Depth) {
+		return false
+	}
+
+	// Pair each path with the hash of its value, nil for the default value.
+	type provenKey struct {
+		path, valueHash []byte
+	}
+	proven := make([]provenKey, len(keys))
+	for i, key := range keys {
+		if !config.checkValueSize(values[i]) {
+			return false
+		}
+		proven[i].path = th.path(key)
+		if !bytes.Equal(values[i], config.defaultValue) {
+			proven[i].valueHash = th.digestValue(values[i])
+		}
+	}
+	sort.Slice(proven, func(i, j int) bool { return bytes.Compare(proven[i].path, proven[j].path) < 0 })
+	// A key given more than once is proven once, as ProveMulti proves it, if
+	// it is given the same value each time.
+	unique := proven[:0]
+	for _, p := range proven {
+		if n := len(unique); n > 0 && bytes.Equal(p.path, unique[n-1].path) {
+			if !bytes.Equal(p.valueHash, unique[n-1].valueHash) {
+				return false
+			}
+			continue
+		}
+		unique = append(unique, p)
+	}
+	proven = unique
+	paths := make([][]byte, len(proven))
+	for i := range proven {
+		paths[i] = proven[i].path
+	}
+
+	v := multiVerifier{th: th, proof: proof}
+	var verify func(depth int, lo, hi int) []byte
+	verify = func(depth int, lo, hi int) []byte {
+		kind, ok := v.nextKind()
+		if !ok {
+			return nil
+		}
+		switch kind {
+		case multiBranch:
+			if lo == hi || depth >= th.maxSideNodes() {
+				return v.fail()
+			}
+			mid := lo + splitPaths(paths[lo:hi], depth)
+			left := verify(depth+1, lo, mid)
+			right := verify(depth+1, mid, hi)
+			if v.failed {
+				return nil
+			}
+			hash, data := th.digestNode(left, right)
+			if writes != nil {
+				writes.add(hash, data)
+			}
+			return hash
+		case multiHash:
+			if lo != hi {
+				return v.fail()
+			}
+			return v.nextHash()
+		case multiEmpty:
+			for _, p := range proven[lo:hi] {
+				if p.valueHash != nil {
+					return v.fail()
+				}
+			}
+			return th.placeholder()
+		case multiLeaf:
+			// Exactly one of the keys here is present, and the leaf is its
+			// own; the others share its prefix but are absent.
+			var leaf, data []byte
+			for _, p := range proven[lo:hi] {
+				if p.valueHash == nil {
+					continue
+				}
+				if leaf != nil {
+					return v.fail()
+				}
+				leaf, data = th.digestLeaf(p.path, p.valueHash)
+			}
+			if leaf == nil {
+				return v.fail()
+			}
+			if writes != nil {
+				writes.add(leaf, data)
+			}
+			return leaf
+		case multiOtherLeaf:
+			data := v.nextLeaf()
+			if data == nil || lo == hi {
+				return v.fail()
+			}
+			leafPath, valueHash := th.parseLeaf(data)
+			for _, p := range proven[lo:hi] {
+				if p.valueHash != nil || bytes.Equal(p.path, leafPath) || countCommonPrefix(p.path, leafPath) < depth {
+					return v.fail()
+				}
+			}
+			hash, _ := th.digestLeaf(leafPath, valueHash)
+			if writes != nil {
+				writes.add(hash, data)
+			}
+			return hash
+		}
+		return v.fail()
+	}
+	computed := verify(0, 0, len(paths))
+	return !v.failed && v.done() && bytes.Equal(computed, root)
+}
+
+// multiVerifier reads the parts of a SparseMultiProof in order, flagging a
+// proof that runs out of them or has malformed ones.
+type multiVerifier struct {
+	th     *treeHasher
+	proof  SparseMultiProof
+	failed bool
+}
+
+func (v *multiVerifier) fail() []byte {
+	v.failed = true
+	return nil
+}
+
+func (v *multiVerifier) nextKind() (byte, bool) {
+	if v.failed || len(v.proof.Structure) == 0 {
+		v.failed = true
+		return 0, false
+	}
+	kind := v.proof.Structure[0]
+	v.proof.Structure = v.proof.Structure[1:]
+	return kind, true
+}
+
+func (v *multiVerifier) nextHash() []byte {
+	if len(v.proof.Hashes) == 0 || len(v.proof.Hashes[0]) != v.th.hashSize() {
+		return v.fail()
+	}
+	hash := v.proof.Hashes[0]
+	v.proof.Hashes = v.proof.Hashes[1:]
+	return hash
+}
+
+func (v *multiVerifier) nextLeaf() []byte {
+	if len(v.proof.Leaves) == 0 || !v.th.validNode(v.proof.Leaves[0]) || !v.th.isLeaf(v.proof.Leaves[0]) {
+		return v.fail()
+	}
+	data := v.proof.Leaves[0]
+	v.proof.Leaves = v.proof.Leaves[1:]
+	return data
+}
+
+// done returns true if every part of the proof was read.
+func (v *multiVerifier) done() bool {
+	return len(v.proof.Structure) == 0 && len(v.proof.Hashes) == 0 && len(v.proof.Leaves) == 0
+}
+
+// sortedPaths returns the distinct paths of keys in ascending order.
+func sortedPaths(th *treeHasher, keys [][]byte) [][]byte {
+	paths := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		paths = append(paths, th.path(key))
+	}
+	sort.Slice(paths, func(i, j int) bool { return bytes.Compare(paths[i], paths[j]) < 0 })
+	distinct := paths[:0]
+	for i, path := range paths {
+		if i == 0 || !bytes.Equal(path, paths[i-1]) {
+			distinct = append(distinct, path)
+		}
+	}
+	return distinct
+}
+
+// splitPaths returns the number of sorted paths that go left at depth.
+func splitPaths(paths [][]byte, depth int) int {
+	return sort.Search(len(paths), func(i int) bool {
+		return getBitAtFromMSB(paths[i], depth) == right
+	})
+}
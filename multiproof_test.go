@@ -0,0 +1,183 @@
+package smt
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestMultiProof(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 200; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+
+	// Scattered keys, present and absent.
+	var keys, values [][]byte
+	for i := 0; i < 20; i++ {
+		n := rand.Intn(300)
+		key := []byte(fmt.Sprintf("testKey%d", n))
+		if containsKey(keys, key) {
+			continue
+		}
+		keys = append(keys, key)
+		value, _ := smt.Get(key)
+		values = append(values, value)
+	}
+	proof, err := smt.ProveMulti(keys)
+	if err != nil {
+		t.Fatalf("returned error when proving keys: %v", err)
+	}
+	if !VerifyMultiProof(proof, smt.Root(), keys, values, sha256.New()) {
+		t.Fatal("valid multiproof failed to verify")
+	}
+	if n := individualProofHashes(t, smt, keys); len(proof.Hashes) > n {
+		t.Errorf("multiproof has %d hashes, individual proofs %d", len(proof.Hashes), n)
+	}
+
+	badValues := append([][]byte(nil), values...)
+	badValues[0] = []byte("badValue")
+	if VerifyMultiProof(proof, smt.Root(), keys, badValues, sha256.New()) {
+		t.Error("multiproof verified with a wrong value")
+	}
+	// Leaving out an absent key may leave a valid proof of the others, as
+	// absent keys can share an empty subtree or another key's leaf, but not
+	// leaving out a present one.
+	for i, value := range values {
+		if len(value) == 0 {
+			continue
+		}
+		fewerKeys := append(append([][]byte(nil), keys[:i]...), keys[i+1:]...)
+		fewerValues := append(append([][]byte(nil), values[:i]...), values[i+1:]...)
+		if VerifyMultiProof(proof, smt.Root(), fewerKeys, fewerValues, sha256.New()) {
+			t.Error("multiproof verified for fewer keys")
+		}
+		break
+	}
+	// A repeated key is proven once, and verifies with the same value.
+	repeated := append(append([][]byte(nil), keys...), keys[0])
+	repeatedProof, err := smt.ProveMulti(repeated)
+	if err != nil {
+		t.Fatalf("returned error when proving a repeated key: %v", err)
+	}
+	if !VerifyMultiProof(repeatedProof, smt.Root(), repeated, append(values, values[0]), sha256.New()) {
+		t.Error("multiproof failed to verify with a repeated key")
+	}
+	if VerifyMultiProof(repeatedProof, smt.Root(), repeated, append(values, []byte("badValue")), sha256.New()) {
+		t.Error("multiproof verified with a repeated key of another value")
+	}
+
+	extra := proof
+	extra.Hashes = append(append([][]byte(nil), proof.Hashes...), proof.Hashes[0])
+	if VerifyMultiProof(extra, smt.Root(), keys, values, sha256.New()) {
+		t.Error("multiproof verified with an extra hash")
+	}
+	tampered := proof
+	tampered.Hashes = append([][]byte(nil), proof.Hashes...)
+	tampered.Hashes[0] = make([]byte, len(proof.Hashes[0]))
+	if VerifyMultiProof(tampered, smt.Root(), keys, values, sha256.New()) {
+		t.Error("multiproof verified with a tampered hash")
+	}
+	truncated := proof
+	truncated.Structure = proof.Structure[:len(proof.Structure)-1]
+	if VerifyMultiProof(truncated, smt.Root(), keys, values, sha256.New()) {
+		t.Error("multiproof verified with a truncated structure")
+	}
+
+	empty := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	proof, err = empty.ProveMulti(keys)
+	if err != nil {
+		t.Fatalf("returned error when proving keys of empty tree: %v", err)
+	}
+	if !VerifyMultiProof(proof, empty.Root(), keys, make([][]byte, len(keys)), sha256.New()) {
+		t.Error("multiproof of empty tree failed to verify")
+	}
+}
+
+func TestMultiProofClustered(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), newDummyHasher(sha256.New()))
+	clusteredKey := func(last byte) []byte {
+		key := make([]byte, 36)
+		key[4] = 0x5a
+		key[35] = last
+		return key
+	}
+	for i := 0; i < 100; i++ {
+		key := make([]byte, 36)
+		rand.Read(key[4:])
+		smt.Update(key, []byte("testValue"))
+	}
+	var keys, values [][]byte
+	for i := 0; i < 16; i++ {
+		key := clusteredKey(byte(i * 3))
+		value := []byte(fmt.Sprintf("testValue%d", i))
+		if i%4 == 0 {
+			value = defaultValue
+		} else {
+			smt.Update(key, value)
+		}
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+
+	proof, err := smt.ProveMulti(keys)
+	if err != nil {
+		t.Fatalf("returned error when proving keys: %v", err)
+	}
+	if !VerifyMultiProof(proof, smt.Root(), keys, values, newDummyHasher(sha256.New())) {
+		t.Fatal("valid multiproof of clustered keys failed to verify")
+	}
+	if n := individualProofHashes(t, smt, keys); len(proof.Hashes)*4 > n {
+		t.Errorf("multiproof of clustered keys has %d hashes, individual proofs %d", len(proof.Hashes), n)
+	}
+}
+
+// individualProofHashes returns the number of side nodes of the individual
+// proofs of keys.
+func individualProofHashes(t *testing.T, smt *SparseMerkleTree, keys [][]byte) int {
+	n := 0
+	for _, key := range keys {
+		proof, err := smt.Prove(key)
+		if err != nil {
+			t.Fatalf("returned error when proving key: %v", err)
+		}
+		n += len(proof.SideNodes)
+	}
+	return n
+}
+
+func containsKey(keys [][]byte, key []byte) bool {
+	for _, k := range keys {
+		if string(k) == string(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMultiProofSnapshot(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	var keys, values [][]byte
+	for i := 0; i < 20; i++ {
+		key, value := []byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i))
+		smt.Update(key, value)
+		keys, values = append(keys, key), append(values, value)
+	}
+	snapshot := smt.Snapshot()
+	defer snapshot.Release()
+	for i := 0; i < 20; i += 2 {
+		smt.Update(keys[i], []byte("newValue"))
+	}
+
+	proof, err := snapshot.ProveMulti(keys)
+	if err != nil {
+		t.Fatalf("returned error when proving keys against snapshot: %v", err)
+	}
+	if !VerifyMultiProof(proof, snapshot.Root(), keys, values, sha256.New()) {
+		t.Error("multiproof against snapshot failed to verify")
+	}
+	if VerifyMultiProof(proof, smt.Root(), keys, values, sha256.New()) {
+		t.Error("multiproof against snapshot verified against current root")
+	}
+}
@@ -0,0 +1,136 @@
+package smt
+
+// MultiStore is a MapStore that can also read and write several keys in one
+// call, e.g. by pipelining requests to a database. The tree uses these
+// methods, when its stores implement them, wherever an operation reads or
+// writes several entries at once, such as writing the nodes along the path of
+// an update.
+type MultiStore interface {
+	MapStore
+	// BatchGet gets the values for keys, in order. It fails like Get if any
+	// key is missing.
+	BatchGet(keys [][]byte) ([][]byte, error)
+	// BatchSet updates the values for keys.
+	BatchSet(keys [][]byte, values [][]byte) error
+}
+
+// batchGet gets the values for keys from store in one call if it is a
+// MultiStore, or one by one otherwise.
+func batchGet(store MapStore, keys [][]byte) ([][]byte, error) {
+	if ms, ok := store.(MultiStore); ok {
+		return ms.BatchGet(keys)
+	}
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		value, err := store.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// batchSet updates the values for keys in store in one call if it is a
+// MultiStore, or one by one otherwise.
+func batchSet(store MapStore, keys [][]byte, values [][]byte) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if ms, ok := store.(MultiStore); ok {
+		return ms.BatchSet(keys, values)
+	}
+	for i, key := range keys {
+		if err := store.Set(key, values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nodeBatch collects node writes, to be made with a single call to setNodes.
+type nodeBatch struct {
+	hashes, data [][]byte
+}
+
+// newNodeBatch returns a batch with room for n writes in a single allocation.
+func newNodeBatch(n int) *nodeBatch {
+	buf := make([][]byte, 2*n)
+	return &nodeBatch{hashes: buf[:0:n], data: buf[n:n]}
+}
+
+func (b *nodeBatch) add(hash []byte, data []byte) {
+	b.hashes = append(b.hashes, hash)
+	b.data = append(b.data, data)
+}
+
+// getNodes gets the data of the nodes with the given hashes from the node
+// store.
+func (smt *SparseMerkleTree) getNodes(hashes [][]byte) ([][]byte, error) {
+	if smt.nodeCache == nil {
+		if smt.metrics != nil {
+			smt.metrics.AddNodeReads(len(hashes), 0)
+		}
+		data, err := batchGet(smt.nodes, hashes)
+		if err != nil {
+			return nil, err
+		}
+		for i := range data {
+			data[i] = smt.th.decodeNode(data[i])
+		}
+		return data, nil
+	}
+
+	// Read the nodes not in the cache in a single batch.
+	data := make([][]byte, len(hashes))
+	var missing []int
+	var missingHashes [][]byte
+	for i, hash := range hashes {
+		if cached, ok := smt.nodeCache.get(hash); ok {
+			data[i] = cached
+			continue
+		}
+		missing = append(missing, i)
+		missingHashes = append(missingHashes, hash)
+	}
+	if smt.metrics != nil {
+		smt.metrics.AddNodeReads(len(missing), len(hashes)-len(missing))
+	}
+	if len(missing) == 0 {
+		return data, nil
+	}
+	read, err := batchGet(smt.nodes, missingHashes)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range missing {
+		data[i] = smt.th.decodeNode(read[j])
+		smt.nodeCache.add(hashes[i], data[i])
+	}
+	return data, nil
+}
+
+// setNodes writes the nodes collected in b to the node store.
+func (smt *SparseMerkleTree) setNodes(b *nodeBatch) error {
+	data := b.data
+	if smt.compactNodes {
+		data = make([][]byte, len(b.data))
+		for i := range b.data {
+			data[i] = smt.th.encodeNode(b.data[i])
+		}
+	}
+	if smt.retention != nil {
+		for _, hash := range b.hashes {
+			delete(smt.retention.pending, string(hash))
+		}
+	}
+	if smt.nodeCache != nil {
+		for _, hash := range b.hashes {
+			smt.nodeCache.evict(hash)
+		}
+	}
+	if smt.metrics != nil {
+		smt.metrics.AddNodeWrites(len(b.hashes), 0)
+	}
+	return batchSet(smt.nodes, b.hashes, data)
+}
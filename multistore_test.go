@@ -0,0 +1,91 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+// multiMap is a MultiStore on a SimpleMap that counts its calls.
+type multiMap struct {
+	*SimpleMap
+	gets, sets, batchGets, batchSets int
+}
+
+func (m *multiMap) Get(key []byte) ([]byte, error) {
+	m.gets++
+	return m.SimpleMap.Get(key)
+}
+
+func (m *multiMap) Set(key []byte, value []byte) error {
+	m.sets++
+	return m.SimpleMap.Set(key, value)
+}
+
+func (m *multiMap) BatchGet(keys [][]byte) ([][]byte, error) {
+	m.batchGets++
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		value, err := m.SimpleMap.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+func (m *multiMap) BatchSet(keys [][]byte, values [][]byte) error {
+	m.batchSets++
+	for i, key := range keys {
+		if err := m.SimpleMap.Set(key, values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestMultiStore(t *testing.T) {
+	nodes := &multiMap{SimpleMap: NewSimpleMap()}
+	smt := NewSparseMerkleTree(nodes, NewSimpleMap(), sha256.New())
+	plain := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		value := []byte(fmt.Sprintf("testValue%d", i))
+		batchSets := nodes.batchSets
+		smt.Update(key, value)
+		plain.Update(key, value)
+		if nodes.batchSets != batchSets+1 {
+			t.Fatalf("update made %d batch writes, expected 1", nodes.batchSets-batchSets)
+		}
+	}
+	for i := 0; i < 50; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		smt.Delete(key)
+		plain.Delete(key)
+	}
+	if nodes.sets != 0 {
+		t.Errorf("made %d single writes to a MultiStore", nodes.sets)
+	}
+	if !bytes.Equal(smt.Root(), plain.Root()) {
+		t.Error("MultiStore changed the root")
+	}
+
+	keys := [][]byte{[]byte("testKey60"), []byte("testKey70")}
+	gets := nodes.gets
+	data, err := smt.ExportProofBundle(keys)
+	if err != nil {
+		t.Fatalf("returned error when exporting bundle: %v", err)
+	}
+	if nodes.batchGets != len(keys) {
+		t.Errorf("made %d batch reads exporting bundle, expected %d", nodes.batchGets, len(keys))
+	}
+	if nodes.gets-gets > 2*smt.depth() {
+		t.Errorf("made %d single reads exporting bundle", nodes.gets-gets)
+	}
+	if _, err := ImportProofBundle(data, sha256.New()); err != nil {
+		t.Errorf("returned error when importing bundle: %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+)
+
+// Neighbors returns the keys of the leaves around the path of key at the
+// current root: the key with the greatest path below it and the key with the
+// least path above it, or nil on a side with no such key. Keys are ordered by
+// path, as in ProveEmptyRange, and key itself is not its own neighbor, so an
+// absent key is proven absent by the proofs of its two adjacent neighbors, as
+// ICS23 non-existence proofs do. The tree must be created with WithKeyStore;
+// otherwise ErrKeysNotRetained is returned.
+func (smt *SparseMerkleTree) Neighbors(key []byte) (left []byte, right []byte, err error) {
+	defer smt.readLock()()
+	return smt.neighborsForRoot(key, smt.Root())
+}
+
+// NeighborsForRoot returns the keys of the leaves around the path of key at
+// root, as Neighbors does at the current root. The key store only holds the
+// keys of the current leaves, so ErrKeysNotRetained is returned for a
+// neighbor deleted since root.
+func (smt *SparseMerkleTree) NeighborsForRoot(key []byte, root []byte) (left []byte, right []byte, err error) {
+	defer smt.readLock()()
+	return smt.neighborsForRoot(key, root)
+}
+
+func (smt *SparseMerkleTree) neighborsForRoot(key []byte, root []byte) ([]byte, []byte, error) {
+	if smt.keys == nil {
+		return nil, nil, ErrKeysNotRetained
+	}
+	path := smt.th.path(key)
+
+	// Descend to the path, keeping the nearest non-empty subtrees on either
+	// side of it: the neighbors are the nearest leaves in them, unless the
+	// leaf the path ends at is another key's.
+	placeholder := smt.th.placeholder()
+	leftTree, rightTree := placeholder, placeholder
+	var leftPath, rightPath []byte
+	hash := root
+descend:
+	for depth := 0; !bytes.Equal(hash, placeholder); depth++ {
+		data, err := smt.getNode(hash)
+		if err != nil {
+			return nil, nil, err
+		}
+		if smt.th.isLeaf(data) {
+			leafPath, _ := smt.th.parseLeaf(data)
+			switch bytes.Compare(leafPath, path) {
+			case -1:
+				leftPath = leafPath
+			case 1:
+				rightPath = leafPath
+			}
+			break descend
+		}
+		leftNode, rightNode := smt.th.parseNode(data)
+		if getBitAtFromMSB(path, depth) == right {
+			if !bytes.Equal(leftNode, placeholder) {
+				leftTree = leftNode
+			}
+			hash = rightNode
+		} else {
+			if !bytes.Equal(rightNode, placeholder) {
+				rightTree = rightNode
+			}
+			hash = leftNode
+		}
+	}
+
+	var err error
+	if leftPath == nil {
+		if leftPath, err = smt.edgeLeafPath(leftTree, true); err != nil {
+			return nil, nil, err
+		}
+	}
+	if rightPath == nil {
+		if rightPath, err = smt.edgeLeafPath(rightTree, false); err != nil {
+			return nil, nil, err
+		}
+	}
+	leftKey, err := smt.leafKey(leftPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	rightKey, err := smt.leafKey(rightPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return leftKey, rightKey, nil
+}
+
+// edgeLeafPath returns the path of the rightmost leaf of the subtree at hash,
+// or of its leftmost if not rightmost, or nil for an empty subtree.
+func (smt *SparseMerkleTree) edgeLeafPath(hash []byte, rightmost bool) ([]byte, error) {
+	for !bytes.Equal(hash, smt.th.placeholder()) {
+		data, err := smt.getNode(hash)
+		if err != nil {
+			return nil, err
+		}
+		if smt.th.isLeaf(data) {
+			path, _ := smt.th.parseLeaf(data)
+			return path, nil
+		}
+		leftNode, rightNode := smt.th.parseNode(data)
+		// A branch has at least one non-empty child.
+		if rightmost && !bytes.Equal(rightNode, smt.th.placeholder()) || bytes.Equal(leftNode, smt.th.placeholder()) {
+			hash = rightNode
+		} else {
+			hash = leftNode
+		}
+	}
+	return nil, nil
+}
+
+// leafKey returns the raw key stored for the leaf at path, or nil for a nil
+// path.
+func (smt *SparseMerkleTree) leafKey(path []byte) ([]byte, error) {
+	if path == nil {
+		return nil, nil
+	}
+	key, err := smt.keys.Get(path)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, ErrKeysNotRetained
+	}
+	return key, err
+}
@@ -0,0 +1,65 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestNeighbors(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithKeyStore(NewSimpleMap()), WithRootRetention(1))
+	if left, right, err := smt.Neighbors([]byte("testKey")); err != nil || left != nil || right != nil {
+		t.Errorf("got neighbors %q and %q in empty tree: %v", left, right, err)
+	}
+
+	var keys [][]byte
+	for i := 0; i < 50; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		smt.Update(key, []byte("testValue"))
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(smt.th.path(keys[i]), smt.th.path(keys[j])) < 0 })
+
+	check := func(key []byte) {
+		var expectedLeft, expectedRight []byte
+		path := smt.th.path(key)
+		for _, k := range keys {
+			switch bytes.Compare(smt.th.path(k), path) {
+			case -1:
+				expectedLeft = k
+			case 1:
+				if expectedRight == nil {
+					expectedRight = k
+				}
+			}
+		}
+		left, right, err := smt.Neighbors(key)
+		if err != nil {
+			t.Fatalf("returned error when getting neighbors: %v", err)
+		}
+		if !bytes.Equal(left, expectedLeft) || !bytes.Equal(right, expectedRight) {
+			t.Errorf("got neighbors %q and %q of %q, expected %q and %q", left, right, key, expectedLeft, expectedRight)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		check([]byte(fmt.Sprintf("testKey%d", i)))
+	}
+
+	// Neighbors at an old root.
+	oldRoot := smt.Root()
+	smt.Update([]byte("newKey"), []byte("newValue"))
+	left, right, _ := smt.Neighbors([]byte("newKey"))
+	oldLeft, oldRight, err := smt.NeighborsForRoot([]byte("newKey"), oldRoot)
+	if err != nil || !bytes.Equal(left, oldLeft) || !bytes.Equal(right, oldRight) {
+		t.Errorf("got neighbors %q and %q at old root, expected %q and %q: %v", oldLeft, oldRight, left, right, err)
+	}
+
+	noKeys := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	noKeys.Update([]byte("testKey"), []byte("testValue"))
+	if _, _, err := noKeys.Neighbors([]byte("otherKey")); !errors.Is(err, ErrKeysNotRetained) {
+		t.Errorf("did not return ErrKeysNotRetained without key store, got %v", err)
+	}
+}
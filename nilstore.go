@@ -0,0 +1,41 @@
+package smt
+
+// NilValueStore is a value store that keeps nothing, for trees that commit to
+// the hashes of values kept elsewhere, such as by an application that already
+// persists them, rather than store the values a second time. Update and the
+// other methods still hash each value into its leaf, or take its hash with
+// UpdateLeafHash, so the root is the same as with any other store, and
+// proofs verify with VerifyProof against values supplied by the caller. Get
+// returns the default value for every key, or ErrValueMissing for keys in the
+// tree in one created with WithMissingValueCheck.
+type NilValueStore struct{}
+
+// NewNilValueStore creates a NilValueStore.
+func NewNilValueStore() *NilValueStore {
+	return &NilValueStore{}
+}
+
+// Get returns an InvalidKeyError for every key.
+func (*NilValueStore) Get(key []byte) ([]byte, error) {
+	return nil, &InvalidKeyError{Key: key}
+}
+
+// Set discards the value.
+func (*NilValueStore) Set(key []byte, value []byte) error {
+	return nil
+}
+
+// Delete returns an InvalidKeyError for every key, as none is stored.
+func (*NilValueStore) Delete(key []byte) error {
+	return &InvalidKeyError{Key: key}
+}
+
+// Export exports an empty store.
+func (*NilValueStore) Export() ([]byte, error) {
+	return encodeSnapshot(map[string][]byte{})
+}
+
+// Size returns no entries.
+func (*NilValueStore) Size() (int, int64, error) {
+	return 0, 0, nil
+}
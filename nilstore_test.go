@@ -0,0 +1,56 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestNilValueStore(t *testing.T) {
+	hashOnly := NewSparseMerkleTree(NewSimpleMap(), NewNilValueStore(), sha256.New())
+	full := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for _, smt := range []*SparseMerkleTree{hashOnly, full} {
+		if _, err := smt.Update([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatalf("returned error when updating: %v", err)
+		}
+		if _, err := smt.UpdateLeafHash([]byte("baz"), sha256Sum([]byte("qux"))); err != nil {
+			t.Fatalf("returned error when updating leaf hash: %v", err)
+		}
+		if _, err := smt.Update([]byte("old"), []byte("value")); err != nil {
+			t.Fatalf("returned error when updating: %v", err)
+		}
+		if _, err := smt.Delete([]byte("old")); err != nil {
+			t.Fatalf("returned error when deleting: %v", err)
+		}
+	}
+	if !bytes.Equal(hashOnly.Root(), full.Root()) {
+		t.Error("hash-only tree has another root than a tree storing its values")
+	}
+
+	value, err := hashOnly.Get([]byte("foo"))
+	if err != nil || len(value) != 0 {
+		t.Errorf("got %q, %v for a key of a hash-only tree, want the default value", value, err)
+	}
+	proof, err := hashOnly.Prove([]byte("foo"))
+	if err != nil {
+		t.Fatalf("returned error when proving: %v", err)
+	}
+	if !VerifyProof(proof, hashOnly.Root(), []byte("foo"), []byte("bar"), sha256.New()) {
+		t.Error("proof of a hash-only tree failed to verify with the value supplied")
+	}
+	if VerifyProof(proof, hashOnly.Root(), []byte("foo"), []byte("other"), sha256.New()) {
+		t.Error("proof of a hash-only tree verified with another value")
+	}
+
+	checked := NewSparseMerkleTree(NewSimpleMap(), NewNilValueStore(), sha256.New(), WithMissingValueCheck())
+	checked.Update([]byte("foo"), []byte("bar"))
+	if _, err := checked.Get([]byte("foo")); !errors.Is(err, ErrValueMissing) {
+		t.Errorf("got %v for a key of a hash-only tree with missing value checks, want ErrValueMissing", err)
+	}
+}
+
+func sha256Sum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
@@ -0,0 +1,107 @@
+package smt
+
+import (
+	"container/list"
+	"sync"
+)
+
+// NodeCacheStats counts the node reads served by the node cache of a tree.
+type NodeCacheStats struct {
+	Hits   uint64 // Node reads served from the cache.
+	Misses uint64 // Node reads made from the node store.
+}
+
+// nodeCache is a least recently used cache of the decoded data of nodes, by
+// hash. As nodes are stored by the hash of their data, a cached node only
+// goes stale when it is deleted or written again, on which it is evicted.
+type nodeCache struct {
+	size int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // Of *nodeCacheEntry, most recently used first.
+	stats   NodeCacheStats
+}
+
+type nodeCacheEntry struct {
+	hash string
+	data []byte
+}
+
+// EnableNodeHashCache caches the data of up to size nodes read from the node
+// store, by hash, so that repeated proofs and Gets over a stable tree read
+// the nodes near the root, which every traversal passes, from memory rather
+// than from the store. Nodes are cached on first read, and evicted when the
+// tree deletes or writes them, as an update does the nodes on the path it
+// replaces; the nodes it writes are only cached once read. A size of 0
+// disables the cache.
+//
+// Like SetOperationLimit, the cache should be enabled before the tree is
+// shared. Writes made to the node store other than by the tree, such as an
+// out-of-band repair, are not seen while the node is cached.
+func (smt *SparseMerkleTree) EnableNodeHashCache(size int) {
+	if size < 0 {
+		panic("smt: negative cache size")
+	}
+	if size == 0 {
+		smt.nodeCache = nil
+		return
+	}
+	smt.nodeCache = &nodeCache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// NodeCacheStats returns the hit and miss counts of the node cache so far, or
+// zero counts if it is not enabled.
+func (smt *SparseMerkleTree) NodeCacheStats() NodeCacheStats {
+	if smt.nodeCache == nil {
+		return NodeCacheStats{}
+	}
+	smt.nodeCache.mu.Lock()
+	defer smt.nodeCache.mu.Unlock()
+	return smt.nodeCache.stats
+}
+
+// get returns the cached data of the node with the given hash, if any, and
+// counts the read.
+func (c *nodeCache) get(hash []byte) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[string(hash)]; ok {
+		c.order.MoveToFront(elem)
+		c.stats.Hits++
+		return elem.Value.(*nodeCacheEntry).data, true
+	}
+	c.stats.Misses++
+	return nil, false
+}
+
+// add caches the data of the node with the given hash, evicting the least
+// recently used node if the cache is full.
+func (c *nodeCache) add(hash []byte, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[string(hash)]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+	if c.order.Len() >= c.size {
+		back := c.order.Back()
+		c.order.Remove(back)
+		delete(c.entries, back.Value.(*nodeCacheEntry).hash)
+	}
+	c.entries[string(hash)] = c.order.PushFront(&nodeCacheEntry{hash: string(hash), data: data})
+}
+
+// evict drops the node with the given hash from the cache, if cached.
+func (c *nodeCache) evict(hash []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[string(hash)]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, string(hash))
+	}
+}
@@ -0,0 +1,68 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func TestNodeHashCache(t *testing.T) {
+	nodes := &countingStore{MapStore: NewSimpleMap()}
+	smt := NewSparseMerkleTree(nodes, NewSimpleMap(), sha256.New())
+	for i := 0; i < 100; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	smt.EnableNodeHashCache(1000)
+	nodes.gets = 0
+
+	prove := func() {
+		for i := 0; i < 100; i++ {
+			key := []byte(fmt.Sprintf("testKey%d", i))
+			proof, err := smt.Prove(key)
+			value, _ := smt.Get(key)
+			if err != nil || !VerifyProof(proof, smt.Root(), key, value, sha256.New()) {
+				t.Fatalf("did not prove key %d: %v", i, err)
+			}
+		}
+	}
+	prove()
+	first := nodes.gets
+	prove()
+	if nodes.gets != first {
+		t.Errorf("read %d nodes from the store with all cached", nodes.gets-first)
+	}
+	if stats := smt.NodeCacheStats(); stats.Hits == 0 || stats.Misses != uint64(first) {
+		t.Errorf("unexpected cache stats %+v after %d store reads", stats, first)
+	}
+
+	// An update evicts the ancestors it replaces, and later reads see it.
+	oldRoot := smt.Root()
+	smt.Update([]byte("testKey7"), []byte("newValue"))
+	if _, ok := smt.nodeCache.entries[string(oldRoot)]; ok {
+		t.Error("old root is still cached after update")
+	}
+	proof, err := smt.Prove([]byte("testKey7"))
+	if err != nil || !VerifyProof(proof, smt.Root(), []byte("testKey7"), []byte("newValue"), sha256.New()) {
+		t.Errorf("did not prove updated key: %v", err)
+	}
+	if value, err := smt.Get([]byte("testKey7")); err != nil || !bytes.Equal(value, []byte("newValue")) {
+		t.Errorf("did not get updated value, got %s, %v", value, err)
+	}
+	smt.Delete([]byte("testKey8"))
+	if has, err := smt.Has([]byte("testKey8")); err != nil || has {
+		t.Errorf("deleted key still present, got %v, %v", has, err)
+	}
+	prove()
+
+	// A small cache holds at most its size.
+	smt.EnableNodeHashCache(10)
+	prove()
+	if n := smt.nodeCache.order.Len(); n > 10 {
+		t.Errorf("cache holds %d nodes, more than its size", n)
+	}
+	smt.EnableNodeHashCache(0)
+	if stats := smt.NodeCacheStats(); stats != (NodeCacheStats{}) {
+		t.Errorf("disabled cache has stats %+v", stats)
+	}
+}
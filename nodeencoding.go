@@ -0,0 +1,126 @@
+package smt
+
+import (
+	"bytes"
+)
+
+// compactNodePrefix marks node data stored in the compact encoding: the prefix,
+// a byte of presence bits for the left (bit 0) and right (bit 1) children, and
+// the hashes of the children that are not placeholders.
+var compactNodePrefix = []byte{2}
+
+const (
+	leftPresent  = 1 << 0
+	rightPresent = 1 << 1
+)
+
+// WithCompactNodes stores internal nodes with a placeholder child in a compact
+// encoding that omits the placeholder, shrinking the node store of sparse
+// trees. Node data is decoded on read, so hashes and proofs are unaffected,
+// and trees read nodes in either encoding regardless of this option.
+func WithCompactNodes() Option {
+	return func(smt *SparseMerkleTree) {
+		smt.compactNodes = true
+	}
+}
+
+// encodeNode returns the compact encoding of node data, or data itself if it
+// is a leaf or has no placeholder child.
+func (th *treeHasher) encodeNode(data []byte) []byte {
+	if th.isLeaf(data) {
+		return data
+	}
+	left, right := th.parseNode(data)
+	var presence byte
+	encoded := append([]byte{}, compactNodePrefix...)
+	encoded = append(encoded, 0)
+	if !bytes.Equal(left, th.placeholder()) {
+		presence |= leftPresent
+		encoded = append(encoded, left...)
+	}
+	if !bytes.Equal(right, th.placeholder()) {
+		presence |= rightPresent
+		encoded = append(encoded, right...)
+	}
+	if presence == leftPresent|rightPresent {
+		return data
+	}
+	encoded[len(compactNodePrefix)] = presence
+	return encoded
+}
+
+// decodeNode returns the node data for data read from the node store.
+func (th *treeHasher) decodeNode(data []byte) []byte {
+	if len(data) == 0 || !bytes.Equal(data[:len(compactNodePrefix)], compactNodePrefix) {
+		return data
+	}
+	presence := data[len(compactNodePrefix)]
+	children := data[len(compactNodePrefix)+1:]
+	left, right := th.placeholder(), th.placeholder()
+	if presence&leftPresent != 0 {
+		left, children = children[:th.hashSize()], children[th.hashSize():]
+	}
+	if presence&rightPresent != 0 {
+		right = children[:th.hashSize()]
+	}
+	decoded := make([]byte, 0, len(th.nodePrefix)+2*th.hashSize())
+	decoded = append(decoded, th.nodePrefix...)
+	decoded = append(decoded, left...)
+	return append(decoded, right...)
+}
+
+// getNode gets the data of the node with the given hash from the node store.
+func (smt *SparseMerkleTree) getNode(hash []byte) ([]byte, error) {
+	if smt.nodeCache != nil {
+		if data, ok := smt.nodeCache.get(hash); ok {
+			if smt.metrics != nil {
+				smt.metrics.AddNodeReads(0, 1)
+			}
+			return data, nil
+		}
+	}
+	if smt.metrics != nil {
+		smt.metrics.AddNodeReads(1, 0)
+	}
+	data, err := smt.nodes.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+	data = smt.th.decodeNode(data)
+	if smt.nodeCache != nil {
+		smt.nodeCache.add(hash, data)
+	}
+	return data, nil
+}
+
+// setNode writes the data of the node with the given hash to the node store.
+func (smt *SparseMerkleTree) setNode(hash []byte, data []byte) error {
+	if smt.retention != nil {
+		// Written again, so no longer orphaned.
+		delete(smt.retention.pending, string(hash))
+	}
+	if smt.nodeCache != nil {
+		smt.nodeCache.evict(hash)
+	}
+	if smt.compactNodes {
+		data = smt.th.encodeNode(data)
+	}
+	if smt.metrics != nil {
+		smt.metrics.AddNodeWrites(1, 0)
+	}
+	return smt.nodes.Set(hash, data)
+}
+
+// deleteNode deletes the node with the given hash from the node store.
+func (smt *SparseMerkleTree) deleteNode(hash []byte) error {
+	if err := smt.preserve(nodeStore, hash); err != nil {
+		return err
+	}
+	if smt.nodeCache != nil {
+		smt.nodeCache.evict(hash)
+	}
+	if smt.metrics != nil {
+		smt.metrics.AddNodeWrites(0, 1)
+	}
+	return smt.nodes.Delete(hash)
+}
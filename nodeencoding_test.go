@@ -0,0 +1,87 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestCompactNodes(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+	compactSmn, compactSmv := NewSimpleMap(), NewSimpleMap()
+	compact := NewSparseMerkleTree(compactSmn, compactSmv, sha256.New(), WithCompactNodes())
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		smt.Update(key, []byte("testValue"))
+		compact.Update(key, []byte("testValue"))
+	}
+	for i := 0; i < 100; i += 3 {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		smt.Delete(key)
+		compact.Delete(key)
+	}
+
+	if !bytes.Equal(smt.Root(), compact.Root()) {
+		t.Fatal("compact node encoding changed the root")
+	}
+	if len(compactSmn.m) != len(smn.m) || storeSize(compactSmn) >= storeSize(smn) {
+		t.Errorf("compact node store is %d bytes, expected less than %d", storeSize(compactSmn), storeSize(smn))
+	}
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		proof, _ := smt.ProveUpdatable(key)
+		compactProof, err := compact.ProveUpdatable(key)
+		if err != nil {
+			t.Errorf("returned error when proving key: %v", err)
+		}
+		if !reflect.DeepEqual(proof, compactProof) {
+			t.Errorf("compact node encoding changed the proof for key %s", key)
+		}
+	}
+
+	// Exported compact stores import into trees without the option.
+	wrap, err := ExportTrie(compact)
+	if err != nil {
+		t.Fatalf("returned error when exporting: %v", err)
+	}
+	importedSmn, importedSmv, err := ImportMerkleMap(wrap.NodesBytes, wrap.ValuesBytes)
+	if err != nil {
+		t.Fatalf("returned error when importing: %v", err)
+	}
+	imported := ImportSparseMerkleTree(importedSmn, importedSmv, sha256.New(), wrap.Root)
+	imported.Update([]byte("testKey1"), []byte("newValue"))
+	smt.Update([]byte("testKey1"), []byte("newValue"))
+	if !bytes.Equal(smt.Root(), imported.Root()) {
+		t.Error("imported compact tree diverged after update")
+	}
+}
+
+func storeSize(sm *SimpleMap) int {
+	size := 0
+	for key, value := range sm.m {
+		size += len(key) + len(value)
+	}
+	return size
+}
+
+func BenchmarkCompactNodesStoreSize(b *testing.B) {
+	for _, compactNodes := range []bool{false, true} {
+		b.Run(fmt.Sprintf("compact=%v", compactNodes), func(b *testing.B) {
+			var options []Option
+			if compactNodes {
+				options = append(options, WithCompactNodes())
+			}
+			for i := 0; i < b.N; i++ {
+				smn := NewSimpleMap()
+				smt := NewSparseMerkleTree(smn, NewSimpleMap(), sha256.New(), options...)
+				for j := 0; j < 1000; j++ {
+					smt.Update([]byte(fmt.Sprintf("testKey%d", j)), []byte("testValue"))
+				}
+				b.ReportMetric(float64(storeSize(smn)), "bytes")
+			}
+		})
+	}
+}
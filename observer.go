@@ -0,0 +1,126 @@
+package smt
+
+import "errors"
+
+// Observer is notified of the changes made to a tree it is registered on with
+// Observe, as they are made, so that indexes and subscribers can follow the
+// state of the tree without diffing it.
+//
+// OnUpdate is called for each key set to a new value, with the value it had
+// before, or nil if it was absent, and OnDelete for each key deleted, with the
+// value it had. oldValue is also nil for a key set with UpdateLeafHash or
+// UpdatePresence, whose value is not stored, and newValue is nil when a key is
+// so set. newRoot is the root of the tree after the change that made them.
+// OnCommit is then called once for the change, with the root before and after
+// it.
+//
+// Update, Delete, UpdateLeafHash, UpdatePresence, UpdateWithProof, UpdateBatch,
+// the Commit of a tree created with WithLazyCommit and the Commit of a Tx
+// report each key they change; a Tx only records them for the observers
+// registered when it began. Changes that replace the root as a whole, such as
+// SetRoot, Revert, Clear and ApplyDiff, only call OnCommit. Changes that
+// leave the root as it was, such as setting a key to its current value, notify
+// nothing. The slices given must not be modified.
+type Observer interface {
+	OnUpdate(key, oldValue, newValue, newRoot []byte)
+	OnDelete(key, oldValue, newRoot []byte)
+	OnCommit(oldRoot, newRoot []byte)
+}
+
+// ObserverFuncs is an Observer calling the functions it holds, any of which
+// may be nil to ignore its events.
+type ObserverFuncs struct {
+	Update func(key, oldValue, newValue, newRoot []byte)
+	Delete func(key, oldValue, newRoot []byte)
+	Commit func(oldRoot, newRoot []byte)
+}
+
+// OnUpdate calls f.Update if set.
+func (f ObserverFuncs) OnUpdate(key, oldValue, newValue, newRoot []byte) {
+	if f.Update != nil {
+		f.Update(key, oldValue, newValue, newRoot)
+	}
+}
+
+// OnDelete calls f.Delete if set.
+func (f ObserverFuncs) OnDelete(key, oldValue, newRoot []byte) {
+	if f.Delete != nil {
+		f.Delete(key, oldValue, newRoot)
+	}
+}
+
+// OnCommit calls f.Commit if set.
+func (f ObserverFuncs) OnCommit(oldRoot, newRoot []byte) {
+	if f.Commit != nil {
+		f.Commit(oldRoot, newRoot)
+	}
+}
+
+// observer is an Observer registered on a tree.
+type observer struct {
+	Observer
+}
+
+// treeEvent is the change of a key recorded for observers.
+type treeEvent struct {
+	key, oldValue, newValue []byte
+	deleted                 bool
+}
+
+// Observe registers o to be notified of the changes made to the tree, and
+// returns a function that unregisters it. Observers are called as OnRootChange
+// callbacks are: synchronously, in the order they were registered, once the
+// change has been written to the stores, and without the tree locked. Each is
+// given all the events of a change before the next observer is.
+func (smt *SparseMerkleTree) Observe(o Observer) (cancel func()) {
+	if o == nil {
+		panic("smt: nil observer")
+	}
+	registered := &observer{o}
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+	smt.observers = append(smt.observers, registered)
+	return func() {
+		smt.mu.Lock()
+		defer smt.mu.Unlock()
+		// The slice is copied, as changes being notified may still hold it.
+		observers := make([]*observer, 0, len(smt.observers))
+		for _, other := range smt.observers {
+			if other != registered {
+				observers = append(observers, other)
+			}
+		}
+		smt.observers = observers
+	}
+}
+
+// observedValue returns the value stored at path before it is changed, for
+// the events of observers: nil if there is none.
+func (smt *SparseMerkleTree) observedValue(path []byte) ([]byte, error) {
+	value, err := smt.getValue(path)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, nil
+	}
+	return value, err
+}
+
+// recordEvent records the change of key for observers, if any are registered.
+func (smt *SparseMerkleTree) recordEvent(event treeEvent) {
+	if smt.observing {
+		smt.events = append(smt.events, event)
+	}
+}
+
+// notifyObservers calls observers with the events of a change of root.
+func notifyObservers(observers []*observer, events []treeEvent, oldRoot, newRoot []byte) {
+	for _, o := range observers {
+		for _, event := range events {
+			if event.deleted {
+				o.OnDelete(event.key, event.oldValue, newRoot)
+			} else {
+				o.OnUpdate(event.key, event.oldValue, event.newValue, newRoot)
+			}
+		}
+		o.OnCommit(oldRoot, newRoot)
+	}
+}
@@ -0,0 +1,163 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// recordingObserver records the events it is notified of as strings.
+type recordingObserver struct {
+	tree   *SparseMerkleTree
+	t      *testing.T
+	events []string
+}
+
+func (o *recordingObserver) OnUpdate(key, oldValue, newValue, newRoot []byte) {
+	o.checkRoot(newRoot)
+	o.events = append(o.events, fmt.Sprintf("update %s %q %q", key, oldValue, newValue))
+}
+
+func (o *recordingObserver) OnDelete(key, oldValue, newRoot []byte) {
+	o.checkRoot(newRoot)
+	o.events = append(o.events, fmt.Sprintf("delete %s %q", key, oldValue))
+}
+
+func (o *recordingObserver) OnCommit(oldRoot, newRoot []byte) {
+	o.checkRoot(newRoot)
+	o.events = append(o.events, "commit")
+}
+
+func (o *recordingObserver) checkRoot(newRoot []byte) {
+	// Observers may use the tree.
+	if !bytes.Equal(o.tree.Root(), newRoot) {
+		o.t.Error("observer notified with another root than the tree's")
+	}
+}
+
+func (o *recordingObserver) take() []string {
+	events := o.events
+	o.events = nil
+	return events
+}
+
+func TestObserver(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	o := &recordingObserver{tree: smt, t: t}
+	cancel := smt.Observe(o)
+
+	smt.Update([]byte("testKey1"), []byte("testValue1"))
+	smt.Update([]byte("testKey1"), []byte("newValue1"))
+	smt.Delete([]byte("testKey1"))
+	want := []string{
+		`update testKey1 "" "testValue1"`, "commit",
+		`update testKey1 "testValue1" "newValue1"`, "commit",
+		`delete testKey1 "newValue1"`, "commit",
+	}
+	if events := o.take(); !reflect.DeepEqual(events, want) {
+		t.Errorf("got events %q, want %q", events, want)
+	}
+
+	// Changes that leave the root as it was notify nothing.
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	o.take()
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	smt.Delete([]byte("missingKey"))
+	if events := o.take(); len(events) != 0 {
+		t.Errorf("got events %q for changes that leave the root", events)
+	}
+
+	smt.UpdateBatch([][]byte{[]byte("testKey2"), []byte("testKey3"), []byte("testKey4")}, [][]byte{nil, []byte("testValue3"), []byte("testValue4")})
+	events := o.take()
+	if len(events) != 4 || events[3] != "commit" {
+		t.Fatalf("got events %q for a batch of three keys", events)
+	}
+	for _, event := range []string{`delete testKey2 "testValue2"`, `update testKey3 "" "testValue3"`, `update testKey4 "" "testValue4"`} {
+		found := false
+		for _, got := range events[:3] {
+			found = found || got == event
+		}
+		if !found {
+			t.Errorf("batch events %q do not include %q", events, event)
+		}
+	}
+
+	smt.UpdatePresence([]byte("testKey3"))
+	smt.Update([]byte("testKey3"), []byte("newValue3"))
+	want = []string{`update testKey3 "testValue3" ""`, "commit", `update testKey3 "" "newValue3"`, "commit"}
+	if events := o.take(); !reflect.DeepEqual(events, want) {
+		t.Errorf("got events %q, want %q", events, want)
+	}
+
+	// Changes of the root as a whole only commit.
+	root := smt.Root()
+	smt.Update([]byte("testKey5"), []byte("testValue5"))
+	o.take()
+	var commits [][]byte
+	other := smt.Observe(ObserverFuncs{Commit: func(oldRoot, newRoot []byte) {
+		commits = append(commits, oldRoot, newRoot)
+	}})
+	current := smt.Root()
+	smt.SetRoot(root)
+	if events := o.take(); !reflect.DeepEqual(events, []string{"commit"}) {
+		t.Errorf("got events %q setting the root", events)
+	}
+	if len(commits) != 2 || !bytes.Equal(commits[0], current) || !bytes.Equal(commits[1], root) {
+		t.Error("did not notify the commit with the old and new root")
+	}
+
+	cancel()
+	other()
+	smt.Update([]byte("testKey6"), []byte("testValue6"))
+	if len(o.events) != 0 || len(commits) != 2 {
+		t.Error("notified a cancelled observer")
+	}
+}
+
+func TestObserverLazyCommit(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithLazyCommit())
+	o := &recordingObserver{tree: smt, t: t}
+	smt.Observe(o)
+	smt.Update([]byte("testKey1"), []byte("testValue1"))
+	smt.Commit()
+	smt.Update([]byte("testKey1"), []byte("newValue1"))
+	if len(o.events) != 2 {
+		t.Errorf("notified staged writes before the commit: %q", o.events)
+	}
+	smt.Commit()
+	want := []string{`update testKey1 "" "testValue1"`, "commit", `update testKey1 "testValue1" "newValue1"`, "commit"}
+	if events := o.take(); !reflect.DeepEqual(events, want) {
+		t.Errorf("got events %q, want %q", events, want)
+	}
+}
+
+func TestObserverTx(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	smt.Update([]byte("testKey1"), []byte("testValue1"))
+	o := &recordingObserver{tree: smt, t: t}
+	smt.Observe(o)
+
+	tx := smt.BeginTx()
+	tx.Update([]byte("testKey1"), []byte("newValue1"))
+	tx.Update([]byte("testKey2"), []byte("testValue2"))
+	tx.Delete([]byte("testKey1"))
+	if len(o.events) != 0 {
+		t.Errorf("notified staged writes before the commit: %q", o.events)
+	}
+	if _, err := tx.Commit(); err != nil {
+		t.Fatalf("returned error when committing: %v", err)
+	}
+	want := []string{`update testKey1 "testValue1" "newValue1"`, `update testKey2 "" "testValue2"`, `delete testKey1 "newValue1"`, "commit"}
+	if events := o.take(); !reflect.DeepEqual(events, want) {
+		t.Errorf("got events %q, want %q", events, want)
+	}
+
+	tx = smt.BeginTx()
+	tx.Update([]byte("testKey3"), []byte("testValue3"))
+	tx.Rollback()
+	if len(o.events) != 0 {
+		t.Errorf("notified the writes of a rolled back transaction: %q", o.events)
+	}
+}
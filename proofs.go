@@ -2,6 +2,7 @@ package smt
 
 import (
 	"bytes"
+	"fmt"
 	"hash"
 	"math"
 )
@@ -19,35 +20,67 @@ type SparseMerkleProof struct {
 	// SiblingData is the data of the sibling node to the leaf being proven,
 	// required for updatable proofs. For unupdatable proofs, is nil.
 	SiblingData []byte
+
+	// Depth is the depth of the tree the proof is from, i.e. the number of
+	// bits of its paths. Verifiers reject proofs whose depth is not the one
+	// they expect; see WithVerifyDepth. Proofs without a depth, such as those
+	// made before it was recorded, are only checked to have no more side nodes
+	// than the expected depth.
+	Depth int
 }
 
 func (proof *SparseMerkleProof) sanityCheck(th *treeHasher) bool {
-	// Do a basic sanity check on the proof, so that a malicious proof cannot
-	// cause the verifier to fatally exit (e.g. due to an index out-of-range
-	// error) or cause a CPU DoS attack.
+	return proof.check(th) == nil
+}
 
+// check returns why the proof is malformed, if it is. Do a basic sanity check
+// on the proof, so that a malicious proof cannot cause the verifier to
+// fatally exit (e.g. due to an index out-of-range error) or cause a CPU DoS
+// attack. Everything is checked before anything is hashed.
+func (proof *SparseMerkleProof) check(th *treeHasher) error {
 	// Check that the number of supplied sidenodes does not exceed the maximum possible.
-	if len(proof.SideNodes) > th.pathSize()*8 ||
+	if len(proof.SideNodes) > th.maxSideNodes() {
+		return fmt.Errorf("%w: %d side nodes, at most %d", ErrProofTooLong, len(proof.SideNodes), th.maxSideNodes())
+	}
 
-		// Check that leaf data for non-membership proofs is the correct size.
-		(proof.NonMembershipLeafData != nil && len(proof.NonMembershipLeafData) != len(leafPrefix)+th.pathSize()+th.hasher.Size()) {
-		return false
+	// Check that the proof is from a tree of the expected depth.
+	if !th.checkDepth(proof.Depth) {
+		return fmt.Errorf("%w: depth %d, want %d", ErrProofDepth, proof.Depth, th.treeDepth())
 	}
 
 	// Check that all supplied sidenodes are the correct size.
-	for _, v := range proof.SideNodes {
-		if len(v) != th.hasher.Size() {
-			return false
+	for i, v := range proof.SideNodes {
+		if len(v) != th.hashSize() {
+			return fmt.Errorf("%w: side node %d of %d bytes, want %d", ErrProofHashSize, i, len(v), th.hashSize())
 		}
 	}
 
+	// Check that leaf data for non-membership proofs is a leaf of the correct size.
+	if proof.NonMembershipLeafData != nil && (!th.validNode(proof.NonMembershipLeafData) || !th.isLeaf(proof.NonMembershipLeafData)) {
+		return fmt.Errorf("%w: not a leaf of the tree", ErrProofLeafData)
+	}
+
+	// Check that the sibling data is a node, so that hashing it is bounded.
+	if proof.SiblingData != nil && !th.validNode(proof.SiblingData) {
+		return fmt.Errorf("%w: not a node of the tree", ErrProofSiblingData)
+	}
+
+	// In strict mode, check that the nearest sidenode is not a placeholder,
+	// which a canonical proof never has.
+	if th.strict && len(proof.SideNodes) > 0 && bytes.Equal(proof.SideNodes[0], th.placeholder()) {
+		return fmt.Errorf("%w: nearest side node is a placeholder", ErrProofNotCanonical)
+	}
+
 	// Check that the sibling data hashes to the first side node if not nil
 	if proof.SiblingData == nil || len(proof.SideNodes) == 0 {
-		return true
+		return nil
 	}
 
 	siblingHash := th.digest(proof.SiblingData)
-	return bytes.Equal(proof.SideNodes[0], siblingHash)
+	if !bytes.Equal(proof.SideNodes[0], siblingHash) {
+		return fmt.Errorf("%w: does not hash to the nearest side node", ErrProofSiblingData)
+	}
+	return nil
 }
 
 // SparseCompactMerkleProof is a compact Merkle proof for an element in a SparseMerkleTree.
@@ -72,57 +105,170 @@ type SparseCompactMerkleProof struct {
 	// SiblingData is the data of the sibling node to the leaf being proven,
 	// required for updatable proofs. For unupdatable proofs, is nil.
 	SiblingData []byte
+
+	// Depth is the depth of the tree the proof is from, as in
+	// SparseMerkleProof.
+	Depth int
 }
 
 func (proof *SparseCompactMerkleProof) sanityCheck(th *treeHasher) bool {
-	// Do a basic sanity check on the proof on the fields of the proof specific to
-	// the compact proof only.
-	//
-	// When the proof is de-compacted and verified, the sanity check for the
-	// de-compacted proof should be executed.
+	return proof.check(th) == nil
+}
 
+// check returns why the fields of the proof specific to the compact proof
+// are malformed, if they are.
+//
+// When the proof is de-compacted and verified, the sanity check for the
+// de-compacted proof should be executed.
+func (proof *SparseCompactMerkleProof) check(th *treeHasher) error {
 	// Compact proofs: check that NumSideNodes is within the right range.
-	if proof.NumSideNodes < 0 || proof.NumSideNodes > th.pathSize()*8 ||
+	if proof.NumSideNodes < 0 {
+		return fmt.Errorf("%w: negative number of side nodes", ErrProofBitMask)
+	}
+	if proof.NumSideNodes > th.maxSideNodes() {
+		return fmt.Errorf("%w: %d side nodes, at most %d", ErrProofTooLong, proof.NumSideNodes, th.maxSideNodes())
+	}
+	if !th.checkDepth(proof.Depth) {
+		return fmt.Errorf("%w: depth %d, want %d", ErrProofDepth, proof.Depth, th.treeDepth())
+	}
+
+	// Compact proofs: check that the length of the bit mask is as expected
+	// according to NumSideNodes.
+	if len(proof.BitMask) != int(math.Ceil(float64(proof.NumSideNodes)/float64(8))) {
+		return fmt.Errorf("%w: %d bytes for %d side nodes", ErrProofBitMask, len(proof.BitMask), proof.NumSideNodes)
+	}
+
+	// Compact proofs: check that no bit is set past the side nodes, which
+	// would be counted below but never read when decompacting.
+	for i := proof.NumSideNodes; i < len(proof.BitMask)*8; i++ {
+		if getBitAtFromMSB(proof.BitMask, i) == 1 {
+			return fmt.Errorf("%w: bit set past the side nodes", ErrProofBitMask)
+		}
+	}
 
-		// Compact proofs: check that the length of the bit mask is as expected
-		// according to NumSideNodes.
-		len(proof.BitMask) != int(math.Ceil(float64(proof.NumSideNodes)/float64(8))) ||
+	// Compact proofs: check that the correct number of sidenodes have been
+	// supplied according to the bit mask.
+	if proof.NumSideNodes > 0 && len(proof.SideNodes) != proof.NumSideNodes-countSetBits(proof.BitMask) {
+		return fmt.Errorf("%w: %d side nodes supplied, %d marked missing of %d", ErrProofBitMask,
+			len(proof.SideNodes), countSetBits(proof.BitMask), proof.NumSideNodes)
+	}
 
-		// Compact proofs: check that the correct number of sidenodes have been
-		// supplied according to the bit mask.
-		(proof.NumSideNodes > 0 && len(proof.SideNodes) != proof.NumSideNodes-countSetBits(proof.BitMask)) {
+	// In strict mode, check that no placeholder is supplied rather than
+	// marked in the bit mask.
+	if th.strict {
+		for _, v := range proof.SideNodes {
+			if bytes.Equal(v, th.placeholder()) {
+				return fmt.Errorf("%w: placeholder side node not in the bit mask", ErrProofNotCanonical)
+			}
+		}
+	}
+
+	return nil
+}
+
+// VerifyProof verifies a Merkle proof. The side nodes are combined along the
+// path of key, so a proof only verifies for the key it was generated for, or,
+// for a non-membership proof, for keys sharing the same empty subtree.
+func VerifyProof(proof SparseMerkleProof, root []byte, key []byte, value []byte, hasher hash.Hash, options ...VerifyOption) bool {
+	config := newVerifyConfig(options)
+	if !config.checkValueSize(value) {
 		return false
 	}
+	result, _ := verifyProofWithUpdates(proof, root, key, value, config.treeHasher(hasher), config.defaultValue)
+	return result
+}
 
-	return true
+// VerifyProofWithValueHash verifies a Merkle proof of membership for a leaf
+// committing to valueHash, as placed by UpdateLeafHash.
+func VerifyProofWithValueHash(proof SparseMerkleProof, root []byte, key []byte, valueHash []byte, hasher hash.Hash, options ...VerifyOption) bool {
+	th := newVerifyConfig(options).treeHasher(hasher)
+	if len(valueHash) != th.hashSize() {
+		return false
+	}
+	result, _ := verifyProofForValueHash(proof, root, th.path(key), valueHash, th)
+	return result
 }
 
-// VerifyProof verifies a Merkle proof.
-func VerifyProof(proof SparseMerkleProof, root []byte, key []byte, value []byte, hasher hash.Hash) bool {
-	result, _ := verifyProofWithUpdates(proof, root, key, value, hasher)
+// VerifyPresenceProof verifies a Merkle proof of membership for a presence
+// leaf, as placed by UpdatePresence, which commits to key without a value.
+func VerifyPresenceProof(proof SparseMerkleProof, root []byte, key []byte, hasher hash.Hash, options ...VerifyOption) bool {
+	th := newVerifyConfig(options).treeHasher(hasher)
+	result, _ := verifyProofForValueHash(proof, root, th.path(key), []byte{}, th)
 	return result
 }
 
-func verifyProofWithUpdates(proof SparseMerkleProof, root []byte, key []byte, value []byte, hasher hash.Hash) (bool, [][][]byte) {
-	th := newTreeHasher(hasher)
-	path := th.path(key)
+// VerifyNotValue verifies a Merkle proof that key holds value, with the
+// default value for a non-membership proof, and returns true if it does and
+// value differs from claimedValue, refuting a claim that key holds
+// claimedValue. As proofs do not carry the value they prove, the value the
+// proof was made for must be given; the proof only refutes the claim once it
+// verifies for that value.
+func VerifyNotValue(proof SparseMerkleProof, root []byte, key []byte, value []byte, claimedValue []byte, hasher hash.Hash, options ...VerifyOption) bool {
+	return !bytes.Equal(value, claimedValue) && VerifyProof(proof, root, key, value, hasher, options...)
+}
+
+// verifyProofWithUpdates verifies a proof for value, where emptyValue stands
+// for an absent key.
+func verifyProofWithUpdates(proof SparseMerkleProof, root []byte, key []byte, value []byte, th *treeHasher, emptyValue []byte) (bool, [][][]byte) {
+	var valueHash []byte
+	if !bytes.Equal(value, emptyValue) {
+		valueHash = th.digestValue(value)
+	}
+	return verifyProofForValueHash(proof, root, th.path(key), valueHash, th)
+}
 
-	if !proof.sanityCheck(th) {
+// verifyProofForValueHash verifies a proof of membership of a leaf for
+// valueHash at path, of a presence leaf if valueHash is empty, or of
+// non-membership if valueHash is nil.
+func verifyProofForValueHash(proof SparseMerkleProof, root []byte, path []byte, valueHash []byte, th *treeHasher) (bool, [][][]byte) {
+	computed, updates, err := computeRootForValueHash(proof, path, valueHash, th)
+	if err != nil {
 		return false, nil
 	}
+	return bytes.Equal(computed, root), updates
+}
+
+// ComputeRootFromProof returns the root that a Merkle proof reconstructs for
+// value at key, as VerifyProof does before comparing it against the expected
+// root, to show how a failing proof diverges from it. ErrBadProof is returned
+// for a malformed proof, or a non-membership proof whose leaf cannot be on the
+// path of key, which reconstruct no root.
+func ComputeRootFromProof(proof SparseMerkleProof, key []byte, value []byte, hasher hash.Hash, options ...VerifyOption) ([]byte, error) {
+	config := newVerifyConfig(options)
+	th := config.treeHasher(hasher)
+	var valueHash []byte
+	if !bytes.Equal(value, config.defaultValue) {
+		valueHash = th.digestValue(value)
+	}
+	root, _, err := computeRootForValueHash(proof, th.path(key), valueHash, th)
+	return root, err
+}
+
+// computeRootForValueHash returns the root that a proof for valueHash at path
+// reconstructs, as verified by verifyProofForValueHash, with the hashes and
+// data of the nodes on the path.
+func computeRootForValueHash(proof SparseMerkleProof, path []byte, valueHash []byte, th *treeHasher) ([]byte, [][][]byte, error) {
+	if err := proof.check(th); err != nil {
+		return nil, nil, err
+	}
 
 	var updates [][][]byte
 
 	// Determine what the leaf hash should be.
 	var currentHash, currentData []byte
-	if bytes.Equal(value, defaultValue) { // Non-membership proof.
+	if valueHash == nil { // Non-membership proof.
 		if proof.NonMembershipLeafData == nil { // Leaf is a placeholder value.
 			currentHash = th.placeholder()
 		} else { // Leaf is an unrelated leaf.
 			actualPath, valueHash := th.parseLeaf(proof.NonMembershipLeafData)
 			if bytes.Equal(actualPath, path) {
 				// This is not an unrelated leaf; non-membership proof failed.
-				return false, nil
+				return nil, nil, fmt.Errorf("%w: leaf of the key proven absent", ErrProofLeafData)
+			}
+			if countCommonPrefix(actualPath, path) < len(proof.SideNodes) {
+				// The leaf cannot be on the path of the key, as the side nodes
+				// claim; non-membership proof failed.
+				return nil, nil, fmt.Errorf("%w: leaf not on the path of the key", ErrProofLeafData)
 			}
 			currentHash, currentData = th.digestLeaf(actualPath, valueHash)
 
@@ -131,7 +277,10 @@ func verifyProofWithUpdates(proof SparseMerkleProof, root []byte, key []byte, va
 			updates = append(updates, update)
 		}
 	} else { // Membership proof.
-		valueHash := th.digest(value)
+		if th.strict && proof.NonMembershipLeafData != nil {
+			// A canonical membership proof carries no other leaf.
+			return nil, nil, fmt.Errorf("%w: non-membership leaf data in a membership proof", ErrProofNotCanonical)
+		}
 		currentHash, currentData = th.digestLeaf(path, valueHash)
 		update := make([][]byte, 2)
 		update[0], update[1] = currentHash, currentData
@@ -140,7 +289,7 @@ func verifyProofWithUpdates(proof SparseMerkleProof, root []byte, key []byte, va
 
 	// Recompute root.
 	for i := 0; i < len(proof.SideNodes); i++ {
-		node := make([]byte, th.pathSize())
+		node := make([]byte, th.hashSize())
 		copy(node, proof.SideNodes[i])
 
 		if getBitAtFromMSB(path, len(proof.SideNodes)-1-i) == right {
@@ -154,30 +303,33 @@ func verifyProofWithUpdates(proof SparseMerkleProof, root []byte, key []byte, va
 		updates = append(updates, update)
 	}
 
-	return bytes.Equal(currentHash, root), updates
+	return currentHash, updates, nil
 }
 
 // VerifyCompactProof verifies a compacted Merkle proof.
-func VerifyCompactProof(proof SparseCompactMerkleProof, root []byte, key []byte, value []byte, hasher hash.Hash) bool {
-	decompactedProof, err := DecompactProof(proof, hasher)
+func VerifyCompactProof(proof SparseCompactMerkleProof, root []byte, key []byte, value []byte, hasher hash.Hash, options ...VerifyOption) bool {
+	decompactedProof, err := decompactProof(proof, newVerifyConfig(options).treeHasher(hasher))
 	if err != nil {
 		return false
 	}
-	return VerifyProof(decompactedProof, root, key, value, hasher)
+	return VerifyProof(decompactedProof, root, key, value, hasher, options...)
 }
 
-// CompactProof compacts a proof, to reduce its size.
-func CompactProof(proof SparseMerkleProof, hasher hash.Hash) (SparseCompactMerkleProof, error) {
-	th := newTreeHasher(hasher)
+// CompactProof compacts a proof, to reduce its size. The proof is checked for
+// the domain prefixes given by options, if any.
+func CompactProof(proof SparseMerkleProof, hasher hash.Hash, options ...VerifyOption) (SparseCompactMerkleProof, error) {
+	return compactProof(proof, newVerifyConfig(options).treeHasher(hasher))
+}
 
-	if !proof.sanityCheck(th) {
-		return SparseCompactMerkleProof{}, ErrBadProof
+func compactProof(proof SparseMerkleProof, th *treeHasher) (SparseCompactMerkleProof, error) {
+	if err := proof.check(th); err != nil {
+		return SparseCompactMerkleProof{}, err
 	}
 
 	bitMask := emptyBytes(int(math.Ceil(float64(len(proof.SideNodes)) / float64(8))))
 	var compactedSideNodes [][]byte
 	for i := 0; i < len(proof.SideNodes); i++ {
-		node := make([]byte, th.hasher.Size())
+		node := make([]byte, th.hashSize())
 		copy(node, proof.SideNodes[i])
 		if bytes.Equal(node, th.placeholder()) {
 			setBitAtFromMSB(bitMask, i)
@@ -192,15 +344,21 @@ func CompactProof(proof SparseMerkleProof, hasher hash.Hash) (SparseCompactMerkl
 		BitMask:               bitMask,
 		NumSideNodes:          len(proof.SideNodes),
 		SiblingData:           proof.SiblingData,
+		Depth:                 proof.Depth,
 	}, nil
 }
 
 // DecompactProof decompacts a proof, so that it can be used for VerifyProof.
-func DecompactProof(proof SparseCompactMerkleProof, hasher hash.Hash) (SparseMerkleProof, error) {
-	th := newTreeHasher(hasher)
+// The proof is checked as VerifyCompactProof checks it with options, if any,
+// so that e.g. a strict verifier rejects a compact proof carrying a
+// placeholder before decompacting it.
+func DecompactProof(proof SparseCompactMerkleProof, hasher hash.Hash, options ...VerifyOption) (SparseMerkleProof, error) {
+	return decompactProof(proof, newVerifyConfig(options).treeHasher(hasher))
+}
 
-	if !proof.sanityCheck(th) {
-		return SparseMerkleProof{}, ErrBadProof
+func decompactProof(proof SparseCompactMerkleProof, th *treeHasher) (SparseMerkleProof, error) {
+	if err := proof.check(th); err != nil {
+		return SparseMerkleProof{}, err
 	}
 
 	decompactedSideNodes := make([][]byte, proof.NumSideNodes)
@@ -218,5 +376,6 @@ func DecompactProof(proof SparseCompactMerkleProof, hasher hash.Hash) (SparseMer
 		SideNodes:             decompactedSideNodes,
 		NonMembershipLeafData: proof.NonMembershipLeafData,
 		SiblingData:           proof.SiblingData,
+		Depth:                 proof.Depth,
 	}, nil
 }
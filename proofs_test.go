@@ -3,8 +3,12 @@ package smt
 import (
 	"bytes"
 	"crypto/sha256"
+	"errors"
+	"fmt"
 	"hash"
+	"hash/fnv"
 	"math/rand"
+	"reflect"
 	"testing"
 )
 
@@ -279,3 +283,601 @@ func checkCompactEquivalence(t *testing.T, proof SparseMerkleProof, hasher hash.
 		t.Error("de-compacted proof does not match original proof")
 	}
 }
+
+// Test that proof size estimates match the sizes of generated proofs.
+func TestEstimateProofSize(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	size := func(sideNodes [][]byte, extra ...[]byte) int {
+		n := 0
+		for _, v := range sideNodes {
+			n += len(v)
+		}
+		for _, v := range extra {
+			n += len(v)
+		}
+		return n
+	}
+	check := func(key []byte) {
+		full, compact, err := smt.EstimateProofSize(key)
+		if err != nil {
+			t.Errorf("returned error when estimating proof size: %v", err)
+		}
+		proof, _ := smt.Prove(key)
+		if want := size(proof.SideNodes, proof.NonMembershipLeafData); full != want {
+			t.Errorf("estimated full proof size %d, expected %d", full, want)
+		}
+		compactProof, _ := smt.ProveCompact(key)
+		if want := size(compactProof.SideNodes, compactProof.NonMembershipLeafData, compactProof.BitMask); compact != want {
+			t.Errorf("estimated compact proof size %d, expected %d", compact, want)
+		}
+	}
+
+	check([]byte("testKey"))
+	for i := 0; i < 20; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte("testValue"))
+	}
+	for i := 0; i < 40; i++ {
+		check([]byte(fmt.Sprintf("testKey%d", i)))
+	}
+}
+
+// Test that proofs are bound to the path of the key they are verified for.
+func TestProofKeyMismatch(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 10; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte("testValue"))
+	}
+	proof, _ := smt.Prove([]byte("testKey1"))
+	if VerifyProof(proof, smt.Root(), []byte("testKey2"), []byte("testValue"), sha256.New()) {
+		t.Error("membership proof for one key verified for another")
+	}
+
+	// Forge a root with a leaf placed on the left although its path starts
+	// with a right bit, and try to prove that a key on the left is absent.
+	th := &smt.th
+	var leftKey, rightKey []byte
+	for i := 0; leftKey == nil || rightKey == nil; i++ {
+		key := []byte(fmt.Sprintf("forgedKey%d", i))
+		if getBitAtFromMSB(th.path(key), 0) == right {
+			rightKey = key
+		} else {
+			leftKey = key
+		}
+	}
+	leafHash, leafData := th.digestLeaf(th.path(rightKey), th.digest([]byte("testValue")))
+	forgedRoot, _ := th.digestNode(leafHash, th.placeholder())
+	forged := SparseMerkleProof{
+		SideNodes:             [][]byte{th.placeholder()},
+		NonMembershipLeafData: leafData,
+	}
+	if VerifyProof(forged, forgedRoot, leftKey, defaultValue, sha256.New()) {
+		t.Error("non-membership proof verified with a leaf off the key's path")
+	}
+}
+
+// Test that leaves and internal nodes are hashed with distinct prefixes, so
+// that neither can be passed off as the other.
+func TestProofDomainSeparation(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 100; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte("testValue"))
+	}
+	th := &smt.th
+
+	a, b := th.digest([]byte("a")), th.digest([]byte("b"))
+	leafHash, leafData := th.digestLeaf(a, b)
+	nodeHash, nodeData := th.digestNode(a, b)
+	if bytes.Equal(leafHash, nodeHash) || bytes.Equal(leafData, nodeData) {
+		t.Fatal("leaf and node with the same children hash alike")
+	}
+
+	// Take the root's left child, an internal node, and a key under it.
+	rootData, _ := smt.getNode(smt.Root())
+	leftHash, rightHash := th.parseNode(rootData)
+	leftData, _ := smt.getNode(leftHash)
+	if th.isLeaf(leftData) {
+		t.Fatal("root's left child is a leaf")
+	}
+	var key []byte
+	for i := 0; key == nil; i++ {
+		candidate := []byte(fmt.Sprintf("forgedKey%d", i))
+		if getBitAtFromMSB(th.path(candidate), 0) != right {
+			key = candidate
+		}
+	}
+
+	// A value whose hash is the internal node's hash does not make a leaf
+	// with that hash: prove it as a leaf in the node's place.
+	forged := SparseMerkleProof{SideNodes: [][]byte{rightHash}}
+	if VerifyProof(forged, smt.Root(), key, leftData, sha256.New()) {
+		t.Error("membership proof verified for a value mimicking an internal node")
+	}
+	if VerifyProofWithValueHash(forged, smt.Root(), key, leftHash, sha256.New()) {
+		t.Error("membership proof verified for a value hash mimicking an internal node")
+	}
+
+	// Nor does internal node data pass for the leaf of a non-membership
+	// proof, whatever path it is parsed as.
+	forged.NonMembershipLeafData = leftData
+	if VerifyProof(forged, smt.Root(), key, defaultValue, sha256.New()) {
+		t.Error("non-membership proof verified with internal node data as its leaf")
+	}
+
+	// Storing such a value gives it a leaf of its own.
+	smt.Update(key, leftData)
+	proof, _ := smt.Prove(key)
+	if !VerifyProof(proof, smt.Root(), key, leftData, sha256.New()) {
+		t.Error("proof for value mimicking an internal node failed to verify")
+	}
+	if len(proof.SideNodes) < 2 {
+		t.Error("value mimicking an internal node took the node's place")
+	}
+}
+
+func TestPresenceProofs(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 50; i++ {
+		smt.UpdatePresence([]byte(fmt.Sprintf("presentKey%d", i)))
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte("testValue"))
+	}
+
+	// Non-membership proofs hold presence leaves as their unrelated leaf.
+	found := false
+	for i := 0; i < 1000 && !found; i++ {
+		key := []byte(fmt.Sprintf("absentKey%d", i))
+		proof, err := smt.Prove(key)
+		if err != nil {
+			t.Fatalf("returned error when proving absent key: %v", err)
+		}
+		if proof.NonMembershipLeafData == nil || proof.NonMembershipLeafData[0] != presenceLeafPrefix[0] {
+			continue
+		}
+		found = true
+		if !VerifyProof(proof, smt.Root(), key, defaultValue, sha256.New()) {
+			t.Error("non-membership proof next to a presence leaf failed to verify")
+		}
+		if VerifyPresenceProof(proof, smt.Root(), key, sha256.New()) {
+			t.Error("non-membership proof verified as a presence proof")
+		}
+
+		// Presence leaf data padded to the length of a value leaf is rejected.
+		padded := proof
+		padded.NonMembershipLeafData = append(append([]byte{}, proof.NonMembershipLeafData...), make([]byte, sha256.Size)...)
+		if VerifyProof(padded, smt.Root(), key, defaultValue, sha256.New()) {
+			t.Error("non-membership proof verified with malformed presence leaf")
+		}
+	}
+	if !found {
+		t.Fatal("found no absent key next to a presence leaf")
+	}
+
+	// Compact proofs of presence verify once decompacted.
+	key := []byte("presentKey7")
+	compact, err := smt.ProveCompact(key)
+	if err != nil {
+		t.Fatalf("returned error when proving presence-only key: %v", err)
+	}
+	proof, err := DecompactProof(compact, sha256.New())
+	if err != nil {
+		t.Fatalf("returned error when decompacting proof: %v", err)
+	}
+	if !VerifyPresenceProof(proof, smt.Root(), key, sha256.New()) {
+		t.Error("decompacted presence proof failed to verify")
+	}
+}
+
+func TestDomainPrefixes(t *testing.T) {
+	const leaf, node = 0x10, 0x11
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithDomainPrefixes(leaf, node))
+
+	// Two keys on either side of the root.
+	left, right := []byte("testKey0"), []byte(nil)
+	leftPath := sha256.Sum256(left)
+	for i := 1; right == nil; i++ {
+		candidate := []byte(fmt.Sprintf("testKey%d", i))
+		path := sha256.Sum256(candidate)
+		if path[0]&0x80 != leftPath[0]&0x80 {
+			right = candidate
+		}
+	}
+	if leftPath[0]&0x80 != 0 {
+		left, right = right, left
+	}
+	smt.Update(left, []byte("leftValue"))
+	smt.Update(right, []byte("rightValue"))
+
+	leafHash := func(key, value []byte) []byte {
+		path, valueHash := sha256.Sum256(key), sha256.Sum256(value)
+		sum := sha256.Sum256(append(append([]byte{leaf}, path[:]...), valueHash[:]...))
+		return sum[:]
+	}
+	leftHash, rightHash := leafHash(left, []byte("leftValue")), leafHash(right, []byte("rightValue"))
+	root := sha256.Sum256(append(append([]byte{node}, leftHash...), rightHash...))
+	if !bytes.Equal(smt.Root(), root[:]) {
+		t.Fatalf("root %x does not match hand-computed root %x", smt.Root(), root)
+	}
+
+	proof, err := smt.Prove(left)
+	if err != nil {
+		t.Fatalf("returned error when proving key: %v", err)
+	}
+	if len(proof.SideNodes) != 1 || !bytes.Equal(proof.SideNodes[0], rightHash) {
+		t.Error("side node does not match hand-computed leaf hash")
+	}
+	if !VerifyProof(proof, root[:], left, []byte("leftValue"), sha256.New(), WithVerifyDomainPrefixes(leaf, node)) {
+		t.Error("proof failed to verify with the tree's prefixes")
+	}
+	if VerifyProof(proof, root[:], left, []byte("leftValue"), sha256.New()) {
+		t.Error("proof verified with the default prefixes")
+	}
+	compact, err := CompactProof(proof, sha256.New(), WithVerifyDomainPrefixes(leaf, node))
+	if err != nil {
+		t.Fatalf("returned error when compacting proof: %v", err)
+	}
+	if !VerifyCompactProof(compact, root[:], left, []byte("leftValue"), sha256.New(), WithVerifyDomainPrefixes(leaf, node)) {
+		t.Error("compact proof failed to verify with the tree's prefixes")
+	}
+
+	// Non-membership proofs carry leaf data with the tree's leaf prefix.
+	var absent []byte
+	for i := 0; absent == nil; i++ {
+		candidate := []byte(fmt.Sprintf("absentKey%d", i))
+		if proof, _ := smt.Prove(candidate); proof.NonMembershipLeafData != nil {
+			absent = candidate
+		}
+	}
+	proof, err = smt.Prove(absent)
+	if err != nil {
+		t.Fatalf("returned error when proving absent key: %v", err)
+	}
+	if proof.NonMembershipLeafData[0] != leaf {
+		t.Error("non-membership leaf data does not start with the leaf prefix")
+	}
+	if !VerifyProof(proof, root[:], absent, defaultValue, sha256.New(), WithVerifyDomainPrefixes(leaf, node)) {
+		t.Error("non-membership proof failed to verify with the tree's prefixes")
+	}
+	if VerifyProof(proof, root[:], absent, defaultValue, sha256.New()) {
+		t.Error("non-membership proof verified with the default prefixes")
+	}
+
+	// The defaults are the prefixes of a tree without the option.
+	defaults := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithDomainPrefixes(0, 1))
+	plain := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for _, tree := range []*SparseMerkleTree{defaults, plain} {
+		tree.Update(left, []byte("leftValue"))
+		tree.Update(right, []byte("rightValue"))
+	}
+	if !bytes.Equal(defaults.Root(), plain.Root()) {
+		t.Error("default prefixes do not give the roots of a tree without the option")
+	}
+	if bytes.Equal(plain.Root(), smt.Root()) {
+		t.Error("custom prefixes give the roots of the default prefixes")
+	}
+
+	expectPanic := func(name string, f func()) {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("did not panic for %s", name)
+			}
+		}()
+		f()
+	}
+	expectPanic("equal prefixes", func() { WithDomainPrefixes(leaf, leaf) })
+	expectPanic("compact node prefix", func() { WithDomainPrefixes(compactNodePrefix[0], node) })
+	expectPanic("presence leaf prefix", func() { WithVerifyDomainPrefixes(leaf, presenceLeafPrefix[0]) })
+}
+
+func TestProofDepth(t *testing.T) {
+	// A tree with 64-bit paths has depth 64.
+	shallow := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), fnv.New64a())
+	deep := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 20; i++ {
+		shallow.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte("testValue"))
+		deep.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte("testValue"))
+	}
+	key, value := []byte("testKey3"), []byte("testValue")
+
+	proof, err := shallow.Prove(key)
+	if err != nil {
+		t.Fatalf("returned error when proving key: %v", err)
+	}
+	if proof.Depth != 64 {
+		t.Errorf("proof records depth %d, expected 64", proof.Depth)
+	}
+	if !VerifyProof(proof, shallow.Root(), key, value, fnv.New64a()) {
+		t.Error("depth-64 proof failed to verify")
+	}
+	if !VerifyProof(proof, shallow.Root(), key, value, fnv.New64a(), WithVerifyDepth(64)) {
+		t.Error("depth-64 proof failed to verify at depth 64")
+	}
+	if VerifyProof(proof, shallow.Root(), key, value, fnv.New64a(), WithVerifyDepth(256)) {
+		t.Error("depth-64 proof verified at depth 256")
+	}
+	compact, _ := shallow.ProveCompact(key)
+	if compact.Depth != 64 {
+		t.Errorf("compact proof records depth %d, expected 64", compact.Depth)
+	}
+	if VerifyCompactProof(compact, shallow.Root(), key, value, fnv.New64a(), WithVerifyDepth(256)) {
+		t.Error("depth-64 compact proof verified at depth 256")
+	}
+
+	proof, err = deep.Prove(key)
+	if err != nil {
+		t.Fatalf("returned error when proving key: %v", err)
+	}
+	if VerifyProof(proof, deep.Root(), key, value, sha256.New(), WithVerifyDepth(64)) {
+		t.Error("depth-256 proof verified at depth 64")
+	}
+	// A depth that disagrees with the hasher's paths is rejected, while
+	// proofs without a depth are still accepted.
+	forged := proof
+	forged.Depth = 64
+	if VerifyProof(forged, deep.Root(), key, value, sha256.New()) {
+		t.Error("proof claiming depth 64 verified against a depth-256 tree")
+	}
+	forged.Depth = 0
+	if !VerifyProof(forged, deep.Root(), key, value, sha256.New()) {
+		t.Error("proof without a depth failed to verify")
+	}
+
+	// Side nodes beyond the bits of a path are rejected whatever the depth.
+	forged.SideNodes = make([][]byte, 257)
+	for i := range forged.SideNodes {
+		forged.SideNodes[i] = make([]byte, sha256.Size)
+	}
+	if VerifyProof(forged, deep.Root(), key, value, sha256.New(), WithVerifyDepth(512)) {
+		t.Error("proof with more side nodes than path bits verified")
+	}
+}
+
+func TestPathLength(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithPathBits(160))
+	batched := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithPathBits(160))
+	var keys, values [][]byte
+	for i := 0; i < 50; i++ {
+		key, value := []byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i))
+		if _, err := smt.Update(key, value); err != nil {
+			t.Fatalf("returned error when updating key: %v", err)
+		}
+		keys, values = append(keys, key), append(values, value)
+	}
+	if _, err := batched.UpdateBatch(keys, values); err != nil {
+		t.Fatalf("returned error when updating batch: %v", err)
+	}
+	if !bytes.Equal(smt.Root(), batched.Root()) {
+		t.Error("batch update of a tree with 160-bit paths gave another root")
+	}
+	if bits := smt.PathBits(keys[0]); len(bits) != 160 {
+		t.Errorf("key has a path of %d bits, expected 160", len(bits))
+	}
+
+	for i, key := range keys {
+		value, err := smt.Get(key)
+		if err != nil || !bytes.Equal(value, values[i]) {
+			t.Errorf("got %q, %v for key %s", value, err, key)
+		}
+		proof, err := smt.Prove(key)
+		if err != nil {
+			t.Fatalf("returned error when proving key: %v", err)
+		}
+		if proof.Depth != 160 {
+			t.Errorf("proof records depth %d, expected 160", proof.Depth)
+		}
+		if !VerifyProof(proof, smt.Root(), key, values[i], sha256.New(), WithVerifyDepth(160)) {
+			t.Error("proof of a tree with 160-bit paths failed to verify at depth 160")
+		}
+		if VerifyProof(proof, smt.Root(), key, values[i], sha256.New()) {
+			t.Error("proof of a tree with 160-bit paths verified at depth 256")
+		}
+		compact, _ := smt.ProveCompact(key)
+		if !VerifyCompactProof(compact, smt.Root(), key, values[i], sha256.New(), WithVerifyDepth(160)) {
+			t.Error("compact proof of a tree with 160-bit paths failed to verify at depth 160")
+		}
+	}
+	proof, _ := smt.Prove([]byte("absent"))
+	if !VerifyProof(proof, smt.Root(), []byte("absent"), defaultValue, sha256.New(), WithVerifyDepth(160)) {
+		t.Error("non-membership proof of a tree with 160-bit paths failed to verify")
+	}
+
+	for _, key := range keys {
+		if _, err := smt.Delete(key); err != nil {
+			t.Fatalf("returned error when deleting key: %v", err)
+		}
+	}
+	if !bytes.Equal(smt.Root(), smt.th.placeholder()) {
+		t.Error("tree with 160-bit paths not empty after deleting every key")
+	}
+}
+
+func TestProveExisting(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithRootRetention(1))
+	for i := 0; i < 20; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte("testValue"))
+	}
+	smt.UpdatePresence([]byte("presentKey"))
+
+	for _, key := range [][]byte{[]byte("testKey3"), []byte("presentKey")} {
+		proof, err := smt.ProveExisting(key)
+		if err != nil {
+			t.Fatalf("returned error when proving existing key: %v", err)
+		}
+		if proof == nil {
+			t.Fatalf("returned no proof for existing key %s", key)
+		}
+		expected, _ := smt.Prove(key)
+		if !reflect.DeepEqual(*proof, expected) {
+			t.Errorf("proof of existing key %s differs from Prove", key)
+		}
+	}
+
+	// Absent keys, whether under a placeholder or an unrelated leaf, have
+	// no proof.
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("absentKey%d", i))
+		proof, err := smt.ProveExisting(key)
+		if err != nil || proof != nil {
+			t.Fatalf("returned %v, %v for absent key, expected no proof", proof, err)
+		}
+	}
+	empty := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	if proof, err := empty.ProveExisting([]byte("testKey")); err != nil || proof != nil {
+		t.Errorf("returned %v, %v for key of empty tree, expected no proof", proof, err)
+	}
+
+	root := smt.Root()
+	smt.Delete([]byte("testKey3"))
+	if proof, err := smt.ProveExisting([]byte("testKey3")); err != nil || proof != nil {
+		t.Errorf("returned %v, %v for deleted key, expected no proof", proof, err)
+	}
+	if proof, err := smt.ProveExistingForRoot([]byte("testKey4"), root); err != nil || proof == nil {
+		t.Errorf("returned %v, %v for key at earlier root, expected a proof", proof, err)
+	}
+}
+
+func TestComputeRootFromProof(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 20; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	for _, key := range []string{"testKey3", "absentKey"} {
+		value, _ := smt.Get([]byte(key))
+		proof, _ := smt.Prove([]byte(key))
+		root, err := ComputeRootFromProof(proof, []byte(key), value, sha256.New())
+		if err != nil {
+			t.Fatalf("returned error when computing root from proof of %s: %v", key, err)
+		}
+		if !bytes.Equal(root, smt.Root()) {
+			t.Errorf("root computed from proof of %s differs from the tree's", key)
+		}
+		if root, _ := ComputeRootFromProof(proof, []byte(key), []byte("wrongValue"), sha256.New()); bytes.Equal(root, smt.Root()) {
+			t.Errorf("root computed from proof of %s for wrong value equals the tree's", key)
+		}
+	}
+
+	proof, _ := smt.Prove([]byte("testKey3"))
+	proof.SideNodes = append(proof.SideNodes, []byte("short"))
+	if _, err := ComputeRootFromProof(proof, []byte("testKey3"), []byte("testValue3"), sha256.New()); !errors.Is(err, ErrBadProof) {
+		t.Errorf("did not return ErrBadProof for malformed proof, got %v", err)
+	}
+}
+
+func TestStrictVerify(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 20; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	for _, key := range []string{"testKey3", "absentKey"} {
+		value, _ := smt.Get([]byte(key))
+		proof, _ := smt.Prove([]byte(key))
+		if !VerifyProof(proof, smt.Root(), []byte(key), value, sha256.New(), WithStrictVerify()) {
+			t.Errorf("canonical proof of %s failed strict verification", key)
+		}
+		compact, _ := smt.ProveCompact([]byte(key))
+		if !VerifyCompactProof(compact, smt.Root(), []byte(key), value, sha256.New(), WithStrictVerify()) {
+			t.Errorf("canonical compact proof of %s failed strict verification", key)
+		}
+	}
+
+	// A tree of one leaf beside an empty subtree, which the tree never
+	// builds, proves the leaf with a default nearest side node.
+	th := newTreeHasher(sha256.New())
+	key, value := []byte("testKey"), []byte("testValue")
+	path := th.path(key)
+	leafHash, _ := th.digestLeaf(path, th.digest(value))
+	var root []byte
+	if getBitAtFromMSB(path, 0) == right {
+		root, _ = th.digestNode(th.placeholder(), leafHash)
+	} else {
+		root, _ = th.digestNode(leafHash, th.placeholder())
+	}
+	proof := SparseMerkleProof{SideNodes: [][]byte{th.placeholder()}}
+	if !VerifyProof(proof, root, key, value, sha256.New()) {
+		t.Fatal("non-canonical proof failed to verify")
+	}
+	if VerifyProof(proof, root, key, value, sha256.New(), WithStrictVerify()) {
+		t.Error("non-canonical proof passed strict verification")
+	}
+
+	// A compact proof carrying a placeholder instead of marking it.
+	compact := SparseCompactMerkleProof{SideNodes: [][]byte{th.placeholder()}, BitMask: []byte{0}, NumSideNodes: 1}
+	if !VerifyCompactProof(compact, root, key, value, sha256.New()) {
+		t.Fatal("non-canonical compact proof failed to verify")
+	}
+	if VerifyCompactProof(compact, root, key, value, sha256.New(), WithStrictVerify()) {
+		t.Error("non-canonical compact proof passed strict verification")
+	}
+	if _, err := DecompactProof(compact, sha256.New(), WithStrictVerify()); !errors.Is(err, ErrBadProof) {
+		t.Errorf("decompacted non-canonical compact proof in strict mode, got %v", err)
+	}
+}
+
+func TestVerifyNotValue(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+
+	proof, _ := smt.Prove([]byte("testKey"))
+	if !VerifyNotValue(proof, smt.Root(), []byte("testKey"), []byte("testValue"), []byte("claimedValue"), sha256.New()) {
+		t.Error("did not refute a differing claimed value")
+	}
+	if VerifyNotValue(proof, smt.Root(), []byte("testKey"), []byte("testValue"), []byte("testValue"), sha256.New()) {
+		t.Error("refuted the stored value")
+	}
+	if VerifyNotValue(proof, smt.Root(), []byte("testKey"), []byte("otherValue"), []byte("claimedValue"), sha256.New()) {
+		t.Error("refuted a claim with a value the proof is not for")
+	}
+	if VerifyNotValue(proof, smt.Root(), []byte("testKey2"), []byte("testValue"), []byte("claimedValue"), sha256.New()) {
+		t.Error("refuted a claim with the proof of another key")
+	}
+
+	// A non-membership proof refutes any claim of a value.
+	proof, _ = smt.Prove([]byte("testKey3"))
+	if !VerifyNotValue(proof, smt.Root(), []byte("testKey3"), defaultValue, []byte("claimedValue"), sha256.New()) {
+		t.Error("did not refute a claimed value for an absent key")
+	}
+	if VerifyNotValue(proof, smt.Root(), []byte("testKey3"), defaultValue, nil, sha256.New()) {
+		t.Error("refuted the default value for an absent key")
+	}
+}
+
+func TestGetWithProof(t *testing.T) {
+	nodes := &countingStore{MapStore: NewSimpleMap()}
+	smt := NewSparseMerkleTree(nodes, NewSimpleMap(), sha256.New())
+	if value, proof, err := smt.GetWithProof([]byte("testKey")); err != nil || !bytes.Equal(value, defaultValue) || !VerifyProof(proof, smt.Root(), []byte("testKey"), value, sha256.New()) {
+		t.Errorf("got %q, %v for a key of an empty tree", value, err)
+	}
+	for i := 0; i < 50; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	smt.UpdatePresence([]byte("presentKey"))
+
+	for i := 0; i < 60; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		nodes.gets = 0
+		value, proof, err := smt.GetWithProof(key)
+		if err != nil {
+			t.Fatalf("returned error when getting with proof: %v", err)
+		}
+		gets := nodes.gets
+		nodes.gets = 0
+		wantProof, _ := smt.Prove(key)
+		if gets != nodes.gets {
+			t.Errorf("read %d nodes for %s, where Prove alone reads %d", gets, key, nodes.gets)
+		}
+		want, _ := smt.Get(key)
+		if !bytes.Equal(value, want) || !reflect.DeepEqual(proof, wantProof) {
+			t.Errorf("got %q and another proof for %s than Get and Prove", value, key)
+		}
+		if !VerifyProof(proof, smt.Root(), key, value, sha256.New()) {
+			t.Errorf("proof for %s failed to verify", key)
+		}
+	}
+
+	value, proof, err := smt.GetWithProof([]byte("presentKey"))
+	if err != nil || !bytes.Equal(value, defaultValue) {
+		t.Errorf("got %q, %v for a presence key", value, err)
+	}
+	if !VerifyPresenceProof(proof, smt.Root(), []byte("presentKey"), sha256.New()) {
+		t.Error("proof for a presence key failed to verify")
+	}
+}
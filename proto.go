@@ -0,0 +1,432 @@
+package smt
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// The protocol buffer encodings of proofs and exported tries are those of the
+// messages of proto/smt.proto, for light clients and other consumers outside
+// Go. Fields are written in field number order, and fields at their default
+// value are left out, as protobuf encoders do; decoding skips unknown fields.
+// Package smtpb holds the generated Go bindings of the messages, and
+// converts them to and from these types.
+
+// Field numbers of the SparseMerkleProof message.
+const (
+	protoProofSideNodes protoNumber = 1 + iota
+	protoProofNonMembershipLeafData
+	protoProofSiblingData
+	protoProofDepth
+)
+
+// Field numbers of the SparseCompactMerkleProof message.
+const (
+	protoCompactSideNodes protoNumber = 1 + iota
+	protoCompactNonMembershipLeafData
+	protoCompactBitMask
+	protoCompactNumSideNodes
+	protoCompactSiblingData
+	protoCompactDepth
+)
+
+// Field numbers of the TrieWrap and Entry messages.
+const (
+	protoTrieRoot protoNumber = 1 + iota
+	protoTrieNodes
+	protoTrieValues
+	protoTrieSchemaVersion
+	protoTrieHash
+	protoTrieDepth
+
+	protoEntryKey   protoNumber = 1
+	protoEntryValue protoNumber = 2
+)
+
+// ToProto encodes the proof as a SparseMerkleProof message.
+func (proof SparseMerkleProof) ToProto() []byte {
+	var b []byte
+	for _, sideNode := range proof.SideNodes {
+		b = appendProtoBytes(b, protoProofSideNodes, sideNode, true)
+	}
+	b = appendProtoBytes(b, protoProofNonMembershipLeafData, proof.NonMembershipLeafData, false)
+	b = appendProtoBytes(b, protoProofSiblingData, proof.SiblingData, false)
+	return appendProtoUint(b, protoProofDepth, uint64(proof.Depth))
+}
+
+// FromProto decodes a SparseMerkleProof message into the proof, returning
+// ErrBadProof if it is malformed. The proof is only decoded, not checked; see
+// VerifyProof.
+func (proof *SparseMerkleProof) FromProto(data []byte) error {
+	var p SparseMerkleProof
+	err := readProto(data, func(num protoNumber, field protoField) error {
+		switch num {
+		case protoProofSideNodes:
+			v, err := field.asBytes()
+			p.SideNodes = append(p.SideNodes, v)
+			return err
+		case protoProofNonMembershipLeafData:
+			v, err := field.asBytes()
+			p.NonMembershipLeafData = emptyToNil(v)
+			return err
+		case protoProofSiblingData:
+			v, err := field.asBytes()
+			p.SiblingData = emptyToNil(v)
+			return err
+		case protoProofDepth:
+			v, err := field.asInt()
+			p.Depth = v
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadProof, err)
+	}
+	*proof = p
+	return nil
+}
+
+// ToProto encodes the proof as a SparseCompactMerkleProof message.
+func (proof SparseCompactMerkleProof) ToProto() []byte {
+	var b []byte
+	for _, sideNode := range proof.SideNodes {
+		b = appendProtoBytes(b, protoCompactSideNodes, sideNode, true)
+	}
+	b = appendProtoBytes(b, protoCompactNonMembershipLeafData, proof.NonMembershipLeafData, false)
+	b = appendProtoBytes(b, protoCompactBitMask, proof.BitMask, false)
+	b = appendProtoUint(b, protoCompactNumSideNodes, uint64(proof.NumSideNodes))
+	b = appendProtoBytes(b, protoCompactSiblingData, proof.SiblingData, false)
+	return appendProtoUint(b, protoCompactDepth, uint64(proof.Depth))
+}
+
+// FromProto decodes a SparseCompactMerkleProof message into the proof,
+// returning ErrBadProof if it is malformed.
+func (proof *SparseCompactMerkleProof) FromProto(data []byte) error {
+	var p SparseCompactMerkleProof
+	err := readProto(data, func(num protoNumber, field protoField) error {
+		switch num {
+		case protoCompactSideNodes:
+			v, err := field.asBytes()
+			p.SideNodes = append(p.SideNodes, v)
+			return err
+		case protoCompactNonMembershipLeafData:
+			v, err := field.asBytes()
+			p.NonMembershipLeafData = emptyToNil(v)
+			return err
+		case protoCompactBitMask:
+			v, err := field.asBytes()
+			p.BitMask = emptyToNil(v)
+			return err
+		case protoCompactNumSideNodes:
+			v, err := field.asInt()
+			p.NumSideNodes = v
+			return err
+		case protoCompactSiblingData:
+			v, err := field.asBytes()
+			p.SiblingData = emptyToNil(v)
+			return err
+		case protoCompactDepth:
+			v, err := field.asInt()
+			p.Depth = v
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadProof, err)
+	}
+	*proof = p
+	return nil
+}
+
+// ToProto encodes the exported trie as a TrieWrap message, with the entries
+// of its node and value snapshots in key order.
+func (wrap *TrieWrap) ToProto() ([]byte, error) {
+	b := appendProtoBytes(nil, protoTrieRoot, wrap.Root, false)
+	for _, store := range []struct {
+		num    protoNumber
+		serial []byte
+	}{{protoTrieNodes, wrap.NodesBytes}, {protoTrieValues, wrap.ValuesBytes}} {
+		var m map[string][]byte
+		if err := decodeSnapshot(store.serial, &m); err != nil {
+			return nil, err
+		}
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			entry := appendProtoBytes(nil, protoEntryKey, []byte(key), false)
+			entry = appendProtoBytes(entry, protoEntryValue, m[key], false)
+			b = appendProtoBytes(b, store.num, entry, true)
+		}
+	}
+	b = appendProtoUint(b, protoTrieSchemaVersion, uint64(wrap.SchemaVersion))
+	b = appendProtoBytes(b, protoTrieHash, []byte(wrap.Hash), false)
+	return appendProtoUint(b, protoTrieDepth, uint64(wrap.Depth)), nil
+}
+
+// FromProto decodes a TrieWrap message into wrap, to be imported with
+// ImportTrie, returning ErrSnapshotCorrupt if it is malformed.
+func (wrap *TrieWrap) FromProto(data []byte) error {
+	var w TrieWrap
+	nodes, values := make(map[string][]byte), make(map[string][]byte)
+	err := readProto(data, func(num protoNumber, field protoField) error {
+		switch num {
+		case protoTrieRoot:
+			v, err := field.asBytes()
+			w.Root = emptyToNil(v)
+			return err
+		case protoTrieNodes:
+			v, err := field.asBytes()
+			if err != nil {
+				return err
+			}
+			return readProtoEntry(v, nodes)
+		case protoTrieValues:
+			v, err := field.asBytes()
+			if err != nil {
+				return err
+			}
+			return readProtoEntry(v, values)
+		case protoTrieSchemaVersion:
+			v, err := field.asInt()
+			w.SchemaVersion = v
+			return err
+		case protoTrieHash:
+			v, err := field.asBytes()
+			w.Hash = string(v)
+			return err
+		case protoTrieDepth:
+			v, err := field.asInt()
+			w.Depth = v
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSnapshotCorrupt, err)
+	}
+	if w.NodesBytes, err = encodeSnapshot(nodes); err != nil {
+		return err
+	}
+	if w.ValuesBytes, err = encodeSnapshot(values); err != nil {
+		return err
+	}
+	*wrap = w
+	return nil
+}
+
+// protoNumber is the number of a field of a message.
+type protoNumber uint64
+
+// protoType is the wire type of a field.
+type protoType uint64
+
+// Wire types of fields. Messages of proto/smt.proto only have varint and
+// length-delimited fields; fields of the other types are skipped as unknown
+// fields.
+const (
+	protoVarint     protoType = 0
+	protoFixed64    protoType = 1
+	protoBytes      protoType = 2
+	protoStartGroup protoType = 3
+	protoEndGroup   protoType = 4
+	protoFixed32    protoType = 5
+)
+
+// protoMaxNumber is the largest valid field number.
+const protoMaxNumber = 1<<29 - 1
+
+// protoMaxGroupDepth is the deepest nesting of groups skipped, as that of the
+// protobuf runtime.
+const protoMaxGroupDepth = 10000
+
+// errProtoType is returned for a field of a message whose wire type is not
+// that of its field number.
+var errProtoType = errors.New("field of the wrong wire type")
+
+// errProtoMalformed is returned for a message that ends within a field or has
+// a varint longer than 64 bits.
+var errProtoMalformed = errors.New("malformed field")
+
+// protoField is a field of a message being decoded.
+type protoField struct {
+	typ   protoType
+	value []byte
+	n     uint64
+}
+
+// asBytes returns a copy of the value of a length-delimited field.
+func (f protoField) asBytes() ([]byte, error) {
+	if f.typ != protoBytes {
+		return nil, errProtoType
+	}
+	return append([]byte{}, f.value...), nil
+}
+
+// asInt returns the value of a varint field, which must fit in an int.
+func (f protoField) asInt() (int, error) {
+	if f.typ != protoVarint {
+		return 0, errProtoType
+	}
+	if f.n > math.MaxInt32 {
+		return 0, fmt.Errorf("integer %d out of range", f.n)
+	}
+	return int(f.n), nil
+}
+
+// readProto calls field with each field of the message in data, in order,
+// stopping at the first error.
+func readProto(data []byte, field func(num protoNumber, field protoField) error) error {
+	for len(data) > 0 {
+		num, typ, n, err := readProtoTag(data)
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+		if n, err = skipProtoField(num, typ, data, 0); err != nil {
+			return err
+		}
+		f := protoField{typ: typ}
+		switch typ {
+		case protoVarint:
+			f.n, _ = binary.Uvarint(data)
+		case protoBytes:
+			_, m := binary.Uvarint(data)
+			f.value = data[m:n]
+		}
+		data = data[n:]
+		if err := field(num, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readProtoTag decodes the tag of the field at the start of data, returning
+// its number and wire type and the length of the tag.
+func readProtoTag(data []byte) (protoNumber, protoType, int, error) {
+	tag, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, 0, 0, errProtoMalformed
+	}
+	num, typ := protoNumber(tag>>3), protoType(tag&7)
+	if num == 0 || num > protoMaxNumber {
+		return 0, 0, 0, fmt.Errorf("invalid field number %d", num)
+	}
+	return num, typ, n, nil
+}
+
+// skipProtoField returns the length of the value of a field of the given
+// number and wire type at the start of data, which for a group is all of its
+// fields and the tag ending it.
+func skipProtoField(num protoNumber, typ protoType, data []byte, depth int) (int, error) {
+	var n int
+	switch typ {
+	case protoVarint:
+		if _, n = binary.Uvarint(data); n <= 0 {
+			return 0, errProtoMalformed
+		}
+	case protoBytes:
+		length, m := binary.Uvarint(data)
+		if m <= 0 || length > uint64(len(data)-m) {
+			return 0, errProtoMalformed
+		}
+		n = m + int(length)
+	case protoFixed64:
+		n = 8
+	case protoFixed32:
+		n = 4
+	case protoStartGroup:
+		if depth == protoMaxGroupDepth {
+			return 0, errors.New("groups nested too deeply")
+		}
+		for {
+			fieldNum, fieldType, m, err := readProtoTag(data[n:])
+			if err != nil {
+				return 0, err
+			}
+			n += m
+			if fieldType == protoEndGroup {
+				if fieldNum != num {
+					return 0, fmt.Errorf("group %d ended by field %d", num, fieldNum)
+				}
+				return n, nil
+			}
+			if m, err = skipProtoField(fieldNum, fieldType, data[n:], depth+1); err != nil {
+				return 0, err
+			}
+			n += m
+		}
+	default:
+		return 0, fmt.Errorf("unexpected wire type %d", typ)
+	}
+	if n > len(data) {
+		return 0, errProtoMalformed
+	}
+	return n, nil
+}
+
+// readProtoEntry decodes an Entry message into m.
+func readProtoEntry(data []byte, m map[string][]byte) error {
+	var key, value []byte
+	err := readProto(data, func(num protoNumber, field protoField) error {
+		var err error
+		switch num {
+		case protoEntryKey:
+			key, err = field.asBytes()
+		case protoEntryValue:
+			value, err = field.asBytes()
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if _, ok := m[string(key)]; ok {
+		return fmt.Errorf("duplicate key %x", key)
+	}
+	m[string(key)] = value
+	return nil
+}
+
+// appendProtoBytes appends a length-delimited field, unless it is empty and
+// not always written, as the elements of repeated fields are.
+func appendProtoBytes(b []byte, num protoNumber, v []byte, always bool) []byte {
+	if len(v) == 0 && !always {
+		return b
+	}
+	b = appendProtoVarint(b, uint64(num)<<3|uint64(protoBytes))
+	b = appendProtoVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// appendProtoUint appends a varint field, unless it is zero.
+func appendProtoUint(b []byte, num protoNumber, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendProtoVarint(b, uint64(num)<<3|uint64(protoVarint))
+	return appendProtoVarint(b, v)
+}
+
+// appendProtoVarint appends v as a varint.
+func appendProtoVarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// emptyToNil returns nil for an empty slice, as proto3 does not tell an
+// empty bytes field from an unset one.
+func emptyToNil(v []byte) []byte {
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
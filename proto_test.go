@@ -0,0 +1,96 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestProofProto(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 20; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	membership, _ := smt.ProveUpdatable([]byte("testKey3"))
+	nonMembership, _ := smt.Prove([]byte("otherKey"))
+
+	for _, proof := range []SparseMerkleProof{membership, nonMembership} {
+		data := proof.ToProto()
+		var decoded SparseMerkleProof
+		if err := decoded.FromProto(data); err != nil {
+			t.Fatalf("returned error when decoding proof: %v", err)
+		}
+		if !reflect.DeepEqual(decoded, proof) {
+			t.Errorf("decoded proof differs, got %+v, want %+v", decoded, proof)
+		}
+	}
+	var decoded SparseMerkleProof
+	decoded.FromProto(nonMembership.ToProto())
+	if !VerifyProof(decoded, smt.Root(), []byte("otherKey"), defaultValue, sha256.New()) {
+		t.Error("decoded proof does not verify")
+	}
+
+	compact, _ := CompactProof(membership, sha256.New())
+	data := compact.ToProto()
+	var decodedCompact SparseCompactMerkleProof
+	if err := decodedCompact.FromProto(data); err != nil {
+		t.Fatalf("returned error when decoding compact proof: %v", err)
+	}
+	if !VerifyCompactProof(decodedCompact, smt.Root(), []byte("testKey3"), []byte("testValue3"), sha256.New()) {
+		t.Error("decoded compact proof does not verify")
+	}
+
+	// Unknown fields are skipped.
+	extended := append(membership.ToProto(), 0x78, 0x01, 0x7b, 0x08, 0x01, 0x7c)
+	if err := decoded.FromProto(extended); err != nil || !reflect.DeepEqual(decoded, membership) {
+		t.Errorf("did not skip unknown field, got %v", err)
+	}
+	for name, data := range map[string][]byte{
+		"truncated":       data[:len(data)-1],
+		"wrong wire type": {0x08, 0x01},
+		"open group":      {0x7b, 0x08, 0x01},
+		"depth too large": {0x20, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f},
+	} {
+		if err := decoded.FromProto(data); !errors.Is(err, ErrBadProof) {
+			t.Errorf("%s: did not return ErrBadProof, got %v", name, err)
+		}
+	}
+}
+
+func TestTrieWrapProto(t *testing.T) {
+	trie := NewMerkleTrie()
+	for i := 0; i < 20; i++ {
+		trie.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	wrap, _ := ExportTrie(trie)
+	data, err := wrap.ToProto()
+	if err != nil {
+		t.Fatalf("returned error when encoding trie: %v", err)
+	}
+
+	var decoded TrieWrap
+	if err := decoded.FromProto(data); err != nil {
+		t.Fatalf("returned error when decoding trie: %v", err)
+	}
+	if !reflect.DeepEqual(&decoded, wrap) {
+		t.Error("decoded trie differs")
+	}
+	imported, err := ImportTrie(&decoded)
+	if err != nil {
+		t.Fatalf("returned error when importing trie: %v", err)
+	}
+	if value, _ := imported.Get([]byte("testKey7")); !bytes.Equal(value, []byte("testValue7")) {
+		t.Error("imported trie lost a value")
+	}
+
+	duplicate := append(append([]byte{}, data...), data...)
+	if err := decoded.FromProto(duplicate); !errors.Is(err, ErrSnapshotCorrupt) {
+		t.Errorf("did not return ErrSnapshotCorrupt for duplicate entries, got %v", err)
+	}
+	if err := decoded.FromProto(data[:len(data)-1]); !errors.Is(err, ErrSnapshotCorrupt) {
+		t.Errorf("did not return ErrSnapshotCorrupt for a truncated trie, got %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+)
+
+// Prune deletes the nodes of the node store that are unreachable from the
+// current root, the roots retained with WithRootRetention, and keepRoots,
+// returning how many it deleted. Updates already prune the nodes they
+// orphan, but a store can still hold unreachable nodes, e.g. those of roots
+// that an application kept by other means and no longer needs, those of an
+// update interrupted before its pruning without a write-ahead log, or those
+// of a store imported along with stale nodes. Nodes are marked from every
+// kept root and swept by listing the store with Export, so a node shared by
+// several roots is kept as long as any of them is, without per-node
+// reference counts. Every kept root must be intact: a node missing under one
+// fails the prune before anything is deleted. In archive mode, every version
+// is kept too.
+//
+// Updates are blocked while the tree is pruned. Snapshots and clones keep
+// reading the nodes deleted, as they do those deleted by updates. See
+// SetOperationLimit, which bounds the traversal of the current root.
+func (smt *SparseMerkleTree) Prune(keepRoots [][]byte, options ...TraversalOption) (int, error) {
+	defer smt.writeLock()()
+	if err := smt.checkOperationLimit(smt.root, options); err != nil {
+		return 0, err
+	}
+
+	if smt.archive != nil {
+		versions, err := smt.versionRoots()
+		if err != nil {
+			return 0, err
+		}
+		keepRoots = append(versions, keepRoots...)
+	}
+	reachable := make(map[string]bool)
+	for _, root := range append(append([][]byte{smt.root}, smt.retainedRoots()...), keepRoots...) {
+		if err := smt.markReachable(root, reachable); err != nil {
+			return 0, err
+		}
+	}
+	data, err := smt.nodes.Export()
+	if err != nil {
+		return 0, err
+	}
+	var stored map[string][]byte
+	if err := decodeSnapshot(data, &stored); err != nil {
+		return 0, err
+	}
+	pruned := 0
+	for hash := range stored {
+		if reachable[hash] {
+			continue
+		}
+		if smt.retention != nil {
+			// Not to be deleted again once its root expires.
+			delete(smt.retention.pending, hash)
+		}
+		if err := smt.deleteNode([]byte(hash)); err != nil {
+			return pruned, err
+		}
+		pruned++
+	}
+	smt.debugf("pruned %d unreachable nodes, keeping %d", pruned, len(reachable))
+	return pruned, nil
+}
+
+// markReachable marks the nodes under hash as reachable, not descending into
+// subtrees already marked, which are shared with a root marked before.
+func (smt *SparseMerkleTree) markReachable(hash []byte, reachable map[string]bool) error {
+	if bytes.Equal(hash, smt.th.placeholder()) || reachable[string(hash)] {
+		return nil
+	}
+	data, err := smt.getNode(hash)
+	if err != nil {
+		return err
+	}
+	reachable[string(hash)] = true
+	if smt.th.isLeaf(data) {
+		return nil
+	}
+	leftNode, rightNode := smt.th.parseNode(data)
+	if err := smt.markReachable(leftNode, reachable); err != nil {
+		return err
+	}
+	return smt.markReachable(rightNode, reachable)
+}
+
+// CompactToRoot copies the nodes reachable from root, the values of their
+// leaves and, if keys is not nil, the keys of those leaves from the stores of
+// the tree into the given stores, which should be empty, returning the number
+// of nodes copied. Unlike Prune, it leaves the stores of the tree as they
+// are, producing a minimal copy of a long-lived store, dominated by the nodes
+// of earlier roots, for state sync or archival; open it with
+// ImportSparseMerkleTree at root. Entries are copied as stored, so the copy
+// has the node and value encodings of the tree.
+//
+// The values of a root other than the current one are those retained with
+// WithRootRetention. Leaves whose value is not stored, such as those set with
+// UpdateLeafHash or those of a root that is not retained and whose values
+// were since replaced, are copied without one. Updates are blocked while the
+// nodes are copied; see SetOperationLimit.
+func (smt *SparseMerkleTree) CompactToRoot(root []byte, nodes, values, keys MapStore, options ...TraversalOption) (int, error) {
+	checkStores(nodes, values)
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	if err := smt.checkOperationLimit(root, options); err != nil {
+		return 0, err
+	}
+	index, err := smt.retainedIndex(root)
+	if errors.Is(err, ErrRootPruned) {
+		// Only the values still current can be copied.
+		index = len(smt.retainedRoots())
+	}
+
+	copied := 0
+	err = smt.walk(root, func(_ []bool, hash []byte, data []byte) error {
+		stored := data
+		if smt.compactNodes {
+			stored = smt.th.encodeNode(data)
+		}
+		if err := nodes.Set(hash, stored); err != nil {
+			return err
+		}
+		copied++
+		if !smt.th.isLeaf(data) {
+			return nil
+		}
+		path, valueHash := smt.th.parseLeaf(data)
+		if keys != nil {
+			key, err := smt.keyAtIndex(path, index)
+			if err != nil {
+				return err
+			}
+			if key != nil {
+				if err := keys.Set(path, key); err != nil {
+					return err
+				}
+			}
+		}
+		if len(valueHash) == 0 {
+			return nil
+		}
+		value, err := smt.storedValueAtIndex(path, index)
+		if err != nil || value == nil {
+			return err
+		}
+		decoded := value
+		if smt.decodeValue != nil {
+			if decoded, err = smt.decodeValue(value); err != nil {
+				return err
+			}
+		}
+		if !bytes.Equal(smt.th.digestValue(decoded), valueHash) {
+			return nil
+		}
+		return values.Set(path, value)
+	})
+	if err != nil {
+		return copied, err
+	}
+	smt.debugf("compacted %d nodes at root %x", copied, root)
+	return copied, nil
+}
@@ -0,0 +1,139 @@
+package smt
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func TestPrune(t *testing.T) {
+	nodes := NewSimpleMap()
+	smt := NewSparseMerkleTree(nodes, NewSimpleMap(), sha256.New(), WithRootRetention(2))
+	for i := 0; i < 50; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	// A second tree on the same node store, whose root the first does not
+	// know of, and stale nodes left in the store.
+	other := NewSparseMerkleTree(nodes, NewSimpleMap(), sha256.New())
+	for i := 0; i < 10; i++ {
+		other.Update([]byte(fmt.Sprintf("otherKey%d", i)), []byte(fmt.Sprintf("otherValue%d", i)))
+	}
+	for i := 0; i < 5; i++ {
+		hash, data := smt.th.digestLeaf(smt.th.path([]byte(fmt.Sprintf("staleKey%d", i))), smt.th.digest([]byte("staleValue")))
+		nodes.Set(hash, data)
+	}
+	countNodes := func(root []byte) int {
+		n := 0
+		smt.walk(root, func(_ []bool, _ []byte, _ []byte) error {
+			n++
+			return nil
+		})
+		return n
+	}
+	otherNodes := countNodes(other.Root())
+
+	// Keeping the second tree's root deletes only the stale nodes.
+	total := len(nodes.m)
+	pruned, err := smt.Prune([][]byte{other.Root()})
+	if err != nil {
+		t.Fatalf("returned error when pruning: %v", err)
+	}
+	if pruned != 5 || len(nodes.m) != total-5 {
+		t.Errorf("pruned %d nodes, expected 5", pruned)
+	}
+	for _, root := range smt.RetainedRoots() {
+		if _, err := smt.ProveForRoot([]byte("testKey1"), root); err != nil {
+			t.Errorf("returned error when proving against retained root: %v", err)
+		}
+	}
+	if value, err := other.Get([]byte("otherKey3")); err != nil || !bytes.Equal(value, []byte("otherValue3")) {
+		t.Errorf("did not keep nodes of kept root, got %s, %v", value, err)
+	}
+
+	// Not keeping it deletes its nodes.
+	if pruned, err := smt.Prune(nil); err != nil || pruned != otherNodes {
+		t.Errorf("pruned %d nodes, expected %d: %v", pruned, otherNodes, err)
+	}
+	// Expiring retained roots afterwards still prunes their orphaned nodes.
+	for i := 0; i < 10; i++ {
+		if _, err := smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte("newValue")); err != nil {
+			t.Fatalf("returned error when updating after prune: %v", err)
+		}
+	}
+	reachable := countNodes(smt.Root())
+	for _, root := range smt.RetainedRoots() {
+		reachable += countNodes(root)
+	}
+	if pruned, err := smt.Prune(nil); err != nil || pruned != 0 {
+		t.Errorf("pruned %d nodes of a clean store: %v", pruned, err)
+	}
+	if len(nodes.m) > reachable {
+		t.Errorf("store holds %d nodes, at most %d reachable", len(nodes.m), reachable)
+	}
+
+	// A kept root missing nodes fails the prune before deleting anything.
+	total = len(nodes.m)
+	nodes.Set([]byte("stale"), []byte("data"))
+	missing := make([]byte, sha256.Size)
+	missing[0] = 1
+	if _, err := smt.Prune([][]byte{missing}); err == nil {
+		t.Error("did not return error for missing root")
+	}
+	if len(nodes.m) != total+1 {
+		t.Error("failed prune deleted nodes")
+	}
+}
+
+func TestCompactToRoot(t *testing.T) {
+	nodes, values := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(nodes, values, sha256.New(), WithRootRetention(10), WithKeyStore(NewSimpleMap()), WithCompactNodes())
+	for i := 0; i < 50; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	smt.UpdatePresence([]byte("presentKey"))
+	oldRoot := smt.Root()
+	for i := 0; i < 10; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte("newValue"))
+	}
+	// Nodes of an unknown root, left in the store.
+	other := NewSparseMerkleTree(nodes, NewSimpleMap(), sha256.New())
+	for i := 0; i < 10; i++ {
+		other.Update([]byte(fmt.Sprintf("otherKey%d", i)), []byte("otherValue"))
+	}
+
+	for _, root := range [][]byte{smt.Root(), oldRoot} {
+		cnodes, cvalues, ckeys := NewSimpleMap(), NewSimpleMap(), NewSimpleMap()
+		copied, err := smt.CompactToRoot(root, cnodes, cvalues, ckeys)
+		if err != nil {
+			t.Fatalf("returned error when compacting: %v", err)
+		}
+		reachable := 0
+		smt.walk(root, func(_ []bool, _ []byte, _ []byte) error {
+			reachable++
+			return nil
+		})
+		if copied != len(cnodes.m) || copied != reachable || len(cnodes.m) >= len(nodes.m) {
+			t.Errorf("copied %d nodes to a store of %d, root has %d of a store of %d", copied, len(cnodes.m), reachable, len(nodes.m))
+		}
+		if len(cvalues.m) != 50 || len(ckeys.m) != 51 {
+			t.Errorf("copied %d values and %d keys, expected 50 and 51", len(cvalues.m), len(ckeys.m))
+		}
+
+		compacted := ImportSparseMerkleTree(cnodes, cvalues, sha256.New(), root, WithKeyStore(ckeys))
+		for i := 0; i < 50; i++ {
+			key := []byte(fmt.Sprintf("testKey%d", i))
+			want := []byte(fmt.Sprintf("testValue%d", i))
+			if i < 10 && bytes.Equal(root, smt.Root()) {
+				want = []byte("newValue")
+			}
+			if got, err := compacted.Get(key); err != nil || !bytes.Equal(got, want) {
+				t.Errorf("compacted store has %q, %v for %s, want %q", got, err, key, want)
+			}
+		}
+		if corrupt, err := compacted.Scrub(context.Background()); err != nil || len(corrupt) != 0 {
+			t.Errorf("compacted store has corrupt nodes %x: %v", corrupt, err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+)
+
+// ErrRangeNotEmpty is returned by ProveEmptyRange when a key lies in the
+// range.
+var ErrRangeNotEmpty = errors.New("range is not empty")
+
+// EmptyRangeProof is a proof that no key of a tree has its path in a range;
+// see ProveEmptyRange.
+type EmptyRangeProof struct {
+	// Nodes is the data of every node whose subtree overlaps the range,
+	// other than placeholders.
+	Nodes [][]byte
+}
+
+// ProveEmptyRange generates a proof against the current root that no key in
+// the tree has a path strictly between the paths of startKey and endKey.
+// Keys are ordered by path, i.e. by the hashes of the keys, not by the keys
+// themselves. The range is exclusive, so startKey and endKey may be in the
+// tree themselves: to prove them absent too, prove them individually with
+// Prove. A nil startKey or endKey leaves the range unbounded on that side, so
+// with both nil the range is the whole keyspace. If a key lies in the range,
+// ErrRangeNotEmpty is returned. To prove a set of keys absent, rather than a
+// range, prove them with ProveMulti and verify them with the default value.
+func (smt *SparseMerkleTree) ProveEmptyRange(startKey []byte, endKey []byte) (EmptyRangeProof, error) {
+	defer smt.readLock()()
+	var proof EmptyRangeProof
+	r := newEmptyRange(&smt.th, startKey, endKey, func(hash []byte) ([]byte, error) {
+		data, err := smt.getNode(hash)
+		if err == nil {
+			proof.Nodes = append(proof.Nodes, data)
+		}
+		return data, err
+	})
+	if err := r.check(smt.Root()); err != nil {
+		return EmptyRangeProof{}, err
+	}
+	return proof, nil
+}
+
+// VerifyEmptyRangeProof verifies a proof generated by ProveEmptyRange that no
+// key has a path strictly between the paths of startKey and endKey, with nil
+// keys leaving the range unbounded.
+func VerifyEmptyRangeProof(proof EmptyRangeProof, root []byte, startKey []byte, endKey []byte, hasher hash.Hash, options ...VerifyOption) bool {
+	th := newVerifyConfig(options).treeHasher(hasher)
+	nodes := make(map[string][]byte, len(proof.Nodes))
+	for _, data := range proof.Nodes {
+		if !th.validNode(data) {
+			return false
+		}
+		nodes[string(th.digest(data))] = data
+	}
+	r := newEmptyRange(th, startKey, endKey, func(hash []byte) ([]byte, error) {
+		data, ok := nodes[string(hash)]
+		if !ok {
+			return nil, ErrBadProof
+		}
+		return data, nil
+	})
+	return r.check(root) == nil
+}
+
+// emptyRange checks that no leaf of a tree has its path in the open interval
+// between lo and hi, where nil bounds are unbounded.
+type emptyRange struct {
+	th      *treeHasher
+	lo, hi  []byte
+	getNode func(hash []byte) ([]byte, error)
+}
+
+func newEmptyRange(th *treeHasher, startKey []byte, endKey []byte, getNode func(hash []byte) ([]byte, error)) *emptyRange {
+	r := &emptyRange{th: th, getNode: getNode}
+	if startKey != nil {
+		r.lo = th.path(startKey)
+	}
+	if endKey != nil {
+		r.hi = th.path(endKey)
+	}
+	return r
+}
+
+// check returns ErrRangeNotEmpty if a leaf under root is in the range, or an
+// error from getNode for the nodes whose subtrees overlap it.
+func (r *emptyRange) check(root []byte) error {
+	return r.checkNode(root, make([]byte, r.th.pathSize()), 0)
+}
+
+// checkNode checks the subtree under hash, whose position is given by the
+// first depth bits of prefix, the rest being zero.
+func (r *emptyRange) checkNode(hash []byte, prefix []byte, depth int) error {
+	if bytes.Equal(hash, r.th.placeholder()) || !r.overlaps(prefix, depth) {
+		return nil
+	}
+	data, err := r.getNode(hash)
+	if err != nil {
+		return err
+	}
+	if r.th.isLeaf(data) {
+		path, _ := r.th.parseLeaf(data)
+		if countCommonPrefix(path, prefix) < depth {
+			// The leaf is not at its place in the tree.
+			return ErrBadProof
+		}
+		if r.contains(path) {
+			return ErrRangeNotEmpty
+		}
+		return nil
+	}
+	if depth == r.th.pathSize()*8 {
+		// Internal nodes cannot be deeper than the paths are long.
+		return ErrBadProof
+	}
+
+	leftNode, rightNode := r.th.parseNode(data)
+	if err := r.checkNode(leftNode, prefix, depth+1); err != nil {
+		return err
+	}
+	rightPrefix := append([]byte{}, prefix...)
+	setBitAtFromMSB(rightPrefix, depth)
+	return r.checkNode(rightNode, rightPrefix, depth+1)
+}
+
+// overlaps returns true if the subtree at prefix and depth holds paths in the
+// range.
+func (r *emptyRange) overlaps(prefix []byte, depth int) bool {
+	// The subtree holds the paths from prefix, to prefix with all the bits
+	// below depth set.
+	if r.hi != nil && bytes.Compare(prefix, r.hi) >= 0 {
+		return false
+	}
+	if r.lo == nil {
+		return true
+	}
+	last := append([]byte{}, prefix...)
+	for i := depth; i < len(last)*8; i++ {
+		setBitAtFromMSB(last, i)
+	}
+	return bytes.Compare(last, r.lo) > 0
+}
+
+// contains returns true if path is in the range.
+func (r *emptyRange) contains(path []byte) bool {
+	return (r.lo == nil || bytes.Compare(path, r.lo) > 0) &&
+		(r.hi == nil || bytes.Compare(path, r.hi) < 0)
+}
@@ -0,0 +1,114 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestEmptyRangeProofs(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	var keys [][]byte
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		smt.Update(key, []byte("testValue"))
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(smt.th.path(keys[i]), smt.th.path(keys[j])) < 0
+	})
+
+	// Take two keys adjacent in path order, and two absent keys between them.
+	a, b, c := keys[5], keys[6], keys[7]
+	var absent [][]byte
+	for i := 0; len(absent) < 2; i++ {
+		key := []byte(fmt.Sprintf("absentKey%d", i))
+		path := smt.th.path(key)
+		if bytes.Compare(path, smt.th.path(a)) > 0 && bytes.Compare(path, smt.th.path(b)) < 0 {
+			absent = append(absent, key)
+		}
+	}
+	sort.Slice(absent, func(i, j int) bool {
+		return bytes.Compare(smt.th.path(absent[i]), smt.th.path(absent[j])) < 0
+	})
+	x, y := absent[0], absent[1]
+
+	for _, tc := range []struct {
+		name       string
+		start, end []byte
+	}{
+		{"present start, present end", a, b},
+		{"present start, absent end", a, y},
+		{"absent start, present end", x, b},
+		{"absent start, absent end", x, y},
+	} {
+		proof, err := smt.ProveEmptyRange(tc.start, tc.end)
+		if err != nil {
+			t.Errorf("%s: returned error when proving empty range: %v", tc.name, err)
+			continue
+		}
+		if !VerifyEmptyRangeProof(proof, smt.Root(), tc.start, tc.end, sha256.New()) {
+			t.Errorf("%s: empty range proof failed to verify", tc.name)
+		}
+		if VerifyEmptyRangeProof(proof, smt.Root(), tc.start, c, sha256.New()) {
+			t.Errorf("%s: empty range proof verified for a range holding a key", tc.name)
+		}
+	}
+
+	// The range is exclusive: widening it past a boundary key makes it
+	// non-empty.
+	if _, err := smt.ProveEmptyRange(a, c); !errors.Is(err, ErrRangeNotEmpty) {
+		t.Errorf("did not return ErrRangeNotEmpty for range holding a key, got %v", err)
+	}
+	if _, err := smt.ProveEmptyRange(nil, b); !errors.Is(err, ErrRangeNotEmpty) {
+		t.Errorf("did not return ErrRangeNotEmpty for range unbounded below, got %v", err)
+	}
+	if _, err := smt.ProveEmptyRange(nil, keys[0]); err != nil {
+		t.Errorf("returned error for empty range below the first key: %v", err)
+	}
+	proof, err := smt.ProveEmptyRange(keys[len(keys)-1], nil)
+	if err != nil {
+		t.Errorf("returned error for empty range above the last key: %v", err)
+	}
+	if !VerifyEmptyRangeProof(proof, smt.Root(), keys[len(keys)-1], nil, sha256.New()) {
+		t.Error("empty range proof above the last key failed to verify")
+	}
+
+	// Tampered and truncated proofs do not verify.
+	proof, _ = smt.ProveEmptyRange(a, b)
+	if VerifyEmptyRangeProof(EmptyRangeProof{Nodes: proof.Nodes[1:]}, smt.Root(), a, b, sha256.New()) {
+		t.Error("truncated empty range proof verified")
+	}
+	tampered := EmptyRangeProof{Nodes: append([][]byte{}, proof.Nodes...)}
+	tampered.Nodes[0] = append([]byte{}, tampered.Nodes[0]...)
+	tampered.Nodes[0][1] ^= 1
+	if VerifyEmptyRangeProof(tampered, smt.Root(), a, b, sha256.New()) {
+		t.Error("tampered empty range proof verified")
+	}
+}
+
+func TestEmptyRangeProofsWholeKeyspace(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	proof, err := smt.ProveEmptyRange(nil, nil)
+	if err != nil {
+		t.Errorf("returned error for whole keyspace of empty tree: %v", err)
+	}
+	if !VerifyEmptyRangeProof(proof, smt.Root(), nil, nil, sha256.New()) {
+		t.Error("empty range proof for whole keyspace of empty tree failed to verify")
+	}
+
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	if _, err := smt.ProveEmptyRange(nil, nil); !errors.Is(err, ErrRangeNotEmpty) {
+		t.Errorf("did not return ErrRangeNotEmpty for whole keyspace of non-empty tree, got %v", err)
+	}
+	if VerifyEmptyRangeProof(proof, smt.Root(), nil, nil, sha256.New()) {
+		t.Error("empty range proof for empty tree verified for non-empty tree")
+	}
+	// A range whose only key is a boundary is empty.
+	if _, err := smt.ProveEmptyRange([]byte("testKey"), nil); err != nil {
+		t.Errorf("returned error for range bounded by the only key: %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package smt
+
+import (
+	"errors"
+)
+
+// ReadOnlyTree is an immutable view of a tree at the root it had when taken
+// with Snapshot. It is safe for concurrent use, including while the tree it
+// was taken from is updated.
+type ReadOnlyTree struct {
+	tree *SparseMerkleTree
+	// view reads the tree's stores through the snapshot's stores, at the
+	// pinned root.
+	view   *SparseMerkleTree
+	stores [3]*readOnlyStore
+}
+
+// Snapshot returns a read-only view of the tree at its current root, from
+// which values can be read and proofs generated while the tree itself moves
+// on to other roots. Proofs from the snapshot verify against its root.
+//
+// The view is kept by copy-on-write: until the snapshot is released, every
+// update copies the node, value and key entries it deletes or overwrites into
+// the snapshot before doing so, and the snapshot reads its copies first.
+// These copies are held in memory and grow with the updates made, so
+// snapshots should be released with Release once they are no longer needed.
+// A snapshot keeps reading the stores the tree had when it was taken, even if
+// they are swapped out with SwapNodeStore or SwapValueStore, so they must
+// outlive it.
+func (smt *SparseMerkleTree) Snapshot() *ReadOnlyTree {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+	s := &ReadOnlyTree{tree: smt}
+	for i := range s.stores {
+		if store := smt.store(i); store != nil {
+			s.stores[i] = &readOnlyStore{tree: smt, base: store, entries: make(map[string][]byte)}
+		}
+	}
+	s.view = &SparseMerkleTree{
+		th:               smt.th,
+		nodes:            s.stores[nodeStore],
+		values:           s.stores[valueStore],
+		root:             smt.root,
+		sealed:           true,
+		decodeValue:      smt.decodeValue,
+		logger:           smt.logger,
+		defaultLeafValue: smt.defaultLeafValue,
+	}
+	if s.stores[keyStore] != nil {
+		s.view.keys = s.stores[keyStore]
+	}
+	if smt.snapshots == nil {
+		smt.snapshots = make(map[*ReadOnlyTree]struct{})
+	}
+	smt.snapshots[s] = struct{}{}
+	smt.debugf("took snapshot at root %x", smt.root)
+	return s
+}
+
+// Root gets the root of the snapshot.
+func (s *ReadOnlyTree) Root() []byte {
+	return s.view.root
+}
+
+// Get gets the value of a key at the root of the snapshot.
+func (s *ReadOnlyTree) Get(key []byte) ([]byte, error) {
+	return s.view.Get(key)
+}
+
+// Has returns true if the tree has a leaf for key at the root of the
+// snapshot, false otherwise.
+func (s *ReadOnlyTree) Has(key []byte) (bool, error) {
+	return s.view.Has(key)
+}
+
+// Prove generates a Merkle proof for a key against the root of the snapshot.
+func (s *ReadOnlyTree) Prove(key []byte) (SparseMerkleProof, error) {
+	return s.view.Prove(key)
+}
+
+// ProveCompact generates a compacted Merkle proof for a key against the root
+// of the snapshot.
+func (s *ReadOnlyTree) ProveCompact(key []byte) (SparseCompactMerkleProof, error) {
+	return s.view.ProveCompact(key)
+}
+
+// ProveMulti generates a proof of the values of keys against the root of the
+// snapshot.
+func (s *ReadOnlyTree) ProveMulti(keys [][]byte) (SparseMultiProof, error) {
+	return s.view.ProveMulti(keys)
+}
+
+// Iterate calls fn with the key and value of each leaf of the snapshot, as
+// the Iterate of a tree does, e.g. to export the state at a single root while
+// the tree moves on.
+func (s *ReadOnlyTree) Iterate(fn func(key, value []byte) bool) error {
+	return s.view.Iterate(fn)
+}
+
+// Release stops the copy-on-write of the snapshot and frees its copies. The
+// snapshot must not be used afterwards.
+func (s *ReadOnlyTree) Release() {
+	s.tree.mu.Lock()
+	defer s.tree.mu.Unlock()
+	delete(s.tree.snapshots, s)
+	for _, store := range s.stores {
+		if store != nil {
+			store.entries = nil
+		}
+	}
+}
+
+// preserve copies the entry at key of the store at index into the snapshots
+// that do not hold a copy of it yet, before it is deleted or overwritten.
+// Missing entries are copied as nil.
+func (smt *SparseMerkleTree) preserve(index int, key []byte) error {
+	var stored []byte
+	loaded := false
+	for s := range smt.snapshots {
+		store := s.stores[index]
+		if store == nil || store.detached {
+			continue
+		}
+		if _, ok := store.entries[string(key)]; ok {
+			continue
+		}
+		if !loaded {
+			value, err := smt.store(index).Get(key)
+			if err == nil {
+				stored = append([]byte{}, value...)
+			} else if !errors.Is(err, ErrKeyNotFound) {
+				return err
+			}
+			loaded = true
+		}
+		store.entries[string(key)] = stored
+	}
+	return nil
+}
+
+// detachSnapshots marks the store at index as no longer written by the tree
+// for every snapshot, after the tree switched to another store.
+func (smt *SparseMerkleTree) detachSnapshots(index int) {
+	for s := range smt.snapshots {
+		if store := s.stores[index]; store != nil {
+			store.detached = true
+		}
+	}
+}
+
+// readOnlyStore is the MapStore a snapshot reads: the copies made for it,
+// over the store of the tree it was taken from.
+type readOnlyStore struct {
+	tree *SparseMerkleTree
+	base MapStore
+	// entries are the copies of the entries changed since the snapshot, nil
+	// for those that were missing.
+	entries map[string][]byte
+	// detached is set once the tree no longer writes to base, so that no
+	// copies are needed.
+	detached bool
+}
+
+func (ss *readOnlyStore) Get(key []byte) ([]byte, error) {
+	// Reads exclude the updates of the tree, so that an entry changed by one
+	// is found either copied or in place.
+	ss.tree.mu.RLock()
+	defer ss.tree.mu.RUnlock()
+	if value, ok := ss.entries[string(key)]; ok {
+		if value == nil {
+			return nil, &InvalidKeyError{Key: key}
+		}
+		return value, nil
+	}
+	return ss.base.Get(key)
+}
+
+func (ss *readOnlyStore) Set(key []byte, value []byte) error {
+	return ErrSealed
+}
+
+func (ss *readOnlyStore) Delete(key []byte) error {
+	return ErrSealed
+}
+
+func (ss *readOnlyStore) Export() ([]byte, error) {
+	return nil, ErrSealed
+}
@@ -0,0 +1,143 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// checkSnapshot checks that the keys of a snapshot have the given values, and
+// that their proofs verify against its root.
+func checkSnapshot(t *testing.T, s *ReadOnlyTree, keys [][]byte, values [][]byte) {
+	for i, key := range keys {
+		value, err := s.Get(key)
+		if err != nil {
+			t.Errorf("returned error when getting key from snapshot: %v", err)
+		}
+		if !bytes.Equal(value, values[i]) {
+			t.Errorf("did not get value of key %s at snapshot root", key)
+		}
+		proof, err := s.Prove(key)
+		if err != nil {
+			t.Errorf("returned error when proving key from snapshot: %v", err)
+		}
+		if !VerifyProof(proof, s.Root(), key, values[i], sha256.New()) {
+			t.Errorf("proof of key %s from snapshot failed to verify", key)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithKeyStore(NewSimpleMap()))
+	var keys, values [][]byte
+	for i := 0; i < 100; i++ {
+		key, value := []byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i))
+		smt.Update(key, value)
+		keys, values = append(keys, key), append(values, value)
+	}
+	absent := []byte("newKey")
+	keys, values = append(keys, absent), append(values, defaultValue)
+
+	s := smt.Snapshot()
+	root := smt.Root()
+	for i := 0; i < 50; i++ {
+		smt.Update(keys[i], []byte("newValue"))
+		smt.Delete(keys[50+i])
+	}
+	smt.Update(absent, []byte("newValue"))
+
+	if !bytes.Equal(s.Root(), root) {
+		t.Error("snapshot root changed with the tree")
+	}
+	checkSnapshot(t, s, keys, values)
+	if _, err := s.Prove(absent); err != nil {
+		t.Errorf("returned error when proving absent key from snapshot: %v", err)
+	}
+	has, err := s.Has(keys[60])
+	if err != nil || !has {
+		t.Errorf("snapshot does not have key deleted since, got %v, %v", has, err)
+	}
+	value, err := smt.Get(keys[0])
+	if err != nil || !bytes.Equal(value, []byte("newValue")) {
+		t.Errorf("did not get updated value from tree, got %s, %v", value, err)
+	}
+
+	if _, err := s.view.Update(absent, []byte("value")); err != ErrSealed {
+		t.Errorf("did not return ErrSealed when updating snapshot, got %v", err)
+	}
+
+	s.Release()
+	if len(smt.snapshots) != 0 {
+		t.Error("released snapshot is still tracked")
+	}
+	smt.Update(keys[1], []byte("otherValue"))
+	if len(s.stores[valueStore].entries) != 0 {
+		t.Error("released snapshot still receives copies")
+	}
+}
+
+func TestSnapshotConcurrentUpdates(t *testing.T) {
+	smt := NewSparseMerkleTreeWithHasherFunc(NewSimpleMap(), NewSimpleMap(), sha256.New)
+	var keys, values [][]byte
+	for i := 0; i < 50; i++ {
+		key, value := []byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i))
+		smt.Update(key, value)
+		keys, values = append(keys, key), append(values, value)
+	}
+	s := smt.Snapshot()
+	defer s.Release()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 500; i++ {
+			key := keys[i%len(keys)]
+			if i%3 == 0 {
+				smt.Delete(key)
+			} else {
+				smt.Update(key, []byte(fmt.Sprintf("newValue%d", i)))
+			}
+		}
+	}()
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 5; i++ {
+				checkSnapshot(t, s, keys, values)
+			}
+		}()
+	}
+	wg.Wait()
+	checkSnapshot(t, s, keys, values)
+}
+
+func TestSnapshotSwapAndRetention(t *testing.T) {
+	log := NewSimpleMap()
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithRootRetention(1), WithWriteAheadLog(log))
+	var keys, values [][]byte
+	for i := 0; i < 20; i++ {
+		key, value := []byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i))
+		smt.Update(key, value)
+		keys, values = append(keys, key), append(values, value)
+	}
+	s := smt.Snapshot()
+	defer s.Release()
+
+	for i := 0; i < 10; i++ {
+		smt.Update(keys[i], []byte("newValue"))
+	}
+	if err := smt.SwapNodeStore(NewSimpleMap()); err != nil {
+		t.Fatalf("returned error when swapping node store: %v", err)
+	}
+	if err := smt.SwapValueStore(NewSimpleMap()); err != nil {
+		t.Fatalf("returned error when swapping value store: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		smt.Update(keys[i], []byte("otherValue"))
+	}
+	checkSnapshot(t, s, keys, values)
+}
@@ -0,0 +1,149 @@
+// Package redisstore provides a ContextMapStore backed by Redis, so that a
+// tree can be served from a cache shared by several processes.
+package redisstore
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/causevest/smt"
+	"github.com/redis/go-redis/v9"
+)
+
+// scanCount is the number of keys Export asks each SCAN for.
+const scanCount = 1000
+
+// RedisStore is a ContextMapStore that keeps its entries in Redis, under a
+// key prefix, so that several stores, such as the nodes and values of a tree,
+// can share one database. The prefixes of stores sharing a database must not
+// be prefixes of each other. Wrap it in an smt.ContextStore to back a tree
+// with it. It is safe for concurrent use.
+type RedisStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisStore creates a RedisStore keeping its entries in client under
+// prefix. Entries already in the database under prefix are kept, so a tree
+// can be imported from the store at its last root. The store does not own
+// client: close it once the stores on it are no longer used.
+func NewRedisStore(client redis.UniversalClient, prefix []byte) *RedisStore {
+	if client == nil {
+		panic("redisstore: nil client")
+	}
+	return &RedisStore{client: client, prefix: string(prefix)}
+}
+
+func (s *RedisStore) key(key []byte) string {
+	return s.prefix + string(key)
+}
+
+// GetContext gets the value for a key.
+func (s *RedisStore) GetContext(ctx context.Context, key []byte) ([]byte, error) {
+	value, err := s.client.Get(ctx, s.key(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, &smt.InvalidKeyError{Key: key}
+	}
+	return value, err
+}
+
+// BatchGetContext gets the values for keys, in order, in one MGET.
+func (s *RedisStore) BatchGetContext(ctx context.Context, keys [][]byte) ([][]byte, error) {
+	if len(keys) == 0 {
+		return [][]byte{}, nil
+	}
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = s.key(key)
+	}
+	results, err := s.client.MGet(ctx, prefixed...).Result()
+	if err != nil {
+		return nil, err
+	}
+	values := make([][]byte, len(keys))
+	for i, result := range results {
+		value, ok := result.(string)
+		if !ok {
+			return nil, &smt.InvalidKeyError{Key: keys[i]}
+		}
+		values[i] = []byte(value)
+	}
+	return values, nil
+}
+
+// SetContext updates the value for a key.
+func (s *RedisStore) SetContext(ctx context.Context, key []byte, value []byte) error {
+	return s.client.Set(ctx, s.key(key), value, 0).Err()
+}
+
+// BatchSetContext updates the values for keys in one MSET, so that the nodes
+// of an update are written in full or not at all.
+func (s *RedisStore) BatchSetContext(ctx context.Context, keys [][]byte, values [][]byte) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	pairs := make([]interface{}, 0, 2*len(keys))
+	for i, key := range keys {
+		pairs = append(pairs, s.key(key), values[i])
+	}
+	return s.client.MSet(ctx, pairs...).Err()
+}
+
+// DeleteContext deletes a key, returning an InvalidKeyError if it is missing,
+// as SimpleMap does.
+func (s *RedisStore) DeleteContext(ctx context.Context, key []byte) error {
+	n, err := s.client.Del(ctx, s.key(key)).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return &smt.InvalidKeyError{Key: key}
+	}
+	return nil
+}
+
+// ExportContext exports the entries of the store as a map snapshot, like
+// SimpleMap.Export. The keys are found by SCAN, so entries written meanwhile
+// may or may not be exported.
+func (s *RedisStore) ExportContext(ctx context.Context) ([]byte, error) {
+	sm := smt.NewSimpleMap()
+	var cursor uint64
+	for {
+		keys, next, err := s.client.Scan(ctx, cursor, pattern(s.prefix), scanCount).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) > 0 {
+			values, err := s.client.MGet(ctx, keys...).Result()
+			if err != nil {
+				return nil, err
+			}
+			for i, key := range keys {
+				// Keys deleted since the scan are skipped.
+				if value, ok := values[i].(string); ok {
+					if err := sm.Set([]byte(key[len(s.prefix):]), []byte(value)); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return sm.Export()
+		}
+	}
+}
+
+// pattern returns the SCAN pattern matching the keys under prefix.
+func pattern(prefix string) string {
+	var b strings.Builder
+	for _, c := range []byte(prefix) {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	b.WriteByte('*')
+	return b.String()
+}
@@ -0,0 +1,115 @@
+package redisstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/causevest/smt"
+	"github.com/redis/go-redis/v9"
+)
+
+func openClient(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("returned error when starting server: %v", err)
+	}
+	return server, redis.NewClient(&redis.Options{Addr: server.Addr()})
+}
+
+func TestRedisStore(t *testing.T) {
+	server, client := openClient(t)
+	defer server.Close()
+	defer client.Close()
+	ctx := context.Background()
+	s := NewRedisStore(client, []byte("n*"))
+	other := NewRedisStore(client, []byte("v/"))
+
+	if err := s.SetContext(ctx, []byte("key"), []byte("value")); err != nil {
+		t.Fatalf("returned error when setting: %v", err)
+	}
+	if value, err := s.GetContext(ctx, []byte("key")); err != nil || !bytes.Equal(value, []byte("value")) {
+		t.Errorf("did not get value, got %s, %v", value, err)
+	}
+	var invalidKeyError *smt.InvalidKeyError
+	if _, err := other.GetContext(ctx, []byte("key")); !errors.As(err, &invalidKeyError) {
+		t.Errorf("read key under another prefix, got %v", err)
+	}
+	if err := other.SetContext(ctx, []byte("otherKey"), []byte("otherValue")); err != nil {
+		t.Fatalf("returned error when setting: %v", err)
+	}
+	if _, err := s.BatchGetContext(ctx, [][]byte{[]byte("key"), []byte("missing")}); !errors.As(err, &invalidKeyError) {
+		t.Errorf("did not return InvalidKeyError for missing key in batch, got %v", err)
+	}
+
+	// The glob characters of the prefix are matched literally.
+	data, err := s.ExportContext(ctx)
+	if err != nil {
+		t.Fatalf("returned error when exporting: %v", err)
+	}
+	m, _, err := smt.ImportMerkleMap(data, data)
+	if err != nil {
+		t.Fatalf("returned error when importing export: %v", err)
+	}
+	if _, err := m.Get([]byte("otherKey")); !errors.As(err, &invalidKeyError) {
+		t.Errorf("exported key under another prefix, got %v", err)
+	}
+	if value, err := m.Get([]byte("key")); err != nil || !bytes.Equal(value, []byte("value")) {
+		t.Errorf("did not export value, got %s, %v", value, err)
+	}
+
+	if err := s.DeleteContext(ctx, []byte("key")); err != nil {
+		t.Errorf("returned error when deleting: %v", err)
+	}
+	if err := s.DeleteContext(ctx, []byte("key")); !errors.As(err, &invalidKeyError) {
+		t.Errorf("did not return InvalidKeyError when deleting missing key, got %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := other.GetContext(cancelled, []byte("otherKey")); !errors.Is(err, context.Canceled) {
+		t.Errorf("did not return Canceled, got %v", err)
+	}
+}
+
+func TestRedisStoreTree(t *testing.T) {
+	server, client := openClient(t)
+	defer server.Close()
+	defer client.Close()
+	nodes := smt.NewContextStore(context.Background(), NewRedisStore(client, []byte("n/")), time.Second)
+	values := smt.NewContextStore(context.Background(), NewRedisStore(client, []byte("v/")), time.Second)
+	tree := smt.NewSparseMerkleTree(nodes, values, sha256.New())
+	reference := smt.NewSparseMerkleTree(smt.NewSimpleMap(), smt.NewSimpleMap(), sha256.New())
+	for i := 0; i < 50; i++ {
+		key, value := []byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i))
+		if _, err := tree.Update(key, value); err != nil {
+			t.Fatalf("returned error when updating: %v", err)
+		}
+		reference.Update(key, value)
+	}
+	tree.Delete([]byte("testKey3"))
+	reference.Delete([]byte("testKey3"))
+	if !bytes.Equal(tree.Root(), reference.Root()) {
+		t.Fatal("tree on Redis has a different root")
+	}
+
+	// Another process sharing the database imports the tree at its root.
+	client2 := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client2.Close()
+	shared := smt.ImportSparseMerkleTree(
+		smt.NewContextStore(context.Background(), NewRedisStore(client2, []byte("n/")), time.Second),
+		smt.NewContextStore(context.Background(), NewRedisStore(client2, []byte("v/")), time.Second),
+		sha256.New(), tree.Root())
+	if value, err := shared.Get([]byte("testKey42")); err != nil || !bytes.Equal(value, []byte("testValue42")) {
+		t.Errorf("did not get value from shared tree, got %s, %v", value, err)
+	}
+	proof, err := shared.Prove([]byte("testKey3"))
+	if err != nil || !smt.VerifyProof(proof, tree.Root(), []byte("testKey3"), nil, sha256.New()) {
+		t.Errorf("did not prove deleted key from shared tree: %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"sort"
+)
+
+// ErrLeafHashOnly is returned by Rehash for a tree holding a leaf set with
+// UpdateLeafHash, whose value is not stored and so cannot be hashed again.
+var ErrLeafHashOnly = errors.New("leaf has a value hash but no value")
+
+// Rehash rebuilds the tree under the hasher returned by newHasher, returning
+// a new tree on in-memory stores with its own key store. The original tree is
+// left untouched. The tree must have been created with WithKeyStore, since
+// leaves are placed by the digests of their raw keys, and must hold the value
+// of every leaf but its presence leaves, which are carried over as such:
+// ErrLeafHashOnly is returned, naming the key, for a leaf set with
+// UpdateLeafHash. The new tree keeps the domain prefixes, path length,
+// default value and value size of the original, or ErrDepthMismatch is
+// returned if the digests of the new hasher are shorter than its paths;
+// other options, such as a value codec, are not carried over. See
+// SetOperationLimit.
+func (smt *SparseMerkleTree) Rehash(newHasher func() hash.Hash, options ...TraversalOption) (*SparseMerkleTree, error) {
+	if smt.keys == nil {
+		return nil, ErrKeysNotRetained
+	}
+	root := smt.Root()
+	if err := smt.checkOperationLimit(root, options); err != nil {
+		return nil, err
+	}
+
+	var keys, values, presentKeys [][]byte
+	err := smt.walk(root, func(_ []bool, _ []byte, data []byte) error {
+		if !smt.th.isLeaf(data) {
+			return nil
+		}
+		path, valueHash := smt.th.parseLeaf(data)
+		key, err := smt.keys.Get(path)
+		if err != nil {
+			return err
+		}
+		if len(valueHash) == 0 {
+			presentKeys = append(presentKeys, key)
+			return nil
+		}
+		value, err := smt.getValue(path)
+		if errors.Is(err, ErrKeyNotFound) {
+			return fmt.Errorf("%w: key %x", ErrLeafHashOnly, key)
+		}
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		values = append(values, value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	prefixes := smt.th.domainPrefixes()
+	treeOptions := []Option{WithKeyStore(NewSimpleMap()), WithDomainPrefixes(prefixes[0], prefixes[1])}
+	if smt.th.pathBytes != 0 {
+		if size := newHasher().Size(); smt.depth() > size*8 {
+			return nil, fmt.Errorf("%w: paths of %d bits, digests of %d", ErrDepthMismatch, smt.depth(), size*8)
+		}
+		treeOptions = append(treeOptions, WithPathBits(smt.depth()))
+	}
+	if smt.defaultLeafValue != nil {
+		treeOptions = append(treeOptions, WithDefaultValue(smt.defaultLeafValue))
+	}
+	if smt.storeDefaultValue {
+		treeOptions = append(treeOptions, WithStorableDefaultValue())
+	}
+	if smt.valueSize != 0 {
+		treeOptions = append(treeOptions, WithFixedValueSize(smt.valueSize))
+	}
+
+	th := newTreeHasherFunc(newHasher)
+	th.pathBytes = smt.th.pathBytes
+	paths := make([][]byte, len(keys))
+	for i, key := range keys {
+		paths[i] = th.path(key)
+	}
+	sort.Sort(byNewPath{keys, values, paths})
+
+	rehashed, err := BuildFromSorted(NewSimpleMap(), NewSimpleMap(), newHasher, NewSliceIterator(keys, values), treeOptions...)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range presentKeys {
+		if _, err := rehashed.UpdatePresence(key); err != nil {
+			return nil, err
+		}
+	}
+	return rehashed, nil
+}
+
+// byNewPath sorts keys and their values by the paths given for them.
+type byNewPath struct {
+	keys, values, paths [][]byte
+}
+
+func (s byNewPath) Len() int           { return len(s.keys) }
+func (s byNewPath) Less(i, j int) bool { return bytes.Compare(s.paths[i], s.paths[j]) < 0 }
+func (s byNewPath) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.values[i], s.values[j] = s.values[j], s.values[i]
+	s.paths[i], s.paths[j] = s.paths[j], s.paths[i]
+}
@@ -0,0 +1,87 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/sha3"
+)
+
+func TestRehash(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New(), WithKeyStore(NewSimpleMap()))
+	expected := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha3.New256())
+	for i := 0; i < 50; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		value := []byte(fmt.Sprintf("testValue%d", i))
+		smt.Update(key, value)
+		expected.Update(key, value)
+	}
+	root := smt.Root()
+	nodeCount := len(smn.m)
+
+	rehashed, err := smt.Rehash(sha3.New256)
+	if err != nil {
+		t.Fatalf("returned error when rehashing: %v", err)
+	}
+	if !bytes.Equal(rehashed.Root(), expected.Root()) {
+		t.Error("rehashed root does not match tree built under the new hasher")
+	}
+	if !bytes.Equal(smt.Root(), root) || len(smn.m) != nodeCount {
+		t.Error("original tree changed after rehashing")
+	}
+
+	// The rehashed tree retains keys, so it can be rehashed again.
+	back, err := rehashed.Rehash(sha256.New)
+	if err != nil {
+		t.Fatalf("returned error when rehashing back: %v", err)
+	}
+	if !bytes.Equal(back.Root(), root) {
+		t.Error("rehashing back did not restore the original root")
+	}
+
+	plain := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	if _, err := plain.Rehash(sha3.New256); !errors.Is(err, ErrKeysNotRetained) {
+		t.Errorf("did not return ErrKeysNotRetained without key store, got %v", err)
+	}
+}
+
+func TestRehashLeafKinds(t *testing.T) {
+	options := []Option{WithKeyStore(NewSimpleMap()), WithPathBits(64), WithDefaultValue([]byte("none")), WithFixedValueSize(4)}
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), options...)
+	expected := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha3.New256(), options...)
+	for _, tree := range []*SparseMerkleTree{smt, expected} {
+		tree.Update([]byte("testKey1"), []byte("val1"))
+		tree.UpdatePresence([]byte("presentKey"))
+	}
+
+	rehashed, err := smt.Rehash(sha3.New256)
+	if err != nil {
+		t.Fatalf("returned error when rehashing presence leaves: %v", err)
+	}
+	if !bytes.Equal(rehashed.Root(), expected.Root()) {
+		t.Error("rehashed root does not match tree built under the new hasher")
+	}
+	if value, _ := rehashed.Get([]byte("absentKey")); string(value) != "none" {
+		t.Errorf("got %q for an absent key, want the default value", value)
+	}
+	if _, err := rehashed.Update([]byte("testKey2"), []byte("long value")); !errors.Is(err, ErrValueSize) {
+		t.Errorf("got %v for a value of another size, want ErrValueSize", err)
+	}
+
+	valueHash := sha256.Sum256([]byte("external"))
+	smt.UpdateLeafHash([]byte("hashedKey"), valueHash[:])
+	if _, err := smt.Rehash(sha3.New256); !errors.Is(err, ErrLeafHashOnly) || !strings.Contains(err.Error(), fmt.Sprintf("%x", "hashedKey")) {
+		t.Errorf("got %v rehashing a leaf with no value, want ErrLeafHashOnly naming its key", err)
+	}
+
+	short := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha512.New(), WithKeyStore(NewSimpleMap()), WithPathBits(384))
+	if _, err := short.Rehash(sha256.New); !errors.Is(err, ErrDepthMismatch) {
+		t.Errorf("got %v rehashing to shorter digests than the paths, want ErrDepthMismatch", err)
+	}
+}
@@ -0,0 +1,359 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ErrRootPruned is returned when accessing a past root whose nodes may have
+// been pruned, i.e. a root that is neither current nor retained; see
+// WithRootRetention.
+var ErrRootPruned = errors.New("root is not retained")
+
+// retention holds what the updates after each retained root removed from the
+// stores, so that it can be removed once the root is no longer retained.
+type retention struct {
+	limit int
+	// roots are the retained past roots, oldest first, and journals[i] what
+	// the update from roots[i] to the next root removed.
+	roots    [][]byte
+	journals []*journal
+	// pending maps the hashes of orphaned nodes that are still stored to the
+	// journal of the update that orphaned them.
+	pending map[string]*journal
+	// current is the journal of the update in progress, if any.
+	current *journal
+}
+
+// journal records the nodes orphaned and the values replaced by an update.
+type journal struct {
+	nodes [][]byte
+	// values are the stored values, as encoded in the value store, by path,
+	// from before they were replaced or deleted; nil if there was none.
+	values map[string][]byte
+	// keys are the raw keys deleted from the key store, by path.
+	keys map[string][]byte
+}
+
+// WithRootRetention keeps the nodes and values of the last n roots before the
+// current one, instead of pruning them as soon as an update orphans them, so
+// that the past roots can still be read with methods like KeyChangedSince.
+// Retained roots are tracked in memory: a tree imported from the same stores
+// starts with none retained, and the nodes the previous tree would still have
+// pruned are left in the stores.
+func WithRootRetention(n int) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.retention = &retention{limit: n, pending: make(map[string]*journal)}
+	}
+}
+
+// WithPrunedRootCheck makes ProveForRoot, ProveUpdatableForRoot,
+// ProveExistingForRoot and ProveCompactForRoot return ErrRootPruned up front
+// for a root that is neither current nor retained, instead of failing with a
+// missing node partway down the tree once its nodes are pruned. With the
+// check, these methods no longer prove keys against the roots of subtrees.
+func WithPrunedRootCheck() Option {
+	return func(smt *SparseMerkleTree) {
+		smt.checkPrunedRoots = true
+	}
+}
+
+// RetainedRoots returns the retained past roots, oldest first.
+func (smt *SparseMerkleTree) RetainedRoots() [][]byte {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	return append([][]byte(nil), smt.retainedRoots()...)
+}
+
+// retainedRoots returns the retained past roots, without locking.
+func (smt *SparseMerkleTree) retainedRoots() [][]byte {
+	if smt.retention == nil {
+		return nil
+	}
+	return smt.retention.roots
+}
+
+// beginJournal starts recording what an update of the root removes.
+func (smt *SparseMerkleTree) beginJournal() {
+	if smt.retention != nil {
+		smt.retention.current = &journal{values: make(map[string][]byte), keys: make(map[string][]byte)}
+	}
+}
+
+// endJournal retains oldRoot with what the update to newRoot removed, if the
+// root changed, and prunes the oldest retained root if over the limit.
+func (smt *SparseMerkleTree) endJournal(oldRoot, newRoot []byte) error {
+	r := smt.retention
+	if r == nil {
+		return nil
+	}
+	j := r.current
+	r.current = nil
+	if j == nil || bytes.Equal(oldRoot, newRoot) {
+		return nil
+	}
+	r.roots = append(r.roots, oldRoot)
+	r.journals = append(r.journals, j)
+	for len(r.roots) > r.limit {
+		if err := smt.pruneJournal(r.journals[0]); err != nil {
+			return err
+		}
+		r.roots, r.journals = r.roots[1:], r.journals[1:]
+	}
+	return nil
+}
+
+// pruneJournal deletes the nodes orphaned by the update recorded in j, except
+// those written again since.
+func (smt *SparseMerkleTree) pruneJournal(j *journal) error {
+	pruned := 0
+	for _, hash := range j.nodes {
+		if smt.retention.pending[string(hash)] != j {
+			continue
+		}
+		delete(smt.retention.pending, string(hash))
+		if err := smt.deleteNode(hash); err != nil {
+			return err
+		}
+		pruned++
+	}
+	if smt.logger != nil {
+		smt.debugf("pruned %d orphaned nodes of an expired root", pruned)
+	}
+	return nil
+}
+
+// pruneNode deletes an orphaned node, or records it in the journal of the
+// update in progress if roots are retained, or among the orphans of the
+// update in archive mode, where it is kept if a version is made of it.
+func (smt *SparseMerkleTree) pruneNode(hash []byte) error {
+	if smt.archive != nil {
+		smt.archive.orphans = append(smt.archive.orphans, hash)
+		return nil
+	}
+	if smt.retention == nil || smt.retention.current == nil {
+		return smt.deleteNode(hash)
+	}
+	j := smt.retention.current
+	j.nodes = append(j.nodes, hash)
+	smt.retention.pending[string(hash)] = j
+	return nil
+}
+
+// retainValue records the stored value at path in the journal of the update
+// in progress, if any, before the update replaces or deletes it.
+func (smt *SparseMerkleTree) retainValue(path []byte) error {
+	if smt.retention == nil || smt.retention.current == nil {
+		return nil
+	}
+	j := smt.retention.current
+	if _, ok := j.values[string(path)]; ok {
+		return nil
+	}
+	value, err := smt.values.Get(path)
+	if errors.Is(err, ErrKeyNotFound) {
+		value = nil
+	} else if err != nil {
+		return err
+	}
+	j.values[string(path)] = value
+	return nil
+}
+
+// retainKey records the raw key at path in the journal of the update in
+// progress, if any, before the update deletes it.
+func (smt *SparseMerkleTree) retainKey(path []byte) error {
+	if smt.retention == nil || smt.retention.current == nil {
+		return nil
+	}
+	j := smt.retention.current
+	if _, ok := j.keys[string(path)]; ok {
+		return nil
+	}
+	key, err := smt.keys.Get(path)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	j.keys[string(path)] = key
+	return nil
+}
+
+// storedValueAtIndex returns the value at path under the root at index, as
+// valueAtIndex does, in the encoding of the value store, or nil if there is
+// none stored.
+func (smt *SparseMerkleTree) storedValueAtIndex(path []byte, index int) ([]byte, error) {
+	stored, err := smt.values.Get(path)
+	if errors.Is(err, ErrKeyNotFound) {
+		stored, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if smt.retention != nil {
+		// The first update after the root to replace the value recorded it.
+		for _, j := range smt.retention.journals[index:] {
+			if value, ok := j.values[string(path)]; ok {
+				stored = value
+				break
+			}
+		}
+	}
+	return stored, nil
+}
+
+// keyAtIndex returns the raw key at path under the retained root at index, or
+// the number of retained roots for the current root, or nil if it is not
+// known, as for a tree without a key store.
+func (smt *SparseMerkleTree) keyAtIndex(path []byte, index int) ([]byte, error) {
+	if smt.keys == nil {
+		return nil, nil
+	}
+	if smt.retention != nil {
+		// The first update after the root to delete the key recorded it.
+		for _, j := range smt.retention.journals[index:] {
+			if key, ok := j.keys[string(path)]; ok {
+				return key, nil
+			}
+		}
+	}
+	key, err := smt.keys.Get(path)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, nil
+	}
+	return key, err
+}
+
+// retainedIndex returns the index of root in the retained roots, the number
+// of retained roots if it is the current root, or ErrRootPruned.
+func (smt *SparseMerkleTree) retainedIndex(root []byte) (int, error) {
+	roots := smt.retainedRoots()
+	if bytes.Equal(root, smt.root) {
+		return len(roots), nil
+	}
+	for i := len(roots) - 1; i >= 0; i-- {
+		if bytes.Equal(roots[i], root) {
+			return i, nil
+		}
+	}
+	return 0, ErrRootPruned
+}
+
+// checkRootRetained returns ErrRootPruned if the tree checks for pruned roots
+// and root is neither current nor retained, nor a version in archive mode.
+// The empty root has no nodes to prune.
+func (smt *SparseMerkleTree) checkRootRetained(root []byte) error {
+	if !smt.checkPrunedRoots || bytes.Equal(root, smt.th.placeholder()) {
+		return nil
+	}
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	_, err := smt.retainedIndex(root)
+	if errors.Is(err, ErrRootPruned) {
+		if ok, versionErr := smt.isVersion(root); versionErr != nil || ok {
+			return versionErr
+		}
+	}
+	return err
+}
+
+// leafValueHash returns the value hash of the leaf at path under root, or nil
+// if there is none.
+func (smt *SparseMerkleTree) leafValueHash(path []byte, root []byte) ([]byte, error) {
+	if bytes.Equal(root, smt.th.placeholder()) {
+		return nil, nil
+	}
+	_, pathNodes, leafData, _, err := smt.sideNodesForRoot(path, root, false)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(pathNodes[0], smt.th.placeholder()) {
+		return nil, nil
+	}
+	leafPath, valueHash := smt.th.parseLeaf(leafData)
+	if !bytes.Equal(leafPath, path) {
+		return nil, nil
+	}
+	return valueHash, nil
+}
+
+// valueAtIndex returns the value at path under the retained root at index,
+// or the current root if index is the number of retained roots, given that
+// there is a leaf at path under it. The value of a leaf set with
+// UpdateLeafHash is the default value.
+func (smt *SparseMerkleTree) valueAtIndex(path []byte, index int) ([]byte, error) {
+	stored, err := smt.storedValueAtIndex(path, index)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return smt.emptyValue(), nil
+	}
+	if smt.decodeValue == nil {
+		return stored, nil
+	}
+	return smt.decodeValue(stored)
+}
+
+// KeyChangedSince reports whether the value of key under the current root
+// differs from its value under oldRoot, returning both. oldRoot must be the
+// current root or a retained one, or ErrRootPruned is returned. Absent keys
+// have the default value.
+func (smt *SparseMerkleTree) KeyChangedSince(oldRoot []byte, key []byte) (changed bool, oldValue []byte, newValue []byte, err error) {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	index, err := smt.retainedIndex(oldRoot)
+	if err != nil {
+		return false, nil, nil, err
+	}
+	path := smt.th.path(key)
+	oldValueHash, err := smt.leafValueHash(path, oldRoot)
+	if err != nil {
+		return false, nil, nil, err
+	}
+	newValueHash, err := smt.leafValueHash(path, smt.root)
+	if err != nil {
+		return false, nil, nil, err
+	}
+	oldValue, newValue = smt.emptyValue(), smt.emptyValue()
+	if oldValueHash != nil {
+		if oldValue, err = smt.valueAtIndex(path, index); err != nil {
+			return false, nil, nil, err
+		}
+	}
+	if newValueHash != nil {
+		if newValue, err = smt.valueAtIndex(path, len(smt.retainedRoots())); err != nil {
+			return false, nil, nil, err
+		}
+	}
+	return !bytes.Equal(oldValueHash, newValueHash), oldValue, newValue, nil
+}
+
+// GetForRoot gets the value of a key under root, which must be the current
+// root or a retained one, or ErrRootPruned is returned: the value store only
+// holds the current value of each key, and the nodes of other past roots are
+// pruned by the updates that orphan them. Keep past roots queryable, along
+// with their proofs from ProveForRoot, with WithRootRetention, or every past
+// root with WithArchive. Absent keys have the default value.
+func (smt *SparseMerkleTree) GetForRoot(key []byte, root []byte) ([]byte, error) {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	index, err := smt.retainedIndex(root)
+	if errors.Is(err, ErrRootPruned) {
+		if ok, versionErr := smt.isVersion(root); versionErr != nil {
+			return nil, versionErr
+		} else if ok {
+			return smt.versionValue(key, root)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	path := smt.th.path(key)
+	valueHash, err := smt.leafValueHash(path, root)
+	if err != nil || valueHash == nil {
+		return smt.emptyValue(), err
+	}
+	return smt.valueAtIndex(path, index)
+}
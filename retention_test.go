@@ -0,0 +1,129 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestRootRetention(t *testing.T) {
+	smn := NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, NewSimpleMap(), sha256.New(), WithRootRetention(3))
+	var roots [][]byte
+	history := make(map[string]map[string]string) // Values by root and key.
+	current := make(map[string]string)
+	snapshot := func() {
+		values := make(map[string]string, len(current))
+		for k, v := range current {
+			values[k] = v
+		}
+		history[string(smt.Root())] = values
+		roots = append(roots, smt.Root())
+	}
+	snapshot()
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("testKey%d", rand.Intn(10))
+		if rand.Intn(3) == 0 {
+			smt.Delete([]byte(key))
+			delete(current, key)
+		} else {
+			value := fmt.Sprintf("testValue%d", i)
+			smt.Update([]byte(key), []byte(value))
+			current[key] = value
+		}
+		snapshot()
+
+		for _, root := range smt.RetainedRoots() {
+			for j := 0; j < 10; j++ {
+				key := fmt.Sprintf("testKey%d", j)
+				old, now := history[string(root)][key], current[key]
+				changed, oldValue, newValue, err := smt.KeyChangedSince(root, []byte(key))
+				if err != nil {
+					t.Fatalf("returned error for retained root: %v", err)
+				}
+				if string(oldValue) != old || string(newValue) != now || changed != (old != now) {
+					t.Fatalf("got changed=%v %q -> %q for %s, expected %q -> %q", changed, oldValue, newValue, key, old, now)
+				}
+				value, err := smt.GetForRoot([]byte(key), root)
+				if err != nil || string(value) != old {
+					t.Fatalf("got %q for %s at retained root, expected %q: %v", value, key, old, err)
+				}
+				proof, err := smt.ProveForRoot([]byte(key), root)
+				if err != nil || !VerifyProof(proof, root, []byte(key), value, sha256.New()) {
+					t.Fatalf("did not prove %s against retained root: %v", key, err)
+				}
+			}
+		}
+	}
+	if len(smt.RetainedRoots()) != 3 {
+		t.Errorf("retained %d roots, expected 3", len(smt.RetainedRoots()))
+	}
+	if _, _, _, err := smt.KeyChangedSince(roots[0], []byte("testKey0")); !errors.Is(err, ErrRootPruned) {
+		t.Errorf("did not return ErrRootPruned for expired root, got %v", err)
+	}
+	if _, err := smt.GetForRoot([]byte("testKey0"), roots[0]); !errors.Is(err, ErrRootPruned) {
+		t.Errorf("did not return ErrRootPruned from GetForRoot for expired root, got %v", err)
+	}
+
+	// Nodes of expired roots are pruned: the store holds no more than the
+	// nodes of the retained and current roots.
+	reachable := make(map[string]bool)
+	for _, root := range append(smt.RetainedRoots(), smt.Root()) {
+		smt.walk(root, func(_ []bool, hash []byte, _ []byte) error {
+			reachable[string(hash)] = true
+			return nil
+		})
+	}
+	if len(smn.m) != len(reachable) {
+		t.Errorf("node store holds %d nodes, expected %d reachable from retained roots", len(smn.m), len(reachable))
+	}
+}
+
+func TestKeyChangedSinceWithoutRetention(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	oldRoot, _ := smt.Update([]byte("testKey"), []byte("testValue"))
+	changed, oldValue, newValue, err := smt.KeyChangedSince(oldRoot, []byte("testKey"))
+	if err != nil || changed || !bytes.Equal(oldValue, []byte("testValue")) || !bytes.Equal(newValue, oldValue) {
+		t.Errorf("did not report unchanged key for current root, got %v %q %q %v", changed, oldValue, newValue, err)
+	}
+	smt.Update([]byte("testKey"), []byte("testValue2"))
+	if _, _, _, err := smt.KeyChangedSince(oldRoot, []byte("testKey")); !errors.Is(err, ErrRootPruned) {
+		t.Errorf("did not return ErrRootPruned without retention, got %v", err)
+	}
+}
+
+func TestPrunedRootCheck(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithRootRetention(1), WithPrunedRootCheck())
+	if _, err := smt.ProveForRoot([]byte("testKey"), smt.Root()); err != nil {
+		t.Errorf("returned error when proving against empty root: %v", err)
+	}
+	first, _ := smt.Update([]byte("testKey"), []byte("testValue"))
+	second, _ := smt.Update([]byte("testKey"), []byte("testValue2"))
+	smt.Update([]byte("testKey"), []byte("testValue3"))
+
+	proof, err := smt.ProveForRoot([]byte("testKey"), second)
+	if err != nil {
+		t.Fatalf("returned error when proving against retained root: %v", err)
+	}
+	if !VerifyProof(proof, second, []byte("testKey"), []byte("testValue2"), sha256.New()) {
+		t.Error("proof against retained root failed to verify")
+	}
+	if _, err := smt.ProveForRoot([]byte("testKey"), first); !errors.Is(err, ErrRootPruned) {
+		t.Errorf("did not return ErrRootPruned when proving against pruned root, got %v", err)
+	}
+	if _, err := smt.ProveUpdatableForRoot([]byte("testKey"), first); !errors.Is(err, ErrRootPruned) {
+		t.Errorf("did not return ErrRootPruned for updatable proof against pruned root, got %v", err)
+	}
+	if _, err := smt.ProveCompactForRoot([]byte("testKey"), first); !errors.Is(err, ErrRootPruned) {
+		t.Errorf("did not return ErrRootPruned for compact proof against pruned root, got %v", err)
+	}
+	if _, err := smt.ProveExistingForRoot([]byte("testKey"), first); !errors.Is(err, ErrRootPruned) {
+		t.Errorf("did not return ErrRootPruned for existing proof against pruned root, got %v", err)
+	}
+	if _, err := smt.Prove([]byte("testKey")); err != nil {
+		t.Errorf("returned error when proving against current root: %v", err)
+	}
+}
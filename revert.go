@@ -0,0 +1,218 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// RevertOption is an option for Revert.
+type RevertOption func(*revertConfig)
+
+type revertConfig struct {
+	discardOrphans bool
+}
+
+// WithDiscardOrphans makes Revert delete the nodes written since the root
+// reverted to, which no retained root reaches once the roots after it are
+// dropped, rather than leaving them in the node store for Prune. Finding them
+// walks the whole trees under the root reverted to and the roots retained
+// before it, which also checks that none of their nodes are missing before
+// anything is changed.
+func WithDiscardOrphans() RevertOption {
+	return func(config *revertConfig) {
+		config.discardOrphans = true
+	}
+}
+
+// Revert resets the root of the tree to root, an earlier root retained with
+// WithRootRetention, restoring the values and keys the updates since have
+// replaced or deleted, as for a chain reorganization. The roots retained after
+// root are dropped, along with the history of the updates from them, so that
+// the tree continues from root as if they had never been made. ErrRootPruned
+// is returned for a root that is neither current nor retained, as the value
+// store only holds the values of the current root, and ErrBadRootNode if the
+// root node is missing from the node store.
+//
+// The revert is a single change of the root, made atomically with a
+// write-ahead log and notified to OnRootChange callbacks. The nodes of the
+// dropped roots are left in the node store unless WithDiscardOrphans is
+// given. In a tree created with WithLazyCommit, staged writes are kept; call
+// Discard to drop them too.
+func (smt *SparseMerkleTree) Revert(root []byte, options ...RevertOption) error {
+	var config revertConfig
+	for _, option := range options {
+		option(&config)
+	}
+	_, err := smt.changeRoot(func(current []byte) ([]byte, error) {
+		return smt.revert(current, root, config)
+	})
+	return err
+}
+
+func (smt *SparseMerkleTree) revert(current []byte, root []byte, config revertConfig) ([]byte, error) {
+	if smt.sealed {
+		return nil, ErrSealed
+	}
+	if bytes.Equal(root, current) {
+		return current, nil
+	}
+	index, err := smt.retainedIndex(root)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %x", err, root)
+	}
+	if err := smt.checkRootNode(root); err != nil {
+		return nil, err
+	}
+
+	r := smt.retention
+	var orphans [][]byte
+	if config.discardOrphans {
+		keep := append([][]byte{root}, r.roots[:index]...)
+		if orphans, err = smt.revertOrphans(current, keep, r.journals[index:]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Applied from the newest update back, the value each path had under root
+	// is the one recorded by the first update after it to change the path.
+	touched := make(map[string]bool)
+	for i := len(r.journals) - 1; i >= index; i-- {
+		j := r.journals[i]
+		for path, value := range j.values {
+			touched[path] = true
+			if err := smt.restore(valueStore, []byte(path), value); err != nil {
+				return nil, err
+			}
+		}
+		for path, key := range j.keys {
+			touched[path] = true
+			if err := smt.restore(keyStore, []byte(path), key); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for path := range touched {
+		// Keys written since root are only recorded by the values they set.
+		if smt.keys != nil {
+			valueHash, err := smt.leafValueHash([]byte(path), root)
+			if err != nil {
+				return nil, err
+			}
+			if valueHash == nil {
+				if err := smt.restore(keyStore, []byte(path), nil); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if err := smt.bumpKeyVersion([]byte(path)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, hash := range orphans {
+		if err := smt.deleteNode(hash); err != nil {
+			return nil, err
+		}
+	}
+	for i := index; i < len(r.journals); i++ {
+		for _, hash := range r.journals[i].nodes {
+			if r.pending[string(hash)] == r.journals[i] {
+				delete(r.pending, string(hash))
+			}
+		}
+	}
+	dropped := len(r.roots) - index
+	r.roots, r.journals = r.roots[:index], r.journals[:index]
+	// The revert is not itself an update to retain the current root for.
+	r.current = nil
+	smt.debugf("reverted from root %x to %x, dropping %d retained roots and %d nodes", current, root, dropped, len(orphans))
+	return root, nil
+}
+
+// checkRootNode returns ErrBadRootNode if the node of root is missing or does
+// not hash to it.
+func (smt *SparseMerkleTree) checkRootNode(root []byte) error {
+	if bytes.Equal(root, smt.th.placeholder()) {
+		return nil
+	}
+	data, err := smt.getNode(root)
+	if errors.Is(err, ErrKeyNotFound) {
+		return rootMismatch("%w: %x is missing", ErrBadRootNode, root)
+	}
+	if err != nil {
+		return fmt.Errorf("getting root node %x: %w", root, err)
+	}
+	if !smt.th.validNode(data) || !bytes.Equal(smt.th.digest(data), root) {
+		return rootMismatch("%w: %x", ErrBadRootNode, root)
+	}
+	return nil
+}
+
+// revertOrphans returns the nodes that a revert from current to the first of
+// keep, the roots it retains, orphans: those of current, and those orphaned by
+// the updates of journals since, that none of keep reach.
+func (smt *SparseMerkleTree) revertOrphans(current []byte, keep [][]byte, journals []*journal) ([][]byte, error) {
+	reachable := make(map[string]bool)
+	for _, root := range keep {
+		if err := smt.markReachable(root, reachable); err != nil {
+			return nil, err
+		}
+	}
+	orphaned := make(map[string]bool)
+	var orphans [][]byte
+	add := func(hash []byte) {
+		if !reachable[string(hash)] && !orphaned[string(hash)] {
+			orphaned[string(hash)] = true
+			orphans = append(orphans, hash)
+		}
+	}
+	for _, j := range journals {
+		for _, hash := range j.nodes {
+			add(hash)
+		}
+	}
+	err := smt.walkUnreachable(current, reachable, add)
+	return orphans, err
+}
+
+// walkUnreachable calls visit for each node under hash that is not in
+// reachable, not descending into those that are, as their subtrees are too.
+func (smt *SparseMerkleTree) walkUnreachable(hash []byte, reachable map[string]bool, visit func(hash []byte)) error {
+	if bytes.Equal(hash, smt.th.placeholder()) || reachable[string(hash)] {
+		return nil
+	}
+	data, err := smt.getNode(hash)
+	if err != nil {
+		return err
+	}
+	visit(hash)
+	if smt.th.isLeaf(data) {
+		return nil
+	}
+	leftNode, rightNode := smt.th.parseNode(data)
+	if err := smt.walkUnreachable(leftNode, reachable, visit); err != nil {
+		return err
+	}
+	return smt.walkUnreachable(rightNode, reachable, visit)
+}
+
+// restore sets key in the store at index to value, as it was stored before,
+// or deletes it if value is nil.
+func (smt *SparseMerkleTree) restore(index int, key []byte, value []byte) error {
+	store := smt.store(index)
+	if store == nil {
+		return nil
+	}
+	if err := smt.preserve(index, key); err != nil {
+		return err
+	}
+	if value != nil {
+		return store.Set(key, value)
+	}
+	err := store.Delete(key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil
+	}
+	return err
+}
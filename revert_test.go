@@ -0,0 +1,113 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestRevert(t *testing.T) {
+	for _, discard := range []bool{false, true} {
+		smn, smv, smk := NewSimpleMap(), NewSimpleMap(), NewSimpleMap()
+		smt := NewSparseMerkleTree(smn, smv, sha256.New(), WithKeyStore(smk), WithRootRetention(30))
+		for i := 0; i < 20; i++ {
+			smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+		}
+		root := smt.Root()
+		retained := len(smt.RetainedRoots())
+		nodes, values, keys := smn.snapshot().(*SimpleMap), smv.snapshot().(*SimpleMap), smk.snapshot().(*SimpleMap)
+
+		smt.Update([]byte("testKey3"), []byte("newValue3"))
+		smt.Delete([]byte("testKey4"))
+		smt.Update([]byte("newKey"), []byte("newValue"))
+		smt.Update([]byte("testKey3"), []byte("newerValue3"))
+		var notified [][]byte
+		smt.OnRootChange(func(oldRoot, newRoot []byte) {
+			notified = append(notified, oldRoot, newRoot)
+		})
+		current := smt.Root()
+
+		var options []RevertOption
+		if discard {
+			options = append(options, WithDiscardOrphans())
+		}
+		if err := smt.Revert(root, options...); err != nil {
+			t.Fatalf("returned error when reverting: %v", err)
+		}
+		if !bytes.Equal(smt.Root(), root) {
+			t.Error("did not revert the root")
+		}
+		if len(notified) != 2 || !bytes.Equal(notified[0], current) || !bytes.Equal(notified[1], root) {
+			t.Error("did not notify the change of root")
+		}
+		if n := len(smt.RetainedRoots()); n != retained {
+			t.Errorf("reverted tree retains %d roots, want %d", n, retained)
+		}
+		for _, c := range []struct{ key, value string }{{"testKey3", "testValue3"}, {"testKey4", "testValue4"}, {"newKey", ""}} {
+			if value, err := smt.Get([]byte(c.key)); err != nil || !bytes.Equal(value, []byte(c.value)) {
+				t.Errorf("got %q, %v for %s from the reverted tree", value, err, c.key)
+			}
+		}
+		if !simpleMapsEqual(smv, values) || !simpleMapsEqual(smk, keys) {
+			t.Error("values or keys differ from those of the root reverted to")
+		}
+		if discard && !simpleMapsEqual(smn, nodes) {
+			t.Error("nodes differ from those of the root reverted to")
+		}
+
+		// The tree continues from the root as if the updates had not been made.
+		reference := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+		for i := 0; i < 20; i++ {
+			reference.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+		}
+		smt.Update([]byte("otherKey"), []byte("otherValue"))
+		reference.Update([]byte("otherKey"), []byte("otherValue"))
+		if !bytes.Equal(smt.Root(), reference.Root()) {
+			t.Error("reverted tree has another root after an update")
+		}
+		if err := smt.Revert(root, options...); err != nil {
+			t.Fatalf("returned error when reverting again: %v", err)
+		}
+		if _, err := smt.Prune(nil); err != nil {
+			t.Errorf("returned error pruning the reverted tree: %v", err)
+		}
+		if value, err := smt.Get([]byte("testKey7")); err != nil || !bytes.Equal(value, []byte("testValue7")) {
+			t.Errorf("got %q, %v from the pruned tree", value, err)
+		}
+	}
+}
+
+func TestRevertErrors(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithRootRetention(1))
+	smt.Update([]byte("testKey1"), []byte("testValue1"))
+	expired := smt.Root()
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	smt.Update([]byte("testKey3"), []byte("testValue3"))
+	if err := smt.Revert(expired); !errors.Is(err, ErrRootPruned) {
+		t.Errorf("got %v reverting to an expired root, want ErrRootPruned", err)
+	}
+	if err := smt.Revert(smt.Root()); err != nil {
+		t.Errorf("returned error reverting to the current root: %v", err)
+	}
+
+	retained := smt.RetainedRoots()[0]
+	smt.nodes.Delete(retained)
+	if err := smt.Revert(retained); !errors.Is(err, ErrBadRootNode) {
+		t.Errorf("got %v reverting to a root whose node is missing, want ErrBadRootNode", err)
+	}
+
+	plain := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	plain.Update([]byte("testKey1"), []byte("testValue1"))
+	root := plain.Root()
+	plain.Update([]byte("testKey2"), []byte("testValue2"))
+	if err := plain.Revert(root); !errors.Is(err, ErrRootPruned) {
+		t.Errorf("got %v reverting a tree without retention, want ErrRootPruned", err)
+	}
+
+	smt.Seal()
+	if err := smt.Revert(retained); !errors.Is(err, ErrSealed) {
+		t.Errorf("got %v reverting a sealed tree, want ErrSealed", err)
+	}
+}
@@ -0,0 +1,83 @@
+package smt
+
+import "bytes"
+
+// OnRootChange registers a callback that is called with the old and new root
+// whenever an update, deletion or SetRoot changes the root of the tree.
+// Updates that leave the root as it was, such as setting a key to its current
+// value, do not call it. Callbacks are called synchronously, in the order they
+// were registered, once the update has been written to the stores. They are
+// called without the tree locked, so they may use the tree, but concurrent
+// updates may then notify out of order.
+func (smt *SparseMerkleTree) OnRootChange(callback func(oldRoot, newRoot []byte)) {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+	smt.rootCallbacks = append(smt.rootCallbacks, callback)
+}
+
+// rootChange is a change of the root of a tree, to be notified once the tree
+// is unlocked.
+type rootChange struct {
+	oldRoot, newRoot []byte
+	callbacks        []func(oldRoot, newRoot []byte)
+	observers        []*observer
+	events           []treeEvent
+}
+
+// changeRoot sets the root of the tree to the one returned by update for the
+// current root, then notifies the OnRootChange callbacks and observers.
+func (smt *SparseMerkleTree) changeRoot(update func(root []byte) ([]byte, error)) ([]byte, error) {
+	change, err := smt.changeRootLocked(update)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(change.oldRoot, change.newRoot) {
+		for _, callback := range change.callbacks {
+			callback(change.oldRoot, change.newRoot)
+		}
+		notifyObservers(change.observers, change.events, change.oldRoot, change.newRoot)
+	}
+	return change.newRoot, nil
+}
+
+func (smt *SparseMerkleTree) changeRootLocked(update func(root []byte) ([]byte, error)) (*rootChange, error) {
+	defer smt.writeLock()()
+	oldRoot := smt.root
+	smt.observing = len(smt.observers) > 0
+	defer func() {
+		smt.observing, smt.events = false, nil
+	}()
+	apply := func() ([]byte, error) {
+		smt.beginJournal()
+		if err := smt.beginArchive(oldRoot); err != nil {
+			return nil, err
+		}
+		smt.events = nil
+		newRoot, err := update(oldRoot)
+		if err != nil {
+			return nil, err
+		}
+		if err := smt.endJournal(oldRoot, newRoot); err != nil {
+			return nil, err
+		}
+		return newRoot, smt.archiveRoot(newRoot)
+	}
+	var newRoot []byte
+	var err error
+	if smt.wal != nil {
+		newRoot, err = smt.withWAL(oldRoot, apply)
+	} else {
+		newRoot, err = apply()
+	}
+	if err != nil {
+		return nil, err
+	}
+	smt.root = newRoot
+	return &rootChange{
+		oldRoot:   oldRoot,
+		newRoot:   newRoot,
+		callbacks: smt.rootCallbacks,
+		observers: smt.observers,
+		events:    smt.events,
+	}, nil
+}
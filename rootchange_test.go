@@ -0,0 +1,53 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestOnRootChange(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	var changes [][2][]byte
+	smt.OnRootChange(func(oldRoot, newRoot []byte) {
+		// Callbacks may use the tree.
+		if !bytes.Equal(smt.Root(), newRoot) {
+			t.Error("callback called before root was set")
+		}
+		changes = append(changes, [2][]byte{oldRoot, newRoot})
+	})
+	calls := 0
+	smt.OnRootChange(func(oldRoot, newRoot []byte) {
+		calls++
+	})
+
+	empty := smt.Root()
+	root1, _ := smt.Update([]byte("testKey"), []byte("testValue"))
+	if len(changes) != 1 || !bytes.Equal(changes[0][0], empty) || !bytes.Equal(changes[0][1], root1) {
+		t.Fatal("did not notify update with old and new root")
+	}
+
+	// No-op updates do not notify.
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	smt.Delete([]byte("otherKey"))
+	if len(changes) != 1 {
+		t.Error("notified update that did not change the root")
+	}
+
+	root2, _ := smt.Delete([]byte("testKey"))
+	if len(changes) != 2 || !bytes.Equal(changes[1][0], root1) || !bytes.Equal(changes[1][1], root2) {
+		t.Error("did not notify delete")
+	}
+	smt.UpdateLeafHash([]byte("testKey"), make([]byte, sha256.Size))
+	smt.SetRoot(root1)
+	if len(changes) != 4 {
+		t.Error("did not notify UpdateLeafHash and SetRoot")
+	}
+
+	// Failed updates do not notify.
+	smt.Seal()
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	if len(changes) != 4 || calls != 4 {
+		t.Error("notified failed update")
+	}
+}
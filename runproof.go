@@ -0,0 +1,153 @@
+package smt
+
+import (
+	"bytes"
+	"hash"
+)
+
+// PlaceholderRun is a run of consecutive placeholder side nodes in a proof.
+type PlaceholderRun struct {
+	// Start is the index of the first side node of the run in the side nodes
+	// of the decompacted proof, which start from the leaf.
+	Start int
+	// Count is the number of side nodes in the run.
+	Count int
+}
+
+// SparseRunLengthMerkleProof is a compact Merkle proof for an element in a
+// SparseMerkleTree that encodes placeholder side nodes as runs rather than a
+// bit mask, which is smaller for proofs whose placeholders are mostly
+// consecutive, such as those for keys in sparse regions of the tree.
+type SparseRunLengthMerkleProof struct {
+	// SideNodes is an array of the sibling nodes leading up to the leaf of
+	// the proof that are not placeholders.
+	SideNodes [][]byte
+
+	// NonMembershipLeafData is the data of the unrelated leaf at the position
+	// of the key being proven, in the case of a non-membership proof. For
+	// membership proofs, is nil.
+	NonMembershipLeafData []byte
+
+	// Runs are the runs of placeholder side nodes, in ascending order.
+	Runs []PlaceholderRun
+
+	// NumSideNodes indicates the number of sidenodes in the proof when
+	// decompacted.
+	NumSideNodes int
+
+	// SiblingData is the data of the sibling node to the leaf being proven,
+	// required for updatable proofs. For unupdatable proofs, is nil.
+	SiblingData []byte
+
+	// Depth is the depth of the tree the proof is from, as in
+	// SparseMerkleProof.
+	Depth int
+}
+
+func (proof *SparseRunLengthMerkleProof) sanityCheck(th *treeHasher) bool {
+	// As for compact proofs, only the fields specific to run-length proofs
+	// are checked here; the de-compacted proof is checked when verified.
+	if proof.NumSideNodes < 0 || proof.NumSideNodes > th.maxSideNodes() || !th.checkDepth(proof.Depth) {
+		return false
+	}
+
+	// Check that the runs are in order, in range and do not overlap, so that
+	// the number of placeholders is bounded by NumSideNodes.
+	end, placeholders := 0, 0
+	for _, run := range proof.Runs {
+		if run.Start < end || run.Count < 1 || run.Count > proof.NumSideNodes-run.Start {
+			return false
+		}
+		end = run.Start + run.Count
+		placeholders += run.Count
+	}
+
+	// Check that the correct number of sidenodes have been supplied according
+	// to the runs.
+	return len(proof.SideNodes) == proof.NumSideNodes-placeholders
+}
+
+// ProveRunLength generates a run-length compacted Merkle proof for a key
+// against the current root.
+func (smt *SparseMerkleTree) ProveRunLength(key []byte) (SparseRunLengthMerkleProof, error) {
+	proof, err := smt.Prove(key)
+	if err != nil {
+		return SparseRunLengthMerkleProof{}, err
+	}
+	return runLengthProof(proof, &smt.th)
+}
+
+// RunLengthProof compacts a proof by encoding its placeholder side nodes as
+// runs, to reduce its size. The proof is checked for the domain prefixes given
+// by options, if any.
+func RunLengthProof(proof SparseMerkleProof, hasher hash.Hash, options ...VerifyOption) (SparseRunLengthMerkleProof, error) {
+	return runLengthProof(proof, newVerifyConfig(options).treeHasher(hasher))
+}
+
+func runLengthProof(proof SparseMerkleProof, th *treeHasher) (SparseRunLengthMerkleProof, error) {
+	if !proof.sanityCheck(th) {
+		return SparseRunLengthMerkleProof{}, ErrBadProof
+	}
+
+	var runs []PlaceholderRun
+	var compactedSideNodes [][]byte
+	for i, sideNode := range proof.SideNodes {
+		if !bytes.Equal(sideNode, th.placeholder()) {
+			node := make([]byte, th.hashSize())
+			copy(node, sideNode)
+			compactedSideNodes = append(compactedSideNodes, node)
+		} else if len(runs) > 0 && runs[len(runs)-1].Start+runs[len(runs)-1].Count == i {
+			runs[len(runs)-1].Count++
+		} else {
+			runs = append(runs, PlaceholderRun{Start: i, Count: 1})
+		}
+	}
+
+	return SparseRunLengthMerkleProof{
+		SideNodes:             compactedSideNodes,
+		NonMembershipLeafData: proof.NonMembershipLeafData,
+		Runs:                  runs,
+		NumSideNodes:          len(proof.SideNodes),
+		SiblingData:           proof.SiblingData,
+		Depth:                 proof.Depth,
+	}, nil
+}
+
+// DecompactRunLengthProof decompacts a run-length proof, so that it can be used
+// for VerifyProof.
+func DecompactRunLengthProof(proof SparseRunLengthMerkleProof, hasher hash.Hash) (SparseMerkleProof, error) {
+	th := newTreeHasher(hasher)
+
+	if !proof.sanityCheck(th) {
+		return SparseMerkleProof{}, ErrBadProof
+	}
+
+	decompactedSideNodes := make([][]byte, 0, proof.NumSideNodes)
+	position := 0
+	for _, run := range proof.Runs {
+		for len(decompactedSideNodes) < run.Start {
+			decompactedSideNodes = append(decompactedSideNodes, proof.SideNodes[position])
+			position++
+		}
+		for i := 0; i < run.Count; i++ {
+			decompactedSideNodes = append(decompactedSideNodes, th.placeholder())
+		}
+	}
+	decompactedSideNodes = append(decompactedSideNodes, proof.SideNodes[position:]...)
+
+	return SparseMerkleProof{
+		SideNodes:             decompactedSideNodes,
+		NonMembershipLeafData: proof.NonMembershipLeafData,
+		SiblingData:           proof.SiblingData,
+		Depth:                 proof.Depth,
+	}, nil
+}
+
+// VerifyRunLengthProof verifies a run-length compacted Merkle proof.
+func VerifyRunLengthProof(proof SparseRunLengthMerkleProof, root []byte, key []byte, value []byte, hasher hash.Hash, options ...VerifyOption) bool {
+	decompactedProof, err := DecompactRunLengthProof(proof, hasher)
+	if err != nil {
+		return false
+	}
+	return VerifyProof(decompactedProof, root, key, value, hasher, options...)
+}
@@ -0,0 +1,141 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestRunLengthProofs(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 100; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+
+	for i := 0; i < 120; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		value, _ := smt.Get(key)
+		proof, _ := smt.ProveUpdatable(key)
+		runProof, err := RunLengthProof(proof, sha256.New())
+		if err != nil {
+			t.Fatalf("returned error when compacting proof: %v", err)
+		}
+		decompacted, err := DecompactRunLengthProof(runProof, sha256.New())
+		if err != nil {
+			t.Fatalf("returned error when decompacting proof: %v", err)
+		}
+		if !reflect.DeepEqual(decompacted, proof) {
+			t.Fatalf("decompacted proof differs from original for key %s", key)
+		}
+		if !VerifyRunLengthProof(runProof, smt.Root(), key, value, sha256.New()) {
+			t.Errorf("run-length proof failed to verify for key %s", key)
+		}
+		if VerifyRunLengthProof(runProof, smt.Root(), key, []byte("wrongValue"), sha256.New()) {
+			t.Errorf("run-length proof verified for wrong value for key %s", key)
+		}
+	}
+
+	runProof, _ := smt.ProveRunLength([]byte("testKey1"))
+	if !VerifyRunLengthProof(runProof, smt.Root(), []byte("testKey1"), []byte("testValue1"), sha256.New()) {
+		t.Error("run-length proof from ProveRunLength failed to verify")
+	}
+}
+
+func TestRunLengthProofsSanityCheck(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 10; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	// Find a key whose proof has placeholders.
+	var key []byte
+	var runProof SparseRunLengthMerkleProof
+	for i := 0; len(runProof.Runs) == 0; i++ {
+		key = []byte(fmt.Sprintf("testKey%d", i))
+		runProof, _ = smt.ProveRunLength(key)
+	}
+	value, _ := smt.Get(key)
+
+	for _, runs := range [][]PlaceholderRun{
+		append(runProof.Runs, runProof.Runs...),    // Overlapping runs.
+		{{Start: 0, Count: 0}},                     // Empty run.
+		{{Start: -1, Count: 1}},                    // Out of range.
+		{{Start: 0, Count: smt.depth() + 1}},       // Longer than the proof.
+		{{Start: runProof.NumSideNodes, Count: 1}}, // Past the end.
+	} {
+		bad := runProof
+		bad.Runs = runs
+		if _, err := DecompactRunLengthProof(bad, sha256.New()); err == nil {
+			t.Errorf("did not return error for runs %v", runs)
+		}
+		if VerifyRunLengthProof(bad, smt.Root(), key, value, sha256.New()) {
+			t.Errorf("verified proof with runs %v", runs)
+		}
+	}
+
+	bad := runProof
+	bad.SideNodes = append(bad.SideNodes, bad.SideNodes...)
+	if _, err := DecompactRunLengthProof(bad, sha256.New()); err == nil {
+		t.Error("did not return error for proof with extra side nodes")
+	}
+	bad = runProof
+	bad.NumSideNodes = smt.depth() + 1
+	if _, err := DecompactRunLengthProof(bad, sha256.New()); err == nil {
+		t.Error("did not return error for proof with too many side nodes")
+	}
+}
+
+// Proof sizes count side nodes and placeholder encodings, with runs encoded
+// as a pair of uvarints.
+func benchmarkProofSizes(b *testing.B, smt *SparseMerkleTree, key []byte) {
+	proof, _ := smt.Prove(key)
+	b.Run("BitMask", func(b *testing.B) {
+		var size int
+		for i := 0; i < b.N; i++ {
+			compact, _ := compactProof(proof, &smt.th)
+			size = len(compact.SideNodes)*smt.th.pathSize() + len(compact.BitMask)
+		}
+		b.ReportMetric(float64(size), "bytes")
+	})
+	b.Run("RunLength", func(b *testing.B) {
+		var size int
+		buf := make([]byte, binary.MaxVarintLen64)
+		for i := 0; i < b.N; i++ {
+			runProof, _ := runLengthProof(proof, &smt.th)
+			size = len(runProof.SideNodes) * smt.th.pathSize()
+			for _, run := range runProof.Runs {
+				size += binary.PutUvarint(buf, uint64(run.Start)) + binary.PutUvarint(buf, uint64(run.Count))
+			}
+		}
+		b.ReportMetric(float64(size), "bytes")
+	})
+}
+
+func BenchmarkRunLengthProof_SingleKey(b *testing.B) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	benchmarkProofSizes(b, smt, []byte("testKey"))
+}
+
+func BenchmarkRunLengthProof_SparseRegion(b *testing.B) {
+	// Two keys whose paths share a long prefix have a proof made mostly of
+	// consecutive placeholders.
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	seen := make(map[string][]byte)
+	r := rand.New(rand.NewSource(1))
+	for {
+		key := make([]byte, 8)
+		r.Read(key)
+		prefix := string(smt.th.path(key)[:2])
+		if other, ok := seen[prefix]; ok && !bytes.Equal(other, key) {
+			smt.Update(other, []byte("testValue"))
+			smt.Update(key, []byte("testValue"))
+			benchmarkProofSizes(b, smt, key)
+			return
+		}
+		seen[prefix] = key
+	}
+}
@@ -0,0 +1,82 @@
+package smt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+)
+
+// Scrub walks the nodes under the current root, checking that each is stored
+// intact: that its data is well formed and hashes to the hash it is stored
+// under, so that the hash of a branch is recomputed from the stored hashes of
+// its children. It returns the hashes of corrupt nodes, including missing
+// ones, without descending below them, to flag bit rot in the node store.
+// Other store errors stop the scrub.
+//
+// Updates are blocked while the tree is scrubbed. If ctx is cancelled, the
+// scrub stops, returning the corrupt nodes found so far and the error of ctx,
+// so that a background scrubber can bound each run.
+func (smt *SparseMerkleTree) Scrub(ctx context.Context) (corrupt [][]byte, err error) {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	err = smt.scrubNode(ctx, smt.root, &corrupt)
+	if n := len(corrupt); n > 0 {
+		smt.warnf("scrub found %d corrupt nodes under root %x", n, smt.root)
+	}
+	return corrupt, err
+}
+
+func (smt *SparseMerkleTree) scrubNode(ctx context.Context, hash []byte, corrupt *[][]byte) error {
+	if bytes.Equal(hash, smt.th.placeholder()) {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	stored, err := smt.nodes.Get(hash)
+	if errors.Is(err, ErrKeyNotFound) {
+		*corrupt = append(*corrupt, hash)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !smt.th.validEncodedNode(stored) {
+		*corrupt = append(*corrupt, hash)
+		return nil
+	}
+	data := smt.th.decodeNode(stored)
+	if !smt.th.validNode(data) || !bytes.Equal(smt.th.digest(data), hash) {
+		*corrupt = append(*corrupt, hash)
+		return nil
+	}
+	if smt.th.isLeaf(data) {
+		return nil
+	}
+
+	leftNode, rightNode := smt.th.parseNode(data)
+	if err := smt.scrubNode(ctx, leftNode, corrupt); err != nil {
+		return err
+	}
+	return smt.scrubNode(ctx, rightNode, corrupt)
+}
+
+// validEncodedNode returns false if data is in the compact encoding but
+// malformed, so that it cannot be decoded.
+func (th *treeHasher) validEncodedNode(data []byte) bool {
+	if len(data) == 0 || !bytes.Equal(data[:len(compactNodePrefix)], compactNodePrefix) {
+		return true
+	}
+	if len(data) < len(compactNodePrefix)+1 {
+		return false
+	}
+	presence := data[len(compactNodePrefix)]
+	children := 0
+	switch presence {
+	case leftPresent, rightPresent:
+		children = 1
+	case 0:
+	default:
+		return false
+	}
+	return len(data) == len(compactNodePrefix)+1+children*th.hashSize()
+}
@@ -0,0 +1,62 @@
+package smt
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestScrub(t *testing.T) {
+	smn := NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, NewSimpleMap(), sha256.New(), WithCompactNodes())
+	for i := 0; i < 50; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	corrupt, err := smt.Scrub(context.Background())
+	if err != nil || len(corrupt) != 0 {
+		t.Fatalf("scrub of intact tree found %d corrupt nodes, %v", len(corrupt), err)
+	}
+
+	var leafHash, compactHash []byte
+	for hash, data := range smn.m {
+		if smt.th.isLeaf(data) {
+			leafHash = []byte(hash)
+		} else if bytes.HasPrefix(data, compactNodePrefix) {
+			compactHash = []byte(hash)
+		}
+	}
+	if compactHash == nil {
+		t.Fatal("tree has no compact nodes")
+	}
+	leafData, compactData := smn.m[string(leafHash)], smn.m[string(compactHash)]
+	for _, c := range []struct {
+		name    string
+		hash    []byte
+		corrupt func()
+	}{
+		{"flipped leaf", leafHash, func() {
+			smn.m[string(leafHash)] = append(append([]byte(nil), leafData[:len(leafData)-1]...), leafData[len(leafData)-1]^1)
+		}},
+		{"missing leaf", leafHash, func() { delete(smn.m, string(leafHash)) }},
+		{"truncated compact node", compactHash, func() { smn.m[string(compactHash)] = compactData[:10] }},
+	} {
+		c.corrupt()
+		corrupt, err := smt.Scrub(context.Background())
+		if err != nil {
+			t.Errorf("returned error when scrubbing %s: %v", c.name, err)
+		}
+		if len(corrupt) != 1 || !bytes.Equal(corrupt[0], c.hash) {
+			t.Errorf("scrub did not flag only the %s, got %d nodes", c.name, len(corrupt))
+		}
+		smn.m[string(leafHash)], smn.m[string(compactHash)] = leafData, compactData
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := smt.Scrub(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("did not return context.Canceled for cancelled scrub, got %v", err)
+	}
+}
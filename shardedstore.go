@@ -0,0 +1,122 @@
+package smt
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// ShardedStore is a MapStore that spreads keys over several inner stores by a
+// hash of the key, each guarded by its own lock, so that concurrent accesses
+// to different shards do not contend and no single map grows to hold every
+// entry. Gets on the same shard may run concurrently, so the shards must
+// support concurrent reads, as SimpleMap does.
+type ShardedStore struct {
+	shards []shard
+}
+
+type shard struct {
+	mu    sync.RWMutex
+	store MapStore
+}
+
+// NewShardedStore creates a ShardedStore over the given shards, e.g. n
+// SimpleMaps. Keys are placed by a fixed hash, so to reopen a store over
+// persistent shards, pass the same shards in the same order.
+func NewShardedStore(shards ...MapStore) *ShardedStore {
+	if len(shards) == 0 {
+		panic("smt: no shards")
+	}
+	ss := &ShardedStore{shards: make([]shard, len(shards))}
+	for i, store := range shards {
+		if store == nil {
+			panic("smt: nil shard")
+		}
+		ss.shards[i].store = store
+	}
+	return ss
+}
+
+func (ss *ShardedStore) shard(key []byte) *shard {
+	h := fnv.New32a()
+	h.Write(key)
+	return &ss.shards[h.Sum32()%uint32(len(ss.shards))]
+}
+
+// Get gets the value for a key.
+func (ss *ShardedStore) Get(key []byte) ([]byte, error) {
+	s := ss.shard(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.store.Get(key)
+}
+
+// Set updates the value for a key.
+func (ss *ShardedStore) Set(key []byte, value []byte) error {
+	s := ss.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.Set(key, value)
+}
+
+// Delete deletes a key.
+func (ss *ShardedStore) Delete(key []byte) error {
+	s := ss.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.Delete(key)
+}
+
+// Export merges the entries of all the shards into a single checksummed gob
+// serial, in the same format as SimpleMap.Export, so that it can be imported
+// with ImportMerkleMap. Writes are blocked until every shard is exported, so
+// that the export is of a single point in time.
+func (ss *ShardedStore) Export() ([]byte, error) {
+	for i := range ss.shards {
+		ss.shards[i].mu.RLock()
+		defer ss.shards[i].mu.RUnlock()
+	}
+	m := make(map[string][]byte)
+	for i := range ss.shards {
+		serial, err := ss.shards[i].store.Export()
+		if err != nil {
+			return nil, err
+		}
+		var entries map[string][]byte
+		if err := decodeSnapshot(serial, &entries); err != nil {
+			return nil, err
+		}
+		for key, value := range entries {
+			m[key] = value
+		}
+	}
+	return encodeSnapshot(m)
+}
+
+// Flush flushes the shards that are ClosableStores.
+func (ss *ShardedStore) Flush() error {
+	for i := range ss.shards {
+		s := &ss.shards[i]
+		s.mu.Lock()
+		err := flushStore(s.store)
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the shards that are ClosableStores, even once one fails,
+// returning the first error.
+func (ss *ShardedStore) Close() error {
+	var first error
+	for i := range ss.shards {
+		s := &ss.shards[i]
+		s.mu.Lock()
+		if err := closeStore(s.store); err != nil && first == nil {
+			first = err
+		}
+		s.mu.Unlock()
+	}
+	return first
+}
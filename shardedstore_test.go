@@ -0,0 +1,126 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func newShardedSimpleMap(n int) (*ShardedStore, []*SimpleMap) {
+	maps := make([]*SimpleMap, n)
+	shards := make([]MapStore, n)
+	for i := range maps {
+		maps[i] = NewSimpleMap()
+		shards[i] = maps[i]
+	}
+	return NewShardedStore(shards...), maps
+}
+
+func TestShardedStore(t *testing.T) {
+	ss, maps := newShardedSimpleMap(8)
+	for i := 0; i < 1000; i++ {
+		if err := ss.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i))); err != nil {
+			t.Fatalf("returned error when setting key: %v", err)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		value, err := ss.Get([]byte(fmt.Sprintf("key%d", i)))
+		if err != nil || !bytes.Equal(value, []byte(fmt.Sprintf("value%d", i))) {
+			t.Fatalf("did not get value of key%d, got %s, %v", i, value, err)
+		}
+	}
+	total := 0
+	for i, m := range maps {
+		if len(m.m) == 0 {
+			t.Errorf("shard %d holds no keys", i)
+		}
+		total += len(m.m)
+	}
+	if total != 1000 {
+		t.Errorf("shards hold %d keys, expected 1000", total)
+	}
+
+	if err := ss.Delete([]byte("key0")); err != nil {
+		t.Errorf("returned error when deleting key: %v", err)
+	}
+	var invalidKeyError *InvalidKeyError
+	if _, err := ss.Get([]byte("key0")); !errors.As(err, &invalidKeyError) {
+		t.Errorf("did not return InvalidKeyError for deleted key, got %v", err)
+	}
+	if err := ss.Delete([]byte("key0")); !errors.As(err, &invalidKeyError) {
+		t.Errorf("did not return InvalidKeyError when deleting missing key, got %v", err)
+	}
+
+	// The export merges the shards into a single map.
+	serial, err := ss.Export()
+	if err != nil {
+		t.Fatalf("returned error when exporting: %v", err)
+	}
+	imported, _, err := ImportMerkleMap(serial, serial)
+	if err != nil {
+		t.Fatalf("returned error when importing export: %v", err)
+	}
+	if len(imported.m) != 999 {
+		t.Errorf("export holds %d keys, expected 999", len(imported.m))
+	}
+	value, err := imported.Get([]byte("key1"))
+	if err != nil || !bytes.Equal(value, []byte("value1")) {
+		t.Errorf("did not get value of key1 from export, got %s, %v", value, err)
+	}
+}
+
+func TestShardedStoreConcurrentTree(t *testing.T) {
+	nodes, _ := newShardedSimpleMap(4)
+	values, _ := newShardedSimpleMap(4)
+	smt := NewSparseMerkleTreeWithHasherFunc(nodes, values, sha256.New)
+	plain := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		smt.Update(key, []byte("testValue"))
+		plain.Update(key, []byte("testValue"))
+	}
+	if !bytes.Equal(smt.Root(), plain.Root()) {
+		t.Error("tree on sharded stores has a different root")
+	}
+
+	// Concurrent readers and a writer share the shards.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			smt.Update([]byte(fmt.Sprintf("newKey%d", i)), []byte("newValue"))
+		}
+	}()
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				value, err := smt.Get([]byte(fmt.Sprintf("testKey%d", i)))
+				if err != nil || !bytes.Equal(value, []byte("testValue")) {
+					t.Errorf("did not get value of key, got %s, %v", value, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	wrap, err := ExportTrie(smt)
+	if err != nil {
+		t.Fatalf("returned error when exporting trie: %v", err)
+	}
+	smn, smv, err := ImportMerkleMap(wrap.NodesBytes, wrap.ValuesBytes)
+	if err != nil {
+		t.Fatalf("returned error when importing trie: %v", err)
+	}
+	imported := ImportSparseMerkleTree(smn, smv, sha256.New(), wrap.Root)
+	value, err := imported.Get([]byte("newKey7"))
+	if err != nil || !bytes.Equal(value, []byte("newValue")) {
+		t.Errorf("did not get value from imported trie, got %s, %v", value, err)
+	}
+}
@@ -4,7 +4,10 @@ package smt
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"hash"
+	"sync"
+	"time"
 )
 
 const (
@@ -15,15 +18,100 @@ var defaultValue = []byte{}
 
 var errKeyAlreadyEmpty = errors.New("key already empty")
 
+// ErrSealed is returned when attempting to modify a sealed tree.
+var ErrSealed = errors.New("tree is sealed")
+
+// ErrBadValueHash is returned by UpdateLeafHash when the value hash is not the
+// size of the tree's hasher.
+var ErrBadValueHash = errors.New("value hash has wrong size")
+
+// ErrValueMissing is returned by Get, in a tree created with
+// WithMissingValueCheck, for a key that has a leaf in the tree but no value
+// in the value store.
+var ErrValueMissing = errors.New("key is in the tree but its value is missing")
+
+// ErrValueSize is returned when setting a value of the wrong size in a tree
+// created with WithFixedValueSize.
+var ErrValueSize = errors.New("value has wrong size")
+
 // SparseMerkleTree is a Sparse Merkle tree.
 type SparseMerkleTree struct {
 	th            treeHasher
 	nodes, values MapStore
+	keys          MapStore
+	versions      MapStore
 	root          []byte
+
+	buildParallelism int
+	sealed           bool
+	compactNodes     bool
+	nodeCache        *nodeCache
+
+	encodeValue, decodeValue func([]byte) ([]byte, error)
+
+	logger  Logger
+	metrics Metrics
+
+	retention        *retention
+	archive          *archive
+	checkPrunedRoots bool
+	checkNodeHashes  bool
+	verifyImport     bool
+	operationLimit   int
+	wal              MapStore
+	lazy             *lazyWrites
+
+	// defaultLeafValue is the value of absent keys, if set with
+	// WithDefaultValue.
+	defaultLeafValue []byte
+	// storeDefaultValue is set by WithStorableDefaultValue.
+	storeDefaultValue bool
+	// checkMissingValues is set by WithMissingValueCheck.
+	checkMissingValues bool
+	// valueSize is the size of every value, if set with WithFixedValueSize.
+	valueSize int
+
+	rootCallbacks []func(oldRoot, newRoot []byte)
+	observers     []*observer
+	// observing is set while a change of root records events for observers
+	// in events.
+	observing bool
+	events    []treeEvent
+
+	// snapshots are the snapshots taken with Snapshot and not yet released.
+	snapshots map[*ReadOnlyTree]struct{}
+
+	// mu serialises updates, and excludes them while a consistent snapshot of
+	// the tree is taken for export.
+	mu sync.RWMutex
+	// reads is the lock of reads and updates set with WithConcurrentReads;
+	// see readLock.
+	reads *sync.RWMutex
+}
+
+// Stores of a tree, as indexed by write-ahead log records and snapshots.
+const (
+	nodeStore = iota
+	valueStore
+	keyStore
+)
+
+// store returns the store of the tree at index, or nil if there is none.
+func (smt *SparseMerkleTree) store(index int) MapStore {
+	switch index {
+	case nodeStore:
+		return smt.nodes
+	case valueStore:
+		return smt.values
+	case keyStore:
+		return smt.keys
+	}
+	return nil
 }
 
 // NewSparseMerkleTree creates a new Sparse Merkle tree on an empty MapStore.
 func NewSparseMerkleTree(nodes, values MapStore, hasher hash.Hash, options ...Option) *SparseMerkleTree {
+	checkStores(nodes, values)
 	smt := SparseMerkleTree{
 		th:     *newTreeHasher(hasher),
 		nodes:  nodes,
@@ -34,115 +122,603 @@ func NewSparseMerkleTree(nodes, values MapStore, hasher hash.Hash, options ...Op
 		option(&smt)
 	}
 
-	smt.SetRoot(smt.th.placeholder())
+	smt.root = smt.th.placeholder()
+
+	return &smt
+}
+
+// NewSparseMerkleTreeWithHasherFunc creates a new Sparse Merkle tree on an
+// empty MapStore, calling newHasher to obtain a separate hash.Hash for each
+// concurrent hashing operation instead of serialising them on a single one.
+func NewSparseMerkleTreeWithHasherFunc(nodes, values MapStore, newHasher func() hash.Hash, options ...Option) *SparseMerkleTree {
+	checkStores(nodes, values)
+	smt := SparseMerkleTree{
+		th:     *newTreeHasherFunc(newHasher),
+		nodes:  nodes,
+		values: values,
+	}
+
+	for _, option := range options {
+		option(&smt)
+	}
+
+	smt.root = smt.th.placeholder()
 
 	return &smt
 }
 
 // ImportSparseMerkleTree imports a Sparse Merkle tree from a non-empty MapStore.
-func ImportSparseMerkleTree(nodes, values MapStore, hasher hash.Hash, root []byte) *SparseMerkleTree {
+func ImportSparseMerkleTree(nodes, values MapStore, hasher hash.Hash, root []byte, options ...Option) *SparseMerkleTree {
+	checkStores(nodes, values)
 	smt := SparseMerkleTree{
 		th:     *newTreeHasher(hasher),
 		nodes:  nodes,
 		values: values,
 		root:   root,
 	}
+
+	for _, option := range options {
+		option(&smt)
+	}
+
+	return &smt
+}
+
+// ImportSparseMerkleTreeWithHasherFunc imports a Sparse Merkle tree from a
+// non-empty MapStore, like ImportSparseMerkleTree, with a hash.Hash
+// constructor as in NewSparseMerkleTreeWithHasherFunc.
+func ImportSparseMerkleTreeWithHasherFunc(nodes, values MapStore, newHasher func() hash.Hash, root []byte, options ...Option) *SparseMerkleTree {
+	checkStores(nodes, values)
+	smt := SparseMerkleTree{
+		th:     *newTreeHasherFunc(newHasher),
+		nodes:  nodes,
+		values: values,
+		root:   root,
+	}
+
+	for _, option := range options {
+		option(&smt)
+	}
+
 	return &smt
 }
 
+// ErrRootNotFound is returned by AttachTree when the root node is not in the
+// node store.
+var ErrRootNotFound = errors.New("root node is not in the node store")
+
+// ErrBadRootNode is returned by AttachTree when the data stored for the root
+// node does not hash to the root.
+var ErrBadRootNode = errors.New("root node does not hash to the root")
+
+// ErrRootMismatch is matched by errors.Is for an error caused by a root that
+// is not the one expected: ErrBadRootNode, ErrTxConflict, the ErrDiffMismatch
+// of a diff from or to another root, and AssertSameRoot when the trees
+// differ.
+var ErrRootMismatch = errors.New("root mismatch")
+
+// rootMismatchError is an error of another kind, such as ErrDiffMismatch,
+// that errors.Is also matches to ErrRootMismatch.
+type rootMismatchError struct {
+	err error
+}
+
+// rootMismatch returns an error formatted as fmt.Errorf does, which errors.Is
+// also matches to ErrRootMismatch.
+func rootMismatch(format string, args ...interface{}) error {
+	return &rootMismatchError{err: fmt.Errorf(format, args...)}
+}
+
+func (e *rootMismatchError) Error() string {
+	return e.err.Error()
+}
+
+func (e *rootMismatchError) Is(target error) bool {
+	return target == ErrRootMismatch
+}
+
+func (e *rootMismatchError) Unwrap() error {
+	return e.err
+}
+
+// AttachTree attaches a Sparse Merkle tree to stores populated out of band,
+// such as a database loaded directly, at root, like ImportSparseMerkleTree,
+// but checks first that the root node is in the node store and hashes to
+// root, so that a tree given the wrong stores or root fails here rather than
+// on first use. ErrRootNotFound is returned if the node is missing, and
+// ErrBadRootNode if its data does not hash to root. Only the root node is
+// checked; use Scrub to check every node under it.
+func AttachTree(nodes, values MapStore, root []byte, hasher hash.Hash, options ...Option) (*SparseMerkleTree, error) {
+	smt := ImportSparseMerkleTree(nodes, values, hasher, root, options...)
+	if bytes.Equal(root, smt.th.placeholder()) {
+		return smt, nil
+	}
+	data, err := smt.getNode(root)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, fmt.Errorf("%w: %x", ErrRootNotFound, root)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting root node %x: %w", root, err)
+	}
+	if !smt.th.validNode(data) || !bytes.Equal(smt.th.digest(data), root) {
+		return nil, rootMismatch("%w: %x", ErrBadRootNode, root)
+	}
+	return smt, nil
+}
+
+// checkStores panics if either store is nil, rather than letting the first
+// operation on the tree fail with a nil dereference.
+func checkStores(nodes, values MapStore) {
+	if nodes == nil {
+		panic("smt: nil nodes store")
+	}
+	if values == nil {
+		panic("smt: nil values store")
+	}
+}
+
 // Root gets the root of the tree.
 func (smt *SparseMerkleTree) Root() []byte {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
 	return smt.root
 }
 
-// SetRoot sets the root of the tree.
-func (smt *SparseMerkleTree) SetRoot(root []byte) {
-	smt.root = root
+// SetRoot sets the root of the tree. It returns ErrSealed for a sealed tree,
+// or the error of a write-ahead log failing to record the change.
+func (smt *SparseMerkleTree) SetRoot(root []byte) error {
+	_, err := smt.changeRoot(func([]byte) ([]byte, error) {
+		if smt.sealed {
+			return nil, ErrSealed
+		}
+		return root, nil
+	})
+	return err
+}
+
+// Seal marks the tree as read-only, so that any further modification returns
+// ErrSealed until Unseal is called. This guards a tree whose state has
+// already been exported against accidental updates.
+func (smt *SparseMerkleTree) Seal() {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+	smt.sealed = true
+}
+
+// Unseal re-enables modifications on a sealed tree.
+func (smt *SparseMerkleTree) Unseal() {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+	smt.sealed = false
+}
+
+// IsSealed returns true if the tree is sealed.
+func (smt *SparseMerkleTree) IsSealed() bool {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	return smt.sealed
 }
 
 func (smt *SparseMerkleTree) depth() int {
 	return smt.th.pathSize() * 8
 }
 
+// PathBits returns the directions from the root to the leaf of key, one per
+// bit of the key's path, most significant bit first: element i is the
+// direction taken at depth i, true for right. This is the convention of the
+// prefixes taken by SubtreeHashesAt and LeafAt.
+//
+// A proof with n side nodes uses the first n directions, with the side nodes
+// ordered from the leaf up: SideNodes[j] is the sibling at depth n-1-j, on
+// the side opposite PathBits(key)[n-1-j].
+func (smt *SparseMerkleTree) PathBits(key []byte) []bool {
+	path := smt.th.path(key)
+	bits := make([]bool, smt.depth())
+	for i := range bits {
+		bits[i] = getBitAtFromMSB(path, i) == right
+	}
+	return bits
+}
+
+// WithDefaultValue sets the value that stands for an absent key, which Get
+// returns for keys that are not in the tree and which deletes a key when it
+// is set. It replaces the empty value, which can then be stored like any
+// other. Proofs from such a tree must be verified with
+// WithVerifyDefaultValue and the same value. The value must not be nil, as
+// nil and empty values are not told apart.
+func WithDefaultValue(value []byte) Option {
+	if value == nil {
+		panic("smt: nil default value")
+	}
+	return func(smt *SparseMerkleTree) {
+		smt.defaultLeafValue = value
+	}
+}
+
+// WithStorableDefaultValue makes the default value a value like any other:
+// setting a key to it stores a leaf for it instead of deleting the key, so
+// that only Delete removes keys. Get still returns the default value for
+// absent keys, and Has reports keys holding it as absent. The membership
+// proof of a stored default value does not verify with VerifyProof, which
+// takes the default value for absence; verify it with
+// VerifyProofWithValueHash and the digest of the value instead.
+//
+// Without this option, setting a key to the default value deletes it, as
+// reported by IsDeletionValue. Trees built or updated from each other, e.g.
+// with BuildFromSorted or VerifyBatchUpdate, follow the default policy.
+func WithStorableDefaultValue() Option {
+	return func(smt *SparseMerkleTree) {
+		smt.storeDefaultValue = true
+	}
+}
+
+// WithMissingValueCheck makes Get return ErrValueMissing for a key whose leaf
+// is in the tree but whose value is missing from the value store, e.g. after
+// the stores of the tree drift apart or a partial import, instead of the
+// default value, so that callers can tell a key that is not in the tree from
+// one whose value was not synced. A Get that finds no value then walks the
+// tree to the key's leaf. Presence leaves commit to no value and still read
+// as the default value, but keys set with UpdateLeafHash, which stores no
+// value, return ErrValueMissing.
+func WithMissingValueCheck() Option {
+	return func(smt *SparseMerkleTree) {
+		smt.checkMissingValues = true
+	}
+}
+
+// WithFixedValueSize requires every value stored in the tree to be n bytes,
+// such as the digests of a hash function, catching values of the wrong size
+// where they are written: Update, and the other methods storing values,
+// return ErrValueSize for any other value, except for the default value when
+// it deletes the key. The value store holds values as is, without their
+// length, so fixed-size values take exactly n bytes each. Proofs from such a
+// tree should be verified with WithVerifyValueSize and the same size.
+func WithFixedValueSize(n int) Option {
+	if n <= 0 {
+		panic("smt: non-positive value size")
+	}
+	return func(smt *SparseMerkleTree) {
+		smt.valueSize = n
+	}
+}
+
+// checkValueSize returns ErrValueSize if the values of the tree are of a fixed
+// size and value is not of that size.
+func (smt *SparseMerkleTree) checkValueSize(value []byte) error {
+	if smt.valueSize != 0 && len(value) != smt.valueSize {
+		return fmt.Errorf("%w: %d bytes, expected %d", ErrValueSize, len(value), smt.valueSize)
+	}
+	return nil
+}
+
+// IsDeletionValue returns true if setting a key to value with Update deletes
+// the key rather than storing value, i.e. if value is the default value and
+// the tree was not created with WithStorableDefaultValue.
+func (smt *SparseMerkleTree) IsDeletionValue(value []byte) bool {
+	return !smt.storeDefaultValue && bytes.Equal(value, smt.emptyValue())
+}
+
+// emptyValue returns the value of absent keys.
+func (smt *SparseMerkleTree) emptyValue() []byte {
+	if smt.defaultLeafValue == nil {
+		return defaultValue
+	}
+	return smt.defaultLeafValue
+}
+
 // Get gets the value of a key from the tree.
 func (smt *SparseMerkleTree) Get(key []byte) ([]byte, error) {
+	if smt.metrics != nil {
+		start := time.Now()
+		value, err := smt.get(key)
+		smt.observe(OperationGet, start, err)
+		return value, err
+	}
+	return smt.get(key)
+}
+
+func (smt *SparseMerkleTree) get(key []byte) ([]byte, error) {
+	if smt.lazy != nil {
+		if value, ok := smt.staged(key); ok {
+			if value == nil {
+				return smt.emptyValue(), nil
+			}
+			return value, nil
+		}
+	}
+	defer smt.readLock()()
 	// Get tree's root
 	root := smt.Root()
 
 	if bytes.Equal(root, smt.th.placeholder()) {
 		// The tree is empty, return the default value.
-		return defaultValue, nil
+		return smt.emptyValue(), nil
 	}
 
 	path := smt.th.path(key)
-	value, err := smt.values.Get(path)
+	if other, err := smt.otherKey(path, key); err != nil || other {
+		// The path belongs to another key; see ErrPathCollision.
+		return smt.emptyValue(), err
+	}
+	value, err := smt.getValue(path)
 
 	if err != nil {
-		var invalidKeyError *InvalidKeyError
-
-		if errors.As(err, &invalidKeyError) {
+		if errors.Is(err, ErrKeyNotFound) {
+			if smt.checkMissingValues {
+				if err := smt.checkValueMissing(key, path, root); err != nil {
+					return nil, err
+				}
+			}
 			// If key isn't found, return default value
-			return defaultValue, nil
+			return smt.emptyValue(), nil
 		} else {
 			// Otherwise percolate up any other error
-			return nil, err
+			return nil, fmt.Errorf("getting value of %x: %w", key, err)
 		}
 	}
 	return value, nil
 }
 
-// Has returns true if the value at the given key is non-default, false
-// otherwise.
+// checkValueMissing returns ErrValueMissing if the tree at root has a value
+// leaf at path.
+func (smt *SparseMerkleTree) checkValueMissing(key []byte, path []byte, root []byte) error {
+	_, _, leafData, _, err := smt.sideNodesForRoot(path, root, false)
+	if err != nil || leafData == nil {
+		return err
+	}
+	leafPath, valueHash := smt.th.parseLeaf(leafData)
+	if bytes.Equal(leafPath, path) && len(valueHash) != 0 {
+		return fmt.Errorf("%w: %x", ErrValueMissing, key)
+	}
+	return nil
+}
+
+// Has returns true if the tree has a leaf for key, false otherwise. Only the
+// nodes on the path of the key are read, not its value, so an absent key is
+// never an error while a failing store is; keys set with UpdateLeafHash or
+// UpdatePresence are present, as is a key set to the default value in a tree
+// created with WithStorableDefaultValue. With WithMissingValueCheck, a key
+// whose value is missing from the value store returns ErrValueMissing, as Get
+// does.
 func (smt *SparseMerkleTree) Has(key []byte) (bool, error) {
-	val, err := smt.Get(key)
-	return !bytes.Equal(defaultValue, val), err
+	if smt.lazy != nil {
+		if value, ok := smt.staged(key); ok {
+			return value != nil, nil
+		}
+	}
+	defer smt.readLock()()
+	root := smt.Root()
+
+	path := smt.th.path(key)
+	if other, err := smt.otherKey(path, key); err != nil || other {
+		// The path belongs to another key; see ErrPathCollision.
+		return false, err
+	}
+	valueHash, err := smt.leafValueHash(path, root)
+	if err != nil || valueHash == nil {
+		return false, err
+	}
+	if smt.checkMissingValues && len(valueHash) != 0 {
+		_, err := smt.getValue(path)
+		if errors.Is(err, ErrKeyNotFound) {
+			return false, fmt.Errorf("%w: %x", ErrValueMissing, key)
+		}
+		if err != nil {
+			return false, fmt.Errorf("getting value of %x: %w", key, err)
+		}
+	}
+	return true, nil
 }
 
 // Update sets a new value for a key in the tree, and sets and returns the new root of the tree.
+// The key and value are copied, so callers may reuse their buffers afterwards.
+// Setting the default value deletes the key; see IsDeletionValue.
+// Keys of any length are accepted: they are hashed to fixed-width paths, and
+// only stored as is in the key store of a tree created with WithKeyStore.
+// In a tree created with WithLazyCommit the write is staged for Commit, and
+// the root returned is that of the last Commit.
 func (smt *SparseMerkleTree) Update(key []byte, value []byte) ([]byte, error) {
-	newRoot, err := smt.UpdateForRoot(key, value, smt.Root())
-	if err != nil {
-		return nil, err
+	if smt.metrics != nil {
+		start := time.Now()
+		root, err := smt.update(key, value)
+		smt.observe(OperationUpdate, start, err)
+		return root, err
 	}
-	smt.SetRoot(newRoot)
-	return newRoot, nil
+	return smt.update(key, value)
+}
+
+func (smt *SparseMerkleTree) update(key []byte, value []byte) ([]byte, error) {
+	if smt.lazy != nil {
+		if smt.IsDeletionValue(value) {
+			value = nil
+		}
+		return smt.stage(key, value)
+	}
+	return smt.changeRoot(func(root []byte) ([]byte, error) {
+		return smt.updateForRoot(key, value, root)
+	})
 }
 
-// Delete deletes a value from tree. It returns the new root of the tree.
+// Delete deletes a value from tree. It returns the new root of the tree, or
+// stages the deletion for Commit, as Update does, in a tree created with
+// WithLazyCommit.
 func (smt *SparseMerkleTree) Delete(key []byte) ([]byte, error) {
-	return smt.Update(key, defaultValue)
+	if smt.metrics != nil {
+		start := time.Now()
+		root, err := smt.delete(key)
+		smt.observe(OperationDelete, start, err)
+		return root, err
+	}
+	return smt.delete(key)
+}
+
+func (smt *SparseMerkleTree) delete(key []byte) ([]byte, error) {
+	if smt.lazy != nil {
+		return smt.stage(key, nil)
+	}
+	return smt.changeRoot(func(root []byte) ([]byte, error) {
+		return smt.deleteForRoot(key, root)
+	})
 }
 
 // UpdateForRoot sets a new value for a key in the tree at a specific root, and returns the new root.
 func (smt *SparseMerkleTree) UpdateForRoot(key []byte, value []byte, root []byte) ([]byte, error) {
+	defer smt.writeLock()()
+	return smt.updateForRoot(key, value, root)
+}
+
+func (smt *SparseMerkleTree) updateForRoot(key []byte, value []byte, root []byte) ([]byte, error) {
+	if smt.IsDeletionValue(value) {
+		return smt.deleteForRoot(key, root)
+	}
+	if smt.sealed {
+		return nil, ErrSealed
+	}
 	path := smt.th.path(key)
 	sideNodes, pathNodes, oldLeafData, _, err := smt.sideNodesForRoot(path, root, false)
 	if err != nil {
 		return nil, err
 	}
+	if err := smt.checkKey(path, key, oldLeafData); err != nil {
+		return nil, err
+	}
+	var oldValue []byte
+	if smt.observing {
+		if oldValue, err = smt.observedValue(path); err != nil {
+			return nil, err
+		}
+	}
+	newRoot, err := smt.updateWithSideNodes(path, smt.th.digestValue(value), value, sideNodes, pathNodes, oldLeafData)
+	if err != nil {
+		return nil, err
+	}
+	if err := smt.setKey(path, key); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(newRoot, root) {
+		smt.recordEvent(treeEvent{key: key, oldValue: oldValue, newValue: value})
+	}
+	return newRoot, nil
+}
 
-	var newRoot []byte
-	if bytes.Equal(value, defaultValue) {
-		// Delete operation.
-		newRoot, err = smt.deleteWithSideNodes(path, sideNodes, pathNodes, oldLeafData)
-		if errors.Is(err, errKeyAlreadyEmpty) {
-			// This key is already empty; return the old root.
-			return root, nil
+func (smt *SparseMerkleTree) deleteForRoot(key []byte, root []byte) ([]byte, error) {
+	if smt.sealed {
+		return nil, ErrSealed
+	}
+	path := smt.th.path(key)
+	sideNodes, pathNodes, oldLeafData, _, err := smt.sideNodesForRoot(path, root, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := smt.checkKey(path, key, oldLeafData); err != nil {
+		return nil, err
+	}
+	var oldValue []byte
+	if smt.observing {
+		if oldValue, err = smt.observedValue(path); err != nil {
+			return nil, err
 		}
-		if err := smt.values.Delete(path); err != nil {
+	}
+	newRoot, err := smt.deleteWithSideNodes(path, sideNodes, pathNodes, oldLeafData)
+	if errors.Is(err, errKeyAlreadyEmpty) {
+		// This key is already empty; return the old root.
+		return root, nil
+	}
+	if err == nil {
+		smt.recordEvent(treeEvent{key: key, oldValue: oldValue, deleted: true})
+	}
+	if err := smt.deleteValue(path); err != nil {
+		return nil, err
+	}
+	if err := smt.deleteKey(path); err != nil {
+		return nil, err
+	}
+	return newRoot, err
+}
+
+// UpdateLeafHash places a leaf committing to valueHash at the path of key,
+// without the value itself, and sets and returns the new root of the tree.
+// valueHash takes the place of the digest of the value under the tree's
+// hasher, so it can be carried over from another system that already hashed
+// the values. The value store is not written: Get returns the default value
+// for such keys, while proofs for them verify with VerifyProofWithValueHash.
+// To store no values at all, create the tree on a NilValueStore.
+func (smt *SparseMerkleTree) UpdateLeafHash(key []byte, valueHash []byte) ([]byte, error) {
+	if len(valueHash) != smt.th.hashSize() {
+		return nil, ErrBadValueHash
+	}
+	return smt.changeRoot(func(root []byte) ([]byte, error) {
+		return smt.updateLeafHashForRoot(key, valueHash, root)
+	})
+}
+
+// UpdatePresence places a presence leaf at the path of key, committing to the
+// key alone, and sets and returns the new root of the tree. A value leaf is
+// hashed as 0x00||path||valueHash, while a presence leaf is hashed as
+// 0x03||path and covers no value bytes at all, so the key's value, if it has
+// one, lives entirely off the tree. Any value stored
+// for the key is deleted. Get returns the default value for such keys, while
+// proofs for them verify with VerifyPresenceProof; Delete removes them as any
+// other key.
+func (smt *SparseMerkleTree) UpdatePresence(key []byte) ([]byte, error) {
+	return smt.changeRoot(func(root []byte) ([]byte, error) {
+		return smt.updateLeafHashForRoot(key, []byte{}, root)
+	})
+}
+
+// updateLeafHashForRoot places a leaf for valueHash at the path of key under
+// root, or a presence leaf if valueHash is empty, without storing a value.
+func (smt *SparseMerkleTree) updateLeafHashForRoot(key []byte, valueHash []byte, root []byte) ([]byte, error) {
+	if smt.sealed {
+		return nil, ErrSealed
+	}
+	path := smt.th.path(key)
+	sideNodes, pathNodes, oldLeafData, _, err := smt.sideNodesForRoot(path, root, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := smt.checkKey(path, key, oldLeafData); err != nil {
+		return nil, err
+	}
+	var oldValue []byte
+	if smt.observing {
+		if oldValue, err = smt.observedValue(path); err != nil {
 			return nil, err
 		}
+	}
+	newRoot, err := smt.updateWithSideNodes(path, valueHash, nil, sideNodes, pathNodes, oldLeafData)
+	if err != nil {
+		return nil, err
+	}
+	if err := smt.setKey(path, key); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(newRoot, root) {
+		smt.recordEvent(treeEvent{key: key, oldValue: oldValue})
+	}
+	return newRoot, nil
+}
 
-	} else {
-		// Insert or update operation.
-		newRoot, err = smt.updateWithSideNodes(path, value, sideNodes, pathNodes, oldLeafData)
+// deleteValue deletes the value at path, which may be missing if its leaf was
+// set with UpdateLeafHash.
+func (smt *SparseMerkleTree) deleteValue(path []byte) error {
+	if err := smt.retainValue(path); err != nil {
+		return err
 	}
-	return newRoot, err
+	if err := smt.preserve(valueStore, path); err != nil {
+		return err
+	}
+	err := smt.values.Delete(path)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil
+	}
+	return err
 }
 
 // DeleteForRoot deletes a value from tree at a specific root. It returns the new root of the tree.
 func (smt *SparseMerkleTree) DeleteForRoot(key, root []byte) ([]byte, error) {
-	return smt.UpdateForRoot(key, defaultValue, root)
+	defer smt.writeLock()()
+	return smt.deleteForRoot(key, root)
 }
 
 func (smt *SparseMerkleTree) deleteWithSideNodes(path []byte, sideNodes [][]byte, pathNodes [][]byte, oldLeafData []byte) ([]byte, error) {
@@ -157,16 +733,20 @@ func (smt *SparseMerkleTree) deleteWithSideNodes(path []byte, sideNodes [][]byte
 	}
 	// All nodes above the deleted leaf are now orphaned
 	for _, node := range pathNodes {
-		if err := smt.nodes.Delete(node); err != nil {
+		if err := smt.pruneNode(node); err != nil {
 			return nil, err
 		}
 	}
+	if smt.logger != nil {
+		smt.debugf("pruned %d orphaned nodes deleting path %x", len(pathNodes), path)
+	}
 
+	writes := newNodeBatch(len(sideNodes))
 	var currentHash, currentData []byte
 	nonPlaceholderReached := false
 	for i, sideNode := range sideNodes {
 		if currentData == nil {
-			sideNodeValue, err := smt.nodes.Get(sideNode)
+			sideNodeValue, err := smt.getNode(sideNode)
 			if err != nil {
 				return nil, err
 			}
@@ -198,11 +778,15 @@ func (smt *SparseMerkleTree) deleteWithSideNodes(path []byte, sideNodes [][]byte
 		} else {
 			currentHash, currentData = smt.th.digestNode(currentData, sideNode)
 		}
-		if err := smt.nodes.Set(currentHash, currentData); err != nil {
-			return nil, err
-		}
+		writes.add(currentHash, currentData)
 		currentData = currentHash
 	}
+	if err := smt.setNodes(writes); err != nil {
+		return nil, err
+	}
+	if err := smt.bumpKeyVersion(path); err != nil {
+		return nil, err
+	}
 
 	if currentHash == nil {
 		// The tree is empty; return placeholder value as root.
@@ -211,14 +795,14 @@ func (smt *SparseMerkleTree) deleteWithSideNodes(path []byte, sideNodes [][]byte
 	return currentHash, nil
 }
 
-func (smt *SparseMerkleTree) updateWithSideNodes(path []byte, value []byte, sideNodes [][]byte, pathNodes [][]byte, oldLeafData []byte) ([]byte, error) {
-	valueHash := smt.th.digest(value)
-	currentHash, currentData := smt.th.digestLeaf(path, valueHash)
-	if err := smt.nodes.Set(currentHash, currentData); err != nil {
-		return nil, err
+// updateWithSideNodes places a leaf for valueHash at path, storing value
+// unless it is nil.
+func (smt *SparseMerkleTree) updateWithSideNodes(path []byte, valueHash []byte, value []byte, sideNodes [][]byte, pathNodes [][]byte, oldLeafData []byte) ([]byte, error) {
+	if value != nil {
+		if err := smt.checkValueSize(value); err != nil {
+			return nil, err
+		}
 	}
-	currentData = currentHash
-
 	// If the leaf node that sibling nodes lead to has a different actual path
 	// than the leaf node being updated, we need to create an intermediate node
 	// with this leaf node and the new leaf node as children.
@@ -234,18 +818,24 @@ func (smt *SparseMerkleTree) updateWithSideNodes(path []byte, value []byte, side
 		actualPath, oldValueHash = smt.th.parseLeaf(oldLeafData)
 		commonPrefixCount = countCommonPrefix(path, actualPath)
 	}
+
+	// The leaf, any intermediate node with an old leaf, and a node per side
+	// node, or per level above the intermediate node, are written.
+	n := len(sideNodes)
+	if commonPrefixCount != smt.depth() && commonPrefixCount > n {
+		n = commonPrefixCount
+	}
+	writes := newNodeBatch(n + 2)
+	currentHash, currentData := smt.th.digestLeaf(path, valueHash)
+	writes.add(currentHash, currentData)
+	currentData = currentHash
 	if commonPrefixCount != smt.depth() {
 		if getBitAtFromMSB(path, commonPrefixCount) == right {
 			currentHash, currentData = smt.th.digestNode(pathNodes[0], currentData)
 		} else {
 			currentHash, currentData = smt.th.digestNode(currentData, pathNodes[0])
 		}
-
-		err := smt.nodes.Set(currentHash, currentData)
-		if err != nil {
-			return nil, err
-		}
-
+		writes.add(currentHash, currentData)
 		currentData = currentHash
 	} else if oldValueHash != nil {
 		// Short-circuit if the same value is being set
@@ -253,19 +843,22 @@ func (smt *SparseMerkleTree) updateWithSideNodes(path []byte, value []byte, side
 			return smt.root, nil
 		}
 		// If an old leaf exists, remove it
-		if err := smt.nodes.Delete(pathNodes[0]); err != nil {
+		if err := smt.pruneNode(pathNodes[0]); err != nil {
 			return nil, err
 		}
-		if err := smt.values.Delete(path); err != nil {
+		if err := smt.deleteValue(path); err != nil {
 			return nil, err
 		}
 	}
 	// All remaining path nodes are orphaned
 	for i := 1; i < len(pathNodes); i++ {
-		if err := smt.nodes.Delete(pathNodes[i]); err != nil {
+		if err := smt.pruneNode(pathNodes[i]); err != nil {
 			return nil, err
 		}
 	}
+	if smt.logger != nil && len(pathNodes) > 1 {
+		smt.debugf("pruned %d orphaned nodes updating path %x", len(pathNodes)-1, path)
+	}
 
 	// The offset from the bottom of the tree to the start of the side nodes.
 	// Note: i-offsetOfSideNodes is the index into sideNodes[]
@@ -293,19 +886,28 @@ func (smt *SparseMerkleTree) updateWithSideNodes(path []byte, value []byte, side
 		} else {
 			currentHash, currentData = smt.th.digestNode(currentData, sideNode)
 		}
-		err := smt.nodes.Set(currentHash, currentData)
-		if err != nil {
+		writes.add(currentHash, currentData)
+		currentData = currentHash
+	}
+	if err := smt.setNodes(writes); err != nil {
+		return nil, err
+	}
+	if value != nil {
+		if err := smt.setValue(path, value); err != nil {
 			return nil, err
 		}
-		currentData = currentHash
 	}
-	if err := smt.values.Set(path, value); err != nil {
+	if err := smt.bumpKeyVersion(path); err != nil {
 		return nil, err
 	}
 
 	return currentHash, nil
 }
 
+// sideNodesHint is the number of side nodes sideNodesForRoot allocates for
+// up front, that of a path in a tree of billions of leaves.
+const sideNodesHint = 32
+
 // Get all the sibling nodes (sidenodes) for a given path from a given root.
 // Returns an array of sibling nodes, the leaf hash found at that path, the
 // leaf data, and the sibling data.
@@ -313,10 +915,16 @@ func (smt *SparseMerkleTree) updateWithSideNodes(path []byte, value []byte, side
 // If the leaf is a placeholder, the leaf data is nil.
 func (smt *SparseMerkleTree) sideNodesForRoot(path []byte, root []byte, getSiblingData bool) ([][]byte, [][]byte, []byte, []byte, error) {
 	// Side nodes for the path. Nodes are inserted in reverse order, then the
-	// slice is reversed at the end.
-	sideNodes := make([][]byte, 0, smt.depth())
-	pathNodes := make([][]byte, 0, smt.depth()+1)
-	pathNodes = append(pathNodes, root)
+	// slice is reversed at the end. Paths are about as long as the log of the
+	// number of leaves, far short of the depth, so both slices start in a
+	// single allocation for a typical path and grow past it if needed.
+	n := smt.depth()
+	if n > sideNodesHint {
+		n = sideNodesHint
+	}
+	nodes := make([][]byte, 2*n+1)
+	sideNodes := nodes[:0:n]
+	pathNodes := append(nodes[n:n], root)
 
 	if bytes.Equal(root, smt.th.placeholder()) {
 		// If the root is a placeholder, there are no sidenodes to return.
@@ -324,7 +932,7 @@ func (smt *SparseMerkleTree) sideNodesForRoot(path []byte, root []byte, getSibli
 		return sideNodes, pathNodes, nil, nil, nil
 	}
 
-	currentData, err := smt.nodes.Get(root)
+	currentData, err := smt.getNode(root)
 	if err != nil {
 		return nil, nil, nil, nil, err
 	} else if smt.th.isLeaf(currentData) {
@@ -355,7 +963,7 @@ func (smt *SparseMerkleTree) sideNodesForRoot(path []byte, root []byte, getSibli
 			break
 		}
 
-		currentData, err = smt.nodes.Get(nodeHash)
+		currentData, err = smt.getNode(nodeHash)
 		if err != nil {
 			return nil, nil, nil, nil, err
 		} else if smt.th.isLeaf(currentData) {
@@ -365,7 +973,7 @@ func (smt *SparseMerkleTree) sideNodesForRoot(path []byte, root []byte, getSibli
 	}
 
 	if getSiblingData {
-		siblingData, err = smt.nodes.Get(sideNode)
+		siblingData, err = smt.getNode(sideNode)
 		if err != nil {
 			return nil, nil, nil, nil, err
 		}
@@ -379,10 +987,60 @@ func (smt *SparseMerkleTree) sideNodesForRoot(path []byte, root []byte, getSibli
 // the leaf may be updated (e.g. in a state transition fraud proof). For
 // updatable proofs, see ProveUpdatable.
 func (smt *SparseMerkleTree) Prove(key []byte) (SparseMerkleProof, error) {
-	proof, err := smt.ProveForRoot(key, smt.Root())
+	var start time.Time
+	if smt.metrics != nil {
+		start = time.Now()
+	}
+	defer smt.readLock()()
+	proof, err := smt.doProveForRoot(key, smt.Root(), false)
+	if smt.metrics != nil {
+		smt.observe(OperationProve, start, err)
+		if err == nil {
+			smt.metrics.ObserveProofSize(proofSize(proof))
+		}
+	}
 	return proof, err
 }
 
+// GetWithProof gets the value of a key, as Get does, along with a Merkle proof
+// of it against the current root, as Prove returns, in a single traversal of
+// the path of the key: a membership proof for a present key, or a
+// non-membership proof, with the default value, for an absent one. In a tree
+// created with WithLazyCommit, both are at the root of the last Commit.
+func (smt *SparseMerkleTree) GetWithProof(key []byte) ([]byte, SparseMerkleProof, error) {
+	defer smt.readLock()()
+	root := smt.Root()
+	path := smt.th.path(key)
+	sideNodes, pathNodes, leafData, siblingData, err := smt.sideNodesForRoot(path, root, false)
+	if err != nil {
+		return nil, SparseMerkleProof{}, err
+	}
+	proof := smt.proofWithSideNodes(path, sideNodes, pathNodes, leafData, siblingData)
+	if bytes.Equal(pathNodes[0], smt.th.placeholder()) {
+		return smt.emptyValue(), proof, nil
+	}
+	leafPath, valueHash := smt.th.parseLeaf(leafData)
+	if !bytes.Equal(leafPath, path) || len(valueHash) == 0 {
+		return smt.emptyValue(), proof, nil
+	}
+	if other, err := smt.otherKey(path, key); err != nil || other {
+		// The path belongs to another key; see ErrPathCollision.
+		return smt.emptyValue(), proof, err
+	}
+	value, err := smt.getValue(path)
+	if errors.Is(err, ErrKeyNotFound) {
+		if smt.checkMissingValues {
+			return nil, SparseMerkleProof{}, fmt.Errorf("%w: %x", ErrValueMissing, key)
+		}
+		// Set with UpdateLeafHash.
+		return smt.emptyValue(), proof, nil
+	}
+	if err != nil {
+		return nil, SparseMerkleProof{}, fmt.Errorf("getting value of %x: %w", key, err)
+	}
+	return value, proof, nil
+}
+
 // ProveForRoot generates a Merkle proof for a key, against a specific node.
 // This is primarily useful for generating Merkle proofs for subtrees.
 //
@@ -390,28 +1048,78 @@ func (smt *SparseMerkleTree) Prove(key []byte) (SparseMerkleProof, error) {
 // the leaf may be updated (e.g. in a state transition fraud proof). For
 // updatable proofs, see ProveUpdatableForRoot.
 func (smt *SparseMerkleTree) ProveForRoot(key []byte, root []byte) (SparseMerkleProof, error) {
+	defer smt.readLock()()
 	return smt.doProveForRoot(key, root, false)
 }
 
 // ProveUpdatable generates an updatable Merkle proof for a key against the current root.
 func (smt *SparseMerkleTree) ProveUpdatable(key []byte) (SparseMerkleProof, error) {
-	proof, err := smt.ProveUpdatableForRoot(key, smt.Root())
+	defer smt.readLock()()
+	proof, err := smt.doProveForRoot(key, smt.Root(), true)
 	return proof, err
 }
 
 // ProveUpdatableForRoot generates an updatable Merkle proof for a key, against a specific node.
 // This is primarily useful for generating Merkle proofs for subtrees.
 func (smt *SparseMerkleTree) ProveUpdatableForRoot(key []byte, root []byte) (SparseMerkleProof, error) {
+	defer smt.readLock()()
 	return smt.doProveForRoot(key, root, true)
 }
 
 func (smt *SparseMerkleTree) doProveForRoot(key []byte, root []byte, isUpdatable bool) (SparseMerkleProof, error) {
+	if err := smt.checkRootRetained(root); err != nil {
+		return SparseMerkleProof{}, err
+	}
 	path := smt.th.path(key)
 	sideNodes, pathNodes, leafData, siblingData, err := smt.sideNodesForRoot(path, root, isUpdatable)
 	if err != nil {
 		return SparseMerkleProof{}, err
 	}
+	return smt.proofWithSideNodes(path, sideNodes, pathNodes, leafData, siblingData), nil
+}
+
+// ProveExisting generates a Merkle proof of membership for a key against the
+// current root if the key is in the tree, or returns nil if it is absent,
+// without building a non-membership proof. Keys set with UpdateLeafHash or
+// UpdatePresence are in the tree.
+func (smt *SparseMerkleTree) ProveExisting(key []byte) (*SparseMerkleProof, error) {
+	defer smt.readLock()()
+	return smt.proveExistingForRoot(key, smt.Root())
+}
+
+// ProveExistingForRoot generates a Merkle proof of membership for a key, at a
+// specific root, like ProveExisting.
+func (smt *SparseMerkleTree) ProveExistingForRoot(key []byte, root []byte) (*SparseMerkleProof, error) {
+	defer smt.readLock()()
+	return smt.proveExistingForRoot(key, root)
+}
+
+func (smt *SparseMerkleTree) proveExistingForRoot(key []byte, root []byte) (*SparseMerkleProof, error) {
+	if err := smt.checkRootRetained(root); err != nil {
+		return nil, err
+	}
+	path := smt.th.path(key)
+	sideNodes, pathNodes, leafData, siblingData, err := smt.sideNodesForRoot(path, root, false)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(pathNodes[0], smt.th.placeholder()) {
+		return nil, nil
+	}
+	if actualPath, _ := smt.th.parseLeaf(leafData); !bytes.Equal(actualPath, path) {
+		return nil, nil
+	}
+	if other, err := smt.otherKey(path, key); err != nil || other {
+		// The leaf belongs to another key; see ErrPathCollision.
+		return nil, err
+	}
+	proof := smt.proofWithSideNodes(path, sideNodes, pathNodes, leafData, siblingData)
+	return &proof, nil
+}
 
+// proofWithSideNodes builds the proof for path from what sideNodesForRoot
+// returned for it.
+func (smt *SparseMerkleTree) proofWithSideNodes(path []byte, sideNodes [][]byte, pathNodes [][]byte, leafData []byte, siblingData []byte) SparseMerkleProof {
 	var nonEmptySideNodes [][]byte
 	for _, v := range sideNodes {
 		if v != nil {
@@ -435,23 +1143,71 @@ func (smt *SparseMerkleTree) doProveForRoot(key []byte, root []byte, isUpdatable
 		SideNodes:             nonEmptySideNodes,
 		NonMembershipLeafData: nonMembershipLeafData,
 		SiblingData:           siblingData,
+		Depth:                 smt.depth(),
 	}
 
-	return proof, err
+	return proof
 }
 
 // ProveCompact generates a compacted Merkle proof for a key against the current root.
 func (smt *SparseMerkleTree) ProveCompact(key []byte) (SparseCompactMerkleProof, error) {
-	proof, err := smt.ProveCompactForRoot(key, smt.Root())
+	var start time.Time
+	if smt.metrics != nil {
+		start = time.Now()
+	}
+	defer smt.readLock()()
+	proof, err := smt.proveCompactForRoot(key, smt.Root())
+	if smt.metrics != nil {
+		smt.observe(OperationProve, start, err)
+		if err == nil {
+			smt.metrics.ObserveProofSize(compactProofSize(proof))
+		}
+	}
 	return proof, err
 }
 
 // ProveCompactForRoot generates a compacted Merkle proof for a key, at a specific root.
 func (smt *SparseMerkleTree) ProveCompactForRoot(key []byte, root []byte) (SparseCompactMerkleProof, error) {
-	proof, err := smt.ProveForRoot(key, root)
+	defer smt.readLock()()
+	return smt.proveCompactForRoot(key, root)
+}
+
+func (smt *SparseMerkleTree) proveCompactForRoot(key []byte, root []byte) (SparseCompactMerkleProof, error) {
+	proof, err := smt.doProveForRoot(key, root, false)
 	if err != nil {
 		return SparseCompactMerkleProof{}, err
 	}
-	compactedProof, err := CompactProof(proof, smt.th.hasher)
+	compactedProof, err := compactProof(proof, &smt.th)
 	return compactedProof, err
 }
+
+// EstimateProofSize returns the size in bytes of the side nodes, bit mask and
+// non-membership leaf data of the proof and compact proof for a key against
+// the current root, without generating either proof.
+func (smt *SparseMerkleTree) EstimateProofSize(key []byte) (full int, compact int, err error) {
+	defer smt.readLock()()
+	path := smt.th.path(key)
+	sideNodes, pathNodes, leafData, _, err := smt.sideNodesForRoot(path, smt.Root(), false)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var leafSize int
+	if !bytes.Equal(pathNodes[0], smt.th.placeholder()) {
+		actualPath, _ := smt.th.parseLeaf(leafData)
+		if !bytes.Equal(actualPath, path) {
+			leafSize = len(leafData)
+		}
+	}
+
+	var nonPlaceholders int
+	for _, v := range sideNodes {
+		if !bytes.Equal(v, smt.th.placeholder()) {
+			nonPlaceholders++
+		}
+	}
+	hashSize := smt.th.hashSize()
+	full = len(sideNodes)*hashSize + leafSize
+	compact = nonPlaceholders*hashSize + (len(sideNodes)+7)/8 + leafSize
+	return full, compact, nil
+}
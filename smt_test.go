@@ -3,8 +3,12 @@ package smt
 import (
 	"bytes"
 	"crypto/sha256"
+	"errors"
+	"fmt"
 	"hash"
 	"math/rand"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -275,6 +279,53 @@ func TestSparseMerkleTreeMaxHeightCase(t *testing.T) {
 	}
 }
 
+// Test that inserting a key into a subtree holding one leaf descends only to
+// the first bit where their paths differ, down to the last bit, and that both
+// keys prove and delete correctly from there.
+func TestSparseMerkleTreeDeepCommonPrefix(t *testing.T) {
+	h := newDummyHasher(sha256.New())
+	for _, bit := range []int{0, 7, 128, 254, 255} {
+		for _, reversed := range []bool{false, true} {
+			smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), h)
+			key1 := make([]byte, h.Size()+4)
+			rand.Read(key1[4:])
+			key2 := append([]byte(nil), key1...)
+			key2[4+bit/8] ^= 1 << (7 - uint(bit%8))
+			keys, values := [][]byte{key1, key2}, [][]byte{[]byte("testValue1"), []byte("testValue2")}
+			if reversed {
+				keys[0], keys[1] = keys[1], keys[0]
+			}
+			emptyRoot := smt.Root()
+			smt.Update(keys[0], values[0])
+			rootWithOne := smt.Root()
+			smt.Update(keys[1], values[1])
+
+			for i, key := range keys {
+				proof, err := smt.Prove(key)
+				if err != nil {
+					t.Fatalf("returned error when proving key diverging at bit %d: %v", bit, err)
+				}
+				if len(proof.SideNodes) != bit+1 {
+					t.Errorf("proof of key diverging at bit %d has %d side nodes", bit, len(proof.SideNodes))
+				}
+				if !VerifyProof(proof, smt.Root(), key, values[i], h) {
+					t.Errorf("proof of key diverging at bit %d failed to verify", bit)
+				}
+			}
+
+			// Deleting the second key collapses the path back to the first leaf.
+			smt.Delete(keys[1])
+			if !bytes.Equal(smt.Root(), rootWithOne) {
+				t.Errorf("deleting key diverging at bit %d did not restore the root", bit)
+			}
+			smt.Delete(keys[0])
+			if !bytes.Equal(smt.Root(), emptyRoot) {
+				t.Errorf("deleting both keys diverging at bit %d did not empty the tree", bit)
+			}
+		}
+	}
+}
+
 // Test base case tree delete operations with a few keys.
 func TestSparseMerkleTreeDeleteBasic(t *testing.T) {
 	smn, smv := NewSimpleMap(), NewSimpleMap()
@@ -614,3 +665,635 @@ func TestOrphanRemoval(t *testing.T) {
 		}
 	})
 }
+
+// Test that a sealed tree rejects modifications until unsealed.
+func TestSparseMerkleTreeSeal(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+
+	root, err := smt.Update([]byte("testKey"), []byte("testValue"))
+	if err != nil {
+		t.Errorf("returned error when updating empty key: %v", err)
+	}
+
+	smt.Seal()
+	if !smt.IsSealed() {
+		t.Error("tree not sealed after Seal")
+	}
+	_, err = smt.Update([]byte("testKey"), []byte("testValue2"))
+	if !errors.Is(err, ErrSealed) {
+		t.Error("did not return ErrSealed when updating sealed tree")
+	}
+	_, err = smt.Delete([]byte("testKey"))
+	if !errors.Is(err, ErrSealed) {
+		t.Error("did not return ErrSealed when deleting from sealed tree")
+	}
+	_, err = smt.UpdateForRoot([]byte("testKey2"), []byte("testValue"), root)
+	if !errors.Is(err, ErrSealed) {
+		t.Error("did not return ErrSealed when updating sealed tree for root")
+	}
+	if err := smt.SetRoot(smt.th.placeholder()); !errors.Is(err, ErrSealed) {
+		t.Error("did not return ErrSealed when setting the root of sealed tree")
+	}
+	if !bytes.Equal(root, smt.Root()) {
+		t.Error("root changed while tree was sealed")
+	}
+	value, err := smt.Get([]byte("testKey"))
+	if err != nil {
+		t.Errorf("returned error when getting key from sealed tree: %v", err)
+	}
+	if !bytes.Equal([]byte("testValue"), value) {
+		t.Error("did not get correct value from sealed tree")
+	}
+
+	smt.Unseal()
+	_, err = smt.Update([]byte("testKey"), []byte("testValue2"))
+	if err != nil {
+		t.Errorf("returned error when updating unsealed tree: %v", err)
+	}
+}
+
+// Test concurrent reads and proofs on trees sharing a single hash.Hash and on
+// trees created with a hash.Hash constructor. Run with -race.
+func TestSparseMerkleTreeConcurrentProofs(t *testing.T) {
+	trees := map[string]*SparseMerkleTree{
+		"shared":  NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New()),
+		"factory": NewSparseMerkleTreeWithHasherFunc(NewSimpleMap(), NewSimpleMap(), sha256.New),
+	}
+	for name, smt := range trees {
+		for i := 0; i < 50; i++ {
+			smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+		}
+		root := smt.Root()
+
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := g; i < 50; i += 8 {
+					key := []byte(fmt.Sprintf("testKey%d", i))
+					value := []byte(fmt.Sprintf("testValue%d", i))
+					got, err := smt.Get(key)
+					if err != nil || !bytes.Equal(got, value) {
+						t.Errorf("%s: did not get correct value for key %s", name, key)
+					}
+					proof, err := smt.Prove(key)
+					if err != nil {
+						t.Errorf("%s: returned error when proving key: %v", name, err)
+					}
+					if !VerifyProof(proof, root, key, value, sha256.New()) {
+						t.Errorf("%s: proof for key %s failed to verify", name, key)
+					}
+					if _, err := smt.ProveCompact(key); err != nil {
+						t.Errorf("%s: returned error when proving compact key: %v", name, err)
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+	}
+}
+
+// Test placing precomputed value hashes without the values.
+func TestSparseMerkleTreeUpdateLeafHash(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	hashed := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		value := []byte(fmt.Sprintf("testValue%d", i))
+		smt.Update(key, value)
+		valueHash := sha256.Sum256(value)
+		if _, err := hashed.UpdateLeafHash(key, valueHash[:]); err != nil {
+			t.Errorf("returned error when updating leaf hash: %v", err)
+		}
+	}
+	if !bytes.Equal(smt.Root(), hashed.Root()) {
+		t.Error("roots differ between values and their precomputed hashes")
+	}
+
+	key, value := []byte("testKey1"), []byte("testValue1")
+	got, err := hashed.Get(key)
+	if err != nil {
+		t.Errorf("returned error when getting hash-only key: %v", err)
+	}
+	if !bytes.Equal(got, defaultValue) {
+		t.Error("did not get default value for hash-only key")
+	}
+	proof, err := hashed.Prove(key)
+	if err != nil {
+		t.Errorf("returned error when proving hash-only key: %v", err)
+	}
+	valueHash := sha256.Sum256(value)
+	if !VerifyProofWithValueHash(proof, hashed.Root(), key, valueHash[:], sha256.New()) {
+		t.Error("proof for hash-only key failed to verify with its value hash")
+	}
+	if !VerifyProof(proof, hashed.Root(), key, value, sha256.New()) {
+		t.Error("proof for hash-only key failed to verify with its value")
+	}
+	if VerifyProofWithValueHash(proof, hashed.Root(), key, make([]byte, sha256.Size), sha256.New()) {
+		t.Error("proof for hash-only key verified with wrong value hash")
+	}
+
+	// Hash-only keys can be updated and deleted like any other.
+	if _, err := hashed.Update(key, []byte("newValue")); err != nil {
+		t.Errorf("returned error when updating hash-only key: %v", err)
+	}
+	if _, err := hashed.Delete([]byte("testKey2")); err != nil {
+		t.Errorf("returned error when deleting hash-only key: %v", err)
+	}
+	smt.Update(key, []byte("newValue"))
+	smt.Delete([]byte("testKey2"))
+	if !bytes.Equal(smt.Root(), hashed.Root()) {
+		t.Error("roots differ after updating and deleting hash-only keys")
+	}
+
+	if _, err := hashed.UpdateLeafHash(key, []byte("short")); !errors.Is(err, ErrBadValueHash) {
+		t.Errorf("did not return ErrBadValueHash for short value hash, got %v", err)
+	}
+}
+
+func TestSparseMerkleTreeUpdatePresence(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 20; i++ {
+		if _, err := smt.UpdatePresence([]byte(fmt.Sprintf("testKey%d", i))); err != nil {
+			t.Errorf("returned error when updating presence: %v", err)
+		}
+	}
+
+	key := []byte("testKey1")
+	got, err := smt.Get(key)
+	if err != nil {
+		t.Errorf("returned error when getting presence-only key: %v", err)
+	}
+	if !bytes.Equal(got, defaultValue) {
+		t.Error("did not get default value for presence-only key")
+	}
+	proof, err := smt.Prove(key)
+	if err != nil {
+		t.Errorf("returned error when proving presence-only key: %v", err)
+	}
+	if !VerifyPresenceProof(proof, smt.Root(), key, sha256.New()) {
+		t.Error("presence proof failed to verify")
+	}
+	if VerifyPresenceProof(proof, smt.Root(), []byte("testKey2"), sha256.New()) {
+		t.Error("presence proof verified for another key")
+	}
+	if VerifyProof(proof, smt.Root(), key, defaultValue, sha256.New()) {
+		t.Error("presence proof verified as a non-membership proof")
+	}
+	if VerifyProofWithValueHash(proof, smt.Root(), key, make([]byte, sha256.Size), sha256.New()) {
+		t.Error("presence proof verified with a value hash")
+	}
+	absent, err := smt.Prove([]byte("absentKey"))
+	if err != nil {
+		t.Errorf("returned error when proving absent key: %v", err)
+	}
+	if VerifyPresenceProof(absent, smt.Root(), []byte("absentKey"), sha256.New()) {
+		t.Error("presence proof verified for an absent key")
+	}
+
+	// The presence leaf commits to the key alone, with no marker value.
+	path := sha256.Sum256(key)
+	leaf := sha256.Sum256(append([]byte{3}, path[:]...))
+	single := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	root, err := single.UpdatePresence(key)
+	if err != nil {
+		t.Errorf("returned error when updating presence: %v", err)
+	}
+	if !bytes.Equal(root, leaf[:]) {
+		t.Error("presence leaf is not hashed as its prefix and path")
+	}
+	valued := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	valued.Update(key, []byte("testValue"))
+	if bytes.Equal(valued.Root(), single.Root()) {
+		t.Error("presence leaf has the same root as a value leaf")
+	}
+
+	// Presence leaves can become value leaves and back, and be deleted.
+	if _, err := single.Update(key, []byte("testValue")); err != nil {
+		t.Errorf("returned error when updating presence-only key: %v", err)
+	}
+	if !bytes.Equal(single.Root(), valued.Root()) {
+		t.Error("roots differ after giving presence-only key a value")
+	}
+	if _, err := single.UpdatePresence(key); err != nil {
+		t.Errorf("returned error when updating presence: %v", err)
+	}
+	if !bytes.Equal(single.Root(), leaf[:]) {
+		t.Error("root is not the presence leaf after dropping the value")
+	}
+	got, err = single.Get(key)
+	if err != nil || !bytes.Equal(got, defaultValue) {
+		t.Errorf("did not drop value of presence-only key, got %x, %v", got, err)
+	}
+	if _, err := single.Delete(key); err != nil {
+		t.Errorf("returned error when deleting presence-only key: %v", err)
+	}
+	if !bytes.Equal(single.Root(), single.th.placeholder()) {
+		t.Error("tree is not empty after deleting presence-only key")
+	}
+}
+
+// Test that constructors reject nil stores up front.
+func TestSparseMerkleTreeNilStores(t *testing.T) {
+	expectPanic := func(name string, expected string, f func()) {
+		defer func() {
+			if r := recover(); r != expected {
+				t.Errorf("%s: expected panic %q, got %v", name, expected, r)
+			}
+		}()
+		f()
+	}
+
+	cases := []struct {
+		nodes, values MapStore
+		expected      string
+	}{
+		{nil, NewSimpleMap(), "smt: nil nodes store"},
+		{NewSimpleMap(), nil, "smt: nil values store"},
+		{nil, nil, "smt: nil nodes store"},
+	}
+	for _, c := range cases {
+		expectPanic("NewSparseMerkleTree", c.expected, func() {
+			NewSparseMerkleTree(c.nodes, c.values, sha256.New())
+		})
+		expectPanic("NewSparseMerkleTreeWithHasherFunc", c.expected, func() {
+			NewSparseMerkleTreeWithHasherFunc(c.nodes, c.values, sha256.New)
+		})
+		expectPanic("ImportSparseMerkleTree", c.expected, func() {
+			ImportSparseMerkleTree(c.nodes, c.values, sha256.New(), nil)
+		})
+		expectPanic("ImportSparseMerkleTreeWithHasherFunc", c.expected, func() {
+			ImportSparseMerkleTreeWithHasherFunc(c.nodes, c.values, sha256.New, nil)
+		})
+		expectPanic("NewDeepSparseMerkleSubTree", c.expected, func() {
+			NewDeepSparseMerkleSubTree(c.nodes, c.values, sha256.New(), nil)
+		})
+	}
+}
+
+// Test that updating with a reused buffer does not corrupt stored values.
+func TestSparseMerkleTreeBufferReuse(t *testing.T) {
+	keys := NewSimpleMap()
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithKeyStore(keys))
+	key, value := []byte("testKey"), []byte("testValue")
+	smt.Update(key, value)
+	root := smt.Root()
+
+	copy(value, "corrupted")
+	copy(key, "corrupt")
+	got, err := smt.Get([]byte("testKey"))
+	if err != nil {
+		t.Errorf("returned error when getting key: %v", err)
+	}
+	if !bytes.Equal(got, []byte("testValue")) {
+		t.Error("mutating the value buffer changed the stored value")
+	}
+	if stored, _ := keys.Get(smt.th.path([]byte("testKey"))); !bytes.Equal(stored, []byte("testKey")) {
+		t.Error("mutating the key buffer changed the stored key")
+	}
+	proof, _ := smt.Prove([]byte("testKey"))
+	if !VerifyProof(proof, root, []byte("testKey"), []byte("testValue"), sha256.New()) {
+		t.Error("proof failed to verify after mutating buffers")
+	}
+}
+
+// Test trees with a custom value for absent keys.
+func TestSparseMerkleTreeDefaultValue(t *testing.T) {
+	sentinel := []byte{0xff}
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithDefaultValue(sentinel))
+	verifyOption := WithVerifyDefaultValue(sentinel)
+
+	value, err := smt.Get([]byte("testKey"))
+	if err != nil {
+		t.Errorf("returned error when getting absent key: %v", err)
+	}
+	if !bytes.Equal(value, sentinel) {
+		t.Error("did not get default value for absent key")
+	}
+	proof, _ := smt.Prove([]byte("testKey"))
+	if !VerifyProof(proof, smt.Root(), []byte("testKey"), sentinel, sha256.New(), verifyOption) {
+		t.Error("non-membership proof failed to verify with default value")
+	}
+
+	// The empty value is now a real, provable leaf.
+	smt.Update([]byte("testKey"), []byte{})
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	if has, _ := smt.Has([]byte("testKey")); !has {
+		t.Error("did not store empty value")
+	}
+	value, _ = smt.Get([]byte("testKey"))
+	if value == nil || len(value) != 0 {
+		t.Error("did not get empty value")
+	}
+	proof, _ = smt.Prove([]byte("testKey"))
+	if !VerifyProof(proof, smt.Root(), []byte("testKey"), []byte{}, sha256.New(), verifyOption) {
+		t.Error("membership proof for empty value failed to verify")
+	}
+	if VerifyProof(proof, smt.Root(), []byte("testKey"), sentinel, sha256.New(), verifyOption) {
+		t.Error("non-membership proof verified for stored empty value")
+	}
+	compactProof, _ := smt.ProveCompact([]byte("testKey"))
+	if !VerifyCompactProof(compactProof, smt.Root(), []byte("testKey"), []byte{}, sha256.New(), verifyOption) {
+		t.Error("compact membership proof for empty value failed to verify")
+	}
+
+	// Setting the default value deletes the key, collapsing the tree.
+	root := smt.Root()
+	updates := []BatchUpdate{{Key: []byte("testKey"), Value: sentinel}}
+	batchProof, err := smt.ProveBatchUpdate(updates)
+	if err != nil {
+		t.Errorf("returned error when proving batch update: %v", err)
+	}
+	smt.Update([]byte("testKey"), sentinel)
+	if has, _ := smt.Has([]byte("testKey")); has {
+		t.Error("did not delete key set to default value")
+	}
+	if !VerifyBatchUpdate(root, smt.Root(), updates, batchProof, sha256.New(), verifyOption) {
+		t.Error("batch update proof failed to verify with default value")
+	}
+	smt.Delete([]byte("testKey2"))
+	if !bytes.Equal(smt.Root(), smt.th.placeholder()) {
+		t.Error("tree not empty after deleting all keys")
+	}
+}
+
+// Test both policies for setting a key to the default value.
+func TestSparseMerkleTreeDefaultValuePolicy(t *testing.T) {
+	// By default, setting the default value deletes the key.
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	if !smt.IsDeletionValue(defaultValue) || smt.IsDeletionValue([]byte("testValue")) {
+		t.Error("did not report the default value as the deletion value")
+	}
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	smt.Update([]byte("testKey"), defaultValue)
+	if !bytes.Equal(smt.Root(), smt.th.placeholder()) {
+		t.Error("setting the default value did not delete the key")
+	}
+
+	// With WithStorableDefaultValue, the default value is stored.
+	smt = NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithStorableDefaultValue())
+	if smt.IsDeletionValue(defaultValue) {
+		t.Error("reported the default value as the deletion value when storable")
+	}
+	root, _ := smt.Update([]byte("testKey"), defaultValue)
+	if bytes.Equal(root, smt.th.placeholder()) {
+		t.Error("setting the default value deleted the key when storable")
+	}
+	value, err := smt.Get([]byte("testKey"))
+	if err != nil || !bytes.Equal(value, defaultValue) {
+		t.Errorf("did not get stored default value, got %x, %v", value, err)
+	}
+	proof, _ := smt.Prove([]byte("testKey"))
+	if !VerifyProofWithValueHash(proof, root, []byte("testKey"), smt.th.digest(defaultValue), sha256.New()) {
+		t.Error("membership proof of stored default value failed to verify")
+	}
+	if VerifyProof(proof, root, []byte("testKey"), defaultValue, sha256.New()) {
+		t.Error("non-membership proof verified for stored default value")
+	}
+	built, err := BuildFromSorted(NewSimpleMap(), NewSimpleMap(), sha256.New, NewSliceIterator([][]byte{[]byte("testKey")}, [][]byte{defaultValue}), WithStorableDefaultValue())
+	if err != nil || !bytes.Equal(built.Root(), root) {
+		t.Errorf("built tree does not store the default value, got %v", err)
+	}
+
+	// Only Delete removes the key.
+	smt.Delete([]byte("testKey"))
+	if !bytes.Equal(smt.Root(), smt.th.placeholder()) {
+		t.Error("did not delete key holding the default value")
+	}
+	smt.Update([]byte("testKey"), defaultValue)
+	if _, err := smt.DeleteForRoot([]byte("testKey"), smt.Root()); err != nil {
+		t.Errorf("returned error when deleting key for root: %v", err)
+	}
+}
+
+// Test that keys of any length are accepted, and bounded in messages.
+func TestSparseMerkleTreeLongKey(t *testing.T) {
+	keys := NewSimpleMap()
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithKeyStore(keys))
+	key := bytes.Repeat([]byte{0xab}, 1<<20)
+	if _, err := smt.Update(key, []byte("testValue")); err != nil {
+		t.Fatalf("returned error when updating long key: %v", err)
+	}
+	value, err := smt.Get(key)
+	if err != nil || !bytes.Equal(value, []byte("testValue")) {
+		t.Errorf("did not get value of long key, got %s, %v", value, err)
+	}
+	proof, _ := smt.Prove(key)
+	if !VerifyProof(proof, smt.Root(), key, []byte("testValue"), sha256.New()) {
+		t.Error("proof of long key failed to verify")
+	}
+	stored, err := keys.Get(smt.th.path(key))
+	if err != nil || !bytes.Equal(stored, key) {
+		t.Error("did not retain long key")
+	}
+
+	message := (&InvalidKeyError{Key: key}).Error()
+	if len(message) > 200 {
+		t.Errorf("error message for long key is %d bytes", len(message))
+	}
+	if !strings.Contains(message, fmt.Sprintf("(%d bytes)", len(key))) {
+		t.Errorf("error message for long key does not give its length: %s", message)
+	}
+	short := (&InvalidKeyError{Key: []byte("testKey")}).Error()
+	if short != fmt.Sprintf("invalid key: %x", "testKey") {
+		t.Errorf("error message for short key is %s", short)
+	}
+}
+
+// Test that path bits match the order proofs are verified in.
+func TestPathBits(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 50; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte("testValue"))
+	}
+
+	for i := 0; i < 50; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		bits := smt.PathBits(key)
+		if len(bits) != 256 {
+			t.Fatalf("got %d path bits, expected 256", len(bits))
+		}
+
+		// Recompute the root from the leaf, combining side nodes as an
+		// external verifier would.
+		proof, _ := smt.Prove(key)
+		n := len(proof.SideNodes)
+		hash, _ := smt.th.digestLeaf(smt.th.path(key), smt.th.digest([]byte("testValue")))
+		for j, sideNode := range proof.SideNodes {
+			if bits[n-1-j] {
+				hash, _ = smt.th.digestNode(sideNode, hash)
+			} else {
+				hash, _ = smt.th.digestNode(hash, sideNode)
+			}
+		}
+		if !bytes.Equal(hash, smt.Root()) {
+			t.Errorf("path bits did not recompute the root for key %s", key)
+		}
+
+		path, _, _, _ := smt.LeafAt(bits[:n])
+		if !bytes.Equal(path, smt.th.path(key)) {
+			t.Errorf("path bits did not lead LeafAt to the leaf of key %s", key)
+		}
+	}
+}
+
+func TestAttachTree(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+	for i := 0; i < 10; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	attached, err := AttachTree(smn, smv, smt.Root(), sha256.New())
+	if err != nil {
+		t.Fatalf("returned error when attaching tree: %v", err)
+	}
+	if value, _ := attached.Get([]byte("testKey3")); !bytes.Equal(value, []byte("testValue3")) {
+		t.Error("attached tree did not get value")
+	}
+	if _, err := AttachTree(NewSimpleMap(), NewSimpleMap(), smt.th.placeholder(), sha256.New()); err != nil {
+		t.Errorf("returned error when attaching empty tree: %v", err)
+	}
+
+	if _, err := AttachTree(NewSimpleMap(), smv, smt.Root(), sha256.New()); !errors.Is(err, ErrRootNotFound) {
+		t.Errorf("did not return ErrRootNotFound for missing root, got %v", err)
+	}
+	rootData := smn.m[string(smt.Root())]
+	smn.m[string(smt.Root())] = append(append([]byte{}, rootData[:len(rootData)-1]...), rootData[len(rootData)-1]^1)
+	if _, err := AttachTree(smn, smv, smt.Root(), sha256.New()); !errors.Is(err, ErrBadRootNode) {
+		t.Errorf("did not return ErrBadRootNode for corrupt root, got %v", err)
+	} else if !errors.Is(err, ErrRootMismatch) {
+		t.Errorf("ErrBadRootNode is not an ErrRootMismatch: %v", err)
+	}
+}
+
+func TestMissingValueCheck(t *testing.T) {
+	values := NewSimpleMap()
+	smt := NewSparseMerkleTree(NewSimpleMap(), values, sha256.New(), WithMissingValueCheck())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	smt.UpdatePresence([]byte("testKey3"))
+
+	// Drop the value of a key from under the tree.
+	if err := values.Delete(smt.th.path([]byte("testKey"))); err != nil {
+		t.Fatalf("returned error when deleting value: %v", err)
+	}
+	_, err := smt.Get([]byte("testKey"))
+	if !errors.Is(err, ErrValueMissing) {
+		t.Errorf("did not return ErrValueMissing, got %v", err)
+	}
+	var invalidKeyError *InvalidKeyError
+	if errors.As(err, &invalidKeyError) {
+		t.Error("returned InvalidKeyError for a key in the tree")
+	}
+	if _, err := smt.Has([]byte("testKey")); !errors.Is(err, ErrValueMissing) {
+		t.Errorf("Has did not return ErrValueMissing, got %v", err)
+	}
+
+	for _, key := range []string{"testKey3", "testKey4"} {
+		if value, err := smt.Get([]byte(key)); err != nil || !bytes.Equal(value, defaultValue) {
+			t.Errorf("did not get default value for %s, got %v, %v", key, value, err)
+		}
+	}
+	if value, err := smt.Get([]byte("testKey2")); err != nil || !bytes.Equal(value, []byte("testValue2")) {
+		t.Errorf("did not get value, got %v, %v", value, err)
+	}
+
+	// Without the option, the key reads as absent.
+	lenient := ImportSparseMerkleTree(smt.nodes, values, sha256.New(), smt.Root())
+	if value, err := lenient.Get([]byte("testKey")); err != nil || !bytes.Equal(value, defaultValue) {
+		t.Errorf("did not get default value without check, got %v, %v", value, err)
+	}
+}
+
+func TestFixedValueSize(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithFixedValueSize(32))
+	value := bytes.Repeat([]byte{1}, 32)
+	if _, err := smt.Update([]byte("testKey"), value); err != nil {
+		t.Fatalf("returned error when setting a value of the fixed size: %v", err)
+	}
+	root := smt.Root()
+	for _, bad := range [][]byte{[]byte("short"), bytes.Repeat([]byte{1}, 33)} {
+		if _, err := smt.Update([]byte("testKey2"), bad); !errors.Is(err, ErrValueSize) {
+			t.Errorf("did not return ErrValueSize for a %d-byte value, got %v", len(bad), err)
+		}
+	}
+	if !bytes.Equal(smt.Root(), root) {
+		t.Error("root changed after rejected updates")
+	}
+	if _, err := smt.Update([]byte("testKey"), defaultValue); err != nil {
+		t.Errorf("returned error when deleting with the default value: %v", err)
+	}
+	smt.Update([]byte("testKey"), value)
+
+	proof, _ := smt.Prove([]byte("testKey"))
+	if !VerifyProof(proof, smt.Root(), []byte("testKey"), value, sha256.New(), WithVerifyValueSize(32)) {
+		t.Error("valid proof failed to verify with the value size")
+	}
+	absent, _ := smt.Prove([]byte("testKey2"))
+	if !VerifyProof(absent, smt.Root(), []byte("testKey2"), defaultValue, sha256.New(), WithVerifyValueSize(32)) {
+		t.Error("non-membership proof failed to verify with the value size")
+	}
+
+	// A tree without a fixed size proves short values, which the verifier
+	// rejects.
+	loose := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	loose.Update([]byte("testKey"), []byte("short"))
+	proof, _ = loose.Prove([]byte("testKey"))
+	if !VerifyProof(proof, loose.Root(), []byte("testKey"), []byte("short"), sha256.New()) {
+		t.Fatal("valid proof failed to verify")
+	}
+	if VerifyProof(proof, loose.Root(), []byte("testKey"), []byte("short"), sha256.New(), WithVerifyValueSize(32)) {
+		t.Error("proof of a value of the wrong size verified")
+	}
+
+	_, err := BuildFromSorted(NewSimpleMap(), NewSimpleMap(), sha256.New, NewSliceIterator([][]byte{[]byte("testKey")}, [][]byte{[]byte("short")}), WithFixedValueSize(32))
+	if !errors.Is(err, ErrValueSize) {
+		t.Errorf("BuildFromSorted did not return ErrValueSize, got %v", err)
+	}
+}
+
+func TestHasReadsNoValues(t *testing.T) {
+	nodes := &missingStore{SimpleMap: NewSimpleMap(), broken: map[string]bool{}}
+	values := &missingStore{SimpleMap: NewSimpleMap(), broken: map[string]bool{}}
+	smt := NewSparseMerkleTree(nodes, values, sha256.New())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	smt.UpdatePresence([]byte("testKey3"))
+	smt.UpdateLeafHash([]byte("testKey4"), sha256Sum([]byte("testValue4")))
+
+	// Values are not read, so a failing value store does not matter.
+	values.broken[string(smt.th.path([]byte("testKey")))] = true
+	for _, key := range []string{"testKey", "testKey2", "testKey3", "testKey4"} {
+		if has, err := smt.Has([]byte(key)); err != nil || !has {
+			t.Errorf("got %v, %v for %s, want it present", has, err, key)
+		}
+	}
+	if has, err := smt.Has([]byte("testKey5")); err != nil || has {
+		t.Errorf("got %v, %v for an absent key", has, err)
+	}
+
+	// A failing node store is not taken for an absent key.
+	nodes.broken[string(smt.Root())] = true
+	if _, err := smt.Has([]byte("testKey5")); !errors.Is(err, errBackend) {
+		t.Errorf("got %v from a failing node store, want the error of the store", err)
+	}
+
+	stored := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithStorableDefaultValue())
+	stored.Update([]byte("testKey"), defaultValue)
+	if has, _ := stored.Has([]byte("testKey")); !has {
+		t.Error("key set to the storable default value is not present")
+	}
+
+	lazy := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithLazyCommit())
+	lazy.Update([]byte("testKey"), []byte("testValue"))
+	if has, _ := lazy.Has([]byte("testKey")); !has {
+		t.Error("staged key is not present")
+	}
+	lazy.Commit()
+	lazy.Delete([]byte("testKey"))
+	if has, _ := lazy.Has([]byte("testKey")); has {
+		t.Error("key with a staged delete is present")
+	}
+}
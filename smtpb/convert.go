@@ -0,0 +1,90 @@
+// Package smtpb holds the Go bindings of the protocol buffer messages of
+// proto/smt.proto, generated with protoc-gen-go, and converts them to and
+// from the proofs and exported tries of package smt. The messages encode as
+// the ToProto methods of those types do, so that services exchanging proofs
+// and trie checkpoints as protobuf messages can embed them in their own:
+//
+//	proof, _ := tree.Prove(key)
+//	m, err := smtpb.FromSparseMerkleProof(proof)
+//	...
+//	proof, err = m.ToSparseMerkleProof()
+package smtpb
+
+import (
+	"google.golang.org/protobuf/proto"
+
+	"github.com/causevest/smt"
+)
+
+// FromSparseMerkleProof returns the SparseMerkleProof message of proof.
+func FromSparseMerkleProof(proof smt.SparseMerkleProof) (*SparseMerkleProof, error) {
+	m := new(SparseMerkleProof)
+	if err := proto.Unmarshal(proof.ToProto(), m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ToSparseMerkleProof returns the proof of the message, or an error wrapping
+// smt.ErrBadProof if it is malformed. The proof is only decoded, not checked;
+// see smt.VerifyProof.
+func (m *SparseMerkleProof) ToSparseMerkleProof() (smt.SparseMerkleProof, error) {
+	var proof smt.SparseMerkleProof
+	data, err := proto.Marshal(m)
+	if err != nil {
+		return proof, err
+	}
+	err = proof.FromProto(data)
+	return proof, err
+}
+
+// FromSparseCompactMerkleProof returns the SparseCompactMerkleProof message
+// of proof.
+func FromSparseCompactMerkleProof(proof smt.SparseCompactMerkleProof) (*SparseCompactMerkleProof, error) {
+	m := new(SparseCompactMerkleProof)
+	if err := proto.Unmarshal(proof.ToProto(), m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ToSparseCompactMerkleProof returns the proof of the message, or an error
+// wrapping smt.ErrBadProof if it is malformed.
+func (m *SparseCompactMerkleProof) ToSparseCompactMerkleProof() (smt.SparseCompactMerkleProof, error) {
+	var proof smt.SparseCompactMerkleProof
+	data, err := proto.Marshal(m)
+	if err != nil {
+		return proof, err
+	}
+	err = proof.FromProto(data)
+	return proof, err
+}
+
+// FromTrieWrap returns the TrieWrap message of an exported trie, with the
+// entries of its stores in key order.
+func FromTrieWrap(wrap *smt.TrieWrap) (*TrieWrap, error) {
+	data, err := wrap.ToProto()
+	if err != nil {
+		return nil, err
+	}
+	m := new(TrieWrap)
+	if err := proto.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ToTrieWrap returns the exported trie of the message, to be imported with
+// smt.ImportTrie, or an error wrapping smt.ErrSnapshotCorrupt if it is
+// malformed.
+func (m *TrieWrap) ToTrieWrap() (*smt.TrieWrap, error) {
+	data, err := proto.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	wrap := new(smt.TrieWrap)
+	if err := wrap.FromProto(data); err != nil {
+		return nil, err
+	}
+	return wrap, nil
+}
@@ -0,0 +1,125 @@
+package smtpb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/causevest/smt"
+)
+
+func TestSparseMerkleProof(t *testing.T) {
+	tree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), smt.NewSimpleMap(), sha256.New())
+	for i := 0; i < 20; i++ {
+		tree.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	membership, _ := tree.ProveUpdatable([]byte("testKey3"))
+	nonMembership, _ := tree.Prove([]byte("otherKey"))
+
+	for _, proof := range []smt.SparseMerkleProof{membership, nonMembership} {
+		m, err := FromSparseMerkleProof(proof)
+		if err != nil {
+			t.Fatalf("returned error when converting proof: %v", err)
+		}
+		if len(m.SideNodes) != len(proof.SideNodes) || !bytes.Equal(m.SideNodes[0], proof.SideNodes[0]) {
+			t.Errorf("message has side nodes %x, want %x", m.SideNodes, proof.SideNodes)
+		}
+		if !bytes.Equal(m.NonMembershipLeafData, proof.NonMembershipLeafData) || !bytes.Equal(m.SiblingData, proof.SiblingData) {
+			t.Error("message has other leaf data")
+		}
+		if m.Depth != uint64(proof.Depth) {
+			t.Errorf("message has depth %d, want %d", m.Depth, proof.Depth)
+		}
+		if data, _ := proto.Marshal(m); !bytes.Equal(data, proof.ToProto()) {
+			t.Error("message encodes differently from the proof")
+		}
+		decoded, err := m.ToSparseMerkleProof()
+		if err != nil {
+			t.Fatalf("returned error when converting message: %v", err)
+		}
+		if !reflect.DeepEqual(decoded, proof) {
+			t.Errorf("converted proof differs, got %+v, want %+v", decoded, proof)
+		}
+	}
+
+	m, _ := FromSparseMerkleProof(nonMembership)
+	decoded, _ := m.ToSparseMerkleProof()
+	if !smt.VerifyProof(decoded, tree.Root(), []byte("otherKey"), nil, sha256.New()) {
+		t.Error("converted proof does not verify")
+	}
+	m.Depth = 1 << 40
+	if _, err := m.ToSparseMerkleProof(); !errors.Is(err, smt.ErrBadProof) {
+		t.Errorf("got %v for a depth out of range, want ErrBadProof", err)
+	}
+
+	compact, _ := smt.CompactProof(membership, sha256.New())
+	mc, err := FromSparseCompactMerkleProof(compact)
+	if err != nil {
+		t.Fatalf("returned error when converting compact proof: %v", err)
+	}
+	if mc.NumSideNodes != uint64(compact.NumSideNodes) || !bytes.Equal(mc.BitMask, compact.BitMask) || len(mc.SideNodes) != len(compact.SideNodes) {
+		t.Error("message differs from the compact proof")
+	}
+	if data, _ := proto.Marshal(mc); !bytes.Equal(data, compact.ToProto()) {
+		t.Error("message encodes differently from the compact proof")
+	}
+	decodedCompact, err := mc.ToSparseCompactMerkleProof()
+	if err != nil {
+		t.Fatalf("returned error when converting compact message: %v", err)
+	}
+	if !smt.VerifyCompactProof(decodedCompact, tree.Root(), []byte("testKey3"), []byte("testValue3"), sha256.New()) {
+		t.Error("converted compact proof does not verify")
+	}
+}
+
+func TestTrieWrap(t *testing.T) {
+	trie := smt.NewMerkleTrie()
+	for i := 0; i < 20; i++ {
+		trie.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	wrap, _ := smt.ExportTrie(trie)
+	m, err := FromTrieWrap(wrap)
+	if err != nil {
+		t.Fatalf("returned error when converting trie: %v", err)
+	}
+	if !bytes.Equal(m.Root, wrap.Root) || m.Hash != wrap.Hash || m.SchemaVersion != uint64(wrap.SchemaVersion) || m.Depth != uint64(wrap.Depth) {
+		t.Errorf("message has root %x, hash %q, schema version %d and depth %d", m.Root, m.Hash, m.SchemaVersion, m.Depth)
+	}
+	if len(m.Values) != 20 {
+		t.Errorf("message has %d values, want 20", len(m.Values))
+	}
+	for i := 1; i < len(m.Nodes); i++ {
+		if bytes.Compare(m.Nodes[i-1].Key, m.Nodes[i].Key) >= 0 {
+			t.Fatal("nodes are not in key order")
+		}
+	}
+	data, _ := wrap.ToProto()
+	if again, _ := proto.Marshal(m); !bytes.Equal(again, data) {
+		t.Error("message encodes differently from the trie")
+	}
+
+	decoded, err := m.ToTrieWrap()
+	if err != nil {
+		t.Fatalf("returned error when converting message: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, wrap) {
+		t.Error("converted trie differs")
+	}
+	imported, err := smt.ImportTrie(decoded)
+	if err != nil {
+		t.Fatalf("returned error when importing trie: %v", err)
+	}
+	if value, _ := imported.Get([]byte("testKey7")); !bytes.Equal(value, []byte("testValue7")) {
+		t.Error("imported trie lost a value")
+	}
+
+	m.Values = append(m.Values, m.Values[0])
+	if _, err := m.ToTrieWrap(); !errors.Is(err, smt.ErrSnapshotCorrupt) {
+		t.Errorf("got %v for duplicate entries, want ErrSnapshotCorrupt", err)
+	}
+}
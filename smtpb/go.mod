@@ -0,0 +1,17 @@
+module github.com/causevest/smt/smtpb
+
+go 1.18
+
+require (
+	github.com/causevest/smt v0.0.0-00010101000000-000000000000
+	google.golang.org/protobuf v1.28.1
+)
+
+require (
+	github.com/google/go-cmp v0.5.6 // indirect
+	golang.org/x/crypto v0.2.0 // indirect
+	golang.org/x/sys v0.4.0 // indirect
+	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
+)
+
+replace github.com/causevest/smt => ..
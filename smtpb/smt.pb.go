@@ -0,0 +1,503 @@
+// Protocol buffer messages for the proofs and exported tries of
+// github.com/causevest/smt, for consumers in other languages. The Go package
+// encodes and decodes them with the ToProto and FromProto methods of
+// SparseMerkleProof, SparseCompactMerkleProof and TrieWrap. The Go code in
+// smtpb is generated from this file with protoc-gen-go:
+//
+//   protoc --go_out=. --go_opt=module=github.com/causevest/smt proto/smt.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.28.1
+// 	protoc        (unknown)
+// source: proto/smt.proto
+
+package smtpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// SparseMerkleProof is a Merkle proof of a key, as smt.SparseMerkleProof.
+type SparseMerkleProof struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// The side nodes of the path of the key, nearest to the leaf first.
+	SideNodes [][]byte `protobuf:"bytes,1,rep,name=side_nodes,json=sideNodes,proto3" json:"side_nodes,omitempty"`
+	// The leaf of another key at the position of the key, for a proof of
+	// non-membership by that leaf; empty otherwise.
+	NonMembershipLeafData []byte `protobuf:"bytes,2,opt,name=non_membership_leaf_data,json=nonMembershipLeafData,proto3" json:"non_membership_leaf_data,omitempty"`
+	// The data of the sibling of the leaf, for updatable proofs.
+	SiblingData []byte `protobuf:"bytes,3,opt,name=sibling_data,json=siblingData,proto3" json:"sibling_data,omitempty"`
+	// The number of bits of the paths of the tree, or 0 if not recorded.
+	Depth uint64 `protobuf:"varint,4,opt,name=depth,proto3" json:"depth,omitempty"`
+}
+
+func (x *SparseMerkleProof) Reset() {
+	*x = SparseMerkleProof{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_smt_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SparseMerkleProof) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SparseMerkleProof) ProtoMessage() {}
+
+func (x *SparseMerkleProof) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_smt_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SparseMerkleProof.ProtoReflect.Descriptor instead.
+func (*SparseMerkleProof) Descriptor() ([]byte, []int) {
+	return file_proto_smt_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SparseMerkleProof) GetSideNodes() [][]byte {
+	if x != nil {
+		return x.SideNodes
+	}
+	return nil
+}
+
+func (x *SparseMerkleProof) GetNonMembershipLeafData() []byte {
+	if x != nil {
+		return x.NonMembershipLeafData
+	}
+	return nil
+}
+
+func (x *SparseMerkleProof) GetSiblingData() []byte {
+	if x != nil {
+		return x.SiblingData
+	}
+	return nil
+}
+
+func (x *SparseMerkleProof) GetDepth() uint64 {
+	if x != nil {
+		return x.Depth
+	}
+	return 0
+}
+
+// SparseCompactMerkleProof is a proof with its placeholder side nodes left
+// out, as smt.SparseCompactMerkleProof.
+type SparseCompactMerkleProof struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// The side nodes that are not placeholders, nearest to the leaf first.
+	SideNodes             [][]byte `protobuf:"bytes,1,rep,name=side_nodes,json=sideNodes,proto3" json:"side_nodes,omitempty"`
+	NonMembershipLeafData []byte   `protobuf:"bytes,2,opt,name=non_membership_leaf_data,json=nonMembershipLeafData,proto3" json:"non_membership_leaf_data,omitempty"`
+	// Bit i, counting from the most significant bit of the first byte, is set
+	// if side node i of the full proof is a placeholder.
+	BitMask []byte `protobuf:"bytes,3,opt,name=bit_mask,json=bitMask,proto3" json:"bit_mask,omitempty"`
+	// The number of side nodes of the full proof.
+	NumSideNodes uint64 `protobuf:"varint,4,opt,name=num_side_nodes,json=numSideNodes,proto3" json:"num_side_nodes,omitempty"`
+	SiblingData  []byte `protobuf:"bytes,5,opt,name=sibling_data,json=siblingData,proto3" json:"sibling_data,omitempty"`
+	Depth        uint64 `protobuf:"varint,6,opt,name=depth,proto3" json:"depth,omitempty"`
+}
+
+func (x *SparseCompactMerkleProof) Reset() {
+	*x = SparseCompactMerkleProof{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_smt_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SparseCompactMerkleProof) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SparseCompactMerkleProof) ProtoMessage() {}
+
+func (x *SparseCompactMerkleProof) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_smt_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SparseCompactMerkleProof.ProtoReflect.Descriptor instead.
+func (*SparseCompactMerkleProof) Descriptor() ([]byte, []int) {
+	return file_proto_smt_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *SparseCompactMerkleProof) GetSideNodes() [][]byte {
+	if x != nil {
+		return x.SideNodes
+	}
+	return nil
+}
+
+func (x *SparseCompactMerkleProof) GetNonMembershipLeafData() []byte {
+	if x != nil {
+		return x.NonMembershipLeafData
+	}
+	return nil
+}
+
+func (x *SparseCompactMerkleProof) GetBitMask() []byte {
+	if x != nil {
+		return x.BitMask
+	}
+	return nil
+}
+
+func (x *SparseCompactMerkleProof) GetNumSideNodes() uint64 {
+	if x != nil {
+		return x.NumSideNodes
+	}
+	return 0
+}
+
+func (x *SparseCompactMerkleProof) GetSiblingData() []byte {
+	if x != nil {
+		return x.SiblingData
+	}
+	return nil
+}
+
+func (x *SparseCompactMerkleProof) GetDepth() uint64 {
+	if x != nil {
+		return x.Depth
+	}
+	return 0
+}
+
+// Entry is an entry of a store.
+type Entry struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Key   []byte `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+}
+
+func (x *Entry) Reset() {
+	*x = Entry{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_smt_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Entry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Entry) ProtoMessage() {}
+
+func (x *Entry) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_smt_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Entry.ProtoReflect.Descriptor instead.
+func (*Entry) Descriptor() ([]byte, []int) {
+	return file_proto_smt_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Entry) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+func (x *Entry) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+// TrieWrap is an exported trie, as smt.TrieWrap, with its stores as entries
+// in key order.
+type TrieWrap struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Root          []byte   `protobuf:"bytes,1,opt,name=root,proto3" json:"root,omitempty"`
+	Nodes         []*Entry `protobuf:"bytes,2,rep,name=nodes,proto3" json:"nodes,omitempty"`
+	Values        []*Entry `protobuf:"bytes,3,rep,name=values,proto3" json:"values,omitempty"`
+	SchemaVersion uint64   `protobuf:"varint,4,opt,name=schema_version,json=schemaVersion,proto3" json:"schema_version,omitempty"`
+	// The name of the hash algorithm of the trie, such as "sha256", or empty
+	// if it is not a registered one.
+	Hash string `protobuf:"bytes,5,opt,name=hash,proto3" json:"hash,omitempty"`
+	// The number of bits of the paths of the trie, or 0 if it is not recorded.
+	Depth uint64 `protobuf:"varint,6,opt,name=depth,proto3" json:"depth,omitempty"`
+}
+
+func (x *TrieWrap) Reset() {
+	*x = TrieWrap{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_smt_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TrieWrap) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TrieWrap) ProtoMessage() {}
+
+func (x *TrieWrap) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_smt_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TrieWrap.ProtoReflect.Descriptor instead.
+func (*TrieWrap) Descriptor() ([]byte, []int) {
+	return file_proto_smt_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *TrieWrap) GetRoot() []byte {
+	if x != nil {
+		return x.Root
+	}
+	return nil
+}
+
+func (x *TrieWrap) GetNodes() []*Entry {
+	if x != nil {
+		return x.Nodes
+	}
+	return nil
+}
+
+func (x *TrieWrap) GetValues() []*Entry {
+	if x != nil {
+		return x.Values
+	}
+	return nil
+}
+
+func (x *TrieWrap) GetSchemaVersion() uint64 {
+	if x != nil {
+		return x.SchemaVersion
+	}
+	return 0
+}
+
+func (x *TrieWrap) GetHash() string {
+	if x != nil {
+		return x.Hash
+	}
+	return ""
+}
+
+func (x *TrieWrap) GetDepth() uint64 {
+	if x != nil {
+		return x.Depth
+	}
+	return 0
+}
+
+var File_proto_smt_proto protoreflect.FileDescriptor
+
+var file_proto_smt_proto_rawDesc = []byte{
+	0x0a, 0x0f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x73, 0x6d, 0x74, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x12, 0x10, 0x63, 0x61, 0x75, 0x73, 0x65, 0x76, 0x65, 0x73, 0x74, 0x2e, 0x73, 0x6d, 0x74,
+	0x2e, 0x76, 0x31, 0x22, 0xa4, 0x01, 0x0a, 0x11, 0x53, 0x70, 0x61, 0x72, 0x73, 0x65, 0x4d, 0x65,
+	0x72, 0x6b, 0x6c, 0x65, 0x50, 0x72, 0x6f, 0x6f, 0x66, 0x12, 0x1d, 0x0a, 0x0a, 0x73, 0x69, 0x64,
+	0x65, 0x5f, 0x6e, 0x6f, 0x64, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x09, 0x73,
+	0x69, 0x64, 0x65, 0x4e, 0x6f, 0x64, 0x65, 0x73, 0x12, 0x37, 0x0a, 0x18, 0x6e, 0x6f, 0x6e, 0x5f,
+	0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x68, 0x69, 0x70, 0x5f, 0x6c, 0x65, 0x61, 0x66, 0x5f,
+	0x64, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x15, 0x6e, 0x6f, 0x6e, 0x4d,
+	0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x68, 0x69, 0x70, 0x4c, 0x65, 0x61, 0x66, 0x44, 0x61, 0x74,
+	0x61, 0x12, 0x21, 0x0a, 0x0c, 0x73, 0x69, 0x62, 0x6c, 0x69, 0x6e, 0x67, 0x5f, 0x64, 0x61, 0x74,
+	0x61, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0b, 0x73, 0x69, 0x62, 0x6c, 0x69, 0x6e, 0x67,
+	0x44, 0x61, 0x74, 0x61, 0x12, 0x14, 0x0a, 0x05, 0x64, 0x65, 0x70, 0x74, 0x68, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x04, 0x52, 0x05, 0x64, 0x65, 0x70, 0x74, 0x68, 0x22, 0xec, 0x01, 0x0a, 0x18, 0x53,
+	0x70, 0x61, 0x72, 0x73, 0x65, 0x43, 0x6f, 0x6d, 0x70, 0x61, 0x63, 0x74, 0x4d, 0x65, 0x72, 0x6b,
+	0x6c, 0x65, 0x50, 0x72, 0x6f, 0x6f, 0x66, 0x12, 0x1d, 0x0a, 0x0a, 0x73, 0x69, 0x64, 0x65, 0x5f,
+	0x6e, 0x6f, 0x64, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x09, 0x73, 0x69, 0x64,
+	0x65, 0x4e, 0x6f, 0x64, 0x65, 0x73, 0x12, 0x37, 0x0a, 0x18, 0x6e, 0x6f, 0x6e, 0x5f, 0x6d, 0x65,
+	0x6d, 0x62, 0x65, 0x72, 0x73, 0x68, 0x69, 0x70, 0x5f, 0x6c, 0x65, 0x61, 0x66, 0x5f, 0x64, 0x61,
+	0x74, 0x61, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x15, 0x6e, 0x6f, 0x6e, 0x4d, 0x65, 0x6d,
+	0x62, 0x65, 0x72, 0x73, 0x68, 0x69, 0x70, 0x4c, 0x65, 0x61, 0x66, 0x44, 0x61, 0x74, 0x61, 0x12,
+	0x19, 0x0a, 0x08, 0x62, 0x69, 0x74, 0x5f, 0x6d, 0x61, 0x73, 0x6b, 0x18, 0x03, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x07, 0x62, 0x69, 0x74, 0x4d, 0x61, 0x73, 0x6b, 0x12, 0x24, 0x0a, 0x0e, 0x6e, 0x75,
+	0x6d, 0x5f, 0x73, 0x69, 0x64, 0x65, 0x5f, 0x6e, 0x6f, 0x64, 0x65, 0x73, 0x18, 0x04, 0x20, 0x01,
+	0x28, 0x04, 0x52, 0x0c, 0x6e, 0x75, 0x6d, 0x53, 0x69, 0x64, 0x65, 0x4e, 0x6f, 0x64, 0x65, 0x73,
+	0x12, 0x21, 0x0a, 0x0c, 0x73, 0x69, 0x62, 0x6c, 0x69, 0x6e, 0x67, 0x5f, 0x64, 0x61, 0x74, 0x61,
+	0x18, 0x05, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0b, 0x73, 0x69, 0x62, 0x6c, 0x69, 0x6e, 0x67, 0x44,
+	0x61, 0x74, 0x61, 0x12, 0x14, 0x0a, 0x05, 0x64, 0x65, 0x70, 0x74, 0x68, 0x18, 0x06, 0x20, 0x01,
+	0x28, 0x04, 0x52, 0x05, 0x64, 0x65, 0x70, 0x74, 0x68, 0x22, 0x2f, 0x0a, 0x05, 0x45, 0x6e, 0x74,
+	0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52,
+	0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x0c, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x22, 0xcf, 0x01, 0x0a, 0x08, 0x54,
+	0x72, 0x69, 0x65, 0x57, 0x72, 0x61, 0x70, 0x12, 0x12, 0x0a, 0x04, 0x72, 0x6f, 0x6f, 0x74, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x72, 0x6f, 0x6f, 0x74, 0x12, 0x2d, 0x0a, 0x05, 0x6e,
+	0x6f, 0x64, 0x65, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x17, 0x2e, 0x63, 0x61, 0x75,
+	0x73, 0x65, 0x76, 0x65, 0x73, 0x74, 0x2e, 0x73, 0x6d, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x45, 0x6e,
+	0x74, 0x72, 0x79, 0x52, 0x05, 0x6e, 0x6f, 0x64, 0x65, 0x73, 0x12, 0x2f, 0x0a, 0x06, 0x76, 0x61,
+	0x6c, 0x75, 0x65, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x17, 0x2e, 0x63, 0x61, 0x75,
+	0x73, 0x65, 0x76, 0x65, 0x73, 0x74, 0x2e, 0x73, 0x6d, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x45, 0x6e,
+	0x74, 0x72, 0x79, 0x52, 0x06, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x73, 0x12, 0x25, 0x0a, 0x0e, 0x73,
+	0x63, 0x68, 0x65, 0x6d, 0x61, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x04, 0x52, 0x0d, 0x73, 0x63, 0x68, 0x65, 0x6d, 0x61, 0x56, 0x65, 0x72, 0x73, 0x69,
+	0x6f, 0x6e, 0x12, 0x12, 0x0a, 0x04, 0x68, 0x61, 0x73, 0x68, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x04, 0x68, 0x61, 0x73, 0x68, 0x12, 0x14, 0x0a, 0x05, 0x64, 0x65, 0x70, 0x74, 0x68, 0x18,
+	0x06, 0x20, 0x01, 0x28, 0x04, 0x52, 0x05, 0x64, 0x65, 0x70, 0x74, 0x68, 0x42, 0x20, 0x5a, 0x1e,
+	0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x63, 0x61, 0x75, 0x73, 0x65,
+	0x76, 0x65, 0x73, 0x74, 0x2f, 0x73, 0x6d, 0x74, 0x2f, 0x73, 0x6d, 0x74, 0x70, 0x62, 0x62, 0x06,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_proto_smt_proto_rawDescOnce sync.Once
+	file_proto_smt_proto_rawDescData = file_proto_smt_proto_rawDesc
+)
+
+func file_proto_smt_proto_rawDescGZIP() []byte {
+	file_proto_smt_proto_rawDescOnce.Do(func() {
+		file_proto_smt_proto_rawDescData = protoimpl.X.CompressGZIP(file_proto_smt_proto_rawDescData)
+	})
+	return file_proto_smt_proto_rawDescData
+}
+
+var file_proto_smt_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_proto_smt_proto_goTypes = []interface{}{
+	(*SparseMerkleProof)(nil),        // 0: causevest.smt.v1.SparseMerkleProof
+	(*SparseCompactMerkleProof)(nil), // 1: causevest.smt.v1.SparseCompactMerkleProof
+	(*Entry)(nil),                    // 2: causevest.smt.v1.Entry
+	(*TrieWrap)(nil),                 // 3: causevest.smt.v1.TrieWrap
+}
+var file_proto_smt_proto_depIdxs = []int32{
+	2, // 0: causevest.smt.v1.TrieWrap.nodes:type_name -> causevest.smt.v1.Entry
+	2, // 1: causevest.smt.v1.TrieWrap.values:type_name -> causevest.smt.v1.Entry
+	2, // [2:2] is the sub-list for method output_type
+	2, // [2:2] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_proto_smt_proto_init() }
+func file_proto_smt_proto_init() {
+	if File_proto_smt_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_proto_smt_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SparseMerkleProof); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_smt_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SparseCompactMerkleProof); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_smt_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Entry); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_smt_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TrieWrap); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_proto_smt_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_proto_smt_proto_goTypes,
+		DependencyIndexes: file_proto_smt_proto_depIdxs,
+		MessageInfos:      file_proto_smt_proto_msgTypes,
+	}.Build()
+	File_proto_smt_proto = out.File
+	file_proto_smt_proto_rawDesc = nil
+	file_proto_smt_proto_goTypes = nil
+	file_proto_smt_proto_depIdxs = nil
+}
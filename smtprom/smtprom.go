@@ -0,0 +1,235 @@
+// Package smtprom records the measurements of smt trees, made through
+// smt.Metrics, in Prometheus collectors, so that operators can follow the
+// latencies, store traffic and size of the trees of a process:
+//
+//	collector := smtprom.NewCollector()
+//	prometheus.MustRegister(collector)
+//	tree := smt.NewSparseMerkleTree(nodes, values, sha256.New(), smt.WithMetrics(collector))
+//	collector.TrackSize(tree)
+//
+// The metrics, under the namespace smt unless WithNamespace is given, are:
+//
+//	smt_operation_duration_seconds  histogram of the calls of get, update,
+//	                                delete, prove and commit, by operation
+//	                                and result, ok or error
+//	smt_proof_size_bytes            histogram of the sizes of proofs
+//	smt_node_reads_total            nodes read, by source, store or cache;
+//	                                the hit rate of the node cache is the
+//	                                share of cache reads
+//	smt_node_writes_total           nodes written to the node store
+//	smt_node_deletes_total          nodes deleted from the node store
+//	smt_leaves                      leaves of the tree of TrackSize
+//	smt_stored_nodes                nodes in its node store
+//	smt_stored_node_bytes           size of the nodes in its node store
+//
+// A collector can be shared by several trees, whose measurements it adds up;
+// register one per tree, with WithLabels telling them apart, to follow them
+// separately.
+package smtprom
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/causevest/smt"
+)
+
+// Option is an option of a Collector.
+type Option func(*config)
+
+type config struct {
+	namespace        string
+	labels           prometheus.Labels
+	durationBuckets  []float64
+	proofSizeBuckets []float64
+}
+
+// WithNamespace sets the namespace of the metrics, smt by default.
+func WithNamespace(namespace string) Option {
+	return func(c *config) {
+		c.namespace = namespace
+	}
+}
+
+// WithLabels sets constant labels of every metric, such as the name of the
+// tree measured.
+func WithLabels(labels prometheus.Labels) Option {
+	return func(c *config) {
+		c.labels = labels
+	}
+}
+
+// WithDurationBuckets sets the buckets of the operation durations, in
+// seconds, prometheus.DefBuckets by default.
+func WithDurationBuckets(buckets []float64) Option {
+	return func(c *config) {
+		c.durationBuckets = buckets
+	}
+}
+
+// WithProofSizeBuckets sets the buckets of the proof sizes, in bytes,
+// powers of 2 from 64 to 32768 by default.
+func WithProofSizeBuckets(buckets []float64) Option {
+	return func(c *config) {
+		c.proofSizeBuckets = buckets
+	}
+}
+
+// Collector records smt.Metrics as Prometheus metrics. It is both the
+// smt.Metrics of the trees measured and the prometheus.Collector registered
+// to export them.
+type Collector struct {
+	operations  *prometheus.HistogramVec
+	proofSizes  prometheus.Histogram
+	nodeReads   *prometheus.CounterVec
+	nodeWrites  prometheus.Counter
+	nodeDeletes prometheus.Counter
+
+	storeReads, cacheReads prometheus.Counter
+
+	leavesDesc, nodesDesc, nodeBytesDesc *prometheus.Desc
+
+	mu   sync.Mutex
+	tree *smt.SparseMerkleTree
+}
+
+var _ smt.Metrics = (*Collector)(nil)
+
+// NewCollector creates a Collector.
+func NewCollector(options ...Option) *Collector {
+	cfg := config{
+		namespace:        "smt",
+		durationBuckets:  prometheus.DefBuckets,
+		proofSizeBuckets: prometheus.ExponentialBuckets(64, 2, 10),
+	}
+	for _, option := range options {
+		option(&cfg)
+	}
+	c := &Collector{
+		operations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   cfg.namespace,
+			Name:        "operation_duration_seconds",
+			Help:        "Duration of the operations of the tree.",
+			ConstLabels: cfg.labels,
+			Buckets:     cfg.durationBuckets,
+		}, []string{"operation", "result"}),
+		proofSizes: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:   cfg.namespace,
+			Name:        "proof_size_bytes",
+			Help:        "Size of the hashes and leaf data of the proofs generated.",
+			ConstLabels: cfg.labels,
+			Buckets:     cfg.proofSizeBuckets,
+		}),
+		nodeReads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   cfg.namespace,
+			Name:        "node_reads_total",
+			Help:        "Nodes read, from the node store or the node cache.",
+			ConstLabels: cfg.labels,
+		}, []string{"source"}),
+		nodeWrites: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   cfg.namespace,
+			Name:        "node_writes_total",
+			Help:        "Nodes written to the node store.",
+			ConstLabels: cfg.labels,
+		}),
+		nodeDeletes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   cfg.namespace,
+			Name:        "node_deletes_total",
+			Help:        "Nodes deleted from the node store.",
+			ConstLabels: cfg.labels,
+		}),
+		leavesDesc: prometheus.NewDesc(prometheus.BuildFQName(cfg.namespace, "", "leaves"),
+			"Leaves of the tree.", nil, cfg.labels),
+		nodesDesc: prometheus.NewDesc(prometheus.BuildFQName(cfg.namespace, "", "stored_nodes"),
+			"Nodes in the node store of the tree.", nil, cfg.labels),
+		nodeBytesDesc: prometheus.NewDesc(prometheus.BuildFQName(cfg.namespace, "", "stored_node_bytes"),
+			"Size of the nodes in the node store of the tree.", nil, cfg.labels),
+	}
+	c.storeReads = c.nodeReads.WithLabelValues("store")
+	c.cacheReads = c.nodeReads.WithLabelValues("cache")
+	return c
+}
+
+// TrackSize makes the collector export the size of tree, which it measures
+// with tree.Stats each time it is collected. As Stats walks the whole tree,
+// large trees should be scraped sparingly, or bounded with
+// SetOperationLimit, over which the size is reported as an error of the
+// scrape. The nodes Stats reads count in smt_node_reads_total if the tree
+// is measured by the collector. A nil tree stops tracking.
+func (c *Collector) TrackSize(tree *smt.SparseMerkleTree) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tree = tree
+}
+
+// ObserveOperation implements smt.Metrics.
+func (c *Collector) ObserveOperation(op smt.Operation, d time.Duration, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	c.operations.WithLabelValues(op.String(), result).Observe(d.Seconds())
+}
+
+// ObserveProofSize implements smt.Metrics.
+func (c *Collector) ObserveProofSize(size int) {
+	c.proofSizes.Observe(float64(size))
+}
+
+// AddNodeReads implements smt.Metrics.
+func (c *Collector) AddNodeReads(fromStore, fromCache int) {
+	if fromStore != 0 {
+		c.storeReads.Add(float64(fromStore))
+	}
+	if fromCache != 0 {
+		c.cacheReads.Add(float64(fromCache))
+	}
+}
+
+// AddNodeWrites implements smt.Metrics.
+func (c *Collector) AddNodeWrites(written, deleted int) {
+	if written != 0 {
+		c.nodeWrites.Add(float64(written))
+	}
+	if deleted != 0 {
+		c.nodeDeletes.Add(float64(deleted))
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.operations.Describe(ch)
+	c.proofSizes.Describe(ch)
+	c.nodeReads.Describe(ch)
+	c.nodeWrites.Describe(ch)
+	c.nodeDeletes.Describe(ch)
+	ch <- c.leavesDesc
+	ch <- c.nodesDesc
+	ch <- c.nodeBytesDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.operations.Collect(ch)
+	c.proofSizes.Collect(ch)
+	c.nodeReads.Collect(ch)
+	c.nodeWrites.Collect(ch)
+	c.nodeDeletes.Collect(ch)
+
+	c.mu.Lock()
+	tree := c.tree
+	c.mu.Unlock()
+	if tree == nil {
+		return
+	}
+	stats, err := tree.Stats()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.leavesDesc, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.leavesDesc, prometheus.GaugeValue, float64(stats.Leaves))
+	ch <- prometheus.MustNewConstMetric(c.nodesDesc, prometheus.GaugeValue, float64(stats.StoredNodes))
+	ch <- prometheus.MustNewConstMetric(c.nodeBytesDesc, prometheus.GaugeValue, float64(stats.NodeBytes))
+}
@@ -0,0 +1,99 @@
+package smtprom
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/causevest/smt"
+)
+
+// gather collects the metrics of collector, by name.
+func gather(t *testing.T, collector prometheus.Collector) map[string]*dto.MetricFamily {
+	t.Helper()
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(collector)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("returned error when gathering: %v", err)
+	}
+	byName := make(map[string]*dto.MetricFamily)
+	for _, family := range families {
+		byName[family.GetName()] = family
+	}
+	return byName
+}
+
+func TestCollector(t *testing.T) {
+	collector := NewCollector(WithLabels(prometheus.Labels{"tree": "state"}))
+	tree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), smt.NewSimpleMap(), sha256.New(), smt.WithMetrics(collector))
+	tree.EnableNodeHashCache(16)
+	collector.TrackSize(tree)
+	tree.Update([]byte("foo"), []byte("bar"))
+	tree.Update([]byte("baz"), []byte("qux"))
+	tree.Get([]byte("foo"))
+	tree.Get([]byte("foo"))
+	tree.Prove([]byte("foo"))
+	tree.Prove([]byte("foo"))
+	tree.Seal()
+	tree.Delete([]byte("foo"))
+
+	families := gather(t, collector)
+	operations := families["smt_operation_duration_seconds"]
+	if operations == nil {
+		t.Fatal("no operation durations")
+	}
+	counts := make(map[string]uint64)
+	for _, metric := range operations.Metric {
+		var operation, result string
+		for _, label := range metric.Label {
+			switch label.GetName() {
+			case "operation":
+				operation = label.GetValue()
+			case "result":
+				result = label.GetValue()
+			case "tree":
+				if label.GetValue() != "state" {
+					t.Errorf("got tree label %q", label.GetValue())
+				}
+			}
+		}
+		counts[operation+"/"+result] = metric.Histogram.GetSampleCount()
+	}
+	for key, want := range map[string]uint64{"update/ok": 2, "get/ok": 2, "prove/ok": 2, "delete/error": 1} {
+		if counts[key] != want {
+			t.Errorf("got %d %s operations, want %d", counts[key], key, want)
+		}
+	}
+	if got := families["smt_proof_size_bytes"].Metric[0].Histogram.GetSampleCount(); got != 2 {
+		t.Errorf("got %d proof sizes, want 2", got)
+	}
+
+	reads := make(map[string]float64)
+	for _, metric := range families["smt_node_reads_total"].Metric {
+		for _, label := range metric.Label {
+			if label.GetName() == "source" {
+				reads[label.GetValue()] = metric.Counter.GetValue()
+			}
+		}
+	}
+	if reads["store"] == 0 || reads["cache"] == 0 {
+		t.Errorf("got node reads %v", reads)
+	}
+	if families["smt_node_writes_total"].Metric[0].Counter.GetValue() == 0 {
+		t.Error("no node writes")
+	}
+	if got := families["smt_leaves"].Metric[0].Gauge.GetValue(); got != 2 {
+		t.Errorf("got %v leaves, want 2", got)
+	}
+	if families["smt_stored_nodes"].Metric[0].Gauge.GetValue() == 0 || families["smt_stored_node_bytes"].Metric[0].Gauge.GetValue() == 0 {
+		t.Error("no stored nodes")
+	}
+
+	collector.TrackSize(nil)
+	if _, ok := gather(t, collector)["smt_leaves"]; ok {
+		t.Error("size of a tree no longer tracked was collected")
+	}
+}
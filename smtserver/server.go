@@ -0,0 +1,204 @@
+// Package smtserver serves a smt.SparseMerkleTree over gRPC, so that processes
+// other than the one holding a tree can read it, prove its keys and follow its
+// changes. The service is the Tree service of proto/smtserver.proto, from
+// which the client and server stubs of this package are generated; clients in
+// Go call it through NewTreeClient.
+package smtserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/causevest/smt"
+)
+
+// Authorizer decides whether the caller of an update may make it, from the
+// context of the call, which carries its metadata and peer. An error denies
+// the update, and is returned to the caller with code PermissionDenied,
+// unless it is a status error of its own.
+type Authorizer func(ctx context.Context) error
+
+// Option is an option of a Server.
+type Option func(*Server)
+
+// WithAuthorizer makes the server accept the updates that authorize allows.
+// Without it, every update is rejected.
+func WithAuthorizer(authorize Authorizer) Option {
+	if authorize == nil {
+		panic("smtserver: nil authorizer")
+	}
+	return func(s *Server) {
+		s.authorize = authorize
+	}
+}
+
+// Server serves a tree as the Tree service. Register it on a grpc.Server with
+// RegisterTreeServer. Calls are served concurrently, so a tree updated
+// through the server should be created with smt.WithConcurrentReads, and
+// Diff needs roots retained with smt.WithRootRetention.
+type Server struct {
+	UnimplementedTreeServer
+
+	tree      *smt.SparseMerkleTree
+	authorize Authorizer
+}
+
+// NewServer creates a Server for tree.
+func NewServer(tree *smt.SparseMerkleTree, options ...Option) *Server {
+	if tree == nil {
+		panic("smtserver: nil tree")
+	}
+	s := &Server{tree: tree}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// Get returns the value of a key.
+func (s *Server) Get(ctx context.Context, req *GetRequest) (*GetResponse, error) {
+	value, err := s.tree.Get(req.Key)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &GetResponse{Value: value}, nil
+}
+
+// Update sets the value of a key, for a caller the server authorizes.
+func (s *Server) Update(ctx context.Context, req *UpdateRequest) (*UpdateResponse, error) {
+	if s.authorize == nil {
+		return nil, status.Error(codes.PermissionDenied, "smtserver: updates are not accepted")
+	}
+	if err := s.authorize(ctx); err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	root, err := s.tree.Update(req.Key, req.Value)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &UpdateResponse{Root: root}, nil
+}
+
+// Prove returns a proof of the value of a key, read from a snapshot of the
+// tree so that the value, proof and root agree while the tree is updated.
+func (s *Server) Prove(ctx context.Context, req *ProveRequest) (*ProveResponse, error) {
+	snapshot := s.tree.Snapshot()
+	defer snapshot.Release()
+	value, err := snapshot.Get(req.Key)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	resp := &ProveResponse{Root: snapshot.Root(), Value: value}
+	if req.Compact {
+		proof, err := snapshot.ProveCompact(req.Key)
+		if err != nil {
+			return nil, statusError(err)
+		}
+		resp.Proof = proof.ToProto()
+	} else {
+		proof, err := snapshot.Prove(req.Key)
+		if err != nil {
+			return nil, statusError(err)
+		}
+		resp.Proof = proof.ToProto()
+	}
+	return resp, nil
+}
+
+// GetRoot returns the current root of the tree.
+func (s *Server) GetRoot(ctx context.Context, req *GetRootRequest) (*GetRootResponse, error) {
+	return &GetRootResponse{Root: s.tree.Root()}, nil
+}
+
+// Diff streams the changes of the keys between two roots.
+func (s *Server) Diff(req *DiffRequest, stream Tree_DiffServer) error {
+	changes, err := s.tree.Diff(req.OldRoot, req.NewRoot)
+	if err != nil {
+		return statusError(err)
+	}
+	for _, c := range changes {
+		if err := stream.Send(&KeyChange{
+			Kind:     KeyChange_Kind(c.Kind),
+			Path:     c.Path,
+			Key:      c.Key,
+			OldValue: c.OldValue,
+			NewValue: c.NewValue,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statusError returns err as a status error, with the code of the errors of
+// package smt it wraps.
+func statusError(err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, smt.ErrRootPruned):
+		code = codes.NotFound
+	case errors.Is(err, smt.ErrSealed), errors.Is(err, smt.ErrLimitExceeded):
+		code = codes.FailedPrecondition
+	case errors.Is(err, smt.ErrPathCollision), errors.Is(err, smt.ErrValueSize):
+		code = codes.InvalidArgument
+	case errors.Is(err, smt.ErrStoreFull):
+		code = codes.ResourceExhausted
+	}
+	return status.Error(code, err.Error())
+}
+
+// authorizationKey is the metadata key of bearer tokens.
+const authorizationKey = "authorization"
+
+const bearerPrefix = "Bearer "
+
+// TokenAuthorizer returns an Authorizer for the callers that send token as a
+// bearer token in the authorization metadata of their calls, as clients with
+// TokenCredentials do. Calls without it are denied with code Unauthenticated.
+func TokenAuthorizer(token string) Authorizer {
+	if token == "" {
+		panic("smtserver: empty token")
+	}
+	return func(ctx context.Context) error {
+		md, _ := metadata.FromIncomingContext(ctx)
+		for _, value := range md.Get(authorizationKey) {
+			if strings.HasPrefix(value, bearerPrefix) &&
+				subtle.ConstantTimeCompare([]byte(value[len(bearerPrefix):]), []byte(token)) == 1 {
+				return nil
+			}
+		}
+		return status.Error(codes.Unauthenticated, "smtserver: missing or invalid token")
+	}
+}
+
+// TokenCredentials returns the credentials of a client sending token to a
+// server authorizing it with TokenAuthorizer, to be given to grpc.Dial with
+// grpc.WithPerRPCCredentials. They require a secure transport, as the token
+// would otherwise be sent in the clear.
+func TokenCredentials(token string) credentials.PerRPCCredentials {
+	return tokenCredentials(token)
+}
+
+type tokenCredentials string
+
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{authorizationKey: bearerPrefix + string(t)}, nil
+}
+
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return true
+}
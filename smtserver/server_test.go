@@ -0,0 +1,136 @@
+package smtserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/causevest/smt"
+)
+
+// dial serves tree with options over an in-memory connection, returning a
+// client of it.
+func dial(t *testing.T, tree *smt.SparseMerkleTree, options ...Option) TreeClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	RegisterTreeServer(server, NewServer(tree, options...))
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("returned error when dialing: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewTreeClient(conn)
+}
+
+func TestServer(t *testing.T) {
+	tree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), smt.NewSimpleMap(), sha256.New(),
+		smt.WithConcurrentReads(), smt.WithRootRetention(10), smt.WithKeyStore(smt.NewSimpleMap()))
+	client := dial(t, tree, WithAuthorizer(TokenAuthorizer("secret")))
+	ctx := context.Background()
+	authorized := metadata.AppendToOutgoingContext(ctx, authorizationKey, bearerPrefix+"secret")
+
+	oldRoot, _ := client.GetRoot(ctx, &GetRootRequest{})
+	if _, err := client.Update(ctx, &UpdateRequest{Key: []byte("foo"), Value: []byte("bar")}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("got %v for an update without a token, want Unauthenticated", err)
+	}
+	wrong := metadata.AppendToOutgoingContext(ctx, authorizationKey, bearerPrefix+"guess")
+	if _, err := client.Update(wrong, &UpdateRequest{Key: []byte("foo"), Value: []byte("bar")}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("got %v for an update with the wrong token, want Unauthenticated", err)
+	}
+	updated, err := client.Update(authorized, &UpdateRequest{Key: []byte("foo"), Value: []byte("bar")})
+	if err != nil {
+		t.Fatalf("returned error when updating: %v", err)
+	}
+	if !bytes.Equal(updated.Root, tree.Root()) {
+		t.Error("update did not return the root of the tree")
+	}
+	client.Update(authorized, &UpdateRequest{Key: []byte("baz"), Value: []byte("qux")})
+
+	got, err := client.Get(ctx, &GetRequest{Key: []byte("foo")})
+	if err != nil || !bytes.Equal(got.Value, []byte("bar")) {
+		t.Errorf("got %v, %v for foo", got, err)
+	}
+	root, _ := client.GetRoot(ctx, &GetRootRequest{})
+	if !bytes.Equal(root.Root, tree.Root()) {
+		t.Error("did not get the root of the tree")
+	}
+
+	for _, compact := range []bool{false, true} {
+		resp, err := client.Prove(ctx, &ProveRequest{Key: []byte("foo"), Compact: compact})
+		if err != nil {
+			t.Fatalf("returned error when proving: %v", err)
+		}
+		var proof smt.SparseMerkleProof
+		if compact {
+			var compactProof smt.SparseCompactMerkleProof
+			if err := compactProof.FromProto(resp.Proof); err != nil {
+				t.Fatalf("returned error decoding compact proof: %v", err)
+			}
+			proof, _ = smt.DecompactProof(compactProof, sha256.New())
+		} else if err := proof.FromProto(resp.Proof); err != nil {
+			t.Fatalf("returned error decoding proof: %v", err)
+		}
+		if !smt.VerifyProof(proof, resp.Root, []byte("foo"), resp.Value, sha256.New()) || !bytes.Equal(resp.Value, []byte("bar")) {
+			t.Errorf("proof of foo did not verify, compact %v", compact)
+		}
+	}
+
+	stream, err := client.Diff(ctx, &DiffRequest{OldRoot: oldRoot.Root, NewRoot: root.Root})
+	if err != nil {
+		t.Fatalf("returned error when diffing: %v", err)
+	}
+	var changes []*KeyChange
+	for {
+		c, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatalf("returned error receiving changes: %v", err)
+		}
+		changes = append(changes, c)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("got %d changes, want 2", len(changes))
+	}
+	for _, c := range changes {
+		if c.Kind != KeyChange_INSERTED || (string(c.Key) != "foo" && string(c.Key) != "baz") {
+			t.Errorf("got change %v", c)
+		}
+	}
+
+	pruned, _ := client.Diff(ctx, &DiffRequest{OldRoot: bytes.Repeat([]byte{1}, 32), NewRoot: root.Root})
+	if _, err := pruned.Recv(); status.Code(err) != codes.NotFound {
+		t.Errorf("got %v diffing from a root that is not retained, want NotFound", err)
+	}
+}
+
+func TestServerReadOnly(t *testing.T) {
+	tree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), smt.NewSimpleMap(), sha256.New())
+	client := dial(t, tree)
+	if _, err := client.Update(context.Background(), &UpdateRequest{Key: []byte("foo"), Value: []byte("bar")}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("got %v updating a server without an authorizer, want PermissionDenied", err)
+	}
+
+	md, _ := TokenCredentials("secret").GetRequestMetadata(context.Background())
+	incoming := metadata.NewIncomingContext(context.Background(), metadata.New(md))
+	if err := TokenAuthorizer("secret")(incoming); err != nil {
+		t.Errorf("token of TokenCredentials was not authorized: %v", err)
+	}
+}
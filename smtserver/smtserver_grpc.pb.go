@@ -0,0 +1,293 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: proto/smtserver.proto
+
+package smtserver
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// TreeClient is the client API for Tree service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type TreeClient interface {
+	// Get returns the value of a key, or the default value if it is absent.
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
+	// Update sets the value of a key, deleting it for the default value. The
+	// server only accepts updates from the callers it authorizes.
+	Update(ctx context.Context, in *UpdateRequest, opts ...grpc.CallOption) (*UpdateResponse, error)
+	// Prove returns a proof of the value of a key, or of its absence, along
+	// with the root it verifies against.
+	Prove(ctx context.Context, in *ProveRequest, opts ...grpc.CallOption) (*ProveResponse, error)
+	// GetRoot returns the current root of the tree.
+	GetRoot(ctx context.Context, in *GetRootRequest, opts ...grpc.CallOption) (*GetRootResponse, error)
+	// Diff streams the changes of the keys between two roots, in ascending
+	// path order. Both roots must be retained by the tree.
+	Diff(ctx context.Context, in *DiffRequest, opts ...grpc.CallOption) (Tree_DiffClient, error)
+}
+
+type treeClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTreeClient(cc grpc.ClientConnInterface) TreeClient {
+	return &treeClient{cc}
+}
+
+func (c *treeClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error) {
+	out := new(GetResponse)
+	err := c.cc.Invoke(ctx, "/causevest.smt.v1.Tree/Get", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *treeClient) Update(ctx context.Context, in *UpdateRequest, opts ...grpc.CallOption) (*UpdateResponse, error) {
+	out := new(UpdateResponse)
+	err := c.cc.Invoke(ctx, "/causevest.smt.v1.Tree/Update", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *treeClient) Prove(ctx context.Context, in *ProveRequest, opts ...grpc.CallOption) (*ProveResponse, error) {
+	out := new(ProveResponse)
+	err := c.cc.Invoke(ctx, "/causevest.smt.v1.Tree/Prove", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *treeClient) GetRoot(ctx context.Context, in *GetRootRequest, opts ...grpc.CallOption) (*GetRootResponse, error) {
+	out := new(GetRootResponse)
+	err := c.cc.Invoke(ctx, "/causevest.smt.v1.Tree/GetRoot", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *treeClient) Diff(ctx context.Context, in *DiffRequest, opts ...grpc.CallOption) (Tree_DiffClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Tree_ServiceDesc.Streams[0], "/causevest.smt.v1.Tree/Diff", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &treeDiffClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Tree_DiffClient interface {
+	Recv() (*KeyChange, error)
+	grpc.ClientStream
+}
+
+type treeDiffClient struct {
+	grpc.ClientStream
+}
+
+func (x *treeDiffClient) Recv() (*KeyChange, error) {
+	m := new(KeyChange)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// TreeServer is the server API for Tree service.
+// All implementations must embed UnimplementedTreeServer
+// for forward compatibility
+type TreeServer interface {
+	// Get returns the value of a key, or the default value if it is absent.
+	Get(context.Context, *GetRequest) (*GetResponse, error)
+	// Update sets the value of a key, deleting it for the default value. The
+	// server only accepts updates from the callers it authorizes.
+	Update(context.Context, *UpdateRequest) (*UpdateResponse, error)
+	// Prove returns a proof of the value of a key, or of its absence, along
+	// with the root it verifies against.
+	Prove(context.Context, *ProveRequest) (*ProveResponse, error)
+	// GetRoot returns the current root of the tree.
+	GetRoot(context.Context, *GetRootRequest) (*GetRootResponse, error)
+	// Diff streams the changes of the keys between two roots, in ascending
+	// path order. Both roots must be retained by the tree.
+	Diff(*DiffRequest, Tree_DiffServer) error
+	mustEmbedUnimplementedTreeServer()
+}
+
+// UnimplementedTreeServer must be embedded to have forward compatible implementations.
+type UnimplementedTreeServer struct {
+}
+
+func (UnimplementedTreeServer) Get(context.Context, *GetRequest) (*GetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedTreeServer) Update(context.Context, *UpdateRequest) (*UpdateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Update not implemented")
+}
+func (UnimplementedTreeServer) Prove(context.Context, *ProveRequest) (*ProveResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Prove not implemented")
+}
+func (UnimplementedTreeServer) GetRoot(context.Context, *GetRootRequest) (*GetRootResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRoot not implemented")
+}
+func (UnimplementedTreeServer) Diff(*DiffRequest, Tree_DiffServer) error {
+	return status.Errorf(codes.Unimplemented, "method Diff not implemented")
+}
+func (UnimplementedTreeServer) mustEmbedUnimplementedTreeServer() {}
+
+// UnsafeTreeServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TreeServer will
+// result in compilation errors.
+type UnsafeTreeServer interface {
+	mustEmbedUnimplementedTreeServer()
+}
+
+func RegisterTreeServer(s grpc.ServiceRegistrar, srv TreeServer) {
+	s.RegisterService(&Tree_ServiceDesc, srv)
+}
+
+func _Tree_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TreeServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/causevest.smt.v1.Tree/Get",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TreeServer).Get(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Tree_Update_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TreeServer).Update(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/causevest.smt.v1.Tree/Update",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TreeServer).Update(ctx, req.(*UpdateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Tree_Prove_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TreeServer).Prove(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/causevest.smt.v1.Tree/Prove",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TreeServer).Prove(ctx, req.(*ProveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Tree_GetRoot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRootRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TreeServer).GetRoot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/causevest.smt.v1.Tree/GetRoot",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TreeServer).GetRoot(ctx, req.(*GetRootRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Tree_Diff_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DiffRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TreeServer).Diff(m, &treeDiffServer{stream})
+}
+
+type Tree_DiffServer interface {
+	Send(*KeyChange) error
+	grpc.ServerStream
+}
+
+type treeDiffServer struct {
+	grpc.ServerStream
+}
+
+func (x *treeDiffServer) Send(m *KeyChange) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Tree_ServiceDesc is the grpc.ServiceDesc for Tree service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Tree_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "causevest.smt.v1.Tree",
+	HandlerType: (*TreeServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Get",
+			Handler:    _Tree_Get_Handler,
+		},
+		{
+			MethodName: "Update",
+			Handler:    _Tree_Update_Handler,
+		},
+		{
+			MethodName: "Prove",
+			Handler:    _Tree_Prove_Handler,
+		},
+		{
+			MethodName: "GetRoot",
+			Handler:    _Tree_GetRoot_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Diff",
+			Handler:       _Tree_Diff_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/smtserver.proto",
+}
@@ -0,0 +1,147 @@
+// Package smttest provides MapStore test doubles for testing code built on
+// package smt.
+package smttest
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/causevest/smt"
+)
+
+// ErrInjected is returned by a FaultyStore for an injected failure that was
+// not given an error of its own.
+var ErrInjected = errors.New("smttest: injected failure")
+
+// Op is an operation of a FaultyStore.
+type Op int
+
+// Operations of a FaultyStore.
+const (
+	OpGet Op = iota
+	OpSet
+	OpDelete
+	OpExport
+	numOps
+)
+
+// fault is a pending injected failure of an operation.
+type fault struct {
+	remaining int // Calls left until the failing one.
+	err       error
+}
+
+// FaultyStore is a MapStore that wraps another, adding latency to its
+// operations and failing chosen calls. It is safe for concurrent use if the
+// wrapped store is.
+type FaultyStore struct {
+	store smt.MapStore
+
+	mu      sync.Mutex
+	latency [numOps]time.Duration
+	faults  [numOps][]*fault
+	calls   [numOps]int
+}
+
+// NewFaultyStore creates a FaultyStore wrapping store, with no latency or
+// failures.
+func NewFaultyStore(store smt.MapStore) *FaultyStore {
+	return &FaultyStore{store: store}
+}
+
+// SetLatency sets the delay added to every call of op.
+func (fs *FaultyStore) SetLatency(op Op, latency time.Duration) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.latency[op] = latency
+}
+
+// FailNth makes the nth call of op from now, counting from 1, fail with err,
+// or ErrInjected if err is nil. The failing call does not reach the wrapped
+// store.
+func (fs *FaultyStore) FailNth(op Op, n int, err error) {
+	if n < 1 {
+		panic("smttest: call number must be positive")
+	}
+	if err == nil {
+		err = ErrInjected
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.faults[op] = append(fs.faults[op], &fault{remaining: n, err: err})
+}
+
+// Reset removes all latency and pending failures.
+func (fs *FaultyStore) Reset() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.latency = [numOps]time.Duration{}
+	fs.faults = [numOps][]*fault{}
+}
+
+// Calls returns the number of calls of op so far, including failed ones.
+func (fs *FaultyStore) Calls(op Op) int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.calls[op]
+}
+
+// before counts a call of op, waits out its latency and returns its injected
+// error, if any.
+func (fs *FaultyStore) before(op Op) error {
+	fs.mu.Lock()
+	fs.calls[op]++
+	latency := fs.latency[op]
+	var err error
+	pending := fs.faults[op][:0]
+	for _, f := range fs.faults[op] {
+		f.remaining--
+		if f.remaining == 0 {
+			if err == nil {
+				err = f.err
+			}
+			continue
+		}
+		pending = append(pending, f)
+	}
+	fs.faults[op] = pending
+	fs.mu.Unlock()
+
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	return err
+}
+
+// Get gets the value for a key.
+func (fs *FaultyStore) Get(key []byte) ([]byte, error) {
+	if err := fs.before(OpGet); err != nil {
+		return nil, err
+	}
+	return fs.store.Get(key)
+}
+
+// Set updates the value for a key.
+func (fs *FaultyStore) Set(key []byte, value []byte) error {
+	if err := fs.before(OpSet); err != nil {
+		return err
+	}
+	return fs.store.Set(key, value)
+}
+
+// Delete deletes a key.
+func (fs *FaultyStore) Delete(key []byte) error {
+	if err := fs.before(OpDelete); err != nil {
+		return err
+	}
+	return fs.store.Delete(key)
+}
+
+// Export exports the wrapped store.
+func (fs *FaultyStore) Export() ([]byte, error) {
+	if err := fs.before(OpExport); err != nil {
+		return nil, err
+	}
+	return fs.store.Export()
+}
@@ -0,0 +1,67 @@
+package smttest
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/causevest/smt"
+)
+
+func TestFaultyStore(t *testing.T) {
+	fs := NewFaultyStore(smt.NewSimpleMap())
+	errCustom := errors.New("disk full")
+	fs.FailNth(OpSet, 2, errCustom)
+	fs.FailNth(OpGet, 1, nil)
+
+	if err := fs.Set([]byte("key1"), []byte("value1")); err != nil {
+		t.Errorf("returned error for first set: %v", err)
+	}
+	if err := fs.Set([]byte("key2"), []byte("value2")); !errors.Is(err, errCustom) {
+		t.Errorf("did not return injected error for second set, got %v", err)
+	}
+	if _, err := fs.Get([]byte("key2")); !errors.Is(err, ErrInjected) {
+		t.Errorf("did not return ErrInjected for first get, got %v", err)
+	}
+	if _, err := fs.Get([]byte("key2")); err == nil {
+		t.Error("failed set reached the wrapped store")
+	}
+	if value, err := fs.Get([]byte("key1")); err != nil || string(value) != "value1" {
+		t.Errorf("did not get value after injected failures, got %q, %v", value, err)
+	}
+	if fs.Calls(OpSet) != 2 || fs.Calls(OpGet) != 3 {
+		t.Errorf("wrong call counts: %d sets, %d gets", fs.Calls(OpSet), fs.Calls(OpGet))
+	}
+
+	fs.SetLatency(OpGet, 20*time.Millisecond)
+	start := time.Now()
+	fs.Get([]byte("key1"))
+	if time.Since(start) < 20*time.Millisecond {
+		t.Error("did not add latency to get")
+	}
+	fs.Reset()
+	fs.FailNth(OpDelete, 1, nil)
+	fs.Reset()
+	if err := fs.Delete([]byte("key1")); err != nil {
+		t.Errorf("returned error after reset: %v", err)
+	}
+}
+
+func TestFaultyStoreTree(t *testing.T) {
+	nodes := NewFaultyStore(smt.NewSimpleMap())
+	tree := smt.NewSparseMerkleTree(nodes, smt.NewSimpleMap(), sha256.New())
+	tree.Update([]byte("testKey1"), []byte("testValue1"))
+	root := tree.Root()
+
+	nodes.FailNth(OpGet, 1, nil)
+	if _, err := tree.Update([]byte("testKey2"), []byte("testValue2")); !errors.Is(err, ErrInjected) {
+		t.Errorf("did not return injected error from update, got %v", err)
+	}
+	if string(tree.Root()) != string(root) {
+		t.Error("failed update changed the root")
+	}
+	if _, err := tree.Update([]byte("testKey2"), []byte("testValue2")); err != nil {
+		t.Errorf("returned error when retrying update: %v", err)
+	}
+}
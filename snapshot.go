@@ -0,0 +1,174 @@
+package smt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+)
+
+// ErrSnapshotChecksum is returned when an exported snapshot does not match
+// its checksum.
+var ErrSnapshotChecksum = errors.New("snapshot checksum mismatch")
+
+// ErrSnapshotVersion is returned when an exported snapshot has an unsupported
+// format version.
+var ErrSnapshotVersion = errors.New("unsupported snapshot version")
+
+// snapshotMagic identifies a map snapshot with a header. Snapshots without
+// it are legacy headerless gob encodings.
+var snapshotMagic = []byte("SMTS")
+
+// snapshotVersion is the format version of new snapshots. Version 3 payloads
+// are canonical: the number of entries, then each entry's key and value, in
+// key order, every length a uvarint, so that the same map always exports to
+// the same bytes, whatever Go version encodes it, and snapshots can be
+// stored by content. Version 2 payloads, the gob encoding of the entries in
+// key order, and version 1 payloads, the gob encoding of the map itself,
+// which varies with the order maps are iterated in, are still decoded.
+const snapshotVersion = 3
+
+// Header layout: magic, one byte of format version, then the big-endian
+// CRC32 (IEEE) of the payload that follows.
+const snapshotHeaderSize = 4 + 1 + 4
+
+// snapshotEntry is an entry of a version 2 snapshot payload.
+type snapshotEntry struct {
+	Key, Value []byte
+}
+
+// encodeSnapshot serialises a map into a versioned, checksummed snapshot.
+func encodeSnapshot(m map[string][]byte) ([]byte, error) {
+	keys := make([]string, 0, len(m))
+	size := snapshotHeaderSize + binary.MaxVarintLen64
+	for key, value := range m {
+		keys = append(keys, key)
+		size += 2*binary.MaxVarintLen64 + len(key) + len(value)
+	}
+	sort.Strings(keys)
+
+	serial := make([]byte, snapshotHeaderSize, size)
+	copy(serial, snapshotMagic)
+	serial[len(snapshotMagic)] = snapshotVersion
+	serial = appendUvarint(serial, uint64(len(keys)))
+	for _, key := range keys {
+		serial = appendUvarint(serial, uint64(len(key)))
+		serial = append(serial, key...)
+		serial = appendUvarint(serial, uint64(len(m[key])))
+		serial = append(serial, m[key]...)
+	}
+	binary.BigEndian.PutUint32(serial[len(snapshotMagic)+1:], crc32.ChecksumIEEE(serial[snapshotHeaderSize:]))
+	return serial, nil
+}
+
+func appendUvarint(b []byte, x uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], x)]...)
+}
+
+// decodeSnapshot deserialises a snapshot produced by encodeSnapshot, verifying
+// its checksum first. Legacy headerless gob encodings are decoded as is. A
+// version 3 payload that is malformed returns ErrSnapshotCorrupt.
+func decodeSnapshot(serial []byte, m *map[string][]byte) error {
+	if !isHeaderedSnapshot(serial) {
+		return GobDecode(serial, m)
+	}
+	if len(serial) < snapshotHeaderSize {
+		return ErrSnapshotChecksum
+	}
+	version := serial[len(snapshotMagic)]
+	if version < 1 || version > snapshotVersion {
+		return fmt.Errorf("%w: %d", ErrSnapshotVersion, version)
+	}
+	payload := serial[snapshotHeaderSize:]
+	if binary.BigEndian.Uint32(serial[len(snapshotMagic)+1:]) != crc32.ChecksumIEEE(payload) {
+		return ErrSnapshotChecksum
+	}
+	switch version {
+	case 1:
+		return GobDecode(payload, m)
+	case 2:
+		var entries []snapshotEntry
+		if err := GobDecode(payload, &entries); err != nil {
+			return err
+		}
+		*m = make(map[string][]byte, len(entries))
+		for _, e := range entries {
+			value := e.Value
+			if value == nil {
+				// Gob decodes empty slices as nil; values are never nil.
+				value = []byte{}
+			}
+			(*m)[string(e.Key)] = value
+		}
+		return nil
+	}
+	return decodeCanonicalSnapshot(payload, m)
+}
+
+// decodeCanonicalSnapshot decodes a version 3 payload.
+func decodeCanonicalSnapshot(payload []byte, m *map[string][]byte) error {
+	next := func() ([]byte, bool) {
+		n, size := binary.Uvarint(payload)
+		if size <= 0 || n > uint64(len(payload)-size) {
+			return nil, false
+		}
+		field := payload[size : size+int(n)]
+		payload = payload[size+int(n):]
+		return field, true
+	}
+	count, size := binary.Uvarint(payload)
+	// Every entry takes at least two bytes, which bounds the count before it
+	// is trusted with an allocation.
+	if size <= 0 || count > uint64(len(payload)-size)/2 {
+		return ErrSnapshotCorrupt
+	}
+	payload = payload[size:]
+	entries := make(map[string][]byte, count)
+	var prev []byte
+	for i := uint64(0); i < count; i++ {
+		key, ok := next()
+		if !ok {
+			return ErrSnapshotCorrupt
+		}
+		value, ok := next()
+		if !ok {
+			return ErrSnapshotCorrupt
+		}
+		// Keys are strictly increasing, so that each map has a single
+		// encoding.
+		if i > 0 && bytes.Compare(prev, key) >= 0 {
+			return ErrSnapshotCorrupt
+		}
+		prev = key
+		entries[string(key)] = append([]byte{}, value...)
+	}
+	if len(payload) != 0 {
+		return ErrSnapshotCorrupt
+	}
+	*m = entries
+	return nil
+}
+
+// UpgradeSnapshot converts a map snapshot, such as one exported by
+// SimpleMap.Export, to the current format: a legacy headerless gob encoding,
+// or a snapshot of an older version, is decoded and encoded again with a
+// current version header and checksum, while a
+// snapshot already in the current format is checked and returned as is.
+// Upgraded snapshots import with ImportMerkleMap like any other.
+func UpgradeSnapshot(old []byte) ([]byte, error) {
+	var m map[string][]byte
+	if err := decodeSnapshot(old, &m); err != nil {
+		return nil, err
+	}
+	if isHeaderedSnapshot(old) && old[len(snapshotMagic)] == snapshotVersion {
+		return old, nil
+	}
+	return encodeSnapshot(m)
+}
+
+func isHeaderedSnapshot(serial []byte) bool {
+	return bytes.HasPrefix(serial, snapshotMagic)
+}
@@ -0,0 +1,276 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"testing"
+)
+
+func TestSnapshotChecksum(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	smt.Update([]byte("testKey1"), []byte("testValue1"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+
+	nodesBytes, err := smt.nodes.Export()
+	if err != nil {
+		t.Fatalf("returned error when exporting nodes: %v", err)
+	}
+	valuesBytes, err := smt.values.Export()
+	if err != nil {
+		t.Fatalf("returned error when exporting values: %v", err)
+	}
+	if !bytes.HasPrefix(nodesBytes, snapshotMagic) {
+		t.Error("exported snapshot has no header")
+	}
+
+	smn, smv, err := ImportMerkleMap(nodesBytes, valuesBytes)
+	if err != nil {
+		t.Fatalf("returned error when importing snapshot: %v", err)
+	}
+	imported := ImportSparseMerkleTree(smn, smv, sha256.New(), smt.Root())
+	value, err := imported.Get([]byte("testKey1"))
+	if err != nil {
+		t.Errorf("returned error when getting imported key: %v", err)
+	}
+	if !bytes.Equal([]byte("testValue1"), value) {
+		t.Error("did not get correct value from imported tree")
+	}
+
+	// Flip a bit in the payload.
+	corrupted := append([]byte(nil), nodesBytes...)
+	corrupted[len(corrupted)-1] ^= 1
+	_, _, err = ImportMerkleMap(corrupted, valuesBytes)
+	if !errors.Is(err, ErrSnapshotChecksum) {
+		t.Errorf("did not return ErrSnapshotChecksum for corrupted snapshot, got %v", err)
+	}
+
+	// Truncate the header.
+	_, _, err = ImportMerkleMap(nodesBytes[:6], valuesBytes)
+	if !errors.Is(err, ErrSnapshotChecksum) {
+		t.Errorf("did not return ErrSnapshotChecksum for truncated snapshot, got %v", err)
+	}
+
+	unsupported := append([]byte(nil), nodesBytes...)
+	unsupported[len(snapshotMagic)] = snapshotVersion + 1
+	_, _, err = ImportMerkleMap(unsupported, valuesBytes)
+	if !errors.Is(err, ErrSnapshotVersion) {
+		t.Errorf("did not return ErrSnapshotVersion for unsupported version, got %v", err)
+	}
+}
+
+func TestSnapshotLegacy(t *testing.T) {
+	sm := NewSimpleMap()
+	sm.Set([]byte("key"), []byte("value"))
+	legacy, err := GobEncode(sm.m)
+	if err != nil {
+		t.Fatalf("returned error when encoding legacy snapshot: %v", err)
+	}
+
+	smn, _, err := ImportMerkleMap(legacy, legacy)
+	if err != nil {
+		t.Fatalf("returned error when importing legacy snapshot: %v", err)
+	}
+	value, err := smn.Get([]byte("key"))
+	if err != nil {
+		t.Errorf("returned error when getting key from legacy snapshot: %v", err)
+	}
+	if !bytes.Equal([]byte("value"), value) {
+		t.Error("did not get correct value from legacy snapshot")
+	}
+}
+
+func TestUpgradeSnapshot(t *testing.T) {
+	sm := NewSimpleMap()
+	sm.Set([]byte("key"), []byte("value"))
+	legacy, err := GobEncode(sm.m)
+	if err != nil {
+		t.Fatalf("returned error when encoding legacy snapshot: %v", err)
+	}
+
+	upgraded, err := UpgradeSnapshot(legacy)
+	if err != nil {
+		t.Fatalf("returned error when upgrading legacy snapshot: %v", err)
+	}
+	if !bytes.HasPrefix(upgraded, snapshotMagic) {
+		t.Error("upgraded snapshot has no header")
+	}
+	smn, _, err := ImportMerkleMap(upgraded, upgraded)
+	if err != nil {
+		t.Fatalf("returned error when importing upgraded snapshot: %v", err)
+	}
+	value, err := smn.Get([]byte("key"))
+	if err != nil || !bytes.Equal([]byte("value"), value) {
+		t.Errorf("did not get correct value from upgraded snapshot, got %s, %v", value, err)
+	}
+
+	// A current snapshot is returned as is.
+	current, err := sm.Export()
+	if err != nil {
+		t.Fatalf("returned error when exporting snapshot: %v", err)
+	}
+	again, err := UpgradeSnapshot(current)
+	if err != nil {
+		t.Errorf("returned error when upgrading current snapshot: %v", err)
+	}
+	if !bytes.Equal(again, current) {
+		t.Error("current snapshot changed when upgraded")
+	}
+
+	corrupted := append([]byte(nil), current...)
+	corrupted[len(corrupted)-1] ^= 1
+	if _, err := UpgradeSnapshot(corrupted); !errors.Is(err, ErrSnapshotChecksum) {
+		t.Errorf("did not return ErrSnapshotChecksum for corrupted snapshot, got %v", err)
+	}
+}
+
+// Test that exports and iterations over the same contents come out in the
+// same order every time, whatever the order of map iteration.
+func TestDeterministicOrder(t *testing.T) {
+	build := func(reversed bool, options ...Option) *SparseMerkleTree {
+		smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), options...)
+		for i := 0; i < 50; i++ {
+			j := i
+			if reversed {
+				j = 49 - i
+			}
+			smt.Update([]byte(fmt.Sprintf("testKey%d", j)), []byte(fmt.Sprintf("testValue%d", j)))
+		}
+		return smt
+	}
+	a, b := build(false), build(true)
+	for i := 0; i < 2; i++ {
+		aNodes, _ := a.nodes.Export()
+		bNodes, _ := b.nodes.Export()
+		if !bytes.Equal(aNodes, bNodes) {
+			t.Fatal("exports of the same nodes differ")
+		}
+		var aDump, bDump bytes.Buffer
+		a.Dump(&aDump)
+		b.Dump(&bDump)
+		if !bytes.Equal(aDump.Bytes(), bDump.Bytes()) {
+			t.Fatal("dumps of the same tree differ")
+		}
+	}
+
+	a = build(false, WithRootRetention(10))
+	fromRoot := a.Root()
+	for i := 0; i < 10; i++ {
+		a.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte("newValue"))
+	}
+	first, _ := a.ExportDiff(fromRoot, a.Root())
+	second, _ := a.ExportDiff(fromRoot, a.Root())
+	if !bytes.Equal(first, second) {
+		t.Error("exports of the same diff differ")
+	}
+}
+
+func TestSnapshotVersion1(t *testing.T) {
+	// Version 1 payloads are the gob encoding of the map.
+	payload, _ := GobEncode(map[string][]byte{"key": []byte("value")})
+	serial := make([]byte, snapshotHeaderSize)
+	copy(serial, snapshotMagic)
+	serial[len(snapshotMagic)] = 1
+	binary.BigEndian.PutUint32(serial[len(snapshotMagic)+1:], crc32.ChecksumIEEE(payload))
+	serial = append(serial, payload...)
+
+	smn, _, err := ImportMerkleMap(serial, serial)
+	if err != nil {
+		t.Fatalf("returned error when importing version 1 snapshot: %v", err)
+	}
+	if value, _ := smn.Get([]byte("key")); !bytes.Equal(value, []byte("value")) {
+		t.Error("did not get value from version 1 snapshot")
+	}
+	upgraded, err := UpgradeSnapshot(serial)
+	if err != nil || upgraded[len(snapshotMagic)] != snapshotVersion {
+		t.Errorf("did not upgrade version 1 snapshot, %v", err)
+	}
+}
+
+func TestSnapshotVersion2(t *testing.T) {
+	// Version 2 payloads are the gob encoding of the entries in key order.
+	payload, _ := GobEncode([]snapshotEntry{{Key: []byte("empty"), Value: []byte{}}, {Key: []byte("key"), Value: []byte("value")}})
+	serial := make([]byte, snapshotHeaderSize)
+	copy(serial, snapshotMagic)
+	serial[len(snapshotMagic)] = 2
+	binary.BigEndian.PutUint32(serial[len(snapshotMagic)+1:], crc32.ChecksumIEEE(payload))
+	serial = append(serial, payload...)
+
+	smn, _, err := ImportMerkleMap(serial, serial)
+	if err != nil {
+		t.Fatalf("returned error when importing version 2 snapshot: %v", err)
+	}
+	if value, _ := smn.Get([]byte("key")); !bytes.Equal(value, []byte("value")) {
+		t.Error("did not get value from version 2 snapshot")
+	}
+	if value, err := smn.Get([]byte("empty")); err != nil || value == nil {
+		t.Errorf("did not get empty value from version 2 snapshot, got %v, %v", value, err)
+	}
+
+	upgraded, err := UpgradeSnapshot(serial)
+	if err != nil {
+		t.Fatalf("returned error when upgrading version 2 snapshot: %v", err)
+	}
+	current, _ := smn.Export()
+	if !bytes.Equal(upgraded, current) {
+		t.Error("upgraded version 2 snapshot differs from a current export")
+	}
+}
+
+func TestSnapshotCanonical(t *testing.T) {
+	sm := NewSimpleMap()
+	for i := 0; i < 20; i++ {
+		sm.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)))
+	}
+	sm.Set([]byte("empty"), []byte{})
+	serial, _ := sm.Export()
+	// Exports of the same entries are the same bytes however they are set.
+	other := NewSimpleMap()
+	other.Set([]byte("empty"), []byte{})
+	for i := 19; i >= 0; i-- {
+		other.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)))
+	}
+	if otherSerial, _ := other.Export(); !bytes.Equal(serial, otherSerial) {
+		t.Error("exports of the same entries differ")
+	}
+	smn, _, err := ImportMerkleMap(serial, serial)
+	if err != nil {
+		t.Fatalf("returned error when importing snapshot: %v", err)
+	}
+	if len(smn.m) != 21 {
+		t.Errorf("imported %d entries, want 21", len(smn.m))
+	}
+	if value, err := smn.Get([]byte("empty")); err != nil || value == nil {
+		t.Errorf("did not get empty value, got %v, %v", value, err)
+	}
+
+	withPayload := func(payload []byte) []byte {
+		serial := make([]byte, snapshotHeaderSize)
+		copy(serial, snapshotMagic)
+		serial[len(snapshotMagic)] = snapshotVersion
+		binary.BigEndian.PutUint32(serial[len(snapshotMagic)+1:], crc32.ChecksumIEEE(payload))
+		return append(serial, payload...)
+	}
+	for name, payload := range map[string][]byte{
+		"empty payload":      {},
+		"count too large":    {0xff, 0xff, 0xff, 0xff, 0x0f},
+		"truncated entry":    {1, 3, 'k'},
+		"missing value":      {1, 1, 'k'},
+		"unsorted keys":      {2, 1, 'b', 0, 1, 'a', 0},
+		"duplicate keys":     {2, 1, 'a', 0, 1, 'a', 0},
+		"trailing bytes":     {1, 1, 'a', 0, 0},
+		"length beyond data": {1, 1, 'a', 9, 'v'},
+	} {
+		var m map[string][]byte
+		if err := decodeSnapshot(withPayload(payload), &m); !errors.Is(err, ErrSnapshotCorrupt) {
+			t.Errorf("%s: did not return ErrSnapshotCorrupt, got %v", name, err)
+		}
+	}
+	var m map[string][]byte
+	if err := decodeSnapshot(withPayload([]byte{0}), &m); err != nil || m == nil || len(m) != 0 {
+		t.Errorf("did not decode empty snapshot, got %v, %v", m, err)
+	}
+}
@@ -0,0 +1,81 @@
+package smt
+
+import (
+	"errors"
+)
+
+// Split partitions the leaves of the tree into two new trees on in-memory
+// stores with their own key stores: the first holds the keys for which
+// predicate returns true, and the second the others. The original tree is
+// left untouched. The tree must have been created with WithKeyStore, so that
+// predicate can be given the raw keys. The new trees keep the hasher, domain
+// prefixes, default value and value codec of the original, and leaves set
+// with UpdateLeafHash or UpdatePresence are carried over as such. See
+// SetOperationLimit.
+func (smt *SparseMerkleTree) Split(predicate func(key []byte) bool, options ...TraversalOption) (*SparseMerkleTree, *SparseMerkleTree, error) {
+	if smt.keys == nil {
+		return nil, nil, ErrKeysNotRetained
+	}
+	root := smt.Root()
+	if err := smt.checkOperationLimit(root, options); err != nil {
+		return nil, nil, err
+	}
+
+	matched, unmatched := smt.emptyCopy(), smt.emptyCopy()
+	err := smt.walk(root, func(_ []bool, _ []byte, data []byte) error {
+		if !smt.th.isLeaf(data) {
+			return nil
+		}
+		path, valueHash := smt.th.parseLeaf(data)
+		key, err := smt.keys.Get(path)
+		if err != nil {
+			return err
+		}
+		if predicate(key) {
+			return smt.copyLeaf(matched, path, key, valueHash)
+		}
+		return smt.copyLeaf(unmatched, path, key, valueHash)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	smt.debugf("split %x into %x and %x", root, matched.root, unmatched.root)
+	return matched, unmatched, nil
+}
+
+// emptyCopy returns an empty tree on in-memory stores, configured like the
+// tree.
+func (smt *SparseMerkleTree) emptyCopy() *SparseMerkleTree {
+	return &SparseMerkleTree{
+		th:                smt.th,
+		nodes:             NewSimpleMap(),
+		values:            NewSimpleMap(),
+		keys:              NewSimpleMap(),
+		root:              smt.th.placeholder(),
+		compactNodes:      smt.compactNodes,
+		encodeValue:       smt.encodeValue,
+		decodeValue:       smt.decodeValue,
+		logger:            smt.logger,
+		defaultLeafValue:  smt.defaultLeafValue,
+		storeDefaultValue: smt.storeDefaultValue,
+	}
+}
+
+// copyLeaf inserts the leaf of key at path, committing to valueHash, into
+// dst, with its value if the tree stores it.
+func (smt *SparseMerkleTree) copyLeaf(dst *SparseMerkleTree, path []byte, key []byte, valueHash []byte) error {
+	if len(valueHash) == 0 {
+		_, err := dst.UpdatePresence(key)
+		return err
+	}
+	value, err := smt.getValue(path)
+	if errors.Is(err, ErrKeyNotFound) {
+		// Set with UpdateLeafHash.
+		_, err = dst.UpdateLeafHash(key, valueHash)
+		return err
+	} else if err != nil {
+		return err
+	}
+	_, err = dst.Update(key, value)
+	return err
+}
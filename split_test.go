@@ -0,0 +1,62 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithKeyStore(NewSimpleMap()))
+	even := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	odd := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	isEven := func(key []byte) bool { return key[len(key)-1]%2 == 0 }
+	for i := 0; i < 100; i++ {
+		key, value := []byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i))
+		smt.Update(key, value)
+		if isEven(key) {
+			even.Update(key, value)
+		} else {
+			odd.Update(key, value)
+		}
+	}
+	// Leaves without values are carried over as such.
+	valueHash := sha256.Sum256([]byte("hashedValue"))
+	smt.UpdateLeafHash([]byte("hashedKey0"), valueHash[:])
+	even.UpdateLeafHash([]byte("hashedKey0"), valueHash[:])
+	smt.UpdatePresence([]byte("presentKey1"))
+	odd.UpdatePresence([]byte("presentKey1"))
+	root := smt.Root()
+
+	matched, unmatched, err := smt.Split(isEven)
+	if err != nil {
+		t.Fatalf("returned error when splitting: %v", err)
+	}
+	if !bytes.Equal(matched.Root(), even.Root()) {
+		t.Error("tree of matched keys has wrong root")
+	}
+	if !bytes.Equal(unmatched.Root(), odd.Root()) {
+		t.Error("tree of unmatched keys has wrong root")
+	}
+	if !bytes.Equal(smt.Root(), root) {
+		t.Error("split changed the original tree")
+	}
+	value, err := matched.Get([]byte("testKey42"))
+	if err != nil || !bytes.Equal(value, []byte("testValue42")) {
+		t.Errorf("did not get value from tree of matched keys, got %s, %v", value, err)
+	}
+	if has, _ := unmatched.Has([]byte("testKey42")); has {
+		t.Error("tree of unmatched keys has a matched key")
+	}
+	// The new trees retain their keys, so they can be split again.
+	if _, _, err := unmatched.Split(isEven); err != nil {
+		t.Errorf("returned error when splitting a split tree: %v", err)
+	}
+
+	plain := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	if _, _, err := plain.Split(isEven); !errors.Is(err, ErrKeysNotRetained) {
+		t.Errorf("did not return ErrKeysNotRetained, got %v", err)
+	}
+}
@@ -0,0 +1,260 @@
+// Package sqlstore provides a MapStore backed by a SQL database through
+// database/sql, such as SQLite or PostgreSQL, so that a tree can be kept next
+// to the other state of an application.
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/causevest/smt"
+)
+
+// ErrTableName is returned by NewSQLStore for a table name that is not a plain
+// SQL identifier.
+var ErrTableName = errors.New("sqlstore: table name is not a plain identifier")
+
+// Dialect is the SQL dialect of a database, which sets the syntax of the
+// statements of a SQLStore.
+type Dialect int
+
+// Supported dialects. Both upsert with INSERT ... ON CONFLICT, which needs
+// SQLite 3.24 or PostgreSQL 9.5.
+const (
+	SQLite Dialect = iota
+	Postgres
+)
+
+// placeholder returns the placeholder of the nth parameter of a statement,
+// counting from 1.
+func (d Dialect) placeholder(n int) string {
+	if d == Postgres {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
+// DefaultBatchSize is the number of rows a SQLStore upserts per statement in
+// BatchSet, by default.
+const DefaultBatchSize = 100
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SQLStore is a MapStore that keeps its entries in a table of a SQL database,
+// with the schema
+//
+//	(key BYTEA PRIMARY KEY, value BYTEA NOT NULL)
+//
+// so that the nodes and values of a tree can be kept in two tables of one
+// database. It is safe for concurrent use.
+type SQLStore struct {
+	db        *sql.DB
+	table     string
+	dialect   Dialect
+	batchSize int
+
+	get, upsert, del *sql.Stmt
+}
+
+// Option is an option of a SQLStore.
+type Option func(*SQLStore)
+
+// WithBatchSize sets the number of rows BatchSet upserts per statement, which
+// is DefaultBatchSize otherwise. A size of 1 upserts each row with the
+// prepared statement of Set.
+func WithBatchSize(n int) Option {
+	if n <= 0 {
+		panic("sqlstore: non-positive batch size")
+	}
+	return func(s *SQLStore) {
+		s.batchSize = n
+	}
+}
+
+// NewSQLStore creates a SQLStore keeping its entries in table of db, creating
+// the table if it does not exist, and prepares its statements. Entries
+// already in the table are kept, so a tree can be imported from the store at
+// its last root. Close the store to release the statements; the store does
+// not own db.
+func NewSQLStore(db *sql.DB, table string, dialect Dialect, options ...Option) (*SQLStore, error) {
+	if db == nil {
+		panic("sqlstore: nil database")
+	}
+	if !identifier.MatchString(table) {
+		return nil, fmt.Errorf("%w: %q", ErrTableName, table)
+	}
+	s := &SQLStore{db: db, table: table, dialect: dialect, batchSize: DefaultBatchSize}
+	for _, option := range options {
+		option(s)
+	}
+
+	if _, err := db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (key BYTEA PRIMARY KEY, value BYTEA NOT NULL)", table)); err != nil {
+		return nil, err
+	}
+	var err error
+	if s.get, err = db.Prepare(fmt.Sprintf("SELECT value FROM %s WHERE key = %s", table, dialect.placeholder(1))); err != nil {
+		return nil, err
+	}
+	if s.upsert, err = db.Prepare(s.upsertQuery(1)); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if s.del, err = db.Prepare(fmt.Sprintf("DELETE FROM %s WHERE key = %s", table, dialect.placeholder(1))); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// upsertQuery returns the statement upserting rows rows.
+func (s *SQLStore) upsertQuery(rows int) string {
+	var query strings.Builder
+	fmt.Fprintf(&query, "INSERT INTO %s (key, value) VALUES ", s.table)
+	for i := 0; i < rows; i++ {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		fmt.Fprintf(&query, "(%s, %s)", s.dialect.placeholder(2*i+1), s.dialect.placeholder(2*i+2))
+	}
+	query.WriteString(" ON CONFLICT (key) DO UPDATE SET value = excluded.value")
+	return query.String()
+}
+
+// Get gets the value for a key.
+func (s *SQLStore) Get(key []byte) ([]byte, error) {
+	var value []byte
+	err := s.get.QueryRow(key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &smt.InvalidKeyError{Key: key}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		// Drivers may scan empty values as nil.
+		value = []byte{}
+	}
+	return value, nil
+}
+
+// BatchGet gets the values for keys, in order.
+func (s *SQLStore) BatchGet(keys [][]byte) ([][]byte, error) {
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		value, err := s.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// Set updates the value for a key.
+func (s *SQLStore) Set(key []byte, value []byte) error {
+	_, err := s.upsert.Exec(key, nonNil(value))
+	return err
+}
+
+// BatchSet updates the values for keys in a single transaction, so that the
+// nodes of an update are written in full or not at all, upserting them in
+// statements of up to the batch size rows each.
+func (s *SQLStore) BatchSet(keys [][]byte, values [][]byte) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(keys); start += s.batchSize {
+		end := start + s.batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		var args []interface{}
+		for i := start; i < end; i++ {
+			args = append(args, keys[i], nonNil(values[i]))
+		}
+		if end-start == 1 {
+			_, err = tx.Stmt(s.upsert).Exec(args...)
+		} else {
+			_, err = tx.Exec(s.upsertQuery(end-start), args...)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Delete deletes a key, returning an InvalidKeyError if it is missing, as
+// SimpleMap does.
+func (s *SQLStore) Delete(key []byte) error {
+	result, err := s.del.Exec(key)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return &smt.InvalidKeyError{Key: key}
+	}
+	return nil
+}
+
+// Export exports the entries of the store as a map snapshot, like
+// SimpleMap.Export.
+func (s *SQLStore) Export() ([]byte, error) {
+	rows, err := s.db.Query(fmt.Sprintf("SELECT key, value FROM %s", s.table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sm := smt.NewSimpleMap()
+	for rows.Next() {
+		var key, value []byte
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		if err := sm.Set(key, nonNil(value)); err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return sm.Export()
+}
+
+// Flush does nothing, as every write is committed when it is made.
+func (s *SQLStore) Flush() error {
+	return nil
+}
+
+// Close releases the prepared statements of the store. The database is left
+// open for its owner to close.
+func (s *SQLStore) Close() error {
+	var first error
+	for _, stmt := range []*sql.Stmt{s.get, s.upsert, s.del} {
+		if stmt == nil {
+			continue
+		}
+		if err := stmt.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// nonNil returns value, or an empty value for nil, as the value column is not
+// nullable.
+func nonNil(value []byte) []byte {
+	if value == nil {
+		return []byte{}
+	}
+	return value
+}
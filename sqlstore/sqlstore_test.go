@@ -0,0 +1,115 @@
+package sqlstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/causevest/smt"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("returned error when opening database: %v", err)
+	}
+	// Each connection to :memory: has a database of its own.
+	db.SetMaxOpenConns(1)
+	return db
+}
+
+func TestSQLStore(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+	s, err := NewSQLStore(db, "nodes", SQLite)
+	if err != nil {
+		t.Fatalf("returned error when creating store: %v", err)
+	}
+	defer s.Close()
+	other, err := NewSQLStore(db, "store_values", SQLite)
+	if err != nil {
+		t.Fatalf("returned error when creating store: %v", err)
+	}
+	defer other.Close()
+
+	if err := s.Set([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("returned error when setting: %v", err)
+	}
+	if err := s.Set([]byte("key"), []byte("newValue")); err != nil {
+		t.Fatalf("returned error when replacing: %v", err)
+	}
+	if value, err := s.Get([]byte("key")); err != nil || !bytes.Equal(value, []byte("newValue")) {
+		t.Errorf("did not get value, got %s, %v", value, err)
+	}
+	var invalidKeyError *smt.InvalidKeyError
+	if _, err := other.Get([]byte("key")); !errors.As(err, &invalidKeyError) {
+		t.Errorf("read key of another table, got %v", err)
+	}
+	if err := s.Delete([]byte("key")); err != nil {
+		t.Errorf("returned error when deleting: %v", err)
+	}
+	if err := s.Delete([]byte("key")); !errors.As(err, &invalidKeyError) {
+		t.Errorf("did not return InvalidKeyError when deleting missing key, got %v", err)
+	}
+	if err := s.Set([]byte("empty"), nil); err != nil {
+		t.Errorf("returned error when setting empty value: %v", err)
+	}
+	if value, err := s.Get([]byte("empty")); err != nil || value == nil || len(value) != 0 {
+		t.Errorf("did not get empty value, got %v, %v", value, err)
+	}
+
+	if _, err := NewSQLStore(db, "nodes; DROP TABLE nodes", SQLite); !errors.Is(err, ErrTableName) {
+		t.Errorf("did not return ErrTableName, got %v", err)
+	}
+}
+
+func TestSQLStoreTree(t *testing.T) {
+	for _, batchSize := range []int{1, 7, DefaultBatchSize} {
+		db := openDB(t)
+		nodes, err := NewSQLStore(db, "nodes", SQLite, WithBatchSize(batchSize))
+		if err != nil {
+			t.Fatalf("returned error when creating store: %v", err)
+		}
+		values, err := NewSQLStore(db, "tree_values", SQLite, WithBatchSize(batchSize))
+		if err != nil {
+			t.Fatalf("returned error when creating store: %v", err)
+		}
+		tree := smt.NewSparseMerkleTree(nodes, values, sha256.New())
+		reference := smt.NewSparseMerkleTree(smt.NewSimpleMap(), smt.NewSimpleMap(), sha256.New())
+		for i := 0; i < 50; i++ {
+			key, value := []byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i))
+			tree.Update(key, value)
+			reference.Update(key, value)
+		}
+		tree.Delete([]byte("testKey3"))
+		reference.Delete([]byte("testKey3"))
+		if !bytes.Equal(tree.Root(), reference.Root()) {
+			t.Fatalf("tree on SQL with batch size %d has a different root", batchSize)
+		}
+
+		nodesBytes, err := nodes.Export()
+		if err != nil {
+			t.Fatalf("returned error when exporting nodes: %v", err)
+		}
+		valuesBytes, err := values.Export()
+		if err != nil {
+			t.Fatalf("returned error when exporting values: %v", err)
+		}
+		smn, smv, err := smt.ImportMerkleMap(nodesBytes, valuesBytes)
+		if err != nil {
+			t.Fatalf("returned error when importing export: %v", err)
+		}
+		imported := smt.ImportSparseMerkleTree(smn, smv, sha256.New(), tree.Root())
+		if value, err := imported.Get([]byte("testKey42")); err != nil || !bytes.Equal(value, []byte("testValue42")) {
+			t.Errorf("did not get value from export, got %s, %v", value, err)
+		}
+		if err := tree.Close(); err != nil {
+			t.Errorf("returned error when closing tree: %v", err)
+		}
+		db.Close()
+	}
+}
@@ -0,0 +1,81 @@
+package smt
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+)
+
+// ErrIncompleteWitness is returned by StatelessExecutor when an update needs a
+// node of the tree that none of the proofs or nodes given to it cover.
+var ErrIncompleteWitness = errors.New("update needs a node not covered by the proofs")
+
+// StatelessExecutor applies updates to a tree known only by its root, from
+// proofs of the keys involved, to compute the new root without access to the
+// tree's stores. Proofs are checked against the old root as they are added;
+// updates are then applied from the proven nodes alone.
+type StatelessExecutor struct {
+	dsmst *DeepSparseMerkleSubTree
+}
+
+// NewStatelessExecutor creates a StatelessExecutor for the tree with root
+// oldRoot. Options are those of the tree's verifiers, e.g.
+// WithVerifyDefaultValue for a tree with a default value.
+func NewStatelessExecutor(oldRoot []byte, hasher hash.Hash, options ...VerifyOption) *StatelessExecutor {
+	config := newVerifyConfig(options)
+	dsmst := NewDeepSparseMerkleSubTree(NewSimpleMap(), NewSimpleMap(), hasher, oldRoot, config.treeOptions()...)
+	dsmst.th.setDepth(config.depth)
+	return &StatelessExecutor{dsmst: dsmst}
+}
+
+// AddProof adds the proof of the value of key under the old root: a
+// membership proof for a key in the tree, or a non-membership proof with the
+// default value for a new key. Keys that are deleted, or whose leaf becomes
+// the sibling of a deleted one, need updatable proofs; see ProveUpdatable.
+// ErrBadProof is returned if the proof does not verify against the old root.
+func (e *StatelessExecutor) AddProof(proof SparseMerkleProof, key []byte, value []byte) error {
+	return e.dsmst.AddBranch(proof, key, value)
+}
+
+// AddMultiProof adds a multiproof of the values of keys under the old root,
+// generated by ProveMulti, as AddProof adds individual proofs. Deleted keys
+// need the leaves of their siblings, which a multiproof gives only for proven
+// keys; see DeepSparseMerkleSubTree.AddMultiBranch.
+func (e *StatelessExecutor) AddMultiProof(proof SparseMultiProof, keys [][]byte, values [][]byte) error {
+	return e.dsmst.AddMultiBranch(proof, keys, values)
+}
+
+// AddNode adds the data of a node of the old tree that is not part of any
+// proof but is reached by the updates, such as the nodes of a
+// BatchUpdateProof. Nodes are stored by hash, so any data given is authentic.
+func (e *StatelessExecutor) AddNode(data []byte) error {
+	return e.dsmst.setNode(e.dsmst.th.digest(data), data)
+}
+
+// Update sets the value of key, returning the new root. ErrIncompleteWitness
+// is returned, leaving the root unchanged, if the update needs a node that was
+// not added.
+func (e *StatelessExecutor) Update(key []byte, value []byte) ([]byte, error) {
+	root, err := e.dsmst.Update(key, value)
+	return root, incompleteWitness(key, err)
+}
+
+// Delete deletes the value of key, returning the new root, like Update.
+func (e *StatelessExecutor) Delete(key []byte) ([]byte, error) {
+	root, err := e.dsmst.Delete(key)
+	return root, incompleteWitness(key, err)
+}
+
+// incompleteWitness returns ErrIncompleteWitness for the error of an update of
+// key that did not find a node, or err otherwise.
+func incompleteWitness(key []byte, err error) error {
+	if errors.Is(err, ErrKeyNotFound) {
+		return fmt.Errorf("%w: updating key %s", ErrIncompleteWitness, formatKey(key))
+	}
+	return err
+}
+
+// Root gets the root after the updates applied so far.
+func (e *StatelessExecutor) Root() []byte {
+	return e.dsmst.Root()
+}
@@ -0,0 +1,120 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestStatelessExecutor(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 50; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	oldRoot := smt.Root()
+	updates := []BatchUpdate{
+		{Key: []byte("testKey1"), Value: []byte("newValue1")},
+		{Key: []byte("newKey"), Value: []byte("newValue")},
+		{Key: []byte("testKey2"), Value: defaultValue},
+		{Key: []byte("testKey1"), Value: []byte("otherValue1")},
+	}
+	proof, err := smt.ProveBatchUpdate(updates)
+	if err != nil {
+		t.Fatalf("returned error when proving updates: %v", err)
+	}
+
+	e := NewStatelessExecutor(oldRoot, sha256.New())
+	for i, update := range updates {
+		if err := e.AddProof(proof.Proofs[i], update.Key, proof.OldValues[i]); err != nil {
+			t.Fatalf("returned error when adding proof: %v", err)
+		}
+	}
+	for _, data := range proof.Nodes {
+		if err := e.AddNode(data); err != nil {
+			t.Fatalf("returned error when adding node: %v", err)
+		}
+	}
+	for _, update := range updates {
+		if _, err := e.Update(update.Key, update.Value); err != nil {
+			t.Fatalf("returned error when applying update: %v", err)
+		}
+		smt.Update(update.Key, update.Value)
+	}
+	if !bytes.Equal(e.Root(), smt.Root()) {
+		t.Error("stateless executor computed a different root")
+	}
+
+	// Keys not covered by the proofs cannot be updated.
+	e = NewStatelessExecutor(smt.Root(), sha256.New())
+	keyProof, _ := smt.ProveUpdatable([]byte("testKey3"))
+	if err := e.AddProof(keyProof, []byte("testKey3"), []byte("testValue3")); err != nil {
+		t.Fatalf("returned error when adding proof: %v", err)
+	}
+	root := e.Root()
+	if _, err := e.Update([]byte("testKey4"), []byte("newValue4")); !errors.Is(err, ErrIncompleteWitness) {
+		t.Errorf("did not return ErrIncompleteWitness for key without proof, got %v", err)
+	}
+	if !bytes.Equal(e.Root(), root) {
+		t.Error("root changed after failed update")
+	}
+	newRoot, err := e.Delete([]byte("testKey3"))
+	if err != nil {
+		t.Errorf("returned error when deleting proven key: %v", err)
+	}
+	expected, _ := smt.Delete([]byte("testKey3"))
+	if !bytes.Equal(newRoot, expected) {
+		t.Error("stateless executor computed a different root after delete")
+	}
+
+	// Proofs are checked against the old root.
+	e = NewStatelessExecutor(oldRoot, sha256.New())
+	if err := e.AddProof(keyProof, []byte("testKey3"), []byte("testValue3")); !errors.Is(err, ErrBadProof) {
+		t.Errorf("did not return ErrBadProof for proof against another root, got %v", err)
+	}
+}
+
+func TestStatelessExecutorMultiProof(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 50; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	oldRoot := smt.Root()
+	var keys, values [][]byte
+	for i := 0; i < 10; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i*3))
+		value, _ := smt.Get(key)
+		keys, values = append(keys, key), append(values, value)
+	}
+	keys, values = append(keys, []byte("newKey")), append(values, defaultValue)
+	proof, err := smt.ProveMulti(keys)
+	if err != nil {
+		t.Fatalf("returned error when proving keys: %v", err)
+	}
+
+	e := NewStatelessExecutor(oldRoot, sha256.New())
+	if err := e.AddMultiProof(proof, keys, values); err != nil {
+		t.Fatalf("returned error when adding multiproof: %v", err)
+	}
+	for i, key := range keys {
+		value := []byte(fmt.Sprintf("newValue%d", i))
+		if _, err := e.Update(key, value); err != nil {
+			t.Fatalf("returned error when applying update: %v", err)
+		}
+		smt.Update(key, value)
+	}
+	if !bytes.Equal(e.Root(), smt.Root()) {
+		t.Error("stateless executor computed a different root from multiproof")
+	}
+	if _, err := e.Update([]byte("testKey1"), []byte("newValue")); !errors.Is(err, ErrIncompleteWitness) {
+		t.Errorf("did not return ErrIncompleteWitness for key not in multiproof, got %v", err)
+	}
+
+	// Multiproofs are checked against the old root and values.
+	e = NewStatelessExecutor(oldRoot, sha256.New())
+	values[0] = []byte("otherValue")
+	if err := e.AddMultiProof(proof, keys, values); !errors.Is(err, ErrBadProof) {
+		t.Errorf("did not return ErrBadProof for multiproof of wrong value, got %v", err)
+	}
+}
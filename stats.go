@@ -0,0 +1,98 @@
+package smt
+
+// TreeStats are statistics of a tree, as returned by Stats.
+type TreeStats struct {
+	// Leaves and Branches are the numbers of leaf and branch nodes under the
+	// root.
+	Leaves, Branches int
+
+	// MaxDepth and MeanDepth are the greatest and mean depths of the leaves,
+	// the root being at depth 0, and Depths the number of leaves at each
+	// depth.
+	MaxDepth  int
+	MeanDepth float64
+	Depths    map[int]int
+
+	// StoredNodes and StoredValues are the numbers of entries in the node
+	// and value stores, and NodeBytes and ValueBytes their sizes, keys and
+	// values included. The node store also holds the nodes of retained
+	// roots, and any that were not pruned, so it can hold more than the
+	// nodes under the root.
+	StoredNodes, StoredValues int
+	NodeBytes, ValueBytes     int64
+}
+
+// SizedStore is implemented by stores that can tell their number of entries
+// and their size, keys and values included, without reading them all.
+type SizedStore interface {
+	Size() (entries int, bytes int64, err error)
+}
+
+// Stats returns statistics of the tree at its current root, walking the
+// whole tree; see SetOperationLimit. The sizes of stores that are not
+// SizedStores are found by exporting them, which holds them in memory.
+func (smt *SparseMerkleTree) Stats(options ...TraversalOption) (TreeStats, error) {
+	defer smt.readLock()()
+	root := smt.Root()
+	if err := smt.checkOperationLimit(root, options); err != nil {
+		return TreeStats{}, err
+	}
+	stats := TreeStats{Depths: make(map[int]int)}
+	totalDepth := 0
+	err := smt.walk(root, func(prefix []bool, _ []byte, data []byte) error {
+		if !smt.th.isLeaf(data) {
+			stats.Branches++
+			return nil
+		}
+		stats.Leaves++
+		stats.Depths[len(prefix)]++
+		totalDepth += len(prefix)
+		if len(prefix) > stats.MaxDepth {
+			stats.MaxDepth = len(prefix)
+		}
+		return nil
+	})
+	if err != nil {
+		return TreeStats{}, err
+	}
+	if stats.Leaves > 0 {
+		stats.MeanDepth = float64(totalDepth) / float64(stats.Leaves)
+	}
+	if stats.StoredNodes, stats.NodeBytes, err = measureStore(smt.nodes); err != nil {
+		return TreeStats{}, err
+	}
+	if stats.StoredValues, stats.ValueBytes, err = measureStore(smt.values); err != nil {
+		return TreeStats{}, err
+	}
+	return stats, nil
+}
+
+// measureStore returns the number of entries and size of store.
+func measureStore(store MapStore) (int, int64, error) {
+	if sized, ok := store.(SizedStore); ok {
+		return sized.Size()
+	}
+	serial, err := store.Export()
+	if err != nil {
+		return 0, 0, err
+	}
+	var m map[string][]byte
+	if err := decodeSnapshot(serial, &m); err != nil {
+		return 0, 0, err
+	}
+	return mapSize(m)
+}
+
+func mapSize(m map[string][]byte) (int, int64, error) {
+	var size int64
+	for key, value := range m {
+		size += int64(len(key) + len(value))
+	}
+	return len(m), size, nil
+}
+
+// Size returns the number of entries of the map and their size.
+func (sm *SimpleMap) Size() (int, int64, error) {
+	defer sm.rlock()()
+	return mapSize(sm.m)
+}
@@ -0,0 +1,68 @@
+package smt
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// unsizedStore hides the Size method of a SimpleMap.
+type unsizedStore struct {
+	MapStore
+}
+
+func TestStats(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	stats, err := smt.Stats()
+	if err != nil {
+		t.Fatalf("returned error for an empty tree: %v", err)
+	}
+	if stats.Leaves != 0 || stats.Branches != 0 || stats.MeanDepth != 0 || stats.StoredNodes != 0 {
+		t.Errorf("got stats %+v for an empty tree", stats)
+	}
+
+	for i := 0; i < 50; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	stats, err = smt.Stats()
+	if err != nil {
+		t.Fatalf("returned error: %v", err)
+	}
+	branches, leaves, _ := smt.NodeCount()
+	if stats.Leaves != leaves || stats.Branches != branches || leaves != 50 {
+		t.Errorf("got %d leaves and %d branches, want %d and %d", stats.Leaves, stats.Branches, leaves, branches)
+	}
+	sum, total := 0, 0
+	for depth, n := range stats.Depths {
+		if depth > stats.MaxDepth {
+			t.Errorf("%d leaves at depth %d, beyond the max depth %d", n, depth, stats.MaxDepth)
+		}
+		sum += depth * n
+		total += n
+	}
+	if total != 50 || stats.MeanDepth != float64(sum)/50 {
+		t.Errorf("depths %v do not add up to mean depth %v", stats.Depths, stats.MeanDepth)
+	}
+	if stats.StoredNodes != branches+leaves || stats.StoredValues != 50 {
+		t.Errorf("got %d stored nodes and %d values", stats.StoredNodes, stats.StoredValues)
+	}
+	nodes, nodeBytes, _ := smt.nodes.(*SimpleMap).Size()
+	if stats.NodeBytes != nodeBytes || nodes != stats.StoredNodes || stats.ValueBytes <= 0 {
+		t.Errorf("got %d node bytes, want %d, and %d value bytes", stats.NodeBytes, nodeBytes, stats.ValueBytes)
+	}
+
+	// Stores without Size are measured from their exports.
+	unsized := ImportSparseMerkleTree(unsizedStore{smt.nodes}, unsizedStore{smt.values}, sha256.New(), smt.Root())
+	if other, err := unsized.Stats(); err != nil || other.NodeBytes != stats.NodeBytes || other.ValueBytes != stats.ValueBytes {
+		t.Errorf("got stats %+v for stores without Size, want %+v, %v", other, stats, err)
+	}
+
+	smt.SetOperationLimit(10)
+	if _, err := smt.Stats(); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("did not return ErrLimitExceeded, got %v", err)
+	}
+	if _, err := smt.Stats(WithoutOperationLimit()); err != nil {
+		t.Errorf("returned error without the limit: %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package smt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+// recordMagic identifies a record stream written by a RecordWriter.
+var recordMagic = []byte("SMTR")
+
+const recordVersion = 1
+
+// Tags of the entries of a record stream.
+const (
+	recordEnd byte = iota
+	recordEntry
+)
+
+// Record stream layout, with lengths as uvarints:
+//
+//	magic, one byte of format version
+//	per entry: tag 1, key length, key, value length, value
+//	tag 0, then the big-endian CRC32 (IEEE) of everything before it
+
+// StreamExporter is implemented by stores that can export their entries to a
+// writer one at a time, without holding them all in memory as Export does.
+type StreamExporter interface {
+	// ExportTo writes the entries of the store to w as a record stream.
+	ExportTo(w io.Writer) error
+}
+
+// RecordWriter writes the entries of a store to a record stream, as read by
+// ImportStoreFrom, for implementations of StreamExporter.
+type RecordWriter struct {
+	w   io.Writer
+	crc hash.Hash32
+	sw  *streamWriter
+}
+
+// NewRecordWriter starts a record stream on w.
+func NewRecordWriter(w io.Writer) *RecordWriter {
+	crc := crc32.NewIEEE()
+	rw := &RecordWriter{w: w, crc: crc, sw: &streamWriter{w: bufio.NewWriter(io.MultiWriter(w, crc))}}
+	rw.sw.write(recordMagic)
+	rw.sw.write([]byte{recordVersion})
+	return rw
+}
+
+// Write writes an entry, buffering it.
+func (rw *RecordWriter) Write(key []byte, value []byte) error {
+	rw.sw.write([]byte{recordEntry})
+	rw.sw.writeBytes(key)
+	rw.sw.writeBytes(value)
+	return rw.sw.err
+}
+
+// Close ends the stream, flushing the buffered entries and writing the
+// checksum. It does not close the underlying writer.
+func (rw *RecordWriter) Close() error {
+	rw.sw.write([]byte{recordEnd})
+	if rw.sw.err != nil {
+		return rw.sw.err
+	}
+	if err := rw.sw.w.Flush(); err != nil {
+		return err
+	}
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], rw.crc.Sum32())
+	_, err := rw.w.Write(sum[:])
+	return err
+}
+
+// ExportStoreTo writes the entries of store to w as a record stream, with
+// ExportTo if the store is a StreamExporter, so that stores larger than memory
+// can be checkpointed or sent over the network; other stores are exported
+// with Export first. To write a tree, rather than a store, see WriteSnapshot.
+func ExportStoreTo(w io.Writer, store MapStore) error {
+	if exporter, ok := store.(StreamExporter); ok {
+		return exporter.ExportTo(w)
+	}
+	serial, err := store.Export()
+	if err != nil {
+		return err
+	}
+	var m map[string][]byte
+	if err := decodeSnapshot(serial, &m); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	rw := NewRecordWriter(w)
+	for _, key := range keys {
+		if err := rw.Write([]byte(key), m[key]); err != nil {
+			return err
+		}
+	}
+	return rw.Close()
+}
+
+// ImportStoreFrom reads a record stream written by ExportStoreTo into store,
+// one entry at a time, returning the number of entries read. A stream that is
+// malformed or truncated returns ErrSnapshotCorrupt, and one whose checksum
+// does not match ErrSnapshotChecksum; the store may then hold part of it.
+func ImportStoreFrom(r io.Reader, store MapStore) (int, error) {
+	sr := &streamReader{r: bufio.NewReader(r), crc: crc32.NewIEEE()}
+	magic := sr.read(len(recordMagic))
+	if sr.err == nil && !bytes.Equal(magic, recordMagic) {
+		return 0, ErrSnapshotCorrupt
+	}
+	version := sr.read(1)
+	if sr.err == nil && version[0] != recordVersion {
+		return 0, fmt.Errorf("%w: %d", ErrSnapshotVersion, version[0])
+	}
+	n := 0
+	for {
+		tag := sr.readTag()
+		if sr.err != nil {
+			return n, sr.err
+		}
+		if tag == recordEnd {
+			break
+		}
+		if tag != recordEntry {
+			return n, ErrSnapshotCorrupt
+		}
+		key := sr.readBytes()
+		value := sr.readBytes()
+		if sr.err != nil {
+			return n, sr.err
+		}
+		if err := store.Set(key, value); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	sum := sr.crc.Sum32()
+	trailer := sr.read(4)
+	if sr.err != nil {
+		return n, sr.err
+	}
+	if binary.BigEndian.Uint32(trailer) != sum {
+		return n, ErrSnapshotChecksum
+	}
+	return n, nil
+}
+
+// ExportTo writes the entries of the map to w as a record stream.
+func (sm *SimpleMap) ExportTo(w io.Writer) error {
+	defer sm.rlock()()
+	rw := NewRecordWriter(w)
+	for key, value := range sm.m {
+		if err := rw.Write([]byte(key), value); err != nil {
+			return err
+		}
+	}
+	return rw.Close()
+}
@@ -0,0 +1,78 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// exportOnlyStore hides the ExportTo of the store it wraps.
+type exportOnlyStore struct {
+	MapStore
+}
+
+func TestExportStoreTo(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 50; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+
+	for _, store := range []MapStore{smt.nodes, exportOnlyStore{smt.nodes}, NewSimpleMap()} {
+		var buf bytes.Buffer
+		if err := ExportStoreTo(&buf, store); err != nil {
+			t.Fatalf("returned error when exporting store: %v", err)
+		}
+		imported := NewSimpleMap()
+		n, err := ImportStoreFrom(bytes.NewReader(buf.Bytes()), imported)
+		if err != nil {
+			t.Fatalf("returned error when importing store: %v", err)
+		}
+		if expected := mustExport(t, store); !bytes.Equal(mustExport(t, imported), expected) || n != len(imported.m) {
+			t.Errorf("imported %d entries differing from the store", n)
+		}
+	}
+
+	// A tree on the imported stores has the same root.
+	var nodes, values bytes.Buffer
+	ExportStoreTo(&nodes, smt.nodes)
+	ExportStoreTo(&values, smt.values)
+	importedNodes, importedValues := NewSimpleMap(), NewSimpleMap()
+	ImportStoreFrom(&nodes, importedNodes)
+	ImportStoreFrom(&values, importedValues)
+	imported := ImportSparseMerkleTree(importedNodes, importedValues, sha256.New(), smt.Root())
+	if value, err := imported.Get([]byte("testKey7")); err != nil || !bytes.Equal(value, []byte("testValue7")) {
+		t.Errorf("did not get value from imported stores, got %s, %v", value, err)
+	}
+}
+
+func TestImportStoreFromCorrupt(t *testing.T) {
+	store := NewSimpleMap()
+	store.Set([]byte("key"), []byte("value"))
+	var buf bytes.Buffer
+	if err := ExportStoreTo(&buf, store); err != nil {
+		t.Fatalf("returned error when exporting store: %v", err)
+	}
+	data := buf.Bytes()
+
+	flipped := append([]byte{}, data...)
+	flipped[len(flipped)-6] ^= 1
+	version := append([]byte{}, data...)
+	version[len(recordMagic)] = recordVersion + 1
+	for name, c := range map[string]struct {
+		data []byte
+		err  error
+	}{
+		"truncated":  {data[:len(data)-1], ErrSnapshotCorrupt},
+		"no magic":   {data[1:], ErrSnapshotCorrupt},
+		"bad tag":    {append(append([]byte{}, data[:len(recordMagic)+1]...), 2), ErrSnapshotCorrupt},
+		"flipped":    {flipped, ErrSnapshotChecksum},
+		"version":    {version, ErrSnapshotVersion},
+		"empty data": {nil, ErrSnapshotCorrupt},
+	} {
+		if _, err := ImportStoreFrom(bytes.NewReader(c.data), NewSimpleMap()); !errors.Is(err, c.err) {
+			t.Errorf("did not return %v for %s stream, got %v", c.err, name, err)
+		}
+	}
+}
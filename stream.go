@@ -0,0 +1,299 @@
+package smt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+// ErrSnapshotHasher is returned by ReadSnapshot when a snapshot stream was
+// written by a tree with a different hasher.
+var ErrSnapshotHasher = errors.New("snapshot was written with a different hasher")
+
+// ErrSnapshotCorrupt is returned by ReadSnapshot when a snapshot stream is
+// malformed, or its contents do not match its root.
+var ErrSnapshotCorrupt = errors.New("snapshot stream is corrupt")
+
+// streamMagic identifies a snapshot stream written by WriteSnapshot.
+var streamMagic = []byte("SMTF")
+
+const streamVersion = 2
+
+// streamVersionDefaultPrefixes is the version of streams written before the
+// domain prefixes were recorded, which all used the default prefixes.
+const streamVersionDefaultPrefixes = 1
+
+// Stream layout, with lengths and counts as uvarints:
+//
+//	magic, one byte of format version
+//	hasher id length, hasher id (the digest of no data under the hasher)
+//	leaf prefix, node prefix (one byte each; absent in version 1 streams)
+//	root length, root
+//	node count, leaf count
+//	per node: data length, data (in depth-first, left-to-right order)
+//	per leaf: path, value length + 1, value (0 if the value is not stored)
+//	big-endian CRC32 (IEEE) of everything before it
+
+// WriteSnapshot writes the nodes and values under the current root to w as a
+// single framed stream, which ReadSnapshot reads back in one pass. Updates
+// are blocked until the snapshot is written; see SetOperationLimit.
+func (smt *SparseMerkleTree) WriteSnapshot(w io.Writer, options ...TraversalOption) error {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	root := smt.root
+	if err := smt.checkOperationLimit(root, options); err != nil {
+		return err
+	}
+	smt.debugf("writing snapshot at root %x", root)
+
+	var nodeCount int
+	var leafPaths [][]byte
+	err := smt.walk(root, func(_ []bool, _ []byte, data []byte) error {
+		nodeCount++
+		if smt.th.isLeaf(data) {
+			path, _ := smt.th.parseLeaf(data)
+			leafPaths = append(leafPaths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	crc := crc32.NewIEEE()
+	sw := &streamWriter{w: bufio.NewWriter(io.MultiWriter(w, crc))}
+	sw.write(streamMagic)
+	sw.write([]byte{streamVersion})
+	sw.writeBytes(smt.th.digest(nil))
+	sw.write(smt.th.domainPrefixes())
+	sw.writeBytes(root)
+	sw.writeUvarint(uint64(nodeCount))
+	sw.writeUvarint(uint64(len(leafPaths)))
+	if sw.err != nil {
+		return sw.err
+	}
+
+	err = smt.walk(root, func(_ []bool, _ []byte, data []byte) error {
+		sw.writeBytes(data)
+		return sw.err
+	})
+	if err != nil {
+		return err
+	}
+	for _, path := range leafPaths {
+		value, err := smt.getValue(path)
+		if errors.Is(err, ErrKeyNotFound) {
+			// Set with UpdateLeafHash.
+			sw.write(path)
+			sw.writeUvarint(0)
+			continue
+		} else if err != nil {
+			return err
+		}
+		sw.write(path)
+		sw.writeUvarint(uint64(len(value)) + 1)
+		sw.write(value)
+	}
+	if err := sw.w.Flush(); err != nil {
+		return err
+	}
+
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	if _, err := w.Write(sum[:]); err != nil {
+		return err
+	}
+	smt.debugf("wrote snapshot at root %x with %d nodes", root, nodeCount)
+	return nil
+}
+
+// ReadSnapshot reads a snapshot stream written by WriteSnapshot into nodes and
+// values, and imports the tree it holds. hasher must be the hasher of the
+// tree that wrote it, or ErrSnapshotHasher is returned, and options must give
+// the same domain prefixes, or ErrDomainPrefixes is returned. Nodes are stored by
+// their hash and values checked against their leaves, so a stream that reads
+// without error holds exactly the tree under its root. The stores should be
+// empty: if an error is returned, they may hold part of the snapshot.
+func ReadSnapshot(r io.Reader, nodes, values MapStore, hasher hash.Hash, options ...Option) (*SparseMerkleTree, error) {
+	sr := &streamReader{r: bufio.NewReader(r), crc: crc32.NewIEEE()}
+	magic := sr.read(len(streamMagic))
+	if sr.err == nil && !bytes.Equal(magic, streamMagic) {
+		return nil, ErrSnapshotCorrupt
+	}
+	version := sr.read(1)
+	if sr.err == nil && version[0] != streamVersion && version[0] != streamVersionDefaultPrefixes {
+		return nil, fmt.Errorf("%w: %d", ErrSnapshotVersion, version[0])
+	}
+	hasherID := sr.readBytes()
+	prefixes := []byte{leafPrefix[0], nodePrefix[0]}
+	if sr.err == nil && version[0] != streamVersionDefaultPrefixes {
+		prefixes = sr.read(len(prefixes))
+	}
+	root := sr.readBytes()
+	nodeCount := sr.readUvarint()
+	leafCount := sr.readUvarint()
+	if sr.err != nil {
+		return nil, sr.err
+	}
+
+	smt := ImportSparseMerkleTree(nodes, values, hasher, root, options...)
+	if !bytes.Equal(hasherID, smt.th.digest(nil)) {
+		return nil, ErrSnapshotHasher
+	}
+	if !bytes.Equal(prefixes, smt.th.domainPrefixes()) {
+		return nil, ErrDomainPrefixes
+	}
+	smt.debugf("reading snapshot at root %x", root)
+
+	// Value hashes of the leaves, by path, to check values against.
+	valueHashes := make(map[string][]byte)
+	for i := uint64(0); i < nodeCount; i++ {
+		data := sr.readBytes()
+		if sr.err != nil {
+			return nil, sr.err
+		}
+		if !smt.th.validNode(data) {
+			return nil, ErrSnapshotCorrupt
+		}
+		if smt.th.isLeaf(data) {
+			path, valueHash := smt.th.parseLeaf(data)
+			valueHashes[string(path)] = valueHash
+		}
+		if err := smt.setNode(smt.th.digest(data), data); err != nil {
+			return nil, err
+		}
+	}
+	for i := uint64(0); i < leafCount; i++ {
+		path := sr.read(smt.th.pathSize())
+		size := sr.readUvarint()
+		if sr.err != nil {
+			return nil, sr.err
+		}
+		valueHash, ok := valueHashes[string(path)]
+		if !ok {
+			return nil, ErrSnapshotCorrupt
+		}
+		if size == 0 {
+			continue
+		}
+		value := sr.readN(size - 1)
+		if sr.err != nil {
+			return nil, sr.err
+		}
+		if !bytes.Equal(smt.th.digestValue(value), valueHash) {
+			return nil, ErrSnapshotCorrupt
+		}
+		if err := smt.setValue(path, value); err != nil {
+			return nil, err
+		}
+	}
+
+	sum := sr.crc.Sum32()
+	trailer := sr.read(4)
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	if binary.BigEndian.Uint32(trailer) != sum {
+		return nil, ErrSnapshotChecksum
+	}
+	if !bytes.Equal(root, smt.th.placeholder()) {
+		if _, err := smt.getNode(root); err != nil {
+			return nil, ErrSnapshotCorrupt
+		}
+	}
+	smt.debugf("read snapshot at root %x with %d nodes", root, nodeCount)
+	return smt, nil
+}
+
+// streamWriter writes the fields of a snapshot stream, keeping the first
+// error.
+type streamWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (sw *streamWriter) write(data []byte) {
+	if sw.err == nil {
+		_, sw.err = sw.w.Write(data)
+	}
+}
+
+func (sw *streamWriter) writeUvarint(x uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	sw.write(buf[:binary.PutUvarint(buf[:], x)])
+}
+
+func (sw *streamWriter) writeBytes(data []byte) {
+	sw.writeUvarint(uint64(len(data)))
+	sw.write(data)
+}
+
+// streamReader reads the fields of a snapshot stream, keeping the first error
+// and the checksum of what it read.
+type streamReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+	err error
+}
+
+func (sr *streamReader) ReadByte() (byte, error) {
+	b, err := sr.r.ReadByte()
+	if err == nil {
+		sr.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (sr *streamReader) read(n int) []byte {
+	return sr.readN(uint64(n))
+}
+
+// readN reads n bytes, growing the buffer as they arrive rather than trusting
+// n up front.
+func (sr *streamReader) readN(n uint64) []byte {
+	if sr.err != nil {
+		return nil
+	}
+	if n > math.MaxInt64 {
+		sr.err = ErrSnapshotCorrupt
+		return nil
+	}
+	var buf bytes.Buffer
+	_, err := io.CopyN(&buf, io.TeeReader(sr.r, sr.crc), int64(n))
+	if errors.Is(err, io.EOF) {
+		sr.err = ErrSnapshotCorrupt
+	} else if err != nil {
+		sr.err = err
+	}
+	return buf.Bytes()
+}
+
+// readTag reads a single byte, such as the tag of an entry, or returns zero
+// once the stream has failed.
+func (sr *streamReader) readTag() byte {
+	if b := sr.read(1); len(b) == 1 {
+		return b[0]
+	}
+	return 0
+}
+
+func (sr *streamReader) readUvarint() uint64 {
+	if sr.err != nil {
+		return 0
+	}
+	x, err := binary.ReadUvarint(sr)
+	if err != nil {
+		sr.err = ErrSnapshotCorrupt
+	}
+	return x
+}
+
+func (sr *streamReader) readBytes() []byte {
+	return sr.readN(sr.readUvarint())
+}
@@ -0,0 +1,146 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"testing"
+)
+
+func TestSnapshotStream(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 100; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	smt.Delete([]byte("testKey7"))
+	smt.UpdateLeafHash([]byte("hashedKey"), make([]byte, sha256.Size))
+
+	var buf bytes.Buffer
+	if err := smt.WriteSnapshot(&buf); err != nil {
+		t.Fatalf("returned error when writing snapshot: %v", err)
+	}
+	stream := buf.Bytes()
+
+	imported, err := ReadSnapshot(bytes.NewReader(stream), NewSimpleMap(), NewSimpleMap(), sha256.New(), WithCompactNodes())
+	if err != nil {
+		t.Fatalf("returned error when reading snapshot: %v", err)
+	}
+	if !bytes.Equal(imported.Root(), smt.Root()) {
+		t.Error("imported tree has wrong root")
+	}
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		expected, _ := smt.Get(key)
+		value, err := imported.Get(key)
+		if err != nil || !bytes.Equal(value, expected) {
+			t.Errorf("did not get correct value for key %s", key)
+		}
+	}
+	proof, _ := imported.Prove([]byte("hashedKey"))
+	if !VerifyProofWithValueHash(proof, smt.Root(), []byte("hashedKey"), make([]byte, sha256.Size), sha256.New()) {
+		t.Error("proof for leaf without value failed to verify")
+	}
+	imported.Update([]byte("testKey7"), []byte("testValue7"))
+	smt.Update([]byte("testKey7"), []byte("testValue7"))
+	if !bytes.Equal(imported.Root(), smt.Root()) {
+		t.Error("imported tree diverged after update")
+	}
+
+	empty := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	buf.Reset()
+	empty.WriteSnapshot(&buf)
+	imported, err = ReadSnapshot(&buf, NewSimpleMap(), NewSimpleMap(), sha256.New())
+	if err != nil || !bytes.Equal(imported.Root(), empty.Root()) {
+		t.Errorf("did not read empty snapshot, got %v", err)
+	}
+}
+
+func TestSnapshotStreamCorrupt(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 10; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	var buf bytes.Buffer
+	smt.WriteSnapshot(&buf)
+	stream := buf.Bytes()
+
+	if _, err := ReadSnapshot(bytes.NewReader(stream), NewSimpleMap(), NewSimpleMap(), sha512.New()); !errors.Is(err, ErrSnapshotHasher) {
+		t.Errorf("did not return ErrSnapshotHasher for wrong hasher, got %v", err)
+	}
+	for n := 0; n < len(stream); n += 7 {
+		if _, err := ReadSnapshot(bytes.NewReader(stream[:n]), NewSimpleMap(), NewSimpleMap(), sha256.New()); err == nil {
+			t.Fatalf("did not return error for stream truncated to %d bytes", n)
+		}
+	}
+
+	// Flipping the last byte of the last value breaks its hash; flipping
+	// the trailer breaks the checksum.
+	tampered := append([]byte(nil), stream...)
+	tampered[len(tampered)-5] ^= 1
+	if _, err := ReadSnapshot(bytes.NewReader(tampered), NewSimpleMap(), NewSimpleMap(), sha256.New()); !errors.Is(err, ErrSnapshotCorrupt) {
+		t.Errorf("did not return ErrSnapshotCorrupt for tampered value, got %v", err)
+	}
+	tampered = append([]byte(nil), stream...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := ReadSnapshot(bytes.NewReader(tampered), NewSimpleMap(), NewSimpleMap(), sha256.New()); !errors.Is(err, ErrSnapshotChecksum) {
+		t.Errorf("did not return ErrSnapshotChecksum for tampered trailer, got %v", err)
+	}
+}
+
+func TestSnapshotStreamDomainPrefixes(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithDomainPrefixes(0x10, 0x11))
+	for i := 0; i < 10; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	var buf bytes.Buffer
+	smt.WriteSnapshot(&buf)
+	stream := buf.Bytes()
+
+	if _, err := ReadSnapshot(bytes.NewReader(stream), NewSimpleMap(), NewSimpleMap(), sha256.New()); !errors.Is(err, ErrDomainPrefixes) {
+		t.Errorf("did not return ErrDomainPrefixes for other prefixes, got %v", err)
+	}
+	imported, err := ReadSnapshot(bytes.NewReader(stream), NewSimpleMap(), NewSimpleMap(), sha256.New(), WithDomainPrefixes(0x10, 0x11))
+	if err != nil {
+		t.Fatalf("returned error when reading snapshot: %v", err)
+	}
+	if !bytes.Equal(imported.Root(), smt.Root()) {
+		t.Error("imported root does not match")
+	}
+}
+
+// Test that streams written before the domain prefixes were recorded are read
+// with the default prefixes.
+func TestSnapshotStreamVersion1(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 10; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	var buf bytes.Buffer
+	smt.WriteSnapshot(&buf)
+	stream := buf.Bytes()
+
+	// Drop the prefixes after the magic, version and hasher id, and checksum
+	// the stream again.
+	prefixes := len(streamMagic) + 1 + 1 + sha256.Size
+	v1 := append([]byte{}, stream[:prefixes]...)
+	v1 = append(v1, stream[prefixes+2:len(stream)-4]...)
+	v1[len(streamMagic)] = streamVersionDefaultPrefixes
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.ChecksumIEEE(v1))
+	v1 = append(v1, sum[:]...)
+
+	imported, err := ReadSnapshot(bytes.NewReader(v1), NewSimpleMap(), NewSimpleMap(), sha256.New())
+	if err != nil {
+		t.Fatalf("returned error when reading version 1 snapshot: %v", err)
+	}
+	if !bytes.Equal(imported.Root(), smt.Root()) {
+		t.Error("imported root does not match")
+	}
+	if _, err := ReadSnapshot(bytes.NewReader(v1), NewSimpleMap(), NewSimpleMap(), sha256.New(), WithDomainPrefixes(0x10, 0x11)); !errors.Is(err, ErrDomainPrefixes) {
+		t.Errorf("did not return ErrDomainPrefixes for version 1 snapshot, got %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package smt
+
+import (
+	"bytes"
+	"hash"
+)
+
+// StreamingVerifier verifies a Merkle proof as its side nodes arrive, one at a
+// time from the leaf up to the root, keeping only a running hash rather than
+// the whole proof. It verifies the same proofs as VerifyProof, given their
+// NonMembershipLeafData and SideNodes in order.
+type StreamingVerifier struct {
+	th           *treeHasher
+	path         []byte
+	membership   bool
+	numSideNodes int
+	// added is the number of side nodes added so far, and currentHash the
+	// hash of the node they lead up to.
+	added       int
+	currentHash []byte
+	err         error
+}
+
+// NewStreamingVerifier creates a StreamingVerifier for a proof with
+// numSideNodes side nodes of the value of key, as given to VerifyProof: a
+// membership proof, or a non-membership proof for the default value. For a
+// non-membership proof, the proof's NonMembershipLeafData, if any, must be
+// added with AddNonMembershipLeafData before the side nodes.
+func NewStreamingVerifier(key []byte, value []byte, numSideNodes int, hasher hash.Hash, options ...VerifyOption) *StreamingVerifier {
+	config := newVerifyConfig(options)
+	th := config.treeHasher(hasher)
+	sv := &StreamingVerifier{th: th, path: th.path(key), numSideNodes: numSideNodes}
+	if numSideNodes < 0 || numSideNodes > th.maxSideNodes() {
+		sv.err = ErrBadProof
+		return sv
+	}
+	if bytes.Equal(value, config.defaultValue) {
+		sv.currentHash = th.placeholder()
+	} else {
+		sv.membership = true
+		sv.currentHash, _ = th.digestLeaf(sv.path, th.digestValue(value))
+	}
+	return sv
+}
+
+// AddNonMembershipLeafData starts a non-membership proof from the unrelated
+// leaf whose data is given, instead of an empty subtree. ErrBadProof is
+// returned, and the proof fails to finalize, if the proof is not of
+// non-membership, side nodes were already added, or the leaf is at the path
+// of the key or cannot be on its path.
+func (sv *StreamingVerifier) AddNonMembershipLeafData(data []byte) error {
+	if sv.err != nil {
+		return sv.err
+	}
+	if sv.membership || sv.added > 0 || !sv.th.validNode(data) || !sv.th.isLeaf(data) {
+		sv.err = ErrBadProof
+		return sv.err
+	}
+	actualPath, valueHash := sv.th.parseLeaf(data)
+	if bytes.Equal(actualPath, sv.path) || countCommonPrefix(actualPath, sv.path) < sv.numSideNodes {
+		sv.err = ErrBadProof
+		return sv.err
+	}
+	sv.currentHash, _ = sv.th.digestLeaf(actualPath, valueHash)
+	return nil
+}
+
+// AddSideNode combines the next side node of the proof, from the leaf
+// upwards, into the running hash. ErrBadProof is returned, and the proof
+// fails to finalize, if the side node is malformed or there is one more than
+// expected, so that a client can stop receiving the proof early.
+func (sv *StreamingVerifier) AddSideNode(sideNode []byte) error {
+	if sv.err != nil {
+		return sv.err
+	}
+	if sv.added == sv.numSideNodes || len(sideNode) != sv.th.hashSize() {
+		sv.err = ErrBadProof
+		return sv.err
+	}
+	if getBitAtFromMSB(sv.path, sv.numSideNodes-1-sv.added) == right {
+		sv.currentHash, _ = sv.th.digestNode(sideNode, sv.currentHash)
+	} else {
+		sv.currentHash, _ = sv.th.digestNode(sv.currentHash, sideNode)
+	}
+	sv.added++
+	return nil
+}
+
+// Hash returns the running hash, i.e. the hash of the node the side nodes
+// added so far lead up to, which a client may compare against a subtree root
+// it already knows.
+func (sv *StreamingVerifier) Hash() []byte {
+	return sv.currentHash
+}
+
+// Finalize returns true if every side node was added and the running hash is
+// root.
+func (sv *StreamingVerifier) Finalize(root []byte) bool {
+	return sv.err == nil && sv.added == sv.numSideNodes && bytes.Equal(sv.currentHash, root)
+}
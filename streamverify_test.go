@@ -0,0 +1,76 @@
+package smt
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// streamProof feeds proof to a StreamingVerifier for key and value.
+func streamProof(proof SparseMerkleProof, key []byte, value []byte) (*StreamingVerifier, error) {
+	sv := NewStreamingVerifier(key, value, len(proof.SideNodes), sha256.New())
+	if proof.NonMembershipLeafData != nil {
+		if err := sv.AddNonMembershipLeafData(proof.NonMembershipLeafData); err != nil {
+			return sv, err
+		}
+	}
+	for _, sideNode := range proof.SideNodes {
+		if err := sv.AddSideNode(sideNode); err != nil {
+			return sv, err
+		}
+	}
+	return sv, nil
+}
+
+func TestStreamingVerifier(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 50; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		value, _ := smt.Get(key)
+		proof, _ := smt.Prove(key)
+		sv, err := streamProof(proof, key, value)
+		if err != nil {
+			t.Fatalf("returned error when streaming proof: %v", err)
+		}
+		if !sv.Finalize(smt.Root()) {
+			t.Errorf("streamed proof of key %s failed to verify", key)
+		}
+		if sv, _ := streamProof(proof, key, []byte("wrongValue")); sv.Finalize(smt.Root()) {
+			t.Errorf("streamed proof of key %s verified for wrong value", key)
+		}
+	}
+
+	key := []byte("testKey1")
+	proof, _ := smt.Prove(key)
+	sv := NewStreamingVerifier(key, []byte("testValue1"), len(proof.SideNodes), sha256.New())
+	for _, sideNode := range proof.SideNodes {
+		sv.AddSideNode(sideNode)
+	}
+	if err := sv.AddSideNode(proof.SideNodes[0]); !errors.Is(err, ErrBadProof) {
+		t.Errorf("did not return ErrBadProof for extra side node, got %v", err)
+	}
+	if sv.Finalize(smt.Root()) {
+		t.Error("streamed proof verified after extra side node")
+	}
+
+	sv = NewStreamingVerifier(key, []byte("testValue1"), len(proof.SideNodes), sha256.New())
+	if err := sv.AddSideNode([]byte("short")); !errors.Is(err, ErrBadProof) {
+		t.Errorf("did not return ErrBadProof for malformed side node, got %v", err)
+	}
+	sv = NewStreamingVerifier(key, []byte("testValue1"), len(proof.SideNodes), sha256.New())
+	sv.AddSideNode(proof.SideNodes[0])
+	if sv.Finalize(smt.Root()) {
+		t.Error("streamed proof verified with missing side nodes")
+	}
+	if err := sv.AddNonMembershipLeafData(proof.SideNodes[0]); !errors.Is(err, ErrBadProof) {
+		t.Errorf("did not return ErrBadProof for leaf data of membership proof, got %v", err)
+	}
+
+	if sv := NewStreamingVerifier(key, nil, smt.depth()+1, sha256.New()); sv.AddSideNode(proof.SideNodes[0]) == nil {
+		t.Error("did not reject proof with too many side nodes")
+	}
+}
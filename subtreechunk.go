@@ -0,0 +1,344 @@
+package smt
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+)
+
+// SubtreeChunk is the part of a tree under a path prefix, as exported by
+// ExportSubtree: every node and value of the subtree, with the boundary hashes
+// that prove it part of the root of the tree. A tree can be synced in chunks
+// by prefix, each checked with VerifySubtreeChunk and grafted with
+// ImportSubtree as it arrives.
+type SubtreeChunk struct {
+	// Prefix is the path prefix of the subtree, where true is right.
+	Prefix []bool
+
+	// Proof proves the root of the subtree, which is the hash of the first of
+	// Nodes, or the placeholder if there are none, under the root of the tree.
+	Proof SparseMerkleSubtreeProof
+
+	// Nodes is the data of the nodes of the subtree, in depth-first,
+	// left-to-right order.
+	Nodes [][]byte
+
+	// Values holds, for each of Nodes, the value of a value leaf, or nil for a
+	// branch or a leaf without a stored value.
+	Values [][]byte
+
+	// Keys holds, for each of Nodes, the raw key of a leaf of a tree created
+	// with WithKeyStore, or nil.
+	Keys [][]byte
+}
+
+// ExportSubtree exports the subtree of the keys whose paths start with prefix,
+// where true is right, under the current root; see SubtreeChunk. The walk of
+// the subtree is subject to SetOperationLimit.
+func (smt *SparseMerkleTree) ExportSubtree(prefix []bool, options ...TraversalOption) (SubtreeChunk, error) {
+	if len(prefix) > smt.depth() {
+		return SubtreeChunk{}, ErrPrefixTooLong
+	}
+	defer smt.readLock()()
+	root := smt.Root()
+	proof, err := smt.ProveSubtree(prefix)
+	if err != nil {
+		return SubtreeChunk{}, err
+	}
+	chunk := SubtreeChunk{Prefix: append([]bool{}, prefix...), Proof: proof}
+
+	subtreeRoot := smt.th.placeholder()
+	if proof.LeafData != nil {
+		if path, _ := smt.th.parseLeaf(proof.LeafData); pathHasPrefix(path, prefix, 0) {
+			subtreeRoot = smt.th.digest(proof.LeafData)
+		}
+	} else if len(proof.SideNodes) == len(prefix) {
+		if subtreeRoot, _, _, err = smt.descendPrefix(root, prefix); err != nil {
+			return SubtreeChunk{}, err
+		}
+	}
+	if err := smt.checkOperationLimit(subtreeRoot, options); err != nil {
+		return SubtreeChunk{}, err
+	}
+
+	err = smt.walk(subtreeRoot, func(_ []bool, _ []byte, data []byte) error {
+		var value, key []byte
+		if smt.th.isLeaf(data) {
+			path, valueHash := smt.th.parseLeaf(data)
+			if len(valueHash) != 0 {
+				stored, err := smt.getValue(path)
+				if err != nil && !errors.Is(err, ErrKeyNotFound) {
+					return err
+				}
+				value = stored
+			}
+			if smt.keys != nil {
+				stored, err := smt.keys.Get(path)
+				if err != nil && !errors.Is(err, ErrKeyNotFound) {
+					return err
+				}
+				key = stored
+			}
+		}
+		chunk.Nodes = append(chunk.Nodes, data)
+		chunk.Values = append(chunk.Values, value)
+		chunk.Keys = append(chunk.Keys, key)
+		return nil
+	})
+	if err != nil {
+		return SubtreeChunk{}, err
+	}
+	return chunk, nil
+}
+
+// VerifySubtreeChunk verifies that a chunk is the subtree under its prefix of
+// the tree at root: that its nodes form the subtree whose root the proof
+// proves under root, and that its values and keys are those of their leaves.
+func VerifySubtreeChunk(chunk SubtreeChunk, root []byte, hasher hash.Hash, options ...VerifyOption) bool {
+	_, ok := chunkNodes(newVerifyConfig(options).treeHasher(hasher), chunk, root)
+	return ok
+}
+
+// ImportSubtree grafts a chunk exported by ExportSubtree from a tree at the
+// same root into the stores of the tree, after verifying it against the
+// root, returning ErrBadProof if it does not verify. Along with the subtree,
+// the branches above it are stored, as computed from the proof, so that a
+// tree imported at a root onto empty stores can be filled chunk by chunk:
+// the keys under the prefixes grafted so far can be read and proven, and once
+// the prefixes cover every path, the whole tree is in the stores. The root is
+// not changed.
+func (smt *SparseMerkleTree) ImportSubtree(chunk SubtreeChunk) error {
+	defer smt.writeLock()()
+	if smt.sealed {
+		return ErrSealed
+	}
+	nodes, ok := chunkNodes(&smt.th, chunk, smt.root)
+	if !ok {
+		return ErrBadProof
+	}
+	for _, data := range nodes {
+		if err := smt.setNode(smt.th.digest(data), data); err != nil {
+			return err
+		}
+	}
+	for i, data := range chunk.Nodes {
+		if !smt.th.isLeaf(data) {
+			continue
+		}
+		path, _ := smt.th.parseLeaf(data)
+		if chunk.Values[i] != nil {
+			if err := smt.setValue(path, chunk.Values[i]); err != nil {
+				return err
+			}
+		}
+		if chunk.Keys[i] != nil {
+			if err := smt.setKey(path, chunk.Keys[i]); err != nil {
+				return err
+			}
+		}
+	}
+	smt.debugf("imported subtree of %d nodes at prefix of %d bits", len(chunk.Nodes), len(chunk.Prefix))
+	return nil
+}
+
+// chunkNodes verifies a chunk against root, returning the data of its nodes
+// and of those on the path from the subtree up to the root.
+func chunkNodes(th *treeHasher, chunk SubtreeChunk, root []byte) ([][]byte, bool) {
+	if len(chunk.Values) != len(chunk.Nodes) || len(chunk.Keys) != len(chunk.Nodes) {
+		return nil, false
+	}
+	depth := len(chunk.Proof.SideNodes)
+	if len(chunk.Prefix) > th.treeDepth() || depth > len(chunk.Prefix) {
+		return nil, false
+	}
+	subtreeRoot := th.placeholder()
+	if len(chunk.Nodes) > 0 {
+		c := &chunkChecker{th: th, chunk: chunk}
+		var ok bool
+		if subtreeRoot, ok = c.check(depth); !ok || c.next != len(chunk.Nodes) {
+			return nil, false
+		}
+	}
+	if !verifySubtreeProof(th, chunk.Proof, root, chunk.Prefix, subtreeRoot) {
+		return nil, false
+	}
+
+	nodes := append([][]byte{}, chunk.Nodes...)
+	current := subtreeRoot
+	if chunk.Proof.LeafData != nil {
+		if len(chunk.Nodes) == 0 {
+			// An unrelated leaf in place of the empty subtree.
+			nodes = append(nodes, chunk.Proof.LeafData)
+		}
+		current = th.digest(chunk.Proof.LeafData)
+	}
+	for i, sideNode := range chunk.Proof.SideNodes {
+		var data []byte
+		if chunk.Prefix[depth-1-i] {
+			current, data = th.digestNode(sideNode, current)
+		} else {
+			current, data = th.digestNode(current, sideNode)
+		}
+		nodes = append(nodes, data)
+	}
+	return nodes, true
+}
+
+// chunkChecker checks that the nodes of a chunk, from next on, form a subtree
+// in depth-first order, with the values and keys of its leaves.
+type chunkChecker struct {
+	th    *treeHasher
+	chunk SubtreeChunk
+	next  int
+}
+
+// check consumes the subtree whose root is the next node, at depth, and
+// returns its hash.
+func (c *chunkChecker) check(depth int) ([]byte, bool) {
+	if c.next >= len(c.chunk.Nodes) || depth > c.th.treeDepth() {
+		return nil, false
+	}
+	i := c.next
+	data := c.chunk.Nodes[i]
+	c.next++
+	if !c.th.validNode(data) {
+		return nil, false
+	}
+	if c.th.isLeaf(data) {
+		path, valueHash := c.th.parseLeaf(data)
+		if !pathHasPrefix(path, c.chunk.Prefix, 0) {
+			return nil, false
+		}
+		if value := c.chunk.Values[i]; value != nil && (len(valueHash) == 0 || !bytes.Equal(c.th.digestValue(value), valueHash)) {
+			return nil, false
+		}
+		if key := c.chunk.Keys[i]; key != nil && !bytes.Equal(c.th.path(key), path) {
+			return nil, false
+		}
+		return c.th.digest(data), true
+	}
+	if c.chunk.Values[i] != nil || c.chunk.Keys[i] != nil || depth == c.th.treeDepth() {
+		return nil, false
+	}
+	left, right := c.th.parseNode(data)
+	for _, child := range [][]byte{left, right} {
+		if bytes.Equal(child, c.th.placeholder()) {
+			continue
+		}
+		hash, ok := c.check(depth + 1)
+		if !ok || !bytes.Equal(hash, child) {
+			return nil, false
+		}
+	}
+	return c.th.digest(data), true
+}
+
+// subtreeChunkMagic identifies a chunk encoded with MarshalBinary.
+var subtreeChunkMagic = []byte("SMTC")
+
+const subtreeChunkVersion = 1
+
+// MarshalBinary encodes the chunk, for sending to a tree to import it. Its
+// contents are checked by VerifySubtreeChunk, not by the encoding.
+func (chunk SubtreeChunk) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	sw := &streamWriter{w: bufio.NewWriter(&buf)}
+	sw.write(subtreeChunkMagic)
+	sw.write([]byte{subtreeChunkVersion})
+	sw.writeUvarint(uint64(len(chunk.Prefix)))
+	packed := make([]byte, (len(chunk.Prefix)+7)/8)
+	for i, bit := range chunk.Prefix {
+		if bit {
+			packed[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	sw.write(packed)
+	sw.writeUvarint(uint64(len(chunk.Proof.SideNodes)))
+	for _, sideNode := range chunk.Proof.SideNodes {
+		sw.writeBytes(sideNode)
+	}
+	sw.writeBytes(chunk.Proof.LeafData)
+	sw.writeUvarint(uint64(len(chunk.Nodes)))
+	for i, data := range chunk.Nodes {
+		sw.writeBytes(data)
+		writeOptionalBytes(sw, chunk.Values[i])
+		writeOptionalBytes(sw, chunk.Keys[i])
+	}
+	if sw.err == nil {
+		sw.err = sw.w.Flush()
+	}
+	if sw.err != nil {
+		return nil, sw.err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a chunk encoded with MarshalBinary, returning
+// ErrBadProof if it is malformed.
+func (chunk *SubtreeChunk) UnmarshalBinary(data []byte) error {
+	sr := &streamReader{r: bufio.NewReader(bytes.NewReader(data)), crc: crc32.NewIEEE()}
+	if magic := sr.read(len(subtreeChunkMagic)); sr.err == nil && !bytes.Equal(magic, subtreeChunkMagic) {
+		return fmt.Errorf("%w: not a subtree chunk", ErrBadProof)
+	}
+	if version := sr.readTag(); sr.err == nil && version != subtreeChunkVersion {
+		return fmt.Errorf("%w: unsupported chunk version %d", ErrBadProof, version)
+	}
+	var decoded SubtreeChunk
+	bits := sr.readUvarint()
+	packed := sr.readN((bits + 7) / 8)
+	if sr.err == nil {
+		decoded.Prefix = make([]bool, bits)
+		for i := range decoded.Prefix {
+			decoded.Prefix[i] = packed[i/8]&(0x80>>(i%8)) != 0
+		}
+	}
+	for n := sr.readUvarint(); sr.err == nil && n > 0; n-- {
+		decoded.Proof.SideNodes = append(decoded.Proof.SideNodes, sr.readBytes())
+	}
+	if leafData := sr.readBytes(); len(leafData) > 0 {
+		decoded.Proof.LeafData = leafData
+	}
+	for n := sr.readUvarint(); sr.err == nil && n > 0; n-- {
+		decoded.Nodes = append(decoded.Nodes, sr.readBytes())
+		decoded.Values = append(decoded.Values, readOptionalBytes(sr))
+		decoded.Keys = append(decoded.Keys, readOptionalBytes(sr))
+	}
+	if sr.err != nil {
+		return fmt.Errorf("%w: %v", ErrBadProof, sr.err)
+	}
+	if _, err := sr.r.ReadByte(); err == nil {
+		return fmt.Errorf("%w: trailing data", ErrBadProof)
+	}
+	*chunk = decoded
+	return nil
+}
+
+// writeOptionalBytes writes a tag of 0 for nil, or 1 followed by data.
+func writeOptionalBytes(sw *streamWriter, data []byte) {
+	if data == nil {
+		sw.write([]byte{0})
+		return
+	}
+	sw.write([]byte{1})
+	sw.writeBytes(data)
+}
+
+func readOptionalBytes(sr *streamReader) []byte {
+	switch sr.readTag() {
+	case 0:
+		return nil
+	case 1:
+		if data := sr.readBytes(); data != nil {
+			return data
+		}
+		return []byte{}
+	default:
+		if sr.err == nil {
+			sr.err = ErrSnapshotCorrupt
+		}
+		return nil
+	}
+}
@@ -0,0 +1,113 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSubtreeChunks(t *testing.T) {
+	for _, n := range []int{0, 3, 200} {
+		smn, smv, smk := NewSimpleMap(), NewSimpleMap(), NewSimpleMap()
+		smt := NewSparseMerkleTree(smn, smv, sha256.New(), WithKeyStore(smk))
+		for i := 0; i < n; i++ {
+			smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+		}
+		smt.UpdatePresence([]byte("presentKey"))
+
+		nodes, values, keys := NewSimpleMap(), NewSimpleMap(), NewSimpleMap()
+		replica := ImportSparseMerkleTree(nodes, values, sha256.New(), smt.Root(), WithKeyStore(keys))
+		for i := 0; i < 16; i++ {
+			prefix := []bool{i&8 != 0, i&4 != 0, i&2 != 0, i&1 != 0}
+			chunk, err := smt.ExportSubtree(prefix)
+			if err != nil {
+				t.Fatalf("returned error when exporting subtree: %v", err)
+			}
+			data, err := chunk.MarshalBinary()
+			if err != nil {
+				t.Fatalf("returned error when encoding chunk: %v", err)
+			}
+			var decoded SubtreeChunk
+			if err := decoded.UnmarshalBinary(data); err != nil {
+				t.Fatalf("returned error when decoding chunk: %v", err)
+			}
+			if !VerifySubtreeChunk(decoded, smt.Root(), sha256.New()) {
+				t.Fatalf("chunk %d of a tree of %d keys failed to verify", i, n)
+			}
+			if err := replica.ImportSubtree(decoded); err != nil {
+				t.Fatalf("returned error when importing chunk: %v", err)
+			}
+		}
+
+		if !simpleMapsEqual(nodes, smn) || !simpleMapsEqual(values, smv) || !simpleMapsEqual(keys, smk) {
+			t.Errorf("stores of a tree of %d keys imported by chunks differ", n)
+		}
+		for i := 0; i < n; i++ {
+			key := []byte(fmt.Sprintf("testKey%d", i))
+			if value, err := replica.Get(key); err != nil || !bytes.Equal(value, []byte(fmt.Sprintf("testValue%d", i))) {
+				t.Errorf("got %q, %v for %s from the imported tree", value, err, key)
+			}
+		}
+	}
+}
+
+func TestSubtreeChunkTampered(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 100; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	prefix := []bool{true, false}
+	chunk, err := smt.ExportSubtree(prefix)
+	if err != nil {
+		t.Fatalf("returned error when exporting subtree: %v", err)
+	}
+	if len(chunk.Nodes) < 3 {
+		t.Fatalf("subtree has only %d nodes", len(chunk.Nodes))
+	}
+	root := smt.Root()
+
+	copyChunk := func() SubtreeChunk {
+		c := chunk
+		c.Nodes = append([][]byte{}, chunk.Nodes...)
+		c.Values = append([][]byte{}, chunk.Values...)
+		c.Keys = append([][]byte{}, chunk.Keys...)
+		return c
+	}
+	for i := range chunk.Values {
+		if chunk.Values[i] != nil {
+			forged := copyChunk()
+			forged.Values[i] = []byte("forgedValue")
+			if VerifySubtreeChunk(forged, root, sha256.New()) {
+				t.Error("chunk with a forged value verified")
+			}
+			break
+		}
+	}
+	dropped := copyChunk()
+	dropped.Nodes, dropped.Values, dropped.Keys = dropped.Nodes[:len(dropped.Nodes)-1], dropped.Values[:len(dropped.Values)-1], dropped.Keys[:len(dropped.Keys)-1]
+	if VerifySubtreeChunk(dropped, root, sha256.New()) {
+		t.Error("chunk missing a node verified")
+	}
+	moved := copyChunk()
+	moved.Prefix = []bool{false, true}
+	if VerifySubtreeChunk(moved, root, sha256.New()) {
+		t.Error("chunk verified under another prefix")
+	}
+
+	smt.Update([]byte("otherKey"), []byte("otherValue"))
+	if VerifySubtreeChunk(chunk, smt.Root(), sha256.New()) {
+		t.Error("chunk verified against another root")
+	}
+	other := ImportSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), smt.Root())
+	if err := other.ImportSubtree(chunk); !errors.Is(err, ErrBadProof) {
+		t.Errorf("got %v importing a chunk of another root, want ErrBadProof", err)
+	}
+
+	data, _ := chunk.MarshalBinary()
+	var decoded SubtreeChunk
+	if err := decoded.UnmarshalBinary(data[:len(data)-1]); !errors.Is(err, ErrBadProof) {
+		t.Errorf("got %v decoding a truncated chunk, want ErrBadProof", err)
+	}
+}
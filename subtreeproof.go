@@ -0,0 +1,105 @@
+package smt
+
+import (
+	"bytes"
+	"hash"
+)
+
+// SparseMerkleSubtreeProof is a Merkle proof that a subtree root, as returned
+// by NodeAt for a prefix, is committed under the root of a tree.
+type SparseMerkleSubtreeProof struct {
+	// SideNodes is an array of the sibling nodes leading up to the subtree's
+	// node, from the node upwards. There are fewer than the length of the
+	// prefix if the descent ends early at a leaf or a placeholder.
+	SideNodes [][]byte
+
+	// LeafData is the data of the leaf the descent ends early at, if any:
+	// either the subtree root, for a leaf whose path starts with the prefix,
+	// or an unrelated leaf, which proves the subtree empty.
+	LeafData []byte
+}
+
+// ProveSubtree generates a proof that the root of the subtree of the keys
+// whose paths start with prefix, i.e. the hash NodeAt returns for prefix, is
+// committed under the current root, where true is right. This lets the state
+// of a namespace be delegated as a subtree root and proven part of the tree.
+func (smt *SparseMerkleTree) ProveSubtree(prefix []bool) (SparseMerkleSubtreeProof, error) {
+	if len(prefix) > smt.depth() {
+		return SparseMerkleSubtreeProof{}, ErrPrefixTooLong
+	}
+	var sideNodes [][]byte
+	hash := smt.Root()
+	for depth := 0; depth < len(prefix); depth++ {
+		if bytes.Equal(hash, smt.th.placeholder()) {
+			break
+		}
+		data, err := smt.getNode(hash)
+		if err != nil {
+			return SparseMerkleSubtreeProof{}, err
+		}
+		if smt.th.isLeaf(data) {
+			return SparseMerkleSubtreeProof{
+				SideNodes: reverseByteSlices(sideNodes),
+				LeafData:  data,
+			}, nil
+		}
+		leftNode, rightNode := smt.th.parseNode(data)
+		if prefix[depth] {
+			hash, sideNodes = rightNode, append(sideNodes, leftNode)
+		} else {
+			hash, sideNodes = leftNode, append(sideNodes, rightNode)
+		}
+	}
+	return SparseMerkleSubtreeProof{SideNodes: reverseByteSlices(sideNodes)}, nil
+}
+
+// VerifySubtreeProof verifies a proof that subtreeRoot is the root of the
+// subtree of the keys whose paths start with prefix, under root.
+func VerifySubtreeProof(proof SparseMerkleSubtreeProof, root []byte, prefix []bool, subtreeRoot []byte, hasher hash.Hash, options ...VerifyOption) bool {
+	return verifySubtreeProof(newVerifyConfig(options).treeHasher(hasher), proof, root, prefix, subtreeRoot)
+}
+
+func verifySubtreeProof(th *treeHasher, proof SparseMerkleSubtreeProof, root []byte, prefix []bool, subtreeRoot []byte) bool {
+	depth := len(proof.SideNodes)
+	if len(prefix) > th.treeDepth() || depth > len(prefix) {
+		return false
+	}
+	for _, sideNode := range proof.SideNodes {
+		if len(sideNode) != th.hashSize() {
+			return false
+		}
+	}
+
+	currentHash := subtreeRoot
+	if proof.LeafData != nil {
+		if !th.validNode(proof.LeafData) || !th.isLeaf(proof.LeafData) {
+			return false
+		}
+		// The leaf must be at the end of the side nodes on the prefix's path,
+		// standing for the subtree or proving it empty.
+		path, _ := th.parseLeaf(proof.LeafData)
+		if !pathHasPrefix(path, prefix[:depth], 0) {
+			return false
+		}
+		currentHash = th.digest(proof.LeafData)
+		if pathHasPrefix(path, prefix[depth:], depth) {
+			if !bytes.Equal(subtreeRoot, currentHash) {
+				return false
+			}
+		} else if !bytes.Equal(subtreeRoot, th.placeholder()) {
+			return false
+		}
+	} else if depth < len(prefix) && !bytes.Equal(subtreeRoot, th.placeholder()) {
+		// The descent can only end early without a leaf at a placeholder.
+		return false
+	}
+
+	for i, sideNode := range proof.SideNodes {
+		if prefix[depth-1-i] {
+			currentHash, _ = th.digestNode(sideNode, currentHash)
+		} else {
+			currentHash, _ = th.digestNode(currentHash, sideNode)
+		}
+	}
+	return bytes.Equal(currentHash, root)
+}
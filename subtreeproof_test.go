@@ -0,0 +1,93 @@
+package smt
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// checkSubtreeProof checks that the subtree root at prefix is proven under
+// the root of smt, and that the proof fails for another subtree root.
+func checkSubtreeProof(t *testing.T, smt *SparseMerkleTree, prefix []bool) {
+	subtreeRoot, _, _, _, err := smt.NodeAt(prefix)
+	if err != nil {
+		t.Fatalf("returned error when getting subtree root: %v", err)
+	}
+	proof, err := smt.ProveSubtree(prefix)
+	if err != nil {
+		t.Fatalf("returned error when proving subtree: %v", err)
+	}
+	if !VerifySubtreeProof(proof, smt.Root(), prefix, subtreeRoot, sha256.New()) {
+		t.Errorf("subtree proof for prefix %v failed to verify", prefix)
+	}
+	wrong := sha256.Sum256(subtreeRoot)
+	if VerifySubtreeProof(proof, smt.Root(), prefix, wrong[:], sha256.New()) {
+		t.Errorf("subtree proof for prefix %v verified for wrong subtree root", prefix)
+	}
+}
+
+func TestSubtreeProof(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	checkSubtreeProof(t, smt, []bool{true})
+
+	for i := 0; i < 200; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	// Nested prefixes along the paths of keys, down past their leaves.
+	for _, key := range []string{"testKey0", "testKey7", "testKey42"} {
+		path := smt.PathBits([]byte(key))
+		for n := 0; n <= 16; n++ {
+			checkSubtreeProof(t, smt, path[:n])
+		}
+	}
+	// Prefixes of empty subtrees, ending at a leaf of another key or at a
+	// placeholder.
+	for n := 1; n <= 16; n++ {
+		prefix := append([]bool(nil), smt.PathBits([]byte("testKey3"))[:n]...)
+		prefix[n-1] = !prefix[n-1]
+		checkSubtreeProof(t, smt, prefix)
+	}
+
+	// A proof holds only for its prefix.
+	prefix := smt.PathBits([]byte("testKey0"))[:4]
+	subtreeRoot, _, _, _, _ := smt.NodeAt(prefix)
+	proof, _ := smt.ProveSubtree(prefix)
+	other := append([]bool(nil), prefix...)
+	other[1] = !other[1]
+	if VerifySubtreeProof(proof, smt.Root(), other, subtreeRoot, sha256.New()) {
+		t.Error("subtree proof verified for another prefix")
+	}
+	if VerifySubtreeProof(proof, smt.Root(), prefix[:3], subtreeRoot, sha256.New()) {
+		t.Error("subtree proof verified for a shorter prefix")
+	}
+
+	if _, err := smt.ProveSubtree(make([]bool, smt.depth()+1)); !errors.Is(err, ErrPrefixTooLong) {
+		t.Errorf("did not return ErrPrefixTooLong, got %v", err)
+	}
+}
+
+func TestSubtreeProofLeaf(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	path := smt.PathBits([]byte("testKey"))
+
+	// The leaf stands for the subtrees of all the prefixes of its path.
+	proof, _ := smt.ProveSubtree(path[:8])
+	if proof.LeafData == nil {
+		t.Fatal("subtree proof below a leaf has no leaf data")
+	}
+	checkSubtreeProof(t, smt, path[:8])
+
+	// A forged leaf cannot stand for an empty subtree.
+	empty := append([]bool(nil), path[:8]...)
+	empty[7] = !empty[7]
+	leafHash, _, _, _, _ := smt.NodeAt(path[:8])
+	if VerifySubtreeProof(proof, smt.Root(), empty, leafHash, sha256.New()) {
+		t.Error("subtree proof verified the leaf for a prefix not on its path")
+	}
+	emptyProof, _ := smt.ProveSubtree(empty)
+	if !VerifySubtreeProof(emptyProof, smt.Root(), empty, smt.th.placeholder(), sha256.New()) {
+		t.Error("subtree proof of empty subtree failed to verify")
+	}
+}
@@ -0,0 +1,206 @@
+package smt
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"math"
+)
+
+// weightSize is the length of the weights, and sums of weights, that follow
+// the hashes of a sum tree.
+const weightSize = 8
+
+// ErrSumOverflow is returned when an update would take the sum of the weights
+// of a SparseMerkleSumTree to math.MaxUint64 or beyond.
+var ErrSumOverflow = errors.New("sum of weights overflows")
+
+// SparseMerkleSumTree is a SparseMerkleTree in which each node also commits to
+// the sum of the uint64 weights of the keys under it, as for proof-of-reserves
+// commitments: a proof that a key holds a value and weight also proves the
+// sums beside its path, which add up with the weight to the total committed
+// to by the root.
+//
+// The hashes of a sum tree, roots and side nodes included, are those of its
+// hasher followed by the sum of the weights under them, as 8 bytes big-endian;
+// RootSum reads the total from a root. The values of the underlying tree, as
+// seen through Tree, are the weight, likewise encoded, followed by the value.
+type SparseMerkleSumTree struct {
+	smt *SparseMerkleTree
+}
+
+// NewSparseMerkleSumTree creates a new SparseMerkleSumTree on empty stores.
+// The options are those of a SparseMerkleTree, except WithLazyCommit, whose
+// staged writes could not be checked for overflowing the sum.
+func NewSparseMerkleSumTree(nodes, values MapStore, hasher hash.Hash, options ...Option) *SparseMerkleSumTree {
+	return newSumTree(NewSparseMerkleTree(nodes, values, hasher, append([]Option{withSums()}, options...)...))
+}
+
+// ImportSparseMerkleSumTree imports a SparseMerkleSumTree from non-empty
+// stores, at root.
+func ImportSparseMerkleSumTree(nodes, values MapStore, hasher hash.Hash, root []byte, options ...Option) *SparseMerkleSumTree {
+	return newSumTree(ImportSparseMerkleTree(nodes, values, hasher, root, append([]Option{withSums()}, options...)...))
+}
+
+func newSumTree(smt *SparseMerkleTree) *SparseMerkleSumTree {
+	if smt.lazy != nil {
+		panic("smt: sum tree with lazy commit")
+	}
+	return &SparseMerkleSumTree{smt: smt}
+}
+
+// withSums makes the tree hash as a sum tree.
+func withSums() Option {
+	return func(smt *SparseMerkleTree) {
+		smt.th.setSums()
+	}
+}
+
+// Tree returns the underlying SparseMerkleTree, e.g. to register observers or
+// retain roots. Writing it directly skips the checks for overflowing the sum:
+// an overflowing sum saturates at math.MaxUint64, which VerifySumProof
+// rejects.
+func (smst *SparseMerkleSumTree) Tree() *SparseMerkleTree {
+	return smst.smt
+}
+
+// Root returns the root of the tree.
+func (smst *SparseMerkleSumTree) Root() []byte {
+	return smst.smt.Root()
+}
+
+// Sum returns the sum of the weights of the keys in the tree.
+func (smst *SparseMerkleSumTree) Sum() uint64 {
+	return RootSum(smst.Root())
+}
+
+// Get returns the value and weight of key, or no value and a weight of zero
+// if it is absent.
+func (smst *SparseMerkleSumTree) Get(key []byte) ([]byte, uint64, error) {
+	stored, err := smst.smt.Get(key)
+	if err != nil || len(stored) < weightSize {
+		return nil, 0, err
+	}
+	return stored[weightSize:], valueWeight(stored), nil
+}
+
+// Update sets value and weight for key, and sets and returns the new root of
+// the tree. ErrSumOverflow is returned if the sum of the weights would reach
+// math.MaxUint64.
+func (smst *SparseMerkleSumTree) Update(key []byte, value []byte, weight uint64) ([]byte, error) {
+	stored := sumValue(value, weight)
+	return smst.smt.changeRoot(func(root []byte) ([]byte, error) {
+		old, err := smst.smt.getValue(smst.smt.th.path(key))
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			return nil, err
+		}
+		total := RootSum(root) - valueWeight(old)
+		if weight >= math.MaxUint64-total {
+			return nil, fmt.Errorf("%w: adding %d to %d", ErrSumOverflow, weight, total)
+		}
+		return smst.smt.updateForRoot(key, stored, root)
+	})
+}
+
+// Delete deletes key, and sets and returns the new root of the tree.
+func (smst *SparseMerkleSumTree) Delete(key []byte) ([]byte, error) {
+	return smst.smt.Delete(key)
+}
+
+// Prove generates a proof of the value and weight of key, or of its absence.
+func (smst *SparseMerkleSumTree) Prove(key []byte) (SparseMerkleSumProof, error) {
+	proof, err := smst.smt.Prove(key)
+	return SparseMerkleSumProof{proof}, err
+}
+
+// SparseMerkleSumProof is a proof for a key of a SparseMerkleSumTree.
+type SparseMerkleSumProof struct {
+	SparseMerkleProof
+}
+
+// SideNodeSums returns the sums of the weights under the side nodes of the
+// proof, one per level from the leaf up. For a proof of membership, they add
+// up with the weight of the key to the sum under the root.
+func (proof SparseMerkleSumProof) SideNodeSums() []uint64 {
+	sums := make([]uint64, len(proof.SideNodes))
+	for i, node := range proof.SideNodes {
+		sums[i] = hashWeight(node)
+	}
+	return sums
+}
+
+// VerifySumProof verifies a proof that key holds value with weight in the
+// SparseMerkleSumTree at root, or, if value is nil, that key is absent from
+// it. The total the proof adds up to is that of the root; see RootSum.
+func VerifySumProof(proof SparseMerkleSumProof, root []byte, key []byte, value []byte, weight uint64, hasher hash.Hash, options ...VerifyOption) bool {
+	th := newVerifyConfig(options).treeHasher(hasher)
+	th.setSums()
+	if len(root) != th.hashSize() || RootSum(root) == math.MaxUint64 {
+		// The sums of the proof may have saturated.
+		return false
+	}
+	var valueHash []byte
+	if value != nil {
+		valueHash = th.digestValue(sumValue(value, weight))
+	}
+	result, _ := verifyProofForValueHash(proof.SparseMerkleProof, root, th.path(key), valueHash, th)
+	return result
+}
+
+// RootSum returns the sum of the weights under root, a root or other hash of
+// a SparseMerkleSumTree.
+func RootSum(root []byte) uint64 {
+	return hashWeight(root)
+}
+
+// setSums makes the hasher hash as that of a sum tree.
+func (th *treeHasher) setSums() {
+	th.sums = true
+	th.zeroValue = make([]byte, th.hashSize())
+}
+
+// nodeSum returns the sum of the weights under the node with data, or zero
+// for data that is not that of a node. Sums saturate at math.MaxUint64.
+func (th *treeHasher) nodeSum(data []byte) uint64 {
+	if !th.validNode(data) {
+		return 0
+	}
+	if th.isLeaf(data) {
+		_, valueHash := th.parseLeaf(data)
+		return hashWeight(valueHash)
+	}
+	left, right := th.parseNode(data)
+	sum := hashWeight(left) + hashWeight(right)
+	if sum < hashWeight(left) {
+		return math.MaxUint64
+	}
+	return sum
+}
+
+// sumValue returns the value stored for value with weight.
+func sumValue(value []byte, weight uint64) []byte {
+	return append(appendWeight(make([]byte, 0, weightSize+len(value)), weight), value...)
+}
+
+// valueWeight returns the weight of a stored value.
+func valueWeight(value []byte) uint64 {
+	if len(value) < weightSize {
+		return 0
+	}
+	return binary.BigEndian.Uint64(value)
+}
+
+// hashWeight returns the weight, or sum of weights, that a hash ends with.
+func hashWeight(hash []byte) uint64 {
+	if len(hash) < weightSize {
+		return 0
+	}
+	return binary.BigEndian.Uint64(hash[len(hash)-weightSize:])
+}
+
+func appendWeight(b []byte, weight uint64) []byte {
+	var buf [weightSize]byte
+	binary.BigEndian.PutUint64(buf[:], weight)
+	return append(b, buf[:]...)
+}
@@ -0,0 +1,101 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestSparseMerkleSumTree(t *testing.T) {
+	smst := NewSparseMerkleSumTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	if smst.Sum() != 0 || len(smst.Root()) != sha256.Size+weightSize {
+		t.Fatalf("empty tree has sum %d and a root of %d bytes", smst.Sum(), len(smst.Root()))
+	}
+	var total uint64
+	for i := 0; i < 20; i++ {
+		weight := uint64(i * 100)
+		if _, err := smst.Update([]byte(fmt.Sprintf("account%d", i)), []byte(fmt.Sprintf("holder%d", i)), weight); err != nil {
+			t.Fatalf("returned error when updating: %v", err)
+		}
+		total += weight
+	}
+	smst.Update([]byte("account3"), []byte("holder3"), 50)
+	smst.Delete([]byte("account4"))
+	total = total + 50 - 300 - 400
+	if smst.Sum() != total {
+		t.Errorf("tree has sum %d, want %d", smst.Sum(), total)
+	}
+	if value, weight, err := smst.Get([]byte("account3")); err != nil || !bytes.Equal(value, []byte("holder3")) || weight != 50 {
+		t.Errorf("got %q, %d, %v for account3", value, weight, err)
+	}
+	if value, weight, err := smst.Get([]byte("account4")); err != nil || value != nil || weight != 0 {
+		t.Errorf("got %q, %d, %v for a deleted key", value, weight, err)
+	}
+
+	root := smst.Root()
+	proof, err := smst.Prove([]byte("account7"))
+	if err != nil {
+		t.Fatalf("returned error when proving: %v", err)
+	}
+	if !VerifySumProof(proof, root, []byte("account7"), []byte("holder7"), 700, sha256.New()) {
+		t.Error("valid proof did not verify")
+	}
+	if VerifySumProof(proof, root, []byte("account7"), []byte("holder7"), 701, sha256.New()) {
+		t.Error("proof verified for another weight")
+	}
+	sum := uint64(700)
+	for _, s := range proof.SideNodeSums() {
+		sum += s
+	}
+	if sum != total {
+		t.Errorf("side node sums and weight add up to %d, want %d", sum, total)
+	}
+	absent, _ := smst.Prove([]byte("account4"))
+	if !VerifySumProof(absent, root, []byte("account4"), nil, 0, sha256.New()) {
+		t.Error("non-membership proof did not verify")
+	}
+
+	// The tree is the same however it is reached.
+	imported := ImportSparseMerkleSumTree(smst.smt.nodes, smst.smt.values, sha256.New(), root)
+	if value, weight, _ := imported.Get([]byte("account9")); !bytes.Equal(value, []byte("holder9")) || weight != 900 {
+		t.Errorf("got %q, %d from the imported tree", value, weight)
+	}
+	other := NewSparseMerkleSumTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 19; i >= 0; i-- {
+		if i != 4 {
+			weight := uint64(i * 100)
+			if i == 3 {
+				weight = 50
+			}
+			other.Update([]byte(fmt.Sprintf("account%d", i)), []byte(fmt.Sprintf("holder%d", i)), weight)
+		}
+	}
+	if !bytes.Equal(other.Root(), root) {
+		t.Error("tree updated in another order has another root")
+	}
+}
+
+func TestSparseMerkleSumTreeOverflow(t *testing.T) {
+	smst := NewSparseMerkleSumTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	smst.Update([]byte("account1"), nil, math.MaxUint64/2)
+	if _, err := smst.Update([]byte("account2"), nil, math.MaxUint64/2+1); !errors.Is(err, ErrSumOverflow) {
+		t.Errorf("got %v reaching the maximum sum, want ErrSumOverflow", err)
+	}
+	// The weight a key replaces does not count.
+	if _, err := smst.Update([]byte("account1"), nil, math.MaxUint64-1); err != nil {
+		t.Errorf("returned error replacing a weight: %v", err)
+	}
+
+	// Sums written around the checks saturate, and do not verify.
+	smst.Tree().Update([]byte("account2"), sumValue(nil, 10))
+	if smst.Sum() != math.MaxUint64 {
+		t.Errorf("overflowing sum is %d", smst.Sum())
+	}
+	proof, _ := smst.Prove([]byte("account2"))
+	if VerifySumProof(proof, smst.Root(), []byte("account2"), nil, 10, sha256.New()) {
+		t.Error("proof with a saturated sum verified")
+	}
+}
@@ -0,0 +1,100 @@
+package smt
+
+import (
+	"errors"
+)
+
+// SwapNodeStore copies the nodes of the tree into store and switches the tree
+// to it, e.g. to migrate a running tree to another backend. The nodes copied
+// are those under the current root and any retained roots; orphaned nodes
+// left in the old store are not. Updates are blocked during the copy. If it
+// fails, the tree keeps the old store, and store may hold part of the copy.
+// See SetOperationLimit.
+func (smt *SparseMerkleTree) SwapNodeStore(store MapStore, options ...TraversalOption) error {
+	if store == nil {
+		panic("smt: nil nodes store")
+	}
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+	if err := smt.checkOperationLimit(smt.root, options); err != nil {
+		return err
+	}
+	smt.debugf("copying nodes to new store")
+	copied := 0
+	err := smt.walkRetained(func(hash []byte, _ []byte) error {
+		// Copy the data as stored, in whichever encoding.
+		data, err := smt.nodes.Get(hash)
+		if err != nil {
+			return err
+		}
+		copied++
+		return store.Set(hash, data)
+	})
+	if err != nil {
+		smt.warnf("failed to copy nodes to new store, keeping old store: %v", err)
+		return err
+	}
+	smt.nodes = store
+	smt.detachSnapshots(nodeStore)
+	smt.debugf("switched to new node store after copying %d nodes", copied)
+	return nil
+}
+
+// SwapValueStore copies the values of the tree into store and switches the
+// tree to it, like SwapNodeStore. The values copied are those of the leaves
+// under the current root and any retained roots.
+func (smt *SparseMerkleTree) SwapValueStore(store MapStore, options ...TraversalOption) error {
+	if store == nil {
+		panic("smt: nil values store")
+	}
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+	if err := smt.checkOperationLimit(smt.root, options); err != nil {
+		return err
+	}
+	smt.debugf("copying values to new store")
+	copied := 0
+	err := smt.walkRetained(func(_ []byte, data []byte) error {
+		if !smt.th.isLeaf(data) {
+			return nil
+		}
+		path, _ := smt.th.parseLeaf(data)
+		// Copy the value as stored, in case it is encoded.
+		value, err := smt.values.Get(path)
+		if errors.Is(err, ErrKeyNotFound) {
+			// Set with UpdateLeafHash, or replaced since a retained root.
+			return nil
+		} else if err != nil {
+			return err
+		}
+		copied++
+		return store.Set(path, value)
+	})
+	if err != nil {
+		smt.warnf("failed to copy values to new store, keeping old store: %v", err)
+		return err
+	}
+	smt.values = store
+	smt.detachSnapshots(valueStore)
+	smt.debugf("switched to new value store after copying %d values", copied)
+	return nil
+}
+
+// walkRetained calls visit once for each node under the current root and any
+// retained roots.
+func (smt *SparseMerkleTree) walkRetained(visit func(hash []byte, data []byte) error) error {
+	seen := make(map[string]bool)
+	for _, root := range append(smt.retainedRoots(), smt.root) {
+		err := smt.walk(root, func(_ []bool, hash []byte, data []byte) error {
+			if seen[string(hash)] {
+				return nil
+			}
+			seen[string(hash)] = true
+			return visit(hash, data)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
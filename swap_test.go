@@ -0,0 +1,89 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// failingStore is a MapStore whose Set fails after a number of writes, or
+// never if the number is negative.
+type failingStore struct {
+	MapStore
+	sets int
+}
+
+var errStoreFull = errors.New("store full")
+
+func (s *failingStore) Set(key []byte, value []byte) error {
+	if s.sets == 0 {
+		return errStoreFull
+	}
+	s.sets--
+	return s.MapStore.Set(key, value)
+}
+
+func TestSwapStores(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New(), WithRootRetention(2))
+	for i := 0; i < 50; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	oldRoot := smt.Root()
+	smt.Update([]byte("testKey0"), []byte("newValue"))
+	smt.UpdateLeafHash([]byte("hashedKey"), make([]byte, sha256.Size))
+
+	newNodes, newValues := NewSimpleMap(), NewSimpleMap()
+	if err := smt.SwapNodeStore(newNodes); err != nil {
+		t.Fatalf("returned error when swapping node store: %v", err)
+	}
+	if err := smt.SwapValueStore(newValues); err != nil {
+		t.Fatalf("returned error when swapping value store: %v", err)
+	}
+	// Drop the old stores to catch any read from them.
+	smn.m, smv.m = nil, nil
+
+	for i := 0; i < 50; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		value, err := smt.Get(key)
+		expected := []byte(fmt.Sprintf("testValue%d", i))
+		if i == 0 {
+			expected = []byte("newValue")
+		}
+		if err != nil || !bytes.Equal(value, expected) {
+			t.Errorf("did not get value for key %s after swap", key)
+		}
+	}
+	_, oldValue, _, err := smt.KeyChangedSince(oldRoot, []byte("testKey0"))
+	if err != nil || !bytes.Equal(oldValue, []byte("testValue0")) {
+		t.Errorf("did not get value under retained root after swap, got %q, %v", oldValue, err)
+	}
+	smt.Update([]byte("testKey1"), []byte("newValue"))
+	proof, _ := smt.Prove([]byte("testKey1"))
+	if !VerifyProof(proof, smt.Root(), []byte("testKey1"), []byte("newValue"), sha256.New()) {
+		t.Error("proof failed to verify after swap")
+	}
+}
+
+func TestSwapStoresFailure(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+	for i := 0; i < 10; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+
+	if err := smt.SwapNodeStore(&failingStore{MapStore: NewSimpleMap(), sets: 5}); !errors.Is(err, errStoreFull) {
+		t.Errorf("did not return copy error when swapping node store, got %v", err)
+	}
+	if err := smt.SwapValueStore(&failingStore{MapStore: NewSimpleMap(), sets: 5}); !errors.Is(err, errStoreFull) {
+		t.Errorf("did not return copy error when swapping value store, got %v", err)
+	}
+	if smt.nodes != smn || smt.values != smv {
+		t.Error("switched store after failed copy")
+	}
+	if value, _ := smt.Get([]byte("testKey9")); !bytes.Equal(value, []byte("testValue9")) {
+		t.Error("did not get value after failed swap")
+	}
+}
@@ -0,0 +1,250 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ErrNotBranch is returned by SubtreeHashesAt when the node at the requested
+// prefix is a leaf or a placeholder rather than a branch.
+var ErrNotBranch = errors.New("node is not a branch")
+
+// ErrNotLeaf is returned by LeafAt when the node at the requested prefix is
+// not a leaf.
+var ErrNotLeaf = errors.New("node is not a leaf")
+
+// ErrSyncMismatch is returned by Syncer when data served by the source does
+// not hash to the expected node, e.g. because the source's root changed
+// during the sync.
+var ErrSyncMismatch = errors.New("sync source returned inconsistent data")
+
+// SyncSource serves the nodes of a tree to a Syncer. A SparseMerkleTree
+// implements SyncSource for its current root.
+type SyncSource interface {
+	// Root returns the root being synced to.
+	Root() []byte
+	// SubtreeHashesAt returns the hashes of the children of the branch at
+	// prefix, or ErrNotBranch if the node at prefix is not a branch.
+	SubtreeHashesAt(prefix []bool) (left []byte, right []byte, err error)
+	// LeafAt returns the path, value hash and value of the leaf at prefix,
+	// or ErrNotLeaf if the node at prefix is not a leaf. The value hash of a
+	// presence leaf is empty, and the value is nil for a leaf that has none,
+	// such as a presence leaf or one set with UpdateLeafHash.
+	LeafAt(prefix []bool) (path []byte, valueHash []byte, value []byte, err error)
+}
+
+// SubtreeHashesAt returns the hashes of the two children of the branch found
+// by following prefix from the current root, where true is right.
+func (smt *SparseMerkleTree) SubtreeHashesAt(prefix []bool) ([]byte, []byte, error) {
+	_, data, depth, err := smt.descendPrefix(smt.Root(), prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	if data == nil || depth != len(prefix) || smt.th.isLeaf(data) {
+		return nil, nil, ErrNotBranch
+	}
+	left, right := smt.th.parseNode(data)
+	return left, right, nil
+}
+
+// RootChildren returns the hashes of the two children of the current root,
+// reading only the root node. Both are placeholders if the tree is empty, and
+// ErrNotBranch is returned if the root is a leaf.
+func (smt *SparseMerkleTree) RootChildren() ([]byte, []byte, error) {
+	root := smt.Root()
+	if bytes.Equal(root, smt.th.placeholder()) {
+		return smt.th.placeholder(), smt.th.placeholder(), nil
+	}
+	data, err := smt.getNode(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	if smt.th.isLeaf(data) {
+		return nil, nil, ErrNotBranch
+	}
+	left, right := smt.th.parseNode(data)
+	return left, right, nil
+}
+
+// LeafAt returns the path, value hash and value of the leaf found by following
+// prefix from the current root, where true is right, with a nil value for a
+// presence leaf or one set with UpdateLeafHash, which have no stored value.
+func (smt *SparseMerkleTree) LeafAt(prefix []bool) ([]byte, []byte, []byte, error) {
+	_, data, _, err := smt.descendPrefix(smt.Root(), prefix)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if data == nil || !smt.th.isLeaf(data) {
+		return nil, nil, nil, ErrNotLeaf
+	}
+	path, valueHash := smt.th.parseLeaf(data)
+	if len(valueHash) == 0 {
+		return path, valueHash, nil, nil
+	}
+	value, err := smt.getValue(path)
+	if errors.Is(err, ErrKeyNotFound) {
+		return path, valueHash, nil, nil
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return path, valueHash, value, nil
+}
+
+// Syncer brings a local tree to the root of a SyncSource by comparing the two
+// trees top-down and transferring only the subtrees that differ.
+type Syncer struct {
+	tree   *SparseMerkleTree
+	source SyncSource
+	// view stages the writes of a sync until it completes.
+	view *SparseMerkleTree
+
+	// Paths of local leaves replaced during the sync, and of leaves fetched
+	// from the source.
+	stale, fetched map[string]struct{}
+}
+
+// NewSyncer creates a Syncer that updates tree from source.
+func NewSyncer(tree *SparseMerkleTree, source SyncSource) *Syncer {
+	return &Syncer{tree: tree, source: source}
+}
+
+// Sync fetches every node and value of the source's root that the local tree
+// does not have at the same position, verifying each against its parent's
+// hash, and sets the local tree's root to the source's root. The values,
+// and keys of local leaves that are not present in the source are deleted,
+// bumping their key versions, as a deletion does.
+//
+// The sync is a single change of the root, as the commit of a Tx is: updates
+// of the tree are blocked until it ends, its writes are staged and made to
+// the stores only once every node has been verified, and retained roots,
+// archive mode and the write-ahead log see it as they would an update. A sync
+// that fails, such as one whose source changes its root meanwhile, writes
+// nothing. It returns ErrSealed for a sealed tree. Replaced local nodes are left in the node store, like
+// those of any other earlier root.
+func (s *Syncer) Sync() ([]byte, error) {
+	s.stale = make(map[string]struct{})
+	s.fetched = make(map[string]struct{})
+
+	remoteRoot := s.source.Root()
+	return s.tree.changeRoot(func(root []byte) ([]byte, error) {
+		if s.tree.sealed {
+			return nil, ErrSealed
+		}
+		tx := s.tree.newTx()
+		tx.done = true
+		s.view = tx.view
+		defer func() { s.view = nil }()
+		if err := s.syncNode(nil, root, remoteRoot); err != nil {
+			return nil, err
+		}
+		for path := range s.stale {
+			if _, ok := s.fetched[path]; ok {
+				continue
+			}
+			if err := s.view.deleteValue([]byte(path)); err != nil {
+				return nil, err
+			}
+			if err := s.view.deleteKey([]byte(path)); err != nil {
+				return nil, err
+			}
+			if err := s.view.bumpKeyVersion([]byte(path)); err != nil {
+				return nil, err
+			}
+		}
+		return remoteRoot, tx.apply()
+	})
+}
+
+func (s *Syncer) syncNode(prefix []bool, localHash []byte, remoteHash []byte) error {
+	th := &s.tree.th
+	if bytes.Equal(localHash, remoteHash) {
+		return nil
+	}
+
+	// Work out the local children to compare against, marking any local leaf
+	// that is replaced as stale.
+	localLeft, localRight := th.placeholder(), th.placeholder()
+	if !bytes.Equal(localHash, th.placeholder()) {
+		localData, err := s.view.getNode(localHash)
+		if err != nil {
+			return err
+		}
+		if th.isLeaf(localData) {
+			path, _ := th.parseLeaf(localData)
+			s.stale[string(path)] = struct{}{}
+		} else {
+			localLeft, localRight = th.parseNode(localData)
+		}
+	}
+
+	if bytes.Equal(remoteHash, th.placeholder()) {
+		return s.markStale(localLeft, localRight)
+	}
+
+	remoteLeft, remoteRight, err := s.source.SubtreeHashesAt(prefix)
+	if errors.Is(err, ErrNotBranch) {
+		if err := s.markStale(localLeft, localRight); err != nil {
+			return err
+		}
+		return s.fetchLeaf(prefix, remoteHash)
+	}
+	if err != nil {
+		return err
+	}
+
+	hash, data := th.digestNode(remoteLeft, remoteRight)
+	if !bytes.Equal(hash, remoteHash) {
+		s.tree.warnf("sync source returned inconsistent branch at depth %d", len(prefix))
+		return fmt.Errorf("%w: branch at depth %d", ErrSyncMismatch, len(prefix))
+	}
+	if err := s.view.setNode(hash, data); err != nil {
+		return err
+	}
+
+	if err := s.syncNode(append(prefix, false), localLeft, remoteLeft); err != nil {
+		return err
+	}
+	return s.syncNode(append(prefix, true), localRight, remoteRight)
+}
+
+func (s *Syncer) fetchLeaf(prefix []bool, remoteHash []byte) error {
+	th := &s.tree.th
+	path, valueHash, value, err := s.source.LeafAt(prefix)
+	if err != nil {
+		return err
+	}
+	hash, data := th.digestLeaf(path, valueHash)
+	if !bytes.Equal(hash, remoteHash) || value != nil && !bytes.Equal(th.digestValue(value), valueHash) {
+		s.tree.warnf("sync source returned inconsistent leaf at depth %d", len(prefix))
+		return fmt.Errorf("%w: leaf at depth %d", ErrSyncMismatch, len(prefix))
+	}
+	if err := s.view.setNode(hash, data); err != nil {
+		return err
+	}
+	if value != nil {
+		if err := s.view.setValue(path, value); err != nil {
+			return err
+		}
+	}
+	s.fetched[string(path)] = struct{}{}
+	return nil
+}
+
+// markStale marks the leaves of the given local subtrees as stale.
+func (s *Syncer) markStale(roots ...[]byte) error {
+	for _, root := range roots {
+		err := s.view.walk(root, func(_ []bool, _ []byte, data []byte) error {
+			if s.tree.th.isLeaf(data) {
+				path, _ := s.tree.th.parseLeaf(data)
+				s.stale[string(path)] = struct{}{}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
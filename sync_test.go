@@ -0,0 +1,300 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// countingSource counts the requests made to a SyncSource.
+type countingSource struct {
+	SyncSource
+	branches, leaves int
+}
+
+func (s *countingSource) SubtreeHashesAt(prefix []bool) ([]byte, []byte, error) {
+	s.branches++
+	return s.SyncSource.SubtreeHashesAt(prefix)
+}
+
+func (s *countingSource) LeafAt(prefix []bool) ([]byte, []byte, []byte, error) {
+	s.leaves++
+	return s.SyncSource.LeafAt(prefix)
+}
+
+func TestSyncer(t *testing.T) {
+	server := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	client := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		server.Update(key, []byte("testValue"))
+		client.Update(key, []byte("testValue"))
+	}
+	// Diverge: the server changes some keys, deletes some and adds some; the
+	// client has some keys of its own.
+	for i := 0; i < 10; i++ {
+		server.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte("newValue"))
+		server.Delete([]byte(fmt.Sprintf("testKey%d", 10+i)))
+		server.Update([]byte(fmt.Sprintf("serverKey%d", i)), []byte("serverValue"))
+		client.Update([]byte(fmt.Sprintf("clientKey%d", i)), []byte("clientValue"))
+	}
+
+	source := &countingSource{SyncSource: server}
+	root, err := NewSyncer(client, source).Sync()
+	if err != nil {
+		t.Fatalf("returned error when syncing: %v", err)
+	}
+	if !bytes.Equal(root, server.Root()) || !bytes.Equal(client.Root(), server.Root()) {
+		t.Error("client root does not match server root after sync")
+	}
+	if source.leaves >= 100 {
+		t.Errorf("fetched %d leaves, expected only the differing ones", source.leaves)
+	}
+
+	check := func(key, expected []byte) {
+		checkSynced(t, client, server.Root(), key, expected)
+	}
+	for i := 0; i < 10; i++ {
+		check([]byte(fmt.Sprintf("testKey%d", i)), []byte("newValue"))
+		check([]byte(fmt.Sprintf("testKey%d", 10+i)), defaultValue)
+		check([]byte(fmt.Sprintf("testKey%d", 20+i)), []byte("testValue"))
+		check([]byte(fmt.Sprintf("serverKey%d", i)), []byte("serverValue"))
+		check([]byte(fmt.Sprintf("clientKey%d", i)), defaultValue)
+	}
+
+	// Syncing identical trees transfers nothing.
+	source = &countingSource{SyncSource: server}
+	if _, err := NewSyncer(client, source).Sync(); err != nil {
+		t.Errorf("returned error when syncing: %v", err)
+	}
+	if source.branches != 0 || source.leaves != 0 {
+		t.Error("transferred nodes when syncing identical trees")
+	}
+
+	// Syncing to an empty tree deletes everything.
+	empty := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	if _, err := NewSyncer(client, empty).Sync(); err != nil {
+		t.Errorf("returned error when syncing: %v", err)
+	}
+	checkSynced(t, client, empty.Root(), []byte("testKey50"), defaultValue)
+}
+
+func checkSynced(t *testing.T, client *SparseMerkleTree, root []byte, key []byte, expected []byte) {
+	value, err := client.Get(key)
+	if err != nil {
+		t.Errorf("returned error when getting synced key: %v", err)
+	}
+	if !bytes.Equal(expected, value) {
+		t.Errorf("did not get correct value for synced key %s", key)
+	}
+	proof, err := client.Prove(key)
+	if err != nil {
+		t.Errorf("returned error when proving synced key: %v", err)
+	}
+	if !VerifyProof(proof, root, key, expected, sha256.New()) {
+		t.Errorf("proof for synced key %s failed to verify", key)
+	}
+}
+
+// tamperedSource serves a wrong value for every leaf.
+type tamperedSource struct {
+	SyncSource
+}
+
+func (s tamperedSource) LeafAt(prefix []bool) ([]byte, []byte, []byte, error) {
+	path, valueHash, _, err := s.SyncSource.LeafAt(prefix)
+	return path, valueHash, []byte("tampered"), err
+}
+
+func TestSyncerPresence(t *testing.T) {
+	server := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 10; i++ {
+		server.UpdatePresence([]byte(fmt.Sprintf("presentKey%d", i)))
+		server.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte("testValue"))
+	}
+	client := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	if _, err := NewSyncer(client, server).Sync(); err != nil {
+		t.Fatalf("returned error when syncing presence leaves: %v", err)
+	}
+	if !bytes.Equal(client.Root(), server.Root()) {
+		t.Error("client root does not match server root after sync")
+	}
+	key := []byte("presentKey3")
+	proof, err := client.Prove(key)
+	if err != nil {
+		t.Errorf("returned error when proving synced key: %v", err)
+	}
+	if !VerifyPresenceProof(proof, server.Root(), key, sha256.New()) {
+		t.Error("presence proof for synced key failed to verify")
+	}
+}
+
+func TestSyncerLeafHash(t *testing.T) {
+	server := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	server.Update([]byte("testKey1"), []byte("testValue1"))
+	valueHash := sha256.Sum256([]byte("external"))
+	server.UpdateLeafHash([]byte("hashedKey"), valueHash[:])
+	client := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	if _, err := NewSyncer(client, server).Sync(); err != nil {
+		t.Fatalf("returned error when syncing a leaf with no value: %v", err)
+	}
+	if !bytes.Equal(client.Root(), server.Root()) {
+		t.Error("client root does not match server root after sync")
+	}
+	if _, err := client.values.Get(client.th.path([]byte("hashedKey"))); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("got %v reading the value of a leaf with none, want ErrKeyNotFound", err)
+	}
+	proof, _ := client.Prove([]byte("hashedKey"))
+	if !VerifyProofWithValueHash(proof, server.Root(), []byte("hashedKey"), valueHash[:], sha256.New()) {
+		t.Error("proof for synced leaf hash failed to verify")
+	}
+	checkSynced(t, client, server.Root(), []byte("testKey1"), []byte("testValue1"))
+}
+
+func TestSyncerTampered(t *testing.T) {
+	server := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	server.Update([]byte("testKey1"), []byte("testValue1"))
+	server.Update([]byte("testKey2"), []byte("testValue2"))
+	client := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+
+	_, err := NewSyncer(client, tamperedSource{server}).Sync()
+	if !errors.Is(err, ErrSyncMismatch) {
+		t.Errorf("did not return ErrSyncMismatch for tampered source, got %v", err)
+	}
+	if !bytes.Equal(client.Root(), client.th.placeholder()) {
+		t.Error("client root changed after failed sync")
+	}
+}
+
+func TestSyncerChangeRoot(t *testing.T) {
+	server := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	server.Update([]byte("testKey1"), []byte("testValue1"))
+	keys, versions := NewSimpleMap(), NewSimpleMap()
+	client := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithKeyStore(keys), WithKeyVersions(versions), WithArchive(NewSimpleMap()))
+	client.Update([]byte("clientKey"), []byte("clientValue"))
+	changes := 0
+	client.OnRootChange(func(oldRoot, newRoot []byte) { changes++ })
+
+	client.Seal()
+	if _, err := NewSyncer(client, server).Sync(); !errors.Is(err, ErrSealed) {
+		t.Errorf("got %v syncing a sealed tree, want ErrSealed", err)
+	}
+	if changes != 0 {
+		t.Error("sealed tree changed its root")
+	}
+	client.Unseal()
+
+	oldRoot := client.Root()
+	before, _ := client.KeyVersion([]byte("clientKey"))
+	if _, err := NewSyncer(client, server).Sync(); err != nil {
+		t.Fatalf("returned error when syncing: %v", err)
+	}
+	if changes != 1 {
+		t.Errorf("got %d root changes, want 1", changes)
+	}
+	path := client.th.path([]byte("clientKey"))
+	if _, err := keys.Get(path); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("got %v reading the key of a deleted leaf, want ErrKeyNotFound", err)
+	}
+	if after, _ := client.KeyVersion([]byte("clientKey")); after <= before {
+		t.Errorf("version of a deleted leaf went from %d to %d", before, after)
+	}
+	if value, err := client.GetForRoot([]byte("clientKey"), oldRoot); err != nil || string(value) != "clientValue" {
+		t.Errorf("got %q and error %v under the root synced from", value, err)
+	}
+}
+
+// changingSource updates its tree after serving a number of branches.
+type changingSource struct {
+	*SparseMerkleTree
+	branches int
+}
+
+func (s *changingSource) SubtreeHashesAt(prefix []bool) ([]byte, []byte, error) {
+	if s.branches--; s.branches == 0 {
+		s.Update([]byte("testKey0"), []byte("changedValue"))
+	}
+	return s.SparseMerkleTree.SubtreeHashesAt(prefix)
+}
+
+func TestSyncerSourceChanged(t *testing.T) {
+	server := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	nodes, values := NewSimpleMap(), NewSimpleMap()
+	client := NewSparseMerkleTree(nodes, values, sha256.New())
+	for i := 0; i < 20; i++ {
+		server.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("serverValue%d", i)))
+		client.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("clientValue%d", i)))
+	}
+	oldRoot := client.Root()
+	nodeCount, valueCount := storedNodes(t, nodes), storedNodes(t, values)
+
+	source := &changingSource{SparseMerkleTree: server, branches: 3}
+	if _, err := NewSyncer(client, source).Sync(); !errors.Is(err, ErrSyncMismatch) {
+		t.Fatalf("got %v syncing from a source whose root changed, want ErrSyncMismatch", err)
+	}
+	if !bytes.Equal(client.Root(), oldRoot) {
+		t.Error("failed sync changed the root")
+	}
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		if value, err := client.Get(key); err != nil || string(value) != fmt.Sprintf("clientValue%d", i) {
+			t.Errorf("got %q and error %v for %s after a failed sync", value, err, key)
+		}
+	}
+	if after := storedNodes(t, nodes); after != nodeCount {
+		t.Errorf("failed sync left %d stored nodes, want %d", after, nodeCount)
+	}
+	if after := storedNodes(t, values); after != valueCount {
+		t.Errorf("failed sync left %d stored values, want %d", after, valueCount)
+	}
+}
+
+// countingStore counts the reads made from a MapStore.
+type countingStore struct {
+	MapStore
+	gets int
+}
+
+func (s *countingStore) Get(key []byte) ([]byte, error) {
+	s.gets++
+	return s.MapStore.Get(key)
+}
+
+func TestRootChildren(t *testing.T) {
+	nodes := &countingStore{MapStore: NewSimpleMap()}
+	smt := NewSparseMerkleTree(nodes, NewSimpleMap(), sha256.New())
+
+	left, right, err := smt.RootChildren()
+	if err != nil {
+		t.Errorf("returned error for empty tree: %v", err)
+	}
+	if !bytes.Equal(left, smt.th.placeholder()) || !bytes.Equal(right, smt.th.placeholder()) {
+		t.Error("did not get placeholders for empty tree")
+	}
+
+	smt.Update([]byte("testKey1"), []byte("testValue1"))
+	if _, _, err := smt.RootChildren(); !errors.Is(err, ErrNotBranch) {
+		t.Errorf("did not return ErrNotBranch for leaf root, got %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte("testValue"))
+	}
+	nodes.gets = 0
+	left, right, err = smt.RootChildren()
+	if err != nil {
+		t.Errorf("returned error when getting root children: %v", err)
+	}
+	if nodes.gets != 1 {
+		t.Errorf("read %d nodes, expected 1", nodes.gets)
+	}
+	expectedLeft, expectedRight, _ := smt.SubtreeHashesAt(nil)
+	if !bytes.Equal(left, expectedLeft) || !bytes.Equal(right, expectedRight) {
+		t.Error("did not get correct root children")
+	}
+	if hash, _ := smt.th.digestNode(left, right); !bytes.Equal(hash, smt.Root()) {
+		t.Error("root children do not hash to the root")
+	}
+}
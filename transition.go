@@ -0,0 +1,77 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+// TransitionProof proves that setting a key to a value changed the root of a
+// tree from one root to another, as a party without the tree, such as the
+// verifier of a rollup fraud proof, checks with VerifyTransition.
+type TransitionProof struct {
+	// OldValue is the value of the key under the old root, or the default
+	// value if the key was absent.
+	OldValue []byte
+	// Proof is an updatable proof of OldValue against the old root.
+	Proof SparseMerkleProof
+}
+
+// UpdateWithProof sets a new value for a key in the tree, or deletes the key
+// if value is the default value, as Update does, and sets and returns the new
+// root of the tree along with a proof of the transition from the previous
+// root, in a single change of the root; see VerifyTransition. Keys set with
+// UpdateLeafHash or UpdatePresence, whose values are not in the tree, return
+// ErrValueMissing, as the proof cannot give their old value.
+func (smt *SparseMerkleTree) UpdateWithProof(key []byte, value []byte) ([]byte, TransitionProof, error) {
+	var proof TransitionProof
+	newRoot, err := smt.changeRoot(func(root []byte) ([]byte, error) {
+		path := smt.th.path(key)
+		sideNodes, pathNodes, leafData, siblingData, err := smt.sideNodesForRoot(path, root, true)
+		if err != nil {
+			return nil, err
+		}
+		proof.Proof = smt.proofWithSideNodes(path, sideNodes, pathNodes, leafData, siblingData)
+		if proof.OldValue, err = smt.transitionOldValue(key, path, leafData); err != nil {
+			return nil, err
+		}
+		return smt.updateForRoot(key, value, root)
+	})
+	if err != nil {
+		return nil, TransitionProof{}, err
+	}
+	return newRoot, proof, nil
+}
+
+// transitionOldValue returns the value of key, at path, whose proof ends at
+// the leaf with the given data, or the default value if it ends elsewhere.
+func (smt *SparseMerkleTree) transitionOldValue(key []byte, path []byte, leafData []byte) ([]byte, error) {
+	if leafData == nil {
+		return smt.emptyValue(), nil
+	}
+	leafPath, valueHash := smt.th.parseLeaf(leafData)
+	if !bytes.Equal(leafPath, path) {
+		return smt.emptyValue(), nil
+	}
+	if len(valueHash) == 0 {
+		return nil, fmt.Errorf("%w: %x is a presence leaf", ErrValueMissing, key)
+	}
+	value, err := smt.getValue(path)
+	if errors.Is(err, ErrKeyNotFound) || (err == nil && !bytes.Equal(smt.th.digestValue(value), valueHash)) {
+		return nil, fmt.Errorf("%w: %x", ErrValueMissing, key)
+	}
+	return value, err
+}
+
+// VerifyTransition verifies that setting key to value, or deleting key if
+// value is the default value, changes the tree at oldRoot to newRoot, using
+// only the proof, as returned by UpdateWithProof, and without access to the
+// tree: the proven branch of the key is replayed on a deep subtree of
+// oldRoot. For several updates at once, see VerifyBatchUpdate.
+func VerifyTransition(oldRoot []byte, newRoot []byte, key []byte, value []byte, proof TransitionProof, hasher hash.Hash, options ...VerifyOption) bool {
+	return VerifyBatchUpdate(oldRoot, newRoot, []BatchUpdate{{Key: key, Value: value}}, BatchUpdateProof{
+		OldValues: [][]byte{proof.OldValue},
+		Proofs:    []SparseMerkleProof{proof.Proof},
+	}, hasher, options...)
+}
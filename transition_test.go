@@ -0,0 +1,60 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestTransitionProofs(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 300; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", r.Intn(40)))
+		value := []byte(fmt.Sprintf("testValue%d", i))
+		if r.Intn(3) == 0 {
+			value = defaultValue
+		}
+		oldRoot := smt.Root()
+		newRoot, proof, err := smt.UpdateWithProof(key, value)
+		if err != nil {
+			t.Fatalf("returned error when updating with proof: %v", err)
+		}
+		if !bytes.Equal(newRoot, smt.Root()) {
+			t.Fatal("returned root is not the root of the tree")
+		}
+		if !VerifyTransition(oldRoot, newRoot, key, value, proof, sha256.New()) {
+			t.Fatalf("transition proof %d for %s failed to verify", i, key)
+		}
+		if VerifyTransition(oldRoot, newRoot, key, []byte("otherValue"), proof, sha256.New()) {
+			t.Error("transition proof verified for another value")
+		}
+		if bytes.Equal(oldRoot, newRoot) {
+			// Deleting an absent key changes nothing, whatever the key.
+			continue
+		}
+		if VerifyTransition(oldRoot, newRoot, []byte("otherKey"), value, proof, sha256.New()) {
+			t.Error("transition proof verified for another key")
+		}
+		if VerifyTransition(oldRoot, oldRoot, key, value, proof, sha256.New()) {
+			t.Error("transition proof verified for another new root")
+		}
+		forged := proof
+		forged.OldValue = []byte("forgedValue")
+		if VerifyTransition(oldRoot, newRoot, key, value, forged, sha256.New()) {
+			t.Error("transition proof verified with a forged old value")
+		}
+	}
+
+	hashOnly := NewSparseMerkleTree(NewSimpleMap(), NewNilValueStore(), sha256.New())
+	hashOnly.Update([]byte("foo"), []byte("bar"))
+	if _, _, err := hashOnly.UpdateWithProof([]byte("foo"), []byte("baz")); !errors.Is(err, ErrValueMissing) {
+		t.Errorf("got %v updating with proof a key whose value is not stored, want ErrValueMissing", err)
+	}
+	if value, _ := hashOnly.Get([]byte("foo")); len(value) != 0 {
+		t.Error("failed update with proof changed the tree")
+	}
+}
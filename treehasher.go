@@ -2,75 +2,266 @@ package smt
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"hash"
+	"sync"
 )
 
+// Leaves come in two formats. A value leaf, leafPrefix||path||valueHash,
+// commits to the key at path and its value. A presence leaf,
+// presenceLeafPrefix||path, commits to the key alone, its value, if any,
+// being kept off the tree; see UpdatePresence. Internal nodes are
+// nodePrefix||left||right. leafPrefix and nodePrefix are the defaults, which
+// WithDomainPrefixes replaces.
 var leafPrefix = []byte{0}
 var nodePrefix = []byte{1}
+var presenceLeafPrefix = []byte{3}
 
+// ErrDomainPrefixes is returned when importing data hashed with other domain
+// prefixes than those of the tree; see WithDomainPrefixes.
+var ErrDomainPrefixes = errors.New("data was hashed with different domain prefixes")
+
+// WithDomainPrefixes sets the bytes that leaf and internal node data are
+// prefixed with before hashing, in place of 0x00 and 0x01, e.g. to produce
+// roots and proofs for an external verifier that expects other prefixes.
+// Changing them changes every hash, so proofs from such a tree must be
+// verified with WithVerifyDomainPrefixes and the same prefixes. The prefixes
+// must differ, and may not be 0x02, which marks compact nodes in the node
+// store, nor 0x03, the prefix of presence leaves.
+func WithDomainPrefixes(leaf byte, node byte) Option {
+	checkDomainPrefixes(leaf, node)
+	return func(smt *SparseMerkleTree) {
+		smt.th.setDomainPrefixes(leaf, node)
+	}
+}
+
+// WithPathBits sets the length of the paths of keys to n bits, and so the
+// depth of the tree, in place of the number of bits of the hasher's digests,
+// e.g. 160 for keys that are Ethereum-style addresses, or fewer for test
+// fixtures. A path is the first n bits of the digest of its key, so fewer
+// bits shrink proofs and the nodes above leaves, at the cost of more likely
+// path collisions between keys; see ErrPathCollision. n must be a multiple of
+// 8, and no more than the bits of the digests. Proofs from such a tree must
+// be verified with WithVerifyDepth(n).
+func WithPathBits(n int) Option {
+	if n <= 0 || n%8 != 0 {
+		panic("smt: invalid path length")
+	}
+	return func(smt *SparseMerkleTree) {
+		if n > smt.th.size*8 {
+			panic("smt: path longer than the hashes")
+		}
+		if n < smt.th.size*8 {
+			smt.th.pathBytes = n / 8
+		}
+	}
+}
+
+// checkDomainPrefixes panics if leaf and node cannot be used as domain
+// prefixes.
+func checkDomainPrefixes(leaf byte, node byte) {
+	if leaf == node {
+		panic("smt: leaf and node prefixes are equal")
+	}
+	for _, reserved := range [][]byte{compactNodePrefix, presenceLeafPrefix} {
+		if leaf == reserved[0] || node == reserved[0] {
+			panic(fmt.Sprintf("smt: domain prefix %#x is reserved", reserved[0]))
+		}
+	}
+}
+
+// treeHasher hashes the nodes of a tree. It is safe for concurrent use: a
+// treeHasher created from a single hash.Hash serialises access to it, while
+// one created from a constructor takes a separate hash.Hash per use.
 type treeHasher struct {
 	hasher    hash.Hash
+	mu        *sync.Mutex
+	pool      *sync.Pool
+	size      int
 	zeroValue []byte
+	// pathBytes is the length of paths, if shorter than the hashes; see
+	// WithPathBits.
+	pathBytes int
+
+	leafPrefix, nodePrefix []byte
+	// depth is the depth proofs are checked against, if not the number of
+	// bits of a path.
+	depth int
+	// strict rejects proofs not in canonical form; see WithStrictVerify.
+	strict bool
+	// sums is set for the hasher of a SparseMerkleSumTree, whose hashes are
+	// followed by the sum of the weights under them.
+	sums bool
 }
 
 func newTreeHasher(hasher hash.Hash) *treeHasher {
-	th := treeHasher{hasher: hasher}
-	th.zeroValue = make([]byte, th.pathSize())
+	th := treeHasher{hasher: hasher, mu: new(sync.Mutex), size: hasher.Size()}
+	th.zeroValue = make([]byte, th.hashSize())
+	th.leafPrefix, th.nodePrefix = leafPrefix, nodePrefix
 
 	return &th
 }
 
-func (th *treeHasher) digest(data []byte) []byte {
-	th.hasher.Write(data)
-	sum := th.hasher.Sum(nil)
-	th.hasher.Reset()
+func newTreeHasherFunc(newHasher func() hash.Hash) *treeHasher {
+	th := treeHasher{
+		pool: &sync.Pool{New: func() interface{} { return newHasher() }},
+		size: newHasher().Size(),
+	}
+	th.zeroValue = make([]byte, th.hashSize())
+	th.leafPrefix, th.nodePrefix = leafPrefix, nodePrefix
+
+	return &th
+}
+
+func (th *treeHasher) sum(data []byte) []byte {
+	var hasher hash.Hash
+	if th.pool != nil {
+		hasher = th.pool.Get().(hash.Hash)
+		defer th.pool.Put(hasher)
+	} else {
+		th.mu.Lock()
+		defer th.mu.Unlock()
+		hasher = th.hasher
+	}
+
+	hasher.Write(data)
+	sum := hasher.Sum(nil)
+	hasher.Reset()
 	return sum
 }
 
+// digest returns the hash of node data, followed by the sum of the weights
+// under the node in a sum tree.
+func (th *treeHasher) digest(data []byte) []byte {
+	if !th.sums {
+		return th.sum(data)
+	}
+	return appendWeight(th.sum(data), th.nodeSum(data))
+}
+
+// digestValue returns the hash of a value, followed by its weight in a sum
+// tree.
+func (th *treeHasher) digestValue(value []byte) []byte {
+	if !th.sums {
+		return th.sum(value)
+	}
+	return appendWeight(th.sum(value), valueWeight(value))
+}
+
 func (th *treeHasher) path(key []byte) []byte {
-	return th.digest(key)
+	path := th.sum(key)
+	return path[:th.pathSize():th.pathSize()]
 }
 
+// digestLeaf returns the hash and data of the leaf at path for valueHash, or
+// of a presence leaf if valueHash is empty.
 func (th *treeHasher) digestLeaf(path []byte, leafData []byte) ([]byte, []byte) {
-	value := make([]byte, 0, len(leafPrefix)+len(path)+len(leafData))
-	value = append(value, leafPrefix...)
+	prefix := th.leafPrefix
+	if len(leafData) == 0 {
+		prefix = presenceLeafPrefix
+	}
+	value := make([]byte, 0, len(prefix)+len(path)+len(leafData))
+	value = append(value, prefix...)
 	value = append(value, path...)
 	value = append(value, leafData...)
 
-	th.hasher.Write(value)
-	sum := th.hasher.Sum(nil)
-	th.hasher.Reset()
-
-	return sum, value
+	return th.digest(value), value
 }
 
+// parseLeaf returns the path and value hash of leaf data, the value hash of a
+// presence leaf being empty.
 func (th *treeHasher) parseLeaf(data []byte) ([]byte, []byte) {
-	return data[len(leafPrefix) : th.pathSize()+len(leafPrefix)], data[len(leafPrefix)+th.pathSize():]
+	return data[len(th.leafPrefix) : th.pathSize()+len(th.leafPrefix)], data[len(th.leafPrefix)+th.pathSize():]
 }
 
 func (th *treeHasher) isLeaf(data []byte) bool {
-	return bytes.Equal(data[:len(leafPrefix)], leafPrefix)
+	return bytes.Equal(data[:len(th.leafPrefix)], th.leafPrefix) || bytes.Equal(data[:len(presenceLeafPrefix)], presenceLeafPrefix)
+}
+
+// validNode returns true if data has the length of a node of its format.
+func (th *treeHasher) validNode(data []byte) bool {
+	switch {
+	case len(data) == 0:
+		return false
+	case bytes.Equal(data[:len(presenceLeafPrefix)], presenceLeafPrefix):
+		return len(data) == len(presenceLeafPrefix)+th.pathSize()
+	case bytes.Equal(data[:len(th.leafPrefix)], th.leafPrefix):
+		return len(data) == len(th.leafPrefix)+th.pathSize()+th.hashSize()
+	}
+	return len(data) == len(th.nodePrefix)+2*th.hashSize() && bytes.Equal(data[:len(th.nodePrefix)], th.nodePrefix)
 }
 
 func (th *treeHasher) digestNode(leftData []byte, rightData []byte) ([]byte, []byte) {
-	value := make([]byte, 0, len(nodePrefix)+len(leftData)+len(rightData))
-	value = append(value, nodePrefix...)
+	value := make([]byte, 0, len(th.nodePrefix)+len(leftData)+len(rightData))
+	value = append(value, th.nodePrefix...)
 	value = append(value, leftData...)
 	value = append(value, rightData...)
 
-	th.hasher.Write(value)
-	sum := th.hasher.Sum(nil)
-	th.hasher.Reset()
-
-	return sum, value
+	return th.digest(value), value
 }
 
 func (th *treeHasher) parseNode(data []byte) ([]byte, []byte) {
-	return data[len(nodePrefix) : th.pathSize()+len(nodePrefix)], data[len(nodePrefix)+th.pathSize():]
+	return data[len(th.nodePrefix) : th.hashSize()+len(th.nodePrefix)], data[len(th.nodePrefix)+th.hashSize():]
+}
+
+// setDomainPrefixes sets the prefixes of leaf and internal node data.
+func (th *treeHasher) setDomainPrefixes(leaf byte, node byte) {
+	th.leafPrefix, th.nodePrefix = []byte{leaf}, []byte{node}
+}
+
+// domainPrefixes returns the prefixes of leaf and internal node data.
+func (th *treeHasher) domainPrefixes() []byte {
+	return []byte{th.leafPrefix[0], th.nodePrefix[0]}
+}
+
+// setDepth sets the depth proofs are checked against, which also sets the
+// length of paths if it is less than the number of bits of the hashes.
+func (th *treeHasher) setDepth(depth int) {
+	th.depth = depth
+	if depth != 0 && depth < th.size*8 {
+		th.pathBytes = (depth + 7) / 8
+	}
+}
+
+// treeDepth returns the depth of the tree proofs are checked against.
+func (th *treeHasher) treeDepth() int {
+	if th.depth != 0 {
+		return th.depth
+	}
+	return th.pathSize() * 8
+}
+
+// maxSideNodes returns the number of side nodes a proof may have, which is
+// also bounded by the number of bits of a path.
+func (th *treeHasher) maxSideNodes() int {
+	if depth := th.treeDepth(); depth < th.pathSize()*8 {
+		return depth
+	}
+	return th.pathSize() * 8
 }
 
+// checkDepth returns true if a proof recording depth, or no depth if it is
+// zero, may be from a tree of the expected depth.
+func (th *treeHasher) checkDepth(depth int) bool {
+	return depth == 0 || depth == th.treeDepth()
+}
+
+// pathSize returns the length of paths, which is that of the hashes unless
+// set with WithPathBits.
 func (th *treeHasher) pathSize() int {
-	return th.hasher.Size()
+	if th.pathBytes != 0 {
+		return th.pathBytes
+	}
+	return th.size
+}
+
+// hashSize returns the length of the hashes of nodes and values.
+func (th *treeHasher) hashSize() int {
+	if th.sums {
+		return th.size + weightSize
+	}
+	return th.size
 }
 
 func (th *treeHasher) placeholder() []byte {
@@ -0,0 +1,163 @@
+package smt
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// TTLCacheStats counts the Get calls served by a TTLCachingStore.
+type TTLCacheStats struct {
+	Hits   uint64 // Gets served from the cache.
+	Misses uint64 // Gets read from the inner store, including expired ones.
+	// Expiries is the number of cached entries found expired by a Get, and so
+	// read again from the inner store.
+	Expiries uint64
+}
+
+// TTLCachingStore is a MapStore that caches the values read from an inner
+// store, such as a remote node store, for a fixed time to live. Gets are
+// served from the cache until an entry expires, after which it is read from
+// the inner store again, so that writes made to a shared store by other
+// processes are eventually seen. Sets are written through to the inner store
+// and cached, and Deletes invalidate the cached entry. When the cache is full,
+// the least recently used entry is evicted. Missing keys are not cached.
+type TTLCachingStore struct {
+	store   MapStore
+	ttl     time.Duration
+	maxSize int
+	now     func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // Of *ttlEntry, most recently used first.
+	stats   TTLCacheStats
+}
+
+type ttlEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewTTLCachingStore creates a TTLCachingStore over store, caching up to
+// maxSize values for ttl each.
+func NewTTLCachingStore(store MapStore, ttl time.Duration, maxSize int) *TTLCachingStore {
+	if store == nil {
+		panic("smt: nil store")
+	}
+	if ttl <= 0 {
+		panic("smt: non-positive cache TTL")
+	}
+	if maxSize <= 0 {
+		panic("smt: non-positive cache size")
+	}
+	return &TTLCachingStore{
+		store:   store,
+		ttl:     ttl,
+		maxSize: maxSize,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Get gets the value for a key, from the cache if it holds an entry for the
+// key that has not expired.
+func (cs *TTLCachingStore) Get(key []byte) ([]byte, error) {
+	cs.mu.Lock()
+	if elem, ok := cs.entries[string(key)]; ok {
+		entry := elem.Value.(*ttlEntry)
+		if cs.now().Before(entry.expires) {
+			cs.order.MoveToFront(elem)
+			cs.stats.Hits++
+			cs.mu.Unlock()
+			return entry.value, nil
+		}
+		cs.remove(elem)
+		cs.stats.Expiries++
+	}
+	cs.stats.Misses++
+	cs.mu.Unlock()
+
+	value, err := cs.store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.add(key, value)
+	return value, nil
+}
+
+// Set updates the value for a key in the inner store, then caches it.
+func (cs *TTLCachingStore) Set(key []byte, value []byte) error {
+	if err := cs.store.Set(key, value); err != nil {
+		cs.Invalidate(key)
+		return err
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.add(key, value)
+	return nil
+}
+
+// Delete deletes a key from the inner store and invalidates its cached value.
+func (cs *TTLCachingStore) Delete(key []byte) error {
+	cs.Invalidate(key)
+	return cs.store.Delete(key)
+}
+
+// Export exports the inner store.
+func (cs *TTLCachingStore) Export() ([]byte, error) {
+	return cs.store.Export()
+}
+
+// Flush flushes the inner store, if it is a ClosableStore.
+func (cs *TTLCachingStore) Flush() error {
+	return flushStore(cs.store)
+}
+
+// Close closes the inner store, if it is a ClosableStore.
+func (cs *TTLCachingStore) Close() error {
+	return closeStore(cs.store)
+}
+
+// Invalidate drops the cached value of key, if any, so that the next Get
+// reads it from the inner store, for a process that learns of a write by
+// another one before the entry expires.
+func (cs *TTLCachingStore) Invalidate(key []byte) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if elem, ok := cs.entries[string(key)]; ok {
+		cs.remove(elem)
+	}
+}
+
+// Stats returns the hit, miss and expiry counts of the cache so far.
+func (cs *TTLCachingStore) Stats() TTLCacheStats {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.stats
+}
+
+// add caches value for key, evicting the least recently used entry if the
+// cache is full. It must be called with the lock held.
+func (cs *TTLCachingStore) add(key []byte, value []byte) {
+	entry := &ttlEntry{key: string(key), value: value, expires: cs.now().Add(cs.ttl)}
+	if elem, ok := cs.entries[entry.key]; ok {
+		elem.Value = entry
+		cs.order.MoveToFront(elem)
+		return
+	}
+	if cs.order.Len() >= cs.maxSize {
+		cs.remove(cs.order.Back())
+	}
+	cs.entries[entry.key] = cs.order.PushFront(entry)
+}
+
+// remove drops elem from the cache. It must be called with the lock held.
+func (cs *TTLCachingStore) remove(elem *list.Element) {
+	cs.order.Remove(elem)
+	delete(cs.entries, elem.Value.(*ttlEntry).key)
+}
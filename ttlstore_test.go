@@ -0,0 +1,53 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTTLCachingStore(t *testing.T) {
+	inner := NewSimpleMap()
+	cs := NewTTLCachingStore(inner, time.Minute, 2)
+	clock := time.Unix(1700000000, 0)
+	cs.now = func() time.Time { return clock }
+
+	inner.Set([]byte("key1"), []byte("value1"))
+	cs.Get([]byte("key1"))
+	// Another writer changes the shared store; the cached value is served
+	// until it expires.
+	inner.Set([]byte("key1"), []byte("changed1"))
+	if value, _ := cs.Get([]byte("key1")); !bytes.Equal(value, []byte("value1")) {
+		t.Errorf("did not serve cached value before expiry, got %s", value)
+	}
+	clock = clock.Add(time.Minute)
+	if value, _ := cs.Get([]byte("key1")); !bytes.Equal(value, []byte("changed1")) {
+		t.Errorf("did not read inner store after expiry, got %s", value)
+	}
+	if stats := cs.Stats(); stats != (TTLCacheStats{Hits: 1, Misses: 2, Expiries: 1}) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	cs.Set([]byte("key2"), []byte("value2"))
+	if value, _ := inner.Get([]byte("key2")); !bytes.Equal(value, []byte("value2")) {
+		t.Error("Set did not write through to inner store")
+	}
+	cs.Get([]byte("key1"))
+	cs.Set([]byte("key3"), []byte("value3"))
+	inner.Set([]byte("key2"), []byte("changed2"))
+	if value, _ := cs.Get([]byte("key2")); !bytes.Equal(value, []byte("changed2")) {
+		t.Errorf("least recently used entry not evicted, got %s", value)
+	}
+
+	cs.Delete([]byte("key3"))
+	var invalidKeyError *InvalidKeyError
+	if _, err := cs.Get([]byte("key3")); !errors.As(err, &invalidKeyError) {
+		t.Errorf("Delete did not invalidate cached value, got %v", err)
+	}
+	inner.Set([]byte("key1"), []byte("rewritten1"))
+	cs.Invalidate([]byte("key1"))
+	if value, _ := cs.Get([]byte("key1")); !bytes.Equal(value, []byte("rewritten1")) {
+		t.Errorf("Invalidate did not drop cached value, got %s", value)
+	}
+}
@@ -0,0 +1,261 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ErrTxConflict is returned by Commit when the root of the tree changed since
+// the transaction began.
+var ErrTxConflict = errors.New("tree changed since the transaction began")
+
+// ErrTxDone is returned by the methods of a transaction that was already
+// committed or rolled back.
+var ErrTxDone = errors.New("transaction is already committed or rolled back")
+
+// Tx is a transaction on a tree, begun with BeginTx: a view of the tree at
+// the root it began at, whose updates are staged in memory until Commit
+// writes them to the tree's stores, or Rollback discards them, so that a
+// batch of updates that fails partway, such as a block whose application
+// fails, leaves the stores as they were.
+//
+// A Tx is not safe for concurrent use. It reads the unchanged parts of the
+// tree from its stores, so the tree should not be updated while it is open:
+// an update made meanwhile makes Commit fail with ErrTxConflict, and may
+// prune nodes the transaction still reads.
+type Tx struct {
+	tree     *SparseMerkleTree
+	view     *SparseMerkleTree
+	baseRoot []byte
+	overlays []*walStore
+	done     bool
+	// events are the changes of keys staged for the observers of the tree.
+	events []treeEvent
+}
+
+// Indexes of the overlays of a transaction.
+const (
+	txNodes = iota
+	txValues
+	txKeys
+	txVersions
+)
+
+// BeginTx begins a transaction at the current root of the tree.
+func (smt *SparseMerkleTree) BeginTx() *Tx {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	return smt.newTx()
+}
+
+// newTx returns a transaction at the current root of the tree. The caller
+// must hold mu.
+func (smt *SparseMerkleTree) newTx() *Tx {
+	tx := &Tx{tree: smt, baseRoot: smt.root, overlays: make([]*walStore, txVersions+1)}
+	// The overlays are not logged, so their store indexes are not used.
+	overlay := func(index int, store MapStore) MapStore {
+		if store == nil {
+			return nil
+		}
+		tx.overlays[index] = newWALStore(store, index)
+		return tx.overlays[index]
+	}
+	tx.view = smt.configuredCopy(overlay(txNodes, smt.nodes), overlay(txValues, smt.values), overlay(txKeys, smt.keys), overlay(txVersions, smt.versions))
+	if len(smt.observers) > 0 {
+		// The view records the changes of keys as a tree does for its
+		// observers, and Commit hands them to those of the tree.
+		tx.view.observers = []*observer{{ObserverFuncs{
+			Update: func(key, oldValue, newValue, _ []byte) {
+				tx.events = append(tx.events, treeEvent{key: key, oldValue: oldValue, newValue: newValue})
+			},
+			Delete: func(key, oldValue, _ []byte) {
+				tx.events = append(tx.events, treeEvent{key: key, oldValue: oldValue, deleted: true})
+			},
+		}}}
+	}
+	return tx
+}
+
+// configuredCopy returns a tree configured like the tree, at its root, on the
+// given stores. The caller must hold mu.
+func (smt *SparseMerkleTree) configuredCopy(nodes, values, keys, versions MapStore) *SparseMerkleTree {
+	return &SparseMerkleTree{
+		th:                 smt.th,
+		nodes:              nodes,
+		values:             values,
+		keys:               keys,
+		versions:           versions,
+		root:               smt.root,
+		compactNodes:       smt.compactNodes,
+		encodeValue:        smt.encodeValue,
+		decodeValue:        smt.decodeValue,
+		logger:             smt.logger,
+		defaultLeafValue:   smt.defaultLeafValue,
+		storeDefaultValue:  smt.storeDefaultValue,
+		checkMissingValues: smt.checkMissingValues,
+		valueSize:          smt.valueSize,
+		sealed:             smt.sealed,
+	}
+}
+
+// Root returns the root of the tree with the updates of the transaction so
+// far.
+func (tx *Tx) Root() []byte {
+	return tx.view.Root()
+}
+
+// Get gets the value of a key, as updated in the transaction.
+func (tx *Tx) Get(key []byte) ([]byte, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	return tx.view.Get(key)
+}
+
+// Has returns true if the tree, as updated in the transaction, has a leaf for
+// key.
+func (tx *Tx) Has(key []byte) (bool, error) {
+	if tx.done {
+		return false, ErrTxDone
+	}
+	return tx.view.Has(key)
+}
+
+// Prove generates a Merkle proof for a key against the root of the
+// transaction.
+func (tx *Tx) Prove(key []byte) (SparseMerkleProof, error) {
+	if tx.done {
+		return SparseMerkleProof{}, ErrTxDone
+	}
+	return tx.view.Prove(key)
+}
+
+// Update stages setting a new value for a key, and returns the new root of
+// the transaction.
+func (tx *Tx) Update(key []byte, value []byte) ([]byte, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	return tx.view.Update(key, value)
+}
+
+// Delete stages deleting a key, and returns the new root of the transaction.
+func (tx *Tx) Delete(key []byte) ([]byte, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	return tx.view.Delete(key)
+}
+
+// UpdateBatch stages the writes of a batch, as by the UpdateBatch of a tree,
+// and returns the new root of the transaction.
+func (tx *Tx) UpdateBatch(keys [][]byte, values [][]byte) ([]byte, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	return tx.view.UpdateBatch(keys, values)
+}
+
+// Commit writes the updates of the transaction to the stores of the tree and
+// sets the root of the tree to the root of the transaction, returning it, as
+// a single change of the root: OnRootChange callbacks are notified once,
+// observers are notified of each key the transaction changed, and a tree
+// with a write-ahead log logs the writes in one record. It returns
+// ErrTxConflict, and writes nothing, if the root of the tree changed since the
+// transaction began. The transaction is done afterwards either way.
+func (tx *Tx) Commit() ([]byte, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	tx.done = true
+	return tx.tree.changeRoot(func(root []byte) ([]byte, error) {
+		if !bytes.Equal(root, tx.baseRoot) {
+			return nil, rootMismatch("%w: root %x, began at %x", ErrTxConflict, root, tx.baseRoot)
+		}
+		if tx.tree.sealed {
+			return nil, ErrSealed
+		}
+		if err := tx.apply(); err != nil {
+			return nil, err
+		}
+		for _, event := range tx.events {
+			tx.tree.recordEvent(event)
+		}
+		return tx.view.root, nil
+	})
+}
+
+// apply makes the writes staged in the overlays on the tree, through the
+// methods an update uses, so that the node cache, retained roots and
+// snapshots of the tree see them as they would the update's.
+func (tx *Tx) apply() error {
+	smt := tx.tree
+	if overlay := tx.overlays[txNodes]; overlay != nil {
+		for _, w := range overlay.order {
+			if w.Delete {
+				// Nodes both written and orphaned in the transaction are not
+				// in the store.
+				_, err := smt.nodes.Get(w.Key)
+				if errors.Is(err, ErrKeyNotFound) {
+					continue
+				} else if err != nil {
+					return err
+				}
+				if err := smt.pruneNode(w.Key); err != nil {
+					return err
+				}
+			} else if err := smt.setNode(w.Key, smt.th.decodeNode(w.Value)); err != nil {
+				return err
+			}
+		}
+	}
+	if overlay := tx.overlays[txValues]; overlay != nil {
+		for _, w := range overlay.order {
+			if w.Delete {
+				if err := smt.deleteValue(w.Key); err != nil {
+					return err
+				}
+				continue
+			}
+			value := w.Value
+			if smt.decodeValue != nil {
+				var err error
+				if value, err = smt.decodeValue(value); err != nil {
+					return err
+				}
+			}
+			if err := smt.setValue(w.Key, value); err != nil {
+				return err
+			}
+		}
+	}
+	if overlay := tx.overlays[txKeys]; overlay != nil {
+		for _, w := range overlay.order {
+			if w.Delete {
+				if err := smt.deleteKey(w.Key); err != nil {
+					return err
+				}
+			} else if err := smt.setKey(w.Key, w.Value); err != nil {
+				return err
+			}
+		}
+	}
+	if overlay := tx.overlays[txVersions]; overlay != nil {
+		for _, w := range overlay.order {
+			if err := smt.versions.Set(w.Key, w.Value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Rollback discards the updates of the transaction, leaving the tree as it
+// was. Rolling back a transaction that is already done does nothing, so it
+// can be deferred.
+func (tx *Tx) Rollback() {
+	tx.done = true
+	for i := range tx.overlays {
+		tx.overlays[i] = nil
+	}
+}
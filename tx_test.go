@@ -0,0 +1,116 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestTx(t *testing.T) {
+	newTree := func() (*SparseMerkleTree, *SimpleMap, *SimpleMap) {
+		nodes, values := NewSimpleMap(), NewSimpleMap()
+		smt := NewSparseMerkleTree(nodes, values, sha256.New(), WithCompactNodes(), WithKeyStore(NewSimpleMap()), WithKeyVersions(NewSimpleMap()))
+		for i := 0; i < 50; i++ {
+			smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+		}
+		return smt, nodes, values
+	}
+	smt, nodes, values := newTree()
+	reference, refNodes, refValues := newTree()
+	root := smt.Root()
+	nodesBefore := mustExport(t, nodes)
+
+	tx := smt.BeginTx()
+	for i := 0; i < 50; i += 3 {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		var err error
+		if i%2 == 0 {
+			_, err = tx.Delete(key)
+			reference.Delete(key)
+		} else {
+			_, err = tx.Update(key, []byte("newValue"))
+			reference.Update(key, []byte("newValue"))
+		}
+		if err != nil {
+			t.Fatalf("returned error when updating in transaction: %v", err)
+		}
+	}
+	// Keys written twice in the transaction.
+	tx.Update([]byte("testKey100"), []byte("value1"))
+	tx.Update([]byte("testKey100"), []byte("value2"))
+	tx.Update([]byte("testKey101"), []byte("value1"))
+	tx.Delete([]byte("testKey101"))
+	reference.Update([]byte("testKey100"), []byte("value2"))
+
+	if value, err := tx.Get([]byte("testKey3")); err != nil || !bytes.Equal(value, []byte("newValue")) {
+		t.Errorf("did not get updated value in transaction, got %s, %v", value, err)
+	}
+	if value, _ := smt.Get([]byte("testKey3")); !bytes.Equal(value, []byte("testValue3")) {
+		t.Errorf("tree sees uncommitted value %s", value)
+	}
+	if !bytes.Equal(smt.Root(), root) || !bytes.Equal(mustExport(t, nodes), nodesBefore) {
+		t.Error("uncommitted transaction changed the tree")
+	}
+	if !bytes.Equal(tx.Root(), reference.Root()) {
+		t.Error("transaction has a different root")
+	}
+
+	newRoot, err := tx.Commit()
+	if err != nil {
+		t.Fatalf("returned error when committing: %v", err)
+	}
+	if !bytes.Equal(newRoot, reference.Root()) || !bytes.Equal(smt.Root(), newRoot) {
+		t.Error("commit gave a different root")
+	}
+	if !bytes.Equal(mustExport(t, nodes), mustExport(t, refNodes)) || !bytes.Equal(mustExport(t, values), mustExport(t, refValues)) {
+		t.Error("commit left different stores than updating the tree")
+	}
+	if version, _ := smt.KeyVersion([]byte("testKey3")); version == 0 {
+		t.Error("commit did not record key version")
+	}
+	if _, err := tx.Update([]byte("testKey1"), []byte("value")); !errors.Is(err, ErrTxDone) {
+		t.Errorf("did not return ErrTxDone, got %v", err)
+	}
+	if _, err := tx.Commit(); !errors.Is(err, ErrTxDone) {
+		t.Errorf("did not return ErrTxDone, got %v", err)
+	}
+}
+
+func TestTxRollback(t *testing.T) {
+	nodes, values := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(nodes, values, sha256.New())
+	smt.Update([]byte("testKey1"), []byte("testValue1"))
+	root := smt.Root()
+	nodesBefore, valuesBefore := mustExport(t, nodes), mustExport(t, values)
+
+	tx := smt.BeginTx()
+	defer tx.Rollback()
+	tx.Update([]byte("testKey2"), []byte("testValue2"))
+	tx.Delete([]byte("testKey1"))
+	tx.Rollback()
+	if !bytes.Equal(smt.Root(), root) || !bytes.Equal(mustExport(t, nodes), nodesBefore) || !bytes.Equal(mustExport(t, values), valuesBefore) {
+		t.Error("rolled back transaction changed the tree")
+	}
+	if _, err := tx.Get([]byte("testKey2")); !errors.Is(err, ErrTxDone) {
+		t.Errorf("did not return ErrTxDone, got %v", err)
+	}
+
+	// A transaction conflicting with an update is not committed.
+	tx = smt.BeginTx()
+	tx.Update([]byte("testKey2"), []byte("testValue2"))
+	smt.Update([]byte("testKey3"), []byte("testValue3"))
+	root = smt.Root()
+	if _, err := tx.Commit(); !errors.Is(err, ErrTxConflict) {
+		t.Errorf("did not return ErrTxConflict, got %v", err)
+	} else if !errors.Is(err, ErrRootMismatch) {
+		t.Errorf("ErrTxConflict is not an ErrRootMismatch: %v", err)
+	}
+	if !bytes.Equal(smt.Root(), root) {
+		t.Error("conflicting commit changed the root")
+	}
+	if value, _ := smt.Get([]byte("testKey2")); len(value) != 0 {
+		t.Error("conflicting commit wrote a value")
+	}
+}
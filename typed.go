@@ -0,0 +1,249 @@
+package smt
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/gob"
+	"hash"
+)
+
+// Codec converts the values of a Tree to and from the bytes stored in, and
+// proven by, the underlying SparseMerkleTree.
+type Codec[V any] struct {
+	Encode func(value V) ([]byte, error)
+	Decode func(data []byte) (V, error)
+}
+
+// BinaryCodec returns a Codec for values whose pointers implement
+// encoding.BinaryMarshaler and encoding.BinaryUnmarshaler.
+func BinaryCodec[V any, PV interface {
+	*V
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+}]() Codec[V] {
+	return Codec[V]{
+		Encode: func(value V) ([]byte, error) {
+			return PV(&value).MarshalBinary()
+		},
+		Decode: func(data []byte) (V, error) {
+			var value V
+			err := PV(&value).UnmarshalBinary(data)
+			return value, err
+		},
+	}
+}
+
+// GobCodec returns a Codec encoding values with encoding/gob. The encodings,
+// and so the roots of trees holding them, are only deterministic for types
+// without maps; each value is encoded with its own type definitions.
+func GobCodec[V any]() Codec[V] {
+	return Codec[V]{
+		Encode: func(value V) ([]byte, error) {
+			var buf bytes.Buffer
+			err := gob.NewEncoder(&buf).Encode(value)
+			return buf.Bytes(), err
+		},
+		Decode: func(data []byte) (V, error) {
+			var value V
+			err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value)
+			return value, err
+		},
+	}
+}
+
+// StringCodec returns a Codec for strings, encoded as their bytes.
+func StringCodec() Codec[string] {
+	return Codec[string]{
+		Encode: func(value string) ([]byte, error) {
+			return []byte(value), nil
+		},
+		Decode: func(data []byte) (string, error) {
+			return string(data), nil
+		},
+	}
+}
+
+// Tree is a SparseMerkleTree holding values of type V, encoded with a Codec.
+// Roots and proofs are those of the underlying tree, over the encoded values;
+// to verify a proof for a value, encode it with the same Codec.
+//
+// A value that encodes to the tree's default value (by default, no bytes) is
+// indistinguishable from an absent one, so updating a key with it deletes the
+// key.
+type Tree[V any] struct {
+	smt   *SparseMerkleTree
+	codec Codec[V]
+}
+
+// NewTree creates a Tree that stores values in smt using codec.
+func NewTree[V any](smt *SparseMerkleTree, codec Codec[V]) *Tree[V] {
+	return &Tree[V]{smt: smt, codec: codec}
+}
+
+// SparseMerkleTree returns the underlying tree.
+func (t *Tree[V]) SparseMerkleTree() *SparseMerkleTree {
+	return t.smt
+}
+
+// Codec returns the codec values are encoded with.
+func (t *Tree[V]) Codec() Codec[V] {
+	return t.codec
+}
+
+// Root gets the root of the tree.
+func (t *Tree[V]) Root() []byte {
+	return t.smt.Root()
+}
+
+// Get gets the value of a key from the tree, or the zero value of V if the key
+// is absent.
+func (t *Tree[V]) Get(key []byte) (V, error) {
+	var zero V
+	data, err := t.smt.Get(key)
+	if err != nil || bytes.Equal(data, t.smt.emptyValue()) {
+		return zero, err
+	}
+	return t.codec.Decode(data)
+}
+
+// Has returns true if the key is present in the tree, false otherwise.
+func (t *Tree[V]) Has(key []byte) (bool, error) {
+	return t.smt.Has(key)
+}
+
+// Update sets a new value for a key in the tree, and sets and returns the new
+// root of the tree.
+func (t *Tree[V]) Update(key []byte, value V) ([]byte, error) {
+	data, err := t.codec.Encode(value)
+	if err != nil {
+		return nil, err
+	}
+	return t.smt.Update(key, data)
+}
+
+// Delete deletes a value from tree. It returns the new root of the tree.
+func (t *Tree[V]) Delete(key []byte) ([]byte, error) {
+	return t.smt.Delete(key)
+}
+
+// Prove generates a Merkle proof for a key against the current root, over the
+// encoded value of the key.
+func (t *Tree[V]) Prove(key []byte) (SparseMerkleProof, error) {
+	return t.smt.Prove(key)
+}
+
+// TypedTrie is a SparseMerkleTree with keys of type K and values of type V,
+// each encoded with a Codec, so that callers work with their own types, e.g.
+// addresses and accounts, rather than bytes. It is a Tree of V whose keys are
+// encoded with the key codec, whose Decode is not used and may be nil; roots
+// and proofs are those of the underlying tree, over the encoded keys and
+// values.
+type TypedTrie[K any, V any] struct {
+	tree *Tree[V]
+	keys Codec[K]
+}
+
+// TypedProof is a Merkle proof of the value of a key of a TypedTrie, carrying
+// the decoded value it proves; verify it with VerifyTypedProof.
+type TypedProof[V any] struct {
+	// Value is the value of the key, or the zero value of V if it is absent.
+	Value V
+	// Present is false for a proof of the absence of the key.
+	Present bool
+	// Proof is the proof of the encoded value in the underlying tree.
+	Proof SparseMerkleProof
+}
+
+// NewTypedTrie creates a TypedTrie that stores values in smt, encoding keys
+// with keyCodec and values with valueCodec.
+func NewTypedTrie[K any, V any](smt *SparseMerkleTree, keyCodec Codec[K], valueCodec Codec[V]) *TypedTrie[K, V] {
+	return &TypedTrie[K, V]{tree: NewTree(smt, valueCodec), keys: keyCodec}
+}
+
+// SparseMerkleTree returns the underlying tree.
+func (t *TypedTrie[K, V]) SparseMerkleTree() *SparseMerkleTree {
+	return t.tree.smt
+}
+
+// Root gets the root of the trie.
+func (t *TypedTrie[K, V]) Root() []byte {
+	return t.tree.Root()
+}
+
+// Get gets the value of a key from the trie, or the zero value of V if the
+// key is absent.
+func (t *TypedTrie[K, V]) Get(key K) (V, error) {
+	data, err := t.keys.Encode(key)
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+	return t.tree.Get(data)
+}
+
+// Has returns true if the key is present in the trie, false otherwise.
+func (t *TypedTrie[K, V]) Has(key K) (bool, error) {
+	data, err := t.keys.Encode(key)
+	if err != nil {
+		return false, err
+	}
+	return t.tree.Has(data)
+}
+
+// Update sets a new value for a key in the trie, and sets and returns the new
+// root of the trie.
+func (t *TypedTrie[K, V]) Update(key K, value V) ([]byte, error) {
+	data, err := t.keys.Encode(key)
+	if err != nil {
+		return nil, err
+	}
+	return t.tree.Update(data, value)
+}
+
+// Delete deletes a value from the trie. It returns the new root of the trie.
+func (t *TypedTrie[K, V]) Delete(key K) ([]byte, error) {
+	data, err := t.keys.Encode(key)
+	if err != nil {
+		return nil, err
+	}
+	return t.tree.Delete(data)
+}
+
+// Prove generates a Merkle proof for a key against the current root, along
+// with its decoded value, in a single traversal; see GetWithProof.
+func (t *TypedTrie[K, V]) Prove(key K) (TypedProof[V], error) {
+	data, err := t.keys.Encode(key)
+	if err != nil {
+		return TypedProof[V]{}, err
+	}
+	smt := t.tree.smt
+	encoded, proof, err := smt.GetWithProof(data)
+	if err != nil {
+		return TypedProof[V]{}, err
+	}
+	typed := TypedProof[V]{Proof: proof}
+	if !bytes.Equal(encoded, smt.emptyValue()) {
+		if typed.Value, err = t.tree.codec.Decode(encoded); err != nil {
+			return TypedProof[V]{}, err
+		}
+		typed.Present = true
+	}
+	return typed, nil
+}
+
+// VerifyTypedProof verifies a proof, as returned by TypedTrie.Prove, of the
+// value it carries for key against root, encoding both with the codecs of the
+// trie, and the default value for a proof of absence.
+func VerifyTypedProof[K any, V any](proof TypedProof[V], root []byte, key K, keyCodec Codec[K], valueCodec Codec[V], hasher hash.Hash, options ...VerifyOption) bool {
+	keyData, err := keyCodec.Encode(key)
+	if err != nil {
+		return false
+	}
+	value := newVerifyConfig(options).defaultValue
+	if proof.Present {
+		if value, err = valueCodec.Encode(proof.Value); err != nil {
+			return false
+		}
+	}
+	return VerifyProof(proof.Proof, root, keyData, value, hasher, options...)
+}
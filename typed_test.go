@@ -0,0 +1,150 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+type account struct {
+	Name    string
+	Balance uint64
+}
+
+// point is encoded with encoding.BinaryMarshaler.
+type point struct {
+	X, Y uint32
+}
+
+func (p *point) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint32(data, p.X)
+	binary.BigEndian.PutUint32(data[4:], p.Y)
+	return data, nil
+}
+
+func (p *point) UnmarshalBinary(data []byte) error {
+	if len(data) != 8 {
+		return errors.New("bad point")
+	}
+	p.X, p.Y = binary.BigEndian.Uint32(data), binary.BigEndian.Uint32(data[4:])
+	return nil
+}
+
+func TestTree(t *testing.T) {
+	codec := Codec[account]{
+		Encode: func(value account) ([]byte, error) { return json.Marshal(value) },
+		Decode: func(data []byte) (account, error) {
+			var value account
+			err := json.Unmarshal(data, &value)
+			return value, err
+		},
+	}
+	tree := NewTree(NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New()), codec)
+
+	alice := account{Name: "alice", Balance: 100}
+	if _, err := tree.Update([]byte("alice"), alice); err != nil {
+		t.Errorf("returned error when updating key: %v", err)
+	}
+	value, err := tree.Get([]byte("alice"))
+	if err != nil {
+		t.Errorf("returned error when getting key: %v", err)
+	}
+	if value != alice {
+		t.Error("did not get correct value for key")
+	}
+	value, err = tree.Get([]byte("bob"))
+	if err != nil {
+		t.Errorf("returned error when getting absent key: %v", err)
+	}
+	if value != (account{}) {
+		t.Error("did not get zero value for absent key")
+	}
+
+	// Proofs verify over the encoded value.
+	proof, err := tree.Prove([]byte("alice"))
+	if err != nil {
+		t.Errorf("returned error when proving key: %v", err)
+	}
+	encoded, _ := tree.Codec().Encode(alice)
+	if !VerifyProof(proof, tree.Root(), []byte("alice"), encoded, sha256.New()) {
+		t.Error("proof over encoded value failed to verify")
+	}
+
+	tree.Delete([]byte("alice"))
+	if has, _ := tree.Has([]byte("alice")); has {
+		t.Error("did not delete key")
+	}
+	if !bytes.Equal(tree.Root(), tree.SparseMerkleTree().th.placeholder()) {
+		t.Error("tree not empty after deleting only key")
+	}
+}
+
+func TestTreeBinaryCodec(t *testing.T) {
+	tree := NewTree(NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New()), BinaryCodec[point]())
+	tree.Update([]byte("origin"), point{})
+	tree.Update([]byte("corner"), point{X: 3, Y: 4})
+	value, err := tree.Get([]byte("corner"))
+	if err != nil {
+		t.Errorf("returned error when getting key: %v", err)
+	}
+	if value != (point{X: 3, Y: 4}) {
+		t.Error("did not get correct value for key")
+	}
+	if has, _ := tree.Has([]byte("origin")); !has {
+		t.Error("zero point was not stored")
+	}
+}
+
+func TestTypedTrie(t *testing.T) {
+	trie := NewTypedTrie(NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New()), StringCodec(), GobCodec[account]())
+	alice := account{Name: "alice", Balance: 100}
+	if _, err := trie.Update("alice", alice); err != nil {
+		t.Fatalf("returned error when updating key: %v", err)
+	}
+	trie.Update("bob", account{Name: "bob", Balance: 5})
+	if value, err := trie.Get("alice"); err != nil || value != alice {
+		t.Errorf("got %v, %v for alice", value, err)
+	}
+	if has, _ := trie.Has("carol"); has {
+		t.Error("absent key is present")
+	}
+	if raw, _ := trie.SparseMerkleTree().Get([]byte("alice")); len(raw) == 0 {
+		t.Error("key is not stored under its encoding")
+	}
+
+	proof, err := trie.Prove("alice")
+	if err != nil {
+		t.Fatalf("returned error when proving key: %v", err)
+	}
+	if !proof.Present || proof.Value != alice {
+		t.Errorf("proof carries %v, present %v", proof.Value, proof.Present)
+	}
+	if !VerifyTypedProof(proof, trie.Root(), "alice", StringCodec(), GobCodec[account](), sha256.New()) {
+		t.Error("proof of a value failed to verify")
+	}
+	if VerifyTypedProof(proof, trie.Root(), "bob", StringCodec(), GobCodec[account](), sha256.New()) {
+		t.Error("proof verified for another key")
+	}
+	forged := proof
+	forged.Value.Balance = 1000
+	if VerifyTypedProof(forged, trie.Root(), "alice", StringCodec(), GobCodec[account](), sha256.New()) {
+		t.Error("proof verified for a forged value")
+	}
+
+	absent, err := trie.Prove("carol")
+	if err != nil || absent.Present || absent.Value != (account{}) {
+		t.Errorf("got %v, %v for an absent key", absent, err)
+	}
+	if !VerifyTypedProof(absent, trie.Root(), "carol", StringCodec(), GobCodec[account](), sha256.New()) {
+		t.Error("proof of absence failed to verify")
+	}
+
+	trie.Delete("alice")
+	if has, _ := trie.Has("alice"); has {
+		t.Error("deleted key is present")
+	}
+}
@@ -0,0 +1,290 @@
+package smt
+
+import (
+	"bytes"
+	"sort"
+)
+
+// batchEntry is the write of one path in a batch update.
+type batchEntry struct {
+	path, key []byte
+	// value is nil for a deletion.
+	value, valueHash []byte
+	// replaced is set for a write over a leaf already at the path.
+	replaced bool
+}
+
+// batchLeaf is a leaf of a subtree being rebuilt by a batch update: a leaf
+// already in the tree, or a new one for an entry.
+type batchLeaf struct {
+	path []byte
+	// hash is set for a leaf already in the tree.
+	hash  []byte
+	entry *batchEntry
+}
+
+// treeBatch holds what a batch update changes until it is written.
+type treeBatch struct {
+	smt    *SparseMerkleTree
+	writes nodeBatch
+	// created tells whether each node in writes is a leaf.
+	created map[string]bool
+	// pruned are the nodes the update orphans.
+	pruned [][]byte
+	// changed are the entries that change a leaf, in path order.
+	changed []*batchEntry
+}
+
+// UpdateBatch sets values[i] for keys[i], or deletes keys[i] if values[i] is
+// the default value, for every i at once, and sets and returns the new root
+// of the tree. The tree is the same as after calling Update and Delete for
+// each key in order, with the last write of a key repeated in keys winning,
+// but each node shared by the paths of several keys is read and rehashed once
+// rather than once per key, so a batch of keys touching overlapping paths,
+// such as the writes of a block, needs much less hashing. The batch is
+// checked before anything is written: a value of the wrong size or a path
+// collision fails it as a whole.
+func (smt *SparseMerkleTree) UpdateBatch(keys [][]byte, values [][]byte) ([]byte, error) {
+	if len(keys) != len(values) {
+		panic("smt: keys and values differ in length")
+	}
+	return smt.changeRoot(func(root []byte) ([]byte, error) {
+		return smt.updateBatchForRoot(keys, values, root)
+	})
+}
+
+func (smt *SparseMerkleTree) updateBatchForRoot(keys [][]byte, values [][]byte, root []byte) ([]byte, error) {
+	if smt.sealed {
+		return nil, ErrSealed
+	}
+	entries := make([]batchEntry, 0, len(keys))
+	byPath := make(map[string]int, len(keys))
+	for i, key := range keys {
+		entry := batchEntry{path: smt.th.path(key), key: key}
+		if !smt.IsDeletionValue(values[i]) {
+			if err := smt.checkValueSize(values[i]); err != nil {
+				return nil, err
+			}
+			entry.value, entry.valueHash = values[i], smt.th.digestValue(values[i])
+		}
+		if j, ok := byPath[string(entry.path)]; ok {
+			entries[j] = entry
+			continue
+		}
+		byPath[string(entry.path)] = len(entries)
+		entries = append(entries, entry)
+	}
+	return smt.applyBatch(entries, root)
+}
+
+// applyBatch writes entries, one per path, to the tree at root, returning the
+// new root.
+func (smt *SparseMerkleTree) applyBatch(entries []batchEntry, root []byte) ([]byte, error) {
+	sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i].path, entries[j].path) < 0 })
+
+	b := &treeBatch{smt: smt, created: make(map[string]bool)}
+	newRoot, err := b.apply(root, 0, entries)
+	if err != nil {
+		return nil, err
+	}
+	for _, hash := range b.pruned {
+		if err := smt.pruneNode(hash); err != nil {
+			return nil, err
+		}
+	}
+	if smt.logger != nil && len(b.pruned) > 0 {
+		smt.debugf("pruned %d orphaned nodes updating %d paths", len(b.pruned), len(b.changed))
+	}
+	if err := smt.setNodes(&b.writes); err != nil {
+		return nil, err
+	}
+	for _, entry := range b.changed {
+		if err := b.writeEntry(entry); err != nil {
+			return nil, err
+		}
+	}
+	return newRoot, nil
+}
+
+// writeEntry writes the value, key and version of a changed entry, as Update
+// or Delete would.
+func (b *treeBatch) writeEntry(entry *batchEntry) error {
+	smt := b.smt
+	if smt.observing {
+		oldValue, err := smt.observedValue(entry.path)
+		if err != nil {
+			return err
+		}
+		smt.recordEvent(treeEvent{key: entry.key, oldValue: oldValue, newValue: entry.value, deleted: entry.value == nil})
+	}
+	if entry.value == nil || entry.replaced {
+		if err := smt.deleteValue(entry.path); err != nil {
+			return err
+		}
+	}
+	if entry.value == nil {
+		if err := smt.deleteKey(entry.path); err != nil {
+			return err
+		}
+	} else {
+		if err := smt.setValue(entry.path, entry.value); err != nil {
+			return err
+		}
+		if err := smt.setKey(entry.path, entry.key); err != nil {
+			return err
+		}
+	}
+	return smt.bumpKeyVersion(entry.path)
+}
+
+// apply returns the hash of the subtree at hash, at depth, with entries
+// applied. Entries off the subtree are not given, and the subtree is returned
+// as is if none are.
+func (b *treeBatch) apply(hash []byte, depth int, entries []batchEntry) ([]byte, error) {
+	if len(entries) == 0 {
+		return hash, nil
+	}
+	smt := b.smt
+	if bytes.Equal(hash, smt.th.placeholder()) {
+		var leaves []batchLeaf
+		for i := range entries {
+			if entries[i].value != nil {
+				leaves = append(leaves, batchLeaf{path: entries[i].path, entry: &entries[i]})
+				b.changed = append(b.changed, &entries[i])
+			}
+		}
+		return b.build(depth, leaves), nil
+	}
+
+	data, err := smt.getNode(hash)
+	if err != nil {
+		return nil, err
+	}
+	if smt.th.isLeaf(data) {
+		return b.applyToLeaf(hash, data, depth, entries)
+	}
+	leftNode, rightNode := smt.th.parseNode(data)
+	split := sort.Search(len(entries), func(i int) bool {
+		return getBitAtFromMSB(entries[i].path, depth) == right
+	})
+	newLeft, err := b.apply(leftNode, depth+1, entries[:split])
+	if err != nil {
+		return nil, err
+	}
+	newRight, err := b.apply(rightNode, depth+1, entries[split:])
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(newLeft, leftNode) && bytes.Equal(newRight, rightNode) {
+		return hash, nil
+	}
+	b.pruned = append(b.pruned, hash)
+	return b.node(newLeft, newRight)
+}
+
+// applyToLeaf returns the hash of the subtree holding the leaf at hash, at
+// depth, with entries applied.
+func (b *treeBatch) applyToLeaf(hash []byte, data []byte, depth int, entries []batchEntry) ([]byte, error) {
+	smt := b.smt
+	leafPath, leafValueHash := smt.th.parseLeaf(data)
+	kept := true
+	var leaves []batchLeaf
+	for i := range entries {
+		entry := &entries[i]
+		if bytes.Equal(entry.path, leafPath) {
+			if err := smt.checkKey(entry.path, entry.key, data); err != nil {
+				return nil, err
+			}
+			if entry.value != nil && bytes.Equal(entry.valueHash, leafValueHash) {
+				// The same value is being set.
+				continue
+			}
+			kept = false
+			entry.replaced = true
+			b.changed = append(b.changed, entry)
+			if entry.value != nil {
+				leaves = append(leaves, batchLeaf{path: entry.path, entry: entry})
+			}
+			continue
+		}
+		if entry.value != nil {
+			leaves = append(leaves, batchLeaf{path: entry.path, entry: entry})
+			b.changed = append(b.changed, entry)
+		}
+	}
+	if kept {
+		if len(leaves) == 0 {
+			return hash, nil
+		}
+		leaves = append(leaves, batchLeaf{path: leafPath, hash: hash})
+		sort.Slice(leaves, func(i, j int) bool { return bytes.Compare(leaves[i].path, leaves[j].path) < 0 })
+	} else {
+		b.pruned = append(b.pruned, hash)
+	}
+	return b.build(depth, leaves), nil
+}
+
+// build returns the hash of the subtree at depth holding leaves, sorted by
+// path, writing its new nodes.
+func (b *treeBatch) build(depth int, leaves []batchLeaf) []byte {
+	switch len(leaves) {
+	case 0:
+		return b.smt.th.placeholder()
+	case 1:
+		if leaves[0].hash != nil {
+			return leaves[0].hash
+		}
+		hash, data := b.smt.th.digestLeaf(leaves[0].path, leaves[0].entry.valueHash)
+		b.writes.add(hash, data)
+		b.created[string(hash)] = true
+		return hash
+	}
+	split := sort.Search(len(leaves), func(i int) bool {
+		return getBitAtFromMSB(leaves[i].path, depth) == right
+	})
+	leftNode, rightNode := b.build(depth+1, leaves[:split]), b.build(depth+1, leaves[split:])
+	hash, data := b.smt.th.digestNode(leftNode, rightNode)
+	b.writes.add(hash, data)
+	b.created[string(hash)] = false
+	return hash
+}
+
+// node returns the hash of the internal node with the given children, or the
+// child itself if it is a leaf and the other child is empty, as a leaf alone
+// in a subtree takes the place of the subtree.
+func (b *treeBatch) node(left, right []byte) ([]byte, error) {
+	placeholder := b.smt.th.placeholder()
+	leftEmpty, rightEmpty := bytes.Equal(left, placeholder), bytes.Equal(right, placeholder)
+	if leftEmpty && rightEmpty {
+		return placeholder, nil
+	}
+	if leftEmpty || rightEmpty {
+		child := left
+		if leftEmpty {
+			child = right
+		}
+		leaf, err := b.isLeaf(child)
+		if err != nil {
+			return nil, err
+		}
+		if leaf {
+			return child, nil
+		}
+	}
+	hash, data := b.smt.th.digestNode(left, right)
+	b.writes.add(hash, data)
+	b.created[string(hash)] = false
+	return hash, nil
+}
+
+// isLeaf reports whether the node at hash, new or stored, is a leaf.
+func (b *treeBatch) isLeaf(hash []byte) (bool, error) {
+	if leaf, ok := b.created[string(hash)]; ok {
+		return leaf, nil
+	}
+	data, err := b.smt.getNode(hash)
+	if err != nil {
+		return false, err
+	}
+	return b.smt.th.isLeaf(data), nil
+}
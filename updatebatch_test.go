@@ -0,0 +1,134 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestUpdateBatch(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+	refn, refv := NewSimpleMap(), NewSimpleMap()
+	reference := NewSparseMerkleTree(refn, refv, sha256.New())
+
+	r := rand.New(rand.NewSource(1))
+	for round := 0; round < 20; round++ {
+		// Sets, resets and deletes of present and absent keys, with some keys
+		// repeated in the batch.
+		var keys, values [][]byte
+		for i := 0; i < 1+r.Intn(100); i++ {
+			key := []byte(fmt.Sprintf("testKey%d", r.Intn(200)))
+			var value []byte
+			switch r.Intn(4) {
+			case 0:
+				value = defaultValue
+			case 1:
+				value, _ = reference.Get(key)
+			default:
+				value = []byte(fmt.Sprintf("testValue%d", r.Intn(1000)))
+			}
+			keys = append(keys, key)
+			values = append(values, value)
+			if _, err := reference.Update(key, value); err != nil {
+				t.Fatalf("returned error when updating reference: %v", err)
+			}
+		}
+		root, err := smt.UpdateBatch(keys, values)
+		if err != nil {
+			t.Fatalf("returned error when updating batch: %v", err)
+		}
+		if !bytes.Equal(root, reference.Root()) || !bytes.Equal(smt.Root(), root) {
+			t.Fatalf("batch update gave a different root in round %d", round)
+		}
+		// Orphaned nodes and deleted values are pruned as by Update.
+		if !bytes.Equal(mustExport(t, smn), mustExport(t, refn)) {
+			t.Fatalf("node store differs in round %d", round)
+		}
+		if !bytes.Equal(mustExport(t, smv), mustExport(t, refv)) {
+			t.Fatalf("value store differs in round %d", round)
+		}
+	}
+
+	// Deleting every key empties the tree.
+	var keys, values [][]byte
+	for i := 0; i < 200; i++ {
+		keys = append(keys, []byte(fmt.Sprintf("testKey%d", i)))
+		values = append(values, defaultValue)
+	}
+	if root, err := smt.UpdateBatch(keys, values); err != nil || !bytes.Equal(root, smt.th.placeholder()) {
+		t.Errorf("did not empty tree, got %x, %v", root, err)
+	}
+
+	if _, err := smt.UpdateBatch(nil, nil); err != nil {
+		t.Errorf("returned error for empty batch: %v", err)
+	}
+}
+
+func TestUpdateBatchReads(t *testing.T) {
+	nodes := &countingStore{MapStore: NewSimpleMap()}
+	smt := NewSparseMerkleTree(nodes, NewSimpleMap(), sha256.New())
+	reference := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	var keys, values [][]byte
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, []byte(fmt.Sprintf("testKey%d", i)))
+		values = append(values, []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	smt.UpdateBatch(keys, values)
+	for i := range keys {
+		reference.Update(keys[i], values[i])
+	}
+	if !bytes.Equal(smt.Root(), reference.Root()) {
+		t.Fatal("batch update gave a different root")
+	}
+
+	// Updating half of the keys reads each node on their paths once.
+	nodes.gets = 0
+	for i := 0; i < len(keys); i += 2 {
+		values[i] = []byte(fmt.Sprintf("newValue%d", i))
+	}
+	smt.UpdateBatch(keys, values)
+	batchGets := nodes.gets
+	nodes.gets = 0
+	for i := 0; i < len(keys); i += 2 {
+		if _, err := smt.Update(keys[i], []byte(fmt.Sprintf("otherValue%d", i))); err != nil {
+			t.Fatalf("returned error when updating: %v", err)
+		}
+	}
+	if batchGets*2 > nodes.gets {
+		t.Errorf("batch update read %d nodes, updates one by one %d", batchGets, nodes.gets)
+	}
+}
+
+func TestUpdateBatchErrors(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithFixedValueSize(4))
+	smt.Update([]byte("testKey1"), []byte("abcd"))
+	root := smt.Root()
+
+	keys := [][]byte{[]byte("testKey2"), []byte("testKey3")}
+	if _, err := smt.UpdateBatch(keys, [][]byte{[]byte("efgh"), []byte("toolong")}); !errors.Is(err, ErrValueSize) {
+		t.Errorf("did not return ErrValueSize, got %v", err)
+	}
+	if !bytes.Equal(smt.Root(), root) {
+		t.Error("failed batch changed the root")
+	}
+	if value, _ := smt.Get([]byte("testKey2")); len(value) != 0 {
+		t.Error("failed batch wrote a value")
+	}
+
+	smt.Seal()
+	if _, err := smt.UpdateBatch(keys[:1], [][]byte{[]byte("efgh")}); !errors.Is(err, ErrSealed) {
+		t.Errorf("did not return ErrSealed, got %v", err)
+	}
+}
+
+func mustExport(t *testing.T, store MapStore) []byte {
+	data, err := store.Export()
+	if err != nil {
+		t.Fatalf("returned error when exporting store: %v", err)
+	}
+	return data
+}
@@ -1,5 +1,23 @@
 package smt
 
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// maxFormattedKey is the number of bytes of a key shown in messages, so that
+// long keys do not make for long messages.
+const maxFormattedKey = 32
+
+// formatKey formats key in hex for messages, truncated to its first
+// maxFormattedKey bytes.
+func formatKey(key []byte) string {
+	if len(key) <= maxFormattedKey {
+		return hex.EncodeToString(key)
+	}
+	return fmt.Sprintf("%x... (%d bytes)", key[:maxFormattedKey], len(key))
+}
+
 // getBitAtFromMSB gets the bit at an offset from the most significant bit
 func getBitAtFromMSB(data []byte, position int) int {
 	if int(data[position/8])&(1<<(8-1-uint(position)%8)) > 0 {
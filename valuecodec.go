@@ -0,0 +1,41 @@
+package smt
+
+// SetValueCodec sets functions that transform values on their way into and
+// out of the value store, e.g. to compress them. Leaves commit to the logical
+// value, i.e. to the value before encode and after decode, so roots and proofs
+// do not depend on the codec and clients verifying proofs need not know it.
+// Only the value store holds encoded values, so stores written with a codec,
+// and their exports, must be read with the same codec.
+func (smt *SparseMerkleTree) SetValueCodec(encode, decode func([]byte) ([]byte, error)) {
+	smt.encodeValue, smt.decodeValue = encode, decode
+}
+
+// getValue gets the logical value at path from the value store.
+func (smt *SparseMerkleTree) getValue(path []byte) ([]byte, error) {
+	value, err := smt.values.Get(path)
+	if err != nil || smt.decodeValue == nil {
+		return value, err
+	}
+	return smt.decodeValue(value)
+}
+
+// setValue writes the logical value at path to the value store. The value is
+// copied, as stores may retain it while callers reuse its buffer.
+func (smt *SparseMerkleTree) setValue(path []byte, value []byte) error {
+	if err := smt.checkValueSize(value); err != nil {
+		return err
+	}
+	if err := smt.retainValue(path); err != nil {
+		return err
+	}
+	if err := smt.preserve(valueStore, path); err != nil {
+		return err
+	}
+	if smt.encodeValue != nil {
+		var err error
+		if value, err = smt.encodeValue(value); err != nil {
+			return err
+		}
+	}
+	return smt.values.Set(path, append([]byte{}, value...))
+}
@@ -0,0 +1,65 @@
+package smt
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+func compress(value []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(value); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompress(value []byte) ([]byte, error) {
+	return ioutil.ReadAll(flate.NewReader(bytes.NewReader(value)))
+}
+
+func TestValueCodec(t *testing.T) {
+	smv := NewSimpleMap()
+	smt := NewSparseMerkleTree(NewSimpleMap(), smv, sha256.New())
+	smt.SetValueCodec(compress, decompress)
+	plain := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		value := bytes.Repeat([]byte(fmt.Sprintf("testValue%d", i)), 100)
+		if _, err := smt.Update(key, value); err != nil {
+			t.Errorf("returned error when updating with codec: %v", err)
+		}
+		plain.Update(key, value)
+	}
+	if !bytes.Equal(smt.Root(), plain.Root()) {
+		t.Error("value codec changed the root")
+	}
+
+	key := []byte("testKey1")
+	value := bytes.Repeat([]byte("testValue1"), 100)
+	got, err := smt.Get(key)
+	if err != nil {
+		t.Errorf("returned error when getting with codec: %v", err)
+	}
+	if !bytes.Equal(got, value) {
+		t.Error("did not get decoded value")
+	}
+	if stored, _ := smv.Get(smt.th.path(key)); len(stored) >= len(value) {
+		t.Error("value store does not hold the encoded value")
+	}
+	proof, _ := smt.Prove(key)
+	if !VerifyProof(proof, smt.Root(), key, value, sha256.New()) {
+		t.Error("proof over logical value failed to verify")
+	}
+}
@@ -0,0 +1,121 @@
+package smt
+
+import (
+	"bytes"
+	"hash"
+)
+
+// VerifyOption is a function that configures proof verification.
+type VerifyOption func(*verifyConfig)
+
+type verifyConfig struct {
+	defaultValue []byte
+	// prefixes are the leaf and node domain prefixes, if not the defaults.
+	prefixes []byte
+	// depth is the expected depth of the tree, if not the number of bits of
+	// a path of the hasher.
+	depth int
+	// strict requires proofs to be canonical.
+	strict bool
+	// valueSize is the size of the values of the tree, if fixed.
+	valueSize int
+}
+
+func newVerifyConfig(options []VerifyOption) *verifyConfig {
+	config := verifyConfig{defaultValue: defaultValue}
+	for _, option := range options {
+		option(&config)
+	}
+	return &config
+}
+
+// WithVerifyDefaultValue verifies proofs from a tree created with
+// WithDefaultValue and the same value, so that value proves non-membership.
+func WithVerifyDefaultValue(value []byte) VerifyOption {
+	if value == nil {
+		panic("smt: nil default value")
+	}
+	return func(config *verifyConfig) {
+		config.defaultValue = value
+	}
+}
+
+// WithVerifyDomainPrefixes verifies proofs from a tree created with
+// WithDomainPrefixes and the same prefixes.
+func WithVerifyDomainPrefixes(leaf byte, node byte) VerifyOption {
+	checkDomainPrefixes(leaf, node)
+	return func(config *verifyConfig) {
+		config.prefixes = []byte{leaf, node}
+	}
+}
+
+// WithVerifyDepth rejects proofs that are not from a tree of the given depth,
+// which otherwise is the number of bits of the paths of the hasher. Proofs
+// record the depth of their tree, so one from a tree of another depth fails
+// to verify even if its side nodes would fit. A depth less than the number
+// of bits of the paths of the hasher verifies proofs from a tree created with
+// WithPathBits and that depth.
+func WithVerifyDepth(depth int) VerifyOption {
+	if depth <= 0 {
+		panic("smt: non-positive depth")
+	}
+	return func(config *verifyConfig) {
+		config.depth = depth
+	}
+}
+
+// WithStrictVerify rejects proofs that are not in the canonical form the tree
+// generates them in, so that each key and root has a single valid proof. The
+// nearest side node of a full proof may not be the placeholder, as the tree
+// never keeps a leaf or empty subtree beside an empty subtree but collapses
+// them instead, and a compact proof must mark every placeholder side node in
+// its bit mask rather than carry it. A membership proof may not carry
+// non-membership leaf data. See
+// VerifyProofStrict, which also returns why a proof fails.
+func WithStrictVerify() VerifyOption {
+	return func(config *verifyConfig) {
+		config.strict = true
+	}
+}
+
+// WithVerifyValueSize verifies proofs from a tree created with
+// WithFixedValueSize and the same size: a proof of a value of another size,
+// other than the default value, fails to verify.
+func WithVerifyValueSize(n int) VerifyOption {
+	if n <= 0 {
+		panic("smt: non-positive value size")
+	}
+	return func(config *verifyConfig) {
+		config.valueSize = n
+	}
+}
+
+// checkValueSize returns false if the values are of a fixed size and value is
+// neither of that size nor the default value.
+func (config *verifyConfig) checkValueSize(value []byte) bool {
+	return config.valueSize == 0 || len(value) == config.valueSize || bytes.Equal(value, config.defaultValue)
+}
+
+// treeHasher returns a treeHasher for hasher with the configured prefixes.
+func (config *verifyConfig) treeHasher(hasher hash.Hash) *treeHasher {
+	th := newTreeHasher(hasher)
+	if config.prefixes != nil {
+		th.setDomainPrefixes(config.prefixes[0], config.prefixes[1])
+	}
+	th.setDepth(config.depth)
+	th.strict = config.strict
+	return th
+}
+
+// treeOptions returns the options of a tree hashing and storing values as the
+// configuration verifies.
+func (config *verifyConfig) treeOptions() []Option {
+	options := []Option{WithDefaultValue(config.defaultValue)}
+	if config.prefixes != nil {
+		options = append(options, WithDomainPrefixes(config.prefixes[0], config.prefixes[1]))
+	}
+	if config.valueSize != 0 {
+		options = append(options, WithFixedValueSize(config.valueSize))
+	}
+	return options
+}
@@ -0,0 +1,84 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+// The errors returned for malformed proofs, by VerifyProofStrict and the
+// functions checking proofs before using them, such as ComputeRootFromProof
+// and DecompactProof. Each wraps ErrBadProof.
+var (
+	// ErrProofTooLong is returned for a proof with more side nodes than the
+	// tree is deep.
+	ErrProofTooLong = fmt.Errorf("%w: too many side nodes", ErrBadProof)
+	// ErrProofDepth is returned for a proof recording a depth other than
+	// that of the tree; see WithVerifyDepth.
+	ErrProofDepth = fmt.Errorf("%w: proof is from a tree of another depth", ErrBadProof)
+	// ErrProofHashSize is returned for a side node, or root, that is not the
+	// size of the hashes of the tree.
+	ErrProofHashSize = fmt.Errorf("%w: hash has wrong size", ErrBadProof)
+	// ErrProofLeafData is returned for non-membership leaf data that is not
+	// a leaf of the tree, or the leaf of the key itself, or a leaf off the
+	// path of the key.
+	ErrProofLeafData = fmt.Errorf("%w: invalid non-membership leaf data", ErrBadProof)
+	// ErrProofSiblingData is returned for sibling data that is not a node of
+	// the tree, or not that of the nearest side node.
+	ErrProofSiblingData = fmt.Errorf("%w: invalid sibling data", ErrBadProof)
+	// ErrProofBitMask is returned for a compact proof whose bit mask and
+	// number of side nodes do not agree with the side nodes it carries.
+	ErrProofBitMask = fmt.Errorf("%w: bit mask does not match side nodes", ErrBadProof)
+	// ErrProofNotCanonical is returned for a proof not in the form the tree
+	// generates it in; see WithStrictVerify.
+	ErrProofNotCanonical = fmt.Errorf("%w: proof is not canonical", ErrBadProof)
+)
+
+// ErrProofMismatch is returned by VerifyProofStrict for a well-formed proof
+// that does not reconstruct the root, e.g. for another value of the key.
+var ErrProofMismatch = errors.New("proof does not match the root")
+
+// VerifyProofStrict verifies a Merkle proof as VerifyProof does with
+// WithStrictVerify, for proofs from untrusted sources, returning nil if it
+// verifies and why it does not otherwise: one of the errors wrapping
+// ErrBadProof for a malformed proof, ErrValueSize for a value that is not of
+// the size set with WithVerifyValueSize, or ErrProofMismatch. The proof and
+// root are checked before the root is reconstructed, so a malformed proof
+// costs no more than reading it and hashing the key and value, however large.
+func VerifyProofStrict(proof SparseMerkleProof, root []byte, key []byte, value []byte, hasher hash.Hash, options ...VerifyOption) error {
+	config := newVerifyConfig(options)
+	config.strict = true
+	th := config.treeHasher(hasher)
+	if len(root) != th.hashSize() {
+		return fmt.Errorf("%w: root of %d bytes, want %d", ErrProofHashSize, len(root), th.hashSize())
+	}
+	if !config.checkValueSize(value) {
+		return fmt.Errorf("%w: %d bytes, want %d", ErrValueSize, len(value), config.valueSize)
+	}
+	var valueHash []byte
+	if !bytes.Equal(value, config.defaultValue) {
+		valueHash = th.digestValue(value)
+	}
+	// The proof is checked before the root is reconstructed.
+	computed, _, err := computeRootForValueHash(proof, th.path(key), valueHash, th)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(computed, root) {
+		return ErrProofMismatch
+	}
+	return nil
+}
+
+// VerifyCompactProofStrict verifies a compacted Merkle proof as
+// VerifyProofStrict does, checking its bit mask before decompacting it.
+func VerifyCompactProofStrict(proof SparseCompactMerkleProof, root []byte, key []byte, value []byte, hasher hash.Hash, options ...VerifyOption) error {
+	config := newVerifyConfig(options)
+	config.strict = true
+	decompacted, err := decompactProof(proof, config.treeHasher(hasher))
+	if err != nil {
+		return err
+	}
+	return VerifyProofStrict(decompacted, root, key, value, hasher, options...)
+}
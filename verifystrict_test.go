@@ -0,0 +1,193 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestVerifyProofStrict(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	smt.Update([]byte("testKey1"), []byte("testValue1"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	smt.Update([]byte("testKey3"), []byte("testValue3"))
+	root, _ := smt.Update([]byte("testKey4"), []byte("testValue4"))
+	key, value := []byte("testKey1"), []byte("testValue1")
+
+	proof, _ := smt.Prove(key)
+	if err := VerifyProofStrict(proof, root, key, value, sha256.New()); err != nil {
+		t.Fatalf("valid proof did not verify: %v", err)
+	}
+	absent, _ := smt.Prove([]byte("testKey5"))
+	if err := VerifyProofStrict(absent, root, []byte("testKey5"), nil, sha256.New()); err != nil {
+		t.Errorf("valid non-membership proof did not verify: %v", err)
+	}
+	if err := VerifyProofStrict(proof, root, key, []byte("other"), sha256.New()); !errors.Is(err, ErrProofMismatch) {
+		t.Errorf("got %v for another value, want ErrProofMismatch", err)
+	}
+
+	_, _, leafData, _, _ := smt.sideNodesForRoot(smt.th.path(key), root, false)
+	for _, tc := range []struct {
+		name   string
+		modify func(p *SparseMerkleProof)
+		root   []byte
+		want   error
+	}{
+		{"too many side nodes", func(p *SparseMerkleProof) {
+			p.SideNodes = make([][]byte, 257)
+			for i := range p.SideNodes {
+				p.SideNodes[i] = proof.SideNodes[0]
+			}
+		}, root, ErrProofTooLong},
+		{"wrong depth", func(p *SparseMerkleProof) { p.Depth = 128 }, root, ErrProofDepth},
+		{"short side node", func(p *SparseMerkleProof) { p.SideNodes[0] = p.SideNodes[0][:31] }, root, ErrProofHashSize},
+		{"short root", func(p *SparseMerkleProof) {}, root[:16], ErrProofHashSize},
+		{"leaf data of a node", func(p *SparseMerkleProof) {
+			p.NonMembershipLeafData = append(append([]byte{}, nodePrefix...), make([]byte, 64)...)
+		}, root, ErrProofLeafData},
+		{"sibling data of no node", func(p *SparseMerkleProof) { p.SiblingData = make([]byte, 1<<20) }, root, ErrProofSiblingData},
+		{"sibling data of another node", func(p *SparseMerkleProof) { p.SiblingData = leafData }, root, ErrProofSiblingData},
+		{"placeholder nearest side node", func(p *SparseMerkleProof) {
+			p.SideNodes = append([][]byte{smt.th.placeholder()}, p.SideNodes...)
+		}, root, ErrProofNotCanonical},
+		{"non-membership leaf data in a membership proof", func(p *SparseMerkleProof) {
+			p.NonMembershipLeafData = absent.NonMembershipLeafData
+		}, root, ErrProofNotCanonical},
+	} {
+		p := SparseMerkleProof{SideNodes: append([][]byte{}, proof.SideNodes...)}
+		tc.modify(&p)
+		err := VerifyProofStrict(p, tc.root, key, value, sha256.New())
+		if !errors.Is(err, tc.want) || !errors.Is(err, ErrBadProof) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, tc.want)
+		}
+		if VerifyProof(p, tc.root, key, value, sha256.New(), WithStrictVerify()) {
+			t.Errorf("%s: proof verified", tc.name)
+		}
+	}
+
+	// A leaf of the key proven absent, or off its path.
+	other, _ := smt.Prove([]byte("testKey2"))
+	off := SparseMerkleProof{SideNodes: other.SideNodes, NonMembershipLeafData: absent.NonMembershipLeafData}
+	if err := VerifyProofStrict(off, root, []byte("testKey5"), nil, sha256.New()); !errors.Is(err, ErrProofLeafData) && !errors.Is(err, ErrProofMismatch) {
+		t.Errorf("got %v for a leaf off the path", err)
+	}
+	smt.Update([]byte("testKey5"), []byte("testValue5"))
+	own, _ := smt.ProveUpdatable([]byte("testKey5"))
+	_, _, leafData, _, _ = smt.sideNodesForRoot(smt.th.path([]byte("testKey5")), smt.Root(), false)
+	own.NonMembershipLeafData, own.SiblingData = leafData, nil
+	if err := VerifyProofStrict(own, smt.Root(), []byte("testKey5"), nil, sha256.New()); !errors.Is(err, ErrProofLeafData) {
+		t.Errorf("got %v for the leaf of the key proven absent, want ErrProofLeafData", err)
+	}
+
+	if err := VerifyProofStrict(proof, root, key, value, sha256.New(), WithVerifyValueSize(4)); !errors.Is(err, ErrValueSize) {
+		t.Errorf("got %v for a value of another size, want ErrValueSize", err)
+	}
+}
+
+func TestVerifyCompactProofStrict(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for _, key := range []string{"testKey1", "testKey2", "testKey3", "testKey4"} {
+		smt.Update([]byte(key), []byte("value"))
+	}
+	root := smt.Root()
+	key := []byte("testKey1")
+	proof, _ := smt.ProveCompact(key)
+	if err := VerifyCompactProofStrict(proof, root, key, []byte("value"), sha256.New()); err != nil {
+		t.Fatalf("valid compact proof did not verify: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		modify func(p *SparseCompactMerkleProof)
+		want   error
+	}{
+		{"negative side nodes", func(p *SparseCompactMerkleProof) { p.NumSideNodes = -1 }, ErrProofBitMask},
+		{"too many side nodes", func(p *SparseCompactMerkleProof) { p.NumSideNodes = 1 << 30 }, ErrProofTooLong},
+		{"short bit mask", func(p *SparseCompactMerkleProof) { p.BitMask = nil }, ErrProofBitMask},
+		{"missing side node", func(p *SparseCompactMerkleProof) { p.SideNodes = p.SideNodes[1:] }, ErrProofBitMask},
+		{"bit past the side nodes", func(p *SparseCompactMerkleProof) {
+			p.BitMask[len(p.BitMask)-1] |= 1
+			p.SideNodes = p.SideNodes[1:]
+		}, ErrProofBitMask},
+		{"placeholder side node", func(p *SparseCompactMerkleProof) {
+			p.SideNodes = append(p.SideNodes, smt.th.placeholder())
+			p.NumSideNodes++
+			p.BitMask = make([]byte, (p.NumSideNodes+7)/8)
+			copy(p.BitMask, proof.BitMask)
+		}, ErrProofNotCanonical},
+	} {
+		p := proof
+		p.SideNodes = append([][]byte{}, proof.SideNodes...)
+		p.BitMask = append([]byte{}, proof.BitMask...)
+		tc.modify(&p)
+		if err := VerifyCompactProofStrict(p, root, key, []byte("value"), sha256.New()); !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, tc.want)
+		}
+		if tc.want != ErrProofNotCanonical && VerifyCompactProof(p, root, key, []byte("value"), sha256.New()) {
+			t.Errorf("%s: proof verified", tc.name)
+		}
+	}
+}
+
+// FuzzProofDecoding checks that the decoders of the serialized proof formats
+// and the strict verifier reject arbitrary input without panicking, and that
+// the proofs they accept encode back to proofs equal to them.
+func FuzzProofDecoding(f *testing.F) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	smt.Update([]byte("foo"), []byte("bar"))
+	root, _ := smt.Update([]byte("baz"), []byte("qux"))
+	proof, _ := smt.ProveUpdatable([]byte("foo"))
+	compact, _ := smt.ProveCompact([]byte("foo"))
+	for _, encoded := range []func() ([]byte, error){
+		func() ([]byte, error) { return proof.ToProto(), nil },
+		func() ([]byte, error) { return compact.ToProto(), nil },
+		proof.MarshalJSON, compact.MarshalJSON,
+		proof.MarshalCBOR, compact.MarshalCBOR,
+	} {
+		data, _ := encoded()
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var decoded []SparseMerkleProof
+		var p SparseMerkleProof
+		if p.FromProto(data) == nil {
+			decoded = append(decoded, p)
+		}
+		if p.UnmarshalJSON(data) == nil {
+			decoded = append(decoded, p)
+		}
+		if p.UnmarshalCBOR(data) == nil {
+			decoded = append(decoded, p)
+		}
+		for _, p := range decoded {
+			var again SparseMerkleProof
+			if err := again.FromProto(p.ToProto()); err != nil || !proofsEqual(p, again) {
+				t.Errorf("decoded proof %v did not round trip: %v", p, err)
+			}
+			VerifyProofStrict(p, root, []byte("foo"), []byte("bar"), sha256.New())
+		}
+
+		var c SparseCompactMerkleProof
+		for _, decode := range []func([]byte) error{c.FromProto, c.UnmarshalJSON, c.UnmarshalCBOR} {
+			if decode(data) == nil {
+				VerifyCompactProofStrict(c, root, []byte("foo"), []byte("bar"), sha256.New())
+			}
+		}
+	})
+}
+
+// proofsEqual returns true if the proofs have the same fields.
+func proofsEqual(a, b SparseMerkleProof) bool {
+	if len(a.SideNodes) != len(b.SideNodes) || a.Depth != b.Depth ||
+		!bytes.Equal(a.NonMembershipLeafData, b.NonMembershipLeafData) || !bytes.Equal(a.SiblingData, b.SiblingData) {
+		return false
+	}
+	for i := range a.SideNodes {
+		if !bytes.Equal(a.SideNodes[i], b.SideNodes[i]) {
+			return false
+		}
+	}
+	return true
+}
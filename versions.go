@@ -0,0 +1,84 @@
+package smt
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrVersionsNotTracked is returned by KeyVersion and Version for a tree
+// created without WithKeyVersions.
+var ErrVersionsNotTracked = errors.New("tree does not track key versions")
+
+// versionCounterKey is the key of the version counter in the version store.
+// It is not the size of a path, so it cannot collide with the version of a
+// key.
+var versionCounterKey = []byte("smt:version")
+
+// WithKeyVersions tracks a version counter for the tree in versions, which
+// every change to a key increments, along with the version at which each key
+// last changed, indexed by path, so that a client can poll KeyVersion and
+// fetch a value again only when its version increases. This stores an 8 byte
+// version per key ever changed, including deleted keys, so that their
+// deletion is seen as a change, and a Get and two Sets of the version store
+// per changed key. Setting a key to its current value is not a change. Bulk
+// loads such as BuildFromSorted and ReadSnapshot do not record versions.
+func WithKeyVersions(versions MapStore) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.versions = versions
+	}
+}
+
+// KeyVersion returns the version at which key last changed, or 0 if it never
+// changed since versions were tracked.
+func (smt *SparseMerkleTree) KeyVersion(key []byte) (uint64, error) {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	if smt.versions == nil {
+		return 0, ErrVersionsNotTracked
+	}
+	return smt.readVersion(smt.th.path(key))
+}
+
+// Version returns the version counter of the tree, i.e. the version of the
+// last change to any key.
+func (smt *SparseMerkleTree) Version() (uint64, error) {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	if smt.versions == nil {
+		return 0, ErrVersionsNotTracked
+	}
+	return smt.readVersion(versionCounterKey)
+}
+
+// bumpKeyVersion increments the version counter and records it as the
+// version of the key at path, if versions are tracked.
+func (smt *SparseMerkleTree) bumpKeyVersion(path []byte) error {
+	if smt.versions == nil {
+		return nil
+	}
+	version, err := smt.readVersion(versionCounterKey)
+	if err != nil {
+		return err
+	}
+	var encoded [8]byte
+	binary.BigEndian.PutUint64(encoded[:], version+1)
+	if err := smt.versions.Set(versionCounterKey, encoded[:]); err != nil {
+		return err
+	}
+	return smt.versions.Set(path, append([]byte{}, encoded[:]...))
+}
+
+// readVersion reads the version stored under key, or 0 if there is none.
+func (smt *SparseMerkleTree) readVersion(key []byte) (uint64, error) {
+	encoded, err := smt.versions.Get(key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(encoded) != 8 {
+		return 0, errors.New("version store holds a malformed version")
+	}
+	return binary.BigEndian.Uint64(encoded), nil
+}
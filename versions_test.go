@@ -0,0 +1,55 @@
+package smt
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestKeyVersions(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithKeyVersions(NewSimpleMap()))
+	version := func(key string) uint64 {
+		v, err := smt.KeyVersion([]byte(key))
+		if err != nil {
+			t.Fatalf("returned error when getting version of %s: %v", key, err)
+		}
+		return v
+	}
+	if version("testKey1") != 0 {
+		t.Error("unchanged key has a version")
+	}
+
+	smt.Update([]byte("testKey1"), []byte("testValue1"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	v1, v2 := version("testKey1"), version("testKey2")
+	if v1 == 0 || v2 <= v1 {
+		t.Errorf("versions of updated keys are %d and %d", v1, v2)
+	}
+	smt.Update([]byte("testKey1"), []byte("testValue1"))
+	if version("testKey1") != v1 {
+		t.Error("setting a key to its value changed its version")
+	}
+	smt.Update([]byte("testKey1"), []byte("newValue1"))
+	if version("testKey1") <= v2 || version("testKey2") != v2 {
+		t.Error("updating a key did not change only its version")
+	}
+	before := version("testKey2")
+	smt.Delete([]byte("testKey2"))
+	if version("testKey2") <= before {
+		t.Error("deleting a key did not change its version")
+	}
+	smt.UpdatePresence([]byte("testKey3"))
+	if current, _ := smt.Version(); current != version("testKey3") {
+		t.Errorf("tree version %d is not that of the last change %d", current, version("testKey3"))
+	}
+	before1, before3 := version("testKey1"), version("testKey3")
+	smt.Clear()
+	if version("testKey1") <= before1 || version("testKey3") <= before3 {
+		t.Error("clearing the tree did not change the versions of its keys")
+	}
+
+	plain := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	if _, err := plain.KeyVersion([]byte("testKey1")); !errors.Is(err, ErrVersionsNotTracked) {
+		t.Errorf("did not return ErrVersionsNotTracked, got %v", err)
+	}
+}
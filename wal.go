@@ -0,0 +1,198 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+)
+
+// Keys of the entries of a write-ahead log store.
+var (
+	walPendingKey = []byte("pending")
+	walRootKey    = []byte("root")
+)
+
+// walRecord is a root transition in a write-ahead log, with every store write
+// needed to make it.
+type walRecord struct {
+	OldRoot, NewRoot []byte
+	Writes           []walWrite
+}
+
+// walWrite is a store write of a walRecord.
+type walWrite struct {
+	Store  int
+	Key    []byte
+	Value  []byte
+	Delete bool
+}
+
+// WithWriteAheadLog logs every change of the root to log before making it, so
+// that a tree interrupted in the middle of an update, e.g. by a crash, can be
+// brought back to a consistent state with Recover.
+//
+// Each update is first made in memory. The resulting root transition and all
+// the writes it needs are then set in log as a single entry, and only once
+// that is done are the writes applied to the tree's stores and the entry
+// cleared. The log also holds the last root committed this way. An update is
+// therefore durable once the entry is durable in log, i.e. once log.Set
+// returns, if log persists its writes by then; until then, a crash leaves the
+// stores and the root as they were. The writes to the tree's stores need not
+// be durable individually, as Recover applies them again. A WALFile is such a
+// log, kept in a single file.
+func WithWriteAheadLog(log MapStore) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.wal = log
+	}
+}
+
+// Recover completes the root transition left in the tree's write-ahead log by
+// an interrupted update, if any, then sets the root of the tree to the last
+// root committed to the log. A tree should be recovered before use when it is
+// imported from the stores of a tree that may have been interrupted; its root
+// need not be known for it, as it is read from the log. If the log holds no
+// root, the root is left as it is.
+func (smt *SparseMerkleTree) Recover() error {
+	if smt.wal == nil {
+		return errors.New("tree has no write-ahead log")
+	}
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+
+	data, err := smt.wal.Get(walPendingKey)
+	if err == nil {
+		var record walRecord
+		if err := GobDecode(data, &record); err != nil {
+			return err
+		}
+		smt.warnf("recovering interrupted update from root %x to %x", record.OldRoot, record.NewRoot)
+		if err := smt.applyWAL(&record); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, ErrKeyNotFound) {
+		return err
+	}
+
+	root, err := smt.wal.Get(walRootKey)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	smt.root = root
+	return nil
+}
+
+// walStore is a MapStore overlay that holds the writes of an update in memory
+// for a write-ahead log record.
+type walStore struct {
+	base   MapStore
+	store  int
+	writes map[string]*walWrite
+	order  []*walWrite
+}
+
+func newWALStore(base MapStore, store int) *walStore {
+	return &walStore{base: base, store: store, writes: make(map[string]*walWrite)}
+}
+
+func (ws *walStore) Get(key []byte) ([]byte, error) {
+	if w, ok := ws.writes[string(key)]; ok {
+		if w.Delete {
+			return nil, &InvalidKeyError{Key: key}
+		}
+		return w.Value, nil
+	}
+	return ws.base.Get(key)
+}
+
+func (ws *walStore) write(key []byte, value []byte, delete bool) {
+	w, ok := ws.writes[string(key)]
+	if !ok {
+		w = &walWrite{Store: ws.store, Key: append([]byte{}, key...)}
+		ws.writes[string(key)] = w
+		ws.order = append(ws.order, w)
+	}
+	w.Value, w.Delete = value, delete
+}
+
+func (ws *walStore) Set(key []byte, value []byte) error {
+	ws.write(key, value, false)
+	return nil
+}
+
+func (ws *walStore) Delete(key []byte) error {
+	if _, err := ws.Get(key); err != nil {
+		return err
+	}
+	ws.write(key, nil, true)
+	return nil
+}
+
+func (ws *walStore) Export() ([]byte, error) {
+	return nil, errors.New("cannot export a store during an update")
+}
+
+// withWAL makes update, which changes the tree's stores, in memory, and logs
+// its writes with the root transition it returns before applying them.
+func (smt *SparseMerkleTree) withWAL(oldRoot []byte, update func() ([]byte, error)) ([]byte, error) {
+	nodes, values, keys := smt.nodes, smt.values, smt.keys
+	overlays := []*walStore{newWALStore(nodes, nodeStore), newWALStore(values, valueStore)}
+	smt.nodes, smt.values = overlays[nodeStore], overlays[valueStore]
+	if keys != nil {
+		overlays = append(overlays, newWALStore(keys, keyStore))
+		smt.keys = overlays[keyStore]
+	}
+	newRoot, err := update()
+	smt.nodes, smt.values, smt.keys = nodes, values, keys
+	if err != nil {
+		return nil, err
+	}
+
+	record := walRecord{OldRoot: oldRoot, NewRoot: newRoot}
+	for _, overlay := range overlays {
+		for _, w := range overlay.order {
+			record.Writes = append(record.Writes, *w)
+		}
+	}
+	if len(record.Writes) == 0 && bytes.Equal(oldRoot, newRoot) {
+		return newRoot, nil
+	}
+	data, err := GobEncode(record)
+	if err != nil {
+		return nil, err
+	}
+	if err := smt.wal.Set(walPendingKey, data); err != nil {
+		return nil, err
+	}
+	if err := smt.applyWAL(&record); err != nil {
+		smt.warnf("failed to apply update from root %x to %x, recover to complete it: %v", oldRoot, newRoot, err)
+		return nil, err
+	}
+	return newRoot, nil
+}
+
+// applyWAL applies the writes of a write-ahead log record, commits its new
+// root and clears it from the log. Writes may already have been applied, if
+// the record is recovered.
+func (smt *SparseMerkleTree) applyWAL(record *walRecord) error {
+	for _, w := range record.Writes {
+		store := smt.store(w.Store)
+		if store == nil {
+			return errors.New("write-ahead log record writes to an unknown store")
+		}
+		if !w.Delete {
+			if err := store.Set(w.Key, w.Value); err != nil {
+				return err
+			}
+			continue
+		}
+		err := store.Delete(w.Key)
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			return err
+		}
+	}
+	if err := smt.wal.Set(walRootKey, record.NewRoot); err != nil {
+		return err
+	}
+	return smt.wal.Delete(walPendingKey)
+}
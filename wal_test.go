@@ -0,0 +1,79 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func TestWriteAheadLogRecovery(t *testing.T) {
+	// Interrupt an update after each number of node writes, and check that
+	// the update is completed on recovery.
+	for crashAfter := 0; crashAfter < 10; crashAfter++ {
+		smn, smv, wal := NewSimpleMap(), NewSimpleMap(), NewSimpleMap()
+		nodes := &failingStore{MapStore: smn, sets: -1}
+		smt := NewSparseMerkleTree(nodes, smv, sha256.New(), WithWriteAheadLog(wal))
+		plain := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+		for i := 0; i < 20; i++ {
+			key, value := []byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i))
+			smt.Update(key, value)
+			plain.Update(key, value)
+		}
+
+		nodes.sets = crashAfter
+		if _, err := smt.Update([]byte("testKey20"), []byte("testValue20")); err == nil {
+			continue // The update made fewer writes.
+		}
+		plain.Update([]byte("testKey20"), []byte("testValue20"))
+
+		// Restart from the stores, without knowing the root.
+		recovered := ImportSparseMerkleTree(smn, smv, sha256.New(), nil, WithWriteAheadLog(wal))
+		if err := recovered.Recover(); err != nil {
+			t.Fatalf("returned error when recovering: %v", err)
+		}
+		if !bytes.Equal(recovered.Root(), plain.Root()) {
+			t.Fatalf("did not recover root after crash after %d writes", crashAfter)
+		}
+		for i := 0; i <= 20; i++ {
+			key := []byte(fmt.Sprintf("testKey%d", i))
+			proof, err := recovered.Prove(key)
+			if err != nil || !VerifyProof(proof, recovered.Root(), key, []byte(fmt.Sprintf("testValue%d", i)), sha256.New()) {
+				t.Fatalf("did not recover key %s after crash after %d writes", key, crashAfter)
+			}
+		}
+		if _, err := recovered.Update([]byte("testKey0"), []byte("newValue")); err != nil {
+			t.Errorf("returned error when updating recovered tree: %v", err)
+		}
+	}
+}
+
+func TestWriteAheadLogFailure(t *testing.T) {
+	// An update that fails to be logged is not made.
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	wal := &failingStore{MapStore: NewSimpleMap(), sets: -1}
+	smt := NewSparseMerkleTree(smn, smv, sha256.New(), WithWriteAheadLog(wal))
+	for i := 0; i < 10; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	root, nodeCount := smt.Root(), len(smn.m)
+
+	wal.sets = 0
+	if _, err := smt.Update([]byte("testKey10"), []byte("testValue10")); err == nil {
+		t.Fatal("did not return error when logging failed")
+	}
+	if len(smn.m) != nodeCount || !bytes.Equal(smt.Root(), root) {
+		t.Error("update was made although logging failed")
+	}
+
+	recovered := ImportSparseMerkleTree(smn, smv, sha256.New(), nil, WithWriteAheadLog(wal))
+	if err := recovered.Recover(); err != nil {
+		t.Fatalf("returned error when recovering: %v", err)
+	}
+	if !bytes.Equal(recovered.Root(), root) {
+		t.Error("did not recover last committed root")
+	}
+	if has, _ := recovered.Has([]byte("testKey10")); has {
+		t.Error("recovered update that was not logged")
+	}
+}
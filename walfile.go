@@ -0,0 +1,303 @@
+package smt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// walFileMagic identifies a file written by WALFile.
+var walFileMagic = []byte("SMTL")
+
+const walFileVersion = 1
+
+// Tags of the records of a WALFile.
+const (
+	walFileSet byte = iota + 1
+	walFileDelete
+)
+
+// WAL file layout, with lengths as uvarints:
+//
+//	magic, one byte of format version
+//	per record: tag, key length, key, then for a set, value length, value;
+//	then the big-endian CRC32 (IEEE) of the record
+
+const walFileHeaderSize = 4 + 1
+
+// DefaultWALFileSize is the size past which a WALFile is checkpointed.
+const DefaultWALFileSize = 4 << 20
+
+// WALFile is a MapStore kept in memory and in an append-only file, for the
+// log of WithWriteAheadLog: every Set and Delete is appended to the file and
+// synced before it returns, so that a logged update survives a crash, and the
+// file is replayed when opened again. Records torn by a crash while they were
+// being appended are discarded on opening.
+//
+// A tree's log only holds the last root and the update in progress, so the
+// file is mostly records that are no longer live; once it grows past its
+// maximum size, it is checkpointed, i.e. rewritten with only the live entries
+// and truncated. See also Checkpoint and SetMaxSize.
+type WALFile struct {
+	mu      sync.Mutex
+	path    string
+	f       *os.File
+	m       map[string][]byte
+	size    int64
+	maxSize int64
+}
+
+// OpenWALFile opens the WALFile at path, replaying its records, or creates it
+// if it does not exist. A file that is not a WALFile returns
+// ErrSnapshotCorrupt.
+func OpenWALFile(path string) (*WALFile, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	wf := &WALFile{path: path, f: f, m: make(map[string][]byte), maxSize: DefaultWALFileSize}
+	if err := wf.replay(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return wf, nil
+}
+
+// replay reads the records of the file into the map, truncating the file
+// after the last whole record.
+func (wf *WALFile) replay() error {
+	info, err := wf.f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return wf.writeHeader(wf.f)
+	}
+	r := bufio.NewReader(wf.f)
+	header := make([]byte, walFileHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.HasPrefix(header, walFileMagic) {
+		return ErrSnapshotCorrupt
+	}
+	if header[len(walFileMagic)] != walFileVersion {
+		return ErrSnapshotVersion
+	}
+	wf.size = walFileHeaderSize
+	for {
+		record, n, ok := readWALFileRecord(r)
+		if !ok {
+			break
+		}
+		key := string(record.Key)
+		if record.Delete {
+			delete(wf.m, key)
+		} else {
+			wf.m[key] = record.Value
+		}
+		wf.size += n
+	}
+	if wf.size < info.Size() {
+		if err := wf.f.Truncate(wf.size); err != nil {
+			return err
+		}
+	}
+	_, err = wf.f.Seek(wf.size, io.SeekStart)
+	return err
+}
+
+func (wf *WALFile) writeHeader(f *os.File) error {
+	header := append(append([]byte{}, walFileMagic...), walFileVersion)
+	if _, err := f.Write(header); err != nil {
+		return err
+	}
+	wf.size = walFileHeaderSize
+	return f.Sync()
+}
+
+// readWALFileRecord reads a record and returns its size, or false if it is
+// missing, torn or corrupt.
+func readWALFileRecord(r *bufio.Reader) (walWrite, int64, bool) {
+	crc := crc32.NewIEEE()
+	sr := &streamReader{r: r, crc: crc}
+	tag := sr.readTag()
+	if sr.err != nil || (tag != walFileSet && tag != walFileDelete) {
+		return walWrite{}, 0, false
+	}
+	record := walWrite{Key: sr.readBytes(), Delete: tag == walFileDelete}
+	if !record.Delete {
+		record.Value = sr.readBytes()
+	}
+	sum := crc.Sum32()
+	trailer := sr.read(4)
+	if sr.err != nil || binary.BigEndian.Uint32(trailer) != sum {
+		return walWrite{}, 0, false
+	}
+	n := 1 + uvarintSize(len(record.Key)) + len(record.Key) + 4
+	if !record.Delete {
+		n += uvarintSize(len(record.Value)) + len(record.Value)
+	}
+	return record, int64(n), true
+}
+
+func uvarintSize(n int) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], uint64(n))
+}
+
+func appendWALFileRecord(b []byte, key []byte, value []byte, deletion bool) []byte {
+	start := len(b)
+	if deletion {
+		b = append(b, walFileDelete)
+	} else {
+		b = append(b, walFileSet)
+	}
+	b = appendUvarint(b, uint64(len(key)))
+	b = append(b, key...)
+	if !deletion {
+		b = appendUvarint(b, uint64(len(value)))
+		b = append(b, value...)
+	}
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.ChecksumIEEE(b[start:]))
+	return append(b, sum[:]...)
+}
+
+// append appends a record to the file and syncs it, checkpointing the file
+// afterwards if it grew past its maximum size.
+func (wf *WALFile) append(key []byte, value []byte, deletion bool) error {
+	if wf.f == nil {
+		return errors.New("WAL file is closed")
+	}
+	record := appendWALFileRecord(nil, key, value, deletion)
+	if _, err := wf.f.Write(record); err != nil {
+		return err
+	}
+	if err := wf.f.Sync(); err != nil {
+		return err
+	}
+	wf.size += int64(len(record))
+	if deletion {
+		delete(wf.m, string(key))
+	} else {
+		wf.m[string(key)] = append([]byte{}, value...)
+	}
+	if wf.maxSize > 0 && wf.size > wf.maxSize {
+		return wf.checkpoint()
+	}
+	return nil
+}
+
+// Get gets the value for a key.
+func (wf *WALFile) Get(key []byte) ([]byte, error) {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	value, ok := wf.m[string(key)]
+	if !ok {
+		return nil, &InvalidKeyError{Key: key}
+	}
+	return value, nil
+}
+
+// Set updates the value for a key, once it is synced to the file.
+func (wf *WALFile) Set(key []byte, value []byte) error {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	return wf.append(key, value, false)
+}
+
+// Delete deletes a key, once the deletion is synced to the file.
+func (wf *WALFile) Delete(key []byte) error {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	if _, ok := wf.m[string(key)]; !ok {
+		return &InvalidKeyError{Key: key}
+	}
+	return wf.append(key, nil, true)
+}
+
+// Export dumps the live entries into a snapshot, in the same format as
+// SimpleMap.Export.
+func (wf *WALFile) Export() ([]byte, error) {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	return encodeSnapshot(wf.m)
+}
+
+// SetMaxSize sets the size in bytes past which the file is checkpointed, 0
+// for it only to be checkpointed with Checkpoint.
+func (wf *WALFile) SetMaxSize(n int64) {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	wf.maxSize = n
+}
+
+// Checkpoint rewrites the file with only its live entries, truncating the
+// records that are no longer live. The new file is written and synced beside
+// the old one before replacing it, so a crash leaves one or the other.
+func (wf *WALFile) Checkpoint() error {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	if wf.f == nil {
+		return errors.New("WAL file is closed")
+	}
+	return wf.checkpoint()
+}
+
+func (wf *WALFile) checkpoint() error {
+	keys := make([]string, 0, len(wf.m))
+	for key := range wf.m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	data := append(append([]byte{}, walFileMagic...), walFileVersion)
+	for _, key := range keys {
+		data = appendWALFileRecord(data, []byte(key), wf.m[key], false)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(wf.path), filepath.Base(wf.path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), wf.path); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	wf.f.Close()
+	wf.f, wf.size = tmp, int64(len(data))
+	if dir, err := os.Open(filepath.Dir(wf.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// Close closes the file. Its entries are kept in it, to be replayed by
+// OpenWALFile.
+func (wf *WALFile) Close() error {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	if wf.f == nil {
+		return nil
+	}
+	err := wf.f.Close()
+	wf.f = nil
+	return err
+}
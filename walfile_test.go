@@ -0,0 +1,116 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWALFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	wf, err := OpenWALFile(path)
+	if err != nil {
+		t.Fatalf("returned error when creating WAL file: %v", err)
+	}
+	wf.Set([]byte("key1"), []byte("value1"))
+	wf.Set([]byte("key2"), []byte("value2"))
+	wf.Set([]byte("key1"), []byte("newValue1"))
+	wf.Delete([]byte("key2"))
+	wf.Set([]byte("empty"), []byte{})
+	if err := wf.Delete([]byte("key2")); err == nil {
+		t.Error("deleted a missing key")
+	}
+	wf.Close()
+
+	// A record torn by a crash is discarded.
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	torn := appendWALFileRecord(nil, []byte("key3"), []byte("value3"), false)
+	f.Write(torn[:len(torn)-2])
+	f.Close()
+
+	wf, err = OpenWALFile(path)
+	if err != nil {
+		t.Fatalf("returned error when reopening WAL file: %v", err)
+	}
+	for key, want := range map[string][]byte{"key1": []byte("newValue1"), "empty": {}} {
+		if value, err := wf.Get([]byte(key)); err != nil || !bytes.Equal(value, want) {
+			t.Errorf("got %q for %s after replay, %v", value, key, err)
+		}
+	}
+	for _, key := range []string{"key2", "key3"} {
+		if _, err := wf.Get([]byte(key)); err == nil {
+			t.Errorf("%s is in the replayed WAL file", key)
+		}
+	}
+	// Appends go after the last whole record.
+	wf.Set([]byte("key4"), []byte("value4"))
+	before, _ := os.Stat(path)
+	if err := wf.Checkpoint(); err != nil {
+		t.Fatalf("returned error when checkpointing: %v", err)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size() {
+		t.Errorf("checkpoint did not truncate the file, %d bytes from %d", after.Size(), before.Size())
+	}
+	wf.Set([]byte("key5"), []byte("value5"))
+	wf.Close()
+	wf, _ = OpenWALFile(path)
+	for _, key := range []string{"key1", "key4", "key5"} {
+		if _, err := wf.Get([]byte(key)); err != nil {
+			t.Errorf("%s is missing after checkpoint: %v", key, err)
+		}
+	}
+	wf.Close()
+
+	other := filepath.Join(t.TempDir(), "other")
+	os.WriteFile(other, []byte("not a log"), 0644)
+	if _, err := OpenWALFile(other); !errors.Is(err, ErrSnapshotCorrupt) {
+		t.Errorf("did not return ErrSnapshotCorrupt, got %v", err)
+	}
+}
+
+func TestWALFileRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	wf, _ := OpenWALFile(path)
+	wf.SetMaxSize(4096)
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	nodes := &failingStore{MapStore: smn, sets: -1}
+	smt := NewSparseMerkleTree(nodes, smv, sha256.New(), WithWriteAheadLog(wf))
+	plain := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 100; i++ {
+		key, value := []byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i))
+		smt.Update(key, value)
+		plain.Update(key, value)
+	}
+	if info, _ := os.Stat(path); info.Size() > 4096 {
+		t.Errorf("WAL file grew to %d bytes, past its maximum size", info.Size())
+	}
+
+	// Crash partway through writing the nodes of an update.
+	nodes.sets = 2
+	if _, err := smt.Update([]byte("testKey100"), []byte("testValue100")); err == nil {
+		t.Fatal("update did not fail")
+	}
+	plain.Update([]byte("testKey100"), []byte("testValue100"))
+	wf.Close()
+
+	wf, err := OpenWALFile(path)
+	if err != nil {
+		t.Fatalf("returned error when reopening WAL file: %v", err)
+	}
+	defer wf.Close()
+	recovered := ImportSparseMerkleTree(smn, smv, sha256.New(), nil, WithWriteAheadLog(wf))
+	if err := recovered.Recover(); err != nil {
+		t.Fatalf("returned error when recovering: %v", err)
+	}
+	if !bytes.Equal(recovered.Root(), plain.Root()) {
+		t.Fatal("did not recover the root of the interrupted update")
+	}
+	if value, _ := recovered.Get([]byte("testKey100")); !bytes.Equal(value, []byte("testValue100")) {
+		t.Error("did not recover the value of the interrupted update")
+	}
+}
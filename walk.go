@@ -0,0 +1,174 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ErrPrefixTooLong is returned by NodeAt for a prefix longer than the paths of
+// the tree.
+var ErrPrefixTooLong = errors.New("prefix is longer than the paths of the tree")
+
+// visitFunc is called for each non-placeholder node visited by walk, with the
+// directions taken from the walk's root to reach it (true is right). The
+// prefix slice is reused between calls and must be copied to be retained.
+type visitFunc func(prefix []bool, hash []byte, data []byte) error
+
+// walk visits every node of the subtree under root in depth-first,
+// left-to-right order, so that leaves are visited in ascending path order.
+func (smt *SparseMerkleTree) walk(root []byte, visit visitFunc) error {
+	return smt.walkNode(root, make([]bool, 0, smt.depth()), visit)
+}
+
+func (smt *SparseMerkleTree) walkNode(hash []byte, prefix []bool, visit visitFunc) error {
+	if bytes.Equal(hash, smt.th.placeholder()) {
+		return nil
+	}
+	data, err := smt.getNode(hash)
+	if err != nil {
+		return err
+	}
+	if err := visit(prefix, hash, data); err != nil {
+		return err
+	}
+	if smt.th.isLeaf(data) {
+		return nil
+	}
+
+	leftNode, rightNode := smt.th.parseNode(data)
+	if err := smt.walkNode(leftNode, append(prefix, false), visit); err != nil {
+		return err
+	}
+	return smt.walkNode(rightNode, append(prefix, true), visit)
+}
+
+// NodeCount returns the number of branch and leaf nodes under the current
+// root, walking the whole tree; see SetOperationLimit.
+func (smt *SparseMerkleTree) NodeCount(options ...TraversalOption) (branches int, leaves int, err error) {
+	root := smt.Root()
+	if err := smt.checkOperationLimit(root, options); err != nil {
+		return 0, 0, err
+	}
+	err = smt.walk(root, func(_ []bool, _ []byte, data []byte) error {
+		if smt.th.isLeaf(data) {
+			leaves++
+		} else {
+			branches++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return branches, leaves, nil
+}
+
+// ProofSizeHistogram returns, for each number of non-default side nodes a
+// proof of a leaf under the current root has, the number of leaves whose
+// proofs have that many, walking the whole tree once; see
+// SetOperationLimit. Proofs with few non-default side nodes are the ones
+// that compact proofs shrink the most.
+func (smt *SparseMerkleTree) ProofSizeHistogram(options ...TraversalOption) (map[int]int, error) {
+	root := smt.Root()
+	if err := smt.checkOperationLimit(root, options); err != nil {
+		return nil, err
+	}
+	histogram := make(map[int]int)
+	if err := smt.proofSizes(root, 0, histogram); err != nil {
+		return nil, err
+	}
+	return histogram, nil
+}
+
+// proofSizes counts the leaves under the node with the given hash in
+// histogram, by the number of non-default side nodes of their proofs, given
+// that the path to the node has sideNodes of them.
+func (smt *SparseMerkleTree) proofSizes(hash []byte, sideNodes int, histogram map[int]int) error {
+	if bytes.Equal(hash, smt.th.placeholder()) {
+		return nil
+	}
+	data, err := smt.getNode(hash)
+	if err != nil {
+		return err
+	}
+	if smt.th.isLeaf(data) {
+		histogram[sideNodes]++
+		return nil
+	}
+	leftNode, rightNode := smt.th.parseNode(data)
+	leftSideNodes, rightSideNodes := sideNodes, sideNodes
+	if !bytes.Equal(rightNode, smt.th.placeholder()) {
+		leftSideNodes++
+	}
+	if !bytes.Equal(leftNode, smt.th.placeholder()) {
+		rightSideNodes++
+	}
+	if err := smt.proofSizes(leftNode, leftSideNodes, histogram); err != nil {
+		return err
+	}
+	return smt.proofSizes(rightNode, rightSideNodes, histogram)
+}
+
+// descendPrefix follows the directions in prefix from root, returning the
+// hash and data of the node reached and its depth. The descent stops early if
+// it reaches a leaf or a placeholder, whose data is nil.
+func (smt *SparseMerkleTree) descendPrefix(root []byte, prefix []bool) ([]byte, []byte, int, error) {
+	hash := root
+	for depth := 0; ; depth++ {
+		if bytes.Equal(hash, smt.th.placeholder()) {
+			return hash, nil, depth, nil
+		}
+		data, err := smt.getNode(hash)
+		if err != nil {
+			return nil, nil, depth, err
+		}
+		if depth == len(prefix) || smt.th.isLeaf(data) {
+			return hash, data, depth, nil
+		}
+
+		leftNode, rightNode := smt.th.parseNode(data)
+		if prefix[depth] {
+			hash = rightNode
+		} else {
+			hash = leftNode
+		}
+	}
+}
+
+// NodeAt returns the node at the position found by following prefix from the
+// current root, where true is right, i.e. the root of the subtree of the keys
+// whose paths start with prefix. For a branch, its hash and those of its
+// children are returned. Leaves stand for subtrees holding only them, so
+// below a leaf whose path starts with prefix, the leaf is returned, with
+// isLeaf set and no children; for an empty subtree, the placeholder is.
+func (smt *SparseMerkleTree) NodeAt(prefix []bool) (hash []byte, left []byte, right []byte, isLeaf bool, err error) {
+	if len(prefix) > smt.depth() {
+		return nil, nil, nil, false, ErrPrefixTooLong
+	}
+	hash, data, depth, err := smt.descendPrefix(smt.Root(), prefix)
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+	if data == nil {
+		return hash, nil, nil, false, nil
+	}
+	if smt.th.isLeaf(data) {
+		if path, _ := smt.th.parseLeaf(data); !pathHasPrefix(path, prefix[depth:], depth) {
+			return smt.th.placeholder(), nil, nil, false, nil
+		}
+		return hash, nil, nil, true, nil
+	}
+	left, right = smt.th.parseNode(data)
+	return hash, left, right, false, nil
+}
+
+// pathHasPrefix returns true if the bits of path from offset on start with
+// the directions in prefix.
+func pathHasPrefix(path []byte, prefix []bool, offset int) bool {
+	for i, bit := range prefix {
+		if bit != (getBitAtFromMSB(path, offset+i) == right) {
+			return false
+		}
+	}
+	return true
+}
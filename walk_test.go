@@ -0,0 +1,125 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestNodeCount(t *testing.T) {
+	smn := NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, NewSimpleMap(), sha256.New())
+	branches, leaves, err := smt.NodeCount()
+	if err != nil {
+		t.Errorf("returned error when counting empty tree: %v", err)
+	}
+	if branches != 0 || leaves != 0 {
+		t.Errorf("counted %d branches and %d leaves in empty tree", branches, leaves)
+	}
+
+	for i := 0; i < 100; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte("testValue"))
+	}
+	for i := 0; i < 100; i += 4 {
+		smt.Delete([]byte(fmt.Sprintf("testKey%d", i)))
+	}
+	branches, leaves, err = smt.NodeCount()
+	if err != nil {
+		t.Errorf("returned error when counting tree: %v", err)
+	}
+	if leaves != 75 {
+		t.Errorf("counted %d leaves, expected 75", leaves)
+	}
+	// Orphaned nodes are removed, so the store holds exactly the tree.
+	if branches+leaves != len(smn.m) {
+		t.Errorf("counted %d nodes, expected %d", branches+leaves, len(smn.m))
+	}
+}
+
+func TestNodeAt(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	hash, left, right, isLeaf, err := smt.NodeAt(nil)
+	if err != nil || !bytes.Equal(hash, smt.th.placeholder()) || left != nil || right != nil || isLeaf {
+		t.Errorf("did not get placeholder at root of empty tree, got %x, %v", hash, err)
+	}
+
+	for i := 0; i < 50; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte("testValue"))
+	}
+	hash, left, right, isLeaf, err = smt.NodeAt(nil)
+	if err != nil || !bytes.Equal(hash, smt.Root()) || isLeaf {
+		t.Errorf("did not get root branch, got %x, %v", hash, err)
+	}
+	rootLeft, rootRight, _ := smt.RootChildren()
+	if !bytes.Equal(left, rootLeft) || !bytes.Equal(right, rootRight) {
+		t.Error("children of root branch do not match RootChildren")
+	}
+
+	// Along the path of a key, the siblings are the side nodes of its proof,
+	// and the leaf stands for every position below it.
+	key := []byte("testKey7")
+	proof, _ := smt.Prove(key)
+	bits := smt.PathBits(key)
+	n := len(proof.SideNodes)
+	for depth := 0; depth < n; depth++ {
+		sibling := append(append([]bool{}, bits[:depth]...), !bits[depth])
+		hash, _, _, _, err := smt.NodeAt(sibling)
+		if err != nil {
+			t.Fatalf("returned error when getting node: %v", err)
+		}
+		if !bytes.Equal(hash, proof.SideNodes[n-1-depth]) {
+			t.Errorf("sibling at depth %d does not match side node of proof", depth)
+		}
+	}
+	leafHash, _ := smt.th.digestLeaf(smt.th.path(key), smt.th.digest([]byte("testValue")))
+	for _, depth := range []int{n, n + 1, len(bits)} {
+		hash, left, right, isLeaf, err := smt.NodeAt(bits[:depth])
+		if err != nil || !isLeaf || !bytes.Equal(hash, leafHash) || left != nil || right != nil {
+			t.Errorf("did not get leaf at depth %d, got %x, %v, %v", depth, hash, isLeaf, err)
+		}
+	}
+	beside := append(append([]bool{}, bits[:n+1]...), !bits[n+1])
+	hash, _, _, isLeaf, err = smt.NodeAt(beside)
+	if err != nil || isLeaf || !bytes.Equal(hash, smt.th.placeholder()) {
+		t.Errorf("did not get placeholder beside leaf, got %x, %v, %v", hash, isLeaf, err)
+	}
+
+	if _, _, _, _, err := smt.NodeAt(make([]bool, len(bits)+1)); !errors.Is(err, ErrPrefixTooLong) {
+		t.Errorf("did not return ErrPrefixTooLong, got %v", err)
+	}
+}
+
+func TestProofSizeHistogram(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	if histogram, err := smt.ProofSizeHistogram(); err != nil || len(histogram) != 0 {
+		t.Errorf("histogram of empty tree is %v, %v", histogram, err)
+	}
+	expected := make(map[int]int)
+	for i := 0; i < 100; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	for i := 0; i < 100; i++ {
+		proof, _ := smt.Prove([]byte(fmt.Sprintf("testKey%d", i)))
+		sideNodes := 0
+		for _, sideNode := range proof.SideNodes {
+			if !bytes.Equal(sideNode, smt.th.placeholder()) {
+				sideNodes++
+			}
+		}
+		expected[sideNodes]++
+	}
+	histogram, err := smt.ProofSizeHistogram()
+	if err != nil {
+		t.Fatalf("returned error when computing histogram: %v", err)
+	}
+	if len(histogram) != len(expected) {
+		t.Errorf("histogram has %d sizes, expected %d", len(histogram), len(expected))
+	}
+	for size, count := range expected {
+		if histogram[size] != count {
+			t.Errorf("histogram has %d leaves with %d side nodes, expected %d", histogram[size], size, count)
+		}
+	}
+}